@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/eduardmaghakyan/qlite/internal/config"
+)
+
+// configResult is the response of /admin/config/validate and /apply.
+type configResult struct {
+	Valid   bool            `json:"valid"`
+	Errors  []string        `json:"errors,omitempty"`
+	Changes []config.Change `json:"changes"`
+	Applied bool            `json:"applied"`
+}
+
+// configHandler serves POST /admin/config/validate, or /admin/config/apply
+// when reload is non-nil. The body is a complete candidate YAML config; it is
+// validated like the config file, except that ${VAR} references are not
+// expanded, and diffed against live. Apply
+// hands the candidate to reload, which restarts the proxy on the same
+// listener once in-flight requests finish. In-memory state such as the exact
+// cache starts empty. Changing server.port, admin.port or admin.socket needs a
+// process restart.
+func configHandler(live *config.Config, reload chan<- *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var res configResult
+		status := http.StatusOK
+		candidate, err := config.ParseRaw(body)
+		switch {
+		case err != nil:
+			res.Changes = []config.Change{}
+			res.Errors = []string{err.Error()}
+			status = http.StatusUnprocessableEntity
+		case candidate.Server.Port != live.Server.Port:
+			res.Changes = config.Diff(live, candidate)
+			res.Errors = []string{"server.port cannot be changed without a restart"}
+			status = http.StatusConflict
+		case candidate.Admin.Port != live.Admin.Port || candidate.Admin.Socket != live.Admin.Socket:
+			res.Changes = config.Diff(live, candidate)
+			res.Errors = []string{"admin.port and admin.socket cannot be changed without a restart"}
+			status = http.StatusConflict
+		default:
+			res.Valid = true
+			res.Changes = config.Diff(live, candidate)
+		}
+
+		if res.Valid && reload != nil && len(res.Changes) > 0 {
+			select {
+			case reload <- candidate:
+				res.Applied = true
+				status = http.StatusAccepted
+			default:
+				res.Errors = []string{"another config apply is in progress"}
+				status = http.StatusConflict
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	})
+}
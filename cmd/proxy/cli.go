@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/eduardmaghakyan/qlite/internal/config"
+	"github.com/eduardmaghakyan/qlite/internal/server"
+)
+
+// Build information, set at build time with -ldflags "-X main.version=...
+// -X main.commit=... -X main.buildDate=...". Without them, commit and
+// buildDate come from the VCS information Go embeds.
+var (
+	version   = "dev"
+	commit    string
+	buildDate string
+)
+
+const usage = `Usage: proxy <command> [flags]
+
+Commands:
+  serve                   run the proxy (default)
+  validate-config         load and validate the configuration, then exit
+  print-effective-config  print the configuration after env overrides and defaults (secrets redacted)
+  cache stats             show cache statistics of a running instance
+  cache analytics         show exact-cache key reuse and hit-age analytics
+  cache clear             clear the caches of a running instance
+  replay                  replay recorded requests through a running instance
+  version                 print version information
+
+Run 'proxy <command> -h' for command flags.
+`
+
+func main() {
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		configPath := fs.String("config", "", "config file (default $QLITE_CONFIG or config/config.yaml)")
+		fs.Parse(args)
+		serve(*configPath)
+	case "validate-config":
+		err = runValidateConfig(args, os.Stdout)
+	case "print-effective-config":
+		err = runPrintEffectiveConfig(args, os.Stdout)
+	case "cache":
+		err = runCache(args, os.Stdout)
+	case "replay":
+		err = runReplay(args, os.Stdout)
+	case "version":
+		printVersion(os.Stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func runValidateConfig(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file (default $QLITE_CONFIG or config/config.yaml)")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "config OK: %d provider(s)\n", len(cfg.Providers))
+	return nil
+}
+
+func runPrintEffectiveConfig(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("print-effective-config", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file (default $QLITE_CONFIG or config/config.yaml)")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(redactConfig(*cfg))
+}
+
+// redactConfig returns a copy of cfg with credentials masked.
+func redactConfig(cfg config.Config) config.Config {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "REDACTED"
+	}
+	providers := make([]config.ProviderConfig, len(cfg.Providers))
+	copy(providers, cfg.Providers)
+	for i := range providers {
+		providers[i].APIKey = mask(providers[i].APIKey)
+		providers[i].Auth.Secret = mask(providers[i].Auth.Secret)
+		providers[i].Auth.ClientSecret = mask(providers[i].Auth.ClientSecret)
+	}
+	cfg.Providers = providers
+	cfg.Cache.Semantic.EmbeddingKey = mask(cfg.Cache.Semantic.EmbeddingKey)
+	fallbacks := make([]config.EmbeddingEndpointConfig, len(cfg.Cache.Semantic.EmbeddingFallbacks))
+	copy(fallbacks, cfg.Cache.Semantic.EmbeddingFallbacks)
+	for i := range fallbacks {
+		fallbacks[i].Key = mask(fallbacks[i].Key)
+	}
+	cfg.Cache.Semantic.EmbeddingFallbacks = fallbacks
+	cfg.Cache.Semantic.QdrantAPIKey = mask(cfg.Cache.Semantic.QdrantAPIKey)
+	prompts := make([]config.SystemPromptConfig, len(cfg.SystemPrompts))
+	copy(prompts, cfg.SystemPrompts)
+	for i := range prompts {
+		keys := make([]string, len(prompts[i].APIKeys))
+		for j, k := range prompts[i].APIKeys {
+			keys[j] = mask(k)
+		}
+		prompts[i].APIKeys = keys
+	}
+	cfg.SystemPrompts = prompts
+	tenants := make(map[string][]string, len(cfg.Tenants))
+	for name, keys := range cfg.Tenants {
+		masked := make([]string, len(keys))
+		for j, k := range keys {
+			masked[j] = mask(k)
+		}
+		tenants[name] = masked
+	}
+	cfg.Tenants = tenants
+	cfg.Admin.Token = mask(cfg.Admin.Token)
+	cfg.RateLimit.Redis.Password = mask(cfg.RateLimit.Redis.Password)
+	return cfg
+}
+
+// adminFlags registers the flags for reaching a running instance's admin
+// API on fs.
+func adminFlags(fs *flag.FlagSet) (addr, token *string) {
+	defaultAddr := os.Getenv("QLITE_ADDR")
+	if defaultAddr == "" {
+		defaultAddr = "http://localhost:8080"
+	}
+	addr = fs.String("addr", defaultAddr, "base URL of the running proxy's admin endpoints, or unix:<path> for an admin socket ($QLITE_ADDR)")
+	token = fs.String("token", os.Getenv("QLITE_ADMIN_TOKEN"), "admin bearer token ($QLITE_ADMIN_TOKEN)")
+	return addr, token
+}
+
+// adminCall sends a request to the admin API at addr and returns the
+// response body, or an error if the status isn't 200.
+func adminCall(addr, token, method, path string, body io.Reader) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	base := strings.TrimRight(addr, "/")
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		base = "http://qlite"
+	}
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("contacting %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API error (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+// runCache talks to the admin API of a running instance.
+func runCache(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: proxy cache <stats|analytics|clear> [-addr URL]")
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("cache "+action, flag.ExitOnError)
+	addr, token := adminFlags(fs)
+	fs.Parse(args)
+
+	var method, path string
+	switch action {
+	case "stats":
+		method, path = http.MethodGet, "/admin/cache/stats"
+	case "analytics":
+		method, path = http.MethodGet, "/admin/cache/analytics"
+	case "clear":
+		method, path = http.MethodPost, "/admin/cache/clear"
+	default:
+		return fmt.Errorf("unknown cache action %q (want stats, analytics or clear)", action)
+	}
+
+	body, err := adminCall(*addr, *token, method, path, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, strings.TrimSpace(string(body)))
+	return nil
+}
+
+// runReplay replays recorded requests through a running instance, either
+// by request ID or from a file exported by GET /admin/debug/export.
+func runReplay(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr, token := adminFlags(fs)
+	file := fs.String("file", "", "JSONL file of exported requests to replay, or - for stdin")
+	noCache := fs.Bool("no-cache", false, "bypass the exact and semantic caches")
+	fs.Parse(args)
+
+	var records [][]byte
+	switch {
+	case *file != "" && fs.NArg() == 0:
+		var r io.Reader = os.Stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 10<<20)
+		for sc.Scan() {
+			if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+				records = append(records, bytes.Clone(line))
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("reading %s: %w", *file, err)
+		}
+	case *file == "" && fs.NArg() > 0:
+		for _, id := range fs.Args() {
+			rec, _ := json.Marshal(map[string]string{"request_id": id})
+			records = append(records, rec)
+		}
+	default:
+		return fmt.Errorf("usage: proxy replay [-no-cache] [-addr URL] <request-id>... | -file <path>")
+	}
+
+	path := "/admin/replay"
+	if *noCache {
+		path += "?no_cache=true"
+	}
+	for _, rec := range records {
+		body, err := adminCall(*addr, *token, http.MethodPost, path, bytes.NewReader(rec))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// buildInfo returns the build information of the running binary.
+func buildInfo() server.BuildInfo {
+	bi := server.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		bi.GoVersion = info.GoVersion
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			}
+		}
+	}
+	return bi
+}
+
+func printVersion(out io.Writer) {
+	bi := buildInfo()
+	fmt.Fprintf(out, "qlite %s", bi.Version)
+	if bi.Commit != "" {
+		fmt.Fprintf(out, " (%s)", bi.Commit)
+	}
+	if bi.BuildDate != "" {
+		fmt.Fprintf(out, " built %s", bi.BuildDate)
+	}
+	if bi.GoVersion != "" {
+		fmt.Fprintf(out, " %s", bi.GoVersion)
+	}
+	fmt.Fprintln(out)
+}
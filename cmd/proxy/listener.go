@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/eduardmaghakyan/qlite/internal/config"
+)
+
+// handoffListener owns the real listener across config reloads. Each server
+// generation accepts through its own view; closing a view stops that server
+// without closing the socket, so connections arriving mid-reload wait in the
+// backlog for the next generation instead of being refused.
+type handoffListener struct {
+	ln    net.Listener
+	conns chan net.Conn
+	errs  chan error
+}
+
+func newHandoffListener(ln net.Listener) *handoffListener {
+	h := &handoffListener{ln: ln, conns: make(chan net.Conn), errs: make(chan error, 1)}
+	go h.acceptLoop()
+	return h
+}
+
+func (h *handoffListener) acceptLoop() {
+	for {
+		c, err := h.ln.Accept()
+		if err != nil {
+			h.errs <- err
+			return
+		}
+		h.conns <- c
+	}
+}
+
+// view returns a listener for one server generation.
+func (h *handoffListener) view() net.Listener {
+	return &listenerView{h: h, done: make(chan struct{})}
+}
+
+// Close closes the underlying socket.
+func (h *handoffListener) Close() error {
+	return h.ln.Close()
+}
+
+type listenerView struct {
+	h    *handoffListener
+	done chan struct{}
+	once sync.Once
+}
+
+func (v *listenerView) Accept() (net.Conn, error) {
+	select {
+	case c := <-v.h.conns:
+		return c, nil
+	case err := <-v.h.errs:
+		// Leave the error for later views too.
+		v.h.errs <- err
+		return nil, err
+	case <-v.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (v *listenerView) Close() error {
+	v.once.Do(func() { close(v.done) })
+	return nil
+}
+
+func (v *listenerView) Addr() net.Addr { return v.h.ln.Addr() }
+
+// listenAdmin opens the listeners configured in ac: a TCP port and/or a Unix
+// socket. It returns none when admin endpoints share the main port. Like the
+// main listener, they outlive config reloads.
+func listenAdmin(ac config.AdminConfig) ([]*handoffListener, error) {
+	var lns []*handoffListener
+	closeAll := func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}
+	if ac.Port != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", ac.Port))
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, newHandoffListener(ln))
+	}
+	if ac.Socket != "" {
+		// A socket left behind by an unclean exit would make the bind fail.
+		if fi, err := os.Lstat(ac.Socket); err == nil && fi.Mode().Type() == fs.ModeSocket {
+			os.Remove(ac.Socket)
+		}
+		ln, err := net.Listen("unix", ac.Socket)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		lns = append(lns, newHandoffListener(ln))
+		if err := os.Chmod(ac.Socket, 0o600); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	return lns, nil
+}
@@ -2,26 +2,41 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/eduardmaghakyan/qlite/internal/alert"
 	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/catalog"
 	"github.com/eduardmaghakyan/qlite/internal/config"
 	"github.com/eduardmaghakyan/qlite/internal/embedding"
+	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+	"github.com/eduardmaghakyan/qlite/internal/pricing"
 	"github.com/eduardmaghakyan/qlite/internal/provider"
 	"github.com/eduardmaghakyan/qlite/internal/qdrant"
+	"github.com/eduardmaghakyan/qlite/internal/redis"
+	"github.com/eduardmaghakyan/qlite/internal/savings"
 	"github.com/eduardmaghakyan/qlite/internal/server"
+	"github.com/eduardmaghakyan/qlite/internal/shutdown"
 	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
 )
 
-func main() {
+// serve runs the proxy until SIGINT/SIGTERM.
+func serve(configPath string) {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
 	if os.Getenv("QLITE_PPROF") == "1" {
@@ -33,62 +48,364 @@ func main() {
 		}()
 	}
 
-	configPath := "config/config.yaml"
-	if p := os.Getenv("QLITE_CONFIG"); p != "" {
-		configPath = p
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logger.Error("failed to load config", "error", err)
+		os.Exit(1)
 	}
 
-	cfg, err := config.Load(configPath)
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.Port))
 	if err != nil {
-		logger.Error("failed to load config", "error", err)
+		logger.Error("failed to listen", "error", err)
 		os.Exit(1)
 	}
+	handoff := newHandoffListener(ln)
+	defer handoff.Close()
+	admin, err := listenAdmin(cfg.Admin)
+	if err != nil {
+		logger.Error("failed to listen for admin endpoints", "error", err)
+		os.Exit(1)
+	}
+	for _, a := range admin {
+		defer a.Close()
+	}
 
+	// Each iteration is one generation of the proxy; /admin/config/apply
+	// ends it with the config for the next.
+	for cfg != nil {
+		cfg = run(cfg, handoff, admin, logger)
+	}
+	logger.Info("server stopped")
+}
+
+// run builds the proxy from cfg and serves on ln, and the admin endpoints on
+// adminLns if there are any, until SIGINT/SIGTERM, which returns nil, or
+// until a new config is applied, which is returned.
+func run(cfg *config.Config, ln *handoffListener, adminLns []*handoffListener, logger *slog.Logger) *config.Config {
+	var err error
+	tokenizer.SetLoader(tokenizer.LoaderConfig{
+		Dir:      cfg.Tokenizer.BPEDir,
+		CacheDir: cfg.Tokenizer.CacheDir,
+		Offline:  cfg.Tokenizer.Offline,
+	})
 	counter := tokenizer.NewCounter()
+	counter.SetLogger(logger)
 	registry := provider.NewRegistry()
 
+	// Instrumented upstream pools, by provider name or "shared".
+	transports := make(map[string]*provider.PooledTransport)
+	if cfg.SharedTransport.Enabled {
+		transports["shared"] = provider.NewPooledTransport(provider.TransportConfig(cfg.SharedTransport.TransportConfig))
+	}
+	// Upstream rate limits, by provider name, for providers that track them.
+	var registered []config.ProviderConfig
+	quotas := make(map[string]func() provider.Quota)
+	// Model discovery, by provider name, for providers that enable it.
+	discoveries := make(map[string]*provider.Discovery)
+	// Health probes, by provider name, for providers that enable them.
+	probes := make(map[string]*provider.HealthProbe)
+
 	for _, pc := range cfg.Providers {
+		var p provider.Provider
 		switch pc.Type {
 		case "openai":
-			p := provider.NewOpenAICompat(pc.Name, pc.BaseURL, pc.APIKey, pc.Models)
-			registry.Register(p)
-			logger.Info("registered provider", "name", pc.Name, "models", pc.Models)
+			p = provider.NewOpenAICompat(pc.Name, pc.BaseURL, pc.APIKey, pc.ModelNames())
 		case "anthropic":
-			p := provider.NewAnthropic(pc.Name, pc.BaseURL, pc.APIKey, pc.Models)
-			registry.Register(p)
-			logger.Info("registered provider", "name", pc.Name, "models", pc.Models)
+			p = provider.NewAnthropic(pc.Name, pc.BaseURL, pc.APIKey, pc.ModelNames())
 		case "google":
-			p := provider.NewGoogle(pc.Name, pc.BaseURL, pc.APIKey, pc.Models)
-			registry.Register(p)
-			logger.Info("registered provider", "name", pc.Name, "models", pc.Models)
+			p = provider.NewGoogle(pc.Name, pc.BaseURL, pc.APIKey, pc.ModelNames())
+		case "vertex":
+			v, err := provider.NewVertex(pc.Name, pc.BaseURL, pc.Project, pc.Region, pc.CredentialsFile, pc.ModelNames())
+			if err != nil {
+				logger.Error("failed to configure vertex provider", "name", pc.Name, "error", err)
+				os.Exit(1)
+			}
+			p = v
+		case "mistral", "groq", "together":
+			o, err := provider.NewFromPreset(pc.Type, pc.Name, pc.BaseURL, pc.APIKey, pc.ModelNames())
+			if err != nil {
+				logger.Error("failed to configure provider", "name", pc.Name, "error", err)
+				os.Exit(1)
+			}
+			p = o
 		default:
 			logger.Warn("unknown provider type, skipping", "type", pc.Type, "name", pc.Name)
+			continue
+		}
+		if len(pc.Headers) > 0 || len(pc.QueryParams) > 0 {
+			if ep, ok := p.(interface{ SetRequestExtras(provider.RequestExtras) }); ok {
+				ep.SetRequestExtras(provider.RequestExtras{Headers: pc.Headers, Query: pc.QueryParams})
+			}
+		}
+		if pc.APIVersion != "" {
+			if vp, ok := p.(interface{ SetAPIVersion(string) }); ok {
+				vp.SetAPIVersion(pc.APIVersion)
+			}
+		}
+		if len(pc.Betas) > 0 {
+			if bp, ok := p.(interface{ SetBetas([]string) }); ok {
+				bp.SetBetas(pc.Betas)
+			}
+		}
+		if pc.IncludeUsage != nil {
+			if up, ok := p.(interface{ SetIncludeUsage(bool) }); ok {
+				up.SetIncludeUsage(*pc.IncludeUsage)
+			}
+		}
+		if pc.Auth.Type != "" {
+			if ap, ok := p.(interface{ SetAuth(provider.AuthProvider) }); ok {
+				ap.SetAuth(providerAuth(pc.Auth))
+			}
+		}
+		if pc.NoStore != (config.NoStoreConfig{}) {
+			if np, ok := p.(interface{ SetNoStoreHints(provider.NoStoreHints) }); ok {
+				np.SetNoStoreHints(provider.NoStoreHints(pc.NoStore))
+			}
+		}
+		if cfg.Validation.Schema != server.SchemaOff {
+			if up, ok := p.(interface{ SetUnknownFieldsHook(func([]string)) }); ok {
+				up.SetUnknownFieldsHook(logDroppedFields(logger, pc.Name))
+			}
+		}
+		if tp, ok := p.(interface{ SetTransport(http.RoundTripper) }); ok {
+			switch {
+			case pc.Transport != (config.TransportConfig{}):
+				pt := provider.NewPooledTransport(provider.TransportConfig(pc.Transport))
+				transports[pc.Name] = pt
+				tp.SetTransport(pt)
+			case transports["shared"] != nil:
+				tp.SetTransport(transports["shared"])
+			}
+		}
+		if qp, ok := p.(interface{ Quota() provider.Quota }); ok {
+			quotas[pc.Name] = qp.Quota
 		}
+		for _, mc := range pc.Models {
+			catalog.Register(pc.Name, catalogModel(mc))
+		}
+		for m, price := range pc.Pricing {
+			pricing.SetForProvider(pc.Name, m, price.Input, price.Output)
+		}
+		lister, _ := p.(provider.ModelLister)
+		if cfg.Fixtures.Mode != "" {
+			p = provider.NewFixtureProvider(p, cfg.Fixtures.Dir, provider.FixtureMode(cfg.Fixtures.Mode))
+		}
+		registry.Register(p)
+		if pc.Discovery.Enabled && lister != nil {
+			d, err := provider.NewDiscovery(registry, p, lister, pc.Discovery.Allow)
+			if err != nil {
+				logger.Error("failed to configure model discovery", "name", pc.Name, "error", err)
+				os.Exit(1)
+			}
+			discoveries[pc.Name] = d
+		}
+		if pc.Health.Enabled && lister != nil {
+			probes[pc.Name] = provider.NewHealthProbe(lister)
+		}
+		registered = append(registered, pc)
+		logger.Info("registered provider", "name", pc.Name, "models", pc.ModelNames())
+	}
+	if cfg.Fixtures.Mode != "" {
+		logger.Info("fixtures enabled", "mode", cfg.Fixtures.Mode, "dir", cfg.Fixtures.Dir)
 	}
 	registry.Freeze()
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	for _, pc := range registered {
+		d, ok := discoveries[pc.Name]
+		if !ok {
+			continue
+		}
+		onRefresh := func(added []string, err error) {
+			if err != nil {
+				logger.Warn("model discovery failed", "provider", pc.Name, "error", err)
+			} else if len(added) > 0 {
+				logger.Info("discovered models", "provider", pc.Name, "models", added)
+			}
+		}
+		// A failed first listing is retried on the interval; configured
+		// models are served meanwhile.
+		ctx, cancel := context.WithTimeout(discoveryCtx, 10*time.Second)
+		onRefresh(d.Refresh(ctx))
+		cancel()
+		if pc.Discovery.Interval > 0 {
+			go d.Run(discoveryCtx, pc.Discovery.Interval, onRefresh)
+		}
+	}
+	for _, pc := range registered {
+		probe, ok := probes[pc.Name]
+		if !ok {
+			continue
+		}
+		name := pc.Name
+		go probe.Run(discoveryCtx, pc.Health.Interval, pc.Health.Timeout, func(prev, cur provider.HealthStatus) {
+			if !prev.CheckedAt.IsZero() && prev.Healthy == cur.Healthy {
+				return
+			}
+			if cur.Healthy {
+				logger.Info("provider health probe succeeded", "provider", name)
+			} else {
+				logger.Warn("provider health probe failed", "provider", name, "error", cur.Error)
+			}
+		})
+	}
+
+	if !cfg.Tokenizer.Lazy {
+		var models []string
+		for _, pc := range cfg.Providers {
+			models = append(models, pc.ModelNames()...)
+		}
+		if cfg.DefaultModel != "" {
+			models = append(models, cfg.DefaultModel)
+		}
+		start := time.Now()
+		// Failures are logged by the counter; token counting falls back to
+		// len/4 until an encoding loads.
+		if loaded, _ := counter.Warm(models); len(loaded) > 0 {
+			logger.Info("tokenizer encodings loaded", "encodings", loaded, "elapsed", time.Since(start))
+		}
+	}
+
+	var volatile *cache.Volatile
+	if len(cfg.Cache.Volatile.Patterns) > 0 {
+		volatile, err = cache.NewVolatile(cfg.Cache.Volatile.Patterns, cfg.Cache.Volatile.Action)
+		if err != nil {
+			logger.Error("invalid cache.volatile config", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("volatile content detection enabled",
+			"patterns", len(cfg.Cache.Volatile.Patterns),
+			"action", cfg.Cache.Volatile.Action,
+		)
+	}
+
+	sampling := cache.SamplingPolicy{
+		MaxTemperature: cfg.Cache.MaxTemperature,
+		IgnoreTopP:     cfg.Cache.IgnoreTopP,
+	}
+
+	storeFilter := cache.NewStoreFilter(cfg.Cache.Store.SkipFinishReasons, cfg.Cache.Store.AllowEmpty)
+	storeFilter.SetMaxBytes(cfg.Cache.Store.MaxResponseBytes)
+
+	var fingerprints *cache.Fingerprints
+	if cfg.Cache.FingerprintInvalidation {
+		fingerprints = cache.NewFingerprints(func(model, old, new string) {
+			logger.Info("system fingerprint changed, invalidating cached responses", "model", model, "old", old, "new", new)
+		})
+	}
+
+	var feedback *cache.Feedback
+	if f := cfg.Cache.Feedback; f.Enabled {
+		feedback = cache.NewFeedback(f.Threshold, f.Window, f.MaxRequests)
+	}
+
+	// Exact-cache responses live in one content-addressed store, which
+	// semantic hits also serve from.
+	responses := cache.NewResponseStore()
+	if cfg.Cache.Compression.Enabled {
+		responses.SetCompression(cfg.Cache.Compression.MinBytes)
+	}
 
 	var exactCache *cache.ExactCache
 	if cfg.Cache.Exact.Enabled {
 		exactCache = cache.New(cfg.Cache.Exact.TTL, cfg.Cache.Exact.MaxEntries)
-		logger.Info("exact cache enabled", "ttl", cfg.Cache.Exact.TTL, "max_entries", cfg.Cache.Exact.MaxEntries)
+		exactCache.SetResponseStore(responses)
+		exactCache.SetNormalize(cfg.Cache.Exact.Normalize)
+		exactCache.SetKeyFormat(cfg.Cache.Exact.KeyFormat)
+		exactCache.SetVolatile(volatile)
+		exactCache.SetSamplingPolicy(sampling)
+		exactCache.SetStoreFilter(storeFilter)
+		exactCache.SetFingerprints(fingerprints)
+		exactCache.SetFeedback(feedback)
+		if a := cfg.Cache.Exact.AdaptiveTTL; a.Enabled {
+			exactCache.SetAdaptiveTTL(cache.AdaptiveTTL{Unhit: a.UnhitTTL, Max: a.MaxTTL})
+		}
+		logger.Info("exact cache enabled",
+			"ttl", cfg.Cache.Exact.TTL,
+			"max_entries", cfg.Cache.Exact.MaxEntries,
+			"normalize", cfg.Cache.Exact.Normalize,
+			"key_format", cfg.Cache.Exact.KeyFormat,
+			"adaptive_ttl", cfg.Cache.Exact.AdaptiveTTL.Enabled,
+		)
 	}
 
 	dispatch := pipeline.NewDispatchStage(registry, counter)
+	dispatch.SetFingerprints(fingerprints)
+	dispatch.SetValidationRetries(cfg.Validation.Retries)
+	dispatch.SetRejectEmpty(cfg.Validation.RejectEmpty)
+	dispatch.SetStreamRetries(cfg.Routing.StreamRetries)
+	dispatch.SetContinuationPrompt(cfg.Continuation.Prompt)
+	var chaos *pipeline.Chaos
+	if c := cfg.Chaos; c.Enabled {
+		chaos = pipeline.NewChaos(pipeline.ChaosConfig{
+			Seed:      c.Seed,
+			DelayRate: c.DelayRate,
+			MaxDelay:  c.MaxDelay,
+			ErrorRate: c.ErrorRate,
+			DropRate:  c.DropRate,
+		})
+		dispatch.SetChaos(chaos)
+		logger.Warn("chaos fault injection enabled",
+			"seed", chaos.Stats().Seed,
+			"delay_rate", c.DelayRate,
+			"max_delay", c.MaxDelay,
+			"error_rate", c.ErrorRate,
+			"drop_rate", c.DropRate,
+		)
+	}
+	if cfg.Routing.Policy == pipeline.RouteCheapest {
+		router := pipeline.NewRouter(registry, pipeline.RouteCheapest, cfg.Routing.Cooldown)
+		router.SetQuotaReserve(cfg.Routing.QuotaReserve)
+		dispatch.SetRouter(router)
+		logger.Info("cheapest-provider routing enabled", "cooldown", cfg.Routing.Cooldown, "quota_reserve", cfg.Routing.QuotaReserve)
+	}
+	if mask := pipeline.NewWordMask(cfg.Transforms.MaskWords); mask != nil {
+		dispatch.SetChunkTransformers(mask)
+	}
 
 	// Build the final stage: either SemanticDispatchStage (wrapping dispatch) or plain dispatch.
 	var finalStage any = dispatch
 	var qdrantClient *qdrant.Client
-	if cfg.Cache.Semantic.Enabled {
-		embClient := embedding.NewClient(
+	var semanticCache *cache.SemanticCache
+	var semStage *pipeline.SemanticDispatchStage
+	var truncator *pipeline.ReplayTruncator
+	if cfg.Cache.TruncateReplays {
+		truncator = pipeline.NewReplayTruncator(counter)
+	}
+	var embFailover *embedding.Failover
+	if cfg.Cache.Semantic.Enabled && cfg.Fixtures.Mode == "replay" {
+		// Replay must stay hermetic; embeddings and Qdrant are network calls.
+		logger.Warn("semantic cache disabled in fixtures replay mode")
+	} else if cfg.Cache.Semantic.Enabled {
+		primary := embedding.NewClient(
 			cfg.Cache.Semantic.EmbeddingURL,
 			cfg.Cache.Semantic.EmbeddingKey,
 			cfg.Cache.Semantic.EmbeddingModel,
 		)
+		var embClient embedding.Embedder = primary
+		if fallbacks := cfg.Cache.Semantic.EmbeddingFallbacks; len(fallbacks) > 0 {
+			clients := []*embedding.Client{primary}
+			for _, f := range fallbacks {
+				clients = append(clients, embedding.NewClient(f.URL, f.Key, f.Model))
+			}
+			failover := embedding.NewFailover(cfg.Cache.Semantic.EmbeddingCooldown, clients...)
+			failover.SetOnStateChange(func(url string, healthy bool, err error) {
+				if healthy {
+					logger.Info("embedding endpoint recovered", "url", url)
+					return
+				}
+				logger.Warn("embedding endpoint failed", "url", url, "error", err, "degraded", failover.Degraded())
+			})
+			embClient = failover
+			embFailover = failover
+		}
 		qdrantClient = qdrant.NewClient(
 			cfg.Cache.Semantic.QdrantURL,
 			cfg.Cache.Semantic.QdrantAPIKey,
 			cfg.Cache.Semantic.QdrantCollection,
 		)
+		if cfg.Cache.Compression.Enabled {
+			qdrantClient.SetCompression(cfg.Cache.Compression.MinBytes)
+		}
 
 		// Best-effort collection creation — warn on failure, don't abort.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -98,9 +415,52 @@ func main() {
 		} else {
 			cancel()
 			sc := cache.NewSemanticCache(embClient, qdrantClient, cfg.Cache.Semantic.Threshold)
-			finalStage = pipeline.NewSemanticDispatchStage(sc, dispatch, logger)
+			sc.SetVolatile(volatile)
+			sc.SetSamplingPolicy(sampling)
+			sc.SetStoreFilter(storeFilter)
+			sc.SetFingerprints(fingerprints)
+			sc.SetFeedback(feedback)
+			sc.SetPromptStorage(cache.PromptStorage{
+				Mode:     cfg.Cache.Semantic.StorePrompt,
+				MaxChars: cfg.Cache.Semantic.PromptMaxChars,
+			})
+			sc.SetCompatibleModels(cfg.Cache.Semantic.CompatibleModels)
+			sc.SetDedupResponses(cfg.Cache.Semantic.DedupResponses)
+			sc.SetLatencyFloor(cfg.Cache.Semantic.LatencyFloor)
+			sc.SetEmbeddingLimits(cache.EmbeddingLimits{
+				Timeout:         cfg.Cache.Semantic.EmbeddingTimeout,
+				PerKTokens:      cfg.Cache.Semantic.EmbeddingTimeoutPer1K,
+				MaxPromptTokens: cfg.Cache.Semantic.MaxPromptTokens,
+			})
+			semanticCache = sc
+			if exactCache != nil {
+				sc.SetExactKeyFunc(exactCache.Key)
+				sc.SetResponseStore(responses)
+				if n := cfg.Cache.Semantic.WarmExact; n > 0 {
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					loaded, err := sc.WarmExact(ctx, exactCache, n)
+					cancel()
+					if err != nil {
+						logger.Warn("failed to warm exact cache from qdrant", "error", err)
+					} else {
+						logger.Info("warmed exact cache from qdrant", "entries", loaded)
+					}
+				}
+			}
+			semStage = pipeline.NewSemanticDispatchStage(sc, dispatch, logger)
+			semStage.SetLookahead(cfg.Cache.Semantic.Lookahead)
+			semStage.SetReplayTruncator(truncator)
+			race := cfg.Cache.Semantic.Race
+			semStage.SetRace(pipeline.RaceConfig{
+				Grace:       race.Grace,
+				FinishOnHit: race.CancelOnHit != nil && !*race.CancelOnHit,
+				Sequential:  race.SequentialProviders,
+			})
+			finalStage = semStage
 			logger.Info("semantic cache enabled",
 				"threshold", cfg.Cache.Semantic.Threshold,
+				"lookahead", cfg.Cache.Semantic.Lookahead,
+				"race_grace", cfg.Cache.Semantic.Race.Grace,
 				"qdrant_url", cfg.Cache.Semantic.QdrantURL,
 				"embedding_model", cfg.Cache.Semantic.EmbeddingModel,
 			)
@@ -109,7 +469,9 @@ func main() {
 
 	var stages []any
 	if exactCache != nil {
-		stages = append(stages, pipeline.NewCacheStage(exactCache, true))
+		cacheStage := pipeline.NewCacheStage(exactCache, true)
+		cacheStage.SetReplayTruncator(truncator)
+		stages = append(stages, cacheStage)
 	}
 	stages = append(stages, finalStage)
 
@@ -118,12 +480,311 @@ func main() {
 		logger.Error("failed to create pipeline", "error", err)
 		os.Exit(1)
 	}
+	pipe.SetChaos(chaos)
 
 	handler := server.NewHandler(pipe, counter, logger, exactCache)
+	handler.SetSSEHeartbeat(cfg.Server.SSEHeartbeat)
+	handler.SetSSEMetadata(cfg.Server.SSEMetadata)
+	handler.SetSSEBackpressure(cfg.Server.SSEWriteTimeout, cfg.Server.SSEMaxBuffered)
+	handler.SetClientMetadata(cfg.Forward.UserHeader, cfg.Forward.MetadataHeaders)
+	handler.SetDefaultModel(cfg.DefaultModel)
+	handler.SetSchemaMode(cfg.Validation.Schema)
+	providerNames := make([]string, len(registered))
+	for i, pc := range registered {
+		providerNames[i] = pc.Name
+	}
+	handler.SetBuildInfo(buildInfo(), server.Features{Providers: providerNames, SemanticCache: semStage != nil})
+	if semanticCache != nil {
+		handler.SetSemanticEntries(semanticCache.Entries)
+	}
+	if len(cfg.Deprecations.Models) > 0 {
+		deps := make([]server.ModelDeprecation, len(cfg.Deprecations.Models))
+		for i, d := range cfg.Deprecations.Models {
+			deps[i] = server.ModelDeprecation{Model: d.Model, Replacement: d.Replacement, Action: d.Action}
+			// Validated as a date when the config was loaded.
+			deps[i].Sunset, _ = time.Parse(time.DateOnly, d.Sunset)
+		}
+		handler.SetDeprecations(deps, cfg.Deprecations.Log)
+		logger.Info("model deprecations configured", "models", len(deps))
+	}
+	if cfg.Continuation.MaxRounds > 0 {
+		handler.SetContinuations(cfg.Continuation.MaxRounds)
+		logger.Info("response continuation enabled", "max_rounds", cfg.Continuation.MaxRounds)
+	}
+	if len(cfg.SystemPrompts) > 0 {
+		prompts := make([]server.SystemPrompt, len(cfg.SystemPrompts))
+		for i, sp := range cfg.SystemPrompts {
+			prompts[i] = server.SystemPrompt{
+				APIKeys:       sp.APIKeys,
+				Tenants:       sp.Tenants,
+				Prompt:        model.InjectedPrompt{Content: sp.Prompt, Append: sp.Position == "append"},
+				HideFromCache: sp.HideFromCache,
+			}
+		}
+		handler.SetSystemPrompts(prompts)
+		handler.SetTenants(cfg.Tenants)
+		logger.Info("system prompts enabled", "rules", len(prompts))
+	}
+	if len(cfg.Profiles) > 0 {
+		profiles := make(map[string]server.Profile, len(cfg.Profiles))
+		for name, p := range cfg.Profiles {
+			profiles[name] = server.Profile{
+				Temperature:  p.Temperature,
+				MaxTokens:    p.MaxTokens,
+				SystemPrompt: model.InjectedPrompt{Content: p.SystemPrompt, Append: p.Position == "append"},
+				Models:       p.Models,
+			}
+		}
+		handler.SetProfiles(profiles)
+		logger.Info("request profiles enabled", "profiles", len(profiles))
+	}
+	var debugLog *server.DebugLog
+	if cfg.Debug.Requests > 0 {
+		debugLog = server.NewDebugLog(cfg.Debug.Requests, cfg.Debug.IncludeContent)
+		handler.SetDebugLog(debugLog)
+		logger.Info("request debug log enabled", "requests", cfg.Debug.Requests, "include_content", cfg.Debug.IncludeContent)
+	}
+	if exactCache != nil || semanticCache != nil {
+		handler.SetStoreFilter(storeFilter)
+	}
+	handler.SetCapabilities(func(m string) []server.ProviderModel {
+		var out []server.ProviderModel
+		for _, p := range registry.Candidates(m) {
+			entry, _ := catalog.Lookup(p.Name(), m)
+			out = append(out, server.ProviderModel{Provider: p.Name(), Model: entry})
+		}
+		return out
+	})
+	if cfg.Idempotency.Enabled {
+		handler.SetIdempotency(cfg.Idempotency.TTL)
+	}
+	if feedback != nil {
+		handler.SetFeedback(feedback)
+		logger.Info("cache feedback enabled", "threshold", cfg.Cache.Feedback.Threshold, "window", cfg.Cache.Feedback.Window)
+	}
+	if cfg.Async.Enabled {
+		handler.SetAsync(cfg.Async.Workers, cfg.Async.MaxQueue, cfg.Async.TTL, cfg.Async.Callbacks, cfg.Async.CallbackHosts)
+		logger.Info("async completions enabled", "workers", cfg.Async.Workers, "max_queue", cfg.Async.MaxQueue, "callbacks", cfg.Async.Callbacks)
+	}
+	if c := cfg.Tokenizer.CountTokens; c.Enabled {
+		handler.SetRemoteTokenCounts(func(model string) provider.TokenCounter {
+			p, err := registry.Lookup(model)
+			if err != nil {
+				return nil
+			}
+			// Fixture-wrapped providers don't count: replay must not call
+			// upstream.
+			tc, _ := p.(provider.TokenCounter)
+			return tc
+		}, c.CacheSize, c.CacheTTL, c.Timeout)
+		logger.Info("upstream token counts enabled", "timeout", c.Timeout, "cache_size", c.CacheSize)
+	}
+	if cfg.Guardrails.Enabled {
+		windows := catalog.ContextWindows()
+		maps.Copy(windows, cfg.Guardrails.ContextWindows)
+		handler.SetContextGuard(server.NewContextGuard(counter, windows, cfg.Guardrails.Action == "truncate"))
+		logger.Info("context window guardrails enabled", "action", cfg.Guardrails.Action)
+	}
+
+	var limiter *server.Limiter
+	if cfg.Server.MaxConcurrent > 0 {
+		limiter = server.NewLimiter(cfg.Server.MaxConcurrent, cfg.Server.MaxQueue, cfg.Server.QueueTimeout)
+		handler.SetLimiter(limiter)
+		logger.Info("request limiter enabled", "max_concurrent", cfg.Server.MaxConcurrent, "max_queue", cfg.Server.MaxQueue)
+	}
+
+	if rl := cfg.RateLimit; rl.Requests > 0 {
+		var store server.RateStore = server.NewLocalRateStore()
+		if rl.Redis.Addr != "" {
+			client := redis.NewClient(rl.Redis.Addr, rl.Redis.Password, rl.Redis.DB, rl.Redis.Timeout)
+			defer client.Close()
+			store = server.NewRedisRateStore(client, rl.Redis.KeyPrefix, rl.Redis.RetryInterval, logger)
+		}
+		handler.SetRateLimiter(server.NewRateLimiter(rl.Requests, rl.Window, store))
+		logger.Info("rate limiting enabled", "requests", rl.Requests, "window", rl.Window, "redis", rl.Redis.Addr)
+	}
+
+	var mirror *server.Mirror
+	if cfg.Mirror.URL != "" && cfg.Mirror.Percent > 0 {
+		mirror = server.NewMirror(cfg.Mirror.URL, cfg.Mirror.Percent, cfg.Mirror.Timeout, cfg.Mirror.MaxInFlight, logger)
+		handler.SetMirror(mirror)
+		logger.Info("request mirroring enabled", "url", cfg.Mirror.URL, "percent", cfg.Mirror.Percent)
+	}
+
+	var rollup *savings.Rollup
+	savingsCtx, stopSavings := context.WithCancel(context.Background())
+	savingsDone := make(chan struct{})
+	if cfg.Savings.Path != "" {
+		rollup, err = savings.Open(cfg.Savings.Path)
+		if err != nil {
+			logger.Error("failed to open savings rollup", "error", err)
+			os.Exit(1)
+		}
+		rollup.SetMaxTags(cfg.Savings.MaxTags)
+		handler.SetSavings(rollup)
+		go func() {
+			defer close(savingsDone)
+			rollup.Run(savingsCtx, cfg.Savings.FlushInterval, func(err error) {
+				logger.Error("failed to flush savings rollup", "error", err)
+			})
+		}()
+		logger.Info("savings rollup enabled", "path", cfg.Savings.Path, "flush_interval", cfg.Savings.FlushInterval)
+	} else {
+		close(savingsDone)
+	}
+	alertsCtx, stopAlerts := context.WithCancel(context.Background())
+	if len(cfg.Alerts.Rules) > 0 {
+		rules := make([]alert.Rule, len(cfg.Alerts.Rules))
+		for i, r := range cfg.Alerts.Rules {
+			rules[i] = alert.Rule{Name: r.Name, Metric: r.Metric, Below: r.Below, Above: r.Above, MinSamples: r.MinSamples}
+		}
+		source := func() alert.Snapshot {
+			hs, ds := handler.Stats(), dispatch.Stats()
+			quotaRemaining, quotaReported := lowestQuota(quotas)
+			return alert.Snapshot{
+				Requests:         hs.Requests,
+				CacheHits:        hs.CacheHits,
+				Cost:             hs.Cost,
+				UpstreamRequests: ds.Requests,
+				UpstreamErrors:   ds.Errors,
+				Deprecated:       hs.Deprecated,
+				SlowClients:      hs.SlowClients,
+				RateLimited:      hs.RateLimited,
+				QuotaRemaining:   quotaRemaining,
+				QuotaReported:    quotaReported,
+			}
+		}
+		evaluator := alert.NewEvaluator(rules, source, alert.NewWebhook(cfg.Alerts.WebhookURL, cfg.Alerts.Slack), cfg.Alerts.Cooldown)
+		go evaluator.Run(alertsCtx, cfg.Alerts.Interval, func(err error) {
+			logger.Error("failed to deliver alert", "error", err)
+		})
+		logger.Info("alerting enabled", "rules", len(rules), "interval", cfg.Alerts.Interval)
+	}
+
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux)
+	// With separate admin listeners, /admin/* is not served on the main port.
+	adminMux := mux
+	if len(adminLns) > 0 {
+		adminMux = http.NewServeMux()
+	}
+
+	for _, pc := range cfg.Providers {
+		if pc.Name != cfg.ReadThrough.Provider {
+			continue
+		}
+		baseURL := pc.BaseURL
+		if baseURL == "" {
+			baseURL = provider.Presets[pc.Type].BaseURL
+		}
+		server.NewReadThrough(baseURL, pc.APIKey, cfg.ReadThrough.ModelsTTL, cfg.ReadThrough.EmbeddingsTTL).RegisterRoutes(mux)
+		logger.Info("read-through cache enabled", "provider", pc.Name)
+	}
+
+	adminMux.HandleFunc("GET /admin/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := struct {
+			Exact     *cache.Stats               `json:"exact"`
+			Semantic  bool                       `json:"semantic_enabled"`
+			Degraded  bool                       `json:"semantic_degraded"`
+			Embedding []embedding.EndpointStatus `json:"embedding_endpoints,omitempty"`
+			Oversize  uint64                     `json:"skipped_oversize"`
+			NoStore   uint64                     `json:"skipped_no_store"`
+			Deduped   uint64                     `json:"semantic_deduped"`
+			Feedback  *cache.FeedbackStats       `json:"feedback,omitempty"`
+		}{Semantic: qdrantClient != nil, Oversize: storeFilter.Oversize(), NoStore: storeFilter.NoStore()}
+		if feedback != nil {
+			s := feedback.Stats()
+			stats.Feedback = &s
+		}
+		if semanticCache != nil {
+			stats.Deduped = semanticCache.Deduped()
+		}
+		if embFailover != nil {
+			stats.Degraded = embFailover.Degraded()
+			stats.Embedding = embFailover.Status()
+		}
+		if exactCache != nil {
+			s := exactCache.Stats()
+			stats.Exact = &s
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	adminMux.HandleFunc("GET /admin/cache/analytics", func(w http.ResponseWriter, r *http.Request) {
+		if exactCache == nil {
+			http.Error(w, "exact cache disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exactCache.Analytics())
+	})
+
+	adminMux.HandleFunc("GET /admin/semantic/inspect", func(w http.ResponseWriter, r *http.Request) {
+		if semanticCache == nil {
+			http.Error(w, "semantic cache disabled", http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		results, err := semanticCache.Inspect(r.Context(), q, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+
+	adminMux.HandleFunc("GET /admin/semantic/export", func(w http.ResponseWriter, r *http.Request) {
+		if semanticCache == nil {
+			http.Error(w, "semantic cache disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		n, err := qdrantClient.Export(r.Context(), w)
+		if err != nil {
+			logger.Error("semantic cache export failed", "error", err, "exported", n)
+			if n == 0 {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			// Headers are sent; abort so the client sees a truncated body
+			// rather than a complete-looking export.
+			panic(http.ErrAbortHandler)
+		}
+		logger.Info("semantic cache exported", "points", n)
+	})
+
+	adminMux.HandleFunc("POST /admin/semantic/import", func(w http.ResponseWriter, r *http.Request) {
+		if semanticCache == nil {
+			http.Error(w, "semantic cache disabled", http.StatusNotFound)
+			return
+		}
+		n, err := qdrantClient.Import(r.Context(), r.Body)
+		if err != nil {
+			logger.Error("semantic cache import failed", "error", err, "imported", n)
+			http.Error(w, fmt.Sprintf("imported %d points, then: %v", n, err), http.StatusBadGateway)
+			return
+		}
+		logger.Info("semantic cache imported", "points", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"imported": n})
+	})
 
-	mux.HandleFunc("POST /admin/cache/clear", func(w http.ResponseWriter, r *http.Request) {
+	adminMux.HandleFunc("POST /admin/cache/clear", func(w http.ResponseWriter, r *http.Request) {
 		if exactCache != nil {
 			exactCache.Clear()
 		}
@@ -145,12 +806,139 @@ func main() {
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
-	wrapped := server.Chain(mux,
-		server.RequestID,
-		server.Logger(logger),
-		server.Recovery(logger),
-		server.CORS,
-	)
+	if rollup != nil {
+		adminMux.Handle("GET /admin/savings", rollup)
+	}
+	if limiter != nil {
+		adminMux.Handle("GET /admin/load", limiter)
+	}
+	adminMux.HandleFunc("GET /admin/tokenizer", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			tokenizer.Stats
+			RemoteCounts *server.TokenCountStats `json:"remote_counts,omitempty"`
+		}{counter.Stats(), handler.TokenCountStats()})
+	})
+	if chaos != nil {
+		adminMux.HandleFunc("GET /admin/chaos", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(chaos.Stats())
+		})
+	}
+	adminMux.HandleFunc("GET /admin/providers", func(w http.ResponseWriter, r *http.Request) {
+		type providerInfo struct {
+			Name       string          `json:"name"`
+			Type       string          `json:"type"`
+			Models     []catalog.Model `json:"models"`
+			Discovered []catalog.Model `json:"discovered,omitempty"`
+			// Discovery and Health are the last model listing and health
+			// probe, with when they ran.
+			Discovery *provider.DiscoveryStatus `json:"discovery,omitempty"`
+			Health    *provider.HealthStatus    `json:"health,omitempty"`
+			Quota     *provider.Quota           `json:"quota,omitempty"`
+		}
+		out := make([]providerInfo, 0, len(registered))
+		for _, pc := range registered {
+			info := providerInfo{Name: pc.Name, Type: pc.Type, Models: catalog.Models(pc.Name, pc.ModelNames())}
+			if d, ok := discoveries[pc.Name]; ok {
+				info.Discovered = catalog.Models(pc.Name, d.Models())
+				st := d.Status()
+				info.Discovery = &st
+			}
+			if probe, ok := probes[pc.Name]; ok {
+				if st := probe.Status(); !st.CheckedAt.IsZero() {
+					info.Health = &st
+				}
+			}
+			if quota, ok := quotas[pc.Name]; ok {
+				if q := quota(); !q.Updated.IsZero() {
+					info.Quota = &q
+				}
+			}
+			out = append(out, info)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Providers []providerInfo `json:"providers"`
+		}{out})
+	})
+	adminMux.HandleFunc("GET /admin/upstream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dispatch.Stats())
+	})
+	reload := make(chan *config.Config, 1)
+	adminMux.Handle("POST /admin/config/validate", configHandler(cfg, nil))
+	adminMux.Handle("POST /admin/config/apply", configHandler(cfg, reload))
+	adminMux.HandleFunc("POST /admin/replay", handler.ServeReplay)
+	adminMux.HandleFunc("GET /admin/version", handler.ServeVersion)
+	if exactCache != nil {
+		adminMux.HandleFunc("GET /admin/cache/entries/{key}", handler.ServeCachedResponse)
+	}
+
+	if debugLog != nil {
+		adminMux.Handle("GET /admin/debug/requests/{id}", debugLog)
+		adminMux.HandleFunc("GET /admin/debug/export", debugLog.ServeExport)
+	}
+	if fingerprints != nil {
+		adminMux.HandleFunc("GET /admin/fingerprints", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fingerprints.Latest())
+		})
+	}
+	if mirror != nil {
+		adminMux.HandleFunc("GET /admin/mirror", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(mirror.Stats())
+		})
+	}
+	if len(transports) > 0 {
+		adminMux.HandleFunc("GET /admin/transport", func(w http.ResponseWriter, r *http.Request) {
+			stats := make(map[string]provider.PoolStats, len(transports))
+			for name, t := range transports {
+				stats[name] = t.Stats()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+		})
+	}
+
+	middlewares := []func(http.Handler) http.Handler{server.RequestID, server.Logger(logger)}
+	if al := cfg.AccessLog; al.Path != "" {
+		accessLog, err := server.OpenRotatingFile(al.Path, al.MaxBytes, al.MaxAge, al.MaxBackups)
+		if err != nil {
+			logger.Error("failed to open access log", "error", err)
+			os.Exit(1)
+		}
+		defer accessLog.Close()
+		middlewares = append(middlewares, server.AccessLog(accessLog, al.Format, al.Fields))
+		logger.Info("access log enabled", "path", al.Path, "format", al.Format)
+	}
+	middlewares = append(middlewares, server.Recovery(logger), server.CORS)
+
+	adminAuth := []func(http.Handler) http.Handler{server.BearerAuth(cfg.Admin.Token)}
+	var adminTLS *tls.Config
+	if cfg.Admin.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.Admin.ClientCAFile)
+		if err != nil {
+			logger.Error("failed to read admin client CA", "error", err)
+			os.Exit(1)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			logger.Error("no certificates in admin client CA file", "path", cfg.Admin.ClientCAFile)
+			os.Exit(1)
+		}
+		adminTLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+		adminAuth = append(adminAuth, server.ClientCertAuth)
+	}
+	if cfg.Admin.Token == "" && adminTLS == nil {
+		logger.Warn("admin endpoints are unauthenticated; set admin.token or admin.client_ca_file")
+	}
+	guardAdmin := func(next http.Handler) http.Handler { return server.Chain(next, adminAuth...) }
+	if len(adminLns) == 0 {
+		middlewares = append(middlewares, server.AdminOnly(guardAdmin))
+	}
+	wrapped := server.Chain(mux, middlewares...)
 
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.Server.Port),
@@ -159,25 +947,221 @@ func main() {
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      cfg.Server.WriteTimeout,
 		IdleTimeout:       120 * time.Second,
+		Protocols:         serverProtocols(cfg.Server),
+	}
+	if len(adminLns) == 0 {
+		// Client certificates are only checked on /admin/* requests.
+		srv.TLSConfig = adminTLS
 	}
 
 	go func() {
-		logger.Info("starting qlite proxy", "port", cfg.Server.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("starting qlite proxy",
+			"port", cfg.Server.Port,
+			"tls", cfg.Server.TLSEnabled(),
+			"h2c", cfg.Server.H2C,
+		)
+		var err error
+		if cfg.Server.TLSEnabled() {
+			err = srv.ServeTLS(ln.view(), cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			err = srv.Serve(ln.view())
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("server error", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	var adminSrvs []*http.Server
+	if len(adminLns) > 0 {
+		adminHandler := server.Chain(adminMux,
+			server.RequestID,
+			server.Logger(logger),
+			server.Recovery(logger),
+			guardAdmin,
+		)
+		if adminTLS != nil {
+			// Admin-only listeners can insist on a certificate during the
+			// handshake.
+			adminTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		for _, aln := range adminLns {
+			view := aln.view()
+			asrv := &http.Server{
+				Handler:           adminHandler,
+				ReadHeaderTimeout: 5 * time.Second,
+				IdleTimeout:       120 * time.Second,
+				TLSConfig:         adminTLS,
+			}
+			adminSrvs = append(adminSrvs, asrv)
+			go func() {
+				logger.Info("serving admin endpoints", "network", view.Addr().Network(), "addr", view.Addr().String(), "tls", adminTLS != nil)
+				var err error
+				if adminTLS != nil {
+					err = asrv.ServeTLS(view, cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+				} else {
+					err = asrv.Serve(view)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					logger.Error("admin server error", "error", err)
+				}
+			}()
+		}
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	defer signal.Stop(quit)
+	var next *config.Config
+	select {
+	case <-quit:
+		logger.Info("shutting down server...")
+	case next = <-reload:
+		logger.Info("applying new config, restarting proxy...")
+	}
 
-	logger.Info("shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Open requests finish first; async work they queued is flushed in the
+	// rest of the grace period.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("server forced to shutdown", "error", err)
 	}
-	logger.Info("server stopped")
+	for _, asrv := range adminSrvs {
+		if err := asrv.Shutdown(ctx); err != nil {
+			logger.Error("admin server forced to shutdown", "error", err)
+		}
+	}
+	stopAlerts()
+	stopDiscovery()
+	var hooks shutdown.Coordinator
+	if semStage != nil {
+		hooks.Register("semantic_stores", semStage.Drain)
+	}
+	if mirror != nil {
+		hooks.Register("mirror", mirror.Drain)
+	}
+	if cfg.Async.Enabled {
+		hooks.Register("async_jobs", handler.DrainAsync)
+	}
+	hooks.Register("savings", func(ctx context.Context) (int, error) {
+		// The final flush is a single local file write. Wait for it even past
+		// ctx, so a reload never reopens the file while it is being written.
+		stopSavings()
+		<-savingsDone
+		return 0, nil
+	})
+	for _, r := range hooks.Run(ctx) {
+		if r.Dropped > 0 || r.Err != nil {
+			logger.Warn("shutdown flush incomplete", "hook", r.Name, "dropped", r.Dropped, "elapsed", r.Elapsed, "error", r.Err)
+		} else {
+			logger.Info("shutdown flush complete", "hook", r.Name, "elapsed", r.Elapsed)
+		}
+	}
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+	return next
+}
+
+// providerAuth builds the AuthProvider a validated auth block describes.
+func providerAuth(ac config.ProviderAuthConfig) provider.AuthProvider {
+	if ac.Type == "oauth2" {
+		return provider.NewOAuthClientCredentials(ac.TokenURL, ac.ClientID, ac.ClientSecret, ac.Scopes)
+	}
+	s := provider.NewHMACSigner([]byte(ac.Secret), ac.KeyID)
+	if ac.Header != "" {
+		s.Header = ac.Header
+	}
+	if ac.TimestampHeader != "" {
+		s.TimestampHeader = ac.TimestampHeader
+	}
+	if ac.KeyIDHeader != "" {
+		s.KeyIDHeader = ac.KeyIDHeader
+	}
+	return s
+}
+
+// catalogModel returns the catalog entry for a configured model: the
+// built-in entry, if there is one, with the configured fields applied.
+func catalogModel(mc config.ModelConfig) catalog.Model {
+	m, _ := catalog.Lookup("", mc.Name)
+	if mc.ContextWindow > 0 {
+		m.ContextWindow = mc.ContextWindow
+	}
+	if mc.SupportsTools != nil {
+		m.SupportsTools = mc.SupportsTools
+	}
+	if mc.SupportsVision != nil {
+		m.SupportsVision = mc.SupportsVision
+	}
+	if mc.InputPrice != nil || mc.OutputPrice != nil {
+		var price catalog.Price
+		if m.Price != nil {
+			price = *m.Price
+		}
+		if mc.InputPrice != nil {
+			price.Input = *mc.InputPrice
+		}
+		if mc.OutputPrice != nil {
+			price.Output = *mc.OutputPrice
+		}
+		m.Price = &price
+	}
+	return m
+}
+
+// logDroppedFields returns a hook that logs each response field a provider
+// sends but qlite drops, once per field.
+func logDroppedFields(logger *slog.Logger, providerName string) func([]string) {
+	var seen sync.Map
+	return func(fields []string) {
+		for _, f := range fields {
+			if _, dup := seen.LoadOrStore(f, true); !dup {
+				logger.Warn("dropping unsupported response field", "provider", providerName, "field", f)
+			}
+		}
+	}
+}
+
+// lowestQuota returns the smallest remaining share of a rate limit reported
+// by any provider, and whether any provider reported one.
+func lowestQuota(quotas map[string]func() provider.Quota) (float64, bool) {
+	now := time.Now()
+	lowest, reported := 1.0, false
+	for _, quota := range quotas {
+		q := quota()
+		if q.Updated.IsZero() {
+			continue
+		}
+		lowest, reported = min(lowest, q.Remaining(now)), true
+	}
+	return lowest, reported
+}
+
+// loadConfig reads the YAML file at configPath, falling back to QLITE_CONFIG
+// and then config/config.yaml. Without an explicit file, QLITE_CONFIG_JSON or
+// a missing default file switch to environment-only configuration.
+func loadConfig(configPath string) (*config.Config, error) {
+	if configPath == "" {
+		configPath = os.Getenv("QLITE_CONFIG")
+	}
+	if configPath == "" {
+		configPath = "config/config.yaml"
+		if _, err := os.Stat(configPath); os.Getenv(config.ConfigJSONEnv) != "" || os.IsNotExist(err) {
+			return config.LoadEnv()
+		}
+	}
+	return config.Load(configPath)
+}
+
+// serverProtocols returns the protocol set for the listener. HTTP/1.1 is always
+// served; HTTP/2 is negotiated via ALPN when TLS is configured, and cleartext
+// HTTP/2 (h2c, prior knowledge) is accepted when enabled.
+func serverProtocols(sc config.ServerConfig) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(sc.TLSEnabled())
+	p.SetUnencryptedHTTP2(sc.H2C)
+	return p
 }
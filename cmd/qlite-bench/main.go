@@ -0,0 +1,297 @@
+// qlite-bench replays a synthetic chat workload against one or more running
+// qlite instances and compares their cache behaviour. Run each instance with
+// the cache configuration under test (for example different
+// semantic.threshold values), all pointing at cmd/mockserver, then:
+//
+//	go run ./cmd/qlite-bench -target t80=http://localhost:8080 -target t90=http://localhost:8081
+//
+// Every target receives the same seeded request sequence. A request is either
+// an exact duplicate of an earlier prompt, a paraphrase of an earlier prompt
+// (same topic, different template), or a new topic. Hit rate per kind shows
+// recall (duplicates, paraphrases) and false positives (new topics), and the
+// projected savings extrapolate the run to -monthly-requests.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Request kinds in a workload.
+const (
+	kindNew        = "new"
+	kindDuplicate  = "duplicate"
+	kindParaphrase = "paraphrase"
+)
+
+var templates = []string{
+	"What is %s?",
+	"Can you explain %s to me?",
+	"Give me a short overview of %s.",
+	"I'd like to understand %s. Where do I start?",
+	"Explain %s in simple terms.",
+	"Tell me about %s.",
+}
+
+var (
+	aspects  = []string{"the history of", "the basics of", "common mistakes in", "best practices for", "the future of", "the economics of", "how to teach", "the tooling for"}
+	subjects = []string{"distributed databases", "sourdough baking", "marathon training", "container orchestration", "beekeeping", "compiler design", "urban gardening", "jazz improvisation", "solar power", "chess openings", "watercolor painting", "network security"}
+)
+
+type targetFlag []target
+
+type target struct {
+	name string
+	url  string
+}
+
+func (t *targetFlag) String() string { return fmt.Sprint(*t) }
+
+func (t *targetFlag) Set(v string) error {
+	name, url, ok := strings.Cut(v, "=")
+	if !ok {
+		name, url = v, v
+	}
+	*t = append(*t, target{name: name, url: strings.TrimRight(url, "/")})
+	return nil
+}
+
+type benchRequest struct {
+	kind   string
+	prompt string
+}
+
+type result struct {
+	kind     string
+	latency  time.Duration
+	provider string
+	hit      bool
+	cost     float64
+	saved    float64
+	err      error
+}
+
+func main() {
+	var (
+		targets        targetFlag
+		requests       = flag.Int("requests", 500, "requests per target")
+		concurrency    = flag.Int("concurrency", 8, "concurrent requests")
+		duplicateRate  = flag.Float64("duplicate-rate", 0.3, "fraction of requests that repeat an earlier prompt exactly")
+		paraphraseRate = flag.Float64("paraphrase-rate", 0.2, "fraction of requests that reword an earlier prompt")
+		seed           = flag.Uint64("seed", 1, "workload seed; the same seed produces the same requests")
+		modelName      = flag.String("model", "gpt-4o-mini", "model to request")
+		monthly        = flag.Int("monthly-requests", 1_000_000, "request volume used to project monthly savings")
+	)
+	flag.Var(&targets, "target", "qlite instance as name=url (repeatable, default http://localhost:8080)")
+	flag.Parse()
+
+	if *duplicateRate < 0 || *paraphraseRate < 0 || *duplicateRate+*paraphraseRate > 1 {
+		log.Fatal("-duplicate-rate and -paraphrase-rate must be >= 0 and sum to at most 1")
+	}
+	if *requests < 1 || *concurrency < 1 {
+		log.Fatal("-requests and -concurrency must be >= 1")
+	}
+	if len(targets) == 0 {
+		targets = targetFlag{{name: "default", url: "http://localhost:8080"}}
+	}
+
+	workload := generate(*requests, *duplicateRate, *paraphraseRate, *seed)
+	client := &http.Client{Timeout: 2 * time.Minute}
+	apiKey := os.Getenv("OPENAI_API_KEY")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "target\terrors\thit rate\texact\tsemantic\tdup hits\tparaphrase hits\tnew hits\tp50\tp99\tsaved\tmonthly saved\t")
+	for _, t := range targets {
+		results := run(client, t.url, apiKey, *modelName, workload, *concurrency)
+		fmt.Fprintln(tw, summarize(t.name, results, *monthly))
+	}
+	tw.Flush()
+}
+
+// generate builds a workload of n requests. Duplicates and paraphrases only
+// refer to prompts that appeared earlier in the sequence.
+func generate(n int, duplicateRate, paraphraseRate float64, seed uint64) []benchRequest {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	type issued struct {
+		topic    string
+		template int
+	}
+	var seen []issued
+	topics := 0
+	newTopic := func() string {
+		i := topics
+		topics++
+		topic := aspects[i%len(aspects)] + " " + subjects[(i/len(aspects))%len(subjects)]
+		if round := i / (len(aspects) * len(subjects)); round > 0 {
+			topic += " (part " + strconv.Itoa(round+1) + ")"
+		}
+		return topic
+	}
+
+	out := make([]benchRequest, 0, n)
+	for range n {
+		r := rng.Float64()
+		switch {
+		case len(seen) > 0 && r < duplicateRate:
+			s := seen[rng.IntN(len(seen))]
+			out = append(out, benchRequest{kindDuplicate, fmt.Sprintf(templates[s.template], s.topic)})
+		case len(seen) > 0 && r < duplicateRate+paraphraseRate:
+			s := seen[rng.IntN(len(seen))]
+			tmpl := (s.template + 1 + rng.IntN(len(templates)-1)) % len(templates)
+			out = append(out, benchRequest{kindParaphrase, fmt.Sprintf(templates[tmpl], s.topic)})
+		default:
+			s := issued{topic: newTopic(), template: rng.IntN(len(templates))}
+			seen = append(seen, s)
+			out = append(out, benchRequest{kindNew, fmt.Sprintf(templates[s.template], s.topic)})
+		}
+	}
+	return out
+}
+
+// run sends the workload to baseURL in order with the given concurrency.
+func run(client *http.Client, baseURL, apiKey, modelName string, workload []benchRequest, concurrency int) []result {
+	results := make([]result, len(workload))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = send(client, baseURL, apiKey, modelName, workload[i])
+			}
+		}()
+	}
+	for i := range workload {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func send(client *http.Client, baseURL, apiKey, modelName string, br benchRequest) result {
+	res := result{kind: br.kind}
+	temperature := 0.0
+	body, _ := json.Marshal(model.ChatRequest{
+		Model:       modelName,
+		Messages:    []model.Message{{Role: "user", Content: br.prompt}},
+		Temperature: &temperature,
+	})
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.latency = time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		res.err = fmt.Errorf("status %d", resp.StatusCode)
+		return res
+	}
+
+	res.provider = resp.Header.Get("X-Provider")
+	res.hit = resp.Header.Get("X-Cache") == "HIT"
+	res.cost, _ = strconv.ParseFloat(resp.Header.Get("X-Request-Cost"), 64)
+	res.saved, _ = strconv.ParseFloat(resp.Header.Get("X-Cost-Saved"), 64)
+	return res
+}
+
+// summarize formats one tab-separated report row.
+func summarize(name string, results []result, monthly int) string {
+	var errs, hits, exact, semantic int
+	var cost, saved float64
+	total := map[string]int{}
+	kindHits := map[string]int{}
+	var latencies []time.Duration
+	for _, r := range results {
+		if r.err != nil {
+			errs++
+			continue
+		}
+		total[r.kind]++
+		latencies = append(latencies, r.latency)
+		cost += r.cost
+		saved += r.saved
+		if !r.hit {
+			continue
+		}
+		hits++
+		kindHits[r.kind]++
+		if r.provider == "semantic_cache" {
+			semantic++
+		} else {
+			exact++
+		}
+	}
+	ok := len(results) - errs
+	slices.Sort(latencies)
+
+	cells := []string{
+		name,
+		strconv.Itoa(errs),
+		percent(hits, ok),
+		percent(exact, ok),
+		percent(semantic, ok),
+	}
+	for _, k := range []string{kindDuplicate, kindParaphrase, kindNew} {
+		cells = append(cells, percent(kindHits[k], total[k]))
+	}
+	var perRequest float64
+	if ok > 0 {
+		perRequest = saved / float64(ok)
+	}
+	cells = append(cells,
+		percentile(latencies, 0.50).Round(10*time.Microsecond).String(),
+		percentile(latencies, 0.99).Round(10*time.Microsecond).String(),
+		fmt.Sprintf("$%.6f (%s)", saved, percentFloat(saved, saved+cost)),
+		fmt.Sprintf("$%.2f", perRequest*float64(monthly)),
+	)
+	return strings.Join(cells, "\t") + "\t"
+}
+
+func percent(n, of int) string {
+	return percentFloat(float64(n), float64(of))
+}
+
+func percentFloat(n, of float64) string {
+	if of == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*n/of)
+}
+
+// percentile returns the p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
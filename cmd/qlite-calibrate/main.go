@@ -0,0 +1,345 @@
+// qlite-calibrate measures how well semantic cache thresholds separate
+// prompts that should share a cached answer from prompts that should not.
+// It reads a CSV of labeled prompt pairs:
+//
+//	prompt_a,prompt_b,label
+//	What is Go?,Tell me about the Go language.,same
+//	What is Go?,What is Rust?,different
+//
+// embeds every prompt with the configured embedding endpoint, and reports
+// precision and recall of "similarity >= threshold" across a range of
+// thresholds:
+//
+//	go run ./cmd/qlite-calibrate -config config/config.yaml -pairs pairs.csv
+//
+// The recommended threshold is the lowest one whose precision reaches
+// -min-precision, which gives the best hit rate at that false-positive
+// budget. With -write it is stored as cache.semantic.threshold in -config.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/eduardmaghakyan/qlite/internal/config"
+	"github.com/eduardmaghakyan/qlite/internal/embedding"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+type pair struct {
+	a, b string
+	same bool
+}
+
+// score counts outcomes at one threshold, where "positive" means the pair
+// would be served from cache.
+type score struct {
+	threshold      float64
+	tp, fp, fn, tn int
+}
+
+func (s score) precision() float64 { return ratio(s.tp, s.tp+s.fp) }
+func (s score) recall() float64    { return ratio(s.tp, s.tp+s.fn) }
+
+func (s score) f1() float64 {
+	p, r := s.precision(), s.recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+func main() {
+	var (
+		pairsPath    = flag.String("pairs", "", "CSV of prompt_a,prompt_b,label (same|different) rows (required)")
+		configPath   = flag.String("config", os.Getenv("QLITE_CONFIG"), "qlite config supplying the embedding endpoint; -write updates it")
+		embURL       = flag.String("embedding-url", "", "embedding API base URL (overrides -config)")
+		embKey       = flag.String("embedding-key", "", "embedding API key (overrides -config; default $OPENAI_API_KEY)")
+		embModel     = flag.String("embedding-model", "", "embedding model (overrides -config)")
+		from         = flag.Float64("from", 0.80, "lowest threshold to evaluate")
+		to           = flag.Float64("to", 0.99, "highest threshold to evaluate")
+		step         = flag.Float64("step", 0.01, "threshold step")
+		minPrecision = flag.Float64("min-precision", 0.98, "precision the recommended threshold must reach")
+		concurrency  = flag.Int("concurrency", 4, "concurrent embedding requests")
+		write        = flag.Bool("write", false, "store the recommended threshold in -config")
+	)
+	flag.Parse()
+
+	if *pairsPath == "" {
+		log.Fatal("-pairs is required")
+	}
+	if *step <= 0 || *from > *to || *concurrency < 1 {
+		log.Fatal("-step and -concurrency must be positive and -from at most -to")
+	}
+	if *write && *configPath == "" {
+		log.Fatal("-write needs -config")
+	}
+
+	url, key, modelName := "https://api.openai.com/v1", os.Getenv("OPENAI_API_KEY"), "text-embedding-3-small"
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("loading config: %v", err)
+		}
+		sc := cfg.Cache.Semantic
+		url, modelName = sc.EmbeddingURL, sc.EmbeddingModel
+		if sc.EmbeddingKey != "" {
+			key = sc.EmbeddingKey
+		}
+	}
+	url, key, modelName = orFlag(*embURL, url), orFlag(*embKey, key), orFlag(*embModel, modelName)
+
+	pairs, err := readPairs(*pairsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := embedding.NewClient(url, key, modelName)
+	sims, err := similarities(client, pairs, *concurrency)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var scores []score
+	// Count steps rather than accumulating floats so the range ends exactly.
+	for i := 0; ; i++ {
+		t := math.Round((*from+float64(i)**step)*1e4) / 1e4
+		if t > *to+1e-9 {
+			break
+		}
+		scores = append(scores, evaluate(t, pairs, sims))
+	}
+	best, ok := recommend(scores, *minPrecision)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "threshold\tprecision\trecall\tf1\tfalse hits\tmissed\t\t")
+	for _, s := range scores {
+		mark := ""
+		if ok && s.threshold == best.threshold {
+			mark = "<- recommended"
+		}
+		fmt.Fprintf(tw, "%.2f\t%.3f\t%.3f\t%.3f\t%d\t%d\t%s\t\n", s.threshold, s.precision(), s.recall(), s.f1(), s.fp, s.fn, mark)
+	}
+	tw.Flush()
+
+	if !ok {
+		fmt.Printf("\nno threshold in [%.2f, %.2f] reaches precision %.3f; add more labeled pairs or lower -min-precision\n", *from, *to, *minPrecision)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%d pairs, model %s: recommended threshold %.2f (precision %.3f, recall %.3f)\n",
+		len(pairs), modelName, best.threshold, best.precision(), best.recall())
+
+	if *write {
+		if err := writeThreshold(*configPath, best.threshold); err != nil {
+			log.Fatalf("writing threshold: %v", err)
+		}
+		fmt.Printf("wrote cache.semantic.threshold: %.2f to %s\n", best.threshold, *configPath)
+	}
+}
+
+func orFlag(flagValue, def string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return def
+}
+
+// readPairs parses the labeled pairs CSV. A first row whose label isn't
+// recognised is taken as a header.
+func readPairs(path string) ([]pair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	var pairs []pair
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		same, ok := parseLabel(rec[2])
+		if !ok {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: label must be same or different, got %q", path, line, rec[2])
+		}
+		pairs = append(pairs, pair{a: rec[0], b: rec[1], same: same})
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%s: no labeled pairs", path)
+	}
+	return pairs, nil
+}
+
+func parseLabel(s string) (same, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "same", "1", "true", "yes":
+		return true, true
+	case "different", "0", "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// similarities returns the cosine similarity of each pair. Prompts are
+// embedded once each, as the semantic cache embeds a single user message.
+func similarities(client *embedding.Client, pairs []pair, concurrency int) ([]float64, error) {
+	var texts []string
+	index := make(map[string]int)
+	for _, p := range pairs {
+		for _, t := range []string{p.a, p.b} {
+			if _, ok := index[t]; !ok {
+				index[t] = len(texts)
+				texts = append(texts, t)
+			}
+		}
+	}
+
+	vectors := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range texts {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			text := embedding.TextFromMessages([]model.Message{{Role: "user", Content: t}})
+			vectors[i], errs[i] = client.Embed(ctx, text)
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("embedding prompts: %w", err)
+	}
+
+	sims := make([]float64, len(pairs))
+	for i, p := range pairs {
+		sims[i] = cosine(vectors[index[p.a]], vectors[index[p.b]])
+	}
+	return sims, nil
+}
+
+// cosine matches the Cosine distance the semantic cache's Qdrant collection
+// is created with.
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func evaluate(threshold float64, pairs []pair, sims []float64) score {
+	s := score{threshold: threshold}
+	for i, p := range pairs {
+		hit := sims[i] >= threshold
+		switch {
+		case hit && p.same:
+			s.tp++
+		case hit:
+			s.fp++
+		case p.same:
+			s.fn++
+		default:
+			s.tn++
+		}
+	}
+	return s
+}
+
+// recommend picks the lowest threshold reaching minPrecision. Scores are in
+// ascending threshold order.
+func recommend(scores []score, minPrecision float64) (score, bool) {
+	for _, s := range scores {
+		if s.tp > 0 && s.precision() >= minPrecision {
+			return s, true
+		}
+	}
+	return score{}, false
+}
+
+// writeThreshold sets cache.semantic.threshold in the YAML file at path,
+// creating the keys if needed. Comments are kept; indentation is
+// normalised to two spaces.
+func writeThreshold(path string, threshold float64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	node := doc.Content[0]
+	for _, key := range []string{"cache", "semantic"} {
+		node = mappingChild(node, key, yaml.MappingNode)
+	}
+	value := mappingChild(node, "threshold", yaml.ScalarNode)
+	value.Tag, value.Value = "!!float", fmt.Sprintf("%.2f", threshold)
+
+	var out strings.Builder
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(out.String()), info.Mode().Perm())
+}
+
+// mappingChild returns the value node for key in mapping m, adding an empty
+// node of kind if the key is missing.
+func mappingChild(m *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: kind}
+	if kind == yaml.MappingNode {
+		child.Tag = "!!map"
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
@@ -0,0 +1,236 @@
+// Package alert evaluates usage alert rules (cache hit rate, daily spend,
+// provider error rate, provider quota, slow clients) over the proxy's counters and delivers notifications
+// to a webhook.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Metric names understood by rules.
+const (
+	// MetricCacheHitRate is the percentage of requests served from cache
+	// during the last evaluation interval.
+	MetricCacheHitRate = "cache_hit_rate"
+	// MetricDailySpend is the upstream cost in USD since the start of the
+	// current UTC day (or since startup, if later).
+	MetricDailySpend = "daily_spend"
+	// MetricProviderErrorRate is the percentage of upstream calls that
+	// failed during the last evaluation interval.
+	MetricProviderErrorRate = "provider_error_rate"
+	// MetricDeprecatedRequests is the number of requests for deprecated
+	// models during the last evaluation interval.
+	MetricDeprecatedRequests = "deprecated_requests"
+	// MetricProviderQuotaRemaining is the smallest percentage of a rate
+	// limit any provider's upstream reported as remaining.
+	MetricProviderQuotaRemaining = "provider_quota_remaining"
+	// MetricSlowClients is the number of streams abandoned because the
+	// client fell behind during the last evaluation interval.
+	MetricSlowClients = "slow_clients"
+	// MetricRateLimited is the number of requests rejected by per-key rate
+	// limits during the last evaluation interval.
+	MetricRateLimited = "rate_limited"
+)
+
+// Snapshot holds cumulative counters sampled at evaluation time.
+type Snapshot struct {
+	Requests         uint64
+	CacheHits        uint64
+	Cost             float64
+	UpstreamRequests uint64
+	UpstreamErrors   uint64
+	Deprecated       uint64
+	SlowClients      uint64
+	RateLimited      uint64
+	// QuotaRemaining is a current value rather than a counter; it is only
+	// meaningful when QuotaReported is set.
+	QuotaRemaining float64
+	QuotaReported  bool
+}
+
+// Rule fires when Metric crosses its threshold: below Below or above Above.
+// Exactly one of Below and Above should be set. Rate metrics are only
+// evaluated once the interval saw at least MinSamples requests.
+type Rule struct {
+	Name       string
+	Metric     string
+	Below      *float64
+	Above      *float64
+	MinSamples uint64
+}
+
+// Alert is a fired rule.
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// Notifier delivers fired alerts.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Evaluator periodically samples counters and fires rules. A rule that stays
+// breached is re-notified at most once per cooldown.
+type Evaluator struct {
+	rules    []Rule
+	source   func() Snapshot
+	notifier Notifier
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	prev     Snapshot
+	day      string
+	dayStart Snapshot
+	fired    map[string]time.Time
+}
+
+// NewEvaluator creates an evaluator over source. The first sample is taken
+// immediately so the first interval's rates start from now.
+func NewEvaluator(rules []Rule, source func() Snapshot, notifier Notifier, cooldown time.Duration) *Evaluator {
+	e := &Evaluator{
+		rules:    rules,
+		source:   source,
+		notifier: notifier,
+		cooldown: cooldown,
+		now:      time.Now,
+		fired:    make(map[string]time.Time),
+	}
+	e.prev = source()
+	e.day = e.now().UTC().Format(time.DateOnly)
+	e.dayStart = e.prev
+	return e
+}
+
+// Evaluate samples the counters once, notifies for breached rules, and
+// returns the alerts that fired along with any delivery errors.
+func (e *Evaluator) Evaluate(ctx context.Context) ([]Alert, error) {
+	e.mu.Lock()
+	now := e.now()
+	cur := e.source()
+	prev := e.prev
+	e.prev = cur
+	if day := now.UTC().Format(time.DateOnly); day != e.day {
+		e.day = day
+		e.dayStart = prev
+	}
+	dayStart := e.dayStart
+
+	var fired []Alert
+	for _, r := range e.rules {
+		value, ok := metricValue(r, prev, cur, dayStart)
+		threshold, breached := r.check(value)
+		if !ok || !breached {
+			delete(e.fired, r.Name)
+			continue
+		}
+		if last, ok := e.fired[r.Name]; ok && now.Sub(last) < e.cooldown {
+			continue
+		}
+		e.fired[r.Name] = now
+		fired = append(fired, Alert{
+			Rule:      r.Name,
+			Metric:    r.Metric,
+			Value:     value,
+			Threshold: threshold,
+			Message:   r.message(value, threshold),
+			Time:      now,
+		})
+	}
+	e.mu.Unlock()
+
+	var errs []error
+	for _, a := range fired {
+		if err := e.notifier.Notify(ctx, a); err != nil {
+			// Allow a retry on the next evaluation rather than waiting out
+			// the cooldown for an alert nobody received.
+			e.mu.Lock()
+			delete(e.fired, a.Rule)
+			e.mu.Unlock()
+			errs = append(errs, fmt.Errorf("notifying %s: %w", a.Rule, err))
+		}
+	}
+	return fired, errors.Join(errs...)
+}
+
+// Run evaluates every interval until ctx is cancelled.
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.Evaluate(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+func metricValue(r Rule, prev, cur, dayStart Snapshot) (float64, bool) {
+	switch r.Metric {
+	case MetricCacheHitRate:
+		n := cur.Requests - prev.Requests
+		if n == 0 || n < r.MinSamples {
+			return 0, false
+		}
+		return 100 * float64(cur.CacheHits-prev.CacheHits) / float64(n), true
+	case MetricProviderErrorRate:
+		n := cur.UpstreamRequests - prev.UpstreamRequests
+		if n == 0 || n < r.MinSamples {
+			return 0, false
+		}
+		return 100 * float64(cur.UpstreamErrors-prev.UpstreamErrors) / float64(n), true
+	case MetricDailySpend:
+		return cur.Cost - dayStart.Cost, true
+	case MetricDeprecatedRequests:
+		return float64(cur.Deprecated - prev.Deprecated), true
+	case MetricSlowClients:
+		return float64(cur.SlowClients - prev.SlowClients), true
+	case MetricRateLimited:
+		return float64(cur.RateLimited - prev.RateLimited), true
+	case MetricProviderQuotaRemaining:
+		return 100 * cur.QuotaRemaining, cur.QuotaReported
+	}
+	return 0, false
+}
+
+func (r Rule) check(value float64) (threshold float64, breached bool) {
+	if r.Below != nil && value < *r.Below {
+		return *r.Below, true
+	}
+	if r.Above != nil && value > *r.Above {
+		return *r.Above, true
+	}
+	return 0, false
+}
+
+func (r Rule) message(value, threshold float64) string {
+	dir := "above"
+	if r.Below != nil && value < *r.Below {
+		dir = "below"
+	}
+	switch r.Metric {
+	case MetricDailySpend:
+		return fmt.Sprintf("qlite alert %s: daily spend $%.2f is %s $%.2f", r.Name, value, dir, threshold)
+	case MetricDeprecatedRequests:
+		return fmt.Sprintf("qlite alert %s: %.0f requests for deprecated models is %s %.0f", r.Name, value, dir, threshold)
+	case MetricSlowClients:
+		return fmt.Sprintf("qlite alert %s: %.0f streams abandoned for slow clients is %s %.0f", r.Name, value, dir, threshold)
+	case MetricRateLimited:
+		return fmt.Sprintf("qlite alert %s: %.0f rate-limited requests is %s %.0f", r.Name, value, dir, threshold)
+	default:
+		return fmt.Sprintf("qlite alert %s: %s %.1f%% is %s %.1f%%", r.Name, r.Metric, value, dir, threshold)
+	}
+}
@@ -0,0 +1,181 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	alerts []Alert
+	err    error
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, a Alert) error {
+	n.alerts = append(n.alerts, a)
+	return n.err
+}
+
+func ptr(f float64) *float64 { return &f }
+
+func TestEvaluator_HitRateAndErrorRate(t *testing.T) {
+	snap := Snapshot{}
+	n := &recordingNotifier{}
+	e := NewEvaluator([]Rule{
+		{Name: "low-hits", Metric: MetricCacheHitRate, Below: ptr(50), MinSamples: 10},
+		{Name: "errors", Metric: MetricProviderErrorRate, Above: ptr(5)},
+	}, func() Snapshot { return snap }, n, time.Hour)
+
+	// 4 requests is below MinSamples: hit rate is not evaluated.
+	snap = Snapshot{Requests: 4, CacheHits: 0, UpstreamRequests: 4, UpstreamErrors: 0}
+	if fired, _ := e.Evaluate(context.Background()); len(fired) != 0 {
+		t.Fatalf("expected no alerts, got %+v", fired)
+	}
+
+	// Next interval: 20 requests, 5 hits (25%), 15 upstream calls, 3 errors (20%).
+	snap = Snapshot{Requests: 24, CacheHits: 5, UpstreamRequests: 19, UpstreamErrors: 3}
+	fired, err := e.Evaluate(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fired) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", fired)
+	}
+	if fired[0].Rule != "low-hits" || fired[0].Value != 25 {
+		t.Errorf("unexpected hit-rate alert: %+v", fired[0])
+	}
+	if fired[1].Rule != "errors" || fired[1].Value != 20 {
+		t.Errorf("unexpected error-rate alert: %+v", fired[1])
+	}
+}
+
+func TestEvaluator_DeprecatedRequests(t *testing.T) {
+	snap := Snapshot{}
+	n := &recordingNotifier{}
+	e := NewEvaluator([]Rule{{Name: "deprecated", Metric: MetricDeprecatedRequests, Above: ptr(0)}},
+		func() Snapshot { return snap }, n, time.Hour)
+
+	if fired, _ := e.Evaluate(context.Background()); len(fired) != 0 {
+		t.Fatalf("expected no alerts, got %+v", fired)
+	}
+	snap = Snapshot{Requests: 10, Deprecated: 3}
+	fired, _ := e.Evaluate(context.Background())
+	if len(fired) != 1 || fired[0].Value != 3 {
+		t.Fatalf("expected one alert for 3 requests, got %+v", fired)
+	}
+	if want := "qlite alert deprecated: 3 requests for deprecated models is above 0"; fired[0].Message != want {
+		t.Errorf("message = %q, want %q", fired[0].Message, want)
+	}
+}
+
+func TestEvaluator_SlowClients(t *testing.T) {
+	snap := Snapshot{SlowClients: 4}
+	e := NewEvaluator([]Rule{{Name: "slow", Metric: MetricSlowClients, Above: ptr(1)}},
+		func() Snapshot { return snap }, &recordingNotifier{}, time.Hour)
+
+	// Counters from before the first evaluation don't count.
+	if fired, _ := e.Evaluate(context.Background()); len(fired) != 0 {
+		t.Fatalf("expected no alerts, got %+v", fired)
+	}
+	snap.SlowClients = 6
+	fired, _ := e.Evaluate(context.Background())
+	if len(fired) != 1 || fired[0].Value != 2 {
+		t.Fatalf("expected one alert for 2 slow clients, got %+v", fired)
+	}
+	if want := "qlite alert slow: 2 streams abandoned for slow clients is above 1"; fired[0].Message != want {
+		t.Errorf("message = %q, want %q", fired[0].Message, want)
+	}
+}
+
+func TestEvaluator_RateLimited(t *testing.T) {
+	snap := Snapshot{RateLimited: 10}
+	e := NewEvaluator([]Rule{{Name: "limited", Metric: MetricRateLimited, Above: ptr(5)}},
+		func() Snapshot { return snap }, &recordingNotifier{}, time.Hour)
+
+	if fired, _ := e.Evaluate(context.Background()); len(fired) != 0 {
+		t.Fatalf("expected no alerts, got %+v", fired)
+	}
+	snap.RateLimited = 20
+	fired, _ := e.Evaluate(context.Background())
+	if len(fired) != 1 || fired[0].Message != "qlite alert limited: 10 rate-limited requests is above 5" {
+		t.Fatalf("expected one alert for 10 rejections, got %+v", fired)
+	}
+}
+
+func TestEvaluator_ProviderQuotaRemaining(t *testing.T) {
+	snap := Snapshot{}
+	e := NewEvaluator([]Rule{{Name: "quota", Metric: MetricProviderQuotaRemaining, Below: ptr(10)}},
+		func() Snapshot { return snap }, &recordingNotifier{}, time.Hour)
+
+	if fired, _ := e.Evaluate(context.Background()); len(fired) != 0 {
+		t.Fatalf("expected no alerts before any quota was reported, got %+v", fired)
+	}
+	snap = Snapshot{QuotaRemaining: 0.05, QuotaReported: true}
+	fired, _ := e.Evaluate(context.Background())
+	if len(fired) != 1 || fired[0].Value != 5 {
+		t.Fatalf("expected one alert at 5%%, got %+v", fired)
+	}
+}
+
+func TestEvaluator_CooldownAndRecovery(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cost := 0.0
+	n := &recordingNotifier{}
+	e := NewEvaluator([]Rule{{Name: "spend", Metric: MetricDailySpend, Above: ptr(10)}},
+		func() Snapshot { return Snapshot{Cost: cost} }, n, time.Hour)
+	e.now = func() time.Time { return now }
+
+	cost = 12
+	e.Evaluate(context.Background())
+	now = now.Add(time.Minute)
+	e.Evaluate(context.Background())
+	if len(n.alerts) != 1 {
+		t.Fatalf("expected cooldown to suppress repeat, got %d alerts", len(n.alerts))
+	}
+
+	// A new UTC day resets the spend baseline, so the rule recovers.
+	now = time.Date(2026, 1, 2, 0, 1, 0, 0, time.UTC)
+	e.Evaluate(context.Background())
+	now = now.Add(time.Minute)
+	cost = 23
+	e.Evaluate(context.Background())
+	if len(n.alerts) != 2 || n.alerts[1].Value != 11 {
+		t.Fatalf("expected fresh alert for the new day at $11, got %+v", n.alerts)
+	}
+}
+
+func TestEvaluator_RetriesFailedDelivery(t *testing.T) {
+	cost := 0.0
+	n := &recordingNotifier{err: errors.New("down")}
+	e := NewEvaluator([]Rule{{Name: "spend", Metric: MetricDailySpend, Above: ptr(1)}},
+		func() Snapshot { return Snapshot{Cost: cost} }, n, time.Hour)
+	cost = 5
+
+	if _, err := e.Evaluate(context.Background()); err == nil {
+		t.Fatal("expected delivery error")
+	}
+	e.Evaluate(context.Background())
+	if len(n.alerts) != 2 {
+		t.Errorf("expected failed alert to be retried, got %d attempts", len(n.alerts))
+	}
+}
+
+func TestWebhook_SlackFormat(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL, true).Notify(context.Background(), Alert{Rule: "r", Message: "hello"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body["text"] != "hello" || len(body) != 1 {
+		t.Errorf("expected slack payload, got %v", body)
+	}
+}
@@ -0,0 +1,54 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook posts alerts as JSON to a URL. With Slack set, the body is a Slack
+// incoming-webhook message ({"text": ...}); otherwise it is the Alert itself.
+type Webhook struct {
+	URL    string
+	Slack  bool
+	Client *http.Client
+}
+
+// NewWebhook creates a webhook notifier with a 10s request timeout.
+func NewWebhook(url string, slack bool) *Webhook {
+	return &Webhook{URL: url, Slack: slack, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify delivers a single alert.
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	var payload any = a
+	if w.Slack {
+		payload = map[string]string{"text": a.Message}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("webhook error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
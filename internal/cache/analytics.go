@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// hitAgeBounds are the upper bounds of the hit-age histogram buckets; the
+// last bucket is open-ended.
+var hitAgeBounds = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+var hitAgeLabels = []string{"<1m", "<5m", "<15m", "<1h", "<6h", "<24h", ">=24h"}
+
+// keyHitBounds are the inclusive upper bounds of the per-key hit count
+// buckets; the last bucket is open-ended.
+var keyHitBounds = []int{0, 1, 4, 9}
+
+var keyHitLabels = []string{"0", "1", "2-4", "5-9", "10+"}
+
+// Bucket is one histogram bucket.
+type Bucket struct {
+	Range string `json:"range"`
+	Count uint64 `json:"count"`
+}
+
+// Analytics describes how the exact cache is being used, to help size TTL
+// and max_entries. KeyHits covers live entries; HitAge and the retirement
+// counters are cumulative since startup.
+type Analytics struct {
+	TTL        string `json:"ttl"`
+	Keys       int    `json:"keys"`
+	MaxEntries int    `json:"max_entries"`
+	// KeysReused counts live keys hit at least twice.
+	KeysReused int      `json:"keys_reused"`
+	KeyHits    []Bucket `json:"key_hits"`
+	// HitAge is the age of entries at the moment they were hit. Hits
+	// clustered well below the TTL suggest it can be shortened.
+	HitAge []Bucket `json:"hit_age"`
+	// Expired and Evicted count entries removed by TTL and by the LRU at
+	// capacity. The Unused variants count those that were never hit;
+	// many evicted-unused entries suggest max_entries is too small.
+	Expired       uint64 `json:"expired"`
+	ExpiredUnused uint64 `json:"expired_unused"`
+	Evicted       uint64 `json:"evicted"`
+	EvictedUnused uint64 `json:"evicted_unused"`
+	// Stale counts entries dropped because their system_fingerprint no
+	// longer matches the model's live one.
+	Stale uint64 `json:"stale"`
+
+	AdaptiveTTL *AdaptiveAnalytics `json:"adaptive_ttl,omitempty"`
+}
+
+// AdaptiveAnalytics shows what the adaptive TTL policy is doing: how many
+// hits extended an entry's lifetime, and how long live entries will last.
+type AdaptiveAnalytics struct {
+	UnhitTTL   string `json:"unhit_ttl"`
+	MaxTTL     string `json:"max_ttl"`
+	Extensions uint64 `json:"extensions"`
+	// Lifetimes counts live entries by their current lifetime, shortest
+	// first.
+	Lifetimes []Bucket `json:"lifetimes"`
+}
+
+// cacheAnalytics holds the cumulative counters. Guarded by ExactCache.mu.
+type cacheAnalytics struct {
+	hitAge        [7]uint64
+	expired       uint64
+	expiredUnused uint64
+	evicted       uint64
+	evictedUnused uint64
+	stale         uint64
+	extended      uint64
+}
+
+// recordHit records a hit on le. Must be called under lock.
+func (a *cacheAnalytics) recordHit(le *lruEntry, now time.Time) {
+	le.hits++
+	age := now.Sub(le.storedAt)
+	i := 0
+	for i < len(hitAgeBounds) && age >= hitAgeBounds[i] {
+		i++
+	}
+	a.hitAge[i]++
+}
+
+// recordExpired records le being dropped after its TTL. Must be called under lock.
+func (a *cacheAnalytics) recordExpired(le *lruEntry) {
+	a.expired++
+	if le.hits == 0 {
+		a.expiredUnused++
+	}
+}
+
+// recordEvicted records le being dropped by the LRU. Must be called under lock.
+func (a *cacheAnalytics) recordEvicted(le *lruEntry) {
+	a.evicted++
+	if le.hits == 0 {
+		a.evictedUnused++
+	}
+}
+
+// Analytics returns key reuse and hit-age statistics. It walks every live
+// entry, so it is meant for admin endpoints rather than hot paths.
+func (c *ExactCache) Analytics() Analytics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keyHits := make([]uint64, len(keyHitLabels))
+	reused := 0
+	lifetimes := make(map[time.Duration]uint64)
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		hits := e.Value.(*lruEntry).hits
+		if c.adaptive.enabled() {
+			lifetimes[c.adaptive.lifetime(c.ttl, hits)]++
+		}
+		if hits >= 2 {
+			reused++
+		}
+		i := 0
+		for i < len(keyHitBounds) && hits > keyHitBounds[i] {
+			i++
+		}
+		keyHits[i]++
+	}
+
+	a := Analytics{
+		TTL:           c.ttl.String(),
+		Keys:          c.order.Len(),
+		MaxEntries:    c.maxEntries,
+		KeysReused:    reused,
+		Expired:       c.analytics.expired,
+		ExpiredUnused: c.analytics.expiredUnused,
+		Evicted:       c.analytics.evicted,
+		EvictedUnused: c.analytics.evictedUnused,
+		Stale:         c.analytics.stale,
+	}
+	for i, n := range keyHits {
+		a.KeyHits = append(a.KeyHits, Bucket{Range: keyHitLabels[i], Count: n})
+	}
+	for i, n := range c.analytics.hitAge {
+		a.HitAge = append(a.HitAge, Bucket{Range: hitAgeLabels[i], Count: n})
+	}
+	if c.adaptive.enabled() {
+		a.AdaptiveTTL = &AdaptiveAnalytics{
+			UnhitTTL:   c.adaptive.Unhit.String(),
+			MaxTTL:     c.adaptive.Max.String(),
+			Extensions: c.analytics.extended,
+			Lifetimes:  []Bucket{},
+		}
+		for _, d := range slices.Sorted(maps.Keys(lifetimes)) {
+			a.AdaptiveTTL.Lifetimes = append(a.AdaptiveTTL.Lifetimes, Bucket{Range: d.String(), Count: lifetimes[d]})
+		}
+	}
+	return a
+}
@@ -6,10 +6,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/stats"
 )
 
 var keyBufPool = sync.Pool{
@@ -22,19 +24,52 @@ type Entry struct {
 	ExpiresAt time.Time
 }
 
-// lruEntry wraps an Entry with its cache key for O(1) eviction.
+// lruEntry wraps an Entry with its cache key for O(1) eviction. The
+// response itself lives in the response store under hash; entry holds only
+// its model and system fingerprint.
 type lruEntry struct {
 	key   string
 	entry *Entry
+	hash  string
+
+	storedAt time.Time
+	hits     int
 }
 
-// ExactCache is an in-memory LRU cache keyed by SHA-256 of (model, messages, temperature, top_p).
+// ExactCache is an in-memory LRU cache keyed by SHA-256 of (model, messages, temperature, top_p, seed).
 type ExactCache struct {
 	mu         sync.Mutex
 	items      map[string]*list.Element
 	order      *list.List // front = most recently used, back = least recently used
 	ttl        time.Duration
 	maxEntries int
+	normalize  bool
+	volatile   *Volatile
+	sampling   SamplingPolicy
+	filter     *StoreFilter
+	keyFormat  string
+	prints     *Fingerprints
+	adaptive   AdaptiveTTL
+	store      *ResponseStore
+	feedback   *Feedback
+
+	hits      stats.Counter
+	misses    stats.Counter
+	analytics cacheAnalytics
+	now       func() time.Time
+}
+
+// Stats is a snapshot of exact-cache occupancy and lookup counters.
+type Stats struct {
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+
+	// Compressed responses, and the bytes compression saves across them,
+	// in the cache's response store.
+	Compressed int   `json:"compressed,omitempty"`
+	SavedBytes int64 `json:"saved_bytes,omitempty"`
 }
 
 // New creates a new ExactCache with the given TTL and max entry count.
@@ -44,24 +79,147 @@ func New(ttl time.Duration, maxEntries int) *ExactCache {
 		order:      list.New(),
 		ttl:        ttl,
 		maxEntries: maxEntries,
+		store:      NewResponseStore(),
+		now:        time.Now,
 	}
 }
 
-// cacheKey is the canonical structure hashed for the cache key.
+// cacheKey is the canonical structure hashed for KeyFormatJSON keys.
+// Seed is omitted when unset so unseeded keys are unchanged.
 type cacheKey struct {
 	Model       string          `json:"model"`
 	Messages    []model.Message `json:"messages"`
 	Temperature *float64        `json:"temperature,omitempty"`
 	TopP        *float64        `json:"top_p,omitempty"`
+	Seed        *int            `json:"seed,omitempty"`
+
+	MaxCompletionTokens *int `json:"max_completion_tokens,omitempty"`
+	N                   *int `json:"n,omitempty"`
+}
+
+// SetNormalize enables prompt normalization (see NormalizeContent) before
+// hashing, so trivially different whitespace shares entries. Off by default
+// for byte-exact semantics. Must be called before the cache is used.
+func (c *ExactCache) SetNormalize(enabled bool) {
+	c.normalize = enabled
+}
+
+// SetVolatile configures volatile-content handling. nil disables it.
+// Must be called before the cache is used.
+func (c *ExactCache) SetVolatile(v *Volatile) {
+	c.volatile = v
+}
+
+// SetSamplingPolicy configures temperature tolerance and top_p keying.
+// Must be called before the cache is used.
+func (c *ExactCache) SetSamplingPolicy(p SamplingPolicy) {
+	c.sampling = p
+}
+
+// SetStoreFilter configures which responses are rejected by Put/PutByKey.
+// nil stores everything. Must be called before the cache is used.
+func (c *ExactCache) SetStoreFilter(f *StoreFilter) {
+	c.filter = f
+}
+
+// SetKeyFormat selects the key format, KeyFormatHash (default) or
+// KeyFormatJSON. Must be called before the cache is used.
+func (c *ExactCache) SetKeyFormat(format string) {
+	c.keyFormat = format
+}
+
+// SetFingerprints makes lookups evict entries whose system_fingerprint is
+// stale according to f. Must be called before the cache is used.
+func (c *ExactCache) SetFingerprints(f *Fingerprints) {
+	c.prints = f
+}
+
+// SetFeedback makes lookups evict entries whose answer end users have
+// rated down according to f. Must be called before the cache is used.
+func (c *ExactCache) SetFeedback(f *Feedback) {
+	c.feedback = f
+}
+
+// SetResponseStore keeps responses in st, which other caches may share,
+// instead of a store of the cache's own. Must be called before the cache is
+// used.
+func (c *ExactCache) SetResponseStore(st *ResponseStore) {
+	c.store = st
+}
+
+// SetCompression stores responses whose JSON encoding is at least minBytes
+// gzipped, trading CPU on every store and hit for memory. 0 disables it.
+// It configures the cache's response store, so it must be called after
+// SetResponseStore and before the cache is used.
+func (c *ExactCache) SetCompression(minBytes int) {
+	c.store.SetCompression(minBytes)
+}
+
+// SetAdaptiveTTL replaces the fixed TTL with p, the TTL passed to New
+// becoming the lifetime of entries hit once. Must be called before the cache
+// is used.
+func (c *ExactCache) SetAdaptiveTTL(p AdaptiveTTL) {
+	if p.Unhit == 0 {
+		p.Unhit = c.ttl / 4
+	}
+	if p.Max == 0 {
+		p.Max = 8 * c.ttl
+	}
+	c.adaptive = p
+}
+
+// SamplingPolicy returns the configured sampling policy.
+func (c *ExactCache) SamplingPolicy() SamplingPolicy {
+	return c.sampling
+}
+
+// Bypass reports whether req contains volatile content and must not be cached.
+func (c *ExactCache) Bypass(req *model.ChatRequest) bool {
+	return c.volatile.Bypass(req)
 }
 
-// KeyFor computes a SHA-256 hex string from the cache-relevant fields of a request.
+// Key computes the cache key for req, honoring the cache's normalization,
+// volatile-stripping and key format settings.
+func (c *ExactCache) Key(req *model.ChatRequest) string {
+	if !c.normalize && c.volatile == nil && !c.sampling.IgnoreTopP {
+		return keyFor(req, req.Messages, c.keyFormat)
+	}
+	if c.sampling.IgnoreTopP && req.TopP != nil {
+		r := *req
+		r.TopP = nil
+		req = &r
+	}
+	msgs := c.volatile.Strip(req.Messages)
+	if c.normalize {
+		msgs = normalizeMessages(msgs)
+	}
+	return keyFor(req, msgs, c.keyFormat)
+}
+
+// KeyFor computes a SHA-256 hex string from the cache-relevant fields of a
+// request, in the default KeyFormatHash format.
 func KeyFor(req *model.ChatRequest) string {
+	return hashKey(req, req.Messages)
+}
+
+func keyFor(req *model.ChatRequest, messages []model.Message, format string) string {
+	if format == KeyFormatJSON {
+		return jsonKey(req, messages)
+	}
+	return hashKey(req, messages)
+}
+
+// jsonKey computes the KeyFormatJSON key.
+func jsonKey(req *model.ChatRequest, messages []model.Message) string {
 	k := cacheKey{
 		Model:       req.Model,
-		Messages:    req.Messages,
+		Messages:    messages,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
+		Seed:        req.Seed,
+
+		MaxCompletionTokens: req.MaxCompletionTokens,
+		N:                   choices(req),
 	}
 	buf := keyBufPool.Get().(*bytes.Buffer)
 	buf.Reset()
@@ -73,54 +231,160 @@ func KeyFor(req *model.ChatRequest) string {
 
 // Get looks up a cached response. Returns nil if not found or expired.
 func (c *ExactCache) Get(req *model.ChatRequest) (*Entry, bool) {
-	return c.GetByKey(KeyFor(req))
+	return c.GetByKey(c.Key(req))
 }
 
 // GetByKey looks up a cached response by precomputed key. Returns nil if not found or expired.
 func (c *ExactCache) GetByKey(key string) (*Entry, bool) {
+	entry, miss := c.Lookup(key)
+	return entry, miss == ""
+}
+
+// Why an exact-cache lookup missed.
+const (
+	MissNotCached = "not_cached"
+	MissExpired   = "ttl_expired"
+	MissStale     = "stale_fingerprint"
+	MissCorrupt   = "corrupt_entry"
+	MissFeedback  = "negative_feedback"
+)
+
+// Lookup is GetByKey, but says why it missed: one of the Miss* reasons, or
+// "" on a hit.
+func (c *ExactCache) Lookup(key string) (*Entry, string) {
 	c.mu.Lock()
 	elem, ok := c.items[key]
 	if !ok {
 		c.mu.Unlock()
-		return nil, false
+		c.misses.Add(1)
+		return nil, MissNotCached
 	}
 
 	le := elem.Value.(*lruEntry)
-	if time.Now().After(le.entry.ExpiresAt) {
-		// Expired — remove under write lock.
-		c.order.Remove(elem)
-		delete(c.items, key)
+	now := c.now()
+	if expired, stale := now.After(le.entry.ExpiresAt), c.prints.Stale(le.entry.Response); expired || stale {
+		// Expired, or produced by an outdated backend — remove under write lock.
+		miss := MissExpired
+		if expired {
+			c.analytics.recordExpired(le)
+		} else {
+			c.analytics.stale++
+			miss = MissStale
+		}
+		c.remove(elem)
 		c.mu.Unlock()
-		return nil, false
+		c.misses.Add(1)
+		return nil, miss
 	}
 
 	// Move to front (most recently used).
 	c.order.MoveToFront(elem)
-	entry := le.entry
+	c.analytics.recordHit(le, now)
+	if c.adaptive.enabled() {
+		if exp := le.storedAt.Add(c.adaptive.lifetime(c.ttl, le.hits)); exp.After(le.entry.ExpiresAt) {
+			// Copy, as earlier hits may still be reading the old entry.
+			e := *le.entry
+			e.ExpiresAt = exp
+			le.entry = &e
+			c.analytics.extended++
+		}
+	}
+	entry, hash := le.entry, le.hash
 	c.mu.Unlock()
-	return entry, true
+
+	resp, err := c.store.Get(hash)
+	if err != nil {
+		c.misses.Add(1)
+		if errors.Is(err, errNotStored) {
+			// Evicted, releasing the response, after the lock was dropped.
+			return nil, MissNotCached
+		}
+		return nil, MissCorrupt
+	}
+	if c.feedback.Excluded(resp) {
+		c.mu.Lock()
+		if elem, ok := c.items[key]; ok && elem.Value.(*lruEntry).hash == hash {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, MissFeedback
+	}
+	c.hits.Add(1)
+	return &Entry{Response: resp, ExpiresAt: entry.ExpiresAt}, ""
+}
+
+// Peek returns the live entry stored under key without counting a lookup,
+// refreshing its recency or extending its lifetime.
+func (c *ExactCache) Peek(key string) (*Entry, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	le := elem.Value.(*lruEntry)
+	if c.now().After(le.entry.ExpiresAt) || c.prints.Stale(le.entry.Response) {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry, hash := le.entry, le.hash
+	c.mu.Unlock()
+
+	resp, err := c.store.Get(hash)
+	if err != nil || c.feedback.Excluded(resp) {
+		return nil, false
+	}
+	return &Entry{Response: resp, ExpiresAt: entry.ExpiresAt}, true
 }
 
 // Put stores a response in the cache. If at capacity, the least recently used entry is evicted.
 func (c *ExactCache) Put(req *model.ChatRequest, resp *model.ChatResponse) {
-	c.PutByKey(KeyFor(req), resp)
+	c.PutByKey(c.Key(req), resp)
 }
 
 // PutByKey stores a response using a precomputed key.
+// Responses rejected by the store filter are silently dropped.
 func (c *ExactCache) PutByKey(key string, resp *model.ChatResponse) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.putAt(key, resp, c.now())
+}
 
+// putAt stores resp as if it had been cached at storedAt, so its TTL runs
+// from then. It reports whether the entry was stored.
+func (c *ExactCache) putAt(key string, resp *model.ChatResponse, storedAt time.Time) bool {
+	if !c.filter.Allows(resp) {
+		return false
+	}
+	ttl := c.ttl
+	if c.adaptive.enabled() {
+		ttl = c.adaptive.lifetime(c.ttl, 0)
+	}
 	entry := &Entry{
-		Response:  resp,
-		ExpiresAt: time.Now().Add(c.ttl),
+		// Fingerprint checks need these without fetching the response.
+		Response:  &model.ChatResponse{Model: resp.Model, SystemFingerprint: resp.SystemFingerprint},
+		ExpiresAt: storedAt.Add(ttl),
 	}
+	if !entry.ExpiresAt.After(c.now()) {
+		return false
+	}
+	hash, err := c.store.Put(resp)
+	if err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if elem, ok := c.items[key]; ok {
 		// Update existing entry, move to front.
-		elem.Value.(*lruEntry).entry = entry
+		le := elem.Value.(*lruEntry)
+		c.store.Release(le.hash)
+		le.entry = entry
+		le.hash = hash
+		le.storedAt = storedAt
+		le.hits = 0
 		c.order.MoveToFront(elem)
-		return
+		return true
 	}
 
 	// Evict LRU if at capacity.
@@ -128,15 +392,28 @@ func (c *ExactCache) PutByKey(key string, resp *model.ChatResponse) {
 		c.evictLRU()
 	}
 
-	le := &lruEntry{key: key, entry: entry}
+	le := &lruEntry{key: key, entry: entry, hash: hash, storedAt: storedAt}
 	elem := c.order.PushFront(le)
 	c.items[key] = elem
+	return true
+}
+
+// remove unlinks elem and releases its response. Must be called under
+// write lock.
+func (c *ExactCache) remove(elem *list.Element) {
+	le := elem.Value.(*lruEntry)
+	c.store.Release(le.hash)
+	c.order.Remove(elem)
+	delete(c.items, le.key)
 }
 
 // Clear removes all entries from the cache.
 func (c *ExactCache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	for _, elem := range c.items {
+		c.store.Release(elem.Value.(*lruEntry).hash)
+	}
 	c.items = make(map[string]*list.Element)
 	c.order.Init()
 }
@@ -148,13 +425,28 @@ func (c *ExactCache) Len() int {
 	return c.order.Len()
 }
 
+// Stats returns current occupancy and hit/miss counters.
+func (c *ExactCache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+	st := c.store.Stats()
+	return Stats{
+		Entries:    entries,
+		MaxEntries: c.maxEntries,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Compressed: st.Compressed,
+		SavedBytes: st.SavedBytes,
+	}
+}
+
 // evictLRU removes the least recently used entry. Must be called under write lock.
 func (c *ExactCache) evictLRU() {
 	back := c.order.Back()
 	if back == nil {
 		return
 	}
-	le := back.Value.(*lruEntry)
-	c.order.Remove(back)
-	delete(c.items, le.key)
+	c.analytics.recordEvicted(back.Value.(*lruEntry))
+	c.remove(back)
 }
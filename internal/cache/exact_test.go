@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -72,6 +73,26 @@ func TestTTLExpiration(t *testing.T) {
 	}
 }
 
+func TestLookup_MissReasons(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := New(time.Minute, 100)
+	c.now = func() time.Time { return now }
+	req := makeReq("hello", ptrFloat(0), false)
+
+	if _, reason := c.Lookup(c.Key(req)); reason != MissNotCached {
+		t.Errorf("expected %q before Put, got %q", MissNotCached, reason)
+	}
+	c.Put(req, makeResp("test-lookup"))
+	if entry, reason := c.Lookup(c.Key(req)); entry == nil || reason != "" {
+		t.Fatalf("expected a hit, got reason %q", reason)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, reason := c.Lookup(c.Key(req)); reason != MissExpired {
+		t.Errorf("expected %q after TTL, got %q", MissExpired, reason)
+	}
+}
+
 func TestKeyExcludesStreamFlag(t *testing.T) {
 	c := New(time.Hour, 100)
 	req := makeReq("hello", ptrFloat(0), false)
@@ -157,3 +178,626 @@ func TestCacheMiss(t *testing.T) {
 		t.Fatal("expected cache miss for uncached request")
 	}
 }
+
+func TestNormalizeContent(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello world", "hello world"},
+		{"  hello   world  ", "hello world"},
+		{"hello\n\n\tworld", "hello world"},
+		{"hello\u00a0world", "hello world"},
+		{"hel\u200blo\ufeff", "hello"},
+		{"héllo  wörld", "héllo wörld"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeContent(tt.in); got != tt.want {
+			t.Errorf("NormalizeContent(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExactCache_Normalize(t *testing.T) {
+	a := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "What is  Go?"}}}
+	b := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: " What is Go?\n"}}}
+
+	c := New(time.Hour, 100)
+	if c.Key(a) == c.Key(b) {
+		t.Error("expected distinct keys without normalization")
+	}
+
+	c.SetNormalize(true)
+	if c.Key(a) != c.Key(b) {
+		t.Error("expected equal keys with normalization")
+	}
+	if b.Messages[0].Content != " What is Go?\n" {
+		t.Error("normalization must not mutate the request")
+	}
+
+	c.Put(a, &model.ChatResponse{ID: "norm"})
+	entry, ok := c.Get(b)
+	if !ok || entry.Response.ID != "norm" {
+		t.Error("expected normalized request to hit")
+	}
+}
+
+func TestVolatile_Bypass(t *testing.T) {
+	v, err := NewVolatile([]string{`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`}, VolatileBypass)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := New(time.Hour, 100)
+	c.SetVolatile(v)
+
+	if !c.Bypass(makeReq("now is 2025-01-02T03:04:05", nil, false)) {
+		t.Error("expected request with timestamp to bypass")
+	}
+	if c.Bypass(makeReq("hello", nil, false)) {
+		t.Error("expected plain request not to bypass")
+	}
+}
+
+func TestVolatile_Strip(t *testing.T) {
+	v, err := NewVolatile([]string{`req-[0-9a-f]{8}`}, VolatileStrip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := New(time.Hour, 100)
+	c.SetVolatile(v)
+
+	a := makeReq("summarize (req-deadbeef)", nil, false)
+	b := makeReq("summarize (req-0badf00d)", nil, false)
+	if c.Bypass(a) {
+		t.Error("strip action must not bypass")
+	}
+	if c.Key(a) != c.Key(b) {
+		t.Error("expected keys to match after stripping volatile spans")
+	}
+	if a.Messages[0].Content != "summarize (req-deadbeef)" {
+		t.Error("strip must not mutate the request")
+	}
+}
+
+func TestNewVolatile_Errors(t *testing.T) {
+	if _, err := NewVolatile([]string{`(`}, VolatileBypass); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := NewVolatile(nil, "drop"); err == nil {
+		t.Error("expected error for unknown action")
+	}
+}
+
+func TestStoreFilter(t *testing.T) {
+	f := NewStoreFilter([]string{"length", "content_filter"}, false)
+	c := New(time.Hour, 100)
+	c.SetStoreFilter(f)
+
+	ok := makeResp("ok")
+	truncated := makeResp("truncated")
+	truncated.Choices[0].FinishReason = "length"
+	empty := makeResp("empty")
+	empty.Choices[0].Message.Content = ""
+
+	c.Put(makeReq("a", nil, false), ok)
+	c.Put(makeReq("b", nil, false), truncated)
+	c.Put(makeReq("c", nil, false), empty)
+
+	if _, hit := c.Get(makeReq("a", nil, false)); !hit {
+		t.Error("expected complete response to be stored")
+	}
+	if _, hit := c.Get(makeReq("b", nil, false)); hit {
+		t.Error("expected finish_reason length to be rejected")
+	}
+	if _, hit := c.Get(makeReq("c", nil, false)); hit {
+		t.Error("expected empty content to be rejected")
+	}
+
+	if !NewStoreFilter(nil, true).Allows(empty) {
+		t.Error("expected empty content to be allowed with allowEmpty")
+	}
+}
+
+func TestStoreFilter_MaxBytes(t *testing.T) {
+	f := NewStoreFilter(nil, false)
+	f.SetMaxBytes(10)
+
+	fits := makeResp("fits")
+	fits.Choices[0].Message.Content = "0123456789"
+	reasoning := makeResp("reasoning")
+	reasoning.Choices[0].Message.Content = "short"
+	reasoning.Choices[0].Message.ReasoningContent = "thinking..."
+
+	if !f.Allows(fits) {
+		t.Error("expected response at the limit to be allowed")
+	}
+	if f.Allows(reasoning) {
+		t.Error("expected reasoning to count toward the limit")
+	}
+	if got := f.Oversize(); got != 0 {
+		t.Errorf("Oversize = %d before Count, want 0", got)
+	}
+	f.Count(fits)
+	f.Count(reasoning)
+	if got := f.Oversize(); got != 1 {
+		t.Errorf("Oversize = %d, want 1", got)
+	}
+}
+
+func TestStoreFilter_NoStore(t *testing.T) {
+	f := NewStoreFilter(nil, false)
+	resp := makeResp("marked")
+	resp.NoStore = true
+	if f.Allows(resp) {
+		t.Error("expected a response marked no-store to be rejected")
+	}
+	f.Count(resp)
+	if got := f.NoStore(); got != 1 {
+		t.Errorf("NoStore = %d, want 1", got)
+	}
+	var nilFilter *StoreFilter
+	if nilFilter.Allows(resp) {
+		t.Error("expected a nil filter to reject a response marked no-store")
+	}
+
+	c := New(time.Hour, 100)
+	req := makeReq("hello", ptrFloat(0), false)
+	c.Put(req, resp)
+	if _, ok := c.Get(req); ok {
+		t.Error("expected a response marked no-store not to be cached")
+	}
+}
+
+func TestExactCache_Stats(t *testing.T) {
+	c := New(time.Hour, 100)
+	req := makeReq("hello", ptrFloat(0), false)
+
+	c.Get(req)
+	c.Put(req, makeResp("test-1"))
+	c.Get(req)
+	c.Get(req)
+
+	s := c.Stats()
+	if s.Entries != 1 || s.MaxEntries != 100 {
+		t.Errorf("unexpected occupancy: %+v", s)
+	}
+	if s.Hits != 2 || s.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %+v", s)
+	}
+}
+
+func TestExactCache_Analytics(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := New(time.Hour, 2)
+	c.now = func() time.Time { return now }
+
+	a, b, d := makeReq("a", ptrFloat(0), false), makeReq("b", ptrFloat(0), false), makeReq("d", ptrFloat(0), false)
+	c.Put(a, makeResp("a"))
+	c.Put(b, makeResp("b"))
+
+	now = now.Add(30 * time.Second)
+	c.Get(a)
+	now = now.Add(10 * time.Minute)
+	c.Get(a)
+	c.Get(a)
+
+	// b was never hit and is least recently used: evicted unused.
+	c.Put(d, makeResp("d"))
+
+	// d expires unused.
+	now = now.Add(2 * time.Hour)
+	c.Get(d)
+
+	got := c.Analytics()
+	if got.Keys != 1 || got.KeysReused != 1 {
+		t.Errorf("expected 1 live key reused, got %+v", got)
+	}
+	if got.KeyHits[2].Range != "2-4" || got.KeyHits[2].Count != 1 {
+		t.Errorf("expected one key in the 2-4 hit bucket, got %+v", got.KeyHits)
+	}
+	if got.HitAge[0].Count != 1 || got.HitAge[2].Count != 2 {
+		t.Errorf("expected hit ages 1x<1m and 2x<15m, got %+v", got.HitAge)
+	}
+	if got.Evicted != 1 || got.EvictedUnused != 1 || got.Expired != 1 || got.ExpiredUnused != 1 {
+		t.Errorf("unexpected retirement counters: %+v", got)
+	}
+}
+
+func TestKeyFormatHash_Unambiguous(t *testing.T) {
+	pairs := [][2]*model.ChatRequest{
+		{
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "ab"}, {Role: "user", Content: "c"}}},
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "a"}, {Role: "user", Content: "bc"}}},
+		},
+		{
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "x"}}},
+			{Model: "m", Messages: []model.Message{{Role: "use", Content: "rx"}}},
+		},
+		{
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "x"}}},
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "x"}}, Temperature: ptrFloat(0)},
+		},
+		{
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "x"}}, Temperature: ptrFloat(1)},
+			{Model: "m", Messages: []model.Message{{Role: "user", Content: "x"}}, TopP: ptrFloat(1)},
+		},
+	}
+	for i, p := range pairs {
+		if KeyFor(p[0]) == KeyFor(p[1]) {
+			t.Errorf("pair %d: distinct requests share a key", i)
+		}
+	}
+}
+
+func TestKeyFormatJSON_Compatible(t *testing.T) {
+	req := makeReq("hello", ptrFloat(0.5), false)
+	// Key produced by the JSON format before key_format existed.
+	const want = "56d7e7b0653bff1e65edecf49b346ecd69ae0fd8e197b567494638db9b23d5cd"
+
+	c := New(time.Hour, 100)
+	c.SetKeyFormat(KeyFormatJSON)
+	if got := c.Key(req); got != want {
+		t.Errorf("v1 key = %s, want %s", got, want)
+	}
+	if KeyFor(req) == want {
+		t.Error("v2 key should differ from v1")
+	}
+
+	c.Put(req, makeResp("a"))
+	if _, ok := c.Get(makeReq("hello", ptrFloat(0.5), true)); !ok {
+		t.Error("v1 key should exclude the stream flag")
+	}
+}
+
+func TestKeyFormatHash_Compatible(t *testing.T) {
+	// Keys produced by the hash format when it was introduced.
+	seed := 42
+	seeded := makeReq("hello", ptrFloat(0.5), false)
+	seeded.Seed = &seed
+	for want, req := range map[string]*model.ChatRequest{
+		"80c3af05044072bdc84a3eb0c15261f354e7a184a07e490b631ac12eb7ab7d69": makeReq("hello", ptrFloat(0.5), false),
+		"54673a83a3541e08b994c6edf8778c6f8552fc57e8682aa3f6d079494423be4f": seeded,
+	} {
+		if got := KeyFor(req); got != want {
+			t.Errorf("v2 key = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestKeyFormatHash_LongContent(t *testing.T) {
+	long := string(make([]byte, 3*keyHasherFlush+7))
+	a := makeReq(long, nil, false)
+	b := makeReq(long+"x", nil, false)
+	if KeyFor(a) != KeyFor(makeReq(long, nil, false)) {
+		t.Error("key not deterministic for long content")
+	}
+	if KeyFor(a) == KeyFor(b) {
+		t.Error("long contents differing in the last byte share a key")
+	}
+}
+
+func benchmarkKey(b *testing.B, format string) {
+	req := &model.ChatRequest{
+		Model: "gpt-4o",
+		Messages: []model.Message{
+			{Role: "system", Content: "You are a helpful assistant that answers questions about geography concisely."},
+			{Role: "user", Content: "What is the capital of France, and what river runs through it?"},
+			{Role: "assistant", Content: "Paris; the Seine runs through it."},
+			{Role: "user", Content: "And the capital of Germany?"},
+		},
+		Temperature: ptrFloat(0.2),
+	}
+	c := New(time.Hour, 100)
+	c.SetKeyFormat(format)
+	b.ReportAllocs()
+	for b.Loop() {
+		c.Key(req)
+	}
+}
+
+func BenchmarkKey_JSON(b *testing.B) { benchmarkKey(b, KeyFormatJSON) }
+func BenchmarkKey_Hash(b *testing.B) { benchmarkKey(b, KeyFormatHash) }
+
+func TestKeyIncludesMaxCompletionTokens(t *testing.T) {
+	n := 50
+	for _, format := range []string{KeyFormatJSON, KeyFormatHash} {
+		c := New(time.Hour, 100)
+		c.SetKeyFormat(format)
+		a := makeReq("hello", nil, false)
+		b := makeReq("hello", nil, false)
+		b.MaxCompletionTokens = &n
+		if c.Key(a) == c.Key(b) {
+			t.Errorf("%s: max_completion_tokens not in key", format)
+		}
+	}
+}
+
+func TestExactCache_FingerprintInvalidation(t *testing.T) {
+	var changes []string
+	prints := NewFingerprints(func(model, old, new string) {
+		changes = append(changes, model+":"+old+"->"+new)
+	})
+	c := New(time.Hour, 100)
+	c.SetFingerprints(prints)
+
+	req := makeReq("hello", ptrFloat(0), false)
+	resp := makeResp("fp-1")
+	resp.SystemFingerprint = "fp_a"
+	prints.Observe(resp)
+	c.Put(req, resp)
+
+	if _, ok := c.Get(req); !ok {
+		t.Fatal("expected hit while fingerprint is current")
+	}
+
+	live := makeResp("fp-2")
+	live.SystemFingerprint = "fp_b"
+	prints.Observe(live)
+	if len(changes) != 1 || changes[0] != "gpt-4o:fp_a->fp_b" {
+		t.Errorf("changes = %v", changes)
+	}
+
+	if _, ok := c.Get(req); ok {
+		t.Fatal("expected stale entry to miss")
+	}
+	if c.Len() != 0 {
+		t.Errorf("stale entry not removed, len = %d", c.Len())
+	}
+	if got := c.Analytics().Stale; got != 1 {
+		t.Errorf("Stale = %d, want 1", got)
+	}
+
+	// Entries without a fingerprint are never stale.
+	c.Put(req, makeResp("fp-3"))
+	if _, ok := c.Get(req); !ok {
+		t.Error("expected hit for entry without fingerprint")
+	}
+	if got := prints.Latest()["gpt-4o"]; got != "fp_b" {
+		t.Errorf("Latest = %q, want fp_b", got)
+	}
+}
+
+func TestExactCache_Feedback(t *testing.T) {
+	feedback := NewFeedback(2, time.Hour, 100)
+	c := New(time.Hour, 100)
+	c.SetFeedback(feedback)
+
+	req := makeReq("hello", ptrFloat(0), false)
+	c.Put(req, makeResp("fp-1"))
+
+	// Three requests served the same answer, under different IDs.
+	feedback.Served("r1", "key", makeResp("fp-1"))
+	feedback.Served("r2", "key", makeResp("fp-2"))
+	feedback.Served("r3", "other", makeResp("fp-2"))
+	if _, err := feedback.Rate("r1", "other", -1); err != ErrUnknownRequest {
+		t.Errorf("expected another API key's request to be unknown, got %v", err)
+	}
+	if _, err := feedback.Rate("r4", "key", -1); err != ErrUnknownRequest {
+		t.Errorf("expected an unserved request to be unknown, got %v", err)
+	}
+
+	// Rating the same request down twice counts once.
+	for range 2 {
+		if excluded, err := feedback.Rate("r1", "key", -1); err != nil || excluded {
+			t.Fatalf("expected one rating not to exclude the answer, got %v, %v", excluded, err)
+		}
+	}
+	// So does one API key rating several requests down.
+	if excluded, err := feedback.Rate("r2", "key", -1); err != nil || excluded {
+		t.Fatalf("expected one API key's ratings not to exclude the answer, got %v, %v", excluded, err)
+	}
+	if _, miss := c.Lookup(c.Key(req)); miss != "" {
+		t.Fatalf("expected a hit below the threshold, got %s", miss)
+	}
+	if excluded, err := feedback.Rate("r3", "other", -1); err != nil || !excluded {
+		t.Fatalf("expected a second API key's rating to exclude the answer, got %v, %v", excluded, err)
+	}
+	if _, miss := c.Lookup(c.Key(req)); miss != MissFeedback {
+		t.Errorf("expected %s, got %q", MissFeedback, miss)
+	}
+	if c.Len() != 0 {
+		t.Errorf("excluded entry not removed, len = %d", c.Len())
+	}
+
+	// A different answer for the same request is served again.
+	other := makeResp("fp-3")
+	other.Choices[0].Message.Content = "Hi there!"
+	c.Put(req, other)
+	if _, miss := c.Lookup(c.Key(req)); miss != "" {
+		t.Errorf("expected a new answer to hit, got %s", miss)
+	}
+	if s := feedback.Stats(); s.Ratings != 4 || s.Excluded != 1 || s.Requests != 3 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestFeedback_Window(t *testing.T) {
+	feedback := NewFeedback(1, time.Minute, 2)
+	now := time.Now()
+	feedback.now = func() time.Time { return now }
+
+	feedback.Served("r1", "", makeResp("fp-1"))
+	now = now.Add(2 * time.Minute)
+	if _, err := feedback.Rate("r1", "", -1); err != ErrUnknownRequest {
+		t.Errorf("expected a request past the window to be unknown, got %v", err)
+	}
+
+	for _, id := range []string{"r2", "r3", "r4"} {
+		feedback.Served(id, "", makeResp(id))
+	}
+	if _, err := feedback.Rate("r2", "", -1); err != ErrUnknownRequest {
+		t.Errorf("expected the oldest request beyond max_requests to be forgotten, got %v", err)
+	}
+	if excluded, err := feedback.Rate("r4", "", -1); err != nil || !excluded {
+		t.Errorf("expected r4 to be rateable, got %v, %v", excluded, err)
+	}
+}
+
+func TestFeedback_Bounds(t *testing.T) {
+	feedback := NewFeedback(1, time.Hour, 2)
+
+	// A request ID keeps the answer it was first served.
+	feedback.Served("r1", "key", makeResp("fp-1"))
+	feedback.Served("r1", "other", makeResp("fp-2"))
+	if _, err := feedback.Rate("r1", "other", -1); err != ErrUnknownRequest {
+		t.Errorf("expected the first serve of r1 to be kept, got %v", err)
+	}
+
+	// Only the newest max_requests exclusions are kept.
+	answers := []*model.ChatResponse{makeResp("a"), makeResp("b"), makeResp("c")}
+	for i, resp := range answers {
+		id := strings.Repeat("x", i+1)
+		resp.Choices[0].Message.Content = "answer " + id
+		feedback.Served(id, "key", resp)
+		if excluded, err := feedback.Rate(id, "key", -1); err != nil || !excluded {
+			t.Fatalf("expected %s to be excluded, got %v, %v", id, excluded, err)
+		}
+	}
+	if feedback.Excluded(answers[0]) || !feedback.Excluded(answers[1]) || !feedback.Excluded(answers[2]) {
+		t.Error("expected the oldest exclusion to be dropped")
+	}
+	if s := feedback.Stats(); s.Excluded != 2 {
+		t.Errorf("expected 2 exclusions, got %+v", s)
+	}
+}
+
+func TestExactCache_Compression(t *testing.T) {
+	prints := NewFingerprints(nil)
+	c := New(time.Hour, 2)
+	c.SetCompression(1024)
+	c.SetFingerprints(prints)
+
+	long := makeResp("long")
+	long.SystemFingerprint = "fp_a"
+	long.Choices[0].Message.Content = strings.Repeat("all work and no play ", 200)
+	small := makeResp("small")
+
+	c.Put(makeReq("long", ptrFloat(0), false), long)
+	c.Put(makeReq("small", ptrFloat(0), false), small)
+
+	s := c.Stats()
+	if s.Compressed != 1 || s.SavedBytes <= 0 {
+		t.Fatalf("expected one compressed entry, got %+v", s)
+	}
+	entry, ok := c.Get(makeReq("long", ptrFloat(0), false))
+	if !ok {
+		t.Fatal("expected hit for compressed entry")
+	}
+	if entry.Response.ID != "long" || entry.Response.Choices[0].Message.Content != long.Choices[0].Message.Content {
+		t.Errorf("compressed entry did not round-trip: %+v", entry.Response)
+	}
+	if entry, _ := c.Get(makeReq("small", ptrFloat(0), false)); entry == nil || entry.Response != small {
+		t.Error("small response should be stored as is")
+	}
+
+	// Fingerprint checks still see the compressed entry's fingerprint.
+	prints.Observe(&model.ChatResponse{Model: "gpt-4o", SystemFingerprint: "fp_b"})
+	if _, ok := c.Get(makeReq("long", ptrFloat(0), false)); ok {
+		t.Error("expected stale compressed entry to miss")
+	}
+	if s := c.Stats(); s.Compressed != 0 || s.SavedBytes != 0 {
+		t.Errorf("removed entry still counted: %+v", s)
+	}
+}
+
+func TestResponseStore_SharedCopies(t *testing.T) {
+	responses := NewResponseStore()
+	c := New(time.Hour, 2)
+	c.SetResponseStore(responses)
+
+	resp := makeResp("same")
+	c.Put(makeReq("a", ptrFloat(0), false), resp)
+	c.Put(makeReq("b", ptrFloat(0), false), makeResp("same"))
+	if s := responses.Stats(); s.Responses != 1 || s.References != 2 {
+		t.Fatalf("expected identical responses to share one copy, got %+v", s)
+	}
+	a, _ := c.Get(makeReq("a", ptrFloat(0), false))
+	b, _ := c.Get(makeReq("b", ptrFloat(0), false))
+	if a == nil || b == nil || a.Response != resp || b.Response != resp {
+		t.Fatalf("expected both keys to serve the first copy, got %+v and %+v", a, b)
+	}
+
+	// Overwriting and evicting entries release their copies.
+	c.Put(makeReq("a", ptrFloat(0), false), makeResp("other"))
+	c.Put(makeReq("c", ptrFloat(0), false), makeResp("third"))
+	if s := responses.Stats(); s.Responses != 2 || s.References != 2 {
+		t.Errorf("expected released copies to be dropped, got %+v", s)
+	}
+}
+
+func TestResponseStore_ReleasedDuringLookup(t *testing.T) {
+	responses := NewResponseStore()
+	c := New(time.Hour, 2)
+	c.SetResponseStore(responses)
+
+	req := makeReq("a", ptrFloat(0), false)
+	resp := makeResp("a")
+	c.Put(req, resp)
+	// As if a concurrent eviction released the copy after Lookup dropped
+	// the cache lock.
+	hash, _ := ContentHash(resp)
+	responses.Release(hash)
+	if _, miss := c.Lookup(c.Key(req)); miss != MissNotCached {
+		t.Errorf("expected a plain miss, got %q", miss)
+	}
+}
+
+func TestExactCache_AdaptiveTTL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := New(time.Hour, 10)
+	c.now = func() time.Time { return now }
+	c.SetAdaptiveTTL(AdaptiveTTL{Unhit: 10 * time.Minute, Max: 3 * time.Hour})
+
+	hot, cold := makeReq("hot", ptrFloat(0), false), makeReq("cold", ptrFloat(0), false)
+	c.Put(hot, makeResp("hot"))
+	c.Put(cold, makeResp("cold"))
+
+	now = now.Add(5 * time.Minute)
+	for range 3 {
+		c.Get(hot) // lifetimes 1h, 2h, then 3h (capped from 4h)
+	}
+	a := c.Analytics().AdaptiveTTL
+	if a == nil || a.Extensions != 3 {
+		t.Fatalf("unexpected adaptive analytics: %+v", a)
+	}
+	if len(a.Lifetimes) != 2 || a.Lifetimes[0] != (Bucket{"10m0s", 1}) || a.Lifetimes[1] != (Bucket{"3h0m0s", 1}) {
+		t.Errorf("unexpected lifetimes: %+v", a.Lifetimes)
+	}
+
+	// The never-hit entry expires after the short unhit TTL.
+	now = now.Add(10 * time.Minute)
+	if _, ok := c.Get(cold); ok {
+		t.Error("expected unhit entry to expire early")
+	}
+	// The hot entry outlives the base TTL, but not the max.
+	now = now.Add(2 * time.Hour)
+	entry, ok := c.Get(hot)
+	if !ok {
+		t.Fatal("expected frequently hit entry to outlive the base TTL")
+	}
+	if want := time.Unix(1_700_000_000, 0).Add(3 * time.Hour); !entry.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", entry.ExpiresAt, want)
+	}
+	now = now.Add(time.Hour)
+	if _, ok := c.Get(hot); ok {
+		t.Error("expected entry to expire at the max TTL")
+	}
+}
+
+func TestKeyIncludesN(t *testing.T) {
+	one, three := 1, 3
+	for _, format := range []string{KeyFormatJSON, KeyFormatHash} {
+		c := New(time.Hour, 100)
+		c.SetKeyFormat(format)
+		a := makeReq("hello", nil, false)
+		b := makeReq("hello", nil, false)
+		b.N = &three
+		if c.Key(a) == c.Key(b) {
+			t.Errorf("%s: n not in key", format)
+		}
+		b.N = &one
+		if c.Key(a) != c.Key(b) {
+			t.Errorf("%s: n 1 should share the key of an unset n", format)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// ErrUnknownRequest is returned by Feedback.Rate for a request ID that was
+// never served, was served longer ago than the feedback window, or was made
+// with another API key.
+var ErrUnknownRequest = errors.New("unknown or expired request ID")
+
+// Feedback collects end-user ratings of served answers, given by request
+// ID, and excludes answers rated down by enough API keys from both caches,
+// so a bad answer isn't replayed forever. An answer is identified by the
+// text of its choices, so the same answer served by either cache, or
+// fresh from upstream, shares its ratings.
+type Feedback struct {
+	threshold   int
+	window      time.Duration
+	maxRequests int
+	now         func() time.Time
+
+	mu       sync.Mutex
+	requests map[string]*list.Element
+	order    *list.List // of *servedAnswer, oldest first
+	// negative counts, per API key, the remembered requests rating each
+	// answer down.
+	negative map[string]map[string]int
+	// excluded holds answers that reached the threshold. They stay
+	// excluded after their ratings are forgotten, until maxRequests newer
+	// answers were excluded.
+	excluded      map[string]*list.Element
+	excludedOrder *list.List // of answers, oldest first
+	positive      uint64
+	ratings       uint64
+}
+
+// servedAnswer is the answer served for one request and its rating, if
+// any.
+type servedAnswer struct {
+	requestID string
+	apiKey    string
+	answer    string
+	at        time.Time
+	rating    int
+}
+
+// FeedbackStats is a snapshot of a Feedback.
+type FeedbackStats struct {
+	Ratings  uint64 `json:"ratings"`
+	Positive uint64 `json:"positive"`
+	// Requests is how many served requests can still be rated.
+	Requests int `json:"requests"`
+	Excluded int `json:"excluded"`
+}
+
+// NewFeedback creates a tracker that excludes an answer once requests
+// made with threshold different API keys have rated it down. Requests can
+// be rated for window after they were served; at most maxRequests requests,
+// and as many exclusions, are remembered.
+func NewFeedback(threshold int, window time.Duration, maxRequests int) *Feedback {
+	return &Feedback{
+		threshold:     threshold,
+		window:        window,
+		maxRequests:   maxRequests,
+		now:           time.Now,
+		requests:      make(map[string]*list.Element),
+		order:         list.New(),
+		negative:      make(map[string]map[string]int),
+		excluded:      make(map[string]*list.Element),
+		excludedOrder: list.New(),
+	}
+}
+
+// Served remembers the answer served for requestID, made with apiKey, so
+// it can be rated. A request ID already remembered keeps its first answer.
+// A nil Feedback ignores everything.
+func (f *Feedback) Served(requestID, apiKey string, resp *model.ChatResponse) {
+	if f == nil || requestID == "" || resp == nil {
+		return
+	}
+	s := &servedAnswer{requestID: requestID, apiKey: apiKey, answer: responseHash(resp), at: f.now()}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	if _, ok := f.requests[requestID]; ok {
+		return
+	}
+	for f.order.Len() >= f.maxRequests && f.order.Len() > 0 {
+		f.forget(f.order.Front())
+	}
+	f.requests[requestID] = f.order.PushBack(s)
+}
+
+// Rate records rating, 1 for a good answer or -1 for a bad one, for the
+// answer served to requestID, which must have been made with apiKey.
+// Rating a request again replaces its earlier rating; however many
+// requests an API key rates down, it counts once towards the threshold. It
+// reports whether the answer is now excluded from the caches.
+func (f *Feedback) Rate(requestID, apiKey string, rating int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	elem, ok := f.requests[requestID]
+	if !ok || elem.Value.(*servedAnswer).apiKey != apiKey {
+		return false, ErrUnknownRequest
+	}
+	s := elem.Value.(*servedAnswer)
+	f.ratings++
+	if s.rating < 0 {
+		f.unrate(s)
+	}
+	if rating > 0 {
+		f.positive++
+	}
+	s.rating = rating
+	if rating < 0 {
+		raters := f.negative[s.answer]
+		if raters == nil {
+			raters = make(map[string]int)
+			f.negative[s.answer] = raters
+		}
+		raters[s.apiKey]++
+		if len(raters) >= f.threshold {
+			f.exclude(s.answer)
+		}
+	}
+	_, excluded := f.excluded[s.answer]
+	return excluded, nil
+}
+
+// exclude adds answer to the exclusions, dropping the oldest beyond
+// maxRequests. f.mu must be held.
+func (f *Feedback) exclude(answer string) {
+	if _, ok := f.excluded[answer]; ok {
+		return
+	}
+	f.excluded[answer] = f.excludedOrder.PushBack(answer)
+	for f.excludedOrder.Len() > f.maxRequests {
+		delete(f.excluded, f.excludedOrder.Remove(f.excludedOrder.Front()).(string))
+	}
+}
+
+// Excluded reports whether resp has been rated down by enough requests
+// that the caches must not serve it.
+func (f *Feedback) Excluded(resp *model.ChatResponse) bool {
+	if f == nil || resp == nil {
+		return false
+	}
+	answer := responseHash(resp)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.excluded[answer]
+	return ok
+}
+
+// Stats returns the rating counters.
+func (f *Feedback) Stats() FeedbackStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FeedbackStats{Ratings: f.ratings, Positive: f.positive, Requests: f.order.Len(), Excluded: len(f.excluded)}
+}
+
+// expire forgets requests served longer ago than the window. f.mu must be
+// held.
+func (f *Feedback) expire() {
+	cutoff := f.now().Add(-f.window)
+	for elem := f.order.Front(); elem != nil && elem.Value.(*servedAnswer).at.Before(cutoff); elem = f.order.Front() {
+		f.forget(elem)
+	}
+}
+
+// forget drops a remembered request and its rating. f.mu must be held.
+func (f *Feedback) forget(elem *list.Element) {
+	s := f.order.Remove(elem).(*servedAnswer)
+	delete(f.requests, s.requestID)
+	if s.rating < 0 {
+		f.unrate(s)
+	}
+}
+
+// unrate drops the negative rating of s from the counts. f.mu must be
+// held.
+func (f *Feedback) unrate(s *servedAnswer) {
+	raters := f.negative[s.answer]
+	if raters[s.apiKey]--; raters[s.apiKey] <= 0 {
+		delete(raters, s.apiKey)
+	}
+	if len(raters) == 0 {
+		delete(f.negative, s.answer)
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"sync/atomic"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// StoreFilter rejects responses that should never be served from a cache:
+// truncated (finish_reason "length") or filtered ("content_filter") answers,
+// and, unless AllowEmpty is set, responses with no content at all. With a
+// size limit it also rejects responses too large to be worth the space.
+// Responses the upstream marked as not cacheable are always rejected.
+type StoreFilter struct {
+	finishReasons map[string]bool
+	allowEmpty    bool
+	maxBytes      int
+
+	oversize atomic.Uint64
+	noStore  atomic.Uint64
+}
+
+// NewStoreFilter creates a filter rejecting the given finish reasons.
+func NewStoreFilter(finishReasons []string, allowEmpty bool) *StoreFilter {
+	f := &StoreFilter{
+		finishReasons: make(map[string]bool, len(finishReasons)),
+		allowEmpty:    allowEmpty,
+	}
+	for _, r := range finishReasons {
+		f.finishReasons[r] = true
+	}
+	return f
+}
+
+// SetMaxBytes rejects responses whose content, summed over choices and
+// including reasoning, exceeds n bytes, so a few giant completions can't
+// evict many useful entries. 0 means no limit. Must be called before the
+// filter is used.
+func (f *StoreFilter) SetMaxBytes(n int) {
+	f.maxBytes = n
+}
+
+// Oversize returns how many responses were rejected for their size.
+func (f *StoreFilter) Oversize() uint64 {
+	if f == nil {
+		return 0
+	}
+	return f.oversize.Load()
+}
+
+// NoStore returns how many responses were rejected because the upstream
+// marked them as not cacheable.
+func (f *StoreFilter) NoStore() uint64 {
+	if f == nil {
+		return 0
+	}
+	return f.noStore.Load()
+}
+
+// Allows reports whether resp may be stored. A nil filter allows everything
+// the upstream didn't mark as not cacheable. Both caches ask about the same
+// responses, so Allows counts nothing; see Count.
+func (f *StoreFilter) Allows(resp *model.ChatResponse) bool {
+	return f.check(resp) == allowed
+}
+
+// Count adds resp to the Oversize or NoStore counter if it is rejected for
+// that reason. Call it once per upstream response offered to the caches.
+func (f *StoreFilter) Count(resp *model.ChatResponse) {
+	if f == nil {
+		return
+	}
+	switch f.check(resp) {
+	case rejectedOversize:
+		f.oversize.Add(1)
+	case rejectedNoStore:
+		f.noStore.Add(1)
+	}
+}
+
+// verdict is the outcome of checking a response against a StoreFilter.
+type verdict int
+
+const (
+	allowed verdict = iota
+	rejected
+	rejectedOversize
+	rejectedNoStore
+)
+
+func (f *StoreFilter) check(resp *model.ChatResponse) verdict {
+	if resp != nil && resp.NoStore {
+		return rejectedNoStore
+	}
+	if f == nil {
+		return allowed
+	}
+	if resp == nil || len(resp.Choices) == 0 {
+		return rejected
+	}
+	size := 0
+	for _, c := range resp.Choices {
+		if f.finishReasons[c.FinishReason] {
+			return rejected
+		}
+		if !f.allowEmpty && c.Message.Content == "" {
+			return rejected
+		}
+		size += len(c.Message.Content) + len(c.Message.ReasoningContent)
+	}
+	if f.maxBytes > 0 && size > f.maxBytes {
+		return rejectedOversize
+	}
+	return allowed
+}
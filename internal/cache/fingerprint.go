@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Fingerprints tracks the latest system_fingerprint seen per model in live
+// upstream responses. A cached response whose fingerprint differs from its
+// model's latest was produced by a different backend configuration, so its
+// answer may have drifted from what the model returns now.
+type Fingerprints struct {
+	mu       sync.RWMutex
+	latest   map[string]string
+	onChange func(model, old, new string)
+}
+
+// NewFingerprints creates an empty tracker. onChange, if non-nil, is called
+// when a model's fingerprint changes from one non-empty value to another.
+func NewFingerprints(onChange func(model, old, new string)) *Fingerprints {
+	return &Fingerprints{latest: make(map[string]string), onChange: onChange}
+}
+
+// Observe records the fingerprint of a live response. Responses without one
+// are ignored. A nil Fingerprints ignores everything.
+func (f *Fingerprints) Observe(resp *model.ChatResponse) {
+	if f == nil || resp == nil || resp.SystemFingerprint == "" {
+		return
+	}
+	f.mu.RLock()
+	old := f.latest[resp.Model]
+	f.mu.RUnlock()
+	if old == resp.SystemFingerprint {
+		return
+	}
+	f.mu.Lock()
+	old = f.latest[resp.Model]
+	f.latest[resp.Model] = resp.SystemFingerprint
+	f.mu.Unlock()
+	if old != "" && old != resp.SystemFingerprint && f.onChange != nil {
+		f.onChange(resp.Model, old, resp.SystemFingerprint)
+	}
+}
+
+// Stale reports whether a cached response carries a fingerprint other than
+// the latest one seen for its model. Responses without a fingerprint, and
+// models not seen live yet, are never stale.
+func (f *Fingerprints) Stale(resp *model.ChatResponse) bool {
+	if f == nil || resp == nil || resp.SystemFingerprint == "" {
+		return false
+	}
+	f.mu.RLock()
+	cur := f.latest[resp.Model]
+	f.mu.RUnlock()
+	return cur != "" && cur != resp.SystemFingerprint
+}
+
+// Latest returns a copy of the latest fingerprint per model.
+func (f *Fingerprints) Latest() map[string]string {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make(map[string]string, len(f.latest))
+	for m, fp := range f.latest {
+		out[m] = fp
+	}
+	return out
+}
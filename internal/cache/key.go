@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math"
+	"sync"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Cache key formats. Changing format changes every key, so existing entries
+// (including exact keys stored alongside semantic-cache points) stop
+// matching.
+const (
+	// KeyFormatJSON hashes the JSON encoding of the key fields. It is the
+	// format used before KeyFormatHash and is kept for compatibility.
+	KeyFormatJSON = "v1"
+	// KeyFormatHash feeds the key fields straight into SHA-256 with length
+	// prefixes, skipping JSON encoding. It is the default.
+	KeyFormatHash = "v2"
+)
+
+// keyHasher streams length-prefixed fields into SHA-256 through a small
+// buffer, so hashing allocates nothing beyond the hex result.
+type keyHasher struct {
+	h   hash.Hash
+	buf []byte
+}
+
+const keyHasherFlush = 16 << 10
+
+var keyHasherPool = sync.Pool{
+	New: func() any {
+		return &keyHasher{h: sha256.New(), buf: make([]byte, 0, keyHasherFlush)}
+	},
+}
+
+// hashKey computes the KeyFormatHash key. Every variable-length field is
+// length-prefixed and every optional field has a presence byte, so distinct
+// requests can't produce the same byte stream.
+func hashKey(req *model.ChatRequest, messages []model.Message) string {
+	k := keyHasherPool.Get().(*keyHasher)
+	defer k.release()
+	k.h.Reset()
+	k.buf = k.buf[:0]
+
+	k.str("qlite-key-v2")
+	k.str(req.Model)
+	k.uint(uint64(len(messages)))
+	for i := range messages {
+		m := &messages[i]
+		k.str(m.Role)
+		k.str(m.Content)
+		k.str(m.ReasoningContent)
+		if m.CacheControl != nil {
+			k.byte(1)
+			k.str(m.CacheControl.Type)
+			k.str(m.CacheControl.TTL)
+		} else {
+			k.byte(0)
+		}
+	}
+	k.float(req.Temperature)
+	k.float(req.TopP)
+	if req.Seed != nil {
+		k.byte(1)
+		k.uint(uint64(*req.Seed))
+	} else {
+		k.byte(0)
+	}
+
+	// Fields added after the format was released are written only when
+	// set, each behind its own tag, so existing keys keep matching.
+	k.tagged(keyTagMaxCompletionTokens, req.MaxCompletionTokens)
+	k.tagged(keyTagN, choices(req))
+
+	k.h.Write(k.buf)
+	var sum [sha256.Size]byte
+	return hex.EncodeToString(k.h.Sum(sum[:0]))
+}
+
+func (k *keyHasher) release() {
+	if cap(k.buf) <= keyHasherFlush {
+		keyHasherPool.Put(k)
+	}
+}
+
+func (k *keyHasher) flush() {
+	if len(k.buf) >= keyHasherFlush {
+		k.h.Write(k.buf)
+		k.buf = k.buf[:0]
+	}
+}
+
+func (k *keyHasher) byte(b byte) {
+	k.buf = append(k.buf, b)
+}
+
+func (k *keyHasher) uint(v uint64) {
+	k.buf = binary.AppendUvarint(k.buf, v)
+}
+
+func (k *keyHasher) str(s string) {
+	k.uint(uint64(len(s)))
+	for len(s) > 0 {
+		n := min(len(s), keyHasherFlush-len(k.buf))
+		if n <= 0 {
+			k.h.Write(k.buf)
+			k.buf = k.buf[:0]
+			continue
+		}
+		k.buf = append(k.buf, s[:n]...)
+		s = s[n:]
+	}
+	k.flush()
+}
+
+// Tags of the optional trailing fields of KeyFormatHash keys, in the order
+// they are written.
+const (
+	keyTagMaxCompletionTokens byte = iota + 1
+	keyTagN
+)
+
+// choices returns req.N if it asks for more than one choice, and nil
+// otherwise, since n 1 and an unset n get the same response.
+func choices(req *model.ChatRequest) *int {
+	if req.N == nil || *req.N == 1 {
+		return nil
+	}
+	return req.N
+}
+
+// tagged writes v behind tag, or nothing if v is nil.
+func (k *keyHasher) tagged(tag byte, v *int) {
+	if v == nil {
+		return
+	}
+	k.byte(tag)
+	k.buf = binary.AppendVarint(k.buf, int64(*v))
+}
+
+func (k *keyHasher) float(f *float64) {
+	if f == nil {
+		k.byte(0)
+		return
+	}
+	k.byte(1)
+	k.buf = binary.LittleEndian.AppendUint64(k.buf, math.Float64bits(*f))
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// NormalizeContent canonicalizes message text for exact-cache keying:
+// leading/trailing whitespace is trimmed, runs of whitespace collapse to a
+// single space, Unicode space variants (NBSP, ideographic space, ...) become
+// ASCII spaces, and zero-width format characters (ZWSP, BOM, ...) are dropped.
+//
+// Full NFC/NFKC normalization would need golang.org/x/text; the proxy stays
+// stdlib-only, so only the whitespace and format-character cases are folded.
+func NormalizeContent(s string) string {
+	if isNormalized(s) {
+		return s
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	pendingSpace := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			pendingSpace = sb.Len() > 0
+		case unicode.Is(unicode.Cf, r):
+			// Zero-width and other invisible format characters.
+		default:
+			if pendingSpace {
+				sb.WriteByte(' ')
+				pendingSpace = false
+			}
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// isNormalized is a fast ASCII check so already-clean prompts avoid allocation.
+func isNormalized(s string) bool {
+	prevSpace := true // leading space is not normalized
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x80 {
+			return false
+		}
+		if c == ' ' {
+			if prevSpace {
+				return false
+			}
+			prevSpace = true
+			continue
+		}
+		if c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f' {
+			return false
+		}
+		prevSpace = false
+	}
+	return !prevSpace || len(s) == 0
+}
+
+// normalizeMessages returns messages with normalized content. The input slice
+// is returned as-is when nothing changes.
+func normalizeMessages(msgs []model.Message) []model.Message {
+	var out []model.Message
+	for i, m := range msgs {
+		n := NormalizeContent(m.Content)
+		if n == m.Content && out == nil {
+			continue
+		}
+		if out == nil {
+			out = make([]model.Message, len(msgs))
+			copy(out, msgs)
+		}
+		out[i].Content = n
+	}
+	if out == nil {
+		return msgs
+	}
+	return out
+}
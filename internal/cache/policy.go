@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// SamplingPolicy controls how sampling parameters affect caching.
+// The zero value caches only requests with no temperature or temperature 0,
+// and keys on top_p.
+type SamplingPolicy struct {
+	// MaxTemperature is the highest temperature still eligible for caching.
+	// Many teams run at ~0.2 but still want deterministic-ish reuse.
+	MaxTemperature float64
+	// IgnoreTopP excludes top_p from the exact-cache key.
+	IgnoreTopP bool
+}
+
+// AllowsTemperature reports whether a request with the given temperature may
+// be served from or stored in the cache. An unset temperature always qualifies.
+func (p SamplingPolicy) AllowsTemperature(t *float64) bool {
+	return t == nil || *t <= p.MaxTemperature
+}
+
+// Allows reports whether req is eligible for the exact cache. A request with
+// an explicit seed is reproducible at any temperature, so it always qualifies;
+// the seed is part of the key.
+func (p SamplingPolicy) Allows(req *model.ChatRequest) bool {
+	return req.Seed != nil || p.AllowsTemperature(req.Temperature)
+}
+
+// AdaptiveTTL makes an exact-cache entry's lifetime depend on how often it is
+// hit, so a fixed memory budget favors popular keys. Entries never hit live
+// for Unhit; the first hit extends that to the cache's TTL and each further
+// hit doubles it, up to Max. Lifetimes run from when the entry was stored.
+// Zero fields default to a quarter and eight times the cache's TTL.
+type AdaptiveTTL struct {
+	Unhit time.Duration
+	Max   time.Duration
+}
+
+func (p AdaptiveTTL) enabled() bool {
+	return p.Max > 0
+}
+
+// lifetime returns how long an entry hit hits times lives, given the cache's
+// base TTL.
+func (p AdaptiveTTL) lifetime(base time.Duration, hits int) time.Duration {
+	if hits == 0 {
+		return p.Unhit
+	}
+	d := base
+	for i := 1; i < hits && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
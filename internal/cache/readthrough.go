@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ResponseCache is a small TTL + LRU cache of raw response bodies, used for
+// read-through caching of deterministic endpoints such as /v1/models and
+// /v1/embeddings. A zero TTL disables it: Get always misses and Put is a no-op.
+type ResponseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // front = most recently used
+}
+
+type responseEntry struct {
+	key       string
+	body      []byte
+	expiresAt time.Time
+}
+
+// NewResponseCache creates a response cache holding at most maxEntries bodies.
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the body cached under key, if present and not expired.
+func (c *ResponseCache) Get(key string) ([]byte, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*responseEntry)
+	if time.Now().After(e.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e.body, true
+}
+
+// Put stores body under key. The slice must not be modified afterwards.
+func (c *ResponseCache) Put(key string, body []byte) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &responseEntry{key: key, body: body, expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = e
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.maxEntries {
+		if back := c.order.Back(); back != nil {
+			c.order.Remove(back)
+			delete(c.items, back.Value.(*responseEntry).key)
+		}
+	}
+	c.items[key] = c.order.PushFront(e)
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/embedding"
@@ -14,13 +16,94 @@ import (
 
 // SemanticCache checks for semantically similar cached responses via embeddings + Qdrant.
 type SemanticCache struct {
-	embedder  *embedding.Client
+	embedder  embedding.Embedder
 	qdrant    *qdrant.Client
 	threshold float32
+	volatile  *Volatile
+	sampling  SamplingPolicy
+	filter    *StoreFilter
+	exactKey  func(*model.ChatRequest) string
+	prompts   PromptStorage
+	// compatible maps a requested model to the models whose cached answers
+	// may also serve it, in addition to its own.
+	compatible map[string][]string
+	limits     EmbeddingLimits
+	prints     *Fingerprints
+	dedup      bool
+	deduped    atomic.Uint64
+	floor      float32
+	store      *ResponseStore
+	feedback   *Feedback
+}
+
+// EmbeddingLimits bounds embedding work. Each embedding call may take
+// Timeout plus PerKTokens for every 1000 estimated prompt tokens, and prompts
+// estimated above MaxPromptTokens skip the semantic cache entirely. Zero
+// values mean a 5s timeout, no scaling and no size limit.
+type EmbeddingLimits struct {
+	Timeout         time.Duration
+	PerKTokens      time.Duration
+	MaxPromptTokens int
+}
+
+// timeout returns the embedding timeout for text.
+func (l EmbeddingLimits) timeout(text string) time.Duration {
+	base := l.Timeout
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	return base + time.Duration(estimateTokens(text))*l.PerKTokens/1000
+}
+
+// estimateTokens is the same len/4 heuristic the tokenizer uses for quick
+// estimates; exact counts aren't worth a tiktoken pass here.
+func estimateTokens(text string) int {
+	return len(text) / 4
+}
+
+// Prompt storage modes for PromptStorage.Mode.
+const (
+	PromptOff       = ""
+	PromptFull      = "full"
+	PromptTruncated = "truncated"
+	PromptHashed    = "hashed"
+)
+
+// PromptStorage controls whether Store keeps the embedded prompt text in the
+// Qdrant payload for debugging: in full, cut to MaxChars, or as a SHA-256 hex
+// digest when prompts must not be retained.
+type PromptStorage struct {
+	Mode     string
+	MaxChars int
+}
+
+func (p PromptStorage) encode(text string) string {
+	switch p.Mode {
+	case PromptFull:
+		return text
+	case PromptTruncated:
+		if r := []rune(text); p.MaxChars > 0 && len(r) > p.MaxChars {
+			return string(r[:p.MaxChars])
+		}
+		return text
+	case PromptHashed:
+		h := sha256.Sum256([]byte(text))
+		return hex.EncodeToString(h[:])
+	}
+	return ""
+}
+
+// InspectResult is a cached entry matched by Inspect.
+type InspectResult struct {
+	ID        string `json:"id"`
+	Model     string `json:"model"`
+	CreatedAt int64  `json:"created_at"`
+	Prompt    string `json:"prompt"`
+	Response  string `json:"response"`
 }
 
 // NewSemanticCache creates a new semantic cache.
-func NewSemanticCache(embedder *embedding.Client, q *qdrant.Client, threshold float32) *SemanticCache {
+func NewSemanticCache(embedder embedding.Embedder, q *qdrant.Client, threshold float32) *SemanticCache {
 	return &SemanticCache{
 		embedder:  embedder,
 		qdrant:    q,
@@ -28,43 +111,246 @@ func NewSemanticCache(embedder *embedding.Client, q *qdrant.Client, threshold fl
 	}
 }
 
+// SetFingerprints makes lookups skip hits whose system_fingerprint is stale
+// according to f.
+func (s *SemanticCache) SetFingerprints(f *Fingerprints) {
+	s.prints = f
+}
+
+// SetVolatile configures volatile-content handling. nil disables it.
+func (s *SemanticCache) SetVolatile(v *Volatile) {
+	s.volatile = v
+}
+
+// SetSamplingPolicy configures temperature tolerance for semantic caching.
+func (s *SemanticCache) SetSamplingPolicy(p SamplingPolicy) {
+	s.sampling = p
+}
+
+// SetStoreFilter configures which responses Store skips. nil stores everything.
+func (s *SemanticCache) SetStoreFilter(f *StoreFilter) {
+	s.filter = f
+}
+
+// SetExactKeyFunc makes Store record each request's exact-cache key in the
+// payload so WarmExact can later hydrate an exact cache. nil records nothing.
+func (s *SemanticCache) SetExactKeyFunc(f func(*model.ChatRequest) string) {
+	s.exactKey = f
+}
+
+// SetPromptStorage configures whether Store keeps prompt text in payloads.
+func (s *SemanticCache) SetPromptStorage(p PromptStorage) {
+	s.prompts = p
+}
+
+// SetCompatibleModels lets entries cached for other models satisfy requests
+// for a model, e.g. {"gpt-4o-mini": ["gpt-4o"]} serves gpt-4o-mini requests
+// from gpt-4o answers too. The mapping is one-directional.
+func (s *SemanticCache) SetCompatibleModels(m map[string][]string) {
+	s.compatible = m
+}
+
+// SetEmbeddingLimits configures embedding timeouts and the prompt size limit.
+func (s *SemanticCache) SetEmbeddingLimits(l EmbeddingLimits) {
+	s.limits = l
+}
+
+// SetDedupResponses makes Store keep one copy of textually identical
+// responses per model: a point whose answer is already stored refers to the
+// point holding it instead of repeating it. Lookups follow such references
+// whether or not dedup is enabled.
+func (s *SemanticCache) SetDedupResponses(on bool) {
+	s.dedup = on
+}
+
+// SetLatencyFloor sets the lowest similarity at which SearchMatch with near
+// returns an entry, as a stand-in for requests that can't wait for the
+// upstream. Zero, or a floor at or above the threshold, returns only hits.
+func (s *SemanticCache) SetLatencyFloor(floor float32) {
+	s.floor = floor
+}
+
+// SetFeedback makes lookups skip entries whose answer end users have rated
+// down according to f. Must be called before the cache is used.
+func (s *SemanticCache) SetFeedback(f *Feedback) {
+	s.feedback = f
+}
+
+// SetResponseStore makes hits on responses st holds, such as those the
+// exact cache keeps in it, serve st's copy, so both caches answer with
+// identical bytes. Store records each response's content hash for this, next
+// to the full response, which stays the durable copy. nil serves responses
+// as decoded from Qdrant.
+func (s *SemanticCache) SetResponseStore(st *ResponseStore) {
+	s.store = st
+}
+
+// Deduped returns how many stored points refer to another point's response
+// instead of carrying their own.
+func (s *SemanticCache) Deduped() uint64 {
+	return s.deduped.Load()
+}
+
+// Entries returns the number of points stored in Qdrant.
+func (s *SemanticCache) Entries(ctx context.Context) (int, error) {
+	return s.qdrant.Count(ctx)
+}
+
+// SamplingPolicy returns the configured sampling policy.
+func (s *SemanticCache) SamplingPolicy() SamplingPolicy {
+	return s.sampling
+}
+
+// Bypass reports whether req contains volatile content, or is too large to
+// be worth embedding, and must not be cached.
+func (s *SemanticCache) Bypass(req *model.ChatRequest) bool {
+	return s.BypassReason(req) != ""
+}
+
+// BypassReason is Bypass, saying why: "volatile_content" or
+// "prompt_too_large", or "" if req may be cached.
+func (s *SemanticCache) BypassReason(req *model.ChatRequest) string {
+	if s.volatile.Bypass(req) {
+		return "volatile_content"
+	}
+	if limit := s.limits.MaxPromptTokens; limit > 0 {
+		n := 0
+		for _, m := range req.Messages {
+			n += len(m.Content)
+		}
+		if n/4 > limit {
+			return "prompt_too_large"
+		}
+	}
+	return ""
+}
+
+// embed computes the embedding for text within the size-scaled timeout.
+func (s *SemanticCache) embed(ctx context.Context, text string) ([]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.limits.timeout(text))
+	defer cancel()
+	return s.embedder.Embed(ctx, text)
+}
+
 // Lookup embeds the request and searches Qdrant for a similar cached response.
 // Returns (response, embedding, text, error). On any failure, returns (nil, nil, "", nil) for graceful fallthrough.
 // The embedding and text are returned so Store() can reuse them without recomputing.
 func (s *SemanticCache) Lookup(ctx context.Context, req *model.ChatRequest) (*model.ChatResponse, []float32, string, error) {
-	text := embedding.TextFromMessages(req.Messages)
+	resp, emb, text, _ := s.Search(ctx, req)
+	return resp, emb, text, nil
+}
 
-	emb, err := s.embedder.Embed(ctx, text)
-	if err != nil {
-		return nil, nil, "", nil
+// Search is Lookup, but reports embedding and Qdrant failures instead of
+// treating them as misses. The embedding and text are still returned when
+// only the search failed.
+func (s *SemanticCache) Search(ctx context.Context, req *model.ChatRequest) (*model.ChatResponse, []float32, string, error) {
+	m, emb, text, err := s.search(ctx, req, s.threshold)
+	return m.Hit, emb, text, err
+}
+
+// Match is the outcome of a semantic search: the closest cached entry for
+// the request's model and how it scored.
+type Match struct {
+	// Hit is the closest entry if it scored at least the threshold.
+	Hit *model.ChatResponse
+	// Near is the closest entry if it scored below the threshold but at or
+	// above the latency floor. Only SearchMatch with near set fills it.
+	Near *model.ChatResponse
+	// Score is the closest entry's similarity. Found is false if there was
+	// no entry to compare with.
+	Score float32
+	Found bool
+	// Stale reports that the closest entry came from an outdated backend
+	// configuration and was ignored.
+	Stale bool
+	// Excluded reports that the closest entry's answer was rated down by
+	// end users and was ignored.
+	Excluded bool
+}
+
+// SearchMatch is Search returning the full Match. With near, an entry
+// below the threshold and at or above the latency floor is returned as
+// Match.Near.
+func (s *SemanticCache) SearchMatch(ctx context.Context, req *model.ChatRequest, near bool) (Match, []float32, string, error) {
+	floor := s.threshold
+	if near {
+		floor = min(s.floor, s.threshold)
 	}
+	return s.search(ctx, req, floor)
+}
+
+// search looks up the closest entry. It is a hit if it scores at least the
+// threshold, and near if it scores at least a lower floor.
+func (s *SemanticCache) search(ctx context.Context, req *model.ChatRequest, floor float32) (m Match, emb []float32, text string, err error) {
+	text = embedding.TextFromMessages(s.volatile.Strip(req.Messages))
 
-	results, err := s.qdrant.Search(ctx, emb, 1, s.threshold, req.Model)
+	emb, err = s.embed(ctx, text)
 	if err != nil {
-		return nil, emb, text, nil
+		return Match{}, nil, "", fmt.Errorf("computing embedding: %w", err)
+	}
+	if floor <= 0 {
+		floor = s.threshold
 	}
 
-	if len(results) > 0 && results[0].Payload != nil && results[0].Payload.Response != nil {
-		return results[0].Payload.Response, emb, text, nil
+	models := []string{req.Model}
+	models = append(models, s.compatible[req.Model]...)
+	// No score threshold: the closest entry's score is reported even when
+	// it misses.
+	results, err := s.qdrant.SearchModels(ctx, emb, 1, 0, models)
+	if err != nil {
+		return Match{}, emb, text, fmt.Errorf("searching qdrant: %w", err)
+	}
+	if len(results) == 0 {
+		return Match{}, emb, text, nil
+	}
+	best := results[0]
+	m = Match{Score: best.Score, Found: true}
+	if best.Score < floor {
+		return m, emb, text, nil
 	}
 
-	return nil, emb, text, nil
+	if err := s.resolveShared(ctx, results[:1]); err != nil {
+		return m, emb, text, err
+	}
+	if best = results[0]; best.Payload == nil {
+		return m, emb, text, nil
+	}
+	if s.shareStored(best.Payload); best.Payload.Response == nil {
+		return m, emb, text, nil
+	}
+	if s.prints.Stale(best.Payload.Response) {
+		// Treated as a miss; the fresh response's store replaces it.
+		m.Stale = true
+		return m, emb, text, nil
+	}
+	if s.feedback.Excluded(best.Payload.Response) {
+		// Also a miss; the fresh response's store replaces it.
+		m.Excluded = true
+		return m, emb, text, nil
+	}
+	if best.Score < s.threshold {
+		m.Near = best.Payload.Response
+	} else {
+		m.Hit = best.Payload.Response
+	}
+	return m, emb, text, nil
 }
 
 // Store saves a response in Qdrant for future semantic lookups.
 // If emb is non-nil it is reused; otherwise a fresh embedding is computed.
 // If text is non-empty it is reused for the point ID; otherwise it is recomputed.
+// Responses rejected by the store filter are skipped without error.
 func (s *SemanticCache) Store(ctx context.Context, req *model.ChatRequest, resp *model.ChatResponse, emb []float32, text string) error {
+	if !s.filter.Allows(resp) {
+		return nil
+	}
 	if text == "" {
-		text = embedding.TextFromMessages(req.Messages)
+		text = embedding.TextFromMessages(s.volatile.Strip(req.Messages))
 	}
 
 	if emb == nil {
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
-
 		var err error
-		emb, err = s.embedder.Embed(ctx, text)
+		emb, err = s.embed(ctx, text)
 		if err != nil {
 			return fmt.Errorf("computing embedding for store: %w", err)
 		}
@@ -76,10 +362,84 @@ func (s *SemanticCache) Store(ctx context.Context, req *model.ChatRequest, resp
 		Model:     req.Model,
 		CreatedAt: time.Now().Unix(),
 	}
+	if s.exactKey != nil {
+		payload.ExactKey = s.exactKey(req)
+	}
+	payload.Prompt = s.prompts.encode(text)
+	if s.store != nil {
+		payload.ContentHash, _ = ContentHash(resp)
+	}
+	if s.dedup {
+		s.shareResponse(ctx, id, payload)
+	}
 
 	return s.qdrant.Upsert(ctx, id, emb, payload)
 }
 
+// shareResponse makes payload refer to an already stored copy of its
+// response, or marks it as the copy later points may refer to. If the copy
+// can't be looked up the response is stored in full.
+func (s *SemanticCache) shareResponse(ctx context.Context, id string, payload *qdrant.CachedPayload) {
+	payload.ResponseHash = responseHash(payload.Response)
+	ref, err := s.qdrant.FindResponse(ctx, payload.Model, payload.ResponseHash)
+	// Qdrant returns IDs in hyphenated UUID form; re-storing the point
+	// holding the copy must not make it refer to itself.
+	if err != nil || ref == "" || strings.ReplaceAll(ref, "-", "") == id {
+		return
+	}
+	payload.Response, payload.ResponseRef = nil, ref
+	s.deduped.Add(1)
+}
+
+// shareStored replaces payload's response with the response store's copy,
+// if the store holds it.
+func (s *SemanticCache) shareStored(payload *qdrant.CachedPayload) {
+	if s.store == nil || payload.ContentHash == "" {
+		return
+	}
+	if resp, err := s.store.Get(payload.ContentHash); err == nil {
+		payload.Response = resp
+	}
+}
+
+// resolveShared fills in the responses of points that refer to another
+// point's copy. A point whose copy is gone, or now holds a different answer,
+// is left without a response.
+func (s *SemanticCache) resolveShared(ctx context.Context, points []qdrant.SearchResult) error {
+	var ids []string
+	for _, p := range points {
+		if p.Payload != nil && p.Payload.Response == nil && p.Payload.ResponseRef != "" {
+			ids = append(ids, p.Payload.ResponseRef)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	shared, err := s.qdrant.Get(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("fetching shared responses: %w", err)
+	}
+	for _, p := range points {
+		if p.Payload == nil || p.Payload.ResponseRef == "" {
+			continue
+		}
+		if c := shared[p.Payload.ResponseRef]; c != nil && c.ResponseHash == p.Payload.ResponseHash {
+			p.Payload.Response = c.Response
+		}
+	}
+	return nil
+}
+
+// responseHash identifies a response by the text and finish reason of its
+// choices.
+func responseHash(resp *model.ChatResponse) string {
+	h := sha256.New()
+	for _, c := range resp.Choices {
+		fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%s\x00", c.Index, c.Message.Role, c.Message.Content, c.Message.ReasoningContent, c.FinishReason)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // pointIDFromText generates a deterministic ID from model and precomputed text.
 func pointIDFromText(modelName, text string) string {
 	h := sha256.New()
@@ -88,3 +448,53 @@ func pointIDFromText(modelName, text string) string {
 	h.Write([]byte(text))
 	return hex.EncodeToString(h.Sum(nil)[:16]) // 128-bit hex string
 }
+
+// WarmExact loads up to n of the most recent semantic entries into exact,
+// skipping entries stored without an exact key or already past the exact
+// cache's TTL. It returns the number of entries loaded.
+func (s *SemanticCache) WarmExact(ctx context.Context, exact *ExactCache, n int) (int, error) {
+	points, err := s.qdrant.Recent(ctx, n)
+	if err != nil {
+		return 0, fmt.Errorf("listing recent entries: %w", err)
+	}
+	if err := s.resolveShared(ctx, points); err != nil {
+		return 0, err
+	}
+	loaded := 0
+	// Oldest first, so the newest entries end up most recently used.
+	for i := len(points) - 1; i >= 0; i-- {
+		p := points[i].Payload
+		if p == nil || p.ExactKey == "" || p.Response == nil {
+			continue
+		}
+		if exact.putAt(p.ExactKey, p.Response, time.Unix(p.CreatedAt, 0)) {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+// Inspect returns up to limit cached entries whose stored prompt contains q.
+// With hashed prompt storage q must be the full prompt text; it is hashed and
+// matched exactly.
+func (s *SemanticCache) Inspect(ctx context.Context, q string, limit int) ([]InspectResult, error) {
+	if s.prompts.Mode == PromptHashed {
+		q = s.prompts.encode(q)
+	}
+	points, err := s.qdrant.FindPrompts(ctx, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolveShared(ctx, points); err != nil {
+		return nil, err
+	}
+	results := make([]InspectResult, 0, len(points))
+	for _, p := range points {
+		r := InspectResult{ID: p.ID, Model: p.Payload.Model, CreatedAt: p.Payload.CreatedAt, Prompt: p.Payload.Prompt}
+		if resp := p.Payload.Response; resp != nil && len(resp.Choices) > 0 {
+			r.Response = resp.Choices[0].Message.Content
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -3,9 +3,12 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/embedding"
 	"github.com/eduardmaghakyan/qlite/internal/model"
@@ -171,3 +174,416 @@ func TestSemanticCache_Store(t *testing.T) {
 		t.Error("expected qdrant upsert to be called")
 	}
 }
+
+func TestSemanticCache_WarmExact(t *testing.T) {
+	exact := New(time.Hour, 100)
+	reqA := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "a"}}}
+	reqB := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "b"}}}
+	resp := func(id string) *model.ChatResponse {
+		return &model.ChatResponse{ID: id, Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: id}, FinishReason: "stop"}}}
+	}
+	now := time.Now().Unix()
+	points := []*qdrant.CachedPayload{
+		{Response: resp("a"), Model: "gpt-4o", CreatedAt: now, ExactKey: exact.Key(reqA)},
+		{Response: resp("nokey"), Model: "gpt-4o", CreatedAt: now},
+		{Response: resp("b"), Model: "gpt-4o", CreatedAt: now - 7200, ExactKey: exact.Key(reqB)}, // past TTL
+	}
+
+	var scrolled bool
+	qdrantServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collections/test/points/scroll" {
+			w.Write([]byte(`{"result":{}}`))
+			return
+		}
+		scrolled = true
+		var res []map[string]any
+		for i, p := range points {
+			payload, _ := json.Marshal(p)
+			res = append(res, map[string]any{"id": fmt.Sprint(i), "payload": json.RawMessage(payload)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"points": res}})
+	}))
+	defer qdrantServer.Close()
+
+	sc := NewSemanticCache(nil, qdrant.NewClient(qdrantServer.URL, "", "test"), 0.95)
+	loaded, err := sc.WarmExact(context.Background(), exact, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !scrolled || loaded != 1 {
+		t.Fatalf("expected 1 entry loaded, got %d", loaded)
+	}
+	if e, ok := exact.Get(reqA); !ok || e.Response.ID != "a" {
+		t.Error("expected warmed entry for request a")
+	}
+	if _, ok := exact.Get(reqB); ok {
+		t.Error("expected expired entry to be skipped")
+	}
+}
+
+// promptQdrant is a fake Qdrant that keeps upserted payloads and answers
+// prompt filters by substring, as Qdrant does without a full-text index.
+func promptQdrant(t *testing.T) *httptest.Server {
+	var payloads []qdrant.CachedPayload
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/test/points":
+			var body struct {
+				Points []struct {
+					Payload qdrant.CachedPayload `json:"payload"`
+				} `json:"points"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			for _, p := range body.Points {
+				payloads = append(payloads, p.Payload)
+			}
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+		case "/collections/test/points/scroll":
+			var body struct {
+				Filter struct {
+					Must []struct {
+						Key   string `json:"key"`
+						Match struct {
+							Text string `json:"text"`
+						} `json:"match"`
+					} `json:"must"`
+				} `json:"filter"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			q := body.Filter.Must[0].Match.Text
+			var res []map[string]any
+			for i, p := range payloads {
+				if strings.Contains(p.Prompt, q) {
+					b, _ := json.Marshal(p)
+					res = append(res, map[string]any{"id": fmt.Sprint(i), "payload": json.RawMessage(b)})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"points": res}})
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+}
+
+func TestSemanticCache_StorePromptAndInspect(t *testing.T) {
+	resp := &model.ChatResponse{ID: "r", Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Paris"}, FinishReason: "stop"}}}
+	req := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "capital of France, please"}}}
+
+	tests := []struct {
+		storage PromptStorage
+		query   string
+		prompt  string
+	}{
+		{PromptStorage{Mode: PromptTruncated, MaxChars: 16}, "France", "user: capital of"},
+		{PromptStorage{Mode: PromptFull}, "France", "user: capital of France, please"},
+		{PromptStorage{Mode: PromptHashed}, "user: capital of France, please", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.storage.Mode, func(t *testing.T) {
+			srv := promptQdrant(t)
+			defer srv.Close()
+			sc := NewSemanticCache(nil, qdrant.NewClient(srv.URL, "", "test"), 0.95)
+			sc.SetPromptStorage(tt.storage)
+			if err := sc.Store(context.Background(), req, resp, []float32{0.1}, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := sc.Inspect(context.Background(), tt.query, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.storage.Mode == PromptTruncated {
+				// "France" was cut off.
+				if len(results) != 0 {
+					t.Errorf("expected no match past the truncation point, got %+v", results)
+				}
+				results, _ = sc.Inspect(context.Background(), "capital", 10)
+			}
+			if len(results) != 1 || results[0].Response != "Paris" {
+				t.Fatalf("expected one match, got %+v", results)
+			}
+			if tt.prompt != "" && results[0].Prompt != tt.prompt {
+				t.Errorf("expected prompt %q, got %q", tt.prompt, results[0].Prompt)
+			}
+			if tt.storage.Mode == PromptHashed && len(results[0].Prompt) != 64 {
+				t.Errorf("expected a SHA-256 hex prompt, got %q", results[0].Prompt)
+			}
+		})
+	}
+}
+
+func TestSemanticCache_CompatibleModels(t *testing.T) {
+	embServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{0.1}}}})
+	}))
+	defer embServer.Close()
+
+	var filters []string
+	qdrantServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter json.RawMessage `json:"filter"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		filters = append(filters, string(body.Filter))
+		json.NewEncoder(w).Encode(map[string]any{"result": []any{}})
+	}))
+	defer qdrantServer.Close()
+
+	sc := NewSemanticCache(embedding.NewClient(embServer.URL, "key", "m"), qdrant.NewClient(qdrantServer.URL, "", "test"), 0.95)
+	sc.SetCompatibleModels(map[string][]string{"gpt-4o-mini": {"gpt-4o"}})
+
+	for _, m := range []string{"gpt-4o-mini", "gpt-4o"} {
+		sc.Lookup(context.Background(), &model.ChatRequest{Model: m, Messages: []model.Message{{Role: "user", Content: "hi"}}})
+	}
+	want := []string{
+		`{"must":[{"key":"model","match":{"any":["gpt-4o-mini","gpt-4o"]}}]}`,
+		`{"must":[{"key":"model","match":{"value":"gpt-4o"}}]}`,
+	}
+	if len(filters) != 2 || filters[0] != want[0] || filters[1] != want[1] {
+		t.Errorf("unexpected filters:\n%v\nwant\n%v", filters, want)
+	}
+}
+
+func TestSemanticCache_EmbeddingLimits(t *testing.T) {
+	l := EmbeddingLimits{Timeout: time.Second, PerKTokens: 2 * time.Second}
+	if got := l.timeout(strings.Repeat("x", 8000)); got != 5*time.Second {
+		t.Errorf("expected 1s + 2s per 1k tokens for 2000 tokens = 5s, got %v", got)
+	}
+	if got := (EmbeddingLimits{}).timeout("hi"); got != 5*time.Second {
+		t.Errorf("expected 5s default, got %v", got)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}))
+	defer slow.Close()
+
+	sc := NewSemanticCache(embedding.NewClient(slow.URL, "key", "m"), qdrant.NewClient("http://unused", "", "test"), 0.95)
+	sc.SetEmbeddingLimits(EmbeddingLimits{Timeout: 50 * time.Millisecond, MaxPromptTokens: 10})
+
+	start := time.Now()
+	resp, emb, _, _ := sc.Lookup(context.Background(), &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "hi"}}})
+	if resp != nil || emb != nil || time.Since(start) > 250*time.Millisecond {
+		t.Errorf("expected lookup to give up after the embedding timeout, took %v", time.Since(start))
+	}
+
+	if sc.Bypass(&model.ChatRequest{Messages: []model.Message{{Role: "user", Content: "short"}}}) {
+		t.Error("expected short prompt to use the semantic cache")
+	}
+	if !sc.Bypass(&model.ChatRequest{Messages: []model.Message{{Role: "user", Content: strings.Repeat("x", 100)}}}) {
+		t.Error("expected prompt above max_prompt_tokens to bypass the semantic cache")
+	}
+}
+
+// dedupQdrant is a fake Qdrant that keeps upserted points by ID, answers
+// response_hash scrolls and point gets, and returns the last upserted point
+// as an exact match for every search.
+func dedupQdrant(t *testing.T) (*httptest.Server, map[string]*qdrant.CachedPayload) {
+	points := make(map[string]*qdrant.CachedPayload)
+	var last string
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type raw struct {
+			ID      string          `json:"id"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		encode := func(id string) raw {
+			b, _ := json.Marshal(points[id])
+			return raw{ID: id, Payload: b}
+		}
+		switch {
+		case r.URL.Path == "/collections/test/index":
+			w.Write([]byte(`{"result":{}}`))
+		case r.URL.Path == "/collections/test/points" && r.Method == http.MethodPut:
+			var body struct {
+				Points []struct {
+					ID      string                `json:"id"`
+					Payload *qdrant.CachedPayload `json:"payload"`
+				} `json:"points"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			for _, p := range body.Points {
+				points[p.ID], last = p.Payload, p.ID
+			}
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+		case r.URL.Path == "/collections/test/points":
+			var body struct {
+				IDs []string `json:"ids"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			var res []raw
+			for _, id := range body.IDs {
+				if points[id] != nil {
+					res = append(res, encode(id))
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"result": res})
+		case r.URL.Path == "/collections/test/points/scroll":
+			var body struct {
+				Filter struct {
+					Must []struct {
+						Key     string                 `json:"key"`
+						Match   struct{ Value string } `json:"match"`
+						IsEmpty *struct{ Key string }  `json:"is_empty"`
+					} `json:"must"`
+				} `json:"filter"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			res := []raw{}
+			for id, p := range points {
+				ok := true
+				for _, c := range body.Filter.Must {
+					switch {
+					case c.IsEmpty != nil:
+						ok = ok && p.ResponseRef == ""
+					case c.Key == "model":
+						ok = ok && p.Model == c.Match.Value
+					case c.Key == "response_hash":
+						ok = ok && p.ResponseHash == c.Match.Value
+					}
+				}
+				if ok {
+					res = append(res, encode(id))
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"points": res}})
+		case r.URL.Path == "/collections/test/points/search":
+			type scored struct {
+				raw
+				Score float32 `json:"score"`
+			}
+			json.NewEncoder(w).Encode(map[string]any{"result": []scored{{encode(last), 1}}})
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	})), points
+}
+
+func TestSemanticCache_DedupResponses(t *testing.T) {
+	embServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{0.1, 0.2, 0.3}}}})
+	}))
+	defer embServer.Close()
+	srv, points := dedupQdrant(t)
+	defer srv.Close()
+
+	sc := NewSemanticCache(embedding.NewClient(embServer.URL, "key", "m"), qdrant.NewClient(srv.URL, "", "test"), 0.9)
+	sc.SetDedupResponses(true)
+	answer := func(id string) *model.ChatResponse {
+		return &model.ChatResponse{ID: id, Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "I can't help with that."}, FinishReason: "stop"}}}
+	}
+	store := func(prompt string, resp *model.ChatResponse) {
+		t.Helper()
+		req := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: prompt}}}
+		if err := sc.Store(context.Background(), req, resp, []float32{0.1, 0.2, 0.3}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store("first", answer("r1"))
+	store("first", answer("r1")) // re-storing the copy keeps it
+	store("second", answer("r2"))
+	store("third", answer("r3"))
+
+	full, refs := 0, 0
+	for _, p := range points {
+		if p.Response != nil {
+			full++
+		} else if p.ResponseRef != "" {
+			refs++
+		}
+	}
+	if full != 1 || refs != 2 || sc.Deduped() != 2 {
+		t.Fatalf("expected one stored copy and two references, got %d and %d (deduped %d)", full, refs, sc.Deduped())
+	}
+
+	// The last stored point refers to the copy; lookups follow it.
+	resp, _, _, err := sc.Search(context.Background(), &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "third"}}})
+	if err != nil || resp == nil || resp.ID != "r1" {
+		t.Fatalf("expected the shared response, got %+v, %v", resp, err)
+	}
+
+	// A copy replaced by a different answer no longer serves references.
+	store("first", &model.ChatResponse{ID: "r4", Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Sure."}, FinishReason: "stop"}}})
+	store("third", answer("r3"))
+	if resp, _, _, _ := sc.Search(context.Background(), &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "third"}}}); resp == nil || resp.ID != "r3" {
+		t.Errorf("expected the re-stored answer to become a copy, got %+v", resp)
+	}
+}
+
+func TestSemanticCache_SharedResponseStore(t *testing.T) {
+	embServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{0.1, 0.2, 0.3}}}})
+	}))
+	defer embServer.Close()
+	srv, points := dedupQdrant(t)
+	defer srv.Close()
+
+	responses := NewResponseStore()
+	exact := New(time.Hour, 1)
+	exact.SetResponseStore(responses)
+	sc := NewSemanticCache(embedding.NewClient(embServer.URL, "key", "m"), qdrant.NewClient(srv.URL, "", "test"), 0.9)
+	sc.SetResponseStore(responses)
+
+	req := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "hi"}}}
+	resp := makeResp("shared")
+	exact.Put(req, resp)
+	if err := sc.Store(context.Background(), req, resp, []float32{0.1, 0.2, 0.3}, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if p.ContentHash == "" || p.Response == nil {
+			t.Errorf("expected stored points to hold the response and its content hash, got %+v", p)
+		}
+	}
+
+	entry, _ := exact.Get(req)
+	hit, _, _, err := sc.Search(context.Background(), req)
+	if err != nil || hit == nil || entry == nil || hit != entry.Response {
+		t.Fatalf("expected both caches to serve the stored copy, got %p and %+v (%v)", hit, entry, err)
+	}
+
+	// Once the exact cache evicts the entry, hits fall back to Qdrant's copy.
+	exact.Put(&model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "other"}}}, makeResp("other"))
+	if _, ok := exact.Get(req); ok {
+		t.Fatal("expected the exact entry to be evicted")
+	}
+	if s := responses.Stats(); s.Responses != 1 {
+		t.Errorf("expected the evicted response to be dropped, got %+v", s)
+	}
+	if hit, _, _, err := sc.Search(context.Background(), req); err != nil || hit == nil || hit.ID != "shared" || hit == resp {
+		t.Errorf("expected the response decoded from qdrant, got %+v (%v)", hit, err)
+	}
+}
+
+func TestSemanticCache_Feedback(t *testing.T) {
+	embServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{0.1, 0.2, 0.3}}}})
+	}))
+	defer embServer.Close()
+	srv, _ := dedupQdrant(t)
+	defer srv.Close()
+
+	feedback := NewFeedback(1, time.Hour, 10)
+	sc := NewSemanticCache(embedding.NewClient(embServer.URL, "key", "m"), qdrant.NewClient(srv.URL, "", "test"), 0.9)
+	sc.SetFeedback(feedback)
+
+	req := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "hi"}}}
+	if err := sc.Store(context.Background(), req, makeResp("bad"), []float32{0.1, 0.2, 0.3}, ""); err != nil {
+		t.Fatal(err)
+	}
+	m, _, _, err := sc.SearchMatch(context.Background(), req, false)
+	if err != nil || m.Hit == nil {
+		t.Fatalf("expected a hit before feedback, got %+v (%v)", m, err)
+	}
+
+	feedback.Served("r1", "", m.Hit)
+	if _, err := feedback.Rate("r1", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	m, _, _, err = sc.SearchMatch(context.Background(), req, false)
+	if err != nil || m.Hit != nil || !m.Excluded {
+		t.Errorf("expected the rated-down entry to be excluded, got %+v (%v)", m, err)
+	}
+}
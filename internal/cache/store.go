@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// errNotStored is returned by ResponseStore.Get for a hash nothing holds.
+var errNotStored = errors.New("response not in store")
+
+// ResponseStore holds cached responses by content hash, so caches holding
+// the same response share one copy and serve identical bytes. Entries are
+// reference counted: each Put must be matched by a Release, and a response
+// is dropped when its last reference is released.
+type ResponseStore struct {
+	mu    sync.Mutex
+	items map[string]*storedResponse
+
+	// compressMin is the smallest response, in JSON bytes, stored
+	// compressed; 0 disables compression.
+	compressMin int
+	compressed  int
+	savedBytes  int64
+}
+
+// storedResponse is one response in a ResponseStore. packed is the gzipped
+// response when compression applied, in which case resp is nil.
+type storedResponse struct {
+	resp   *model.ChatResponse
+	packed []byte
+	saved  int // bytes saved by compression
+	refs   int
+}
+
+// StoreStats is a snapshot of a ResponseStore.
+type StoreStats struct {
+	Responses int `json:"responses"`
+	// References across all responses; more than Responses when caches
+	// share copies.
+	References int   `json:"references"`
+	Compressed int   `json:"compressed,omitempty"`
+	SavedBytes int64 `json:"saved_bytes,omitempty"`
+}
+
+// NewResponseStore creates an empty store.
+func NewResponseStore() *ResponseStore {
+	return &ResponseStore{items: make(map[string]*storedResponse)}
+}
+
+// SetCompression stores responses whose JSON encoding is at least minBytes
+// gzipped, trading CPU on every store and read for memory. 0 disables it.
+// Must be called before the store is used.
+func (s *ResponseStore) SetCompression(minBytes int) {
+	s.compressMin = minBytes
+}
+
+// ContentHash returns the SHA-256 hex digest of resp's JSON encoding, the
+// key it is stored under.
+func ContentHash(resp *model.ChatResponse) (string, error) {
+	hash, _, err := encodeResponse(resp)
+	return hash, err
+}
+
+func encodeResponse(resp *model.ChatResponse) (hash string, raw []byte, err error) {
+	raw, err = json.Marshal(resp)
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha256.Sum256(raw)
+	return hex.EncodeToString(h[:]), raw, nil
+}
+
+// Put adds a reference to resp and returns its content hash. If an
+// identical response is already stored, that copy is kept and resp is not.
+func (s *ResponseStore) Put(resp *model.ChatResponse) (string, error) {
+	hash, raw, err := encodeResponse(resp)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	if e, ok := s.items[hash]; ok {
+		e.refs++
+		s.mu.Unlock()
+		return hash, nil
+	}
+	s.mu.Unlock()
+
+	// Compress outside the lock; a concurrent Put of the same response
+	// just finds the entry below.
+	e := &storedResponse{resp: resp, refs: 1}
+	if s.compressMin > 0 {
+		if data, ok := model.CompressJSON(raw, s.compressMin); ok {
+			e.resp, e.packed, e.saved = nil, data, len(raw)-len(data)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.items[hash]; ok {
+		existing.refs++
+		return hash, nil
+	}
+	s.items[hash] = e
+	if e.packed != nil {
+		s.compressed++
+		s.savedBytes += int64(e.saved)
+	}
+	return hash, nil
+}
+
+// Get returns the response stored under hash. Callers must not modify it.
+func (s *ResponseStore) Get(hash string) (*model.ChatResponse, error) {
+	s.mu.Lock()
+	e, ok := s.items[hash]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errNotStored
+	}
+	if e.packed != nil {
+		return model.DecompressResponse(e.packed)
+	}
+	return e.resp, nil
+}
+
+// Release drops a reference taken by Put.
+func (s *ResponseStore) Release(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[hash]
+	if !ok {
+		return
+	}
+	if e.refs--; e.refs > 0 {
+		return
+	}
+	delete(s.items, hash)
+	if e.packed != nil {
+		s.compressed--
+		s.savedBytes -= int64(e.saved)
+	}
+}
+
+// Stats returns the store's occupancy and compression counters.
+func (s *ResponseStore) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := StoreStats{Responses: len(s.items), Compressed: s.compressed, SavedBytes: s.savedBytes}
+	for _, e := range s.items {
+		st.References += e.refs
+	}
+	return st
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Volatile actions.
+const (
+	// VolatileBypass skips both caches for requests containing a volatile marker.
+	VolatileBypass = "bypass"
+	// VolatileStrip removes volatile spans from the text used for cache keys.
+	VolatileStrip = "strip"
+)
+
+// Volatile detects volatile content (timestamps, request IDs, nonces) in
+// prompts. Such requests either bypass caching or have the volatile spans
+// stripped from the key, so the cache doesn't fill with never-reused entries.
+type Volatile struct {
+	patterns []*regexp.Regexp
+	strip    bool
+}
+
+// NewVolatile compiles the given patterns. action is VolatileBypass or VolatileStrip.
+func NewVolatile(patterns []string, action string) (*Volatile, error) {
+	v := &Volatile{}
+	switch action {
+	case VolatileBypass, "":
+	case VolatileStrip:
+		v.strip = true
+	default:
+		return nil, fmt.Errorf("unknown volatile action %q", action)
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("compiling volatile pattern %q: %w", p, err)
+		}
+		v.patterns = append(v.patterns, re)
+	}
+	return v, nil
+}
+
+// Bypass reports whether req must skip caching entirely.
+func (v *Volatile) Bypass(req *model.ChatRequest) bool {
+	if v == nil || v.strip {
+		return false
+	}
+	for _, m := range req.Messages {
+		for _, re := range v.patterns {
+			if re.MatchString(m.Content) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Strip returns messages with volatile spans removed when the action is
+// VolatileStrip; otherwise msgs is returned unchanged. The input is never mutated.
+func (v *Volatile) Strip(msgs []model.Message) []model.Message {
+	if v == nil || !v.strip {
+		return msgs
+	}
+	var out []model.Message
+	for i, m := range msgs {
+		content := m.Content
+		for _, re := range v.patterns {
+			content = re.ReplaceAllString(content, "")
+		}
+		if content == m.Content {
+			continue
+		}
+		if out == nil {
+			out = make([]model.Message, len(msgs))
+			copy(out, msgs)
+		}
+		out[i].Content = content
+	}
+	if out == nil {
+		return msgs
+	}
+	return out
+}
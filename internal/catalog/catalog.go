@@ -0,0 +1,117 @@
+// Package catalog is the one table of what qlite knows about models: their
+// context windows, capabilities and prices. Built-in entries cover the
+// models qlite has defaults for; provider configuration adds or overrides
+// entries per provider at startup. It is a leaf package: anything may use
+// it.
+package catalog
+
+// Price is a model price in USD per 1M tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Model describes a model. A zero ContextWindow is unknown, and so are a
+// nil SupportsTools, SupportsVision or Price; a Price of zero is a free
+// model.
+type Model struct {
+	Name           string `json:"name"`
+	ContextWindow  int    `json:"context_window,omitempty"`
+	SupportsTools  *bool  `json:"supports_tools,omitempty"`
+	SupportsVision *bool  `json:"supports_vision,omitempty"`
+	Price          *Price `json:"price,omitempty"`
+}
+
+// Lacks reports whether m is known not to support tools, if tools is set,
+// or image input, if vision is set. Unknown capabilities are assumed.
+func (m Model) Lacks(tools, vision bool) bool {
+	return tools && m.SupportsTools != nil && !*m.SupportsTools ||
+		vision && m.SupportsVision != nil && !*m.SupportsVision
+}
+
+// supported returns a Supports* value for the built-in entries.
+func supported(b bool) *bool { return &b }
+
+// builtin holds the models qlite ships defaults for, including those of the
+// provider presets.
+var builtin = map[string]Model{
+	"gpt-4o":            {ContextWindow: 128_000, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{2.50, 10.00}},
+	"gpt-4o-mini":       {ContextWindow: 128_000, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{0.15, 0.60}},
+	"gpt-4.1-nano":      {ContextWindow: 1_047_576, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{0.10, 0.40}},
+	"claude-sonnet-4-5": {ContextWindow: 200_000, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{3.00, 15.00}},
+	"claude-haiku-4-5":  {ContextWindow: 200_000, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{0.80, 4.00}},
+	"gemini-2.5-flash":  {ContextWindow: 1_048_576, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{0.15, 0.60}},
+	"gemini-2.5-pro":    {ContextWindow: 1_048_576, SupportsTools: supported(true), SupportsVision: supported(true), Price: &Price{1.25, 10.00}},
+
+	// mistral
+	"mistral-large-latest":  {SupportsTools: supported(true), Price: &Price{2.00, 6.00}},
+	"mistral-small-latest":  {SupportsTools: supported(true), Price: &Price{0.10, 0.30}},
+	"codestral-latest":      {SupportsTools: supported(true), Price: &Price{0.30, 0.90}},
+	"open-mistral-nemo":     {SupportsTools: supported(true), Price: &Price{0.15, 0.15}},
+	"mistral-medium-latest": {SupportsTools: supported(true), Price: &Price{0.40, 2.00}},
+	// groq
+	"llama-3.3-70b-versatile": {SupportsTools: supported(true), Price: &Price{0.59, 0.79}},
+	"llama-3.1-8b-instant":    {SupportsTools: supported(true), Price: &Price{0.05, 0.08}},
+	// together
+	"meta-llama/Llama-3.3-70B-Instruct-Turbo": {SupportsTools: supported(true), Price: &Price{0.88, 0.88}},
+	"deepseek-ai/DeepSeek-V3":                 {SupportsTools: supported(true), Price: &Price{1.25, 1.25}},
+}
+
+// providers holds per-provider entries, keyed by provider then model, for
+// models a provider serves with other limits or prices than the default.
+var providers = map[string]map[string]Model{}
+
+// Register records m as provider's entry for m.Name. It is meant for
+// startup wiring and is not safe to call concurrently with lookups.
+func Register(provider string, m Model) {
+	if providers[provider] == nil {
+		providers[provider] = make(map[string]Model)
+	}
+	providers[provider][m.Name] = m
+}
+
+// Lookup returns provider's entry for name, falling back to the built-in
+// one. An empty provider only consults the built-in entries.
+func Lookup(provider, name string) (Model, bool) {
+	if m, ok := providers[provider][name]; ok {
+		return m, true
+	}
+	m, ok := builtin[name]
+	m.Name = name
+	return m, ok
+}
+
+// ContextWindows returns the known context window of every model. Provider
+// entries take precedence over built-in ones; a model whose providers
+// declare different windows gets the smallest, so a request that fits may
+// go to any of them.
+func ContextWindows() map[string]int {
+	windows := make(map[string]int)
+	for _, entries := range providers {
+		for name, m := range entries {
+			if m.ContextWindow <= 0 {
+				continue
+			}
+			if n, ok := windows[name]; !ok || m.ContextWindow < n {
+				windows[name] = m.ContextWindow
+			}
+		}
+	}
+	for name, m := range builtin {
+		if _, ok := windows[name]; !ok && m.ContextWindow > 0 {
+			windows[name] = m.ContextWindow
+		}
+	}
+	return windows
+}
+
+// Models returns provider's entries for names, in order, with built-in
+// defaults for models it has no entry for.
+func Models(provider string, names []string) []Model {
+	out := make([]Model, 0, len(names))
+	for _, name := range names {
+		m, _ := Lookup(provider, name)
+		out = append(out, m)
+	}
+	return out
+}
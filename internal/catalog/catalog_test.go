@@ -0,0 +1,57 @@
+package catalog
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	m, ok := Lookup("", "gpt-4o")
+	if !ok || m.Name != "gpt-4o" || m.ContextWindow != 128_000 || m.Price == nil || m.Price.Input != 2.50 {
+		t.Fatalf("unexpected built-in entry %+v (%v)", m, ok)
+	}
+	if _, ok := Lookup("", "unknown-model"); ok {
+		t.Error("expected unknown model not to be found")
+	}
+
+	Register("lookup-cheap", Model{Name: "gpt-4o", ContextWindow: 64_000, Price: &Price{1, 4}})
+	if m, _ := Lookup("lookup-cheap", "gpt-4o"); m.ContextWindow != 64_000 || m.Price.Input != 1 {
+		t.Errorf("expected the provider entry, got %+v", m)
+	}
+	if m, _ := Lookup("lookup-other", "gpt-4o"); m.ContextWindow != 128_000 {
+		t.Errorf("expected other providers to fall back to the built-in entry, got %+v", m)
+	}
+}
+
+func TestContextWindows(t *testing.T) {
+	Register("windows-a", Model{Name: "windows-model", ContextWindow: 32_000})
+	Register("windows-b", Model{Name: "windows-model", ContextWindow: 8_000})
+	Register("windows-a", Model{Name: "gpt-4o-mini", ContextWindow: 256_000})
+	Register("windows-a", Model{Name: "windows-unknown"})
+
+	windows := ContextWindows()
+	if windows["windows-model"] != 8_000 {
+		t.Errorf("expected the smallest declared window, got %d", windows["windows-model"])
+	}
+	if windows["gpt-4o-mini"] != 256_000 {
+		t.Errorf("expected the provider window to override the built-in one, got %d", windows["gpt-4o-mini"])
+	}
+	if windows["claude-sonnet-4-5"] != 200_000 {
+		t.Errorf("expected built-in windows, got %d", windows["claude-sonnet-4-5"])
+	}
+	if _, ok := windows["windows-unknown"]; ok {
+		t.Error("expected models without a window to be left out")
+	}
+}
+
+func TestModel_Lacks(t *testing.T) {
+	m, _ := Lookup("", "mistral-large-latest")
+	if m.Lacks(true, false) {
+		t.Error("expected built-in tool support")
+	}
+	if m.Lacks(false, true) {
+		t.Error("expected unknown vision support to be assumed")
+	}
+	no := false
+	m.SupportsVision = &no
+	if !m.Lacks(true, true) || m.Lacks(false, false) {
+		t.Error("expected vision marked unsupported to be lacking")
+	}
+}
@@ -3,20 +3,434 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server    ServerConfig     `yaml:"server"`
-	Providers []ProviderConfig `yaml:"providers"`
-	Cache     CacheConfig      `yaml:"cache"`
+	Server      ServerConfig      `yaml:"server"`
+	Providers   []ProviderConfig  `yaml:"providers"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Fixtures    FixturesConfig    `yaml:"fixtures"`
+	Validation  ValidationConfig  `yaml:"validation"`
+	Savings     SavingsConfig     `yaml:"savings"`
+	Forward     ForwardConfig     `yaml:"forward"`
+	Alerts      AlertsConfig      `yaml:"alerts"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Guardrails  GuardrailsConfig  `yaml:"guardrails"`
+	Transforms  TransformsConfig  `yaml:"transforms"`
+	ReadThrough ReadThroughConfig `yaml:"read_through"`
+	Mirror      MirrorConfig      `yaml:"mirror"`
+	Routing     RoutingConfig     `yaml:"routing"`
+	Debug       DebugConfig       `yaml:"debug"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Tokenizer   TokenizerConfig   `yaml:"tokenizer"`
+	Chaos       ChaosConfig       `yaml:"chaos"`
+	AccessLog   AccessLogConfig   `yaml:"access_log"`
+
+	// SharedTransport is one upstream connection pool used by every
+	// provider without its own transport settings.
+	SharedTransport SharedTransportConfig `yaml:"shared_transport"`
+
+	// SystemPrompts are enforced on matching requests, in order.
+	SystemPrompts []SystemPromptConfig `yaml:"system_prompts"`
+
+	// Tenants maps tenant names to the API keys of their clients, for
+	// system prompt rules matching tenants.
+	Tenants map[string][]string `yaml:"tenants"`
+
+	// Profiles are named generation settings clients select with the
+	// X-QLite-Profile header.
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+
+	// DefaultModel is used for chat requests that omit model or set it to
+	// "auto". Empty keeps model required.
+	DefaultModel string `yaml:"default_model"`
+
+	// Deprecations warn about, and eventually retire, deprecated models.
+	Deprecations DeprecationsConfig `yaml:"deprecations"`
+
+	// Continuation continues responses cut off by max_tokens for clients
+	// that opt in.
+	Continuation ContinuationConfig `yaml:"continuation"`
+
+	// Async queues chat requests for background processing.
+	Async AsyncConfig `yaml:"async"`
+
+	// RateLimit caps chat requests per API key.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RateLimitConfig allows Requests chat requests per API key in each Window
+// (default 1m); 0 disables rate limiting. With Redis.Addr set, counts are
+// kept in Redis and shared by every replica using it.
+type RateLimitConfig struct {
+	Requests int           `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+	Redis    RedisConfig   `yaml:"redis"`
+}
+
+// RedisConfig connects to a Redis server at Addr (host:port). Each command
+// must complete within Timeout (default 100ms); after a failure, local state
+// is used instead until Redis is tried again RetryInterval (default 5s)
+// later. Keys start with KeyPrefix (default "qlite:ratelimit:").
+type RedisConfig struct {
+	Addr          string        `yaml:"addr"`
+	Password      string        `yaml:"password"`
+	DB            int           `yaml:"db"`
+	Timeout       time.Duration `yaml:"timeout"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	KeyPrefix     string        `yaml:"key_prefix"`
+}
+
+// AsyncConfig enables POST /v1/async/chat/completions: requests are queued,
+// up to MaxQueue (default 1000), and run through the pipeline by Workers
+// (default 4). Results are kept for polling at GET /v1/async/jobs/{id} for
+// TTL (default 1h) after a job finishes. With Callbacks, clients may name a
+// URL in X-QLite-Callback-URL that the finished job is POSTed to. Its host
+// must be in CallbackHosts or, with none listed, have only public
+// addresses.
+type AsyncConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Workers       int           `yaml:"workers"`
+	MaxQueue      int           `yaml:"max_queue"`
+	TTL           time.Duration `yaml:"ttl"`
+	Callbacks     bool          `yaml:"callbacks"`
+	CallbackHosts []string      `yaml:"callback_hosts"`
+}
+
+// ContinuationConfig lets clients opt in, with the X-QLite-Continue header,
+// to having a response that stopped with finish_reason length continued by
+// up to MaxRounds follow-up requests (default 0, off), stitched into one
+// response. Prompt is the user message asking the model to continue; empty
+// uses a built-in one.
+type ContinuationConfig struct {
+	MaxRounds int    `yaml:"max_rounds"`
+	Prompt    string `yaml:"prompt"`
+}
+
+// DeprecationsConfig lists deprecated models. With Log, every request for
+// one is logged.
+type DeprecationsConfig struct {
+	Log    bool                     `yaml:"log"`
+	Models []ModelDeprecationConfig `yaml:"models"`
+}
+
+// ModelDeprecationConfig marks Model as deprecated in favor of Replacement.
+// From Sunset (YYYY-MM-DD, UTC; empty never) requests for it are rejected,
+// or with Action rewrite sent to Replacement. Action defaults to reject.
+type ModelDeprecationConfig struct {
+	Model       string `yaml:"model"`
+	Replacement string `yaml:"replacement"`
+	Sunset      string `yaml:"sunset"`
+	Action      string `yaml:"action"`
+}
+
+// MirrorConfig asynchronously replays Percent (0-100) of chat requests to
+// URL, another qlite instance or compatible endpoint, discarding its
+// responses. Each mirrored request is abandoned after Timeout (default 30s)
+// and at most MaxInFlight (default 64) run at once; extra requests are not
+// mirrored. Empty URL disables mirroring.
+type MirrorConfig struct {
+	URL         string        `yaml:"url"`
+	Percent     float64       `yaml:"percent"`
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxInFlight int           `yaml:"max_in_flight"`
+}
+
+// AdminConfig moves the /admin/* endpoints off the main port onto their own
+// listeners: Port (TCP) and/or Socket (a Unix socket path, created with mode
+// 0600). With neither set, admin endpoints are served on server.port as
+// before. Wherever they are served, admin requests must carry
+// "Authorization: Bearer <Token>" when Token is non-empty, and a client
+// certificate issued by a CA in ClientCAFile (PEM) when that is set. Client
+// certificates need server TLS; separate admin listeners then serve TLS
+// with the server's certificate too.
+type AdminConfig struct {
+	Port         int    `yaml:"port"`
+	Socket       string `yaml:"socket"`
+	Token        string `yaml:"token"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Separate reports whether admin endpoints have their own listeners.
+func (a AdminConfig) Separate() bool {
+	return a.Port != 0 || a.Socket != ""
+}
+
+// DebugConfig keeps a diagnostic bundle (sanitized request, stage timings,
+// cache decisions, provider and upstream outcome) for each of the last
+// Requests chat requests, served at GET /admin/debug/requests/{id}. Message
+// content is reduced to its length unless IncludeContent is set. Zero
+// Requests disables it.
+type DebugConfig struct {
+	Requests       int  `yaml:"requests"`
+	IncludeContent bool `yaml:"include_content"`
+}
+
+// TokenizerConfig controls the tiktoken encodings used to count tokens. The
+// encodings of the configured providers' models are loaded at startup unless
+// Lazy is set, in which case each loads on first use. BPEDir, if set, holds
+// the encoding files (o200k_base.tiktoken, ...) to read instead of
+// downloading them. Otherwise downloads are cached in CacheDir (default
+// $TIKTOKEN_CACHE_DIR); with Offline nothing is downloaded and encodings
+// missing from the cache are unavailable. Models whose encoding is
+// unavailable are counted with a len/4 estimate.
+type TokenizerConfig struct {
+	Lazy     bool   `yaml:"lazy"`
+	BPEDir   string `yaml:"bpe_dir"`
+	CacheDir string `yaml:"cache_dir"`
+	Offline  bool   `yaml:"offline"`
+
+	// CountTokens asks Anthropic for the input tokens of streaming requests.
+	CountTokens CountTokensConfig `yaml:"count_tokens"`
+}
+
+// CountTokensConfig makes streaming requests for models served by an
+// anthropic provider report the input tokens Anthropic's count_tokens
+// endpoint returns in X-Tokens-Input, instead of a len/4 estimate. That is
+// an extra upstream round trip before the stream starts, bounded by Timeout
+// (default 2s), after which the estimate is used. Counts are cached for
+// CacheTTL (default 10m), for up to CacheSize (default 1000) prompts.
+type CountTokensConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Timeout   time.Duration `yaml:"timeout"`
+	CacheSize int           `yaml:"cache_size"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+}
+
+// ChaosConfig injects faults for resilience testing; never enable it in
+// production. Before each pipeline stage and upstream call a random delay of
+// up to MaxDelay (default 1s) is added with probability DelayRate, and the
+// call fails with probability ErrorRate. Each streamed chunk is dropped with
+// probability DropRate. Seed makes a sequential run reproducible; 0 picks a
+// random seed, reported by /admin/chaos.
+type ChaosConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Seed      int64         `yaml:"seed"`
+	DelayRate float64       `yaml:"delay_rate"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	ErrorRate float64       `yaml:"error_rate"`
+	DropRate  float64       `yaml:"drop_rate"`
+}
+
+// ReadThroughConfig serves GET /v1/models and POST /v1/embeddings by
+// forwarding them to Provider (an OpenAI-compatible provider, whose base_url
+// and api_key are reused) and caching successful responses for ModelsTTL
+// (default 10m) and EmbeddingsTTL (default 24h). Empty Provider disables it.
+type ReadThroughConfig struct {
+	Provider      string        `yaml:"provider"`
+	ModelsTTL     time.Duration `yaml:"models_ttl"`
+	EmbeddingsTTL time.Duration `yaml:"embeddings_ttl"`
+}
+
+// TransformsConfig rewrites streamed deltas before they reach the client.
+// MaskWords replaces whole-word, case-insensitive matches with asterisks.
+type TransformsConfig struct {
+	MaskWords []string `yaml:"mask_words"`
+}
+
+// GuardrailsConfig checks each request's estimated input tokens plus
+// max_tokens against the model's context window before calling upstream.
+// ContextWindows overrides or extends the built-in per-model windows. Action
+// is reject (default, 400 context_length_exceeded) or truncate (drop the
+// oldest non-system messages until the request fits).
+type GuardrailsConfig struct {
+	Enabled        bool           `yaml:"enabled"`
+	Action         string         `yaml:"action"`
+	ContextWindows map[string]int `yaml:"context_windows"`
+}
+
+// SystemPromptConfig adds Prompt as a system message to requests whose API
+// key is in APIKeys and belongs to a tenant in Tenants (an empty list
+// matches anything). Position is prepend (default) or append. With
+// HideFromCache the prompt is added only when calling the provider, so it is
+// not part of cache keys.
+type SystemPromptConfig struct {
+	Name          string   `yaml:"name"`
+	APIKeys       []string `yaml:"api_keys"`
+	Tenants       []string `yaml:"tenants"`
+	Prompt        string   `yaml:"prompt"`
+	Position      string   `yaml:"position"`
+	HideFromCache bool     `yaml:"hide_from_cache"`
+}
+
+// ProfileConfig is a request profile. Temperature and MaxTokens, when set,
+// replace the client's values; SystemPrompt is added as a system message at
+// Position, prepend (default) or append; Models maps model aliases to the
+// models they stand for.
+type ProfileConfig struct {
+	Temperature  *float64          `yaml:"temperature"`
+	MaxTokens    *int              `yaml:"max_tokens"`
+	SystemPrompt string            `yaml:"system_prompt"`
+	Position     string            `yaml:"position"`
+	Models       map[string]string `yaml:"models"`
+}
+
+// IdempotencyConfig enables Idempotency-Key replay. Results are kept for TTL
+// (default 10m) in a store separate from the response caches.
+type IdempotencyConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// AccessLogConfig writes one line per proxied request to Path, separate from
+// the application log. Format is json (JSON Lines with Fields, default all)
+// or common (Common Log Format). The file is rotated once it would grow past
+// MaxBytes (default 100 MiB) or has been open for MaxAge (0 never), keeping
+// the newest MaxBackups rotated files (default 5). Empty Path disables it.
+type AccessLogConfig struct {
+	Path       string        `yaml:"path"`
+	Format     string        `yaml:"format"`
+	Fields     []string      `yaml:"fields"`
+	MaxBytes   int64         `yaml:"max_bytes"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBackups int           `yaml:"max_backups"`
+}
+
+// accessLogFields must match server.AccessLogFields.
+var accessLogFields = []string{
+	"time", "request_id", "remote_addr", "method", "path", "proto", "status",
+	"bytes", "duration_ms", "upstream_latency_ms", "cache", "provider",
+	"cost", "tokens_in", "tokens_out", "user_agent",
+}
+
+// AlertsConfig enables usage alert rules evaluated every Interval (default
+// 1m) and posted to WebhookURL (Slack format when Slack is set). A rule that
+// stays breached is re-sent at most once per Cooldown (default 1h).
+type AlertsConfig struct {
+	WebhookURL string            `yaml:"webhook_url"`
+	Slack      bool              `yaml:"slack"`
+	Interval   time.Duration     `yaml:"interval"`
+	Cooldown   time.Duration     `yaml:"cooldown"`
+	Rules      []AlertRuleConfig `yaml:"rules"`
+}
+
+// AlertRuleConfig fires when Metric (cache_hit_rate, daily_spend,
+// provider_error_rate, deprecated_requests, slow_clients, rate_limited or
+// provider_quota_remaining) goes below Below or above Above. Rates are
+// percentages over the last interval, evaluated once MinSamples requests
+// were seen; deprecated_requests counts requests for deprecated models,
+// slow_clients streams abandoned for slow clients and rate_limited requests
+// rejected by rate_limit over the last interval, and
+// provider_quota_remaining is the lowest percentage of a rate limit any
+// upstream reports as left.
+type AlertRuleConfig struct {
+	Name       string   `yaml:"name"`
+	Metric     string   `yaml:"metric"`
+	Below      *float64 `yaml:"below"`
+	Above      *float64 `yaml:"above"`
+	MinSamples uint64   `yaml:"min_samples"`
+}
+
+// ForwardConfig maps incoming client headers onto the upstream request so
+// per-user abuse monitoring and analytics survive the proxy hop.
+type ForwardConfig struct {
+	// UserHeader (e.g. X-User-Id) populates the request's "user" field.
+	UserHeader string `yaml:"user_header"`
+	// MetadataHeaders maps header names to metadata keys (e.g. X-Org: org).
+	MetadataHeaders map[string]string `yaml:"metadata_headers"`
+}
+
+// SavingsConfig enables the persistent daily cost/savings rollup served at
+// /admin/savings. Disabled when Path is empty.
+type SavingsConfig struct {
+	Path          string        `yaml:"path"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// MaxTags bounds the distinct X-QLite-Tags name=value pairs that get a
+	// row of their own per day (default 1000); the rest count as "_other".
+	MaxTags int `yaml:"max_tags"`
+}
+
+// ValidationConfig controls handling of malformed upstream responses.
+type ValidationConfig struct {
+	// Retries is how many times a malformed non-streaming response is retried
+	// before returning an error.
+	Retries int `yaml:"retries"`
+	// RejectEmpty also treats a response with empty content and
+	// finish_reason "stop" as malformed. Off by default.
+	RejectEmpty bool `yaml:"reject_empty"`
+	// Schema handles request fields qlite doesn't model and would drop:
+	// off (default), permissive (log them and list them in
+	// X-QLite-Dropped-Fields) or strict (reject the request). Outside off,
+	// dropped OpenAI-compatible response fields are logged once each.
+	Schema string `yaml:"schema"`
+}
+
+// FixturesConfig enables recording upstream responses to disk ("record") or
+// serving them back without network access ("replay").
+type FixturesConfig struct {
+	Mode string `yaml:"mode"`
+	Dir  string `yaml:"dir"`
 }
 
 type CacheConfig struct {
 	Exact    ExactCacheConfig    `yaml:"exact"`
 	Semantic SemanticCacheConfig `yaml:"semantic"`
+	Volatile VolatileConfig      `yaml:"volatile"`
+
+	// MaxTemperature is the highest temperature still cached by both caches
+	// (default 0: any temperature > 0 bypasses caching).
+	MaxTemperature float64 `yaml:"max_temperature"`
+	// IgnoreTopP excludes top_p from the exact-cache key.
+	IgnoreTopP bool `yaml:"ignore_top_p"`
+
+	Store StoreFilterConfig `yaml:"store"`
+
+	// FingerprintInvalidation drops cached responses whose
+	// system_fingerprint differs from the latest one seen live for their
+	// model, so answers from an outdated backend configuration aren't served.
+	FingerprintInvalidation bool `yaml:"fingerprint_invalidation"`
+
+	// TruncateReplays cuts cached answers longer than the max_tokens of the
+	// request they are served to down to that limit, with finish_reason
+	// "length" and recounted usage.
+	TruncateReplays bool `yaml:"truncate_replays"`
+
+	Compression CompressionConfig `yaml:"compression"`
+	Feedback    FeedbackConfig    `yaml:"feedback"`
+}
+
+// FeedbackConfig enables POST /v1/feedback. An answer rated down by
+// requests from Threshold API keys (default 2) is no longer served by
+// either cache. Requests can be rated for Window after they were served
+// (default 24h), and at most MaxRequests (default 10000) requests, and as
+// many excluded answers, are remembered.
+type FeedbackConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Threshold   int           `yaml:"threshold"`
+	Window      time.Duration `yaml:"window"`
+	MaxRequests int           `yaml:"max_requests"`
+}
+
+// CompressionConfig stores cached responses gzipped, in the exact cache and
+// in Qdrant payloads, once their JSON encoding reaches MinBytes (default
+// 4096). Smaller responses compress poorly and are stored as is.
+type CompressionConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	MinBytes int  `yaml:"min_bytes"`
+}
+
+// StoreFilterConfig decides which responses both caches refuse to store.
+type StoreFilterConfig struct {
+	// SkipFinishReasons defaults to [length, content_filter].
+	SkipFinishReasons []string `yaml:"skip_finish_reasons"`
+	// AllowEmpty stores responses with empty content (off by default).
+	AllowEmpty bool `yaml:"allow_empty"`
+	// MaxResponseBytes skips storing responses whose content exceeds this
+	// many bytes; they are still served. 0 means no limit.
+	MaxResponseBytes int `yaml:"max_response_bytes"`
+}
+
+// VolatileConfig lists regexes for volatile prompt content (timestamps,
+// request IDs, nonces). Action "bypass" skips caching for matching requests;
+// "strip" removes the matched spans from the cache key.
+type VolatileConfig struct {
+	Patterns []string `yaml:"patterns"`
+	Action   string   `yaml:"action"`
 }
 
 type SemanticCacheConfig struct {
@@ -28,48 +442,361 @@ type SemanticCacheConfig struct {
 	QdrantURL       string  `yaml:"qdrant_url"`
 	QdrantAPIKey    string  `yaml:"qdrant_api_key"`
 	QdrantCollection string `yaml:"qdrant_collection"`
+	// Lookahead starts the embedding concurrently with the exact-cache
+	// check rather than after it misses.
+	Lookahead bool `yaml:"lookahead"`
+	// WarmExact loads up to this many of the most recent semantic entries
+	// into the exact cache at startup. Only entries stored while the exact
+	// cache was enabled carry the key needed for this.
+	WarmExact int `yaml:"warm_exact"`
+	// StorePrompt keeps the embedded prompt in each Qdrant payload for
+	// /admin/semantic/inspect: full, truncated (to PromptMaxChars, default
+	// 500) or hashed. Empty stores no prompt.
+	StorePrompt    string `yaml:"store_prompt"`
+	PromptMaxChars int    `yaml:"prompt_max_chars"`
+	// CompatibleModels lets cached answers from other models serve a
+	// requested model, e.g. gpt-4o-mini: [gpt-4o].
+	CompatibleModels map[string][]string `yaml:"compatible_models"`
+	// EmbeddingFallbacks are tried in order when the primary embedding
+	// endpoint fails. A failed endpoint is skipped for EmbeddingCooldown
+	// (default 30s).
+	EmbeddingFallbacks []EmbeddingEndpointConfig `yaml:"embedding_fallbacks"`
+	EmbeddingCooldown  time.Duration             `yaml:"embedding_cooldown"`
+	// EmbeddingTimeout (default 5s) bounds each embedding call, plus
+	// EmbeddingTimeoutPer1K for every 1000 estimated prompt tokens.
+	// Prompts above MaxPromptTokens skip the semantic cache.
+	EmbeddingTimeout      time.Duration `yaml:"embedding_timeout"`
+	EmbeddingTimeoutPer1K time.Duration `yaml:"embedding_timeout_per_1k_tokens"`
+	MaxPromptTokens       int           `yaml:"max_prompt_tokens"`
+	// DedupResponses stores textually identical answers for a model once;
+	// further prompts with the same answer point at the stored copy.
+	DedupResponses bool `yaml:"dedup_responses"`
+	// LatencyFloor is the lowest similarity at which a cached answer may
+	// stand in for a request whose X-QLite-Max-Latency budget ran out.
+	// Zero serves only entries above threshold.
+	LatencyFloor float32 `yaml:"latency_floor"`
+	// Race tunes how the lookup is raced against dispatch.
+	Race RaceConfig `yaml:"race"`
+}
+
+// RaceConfig tunes the race between the semantic lookup and dispatch.
+type RaceConfig struct {
+	// Grace bounds how long dispatch output waits for the lookup before
+	// it is sent anyway; zero waits for the lookup.
+	Grace time.Duration `yaml:"grace"`
+	// CancelOnHit (default true) cancels dispatch when the lookup hits.
+	// When false, a non-streaming upstream call finishes in the background,
+	// which keeps its connection reusable, and its response is stored.
+	// Streams are cancelled either way.
+	CancelOnHit *bool `yaml:"cancel_on_hit"`
+	// SequentialProviders aren't raced: for requests routed to them the
+	// lookup runs first and dispatch only on a miss.
+	SequentialProviders []string `yaml:"sequential_providers"`
+}
+
+// EmbeddingEndpointConfig is a fallback embedding endpoint. Model defaults
+// to the primary embedding_model; it must produce vectors of the same size.
+type EmbeddingEndpointConfig struct {
+	URL   string `yaml:"url"`
+	Key   string `yaml:"key"`
+	Model string `yaml:"model"`
 }
 
 type ExactCacheConfig struct {
 	Enabled    bool          `yaml:"enabled"`
 	TTL        time.Duration `yaml:"ttl"`
 	MaxEntries int           `yaml:"max_entries"`
+	Normalize  bool          `yaml:"normalize"`
+	// KeyFormat is v2 (default, direct hashing) or v1 (the JSON-based
+	// keys of earlier releases). Switching invalidates existing entries.
+	KeyFormat string `yaml:"key_format"`
+
+	AdaptiveTTL AdaptiveTTLConfig `yaml:"adaptive_ttl"`
+}
+
+// AdaptiveTTLConfig lets entry lifetimes follow their hits instead of all
+// lasting TTL: entries never hit expire after UnhitTTL (default TTL/4), the
+// first hit extends that to TTL, and each further hit doubles it, up to
+// MaxTTL (default 8×TTL).
+type AdaptiveTTLConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	UnhitTTL time.Duration `yaml:"unhit_ttl"`
+	MaxTTL   time.Duration `yaml:"max_ttl"`
 }
 
 type ServerConfig struct {
 	Port         int           `yaml:"port"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
+	TLSCertFile  string        `yaml:"tls_cert_file"`
+	TLSKeyFile   string        `yaml:"tls_key_file"`
+	H2C          bool          `yaml:"h2c"`
+	SSEHeartbeat time.Duration `yaml:"sse_heartbeat"`
+	// SSEMetadata starts each stream with a ": qlite {...}" comment carrying
+	// request_id, provider and cache status.
+	SSEMetadata bool `yaml:"sse_metadata"`
+	// SSEWriteTimeout, if set, queues streamed events for the client, up to
+	// SSEMaxBuffered bytes (default 1MiB), and bounds each write to it. A
+	// client that falls further behind is disconnected and the upstream
+	// cancelled.
+	SSEWriteTimeout time.Duration `yaml:"sse_write_timeout"`
+	SSEMaxBuffered  int           `yaml:"sse_max_buffered"`
+
+	// MaxConcurrent caps in-flight chat requests (0 = unlimited). Overflow
+	// waits in a queue of MaxQueue for up to QueueTimeout (default 30s).
+	MaxConcurrent int           `yaml:"max_concurrent"`
+	MaxQueue      int           `yaml:"max_queue"`
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`
+
+	// ShutdownTimeout (default 30s) is the grace period on SIGTERM: open
+	// requests finish first, then async work (semantic stores, mirrored
+	// requests, the savings flush) is flushed in what remains.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// TLSEnabled reports whether the listener should serve HTTPS (and HTTP/2 over TLS).
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
 }
 
 type ProviderConfig struct {
-	Name    string   `yaml:"name"`
-	Type    string   `yaml:"type"`
-	BaseURL string   `yaml:"base_url"`
-	APIKey  string   `yaml:"api_key"`
-	Models  []string `yaml:"models"`
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	BaseURL string `yaml:"base_url"`
+	APIKey  string `yaml:"api_key"`
+
+	// Models lists the models the provider serves, each either a name or
+	// an entry describing it (see ModelConfig).
+	Models []ModelConfig `yaml:"models"`
+
+	// Discovery registers further models the upstream lists.
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	// Health probes the upstream in the background.
+	Health HealthConfig `yaml:"health"`
+
+	// Static headers and query parameters added to every upstream request.
+	Headers     map[string]string `yaml:"headers"`
+	QueryParams map[string]string `yaml:"query_params"`
+
+	// Vertex AI ("vertex" type) settings. Credentials default to ADC.
+	Project         string `yaml:"project"`
+	Region          string `yaml:"region"`
+	CredentialsFile string `yaml:"credentials_file"`
+
+	// Pricing overrides this provider's prices, in USD per 1M tokens, for
+	// the cheapest routing policy and cost reporting.
+	Pricing map[string]PriceConfig `yaml:"pricing"`
+
+	// Transport tunes this provider's upstream connection pool.
+	Transport TransportConfig `yaml:"transport"`
+
+	// APIVersion pins the upstream API version: the anthropic-version header
+	// for anthropic (default 2023-06-01), or the Gemini path version for
+	// google and vertex (v1, v1beta, ...; defaults come from base_url).
+	// Betas are sent as Anthropic's anthropic-beta header.
+	APIVersion string   `yaml:"api_version"`
+	Betas      []string `yaml:"betas"`
+
+	// NoStore names a response header and/or top-level response field
+	// (e.g. x-no-cache set by an internal gateway) that, when set to a value
+	// other than empty, false, no or 0, keeps the response out of both
+	// caches.
+	NoStore NoStoreConfig `yaml:"no_store"`
+
+	// IncludeUsage sets whether streaming requests to an OpenAI-compatible
+	// provider send stream_options include_usage. Unset sends it unless the
+	// type's preset knows the vendor rejects it. Upstreams that reject it
+	// anyway are retried without it, and streams that end without usage
+	// have it estimated with the tokenizer.
+	IncludeUsage *bool `yaml:"include_usage"`
+
+	// Auth authenticates upstream requests beyond the static api_key, for
+	// gateways that require signed requests or OAuth tokens.
+	Auth ProviderAuthConfig `yaml:"auth"`
+}
+
+// ProviderAuthConfig selects how upstream requests are authenticated. Type
+// hmac signs each request with Secret, sending the signature in Header
+// (default X-Signature), the Unix timestamp signed in TimestampHeader
+// (default X-Signature-Timestamp) and KeyID, if set, in KeyIDHeader
+// (default X-Signature-Key-Id). Type oauth2 sends a bearer token obtained
+// from TokenURL with the client credentials grant, cached until shortly
+// before it expires.
+type ProviderAuthConfig struct {
+	Type string `yaml:"type"`
+
+	Secret          string `yaml:"secret"`
+	KeyID           string `yaml:"key_id"`
+	Header          string `yaml:"header"`
+	TimestampHeader string `yaml:"timestamp_header"`
+	KeyIDHeader     string `yaml:"key_id_header"`
+
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// DiscoveryConfig registers the models an OpenAI-compatible upstream lists
+// at GET /models whose names match one of Allow (regular expressions; none
+// allows every model), at startup and then every Interval (0: only at
+// startup). Models are added, never removed. Intervals vary at random by
+// up to 20%, so replicas don't refresh in step.
+type DiscoveryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Allow    []string      `yaml:"allow"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// HealthConfig probes an OpenAI-compatible upstream by listing its models
+// at GET /models about every Interval (default 30s, jittered like
+// discovery), each probe bounded by Timeout (default 5s). The last result
+// is reported by /admin/providers.
+type HealthConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// ModelConfig describes a model a provider serves. A plain string is the
+// model's name alone. Unset fields keep the built-in values for the model,
+// if qlite has any: context windows feed the context guardrails, prices
+// (USD per 1M tokens) feed the cheapest routing policy and cost reporting.
+// The capability flags are informational and listed by /admin/providers.
+type ModelConfig struct {
+	Name           string   `yaml:"name"`
+	ContextWindow  int      `yaml:"context_window"`
+	SupportsTools  *bool    `yaml:"supports_tools"`
+	SupportsVision *bool    `yaml:"supports_vision"`
+	InputPrice     *float64 `yaml:"input_price"`
+	OutputPrice    *float64 `yaml:"output_price"`
+}
+
+// UnmarshalYAML accepts a model name as well as a full entry.
+func (m *ModelConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*m = ModelConfig{Name: value.Value}
+		return nil
+	}
+	type plain ModelConfig
+	return value.Decode((*plain)(m))
+}
+
+// ModelNames returns the names of the models p serves.
+func (p ProviderConfig) ModelNames() []string {
+	names := make([]string, len(p.Models))
+	for i, m := range p.Models {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// NoStoreConfig names the upstream signals that a response must not be cached.
+type NoStoreConfig struct {
+	Header string `yaml:"header"`
+	Field  string `yaml:"field"`
+}
+
+// SharedTransportConfig enables a single transport, tuned like a provider's
+// transport block, shared by all providers that don't set their own. Fewer
+// pools means fewer idle sockets when many providers are configured.
+type SharedTransportConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	TransportConfig `yaml:",inline"`
+}
+
+// TransportConfig tunes a provider's HTTP transport. Zero values keep the
+// built-in defaults (1000 idle connections, 90s idle timeout, 32KiB buffers,
+// HTTP/2 when the upstream offers it, no dial or header timeouts).
+type TransportConfig struct {
+	MaxIdleConns          int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	WriteBufferSize       int           `yaml:"write_buffer_size"`
+	ReadBufferSize        int           `yaml:"read_buffer_size"`
+	DisableHTTP2          bool          `yaml:"disable_http2"`
 }
 
+// PriceConfig is a model price in USD per 1M tokens.
+type PriceConfig struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// RoutingConfig picks among providers serving the same model. Policy is
+// first (default: the first provider listed for the model) or cheapest (the
+// lowest-priced provider not failing within Cooldown, default 30s).
+// StreamRetries retries a stream that fails before its first chunk, on
+// another provider serving the model when there is one (default 0, off).
+// The cheapest policy skips providers whose upstream reported an exhausted
+// rate limit and ranks those with less than QuotaReserve (0 to 1, default 0)
+// of a limit left after the rest.
+type RoutingConfig struct {
+	Policy        string        `yaml:"policy"`
+	Cooldown      time.Duration `yaml:"cooldown"`
+	StreamRetries int           `yaml:"stream_retries"`
+	QuotaReserve  float64       `yaml:"quota_reserve"`
+}
+
+func (t TransportConfig) validate(field string) error {
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 ||
+		t.WriteBufferSize < 0 || t.ReadBufferSize < 0 {
+		return fmt.Errorf("%s sizes must be non-negative", field)
+	}
+	if t.IdleConnTimeout < 0 || t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("%s timeouts must be non-negative", field)
+	}
+	return nil
+}
+
+// presetTypes are provider types with a built-in default base URL
+// (see provider.Presets).
+var presetTypes = map[string]bool{"mistral": true, "groq": true, "together": true}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
+	return Parse(data)
+}
 
-	expanded := os.ExpandEnv(string(data))
+// Parse builds a config from a YAML document, exactly as Load does for a
+// file: ${VAR} references are expanded, QLITE_* overrides and defaults are
+// applied, and the result is validated.
+func Parse(data []byte) (*Config, error) {
+	return ParseRaw([]byte(os.ExpandEnv(string(data))))
+}
 
+// ParseRaw is Parse without expanding ${VAR} references, for documents
+// from outside the deployment, such as admin API bodies, which must not be
+// able to read the process environment.
+func ParseRaw(data []byte) (*Config, error) {
 	var cfg Config
-	if err := yaml.Unmarshal([]byte(expanded), &cfg); err != nil {
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
 
-	applyDefaults(&cfg)
+	return finish(&cfg)
+}
+
+// finish applies QLITE_* environment overrides and defaults, then validates.
+func finish(cfg *Config) (*Config, error) {
+	if err := applyEnv(cfg, os.Environ()); err != nil {
+		return nil, fmt.Errorf("applying environment overrides: %w", err)
+	}
+
+	applyDefaults(cfg)
 
-	if err := validate(&cfg); err != nil {
+	if err := validate(cfg); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
 
-	return &cfg, nil
+	return cfg, nil
 }
 
 func applyDefaults(cfg *Config) {
@@ -82,12 +809,149 @@ func applyDefaults(cfg *Config) {
 	if cfg.Server.WriteTimeout == 0 {
 		cfg.Server.WriteTimeout = 120 * time.Second
 	}
+	if cfg.Async.Workers == 0 {
+		cfg.Async.Workers = 4
+	}
+	if cfg.Async.MaxQueue == 0 {
+		cfg.Async.MaxQueue = 1000
+	}
+	if cfg.Async.TTL == 0 {
+		cfg.Async.TTL = time.Hour
+	}
+	if cfg.Idempotency.TTL == 0 {
+		cfg.Idempotency.TTL = 10 * time.Minute
+	}
+	if c := &cfg.Tokenizer.CountTokens; c.Enabled {
+		if c.Timeout == 0 {
+			c.Timeout = 2 * time.Second
+		}
+		if c.CacheSize == 0 {
+			c.CacheSize = 1000
+		}
+		if c.CacheTTL == 0 {
+			c.CacheTTL = 10 * time.Minute
+		}
+	}
+	if cfg.Routing.Policy == "" {
+		cfg.Routing.Policy = "first"
+	}
+	if cfg.Routing.Cooldown == 0 {
+		cfg.Routing.Cooldown = 30 * time.Second
+	}
+	if cfg.Mirror.Timeout == 0 {
+		cfg.Mirror.Timeout = 30 * time.Second
+	}
+	if cfg.Mirror.MaxInFlight == 0 {
+		cfg.Mirror.MaxInFlight = 64
+	}
+	if cfg.AccessLog.Format == "" {
+		cfg.AccessLog.Format = "json"
+	}
+	if cfg.AccessLog.MaxBytes == 0 {
+		cfg.AccessLog.MaxBytes = 100 << 20
+	}
+	if cfg.AccessLog.MaxBackups == 0 {
+		cfg.AccessLog.MaxBackups = 5
+	}
+	if cfg.Chaos.MaxDelay == 0 {
+		cfg.Chaos.MaxDelay = time.Second
+	}
+	if cfg.Guardrails.Action == "" {
+		cfg.Guardrails.Action = "reject"
+	}
+	if cfg.Cache.Semantic.PromptMaxChars == 0 {
+		cfg.Cache.Semantic.PromptMaxChars = 500
+	}
+	if cfg.ReadThrough.ModelsTTL == 0 {
+		cfg.ReadThrough.ModelsTTL = 10 * time.Minute
+	}
+	if cfg.ReadThrough.EmbeddingsTTL == 0 {
+		cfg.ReadThrough.EmbeddingsTTL = 24 * time.Hour
+	}
+	if cfg.Alerts.Interval == 0 {
+		cfg.Alerts.Interval = time.Minute
+	}
+	if cfg.Alerts.Cooldown == 0 {
+		cfg.Alerts.Cooldown = time.Hour
+	}
+	for i := range cfg.Deprecations.Models {
+		if cfg.Deprecations.Models[i].Action == "" {
+			cfg.Deprecations.Models[i].Action = "reject"
+		}
+	}
+	for i := range cfg.Alerts.Rules {
+		if cfg.Alerts.Rules[i].Name == "" {
+			cfg.Alerts.Rules[i].Name = cfg.Alerts.Rules[i].Metric
+		}
+	}
+	if cfg.Server.SSEMaxBuffered == 0 {
+		cfg.Server.SSEMaxBuffered = 1 << 20
+	}
+	if cfg.Server.QueueTimeout == 0 {
+		cfg.Server.QueueTimeout = 30 * time.Second
+	}
+	if cfg.Server.ShutdownTimeout == 0 {
+		cfg.Server.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.RateLimit.Window == 0 {
+		cfg.RateLimit.Window = time.Minute
+	}
+	if r := &cfg.RateLimit.Redis; r.Addr != "" {
+		if r.Timeout == 0 {
+			r.Timeout = 100 * time.Millisecond
+		}
+		if r.RetryInterval == 0 {
+			r.RetryInterval = 5 * time.Second
+		}
+		if r.KeyPrefix == "" {
+			r.KeyPrefix = "qlite:ratelimit:"
+		}
+	}
+	if cfg.Validation.Schema == "" {
+		cfg.Validation.Schema = "off"
+	}
+	for i := range cfg.Providers {
+		if h := &cfg.Providers[i].Health; h.Enabled {
+			if h.Interval == 0 {
+				h.Interval = 30 * time.Second
+			}
+			if h.Timeout == 0 {
+				h.Timeout = 5 * time.Second
+			}
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if p.Position == "" {
+			p.Position = "prepend"
+			cfg.Profiles[name] = p
+		}
+	}
+	for i := range cfg.SystemPrompts {
+		if cfg.SystemPrompts[i].Position == "" {
+			cfg.SystemPrompts[i].Position = "prepend"
+		}
+	}
 	if cfg.Cache.Exact.TTL == 0 {
 		cfg.Cache.Exact.TTL = time.Hour
 	}
 	if cfg.Cache.Exact.MaxEntries == 0 {
 		cfg.Cache.Exact.MaxEntries = 10000
 	}
+	if cfg.Cache.Compression.MinBytes == 0 {
+		cfg.Cache.Compression.MinBytes = 4096
+	}
+	if cfg.Cache.Feedback.Threshold == 0 {
+		cfg.Cache.Feedback.Threshold = 2
+	}
+	if cfg.Cache.Feedback.Window == 0 {
+		cfg.Cache.Feedback.Window = 24 * time.Hour
+	}
+	if cfg.Cache.Feedback.MaxRequests == 0 {
+		cfg.Cache.Feedback.MaxRequests = 10000
+	}
+	if cfg.Cache.Exact.KeyFormat == "" {
+		cfg.Cache.Exact.KeyFormat = "v2"
+	}
 	if cfg.Cache.Semantic.Threshold == 0 {
 		cfg.Cache.Semantic.Threshold = 0.95
 	}
@@ -97,6 +961,29 @@ func applyDefaults(cfg *Config) {
 	if cfg.Cache.Semantic.EmbeddingURL == "" {
 		cfg.Cache.Semantic.EmbeddingURL = "https://api.openai.com/v1"
 	}
+	if cfg.Cache.Semantic.EmbeddingTimeout == 0 {
+		cfg.Cache.Semantic.EmbeddingTimeout = 5 * time.Second
+	}
+	if cfg.Cache.Semantic.EmbeddingCooldown == 0 {
+		cfg.Cache.Semantic.EmbeddingCooldown = 30 * time.Second
+	}
+	for i := range cfg.Cache.Semantic.EmbeddingFallbacks {
+		if cfg.Cache.Semantic.EmbeddingFallbacks[i].Model == "" {
+			cfg.Cache.Semantic.EmbeddingFallbacks[i].Model = cfg.Cache.Semantic.EmbeddingModel
+		}
+	}
+	if cfg.Savings.FlushInterval == 0 {
+		cfg.Savings.FlushInterval = time.Minute
+	}
+	if cfg.Savings.MaxTags == 0 {
+		cfg.Savings.MaxTags = 1000
+	}
+	if cfg.Cache.Store.SkipFinishReasons == nil {
+		cfg.Cache.Store.SkipFinishReasons = []string{"length", "content_filter"}
+	}
+	if cfg.Cache.Volatile.Action == "" {
+		cfg.Cache.Volatile.Action = "bypass"
+	}
 	if cfg.Cache.Semantic.QdrantCollection == "" {
 		cfg.Cache.Semantic.QdrantCollection = "qlite_cache"
 	}
@@ -106,9 +993,219 @@ func validate(cfg *Config) error {
 	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("server.port must be between 1 and 65535, got %d", cfg.Server.Port)
 	}
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		return fmt.Errorf("server.tls_cert_file and server.tls_key_file must be set together")
+	}
+	if cfg.Server.MaxConcurrent < 0 || cfg.Server.MaxQueue < 0 {
+		return fmt.Errorf("server.max_concurrent and server.max_queue must not be negative")
+	}
+	if cfg.RateLimit.Requests < 0 || cfg.RateLimit.Window < 0 {
+		return fmt.Errorf("rate_limit.requests and window must not be negative, got %d and %s", cfg.RateLimit.Requests, cfg.RateLimit.Window)
+	}
+	if r := cfg.RateLimit.Redis; r.Timeout < 0 || r.RetryInterval < 0 || r.DB < 0 {
+		return fmt.Errorf("rate_limit.redis.timeout, retry_interval and db must not be negative, got %s, %s and %d", r.Timeout, r.RetryInterval, r.DB)
+	}
+	if cfg.Server.SSEWriteTimeout < 0 || cfg.Server.SSEMaxBuffered < 0 {
+		return fmt.Errorf("server.sse_write_timeout and sse_max_buffered must not be negative, got %s and %d", cfg.Server.SSEWriteTimeout, cfg.Server.SSEMaxBuffered)
+	}
+	if cfg.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("server.shutdown_timeout must not be negative, got %s", cfg.Server.ShutdownTimeout)
+	}
+	if cfg.Savings.MaxTags < 0 {
+		return fmt.Errorf("savings.max_tags must not be negative, got %d", cfg.Savings.MaxTags)
+	}
+	if cfg.Cache.MaxTemperature < 0 || cfg.Cache.MaxTemperature > 2 {
+		return fmt.Errorf("cache.max_temperature must be between 0 and 2, got %g", cfg.Cache.MaxTemperature)
+	}
+	if cfg.Cache.Semantic.WarmExact < 0 {
+		return fmt.Errorf("cache.semantic.warm_exact must not be negative, got %d", cfg.Cache.Semantic.WarmExact)
+	}
+	if cfg.Cache.Semantic.MaxPromptTokens < 0 || cfg.Cache.Semantic.EmbeddingTimeoutPer1K < 0 {
+		return fmt.Errorf("cache.semantic.max_prompt_tokens and embedding_timeout_per_1k_tokens must not be negative")
+	}
+	if f := cfg.Cache.Semantic.LatencyFloor; f < 0 || f > cfg.Cache.Semantic.Threshold {
+		return fmt.Errorf("cache.semantic.latency_floor must be between 0 and threshold, got %g", f)
+	}
+	if g := cfg.Cache.Semantic.Race.Grace; g < 0 {
+		return fmt.Errorf("cache.semantic.race.grace must not be negative, got %s", g)
+	}
+	for _, name := range cfg.Cache.Semantic.Race.SequentialProviders {
+		if cfg.provider(name) == nil {
+			return fmt.Errorf("cache.semantic.race.sequential_providers: %q is not a configured provider", name)
+		}
+	}
+	for i, f := range cfg.Cache.Semantic.EmbeddingFallbacks {
+		if f.URL == "" {
+			return fmt.Errorf("cache.semantic.embedding_fallbacks[%d].url is required", i)
+		}
+	}
+	switch cfg.Cache.Semantic.StorePrompt {
+	case "", "full", "truncated", "hashed":
+	default:
+		return fmt.Errorf("cache.semantic.store_prompt must be full, truncated or hashed, got %q", cfg.Cache.Semantic.StorePrompt)
+	}
+	switch cfg.Validation.Schema {
+	case "off", "permissive", "strict":
+	default:
+		return fmt.Errorf("validation.schema must be off, permissive or strict, got %q", cfg.Validation.Schema)
+	}
+	if cfg.Validation.Retries < 0 {
+		return fmt.Errorf("validation.retries must not be negative, got %d", cfg.Validation.Retries)
+	}
+	switch cfg.Cache.Volatile.Action {
+	case "bypass", "strip":
+	default:
+		return fmt.Errorf("cache.volatile.action must be bypass or strip, got %q", cfg.Cache.Volatile.Action)
+	}
+	switch cfg.Guardrails.Action {
+	case "reject", "truncate":
+	default:
+		return fmt.Errorf("guardrails.action must be reject or truncate, got %q", cfg.Guardrails.Action)
+	}
+	for m, n := range cfg.Guardrails.ContextWindows {
+		if n <= 0 {
+			return fmt.Errorf("guardrails.context_windows[%s] must be positive, got %d", m, n)
+		}
+	}
+	if len(cfg.Alerts.Rules) > 0 && cfg.Alerts.WebhookURL == "" {
+		return fmt.Errorf("alerts.webhook_url is required when alert rules are configured")
+	}
+	for i, r := range cfg.Alerts.Rules {
+		switch r.Metric {
+		case "cache_hit_rate", "daily_spend", "provider_error_rate", "deprecated_requests", "slow_clients", "rate_limited", "provider_quota_remaining":
+		default:
+			return fmt.Errorf("alerts.rules[%d].metric must be cache_hit_rate, daily_spend, provider_error_rate, deprecated_requests, slow_clients, rate_limited or provider_quota_remaining, got %q", i, r.Metric)
+		}
+		if (r.Below == nil) == (r.Above == nil) {
+			return fmt.Errorf("alerts.rules[%d] must set exactly one of below or above", i)
+		}
+	}
+	switch cfg.Fixtures.Mode {
+	case "", "record", "replay":
+	default:
+		return fmt.Errorf("fixtures.mode must be record or replay, got %q", cfg.Fixtures.Mode)
+	}
+	if cfg.Fixtures.Mode != "" && cfg.Fixtures.Dir == "" {
+		return fmt.Errorf("fixtures.dir is required when fixtures.mode is set")
+	}
 	if len(cfg.Providers) == 0 {
 		return fmt.Errorf("at least one provider must be configured")
 	}
+	switch cfg.Cache.Exact.KeyFormat {
+	case "v1", "v2":
+	default:
+		return fmt.Errorf("cache.exact.key_format must be v1 or v2, got %q", cfg.Cache.Exact.KeyFormat)
+	}
+	switch cfg.Routing.Policy {
+	case "first", "cheapest":
+	default:
+		return fmt.Errorf("routing.policy must be first or cheapest, got %q", cfg.Routing.Policy)
+	}
+	if cfg.Routing.StreamRetries < 0 {
+		return fmt.Errorf("routing.stream_retries must not be negative, got %d", cfg.Routing.StreamRetries)
+	}
+	if cfg.Routing.QuotaReserve < 0 || cfg.Routing.QuotaReserve > 1 {
+		return fmt.Errorf("routing.quota_reserve must be between 0 and 1, got %g", cfg.Routing.QuotaReserve)
+	}
+	if cfg.Mirror.Percent < 0 || cfg.Mirror.Percent > 100 {
+		return fmt.Errorf("mirror.percent must be between 0 and 100, got %g", cfg.Mirror.Percent)
+	}
+	if cfg.Admin.Port < 0 || cfg.Admin.Port > 65535 {
+		return fmt.Errorf("admin.port must be between 0 and 65535, got %d", cfg.Admin.Port)
+	}
+	if cfg.Admin.Port != 0 && cfg.Admin.Port == cfg.Server.Port {
+		return fmt.Errorf("admin.port must differ from server.port (%d)", cfg.Server.Port)
+	}
+	if cfg.Admin.ClientCAFile != "" && !cfg.Server.TLSEnabled() {
+		return fmt.Errorf("admin.client_ca_file requires server.tls_cert_file and server.tls_key_file")
+	}
+	if a := cfg.Cache.Exact.AdaptiveTTL; a.Enabled {
+		if a.UnhitTTL < 0 || a.UnhitTTL > cfg.Cache.Exact.TTL {
+			return fmt.Errorf("cache.exact.adaptive_ttl.unhit_ttl must be between 0 and cache.exact.ttl (%s), got %s", cfg.Cache.Exact.TTL, a.UnhitTTL)
+		}
+		if a.MaxTTL != 0 && a.MaxTTL < cfg.Cache.Exact.TTL {
+			return fmt.Errorf("cache.exact.adaptive_ttl.max_ttl must be at least cache.exact.ttl (%s), got %s", cfg.Cache.Exact.TTL, a.MaxTTL)
+		}
+	}
+	if cfg.Cache.Store.MaxResponseBytes < 0 {
+		return fmt.Errorf("cache.store.max_response_bytes must not be negative, got %d", cfg.Cache.Store.MaxResponseBytes)
+	}
+	if cfg.Cache.Compression.MinBytes < 0 {
+		return fmt.Errorf("cache.compression.min_bytes must not be negative, got %d", cfg.Cache.Compression.MinBytes)
+	}
+	if f := cfg.Cache.Feedback; f.Threshold < 0 || f.Window < 0 || f.MaxRequests < 0 {
+		return fmt.Errorf("cache.feedback.threshold, window and max_requests must not be negative, got %d, %v and %d", f.Threshold, f.Window, f.MaxRequests)
+	}
+	if cfg.Debug.Requests < 0 {
+		return fmt.Errorf("debug.requests must not be negative, got %d", cfg.Debug.Requests)
+	}
+	if cfg.Mirror.MaxInFlight < 0 {
+		return fmt.Errorf("mirror.max_in_flight must not be negative, got %d", cfg.Mirror.MaxInFlight)
+	}
+	for _, r := range []struct {
+		name string
+		rate float64
+	}{
+		{"delay_rate", cfg.Chaos.DelayRate},
+		{"error_rate", cfg.Chaos.ErrorRate},
+		{"drop_rate", cfg.Chaos.DropRate},
+	} {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("chaos.%s must be between 0 and 1, got %g", r.name, r.rate)
+		}
+	}
+	if cfg.Chaos.MaxDelay < 0 {
+		return fmt.Errorf("chaos.max_delay must not be negative, got %s", cfg.Chaos.MaxDelay)
+	}
+	switch cfg.AccessLog.Format {
+	case "json", "common":
+	default:
+		return fmt.Errorf("access_log.format must be json or common, got %q", cfg.AccessLog.Format)
+	}
+	for _, f := range cfg.AccessLog.Fields {
+		if !slices.Contains(accessLogFields, f) {
+			return fmt.Errorf("access_log.fields: unknown field %q", f)
+		}
+	}
+	if cfg.AccessLog.MaxBytes < 0 || cfg.AccessLog.MaxAge < 0 || cfg.AccessLog.MaxBackups < 0 {
+		return fmt.Errorf("access_log rotation limits must not be negative")
+	}
+	seen := make(map[string]bool, len(cfg.Deprecations.Models))
+	for i, d := range cfg.Deprecations.Models {
+		if d.Model == "" {
+			return fmt.Errorf("deprecations.models[%d].model is required", i)
+		}
+		if seen[d.Model] {
+			return fmt.Errorf("deprecations.models[%d]: model %q is listed twice", i, d.Model)
+		}
+		seen[d.Model] = true
+		if d.Sunset != "" {
+			if _, err := time.Parse(time.DateOnly, d.Sunset); err != nil {
+				return fmt.Errorf("deprecations.models[%d].sunset must be a YYYY-MM-DD date, got %q", i, d.Sunset)
+			}
+		}
+		switch d.Action {
+		case "reject":
+		case "rewrite":
+			if d.Replacement == "" {
+				return fmt.Errorf("deprecations.models[%d].replacement is required with action rewrite", i)
+			}
+		default:
+			return fmt.Errorf("deprecations.models[%d].action must be reject or rewrite, got %q", i, d.Action)
+		}
+	}
+	if cfg.DefaultModel == "auto" {
+		return fmt.Errorf("default_model must name a concrete model, not auto")
+	}
+	if cfg.Async.Workers < 0 || cfg.Async.MaxQueue < 0 || cfg.Async.TTL < 0 {
+		return fmt.Errorf("async.workers, max_queue and ttl must not be negative, got %d, %d and %v", cfg.Async.Workers, cfg.Async.MaxQueue, cfg.Async.TTL)
+	}
+	if c := cfg.Tokenizer.CountTokens; c.Timeout < 0 || c.CacheSize < 0 || c.CacheTTL < 0 {
+		return fmt.Errorf("tokenizer.count_tokens.timeout, cache_size and cache_ttl must not be negative, got %v, %d and %v", c.Timeout, c.CacheSize, c.CacheTTL)
+	}
+	if cfg.Continuation.MaxRounds < 0 {
+		return fmt.Errorf("continuation.max_rounds must not be negative, got %d", cfg.Continuation.MaxRounds)
+	}
 	if cfg.Cache.Semantic.Enabled {
 		if cfg.Cache.Semantic.QdrantURL == "" {
 			return fmt.Errorf("cache.semantic.qdrant_url is required when semantic cache is enabled")
@@ -124,12 +1221,213 @@ func validate(cfg *Config) error {
 		if p.Type == "" {
 			return fmt.Errorf("providers[%d].type is required", i)
 		}
-		if p.BaseURL == "" {
+		if p.Type == "vertex" {
+			if p.Project == "" || p.Region == "" {
+				return fmt.Errorf("providers[%d].project and region are required for vertex", i)
+			}
+		} else if p.BaseURL == "" && !presetTypes[p.Type] && p.Type != "google" {
 			return fmt.Errorf("providers[%d].base_url is required", i)
 		}
-		if len(p.Models) == 0 {
+		if len(p.Models) == 0 && !p.Discovery.Enabled {
 			return fmt.Errorf("providers[%d].models must have at least one model", i)
 		}
+		if err := p.validateModels(i); err != nil {
+			return err
+		}
+		if err := p.validateDiscovery(i); err != nil {
+			return err
+		}
+		if err := p.validateHealth(i); err != nil {
+			return err
+		}
+		if p.IncludeUsage != nil && p.Type != "openai" && !presetTypes[p.Type] {
+			return fmt.Errorf("providers[%d].include_usage is only supported for OpenAI-compatible providers, got type %s", i, p.Type)
+		}
+		if err := p.Transport.validate(fmt.Sprintf("providers[%d].transport", i)); err != nil {
+			return err
+		}
+		if err := p.validateAPIVersion(i); err != nil {
+			return err
+		}
+		if err := p.validateAuth(i); err != nil {
+			return err
+		}
+	}
+	if err := cfg.SharedTransport.validate("shared_transport"); err != nil {
+		return err
+	}
+	tenantOf := make(map[string]string)
+	for name, keys := range cfg.Tenants {
+		if len(keys) == 0 {
+			return fmt.Errorf("tenants.%s must list at least one API key", name)
+		}
+		for _, k := range keys {
+			if other, ok := tenantOf[k]; ok && other != name {
+				return fmt.Errorf("tenants: an API key must belong to one tenant, got one in %s and %s", min(name, other), max(name, other))
+			}
+			tenantOf[k] = name
+		}
+	}
+	for i, sp := range cfg.SystemPrompts {
+		if sp.Prompt == "" {
+			return fmt.Errorf("system_prompts[%d].prompt is required", i)
+		}
+		if sp.Position != "prepend" && sp.Position != "append" {
+			return fmt.Errorf("system_prompts[%d].position must be prepend or append, got %q", i, sp.Position)
+		}
+		for _, t := range sp.Tenants {
+			if _, ok := cfg.Tenants[t]; !ok {
+				return fmt.Errorf("system_prompts[%d].tenants must be defined in tenants, got %q", i, t)
+			}
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if name == "" {
+			return fmt.Errorf("profiles must not have an empty name")
+		}
+		if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+			return fmt.Errorf("profiles.%s.temperature must be between 0 and 2, got %v", name, *p.Temperature)
+		}
+		if p.MaxTokens != nil && *p.MaxTokens <= 0 {
+			return fmt.Errorf("profiles.%s.max_tokens must be positive, got %v", name, *p.MaxTokens)
+		}
+		if p.Position != "prepend" && p.Position != "append" {
+			return fmt.Errorf("profiles.%s.position must be prepend or append, got %q", name, p.Position)
+		}
+		for alias, m := range p.Models {
+			if alias == "" || m == "" {
+				return fmt.Errorf("profiles.%s.models must map non-empty aliases to non-empty models, got %q: %q", name, alias, m)
+			}
+			if !cfg.serves(m) {
+				return fmt.Errorf("profiles.%s.models.%s must name a model a provider serves, got %q", name, alias, m)
+			}
+		}
+	}
+	if name := cfg.ReadThrough.Provider; name != "" {
+		p := cfg.provider(name)
+		if p == nil {
+			return fmt.Errorf("read_through.provider %q is not a configured provider", name)
+		}
+		switch p.Type {
+		case "anthropic", "google", "vertex":
+			return fmt.Errorf("read_through.provider %q must be OpenAI-compatible, got type %s", name, p.Type)
+		}
+	}
+	return nil
+}
+
+// geminiVersion matches a Gemini API version such as v1 or v1beta.
+var geminiVersion = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+func (p ProviderConfig) validateAPIVersion(i int) error {
+	switch p.Type {
+	case "anthropic":
+	case "google", "vertex":
+		if p.APIVersion != "" && !geminiVersion.MatchString(p.APIVersion) {
+			return fmt.Errorf("providers[%d].api_version must look like v1 or v1beta, got %q", i, p.APIVersion)
+		}
+	default:
+		if p.APIVersion != "" {
+			return fmt.Errorf("providers[%d].api_version is only supported for anthropic, google and vertex", i)
+		}
+	}
+	if len(p.Betas) > 0 && p.Type != "anthropic" {
+		return fmt.Errorf("providers[%d].betas is only supported for anthropic", i)
+	}
+	return nil
+}
+
+func (p ProviderConfig) validateModels(i int) error {
+	seen := make(map[string]bool, len(p.Models))
+	for j, m := range p.Models {
+		switch {
+		case m.Name == "":
+			return fmt.Errorf("providers[%d].models[%d].name is required", i, j)
+		case seen[m.Name]:
+			return fmt.Errorf("providers[%d].models lists %s twice", i, m.Name)
+		case m.ContextWindow < 0:
+			return fmt.Errorf("providers[%d].models[%d].context_window must not be negative, got %d", i, j, m.ContextWindow)
+		case m.InputPrice != nil && *m.InputPrice < 0:
+			return fmt.Errorf("providers[%d].models[%d].input_price must not be negative, got %v", i, j, *m.InputPrice)
+		case m.OutputPrice != nil && *m.OutputPrice < 0:
+			return fmt.Errorf("providers[%d].models[%d].output_price must not be negative, got %v", i, j, *m.OutputPrice)
+		}
+		seen[m.Name] = true
+	}
+	return nil
+}
+
+func (p ProviderConfig) validateDiscovery(i int) error {
+	if !p.Discovery.Enabled {
+		return nil
+	}
+	if p.Type != "openai" && !presetTypes[p.Type] {
+		return fmt.Errorf("providers[%d].discovery is only supported for OpenAI-compatible providers, got type %s", i, p.Type)
+	}
+	if p.Discovery.Interval < 0 {
+		return fmt.Errorf("providers[%d].discovery.interval must not be negative, got %v", i, p.Discovery.Interval)
+	}
+	for _, a := range p.Discovery.Allow {
+		if _, err := regexp.Compile(a); err != nil {
+			return fmt.Errorf("providers[%d].discovery.allow: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (p ProviderConfig) validateHealth(i int) error {
+	if !p.Health.Enabled {
+		return nil
+	}
+	if p.Type != "openai" && !presetTypes[p.Type] {
+		return fmt.Errorf("providers[%d].health is only supported for OpenAI-compatible providers, got type %s", i, p.Type)
+	}
+	if p.Health.Interval < 0 || p.Health.Timeout < 0 {
+		return fmt.Errorf("providers[%d].health.interval and timeout must not be negative, got %v and %v", i, p.Health.Interval, p.Health.Timeout)
+	}
+	return nil
+}
+
+func (p ProviderConfig) validateAuth(i int) error {
+	a := p.Auth
+	switch a.Type {
+	case "":
+	case "hmac":
+		if a.Secret == "" {
+			return fmt.Errorf("providers[%d].auth.secret is required for hmac", i)
+		}
+	case "oauth2":
+		if a.TokenURL == "" || a.ClientID == "" {
+			return fmt.Errorf("providers[%d].auth.token_url and client_id are required for oauth2", i)
+		}
+	default:
+		return fmt.Errorf("providers[%d].auth.type must be hmac or oauth2, got %q", i, a.Type)
+	}
+	return nil
+}
+
+// serves reports whether requests for model can be served: a provider
+// lists it or discovers its models at runtime, or a deprecation rewrites it.
+func (c *Config) serves(model string) bool {
+	for _, p := range c.Providers {
+		if p.Discovery.Enabled || slices.Contains(p.ModelNames(), model) {
+			return true
+		}
+	}
+	for _, d := range c.Deprecations.Models {
+		if d.Model == model && d.Action == "rewrite" {
+			return true
+		}
+	}
+	return false
+}
+
+// provider returns the provider config named name, or nil.
+func (c *Config) provider(name string) *ProviderConfig {
+	for i := range c.Providers {
+		if c.Providers[i].Name == name {
+			return &c.Providers[i]
+		}
 	}
 	return nil
 }
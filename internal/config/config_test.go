@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad_ValidConfig(t *testing.T) {
@@ -41,6 +42,46 @@ providers:
 	}
 }
 
+func TestLoad_ModelEntries(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `
+providers:
+  - name: local
+    type: openai
+    base_url: http://localhost:8000/v1
+    models:
+      - llama-3.1-8b
+      - name: qwen-2.5-72b
+        context_window: 32768
+        supports_tools: true
+        input_price: 0
+        output_price: 0.5
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := cfg.Providers[0]
+	if names := p.ModelNames(); len(names) != 2 || names[0] != "llama-3.1-8b" || names[1] != "qwen-2.5-72b" {
+		t.Fatalf("unexpected model names %v", names)
+	}
+	if m := p.Models[0]; m.ContextWindow != 0 || m.SupportsTools != nil || m.InputPrice != nil {
+		t.Errorf("expected a bare name to leave the entry unset, got %+v", m)
+	}
+	m := p.Models[1]
+	if m.ContextWindow != 32768 || m.SupportsTools == nil || !*m.SupportsTools || m.SupportsVision != nil {
+		t.Errorf("unexpected entry %+v", m)
+	}
+	if m.InputPrice == nil || *m.InputPrice != 0 || m.OutputPrice == nil || *m.OutputPrice != 0.5 {
+		t.Errorf("expected an explicit free input price, got %+v", m)
+	}
+}
+
 func TestLoad_Defaults(t *testing.T) {
 	dir := t.TempDir()
 	configPath := filepath.Join(dir, "config.yaml")
@@ -93,6 +134,105 @@ providers:
 	}
 }
 
+func TestParseRaw_NoEnvExpansion(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "sk-expanded")
+
+	cfg, err := ParseRaw([]byte(`
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1/${TEST_API_KEY}
+    models: [gpt-4o]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Providers[0].BaseURL; got != "https://api.openai.com/v1/${TEST_API_KEY}" {
+		t.Errorf("expected ${TEST_API_KEY} left unexpanded, got %q", got)
+	}
+}
+
+func TestLoadEnv_VariablesOnly(t *testing.T) {
+	t.Setenv("QLITE_SERVER_PORT", "9090")
+	t.Setenv("QLITE_SERVER_QUEUE_TIMEOUT", "5s")
+	t.Setenv("QLITE_CACHE_EXACT_ENABLED", "true")
+	t.Setenv("QLITE_PROVIDERS_0_NAME", "openai")
+	t.Setenv("QLITE_PROVIDERS_0_TYPE", "openai")
+	t.Setenv("QLITE_PROVIDERS_0_BASE_URL", "https://api.openai.com/v1")
+	t.Setenv("QLITE_PROVIDERS_0_MODELS", "gpt-4o, gpt-4o-mini")
+	t.Setenv("QLITE_PROVIDERS_0_HEADERS", `{"X-Title": "qlite"}`)
+	t.Setenv("QLITE_PROVIDERS_1_NAME", "groq")
+	t.Setenv("QLITE_PROVIDERS_1_TYPE", "groq")
+	t.Setenv("QLITE_PROVIDERS_1_MODELS", "[llama-3.1-8b-instant]")
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Port != 9090 || cfg.Server.QueueTimeout != 5*time.Second {
+		t.Errorf("unexpected server config: %+v", cfg.Server)
+	}
+	if !cfg.Cache.Exact.Enabled {
+		t.Error("expected exact cache enabled")
+	}
+	if len(cfg.Providers) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(cfg.Providers))
+	}
+	if got := cfg.Providers[0].Models; len(got) != 2 || got[1].Name != "gpt-4o-mini" {
+		t.Errorf("expected comma-separated models, got %v", got)
+	}
+	if cfg.Providers[0].Headers["X-Title"] != "qlite" {
+		t.Errorf("expected headers map, got %v", cfg.Providers[0].Headers)
+	}
+	if cfg.Providers[1].Models[0].Name != "llama-3.1-8b-instant" {
+		t.Errorf("expected YAML list models, got %v", cfg.Providers[1].Models)
+	}
+}
+
+func TestLoadEnv_JSONWithOverrides(t *testing.T) {
+	t.Setenv("QLITE_CONFIG_JSON", `{"server":{"port":7070},"providers":[{"name":"openai","type":"openai","base_url":"https://api.openai.com/v1","api_key":"sk-json","models":["gpt-4o"]}]}`)
+	t.Setenv("QLITE_PROVIDERS_0_API_KEY", "sk-env")
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Port != 7070 {
+		t.Errorf("expected port from JSON, got %d", cfg.Server.Port)
+	}
+	if cfg.Providers[0].APIKey != "sk-env" {
+		t.Errorf("expected env override of api_key, got %q", cfg.Providers[0].APIKey)
+	}
+}
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	t.Setenv("QLITE_SERVER_PORT", "not-a-number")
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("expected error for invalid QLITE_SERVER_PORT")
+	}
+
+	t.Setenv("QLITE_SERVER_PORT", "8181")
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Port != 8181 {
+		t.Errorf("expected port override 8181, got %d", cfg.Server.Port)
+	}
+}
+
 func TestLoad_ValidationErrors(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -138,6 +278,561 @@ providers:
     base_url: https://api.openai.com/v1
     api_key: sk-test`,
 		},
+		{
+			name: "duplicate model",
+			content: `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o, {name: gpt-4o, context_window: 64000}]`,
+		},
+		{
+			name: "negative model price",
+			content: `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models:
+      - name: gpt-4o
+        input_price: -1`,
+		},
+		{
+			name: "discovery on anthropic provider",
+			content: `
+providers:
+  - name: anthropic
+    type: anthropic
+    base_url: https://api.anthropic.com/v1
+    discovery:
+      enabled: true`,
+		},
+		{
+			name: "include_usage on anthropic provider",
+			content: `
+providers:
+  - name: anthropic
+    type: anthropic
+    base_url: https://api.anthropic.com/v1
+    include_usage: false`,
+		},
+		{
+			name: "health on anthropic provider",
+			content: `
+providers:
+  - name: anthropic
+    type: anthropic
+    base_url: https://api.anthropic.com/v1
+    models: [claude-sonnet-4]
+    health:
+      enabled: true`,
+		},
+		{
+			name: "invalid discovery allow pattern",
+			content: `
+providers:
+  - name: vllm
+    type: openai
+    base_url: http://localhost:8000/v1
+    discovery:
+      enabled: true
+      allow: ["llama-(3"]`,
+		},
+		{
+			name: "fixtures mode without dir",
+			content: `
+fixtures:
+  mode: replay
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    api_key: sk-test
+    models: [gpt-4o]`,
+		},
+		{
+			name: "tls cert without key",
+			content: `
+server:
+  tls_cert_file: /etc/qlite/cert.pem
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    api_key: sk-test
+    models: [gpt-4o]`,
+		},
+		{
+			name: "alert rule without webhook",
+			content: `
+alerts:
+  rules:
+    - metric: daily_spend
+      above: 50
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "alert rule with both thresholds",
+			content: `
+alerts:
+  webhook_url: https://hooks.example.com/x
+  rules:
+    - metric: cache_hit_rate
+      below: 20
+      above: 90
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "invalid guardrails action",
+			content: `
+guardrails:
+  enabled: true
+  action: summarize
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "mirror percent out of range",
+			content: `
+mirror:
+  url: http://staging:8080
+  percent: 150
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown routing policy",
+			content: `
+routing:
+  policy: random
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative transport size",
+			content: `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]
+    transport:
+      max_idle_conns_per_host: -1`,
+		},
+		{
+			name: "negative shared transport timeout",
+			content: `
+shared_transport:
+  enabled: true
+  dial_timeout: -1s
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "malformed gemini api version",
+			content: `
+providers:
+  - name: gemini
+    type: google
+    api_version: "2024-01-01"
+    models: [gemini-2.5-flash]`,
+		},
+		{
+			name: "api version on openai provider",
+			content: `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    api_version: v2
+    models: [gpt-4o]`,
+		},
+		{
+			name: "betas on google provider",
+			content: `
+providers:
+  - name: gemini
+    type: google
+    betas: [tools-2024-04-04]
+    models: [gemini-2.5-flash]`,
+		},
+		{
+			name: "system prompt without prompt",
+			content: `
+system_prompts:
+  - name: guardrails
+    tenants: [acme]
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "system prompt for undefined tenant",
+			content: `
+system_prompts:
+  - prompt: Be nice.
+    tenants: [acme]
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "API key in two tenants",
+			content: `
+tenants:
+  acme: [key-1]
+  globex: [key-1]
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown system prompt position",
+			content: `
+system_prompts:
+  - prompt: Be nice.
+    position: middle
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "profile temperature out of range",
+			content: `
+profiles:
+  creative:
+    temperature: 3
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "profile alias to unserved model",
+			content: `
+profiles:
+  fast:
+    models:
+      smart: gpt-4o-mnii
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o, gpt-4o-mini]`,
+		},
+		{
+			name: "negative rate limit",
+			content: `
+rate_limit:
+  requests: -1
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "hmac auth without secret",
+			content: `
+providers:
+  - name: gateway
+    type: openai
+    base_url: https://llm.internal/v1
+    auth:
+      type: hmac
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown schema mode",
+			content: `
+validation:
+  schema: lenient
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "admin port same as server port",
+			content: `
+server:
+  port: 8080
+admin:
+  port: 8080
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "admin client CA without TLS",
+			content: `
+admin:
+  client_ca_file: /etc/qlite/admin-ca.pem
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "adaptive ttl max below ttl",
+			content: `
+cache:
+  exact:
+    ttl: 1h
+    adaptive_ttl:
+      enabled: true
+      max_ttl: 30m
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative stream retries",
+			content: `
+routing:
+  stream_retries: -1
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative continuation rounds",
+			content: `
+continuation:
+  max_rounds: -1
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative async workers",
+			content: `
+async:
+  enabled: true
+  workers: -2
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative feedback threshold",
+			content: `
+cache:
+  feedback:
+    enabled: true
+    threshold: -1
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown sequential race provider",
+			content: `
+cache:
+  semantic:
+    race:
+      sequential_providers: [groq]
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative count_tokens timeout",
+			content: `
+tokenizer:
+  count_tokens:
+    enabled: true
+    timeout: -1s
+providers:
+  - name: anthropic
+    type: anthropic
+    base_url: https://api.anthropic.com/v1
+    models: [claude-sonnet-4]`,
+		},
+		{
+			name: "quota reserve above one",
+			content: `
+routing:
+  quota_reserve: 1.5
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "latency floor above threshold",
+			content: `
+cache:
+  semantic:
+    threshold: 0.9
+    latency_floor: 0.95
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "chaos rate above one",
+			content: `
+chaos:
+  enabled: true
+  error_rate: 1.5
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown access log field",
+			content: `
+access_log:
+  path: /tmp/access.log
+  fields: [status, referer]
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "deprecation rewrite without replacement",
+			content: `
+deprecations:
+  models:
+    - model: gpt-4-0613
+      sunset: 2025-06-30
+      action: rewrite
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative debug requests",
+			content: `
+debug:
+  requests: -1
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "negative shutdown timeout",
+			content: `
+server:
+  shutdown_timeout: -1s
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "unknown cache key format",
+			content: `
+cache:
+  exact:
+    key_format: v3
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "default_model auto",
+			content: `
+default_model: auto
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "read_through with unknown provider",
+			content: `
+read_through:
+  provider: missing
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "invalid semantic store_prompt",
+			content: `
+cache:
+  semantic:
+    store_prompt: plain
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    models: [gpt-4o]`,
+		},
+		{
+			name: "vertex without project",
+			content: `
+providers:
+  - name: vertex
+    type: vertex
+    region: us-central1
+    models: [gemini-2.5-flash]`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -161,3 +856,72 @@ func TestLoad_FileNotFound(t *testing.T) {
 		t.Error("expected error for missing file")
 	}
 }
+
+func TestDiff(t *testing.T) {
+	base := `
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    api_key: sk-old
+    models: [gpt-4o]`
+	old, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := Parse([]byte(`
+default_model: gpt-4o
+cache:
+  exact:
+    ttl: 2h
+shared_transport:
+  max_conns_per_host: 50
+providers:
+  - name: openai
+    type: openai
+    base_url: https://api.openai.com/v1
+    api_key: sk-new
+    models: [gpt-4o]
+  - name: groq
+    type: groq
+    api_key: gsk-secret
+    auth:
+      type: hmac
+      secret: hmac-secret
+    models: [llama-3.3-70b-versatile]
+system_prompts:
+  - prompt: Be brief.
+    api_keys: [sk-team]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]Change)
+	for _, c := range Diff(old, updated) {
+		got[c.Path] = c
+	}
+	if len(got) != 6 {
+		t.Errorf("expected 6 changes, got %v", got)
+	}
+	if c := got["shared_transport.max_conns_per_host"]; c.New != 50 {
+		t.Errorf("expected inline transport field change, got %+v", c)
+	}
+	if c := got["default_model"]; c.Old != "" || c.New != "gpt-4o" {
+		t.Errorf("unexpected default_model change: %+v", c)
+	}
+	if c, ok := got["cache.exact.ttl"]; !ok || c.New != 2*time.Hour {
+		t.Errorf("unexpected ttl change: %+v", c)
+	}
+	if c := got["providers[0].api_key"]; c.Old != "REDACTED" || c.New != "REDACTED" {
+		t.Errorf("expected redacted api_key change, got %+v", c)
+	}
+	if c, ok := got["providers[1]"].New.(ProviderConfig); !ok || c.Name != "groq" || c.APIKey != "REDACTED" || c.Auth.Secret != "REDACTED" {
+		t.Errorf("expected added provider with redacted credentials, got %+v", got["providers[1]"])
+	}
+	if c, ok := got["system_prompts[0]"].New.(SystemPromptConfig); !ok || len(c.APIKeys) != 1 || c.APIKeys[0] != "REDACTED" {
+		t.Errorf("expected added system prompt with redacted api_keys, got %+v", got["system_prompts[0]"])
+	}
+	if len(Diff(old, old)) != 0 {
+		t.Error("expected no changes for identical configs")
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change is one setting that differs between two configs. Path is the
+// dotted YAML path, with list entries addressed by index, e.g.
+// "providers[0].models".
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+// secretFields are YAML keys whose values Diff reports as REDACTED.
+var secretFields = map[string]bool{
+	"api_key":        true,
+	"api_keys":       true,
+	"key":            true,
+	"embedding_key":  true,
+	"qdrant_api_key": true,
+	"token":          true,
+	"tenants":        true,
+	"secret":         true,
+	"client_secret":  true,
+	"password":       true,
+}
+
+// Diff returns the settings that differ between old and new, in field order.
+// Credential values are reported as REDACTED.
+func Diff(old, new *Config) []Change {
+	changes := []Change{}
+	diffValue(&changes, "", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+	return changes
+}
+
+func diffValue(changes *[]Change, path string, a, b reflect.Value) {
+	switch {
+	case a.Kind() == reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if opts == "inline" {
+				diffValue(changes, path, a.Field(i), b.Field(i))
+				continue
+			}
+			if tag == "" || tag == "-" {
+				continue
+			}
+			p := tag
+			if path != "" {
+				p = path + "." + tag
+			}
+			diffValue(changes, p, a.Field(i), b.Field(i))
+		}
+	case a.Kind() == reflect.Slice && a.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < max(a.Len(), b.Len()); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				*changes = append(*changes, Change{Path: p, New: redactStruct(b.Index(i))})
+			case i >= b.Len():
+				*changes = append(*changes, Change{Path: p, Old: redactStruct(a.Index(i))})
+			default:
+				diffValue(changes, p, a.Index(i), b.Index(i))
+			}
+		}
+	default:
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return
+		}
+		c := Change{Path: path, Old: a.Interface(), New: b.Interface()}
+		if secretFields[path[strings.LastIndex(path, ".")+1:]] {
+			c.Old, c.New = "REDACTED", "REDACTED"
+		}
+		*changes = append(*changes, c)
+	}
+}
+
+// redactStruct returns a copy of the struct v with credential fields masked,
+// including those of nested structs.
+func redactStruct(v reflect.Value) any {
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	t := c.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		switch f := c.Field(i); {
+		case secretFields[tag] && f.Kind() == reflect.String && f.String() != "":
+			f.SetString("REDACTED")
+		case secretFields[tag] && f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			masked := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			for j := range f.Len() {
+				masked.Index(j).SetString("REDACTED")
+			}
+			f.Set(masked)
+		case f.Kind() == reflect.Struct && f.CanSet():
+			f.Set(reflect.ValueOf(redactStruct(f)))
+		}
+	}
+	return c.Interface()
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix namespaces configuration environment variables. A field's
+// variable name is the prefix followed by its upper-cased yaml path joined
+// with underscores; slice elements are addressed by index, e.g.
+// QLITE_SERVER_PORT, QLITE_CACHE_EXACT_ENABLED, QLITE_PROVIDERS_0_NAME.
+const envPrefix = "QLITE"
+
+// ConfigJSONEnv holds a complete configuration document as JSON.
+const ConfigJSONEnv = "QLITE_CONFIG_JSON"
+
+// LoadEnv builds the configuration without a config file: it starts from the
+// JSON document in QLITE_CONFIG_JSON (if set) and applies QLITE_* overrides.
+func LoadEnv() (*Config, error) {
+	var cfg Config
+	if doc := os.Getenv(ConfigJSONEnv); doc != "" {
+		// JSON is a subset of YAML, so the yaml tags apply unchanged.
+		if err := yaml.Unmarshal([]byte(doc), &cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", ConfigJSONEnv, err)
+		}
+	}
+	return finish(&cfg)
+}
+
+// applyEnv overlays QLITE_* variables from environ onto cfg.
+func applyEnv(cfg *Config, environ []string) error {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(k, envPrefix+"_") {
+			vars[k] = v
+		}
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return overlayEnv(reflect.ValueOf(cfg).Elem(), envPrefix, vars)
+}
+
+func overlayEnv(v reflect.Value, prefix string, vars map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := overlayEnv(field, name, vars); err != nil {
+				return err
+			}
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			// The whole list may be given at once, then refined per element.
+			if raw, ok := vars[name]; ok {
+				if err := setFromEnv(field, raw); err != nil {
+					return fmt.Errorf("env %s: %w", name, err)
+				}
+			}
+			for idx := 0; ; idx++ {
+				elemName := name + "_" + strconv.Itoa(idx)
+				if idx >= field.Len() {
+					if !hasPrefix(vars, elemName+"_") {
+						break
+					}
+					field.Set(reflect.Append(field, reflect.New(field.Type().Elem()).Elem()))
+				}
+				if err := overlayEnv(field.Index(idx), elemName, vars); err != nil {
+					return err
+				}
+			}
+		default:
+			raw, ok := vars[name]
+			if !ok {
+				continue
+			}
+			if err := setFromEnv(field, raw); err != nil {
+				return fmt.Errorf("env %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setFromEnv assigns raw to field. Strings are taken verbatim, slices accept
+// a comma-separated list of scalars, and everything else (numbers, bools,
+// durations, maps) is decoded as a YAML/JSON value.
+func setFromEnv(field reflect.Value, raw string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(raw)
+		return nil
+	case field.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(raw), "["):
+		parts := strings.Split(raw, ",")
+		list := make([]string, 0, len(parts))
+		for _, p := range parts {
+			if p = strings.TrimSpace(p); p != "" {
+				list = append(list, p)
+			}
+		}
+		if field.Type().Elem().Kind() == reflect.String {
+			field.Set(reflect.ValueOf(list))
+			return nil
+		}
+		// Decode the items as YAML scalars, e.g. model names.
+		doc, err := yaml.Marshal(list)
+		if err != nil {
+			return err
+		}
+		raw = string(doc)
+	}
+	return yaml.Unmarshal([]byte(raw), field.Addr().Interface())
+}
+
+func hasPrefix(vars map[string]string, prefix string) bool {
+	for k := range vars {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Embedder computes embedding vectors. Client and Failover implement it.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// ErrNoHealthyEndpoint is returned by Failover when every endpoint is
+// cooling down after a failure.
+var ErrNoHealthyEndpoint = errors.New("no healthy embedding endpoint")
+
+// EndpointStatus describes one Failover endpoint.
+type EndpointStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	LastError string    `json:"last_error,omitempty"`
+	RetryAt   time.Time `json:"retry_at,omitzero"`
+}
+
+// Failover tries embedding endpoints in order, skipping any that failed
+// within the cooldown. When all endpoints are cooling down, semantic caching
+// is effectively off; Degraded reports this.
+type Failover struct {
+	clients  []*Client
+	cooldown time.Duration
+	onChange func(url string, healthy bool, err error)
+	now      func() time.Time
+
+	mu      sync.Mutex
+	retryAt []time.Time
+	lastErr []error
+}
+
+// NewFailover creates a failover embedder over clients, in priority order.
+func NewFailover(cooldown time.Duration, clients ...*Client) *Failover {
+	return &Failover{
+		clients:  clients,
+		cooldown: cooldown,
+		now:      time.Now,
+		retryAt:  make([]time.Time, len(clients)),
+		lastErr:  make([]error, len(clients)),
+	}
+}
+
+// SetOnStateChange registers a callback invoked when an endpoint is marked
+// unhealthy (with the error) or healthy again (with nil).
+func (f *Failover) SetOnStateChange(fn func(url string, healthy bool, err error)) {
+	f.onChange = fn
+}
+
+// Embed returns the embedding from the first healthy endpoint that succeeds.
+// Cancellation of ctx never marks an endpoint unhealthy.
+func (f *Failover) Embed(ctx context.Context, text string) ([]float32, error) {
+	var errs []error
+	for i, c := range f.clients {
+		if !f.available(i) {
+			continue
+		}
+		emb, err := c.Embed(ctx, text)
+		if err == nil {
+			f.mark(i, nil)
+			return emb, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		f.mark(i, err)
+		errs = append(errs, fmt.Errorf("%s: %w", c.baseURL, err))
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoHealthyEndpoint
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Degraded reports whether every endpoint is cooling down.
+func (f *Failover) Degraded() bool {
+	for i := range f.clients {
+		if f.available(i) {
+			return false
+		}
+	}
+	return len(f.clients) > 0
+}
+
+// Status returns the health of each endpoint.
+func (f *Failover) Status() []EndpointStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	out := make([]EndpointStatus, len(f.clients))
+	for i, c := range f.clients {
+		out[i] = EndpointStatus{URL: c.baseURL, Healthy: !now.Before(f.retryAt[i])}
+		if !out[i].Healthy {
+			out[i].RetryAt = f.retryAt[i]
+		}
+		if f.lastErr[i] != nil {
+			out[i].LastError = f.lastErr[i].Error()
+		}
+	}
+	return out
+}
+
+func (f *Failover) available(i int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.now().Before(f.retryAt[i])
+}
+
+// mark records the outcome of a call to endpoint i.
+func (f *Failover) mark(i int, err error) {
+	f.mu.Lock()
+	wasFailing := f.lastErr[i] != nil
+	f.lastErr[i] = err
+	if err != nil {
+		f.retryAt[i] = f.now().Add(f.cooldown)
+	}
+	f.mu.Unlock()
+
+	if f.onChange != nil && (err != nil || wasFailing) {
+		f.onChange(f.clients[i].baseURL, err == nil, err)
+	}
+}
@@ -0,0 +1,92 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func embeddingServer(fail *atomic.Bool, calls *atomic.Int32, value float32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{value}}}})
+	}))
+}
+
+func TestFailover(t *testing.T) {
+	var primaryDown, backupDown atomic.Bool
+	var primaryCalls, backupCalls atomic.Int32
+	primary := embeddingServer(&primaryDown, &primaryCalls, 1)
+	defer primary.Close()
+	backup := embeddingServer(&backupDown, &backupCalls, 2)
+	defer backup.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	f := NewFailover(30*time.Second, NewClient(primary.URL, "k", "m"), NewClient(backup.URL, "k", "m"))
+	f.now = func() time.Time { return now }
+	var changes []bool
+	f.SetOnStateChange(func(url string, healthy bool, err error) { changes = append(changes, healthy) })
+
+	ctx := context.Background()
+	primaryDown.Store(true)
+	if emb, err := f.Embed(ctx, "x"); err != nil || emb[0] != 2 {
+		t.Fatalf("expected failover to backup, got %v, %v", emb, err)
+	}
+	// Primary is cooling down and not retried.
+	f.Embed(ctx, "x")
+	if primaryCalls.Load() != 1 || backupCalls.Load() != 2 {
+		t.Errorf("expected primary skipped during cooldown, got %d/%d calls", primaryCalls.Load(), backupCalls.Load())
+	}
+
+	backupDown.Store(true)
+	f.Embed(ctx, "x")
+	if !f.Degraded() {
+		t.Error("expected degraded with every endpoint down")
+	}
+	if _, err := f.Embed(ctx, "x"); !errors.Is(err, ErrNoHealthyEndpoint) {
+		t.Errorf("expected ErrNoHealthyEndpoint, got %v", err)
+	}
+
+	// After the cooldown the primary is retried and recovers.
+	primaryDown.Store(false)
+	now = now.Add(31 * time.Second)
+	if emb, err := f.Embed(ctx, "x"); err != nil || emb[0] != 1 {
+		t.Fatalf("expected primary to recover, got %v, %v", emb, err)
+	}
+	if f.Degraded() {
+		t.Error("expected not degraded after recovery")
+	}
+	st := f.Status()
+	if !st[0].Healthy || st[0].LastError != "" {
+		t.Errorf("unexpected primary status: %+v", st[0])
+	}
+	if want := []bool{false, false, true}; len(changes) != len(want) || changes[0] || changes[1] || !changes[2] {
+		t.Errorf("expected state changes %v, got %v", want, changes)
+	}
+}
+
+func TestFailover_CancelledContextKeepsEndpointHealthy(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := embeddingServer(&down, &calls, 1)
+	defer srv.Close()
+
+	f := NewFailover(time.Minute, NewClient(srv.URL, "k", "m"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Embed(ctx, "x"); err == nil {
+		t.Fatal("expected error for cancelled context")
+	}
+	if f.Degraded() {
+		t.Error("cancellation must not mark the endpoint unhealthy")
+	}
+}
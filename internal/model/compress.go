@@ -0,0 +1,51 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+)
+
+// CompressResponse returns resp as gzipped JSON if its JSON encoding is at
+// least minBytes long. ok is false, and nothing is returned, for smaller
+// responses, which aren't worth the CPU.
+func CompressResponse(resp *ChatResponse, minBytes int) (data []byte, rawLen int, ok bool) {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return nil, 0, false
+	}
+	data, ok = CompressJSON(raw, minBytes)
+	return data, len(raw), ok
+}
+
+// CompressJSON is CompressResponse for a response already encoded as raw.
+func CompressJSON(raw []byte, minBytes int) ([]byte, bool) {
+	if len(raw) < minBytes {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil || buf.Len() >= len(raw) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// DecompressResponse decodes data produced by CompressResponse.
+func DecompressResponse(data []byte) (*ChatResponse, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var resp ChatResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// UnknownFields returns the JSON object keys in data that have no matching
+// field in v's type, which decoding into v would silently drop. Nested
+// objects and arrays of modeled fields are checked too; paths look like
+// "tools" or "messages[].name". Keys match fields case-insensitively, as in
+// encoding/json. Each path is reported once, sorted. data that isn't a JSON
+// object yields nil.
+func UnknownFields(data []byte, v any) []string {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	var out []string
+	collectUnknown(&out, "", raw, reflect.TypeOf(v))
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+func collectUnknown(out *[]string, path string, raw any, t reflect.Type) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Kind() != reflect.Pointer {
+			arr, ok := raw.([]any)
+			if !ok {
+				return
+			}
+			for _, el := range arr {
+				collectUnknown(out, path+"[]", el, t.Elem())
+			}
+			return
+		}
+		t = t.Elem()
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok || t.Kind() != reflect.Struct || t == rawMessageType {
+		return
+	}
+	fields := jsonFields(t)
+	for key, val := range obj {
+		p := key
+		if path != "" {
+			p = path + "." + key
+		}
+		ft, known := fields[strings.ToLower(key)]
+		if !known {
+			*out = append(*out, p)
+			continue
+		}
+		collectUnknown(out, p, val, ft)
+	}
+}
+
+var rawMessageType = reflect.TypeFor[json.RawMessage]()
+
+// fieldCache maps a struct type to its lowercased JSON field names and
+// their types.
+var fieldCache sync.Map // reflect.Type -> map[string]reflect.Type
+
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(map[string]reflect.Type)
+	}
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Key identifies a Metadata value of type T. Keys with the same name but
+// different types are distinct. Declare each key once, as a package-level
+// variable next to the stage that sets it:
+//
+//	var TenantKey = model.NewKey[string]("tenant")
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns the key for name.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// String returns the key's name.
+func (k Key[T]) String() string { return k.name }
+
+// Get returns the value stored under k, or the zero value and false.
+func (k Key[T]) Get(m Metadata) (T, bool) {
+	v, ok := m[k].(T)
+	return v, ok
+}
+
+// Set stores v under k, allocating *m on first use.
+func (k Key[T]) Set(m *Metadata, v T) {
+	if *m == nil {
+		*m = make(Metadata)
+	}
+	(*m)[k] = v
+}
+
+// Delete removes the value stored under k.
+func (k Key[T]) Delete(m Metadata) {
+	delete(m, k)
+}
+
+// Metadata carries decisions a stage hands on to later stages and to the
+// handler, such as a tenant or a redaction map, without widening
+// ProxyRequest for each of them. Values are set and read through Keys; a nil
+// Metadata is empty. It is not safe for concurrent writes: stages run one at
+// a time, and racing stages must not write to the request they share.
+type Metadata map[any]any
+
+// Names returns the sorted names of the keys set in m.
+func (m Metadata) Names() []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, fmt.Sprint(k))
+	}
+	slices.Sort(names)
+	return names
+}
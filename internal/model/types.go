@@ -2,12 +2,25 @@ package model
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // Message represents a chat message.
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ReasoningContent carries the reasoning trace returned by DeepSeek-R1
+	// and xAI style backends alongside the final answer.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// CacheControl is an extension for Anthropic prompt caching; it is passed
+	// through to Anthropic and stripped before reaching other providers.
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl marks a message as a prompt-cache breakpoint (e.g. {"type":"ephemeral"}).
+type CacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
 }
 
 // ChatRequest mirrors the OpenAI chat completions request.
@@ -24,6 +37,22 @@ type ChatRequest struct {
 	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
 	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
 	User             string          `json:"user,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	// Metadata is free-form key/value tags forwarded to providers that
+	// support them (OpenAI "metadata"; Anthropic only receives user_id).
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// MaxCompletionTokens is OpenAI's successor to MaxTokens; see
+	// MaxOutputTokens.
+	MaxCompletionTokens *int `json:"max_completion_tokens,omitempty"`
+}
+
+// MaxOutputTokens returns the completion token limit, preferring
+// max_completion_tokens over the legacy max_tokens when both are set.
+func (r *ChatRequest) MaxOutputTokens() *int {
+	if r.MaxCompletionTokens != nil {
+		return r.MaxCompletionTokens
+	}
+	return r.MaxTokens
 }
 
 // StreamOptions controls streaming behavior.
@@ -32,10 +61,14 @@ type StreamOptions struct {
 }
 
 // Usage represents token usage information.
+// PromptTokens includes any provider-side cached tokens; the cache fields
+// break down how many were written to or read from the provider's prompt cache.
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens             int `json:"prompt_tokens"`
+	CompletionTokens         int `json:"completion_tokens"`
+	TotalTokens              int `json:"total_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // Choice represents a single completion choice.
@@ -53,12 +86,42 @@ type ChatResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+	// SystemFingerprint identifies the backend configuration that produced
+	// the response (OpenAI); it changes when the provider updates it.
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// NoStore is set when the upstream marked the response as not
+	// cacheable (see provider.NoStoreHints), or when qlite stitched it
+	// together from continued responses. It is never serialized.
+	NoStore bool `json:"-"`
 }
 
 // Delta represents incremental content in a streaming chunk.
 type Delta struct {
-	Role    string `json:"role,omitempty"`
-	Content string `json:"content,omitempty"`
+	Role             string          `json:"role,omitempty"`
+	Content          string          `json:"content,omitempty"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// IsZero reports whether d carries nothing.
+func (d *Delta) IsZero() bool {
+	return d.Role == "" && d.Content == "" && d.ReasoningContent == "" && len(d.ToolCalls) == 0
+}
+
+// ToolCallDelta is part of a tool call streamed in OpenAI's shape. The
+// first delta of a call carries its ID, type and function name; the
+// arguments of every delta for the same Index are concatenated.
+type ToolCallDelta struct {
+	Index    int               `json:"index"`
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function FunctionCallDelta `json:"function"`
+}
+
+// FunctionCallDelta is the function part of a ToolCallDelta.
+type FunctionCallDelta struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
 }
 
 // StreamChoice represents a choice in a streaming chunk.
@@ -76,6 +139,8 @@ type ChatStreamChunk struct {
 	Model   string         `json:"model"`
 	Choices []StreamChoice `json:"choices"`
 	Usage   *Usage         `json:"usage,omitempty"`
+
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
 }
 
 // ProxyRequest wraps a ChatRequest with proxy-specific metadata.
@@ -85,6 +150,65 @@ type ProxyRequest struct {
 	InputTokens    int
 	APIKey         string
 	CacheKey       string // precomputed exact-cache key, set by CacheStage
+	// Tags are cost attribution labels from the X-QLite-Tags header.
+	Tags map[string]string
+	// Provider forces a specific provider (X-QLite-Provider) instead of
+	// the routing policy's choice.
+	Provider string
+	// RequestedModel is the model as the client sent it, before profile
+	// aliases, the default model and deprecations rewrote ChatRequest.Model;
+	// "auto" if the client omitted it.
+	RequestedModel string
+	// HiddenPrompts are system messages added only on the way upstream, so
+	// they don't affect cache keys. See UpstreamRequest.
+	HiddenPrompts []InjectedPrompt
+	// NoCache skips cache lookups and stores, so the request always reaches
+	// a provider. Set for replays that bypass caches.
+	NoCache bool
+	// Continuations is how many follow-up requests may continue a response
+	// cut off by max_tokens (X-QLite-Continue). Zero disables it.
+	Continuations int
+	// LatencyDeadline, if set, is when the client stops waiting for the
+	// upstream (X-QLite-Max-Latency). Past it a cached answer below the
+	// semantic threshold may be served instead, or the request fails.
+	LatencyDeadline time.Time
+	// Meta carries values stages pass on to later stages; see Metadata.
+	Meta Metadata
+}
+
+// UpstreamRequest returns the request to send to a provider: ChatRequest
+// with HiddenPrompts added, or ChatRequest itself when there are none.
+func (r *ProxyRequest) UpstreamRequest() *ChatRequest {
+	if len(r.HiddenPrompts) == 0 {
+		return &r.ChatRequest
+	}
+	c := r.ChatRequest
+	c.Messages = InjectPrompts(c.Messages, r.HiddenPrompts)
+	return &c
+}
+
+// InjectedPrompt is a system message the proxy adds to a request.
+type InjectedPrompt struct {
+	Content string `json:"content"`
+	Append  bool   `json:"append,omitempty"` // after the conversation instead of before it
+}
+
+// InjectPrompts returns msgs with prompts added as system messages, keeping
+// the prompts' order within each position. msgs is not modified.
+func InjectPrompts(msgs []Message, prompts []InjectedPrompt) []Message {
+	out := make([]Message, 0, len(msgs)+len(prompts))
+	for _, p := range prompts {
+		if !p.Append {
+			out = append(out, Message{Role: "system", Content: p.Content})
+		}
+	}
+	out = append(out, msgs...)
+	for _, p := range prompts {
+		if p.Append {
+			out = append(out, Message{Role: "system", Content: p.Content})
+		}
+	}
+	return out
 }
 
 // ProxyResponse wraps a ChatResponse with proxy-specific metadata.
@@ -93,7 +217,16 @@ type ProxyResponse struct {
 	OutputTokens int
 	Cost         float64
 	CacheStatus  string
+	// CacheReason says why the response was or wasn't served from a cache,
+	// e.g. "ttl_expired" or "hit score=0.97".
+	CacheReason  string
 	ProviderName string
+	// UpstreamLatency is the time spent in provider calls, including
+	// validation retries. Zero for cache hits.
+	UpstreamLatency time.Duration
+	// Meta carries values the answering stage reports to the handler; see
+	// Metadata.
+	Meta Metadata
 }
 
 // ErrorResponse represents an OpenAI-compatible error.
@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"slices"
 	"testing"
 )
 
@@ -38,6 +39,55 @@ func TestChatRequest_JSONRoundtrip(t *testing.T) {
 	}
 }
 
+func TestChatRequest_Seed(t *testing.T) {
+	var req ChatRequest
+	if err := json.Unmarshal([]byte(`{"model":"gpt-4o","seed":42}`), &req); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if req.Seed == nil || *req.Seed != 42 {
+		t.Fatal("expected seed 42")
+	}
+
+	data, _ := json.Marshal(ChatRequest{Model: "gpt-4o"})
+	if string(data) != `{"model":"gpt-4o","messages":null,"stream":false}` {
+		t.Errorf("expected seed to be omitted when unset, got %s", data)
+	}
+}
+
+func TestChatRequest_MaxOutputTokens(t *testing.T) {
+	var req ChatRequest
+	if err := json.Unmarshal([]byte(`{"model":"o3","max_tokens":100,"max_completion_tokens":200}`), &req); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if n := req.MaxOutputTokens(); n == nil || *n != 200 {
+		t.Errorf("expected max_completion_tokens to win, got %v", n)
+	}
+	req.MaxCompletionTokens = nil
+	if n := req.MaxOutputTokens(); n == nil || *n != 100 {
+		t.Errorf("expected max_tokens fallback, got %v", n)
+	}
+	req.MaxTokens = nil
+	if n := req.MaxOutputTokens(); n != nil {
+		t.Errorf("expected nil, got %d", *n)
+	}
+}
+
+func TestMessage_ReasoningContent(t *testing.T) {
+	var resp ChatResponse
+	body := `{"choices":[{"index":0,"message":{"role":"assistant","content":"42","reasoning_content":"6*7"}}]}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if resp.Choices[0].Message.ReasoningContent != "6*7" {
+		t.Errorf("expected reasoning_content to be preserved, got %q", resp.Choices[0].Message.ReasoningContent)
+	}
+
+	data, _ := json.Marshal(Message{Role: "user", Content: "hi"})
+	if string(data) != `{"role":"user","content":"hi"}` {
+		t.Errorf("expected reasoning_content to be omitted when empty, got %s", data)
+	}
+}
+
 func TestChatResponse_JSONRoundtrip(t *testing.T) {
 	resp := ChatResponse{
 		ID:      "chatcmpl-123",
@@ -156,3 +206,54 @@ func TestErrorResponse_JSON(t *testing.T) {
 		t.Errorf("expected 'model not found', got %q", decoded.Error.Message)
 	}
 }
+
+func TestUnknownFields(t *testing.T) {
+	body := `{"model":"gpt-4o","Temperature":0.2,"tools":[{"type":"function"}],"metadata":{"a":"b"},
+		"stop":["x"],"messages":[{"role":"user","content":"hi","name":"bob"},{"role":"user","content":"yo","name":"al"}]}`
+	got := UnknownFields([]byte(body), &ChatRequest{})
+	want := []string{"messages[].name", "tools"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("UnknownFields = %v, want %v", got, want)
+	}
+
+	resp := `{"id":"x","service_tier":"default","choices":[{"index":0,"logprobs":null,"message":{"role":"assistant","content":"hi","refusal":null}}]}`
+	got = UnknownFields([]byte(resp), &ChatResponse{})
+	want = []string{"choices[].logprobs", "choices[].message.refusal", "service_tier"}
+	if len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("UnknownFields = %v, want %v", got, want)
+	}
+
+	if got := UnknownFields([]byte(`[1,2]`), &ChatRequest{}); got != nil {
+		t.Errorf("expected nil for a non-object, got %v", got)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tenant := NewKey[string]("tenant")
+	redactions := NewKey[map[string]string]("redactions")
+	count := NewKey[int]("tenant") // same name, different type
+
+	var req ProxyRequest
+	if _, ok := tenant.Get(req.Meta); ok {
+		t.Fatal("expected nil metadata to be empty")
+	}
+	tenant.Set(&req.Meta, "acme")
+	redactions.Set(&req.Meta, map[string]string{"[EMAIL_1]": "a@b.c"})
+
+	if v, ok := tenant.Get(req.Meta); !ok || v != "acme" {
+		t.Errorf("tenant = %q, %v", v, ok)
+	}
+	if m, _ := redactions.Get(req.Meta); m["[EMAIL_1]"] != "a@b.c" {
+		t.Errorf("unexpected redactions %v", m)
+	}
+	if _, ok := count.Get(req.Meta); ok {
+		t.Error("expected keys of different types not to collide")
+	}
+	if names := req.Meta.Names(); !slices.Equal(names, []string{"redactions", "tenant"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	tenant.Delete(req.Meta)
+	if _, ok := tenant.Get(req.Meta); ok {
+		t.Error("expected tenant to be deleted")
+	}
+}
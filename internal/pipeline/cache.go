@@ -8,15 +8,21 @@ import (
 	"github.com/eduardmaghakyan/qlite/internal/sse"
 )
 
+// cacheReason is why the caches stages run so far did not answer a
+// request; the pipeline reports it on responses that don't give their own.
+var cacheReason = model.NewKey[string]("cache_reason")
+
 // CacheStage checks the exact-match cache before dispatching to a provider.
 // It implements both Stage and StreamStage.
 type CacheStage struct {
 	cache             *cache.ExactCache
 	skipTempAboveZero bool
+	truncator         *ReplayTruncator
 }
 
 // NewCacheStage creates a new CacheStage.
-// If skipTempAboveZero is true, requests with temperature explicitly > 0 bypass the cache.
+// If skipTempAboveZero is true, unseeded requests with temperature above the
+// cache's SamplingPolicy.MaxTemperature (0 by default) bypass the cache.
 func NewCacheStage(c *cache.ExactCache, skipTempAboveZero bool) *CacheStage {
 	return &CacheStage{
 		cache:             c,
@@ -26,26 +32,41 @@ func NewCacheStage(c *cache.ExactCache, skipTempAboveZero bool) *CacheStage {
 
 func (s *CacheStage) Name() string { return "cache" }
 
+// SetReplayTruncator cuts hits down to the max_tokens of the request they
+// answer. nil, the default, replays them whole. Must be called before
+// serving.
+func (s *CacheStage) SetReplayTruncator(t *ReplayTruncator) {
+	s.truncator = t
+}
+
 // Process handles non-streaming cache lookup.
 // Returns nil to pass through to the next stage on miss.
 func (s *CacheStage) Process(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
-	if s.shouldSkip(req) {
+	trace := TraceFrom(ctx)
+	if reason := s.skipReason(req); reason != "" {
+		trace.Decide(s.Name(), "bypass: %s", reason)
+		cacheReason.Set(&req.Meta, reason)
 		return nil, nil
 	}
 
-	key := cache.KeyFor(&req.ChatRequest)
+	key := s.cache.Key(&req.ChatRequest)
 	req.CacheKey = key
 
-	entry, ok := s.cache.GetByKey(key)
-	if !ok {
+	entry, miss := s.cache.Lookup(key)
+	if miss != "" {
+		trace.Decide(s.Name(), "miss key=%s: %s", key, miss)
+		cacheReason.Set(&req.Meta, miss)
 		return nil, nil
 	}
+	trace.Decide(s.Name(), "hit key=%s", key)
+	cached := s.truncator.apply(ctx, s.Name(), &req.ChatRequest, entry.Response)
 
 	return &model.ProxyResponse{
-		ChatResponse: entry.Response,
-		OutputTokens: entry.Response.Usage.CompletionTokens,
+		ChatResponse: cached,
+		OutputTokens: cached.Usage.CompletionTokens,
 		Cost:         0,
 		CacheStatus:  "HIT",
+		CacheReason:  "hit",
 		ProviderName: "cache",
 	}, nil
 }
@@ -53,39 +74,70 @@ func (s *CacheStage) Process(ctx context.Context, req *model.ProxyRequest) (*mod
 // ProcessStream handles streaming cache lookup.
 // On hit, replays the cached response as SSE events.
 func (s *CacheStage) ProcessStream(ctx context.Context, req *model.ProxyRequest, sw sse.Writer) (*model.ProxyResponse, error) {
-	if s.shouldSkip(req) {
+	trace := TraceFrom(ctx)
+	if reason := s.skipReason(req); reason != "" {
+		trace.Decide(s.Name(), "bypass: %s", reason)
+		cacheReason.Set(&req.Meta, reason)
+		sw.SetHeader("X-Cache-Reason", reason)
 		return nil, nil
 	}
 
-	key := cache.KeyFor(&req.ChatRequest)
+	key := s.cache.Key(&req.ChatRequest)
 	req.CacheKey = key
 
-	entry, ok := s.cache.GetByKey(key)
-	if !ok {
+	entry, miss := s.cache.Lookup(key)
+	if miss != "" {
+		trace.Decide(s.Name(), "miss key=%s: %s", key, miss)
+		cacheReason.Set(&req.Meta, miss)
+		sw.SetHeader("X-Cache-Reason", miss)
 		return nil, nil
 	}
+	trace.Decide(s.Name(), "hit key=%s", key)
+	cached := s.truncator.apply(ctx, s.Name(), &req.ChatRequest, entry.Response)
 
 	sw.SetHeader("X-Cache", "HIT")
+	sw.SetHeader("X-Cache-Reason", "hit")
 	sw.SetHeader("X-Provider", "cache")
+	setFingerprintHeader(sw, cached)
 
-	if err := sse.WriteResponseAsSSE(sw, entry.Response); err != nil {
+	if err := sse.WriteResponseAsSSE(sw, cached); err != nil {
 		return nil, err
 	}
 
 	return &model.ProxyResponse{
-		ChatResponse: entry.Response,
-		OutputTokens: entry.Response.Usage.CompletionTokens,
+		ChatResponse: cached,
+		OutputTokens: cached.Usage.CompletionTokens,
 		Cost:         0,
 		CacheStatus:  "HIT",
+		CacheReason:  "hit",
 		ProviderName: "cache",
 	}, nil
 }
 
-// shouldSkip returns true if this request should bypass the cache.
-func (s *CacheStage) shouldSkip(req *model.ProxyRequest) bool {
+// setFingerprintHeader exposes the system_fingerprint of a replayed response,
+// since replayed chunks don't carry it.
+func setFingerprintHeader(sw sse.Writer, resp *model.ChatResponse) {
+	if resp.SystemFingerprint != "" {
+		sw.SetHeader("X-QLite-System-Fingerprint", resp.SystemFingerprint)
+	}
+}
+
+// skipReason returns why this request should bypass the cache, as reported
+// in X-Cache-Reason, or "" if it shouldn't.
+func (s *CacheStage) skipReason(req *model.ProxyRequest) string {
+	if req.NoCache {
+		return "no_cache"
+	}
+	if s.cache.Bypass(&req.ChatRequest) {
+		return "volatile_content"
+	}
 	if !s.skipTempAboveZero {
-		return false
+		return ""
+	}
+	// Only skip when temperature is explicitly set above the tolerated ceiling
+	// and no seed makes the response reproducible.
+	if !s.cache.SamplingPolicy().Allows(&req.ChatRequest) {
+		return "temp_above_zero"
 	}
-	// Only skip when temperature is explicitly set to > 0.
-	return req.ChatRequest.Temperature != nil && *req.ChatRequest.Temperature > 0
+	return ""
 }
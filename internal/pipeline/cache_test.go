@@ -47,6 +47,9 @@ func TestCacheStage_Miss(t *testing.T) {
 	if resp != nil {
 		t.Fatal("expected nil response on cache miss")
 	}
+	if reason, _ := cacheReason.Get(req.Meta); reason != cache.MissNotCached {
+		t.Errorf("expected reason %s, got %q", cache.MissNotCached, reason)
+	}
 }
 
 func TestCacheStage_HitNonStreaming(t *testing.T) {
@@ -82,6 +85,9 @@ func TestCacheStage_HitNonStreaming(t *testing.T) {
 	if resp.ChatResponse.ID != "chatcmpl-cached" {
 		t.Errorf("expected cached response ID, got %s", resp.ChatResponse.ID)
 	}
+	if resp.CacheReason != "hit" {
+		t.Errorf("expected reason hit, got %q", resp.CacheReason)
+	}
 }
 
 func TestCacheStage_HitStreaming(t *testing.T) {
@@ -146,6 +152,9 @@ func TestCacheStage_TemperatureAboveZeroSkips(t *testing.T) {
 	if resp != nil {
 		t.Fatal("expected nil response when temperature > 0")
 	}
+	if reason, _ := cacheReason.Get(req.Meta); reason != "temp_above_zero" {
+		t.Errorf("expected reason temp_above_zero, got %q", reason)
+	}
 }
 
 func TestCacheStage_NilTemperatureUsesCache(t *testing.T) {
@@ -195,6 +204,75 @@ func TestCacheStage_TemperatureZeroUsesCache(t *testing.T) {
 	}
 }
 
+func TestCacheStage_TemperatureWithinCeilingUsesCache(t *testing.T) {
+	c := cache.New(time.Hour, 100)
+	c.SetSamplingPolicy(cache.SamplingPolicy{MaxTemperature: 0.3, IgnoreTopP: true})
+	stage := NewCacheStage(c, true)
+
+	stored := model.ChatRequest{
+		Model:       "gpt-4o",
+		Messages:    []model.Message{{Role: "user", Content: "hello"}},
+		Temperature: ptrFloat(0.2),
+		TopP:        ptrFloat(0.9),
+	}
+	c.Put(&stored, cachedResponse())
+
+	// Same temperature, different top_p: should hit because top_p is ignored.
+	lookup := stored
+	lookup.TopP = ptrFloat(0.5)
+	resp, err := stage.Process(context.Background(), &model.ProxyRequest{ChatRequest: lookup})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp == nil {
+		t.Fatal("expected cache hit for temperature within ceiling")
+	}
+
+	// Above the ceiling still bypasses.
+	hot := stored
+	hot.Temperature = ptrFloat(0.5)
+	req := &model.ProxyRequest{ChatRequest: hot}
+	resp, err = stage.Process(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp != nil || req.CacheKey != "" {
+		t.Fatal("expected bypass for temperature above ceiling")
+	}
+}
+
+func TestCacheStage_SeededTemperatureUsesCache(t *testing.T) {
+	c := cache.New(time.Hour, 100)
+	stage := NewCacheStage(c, true)
+
+	seed := 42
+	chatReq := model.ChatRequest{
+		Model:       "gpt-4o",
+		Messages:    []model.Message{{Role: "user", Content: "hello"}},
+		Temperature: ptrFloat(0.7),
+		Seed:        &seed,
+	}
+	c.Put(&chatReq, cachedResponse())
+
+	resp, err := stage.Process(context.Background(), &model.ProxyRequest{ChatRequest: chatReq})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp == nil {
+		t.Fatal("expected cache hit for seeded request")
+	}
+
+	other := 7
+	chatReq.Seed = &other
+	resp, err = stage.Process(context.Background(), &model.ProxyRequest{ChatRequest: chatReq})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp != nil {
+		t.Fatal("expected miss for a different seed")
+	}
+}
+
 func TestCacheStage_FullPipelineIntegration(t *testing.T) {
 	expected := model.ChatResponse{
 		ID:      "chatcmpl-integration",
@@ -274,3 +352,65 @@ func TestCacheStage_FullPipelineIntegration(t *testing.T) {
 		t.Errorf("expected still 1 upstream call, got %d", callCount)
 	}
 }
+
+func TestCacheStage_TruncatesReplays(t *testing.T) {
+	c := cache.New(time.Hour, 100)
+	stage := NewCacheStage(c, true)
+	stage.SetReplayTruncator(NewReplayTruncator(tokenizer.NewCounter()))
+
+	// Without an encoding for the model, a token is 4 bytes.
+	long := &model.ChatResponse{
+		ID:      "chatcmpl-long",
+		Model:   "unknown-model",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "0123456789abcdef"}, FinishReason: "stop"}},
+		Usage:   model.Usage{PromptTokens: 10, CompletionTokens: 4, TotalTokens: 14},
+	}
+	messages := []model.Message{{Role: "user", Content: "hello"}}
+	c.Put(&model.ChatRequest{Model: "unknown-model", Messages: messages}, long)
+
+	limit := 2
+	for _, stream := range []bool{false, true} {
+		req := &model.ProxyRequest{ChatRequest: model.ChatRequest{Model: "unknown-model", Messages: messages, MaxTokens: &limit, Stream: stream}}
+		var resp *model.ProxyResponse
+		var err error
+		sw := newTestSSEWriter()
+		if stream {
+			resp, err = stage.ProcessStream(context.Background(), req, sw)
+		} else {
+			resp, err = stage.Process(context.Background(), req)
+		}
+		if err != nil || resp == nil {
+			t.Fatalf("stream=%t: expected a hit, got %v, %v", stream, resp, err)
+		}
+		choice := resp.ChatResponse.Choices[0]
+		if choice.Message.Content != "01234567" || choice.FinishReason != "length" {
+			t.Errorf("stream=%t: expected content cut to 2 tokens, got %q (%s)", stream, choice.Message.Content, choice.FinishReason)
+		}
+		if u := resp.ChatResponse.Usage; u.CompletionTokens != 2 || u.TotalTokens != 12 || resp.OutputTokens != 2 {
+			t.Errorf("stream=%t: usage = %+v, output tokens %d", stream, u, resp.OutputTokens)
+		}
+		if stream {
+			var content string
+			for _, e := range sw.events {
+				var chunk model.ChatStreamChunk
+				json.Unmarshal([]byte(e), &chunk)
+				for _, ch := range chunk.Choices {
+					content += ch.Delta.Content
+				}
+			}
+			if content != "01234567" {
+				t.Errorf("expected the replayed stream to be cut too, got %q", content)
+			}
+		}
+	}
+	if long.Choices[0].Message.Content != "0123456789abcdef" {
+		t.Error("truncation modified the cached entry")
+	}
+
+	// A request allowing the whole answer gets it unchanged.
+	limit = 10
+	req := &model.ProxyRequest{ChatRequest: model.ChatRequest{Model: "unknown-model", Messages: messages, MaxTokens: &limit}}
+	if resp, _ := stage.Process(context.Background(), req); resp.ChatResponse.Choices[0].FinishReason != "stop" {
+		t.Errorf("expected the whole answer, got %+v", resp.ChatResponse.Choices[0])
+	}
+}
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// ErrChaos is the failure injected by Chaos.
+var ErrChaos = errors.New("chaos: injected failure")
+
+// ChaosConfig sets how often Chaos injects each fault. Rates are
+// probabilities between 0 and 1.
+type ChaosConfig struct {
+	Seed      int64 // 0 picks a random seed, reported by Stats
+	DelayRate float64
+	MaxDelay  time.Duration
+	ErrorRate float64
+	DropRate  float64
+}
+
+// Chaos injects random delays and errors before each stage and upstream
+// call, and drops random streamed chunks, so the race, retry and fallback
+// logic can be exercised under failure. Every decision is drawn from one
+// seeded source: a sequential run with the same seed injects the same
+// faults, while concurrent requests draw in whatever order they arrive.
+// A nil *Chaos injects nothing.
+type Chaos struct {
+	cfg ChaosConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	delays atomic.Uint64
+	errors atomic.Uint64
+	drops  atomic.Uint64
+}
+
+// ChaosStats counts the faults injected so far.
+type ChaosStats struct {
+	Seed   int64  `json:"seed"`
+	Delays uint64 `json:"delays"`
+	Errors uint64 `json:"errors"`
+	Drops  uint64 `json:"drops"`
+}
+
+// NewChaos creates a Chaos injecting faults at the rates in cfg.
+func NewChaos(cfg ChaosConfig) *Chaos {
+	if cfg.Seed == 0 {
+		cfg.Seed = rand.Int64()
+	}
+	return &Chaos{cfg: cfg, rng: rand.New(rand.NewPCG(uint64(cfg.Seed), 0))}
+}
+
+// Stats returns the seed and the number of faults injected.
+func (c *Chaos) Stats() ChaosStats {
+	return ChaosStats{
+		Seed:   c.cfg.Seed,
+		Delays: c.delays.Load(),
+		Errors: c.errors.Load(),
+		Drops:  c.drops.Load(),
+	}
+}
+
+// chance reports whether an event with probability rate happens.
+func (c *Chaos) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	return c.float() < rate
+}
+
+func (c *Chaos) float() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64()
+}
+
+// inject runs before the boundary named point: it may sleep for up to
+// MaxDelay, then may fail with ErrChaos. Injected faults are recorded on the
+// request trace.
+func (c *Chaos) inject(ctx context.Context, point string) error {
+	if c == nil {
+		return nil
+	}
+	trace := TraceFrom(ctx)
+	if c.chance(c.cfg.DelayRate) {
+		d := time.Duration(c.float() * float64(c.cfg.MaxDelay))
+		c.delays.Add(1)
+		trace.Decide("chaos", "delaying %s by %s", point, d)
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if c.chance(c.cfg.ErrorRate) {
+		c.errors.Add(1)
+		trace.Decide("chaos", "failing %s", point)
+		return fmt.Errorf("%s: %w", point, ErrChaos)
+	}
+	return nil
+}
+
+// writer wraps sw to drop streamed chunks at DropRate.
+func (c *Chaos) writer(sw sse.Writer) sse.Writer {
+	if c == nil || c.cfg.DropRate <= 0 {
+		return sw
+	}
+	return &chaosWriter{Writer: sw, chaos: c}
+}
+
+// chaosWriter silently discards some events. It does not implement
+// sse.RawWriter, so providers frame each event through WriteEvent.
+type chaosWriter struct {
+	sse.Writer
+	chaos *Chaos
+}
+
+func (w *chaosWriter) WriteEvent(data []byte) error {
+	if w.chaos.chance(w.chaos.cfg.DropRate) {
+		w.chaos.drops.Add(1)
+		return nil
+	}
+	return w.Writer.WriteEvent(data)
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestChaos_Deterministic(t *testing.T) {
+	pattern := func(seed int64) []bool {
+		c := NewChaos(ChaosConfig{Seed: seed, ErrorRate: 0.5})
+		var out []bool
+		for range 32 {
+			out = append(out, c.inject(context.Background(), "x") != nil)
+		}
+		return out
+	}
+	a, b := pattern(42), pattern(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed diverged at %d", i)
+		}
+	}
+	if NewChaos(ChaosConfig{}).Stats().Seed == 0 {
+		t.Error("expected a random seed to be picked")
+	}
+}
+
+func TestChaos_Pipeline(t *testing.T) {
+	srv, calls := flakyStreamServer(0, 0)
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	pipe, err := New(NewCacheStage(cache.New(0, 10), true), dispatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chaos := NewChaos(ChaosConfig{Seed: 1, ErrorRate: 1})
+	pipe.SetChaos(chaos)
+	if _, err := pipe.ExecuteStream(context.Background(), streamReq(), newTestSSEWriter()); !errors.Is(err, ErrChaos) {
+		t.Fatalf("expected ErrChaos, got %v", err)
+	}
+	if calls.Load() != 0 || chaos.Stats().Errors != 1 {
+		t.Errorf("expected failure before the first stage, got %d calls, %+v", calls.Load(), chaos.Stats())
+	}
+
+	// Upstream failures go through the stream retry logic.
+	pipe.SetChaos(nil)
+	dispatch.SetChaos(chaos)
+	dispatch.SetStreamRetries(2)
+	if _, err := pipe.ExecuteStream(context.Background(), streamReq(), newTestSSEWriter()); !errors.Is(err, ErrChaos) {
+		t.Fatalf("expected ErrChaos, got %v", err)
+	}
+	if calls.Load() != 0 || chaos.Stats().Errors != 4 {
+		t.Errorf("expected 3 injected upstream failures, got %d calls, %+v", calls.Load(), chaos.Stats())
+	}
+
+	drops := NewChaos(ChaosConfig{Seed: 1, DropRate: 1})
+	dispatch.SetChaos(drops)
+	sw := newTestSSEWriter()
+	if _, err := pipe.ExecuteStream(context.Background(), streamReq(), sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sw.events) != 0 || !sw.done || drops.Stats().Drops != 1 {
+		t.Errorf("expected the chunk dropped and the stream finished, got %q, %+v", sw.events, drops.Stats())
+	}
+}
+
+func TestChaos_DelayRespectsContext(t *testing.T) {
+	c := NewChaos(ChaosConfig{Seed: 1, DelayRate: 1, MaxDelay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.inject(ctx, "x"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
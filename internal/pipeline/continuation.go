@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// DefaultContinuationPrompt is the user message that asks the model to pick
+// up a response cut off by max_tokens.
+const DefaultContinuationPrompt = "Continue exactly where your previous message stopped. Do not repeat anything and do not add any preamble."
+
+// SetContinuationPrompt sets the user message sent after the partial
+// response when continuing it (see model.ProxyRequest.Continuations). Empty
+// uses DefaultContinuationPrompt. Must be called before the stage is used.
+func (d *DispatchStage) SetContinuationPrompt(prompt string) {
+	d.continuationPrompt = prompt
+}
+
+// continuation returns req followed by the partial response content and a
+// request to continue it.
+func (d *DispatchStage) continuation(req *model.ChatRequest, content string) *model.ChatRequest {
+	prompt := d.continuationPrompt
+	if prompt == "" {
+		prompt = DefaultContinuationPrompt
+	}
+	c := *req
+	c.Messages = append(slices.Clip(req.Messages),
+		model.Message{Role: "assistant", Content: content},
+		model.Message{Role: "user", Content: prompt},
+	)
+	return &c
+}
+
+// continueChat extends resp, as long as it was cut off by max_tokens, with up
+// to rounds follow-up calls to p, and returns the time they took. Content is
+// appended and usage summed, so cost covers every call. A failed follow-up
+// ends the continuation and leaves resp as it was. Stitched responses are
+// never cached: the same request without the opt-in must not get them.
+func (d *DispatchStage) continueChat(ctx context.Context, p provider.Provider, req *model.ChatRequest, resp *model.ChatResponse, rounds int) time.Duration {
+	trace := TraceFrom(ctx)
+	var latency time.Duration
+	for round := 1; round <= rounds && len(resp.Choices) == 1 && resp.Choices[0].FinishReason == "length"; round++ {
+		trace.Decide(d.Name(), "continuing response cut off by max_tokens (round %d)", round)
+		d.upstreamRequests.Add(1)
+		start := time.Now()
+		err := d.chaos.inject(ctx, "provider "+p.Name())
+		var next *model.ChatResponse
+		if err == nil {
+			next, err = p.Chat(ctx, d.continuation(req, resp.Choices[0].Message.Content))
+		}
+		elapsed := time.Since(start)
+		latency += elapsed
+		d.router.Report(p.Name(), err)
+		if err == nil {
+			err = validateResponse(next, d.rejectEmpty)
+		}
+		trace.Upstream(p.Name(), elapsed, err)
+		if err != nil {
+			d.countError(err)
+			trace.Decide(d.Name(), "continuation failed, returning the response so far: %v", err)
+			break
+		}
+		c := &resp.Choices[0]
+		c.Message.Content += next.Choices[0].Message.Content
+		c.FinishReason = next.Choices[0].FinishReason
+		addUsage(&resp.Usage, next.Usage)
+		resp.NoStore = true
+	}
+	return latency
+}
+
+// continueStream runs follow-up rounds on p while the stream relayed through
+// cw was cut off by max_tokens, up to rounds in total. usage is the first
+// round's; it returns the usage of every round and the time the follow-ups
+// took. Content has been sent by then, so a failed follow-up fails the
+// stream.
+func (d *DispatchStage) continueStream(ctx context.Context, p provider.Provider, req *model.ChatRequest, cw *continueWriter, rounds int, usage *model.Usage) (*model.Usage, time.Duration, error) {
+	trace := TraceFrom(ctx)
+	var total model.Usage
+	if usage != nil {
+		total = *usage
+	}
+	var latency time.Duration
+	for round := 1; cw.cut; round++ {
+		trace.Decide(d.Name(), "continuing stream cut off by max_tokens (round %d)", round)
+		cw.prior = total
+		cw.startRound(round < rounds)
+		d.upstreamRequests.Add(1)
+		start := time.Now()
+		err := d.chaos.inject(ctx, "provider "+p.Name())
+		var u *model.Usage
+		if err == nil {
+			u, err = p.ChatStream(ctx, d.continuation(req, cw.content.String()), cw)
+		}
+		elapsed := time.Since(start)
+		latency += elapsed
+		d.router.Report(p.Name(), err)
+		trace.Upstream(p.Name(), elapsed, err)
+		if err != nil {
+			d.countError(err)
+			return nil, latency, fmt.Errorf("continuing stream from provider %s: %w", p.Name(), err)
+		}
+		if u != nil {
+			addUsage(&total, *u)
+		}
+		usage = &total
+	}
+	return usage, latency, nil
+}
+
+// singleChoice reports whether req asks for one choice; only those are
+// continued.
+func singleChoice(req *model.ChatRequest) bool {
+	return req.N == nil || *req.N <= 1
+}
+
+func addUsage(u *model.Usage, v model.Usage) {
+	u.PromptTokens += v.PromptTokens
+	u.CompletionTokens += v.CompletionTokens
+	u.TotalTokens += v.TotalTokens
+	u.CacheCreationInputTokens += v.CacheCreationInputTokens
+	u.CacheReadInputTokens += v.CacheReadInputTokens
+}
+
+// continueWriter relays a stream that may be continued. While more rounds
+// are allowed, a chunk finishing with "length" is relayed without its
+// finish_reason, and that round's usage chunk and [DONE] are held back, so
+// the client sees one stream. The final round's usage chunk carries the
+// usage of every round. It does not implement sse.RawWriter: chunks have to
+// be inspected one by one.
+type continueWriter struct {
+	sse.Writer
+	content strings.Builder
+	more    bool        // another round is allowed after this one
+	cut     bool        // this round ended with finish_reason length
+	prior   model.Usage // usage of the rounds before this one
+}
+
+// startRound resets the per-round state; more reports whether another round
+// may follow this one.
+func (w *continueWriter) startRound(more bool) {
+	w.more, w.cut = more, false
+}
+
+func (w *continueWriter) WriteEvent(data []byte) error {
+	var chunk model.ChatStreamChunk
+	if json.Unmarshal(data, &chunk) != nil || len(chunk.Choices) > 1 {
+		return w.Writer.WriteEvent(data)
+	}
+	changed := false
+	if len(chunk.Choices) == 1 {
+		c := &chunk.Choices[0]
+		w.content.WriteString(c.Delta.Content)
+		if c.FinishReason == "length" && w.more {
+			w.cut = true
+			c.FinishReason = ""
+			changed = true
+			if c.Delta.IsZero() && chunk.Usage == nil {
+				return nil
+			}
+		}
+	}
+	if chunk.Usage != nil {
+		switch {
+		case w.cut && len(chunk.Choices) == 0:
+			return nil
+		case w.cut:
+			chunk.Usage = nil
+			changed = true
+		case w.prior != (model.Usage{}):
+			addUsage(chunk.Usage, w.prior)
+			changed = true
+		}
+	}
+	if changed {
+		b, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+	return w.Writer.WriteEvent(data)
+}
+
+func (w *continueWriter) Done() error {
+	if w.cut {
+		return nil
+	}
+	return w.Writer.Done()
+}
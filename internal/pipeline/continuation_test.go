@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+// truncatingServer answers with "part0", "part1", ... depending on how many
+// parts the partial response it is asked to continue has, cut off by
+// max_tokens until the third part. Every call uses 10 prompt and 5
+// completion tokens.
+func truncatingServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		round := 0
+		if len(req.Messages) > 1 {
+			last := req.Messages[len(req.Messages)-2:]
+			if len(req.Messages) != 3 || last[0].Role != "assistant" || last[1].Content != DefaultContinuationPrompt {
+				t.Errorf("unexpected continuation messages %+v", req.Messages)
+			}
+			round = strings.Count(last[0].Content, "part")
+		}
+		finish := "length"
+		if round == 2 {
+			finish = "stop"
+		}
+		content := fmt.Sprintf("part%d", round)
+		if !req.Stream {
+			json.NewEncoder(w).Encode(model.ChatResponse{
+				Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: content}, FinishReason: finish}},
+				Usage:   model.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+		fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":%q}]}\n\n", finish)
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":5,\"total_tokens\":15}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestDispatchStage_Continuation(t *testing.T) {
+	srv := truncatingServer(t)
+	defer srv.Close()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+
+	for _, tc := range []struct {
+		rounds  int
+		content string
+		finish  string
+		calls   int
+	}{
+		{0, "part0", "length", 1},
+		{1, "part0part1", "length", 2},
+		{5, "part0part1part2", "stop", 3},
+	} {
+		req := &model.ProxyRequest{
+			ChatRequest:   model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+			Continuations: tc.rounds,
+		}
+		resp, err := dispatch.Process(context.Background(), req)
+		if err != nil {
+			t.Fatalf("rounds %d: unexpected error: %v", tc.rounds, err)
+		}
+		c := resp.ChatResponse.Choices[0]
+		if c.Message.Content != tc.content || c.FinishReason != tc.finish {
+			t.Errorf("rounds %d: got %q (%s), want %q (%s)", tc.rounds, c.Message.Content, c.FinishReason, tc.content, tc.finish)
+		}
+		if u := resp.ChatResponse.Usage; u.PromptTokens != 10*tc.calls || resp.OutputTokens != 5*tc.calls {
+			t.Errorf("rounds %d: expected usage of %d calls, got %+v", tc.rounds, tc.calls, u)
+		}
+		if resp.ChatResponse.NoStore != (tc.calls > 1) {
+			t.Errorf("rounds %d: expected stitched responses not to be cached", tc.rounds)
+		}
+	}
+}
+
+func TestDispatchStage_ContinuationStream(t *testing.T) {
+	srv := truncatingServer(t)
+	defer srv.Close()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+
+	req := streamReq()
+	req.Continuations = 5
+	sw := newTestSSEWriter()
+	resp, err := dispatch.ProcessStream(context.Background(), req, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		`{"choices":[{"index":0,"delta":{"content":"part0"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"part1"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"part2"}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	}
+	if len(sw.events) != len(want)+1 || !sw.done {
+		t.Fatalf("expected one stitched stream, got %q (done %v)", sw.events, sw.done)
+	}
+	for i, w := range want {
+		if sw.events[i] != w {
+			t.Errorf("event %d = %s, want %s", i, sw.events[i], w)
+		}
+	}
+	var last model.ChatStreamChunk
+	json.Unmarshal([]byte(sw.events[len(want)]), &last)
+	if last.Usage == nil || last.Usage.PromptTokens != 30 || last.Usage.CompletionTokens != 15 {
+		t.Errorf("expected the usage chunk to cover every round, got %s", sw.events[len(want)])
+	}
+	if resp.OutputTokens != 15 {
+		t.Errorf("expected output tokens of every round, got %d", resp.OutputTokens)
+	}
+}
@@ -2,19 +2,95 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/eduardmaghakyan/qlite/internal/cache"
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/pricing"
 	"github.com/eduardmaghakyan/qlite/internal/provider"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
+	"github.com/eduardmaghakyan/qlite/internal/stats"
 	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
 )
 
+// InputCounter returns the input tokens of req as the upstream counts them,
+// and false if there is no count to use.
+type InputCounter func(ctx context.Context, req *model.ChatRequest) (int, bool)
+
+type inputCounterKey struct{}
+
+// WithInputCounter returns ctx carrying count. Streaming dispatch reports
+// its result in X-Tokens-Input before sending the request upstream. As
+// dispatch only runs once the cache stages missed, hits are never counted.
+func WithInputCounter(ctx context.Context, count InputCounter) context.Context {
+	return context.WithValue(ctx, inputCounterKey{}, count)
+}
+
 // DispatchStage routes requests to the appropriate provider.
 type DispatchStage struct {
 	registry *provider.Registry
 	counter  *tokenizer.Counter
+	router   *Router
+
+	validationRetries int
+	rejectEmpty       bool
+	streamRetries     int
+	transformers      []ChunkTransformer
+	fingerprints      *cache.Fingerprints
+	chaos             *Chaos
+
+	continuationPrompt string
+
+	upstreamRequests stats.Counter
+	upstreamErrors   stats.Counter
+	rateLimited      stats.Counter
+	authErrors       stats.Counter
+	contextLength    stats.Counter
+	timeouts         stats.Counter
+}
+
+// DispatchStats counts upstream provider calls and failures. Errors counts
+// every failure but calls cut short by a latency budget; the other error counters break down the classified ones
+// (see provider.ErrRateLimited and friends).
+type DispatchStats struct {
+	Requests      uint64 `json:"requests"`
+	Errors        uint64 `json:"errors"`
+	RateLimited   uint64 `json:"rate_limited"`
+	AuthErrors    uint64 `json:"auth_errors"`
+	ContextLength uint64 `json:"context_length"`
+	Timeouts      uint64 `json:"timeouts"`
+}
+
+// Stats returns cumulative upstream call counters.
+func (d *DispatchStage) Stats() DispatchStats {
+	return DispatchStats{
+		Requests:      d.upstreamRequests.Load(),
+		Errors:        d.upstreamErrors.Load(),
+		RateLimited:   d.rateLimited.Load(),
+		AuthErrors:    d.authErrors.Load(),
+		ContextLength: d.contextLength.Load(),
+		Timeouts:      d.timeouts.Load(),
+	}
+}
+
+// countError records a failed provider call.
+func (d *DispatchStage) countError(err error) {
+	d.upstreamErrors.Add(1)
+	switch {
+	case errors.Is(err, provider.ErrRateLimited):
+		d.rateLimited.Add(1)
+	case errors.Is(err, provider.ErrAuth):
+		d.authErrors.Add(1)
+	case errors.Is(err, provider.ErrContextLength):
+		d.contextLength.Add(1)
+	case errors.Is(err, provider.ErrUpstreamTimeout):
+		d.timeouts.Add(1)
+	}
 }
 
 // NewDispatchStage creates a new provider dispatch stage.
@@ -22,60 +98,350 @@ func NewDispatchStage(registry *provider.Registry, counter *tokenizer.Counter) *
 	return &DispatchStage{
 		registry: registry,
 		counter:  counter,
+		router:   NewRouter(registry, RouteFirst, 0),
 	}
 }
 
+// SetRouter replaces the default first-provider routing.
+func (d *DispatchStage) SetRouter(r *Router) {
+	d.router = r
+}
+
+// SetValidationRetries sets how many times a non-streaming request is retried
+// when the upstream response is malformed (see validateResponse). Zero fails
+// immediately with ErrMalformedResponse.
+func (d *DispatchStage) SetValidationRetries(n int) {
+	d.validationRetries = n
+}
+
+// SetRejectEmpty makes a non-streaming response with a choice that finished
+// with "stop" but has no content count as malformed. Tool calls aren't
+// carried in non-streaming responses, so only enable this for providers
+// whose tool-call replies finish with "tool_calls".
+func (d *DispatchStage) SetRejectEmpty(reject bool) {
+	d.rejectEmpty = reject
+}
+
+// SetStreamRetries sets how many times a stream that fails before anything
+// was written to the client is retried, on another provider serving the
+// model if there is one. Once a chunk has been written the failure is
+// final. Zero disables retries.
+func (d *DispatchStage) SetStreamRetries(n int) {
+	d.streamRetries = n
+}
+
+// SetChaos injects faults from c before every upstream call and into
+// streamed responses. Nil disables injection.
+func (d *DispatchStage) SetChaos(c *Chaos) {
+	d.chaos = c
+}
+
+// SetChunkTransformers sets the transformers applied, in order, to every
+// streamed chunk before it is written to the client.
+func (d *DispatchStage) SetChunkTransformers(ts ...ChunkTransformer) {
+	d.transformers = ts
+}
+
+// SetFingerprints records the system_fingerprint of every live non-streaming
+// response in f.
+func (d *DispatchStage) SetFingerprints(f *cache.Fingerprints) {
+	d.fingerprints = f
+}
+
 func (d *DispatchStage) Name() string { return "dispatch" }
 
+// pickedProvider is the provider an earlier stage picked for the request,
+// so dispatch goes where that stage's decision assumed.
+var pickedProvider = model.NewKey[provider.Provider]("picked_provider")
+
+// pick returns the provider for req: the one in pickedProvider, if set,
+// otherwise the router's choice.
+func (d *DispatchStage) pick(req *model.ProxyRequest) (provider.Provider, error) {
+	if p, ok := pickedProvider.Get(req.Meta); ok {
+		return p, nil
+	}
+	return d.router.Pick(req.ChatRequest.Model, req.Provider)
+}
+
 // Process handles non-streaming requests.
 func (d *DispatchStage) Process(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
-	p, err := d.registry.Lookup(req.ChatRequest.Model)
+	p, err := d.pick(req)
 	if err != nil {
 		return nil, fmt.Errorf("looking up provider: %w", err)
 	}
+	ctx, budget, release := withBudget(ctx, req)
+	defer release()
 
-	chatResp, err := p.Chat(ctx, &req.ChatRequest)
-	if err != nil {
-		return nil, fmt.Errorf("calling provider %s: %w", p.Name(), err)
+	trace := TraceFrom(ctx)
+	trace.Decide(d.Name(), "provider %s", p.Name())
+	upstreamReq := req.UpstreamRequest()
+	var chatResp *model.ChatResponse
+	var latency time.Duration
+	for attempt := 0; ; attempt++ {
+		d.upstreamRequests.Add(1)
+		start := time.Now()
+		err = d.chaos.inject(ctx, "provider "+p.Name())
+		if err == nil {
+			chatResp, err = p.Chat(ctx, upstreamReq)
+		}
+		elapsed := time.Since(start)
+		latency += elapsed
+		if err != nil && budgetExpired(ctx) {
+			trace.Upstream(p.Name(), elapsed, err)
+			return nil, budgetErr(ctx, fmt.Errorf("calling provider %s: %w", p.Name(), err))
+		}
+		d.router.Report(p.Name(), err)
+		if err != nil {
+			trace.Upstream(p.Name(), elapsed, err)
+			d.countError(err)
+			return nil, fmt.Errorf("calling provider %s: %w", p.Name(), err)
+		}
+		err = validateResponse(chatResp, d.rejectEmpty)
+		trace.Upstream(p.Name(), elapsed, err)
+		if err == nil {
+			break
+		}
+		d.upstreamErrors.Add(1)
+		if attempt >= d.validationRetries {
+			return nil, fmt.Errorf("provider %s: %w", p.Name(), err)
+		}
 	}
+	budget.stop()
 
+	if req.Continuations > 0 {
+		latency += d.continueChat(ctx, p, upstreamReq, chatResp, req.Continuations)
+	}
+
+	d.fingerprints.Observe(chatResp)
 	outputTokens := chatResp.Usage.CompletionTokens
-	cost := pricing.Calculate(req.ChatRequest.Model, chatResp.Usage.PromptTokens, outputTokens)
+	cost := pricing.CalculateUsageFor(p.Name(), req.ChatRequest.Model, chatResp.Usage)
 
 	return &model.ProxyResponse{
-		ChatResponse: chatResp,
-		OutputTokens: outputTokens,
-		Cost:         cost,
-		CacheStatus:  "MISS",
-		ProviderName: p.Name(),
+		ChatResponse:    chatResp,
+		OutputTokens:    outputTokens,
+		Cost:            cost,
+		CacheStatus:     "MISS",
+		ProviderName:    p.Name(),
+		UpstreamLatency: latency,
 	}, nil
 }
 
 // ProcessStream handles streaming requests.
 func (d *DispatchStage) ProcessStream(ctx context.Context, req *model.ProxyRequest, sw sse.Writer) (*model.ProxyResponse, error) {
-	p, err := d.registry.Lookup(req.ChatRequest.Model)
+	p, err := d.pick(req)
 	if err != nil {
 		return nil, fmt.Errorf("looking up provider: %w", err)
 	}
+	ctx, budget, release := withBudget(ctx, req)
+	defer release()
 
-	usage, err := p.ChatStream(ctx, &req.ChatRequest, sw)
-	if err != nil {
-		return nil, fmt.Errorf("streaming from provider %s: %w", p.Name(), err)
+	sw, progress := trackProgress(sw)
+	progress.first = budget.stop
+	if len(d.transformers) > 0 {
+		sw = &transformWriter{inner: sw, transformers: d.transformers}
+	}
+	sw = d.chaos.writer(sw)
+	var cw *continueWriter
+	if req.Continuations > 0 && singleChoice(&req.ChatRequest) {
+		cw = &continueWriter{Writer: sw}
+		sw = cw
+	}
+
+	trace := TraceFrom(ctx)
+	tried := make(map[string]bool)
+	upstreamReq := req.UpstreamRequest()
+	if count, _ := ctx.Value(inputCounterKey{}).(InputCounter); count != nil {
+		if n, ok := count(ctx, upstreamReq); ok {
+			sw.SetHeader("X-Tokens-Input", strconv.Itoa(n))
+		}
+	}
+	var usage *model.Usage
+	var latency time.Duration
+	for attempt := 0; ; attempt++ {
+		trace.Decide(d.Name(), "provider %s", p.Name())
+		sw.SetHeader("X-Provider", p.Name())
+		tried[p.Name()] = true
+		if cw != nil {
+			cw.content.Reset()
+			cw.startRound(true)
+		}
+		d.upstreamRequests.Add(1)
+		start := time.Now()
+		err = d.chaos.inject(ctx, "provider "+p.Name())
+		if err == nil {
+			usage, err = d.streamFrom(ctx, p, upstreamReq, sw)
+		}
+		elapsed := time.Since(start)
+		latency += elapsed
+		trace.Upstream(p.Name(), elapsed, err)
+		if err != nil && budgetExpired(ctx) {
+			return nil, budgetErr(ctx, fmt.Errorf("streaming from provider %s: %w", p.Name(), err))
+		}
+		d.router.Report(p.Name(), err)
+		if err == nil {
+			break
+		}
+		d.countError(err)
+		var next provider.Provider
+		if !progress.wrote && attempt < d.streamRetries && retryableStream(ctx, err) {
+			next = d.fallback(req, p, err, tried)
+		}
+		if next == nil {
+			return nil, fmt.Errorf("streaming from provider %s: %w", p.Name(), err)
+		}
+		trace.Decide(d.Name(), "retrying stream: nothing sent before %v", err)
+		p = next
+	}
+	if cw != nil {
+		var extra time.Duration
+		usage, extra, err = d.continueStream(ctx, p, upstreamReq, cw, req.Continuations, usage)
+		latency += extra
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	var outputTokens int
-	var inputTokens int
+	var cost float64
 	if usage != nil {
 		outputTokens = usage.CompletionTokens
-		inputTokens = usage.PromptTokens
+		cost = pricing.CalculateUsageFor(p.Name(), req.ChatRequest.Model, *usage)
 	}
 
-	cost := pricing.Calculate(req.ChatRequest.Model, inputTokens, outputTokens)
-
 	return &model.ProxyResponse{
-		OutputTokens: outputTokens,
-		Cost:         cost,
-		CacheStatus:  "MISS",
-		ProviderName: p.Name(),
+		OutputTokens:    outputTokens,
+		Cost:            cost,
+		CacheStatus:     "MISS",
+		ProviderName:    p.Name(),
+		UpstreamLatency: latency,
 	}, nil
 }
+
+// streamFrom streams req from p through sw. If p rejects stream_options it
+// is retried once without them. When p isn't expected to report usage, the
+// relayed content is collected so missing usage can be estimated.
+func (d *DispatchStage) streamFrom(ctx context.Context, p provider.Provider, req *model.ChatRequest, sw sse.Writer) (*model.Usage, error) {
+	for retried := false; ; retried = true {
+		var est *usageEstimator
+		w := sw
+		if r, ok := p.(provider.StreamUsageReporter); ok && !r.ReportsStreamUsage() {
+			est = &usageEstimator{Writer: sw}
+			w = est
+		}
+		usage, err := p.ChatStream(ctx, req, w)
+		if !retried && errors.Is(err, provider.ErrStreamOptionsRejected) {
+			TraceFrom(ctx).Decide(d.Name(), "provider %s rejected stream_options, retrying without", p.Name())
+			continue
+		}
+		if err == nil && usage == nil && est != nil {
+			usage = est.estimate(d.counter, req)
+			TraceFrom(ctx).Decide(d.Name(), "no usage reported, estimated %d prompt and %d completion tokens", usage.PromptTokens, usage.CompletionTokens)
+		}
+		return usage, err
+	}
+}
+
+// usageEstimator collects the content streamed through it, for streams
+// that end without usage. It does not implement sse.RawWriter: chunks have
+// to be inspected one by one.
+type usageEstimator struct {
+	sse.Writer
+	content strings.Builder
+}
+
+func (w *usageEstimator) WriteEvent(data []byte) error {
+	var chunk model.ChatStreamChunk
+	if json.Unmarshal(data, &chunk) == nil {
+		for _, c := range chunk.Choices {
+			w.content.WriteString(c.Delta.ReasoningContent)
+			w.content.WriteString(c.Delta.Content)
+		}
+	}
+	return w.Writer.WriteEvent(data)
+}
+
+// estimate counts req's messages and the collected content with counter.
+func (w *usageEstimator) estimate(counter *tokenizer.Counter, req *model.ChatRequest) *model.Usage {
+	u := &model.Usage{
+		PromptTokens:     counter.CountMessages(req.Model, req.Messages),
+		CompletionTokens: counter.CountText(req.Model, w.content.String()),
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// fallback returns the provider for the next attempt at req after failed
+// returned err: the candidate for the model the router picks among those not
+// tried yet (see Router.Retry), or failed itself if none is left or the
+// request forces a provider. It returns nil if only failed is left and it
+// rejected the credentials or is rate limited.
+func (d *DispatchStage) fallback(req *model.ProxyRequest, failed provider.Provider, err error, tried map[string]bool) provider.Provider {
+	if req.Provider == "" {
+		if next := d.router.Retry(req.ChatRequest.Model, tried); next != nil {
+			return next
+		}
+	}
+	if errors.Is(err, provider.ErrAuth) || errors.Is(err, provider.ErrRateLimited) {
+		return nil
+	}
+	return failed
+}
+
+// retryableStream reports whether a stream that failed with err may succeed
+// on another attempt. Requests the upstream rejected as invalid, or too long,
+// would fail again.
+func retryableStream(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, provider.ErrContextLength) {
+		return false
+	}
+	var ue *provider.UpstreamError
+	if errors.As(err, &ue) && ue.Status >= 400 && ue.Status < 500 {
+		return ue.Kind != nil
+	}
+	return true
+}
+
+// progressWriter records whether anything was written to the client.
+type progressWriter struct {
+	sse.Writer
+	wrote bool
+	first func() // if set, called before the first write
+}
+
+func (w *progressWriter) mark() {
+	if !w.wrote && w.first != nil {
+		w.first()
+	}
+	w.wrote = true
+}
+
+func (w *progressWriter) WriteEvent(data []byte) error {
+	w.mark()
+	return w.Writer.WriteEvent(data)
+}
+
+func (w *progressWriter) Done() error {
+	w.mark()
+	return w.Writer.Done()
+}
+
+// rawProgressWriter is a progressWriter that keeps its writer's raw fast
+// path available to providers.
+type rawProgressWriter struct {
+	*progressWriter
+	raw sse.RawWriter
+}
+
+func (w rawProgressWriter) WriteRaw(p []byte) error {
+	w.mark()
+	return w.raw.WriteRaw(p)
+}
+
+// trackProgress wraps sw to record whether anything was written through it.
+func trackProgress(sw sse.Writer) (sse.Writer, *progressWriter) {
+	pw := &progressWriter{Writer: sw}
+	if raw, ok := sw.(sse.RawWriter); ok {
+		return rawProgressWriter{progressWriter: pw, raw: raw}, pw
+	}
+	return pw, pw
+}
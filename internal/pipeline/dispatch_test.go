@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pricing"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+const streamChunk = `{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"hi"}}]}`
+
+// flakyStreamServer fails the first fail requests with status, then streams
+// one chunk. It reports how many requests it received.
+func flakyStreamServer(fail int, status int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= fail {
+			http.Error(w, `{"error":{"message":"boom"}}`, status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", streamChunk)
+	}))
+	return srv, &calls
+}
+
+func streamReq() *model.ProxyRequest {
+	return &model.ProxyRequest{
+		ChatRequest: model.ChatRequest{Model: "gpt-4o", Stream: true, Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+	}
+}
+
+func TestDispatchStage_StreamRetry(t *testing.T) {
+	srv, calls := flakyStreamServer(1, http.StatusBadGateway)
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), newTestSSEWriter()); err == nil {
+		t.Fatal("expected failure without retries")
+	}
+
+	calls.Store(0)
+	dispatch.SetStreamRetries(1)
+	sw := newTestSSEWriter()
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 2 || len(sw.events) != 1 || !sw.done {
+		t.Errorf("expected one retry and a complete stream, got %d calls, events %q", calls.Load(), sw.events)
+	}
+	if s := dispatch.Stats(); s.Errors != 2 {
+		t.Errorf("expected both failures counted, got %+v", s)
+	}
+}
+
+func TestDispatchStage_StreamUsageEstimated(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, ok := req["stream_options"]; ok {
+			http.Error(w, `{"detail":[{"type":"extra_forbidden","loc":["body","stream_options"],"msg":"Extra inputs are not permitted"}]}`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello there, how can I help?\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+
+	sw := newTestSSEWriter()
+	resp, err := dispatch.ProcessStream(context.Background(), streamReq(), sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 2 || len(sw.events) != 1 || !sw.done {
+		t.Errorf("expected one retry without stream_options, got %d calls, events %q", calls.Load(), sw.events)
+	}
+	if resp.OutputTokens == 0 {
+		t.Error("expected output tokens to be estimated")
+	}
+
+	calls.Store(0)
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), newTestSSEWriter()); err != nil || calls.Load() != 1 {
+		t.Errorf("expected later streams to skip stream_options, got %d calls, err %v", calls.Load(), err)
+	}
+}
+
+func TestDispatchStage_StreamRetryFallsBack(t *testing.T) {
+	bad, badCalls := flakyStreamServer(100, http.StatusServiceUnavailable)
+	defer bad.Close()
+	good, _ := flakyStreamServer(0, 0)
+	defer good.Close()
+
+	// The first provider listed for a model is picked first.
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("bad", bad.URL, "k", []string{"gpt-4o"}))
+	registry.Register(provider.NewOpenAICompat("good", good.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetStreamRetries(2)
+
+	sw := newTestSSEWriter()
+	resp, err := dispatch.ProcessStream(context.Background(), streamReq(), sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if badCalls.Load() != 1 || resp.ProviderName != "good" || sw.headers["X-Provider"] != "good" {
+		t.Errorf("expected fallback to good after one failure, got %d bad calls, provider %s, header %s", badCalls.Load(), resp.ProviderName, sw.headers["X-Provider"])
+	}
+
+	// A forced provider is retried, not replaced.
+	badCalls.Store(0)
+	req := streamReq()
+	req.Provider = "bad"
+	if _, err := dispatch.ProcessStream(context.Background(), req, newTestSSEWriter()); err == nil {
+		t.Fatal("expected forced provider to fail")
+	}
+	if badCalls.Load() != 3 {
+		t.Errorf("expected 3 attempts on the forced provider, got %d", badCalls.Load())
+	}
+}
+
+func TestDispatchStage_StreamRetryUsesRouter(t *testing.T) {
+	bad, _ := flakyStreamServer(100, http.StatusServiceUnavailable)
+	defer bad.Close()
+	down, downCalls := flakyStreamServer(0, 0)
+	defer down.Close()
+	pricey, priceyCalls := flakyStreamServer(0, 0)
+	defer pricey.Close()
+	mid, midCalls := flakyStreamServer(0, 0)
+	defer mid.Close()
+
+	registry := provider.NewRegistry()
+	// Listed in an order where the first untried candidate isn't the pick.
+	for _, p := range []struct{ name, url string }{{"retry-bad", bad.URL}, {"retry-pricey", pricey.URL}, {"retry-down", down.URL}, {"retry-mid", mid.URL}} {
+		registry.Register(provider.NewOpenAICompat(p.name, p.url, "k", []string{"retry-llama"}))
+	}
+	pricing.SetForProvider("retry-bad", "retry-llama", 0.1, 0.1)
+	pricing.SetForProvider("retry-down", "retry-llama", 0.2, 0.2)
+	pricing.SetForProvider("retry-mid", "retry-llama", 0.5, 0.5)
+	pricing.SetForProvider("retry-pricey", "retry-llama", 1, 1)
+	router := NewRouter(registry, RouteCheapest, time.Minute)
+	router.Report("retry-down", errors.New("boom"))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetRouter(router)
+	dispatch.SetStreamRetries(1)
+
+	req := streamReq()
+	req.ChatRequest.Model = "retry-llama"
+	resp, err := dispatch.ProcessStream(context.Background(), req, newTestSSEWriter())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ProviderName != "retry-mid" || midCalls.Load() != 1 || downCalls.Load() != 0 || priceyCalls.Load() != 0 {
+		t.Errorf("expected the retry on the cheapest healthy provider, got %s", resp.ProviderName)
+	}
+}
+
+func TestDispatchStage_StreamRetrySkipsRateLimited(t *testing.T) {
+	srv, calls := flakyStreamServer(1, http.StatusTooManyRequests)
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetStreamRetries(3)
+
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), newTestSSEWriter()); !errors.Is(err, provider.ErrRateLimited) {
+		t.Fatalf("expected the rate limit returned, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected no retry of a 429 on the same provider, got %d calls", calls.Load())
+	}
+}
+
+func TestDispatchStage_StreamRetrySkipsClientErrors(t *testing.T) {
+	srv, calls := flakyStreamServer(1, http.StatusBadRequest)
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetStreamRetries(3)
+
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), newTestSSEWriter()); err == nil {
+		t.Fatal("expected a rejected request to fail")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected no retry of a 400, got %d calls", calls.Load())
+	}
+}
+
+func TestDispatchStage_StreamNoRetryAfterWrite(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", streamChunk)
+		w.(http.Flusher).Flush()
+		// Drop the connection mid-stream.
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetStreamRetries(3)
+
+	sw := newTestSSEWriter()
+	if _, err := dispatch.ProcessStream(context.Background(), streamReq(), sw); err == nil {
+		t.Fatal("expected mid-stream failure")
+	}
+	if calls.Load() != 1 || len(sw.events) != 1 {
+		t.Errorf("expected no retry once a chunk was sent, got %d calls, %d events", calls.Load(), len(sw.events))
+	}
+}
+
+func TestDispatchStage_PickedProvider(t *testing.T) {
+	a, aCalls := flakyStreamServer(0, 0)
+	defer a.Close()
+	b, bCalls := flakyStreamServer(0, 0)
+	defer b.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("a", a.URL, "k", []string{"gpt-4o"}))
+	pb := provider.NewOpenAICompat("b", b.URL, "k", []string{"gpt-4o"})
+	registry.Register(pb)
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+
+	req := streamReq()
+	pickedProvider.Set(&req.Meta, provider.Provider(pb))
+	resp, err := dispatch.ProcessStream(context.Background(), req, newTestSSEWriter())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ProviderName != "b" || aCalls.Load() != 0 || bCalls.Load() != 1 {
+		t.Errorf("expected the picked provider used, got %s (%d calls to a)", resp.ProviderName, aCalls.Load())
+	}
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// ErrLatencyBudget is returned when the upstream hasn't responded by a
+// request's latency deadline (model.ProxyRequest.LatencyDeadline) and no
+// cached answer could stand in.
+var ErrLatencyBudget = errors.New("latency budget exceeded")
+
+// latencyBudget cancels a dispatch that hasn't heard from the upstream by
+// the request's latency deadline. A nil budget never fires.
+type latencyBudget struct {
+	timer *time.Timer
+}
+
+// withBudget returns ctx cancelled with ErrLatencyBudget at req's latency
+// deadline, unless the budget is stopped first. Without a deadline ctx is
+// returned as is. release must be called when the dispatch is done.
+func withBudget(ctx context.Context, req *model.ProxyRequest) (_ context.Context, b *latencyBudget, release func()) {
+	if req.LatencyDeadline.IsZero() {
+		return ctx, nil, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	b = &latencyBudget{timer: time.AfterFunc(time.Until(req.LatencyDeadline), func() {
+		cancel(ErrLatencyBudget)
+	})}
+	return ctx, b, func() {
+		b.stop()
+		cancel(nil)
+	}
+}
+
+// stop lifts the budget once the upstream has responded.
+func (b *latencyBudget) stop() {
+	if b != nil {
+		b.timer.Stop()
+	}
+}
+
+// budgetExpired reports whether the budget cancelled ctx. A call cut short
+// that way says nothing about the provider, so it isn't reported to the
+// router or counted as an upstream error.
+func budgetExpired(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrLatencyBudget)
+}
+
+// budgetErr marks err as ErrLatencyBudget if the budget cancelled ctx.
+func budgetErr(ctx context.Context, err error) error {
+	if budgetExpired(ctx) && !errors.Is(err, ErrLatencyBudget) {
+		return fmt.Errorf("%w: %w", ErrLatencyBudget, err)
+	}
+	return err
+}
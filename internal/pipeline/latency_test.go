@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/embedding"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/qdrant"
+)
+
+// slowUpstream answers after delay, streaming the first chunk right away.
+func slowUpstream(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"fresh\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if req.Stream {
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "fresh",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "fresh"}, FinishReason: "stop"}},
+		})
+	}))
+}
+
+// nearQdrantServer holds one entry scoring 0.85 and honours the search's
+// score_threshold.
+func nearQdrantServer(entry *model.ChatResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collections/test/points/search" {
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+			return
+		}
+		var body struct {
+			ScoreThreshold float32 `json:"score_threshold"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		results := []map[string]any{}
+		if body.ScoreThreshold <= 0.85 {
+			payload, _ := json.Marshal(&qdrant.CachedPayload{Response: entry, Model: "gpt-4o"})
+			results = append(results, map[string]any{"id": "near", "score": 0.85, "payload": json.RawMessage(payload)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": results})
+	}))
+}
+
+func budgetReq(stream bool, budget time.Duration) *model.ProxyRequest {
+	return &model.ProxyRequest{
+		ChatRequest:     model.ChatRequest{Model: "gpt-4o", Stream: stream, Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+		LatencyDeadline: time.Now().Add(budget),
+	}
+}
+
+func TestDispatchStage_LatencyBudget(t *testing.T) {
+	srv := slowUpstream(300 * time.Millisecond)
+	defer srv.Close()
+	dispatch := newTestDispatch(srv.URL)
+
+	start := time.Now()
+	_, err := dispatch.Process(context.Background(), budgetReq(false, 50*time.Millisecond))
+	if !errors.Is(err, ErrLatencyBudget) {
+		t.Fatalf("expected ErrLatencyBudget, got %v", err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("expected the budget to end the call early, took %v", d)
+	}
+	if s := dispatch.Stats(); s.Requests != 1 || s.Errors != 0 {
+		t.Errorf("expected the expired budget not counted as an upstream error, got %+v", s)
+	}
+
+	// A stream that has started within the budget runs to completion.
+	sw := newTestSSEWriter()
+	if _, err := dispatch.ProcessStream(context.Background(), budgetReq(true, 50*time.Millisecond), sw); err != nil {
+		t.Fatalf("expected the started stream to finish, got %v", err)
+	}
+	if !sw.done {
+		t.Error("expected the stream to complete")
+	}
+}
+
+func TestSemanticDispatch_LatencyBudgetServesNearEntry(t *testing.T) {
+	near := &model.ChatResponse{
+		ID:      "near",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "close enough"}, FinishReason: "stop"}},
+	}
+	upstream := slowUpstream(300 * time.Millisecond)
+	defer upstream.Close()
+	embServer := mockEmbeddingServer([]float32{0.1, 0.2, 0.3}, 0)
+	defer embServer.Close()
+	qdrantSrv := nearQdrantServer(near)
+	defer qdrantSrv.Close()
+
+	sc := cache.NewSemanticCache(embedding.NewClient(embServer.URL, "key", "m"), qdrant.NewClient(qdrantSrv.URL, "", "test"), 0.95)
+	stage := NewSemanticDispatchStage(sc, newTestDispatch(upstream.URL), slog.Default())
+
+	// Without a floor below the entry's score there is nothing to serve.
+	if _, err := stage.Process(context.Background(), budgetReq(false, 50*time.Millisecond)); !errors.Is(err, ErrLatencyBudget) {
+		t.Fatalf("expected ErrLatencyBudget, got %v", err)
+	}
+
+	sc.SetLatencyFloor(0.8)
+	resp, err := stage.Process(context.Background(), budgetReq(false, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CacheStatus != "HIT" || resp.ChatResponse.ID != "near" {
+		t.Errorf("expected the near entry, got %s from %s", resp.CacheStatus, resp.ProviderName)
+	}
+
+	// Without a budget the near entry is not a hit.
+	resp, err = stage.Process(context.Background(), &model.ProxyRequest{ChatRequest: budgetReq(false, 0).ChatRequest})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ChatResponse.ID != "fresh" {
+		t.Errorf("expected the upstream response, got %s", resp.ChatResponse.ID)
+	}
+	stage.Wait()
+}
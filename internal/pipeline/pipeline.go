@@ -3,6 +3,7 @@ package pipeline
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
@@ -21,9 +22,19 @@ type StreamStage interface {
 	ProcessStream(ctx context.Context, req *model.ProxyRequest, sw sse.Writer) (*model.ProxyResponse, error)
 }
 
+// Prefetcher is implemented by stages that can start slow work before the
+// stages ahead of them have run. Prefetch returns a context carrying whatever
+// the stage needs to pick that work up later; the context is cancelled when
+// the pipeline returns, so work nobody consumed is abandoned.
+type Prefetcher interface {
+	Prefetch(ctx context.Context, req *model.ProxyRequest) context.Context
+}
+
 // Pipeline holds an ordered list of stages.
 type Pipeline struct {
-	stages []any // each is Stage and/or StreamStage
+	stages      []any // each is Stage and/or StreamStage
+	prefetchers []Prefetcher
+	chaos       *Chaos
 }
 
 // New creates a pipeline from the given stages.
@@ -36,21 +47,55 @@ func New(stages ...any) (*Pipeline, error) {
 			return nil, fmt.Errorf("stage %d does not implement Stage or StreamStage", i)
 		}
 	}
-	return &Pipeline{stages: stages}, nil
+	p := &Pipeline{stages: stages}
+	for _, s := range stages {
+		if pf, ok := s.(Prefetcher); ok {
+			p.prefetchers = append(p.prefetchers, pf)
+		}
+	}
+	return p, nil
+}
+
+// SetChaos injects faults from c before every stage. Nil disables injection.
+// Must be called before the pipeline is used.
+func (p *Pipeline) SetChaos(c *Chaos) { p.chaos = c }
+
+// prefetch lets every Prefetcher start its work before any stage runs.
+func (p *Pipeline) prefetch(ctx context.Context, req *model.ProxyRequest) (context.Context, context.CancelFunc) {
+	if len(p.prefetchers) == 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	for _, pf := range p.prefetchers {
+		ctx = pf.Prefetch(ctx, req)
+	}
+	return ctx, cancel
 }
 
 // Execute runs the non-streaming pipeline.
 func (p *Pipeline) Execute(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
+	ctx, cancel := p.prefetch(ctx, req)
+	defer cancel()
+	trace := TraceFrom(ctx)
 	for _, s := range p.stages {
 		stage, ok := s.(Stage)
 		if !ok {
 			continue
 		}
-		resp, err := stage.Process(ctx, req)
+		start := time.Now()
+		err := p.chaos.inject(ctx, "stage "+stage.Name())
+		var resp *model.ProxyResponse
+		if err == nil {
+			resp, err = stage.Process(ctx, req)
+		}
+		trace.stage(stage.Name(), time.Since(start), resp != nil, err)
 		if err != nil {
 			return nil, fmt.Errorf("stage %s: %w", stage.Name(), err)
 		}
 		if resp != nil {
+			if resp.CacheReason == "" {
+				resp.CacheReason, _ = cacheReason.Get(req.Meta)
+			}
 			return resp, nil
 		}
 	}
@@ -59,16 +104,28 @@ func (p *Pipeline) Execute(ctx context.Context, req *model.ProxyRequest) (*model
 
 // ExecuteStream runs the streaming pipeline.
 func (p *Pipeline) ExecuteStream(ctx context.Context, req *model.ProxyRequest, sw sse.Writer) (*model.ProxyResponse, error) {
+	ctx, cancel := p.prefetch(ctx, req)
+	defer cancel()
+	trace := TraceFrom(ctx)
 	for _, s := range p.stages {
 		stage, ok := s.(StreamStage)
 		if !ok {
 			continue
 		}
-		resp, err := stage.ProcessStream(ctx, req, sw)
+		start := time.Now()
+		err := p.chaos.inject(ctx, "stage "+stage.Name())
+		var resp *model.ProxyResponse
+		if err == nil {
+			resp, err = stage.ProcessStream(ctx, req, sw)
+		}
+		trace.stage(stage.Name(), time.Since(start), resp != nil, err)
 		if err != nil {
 			return nil, fmt.Errorf("stage %s: %w", stage.Name(), err)
 		}
 		if resp != nil {
+			if resp.CacheReason == "" {
+				resp.CacheReason, _ = cacheReason.Get(req.Meta)
+			}
 			return resp, nil
 		}
 	}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/pricing"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+)
+
+// Routing policies for Router.
+const (
+	// RouteFirst always uses the registry's provider for the model.
+	RouteFirst = "first"
+	// RouteCheapest picks the healthy provider with the lowest blended
+	// price for the model.
+	RouteCheapest = "cheapest"
+)
+
+// Router chooses which provider serves a request when several serve the
+// same model. A provider whose call fails is considered unhealthy for the
+// cooldown and is skipped by the cheapest policy while others are healthy,
+// as is one whose upstream reported an exhausted rate limit.
+type Router struct {
+	registry *provider.Registry
+	policy   string
+	cooldown time.Duration
+	reserve  float64
+	now      func() time.Time
+
+	mu        sync.Mutex
+	downUntil map[string]time.Time
+}
+
+// NewRouter creates a router over registry using policy (RouteFirst or
+// RouteCheapest).
+func NewRouter(registry *provider.Registry, policy string, cooldown time.Duration) *Router {
+	return &Router{
+		registry:  registry,
+		policy:    policy,
+		cooldown:  cooldown,
+		now:       time.Now,
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+// SetQuotaReserve makes the cheapest policy rank providers with less than
+// reserve (0 to 1) of a reported rate limit left after those with more.
+// Must be called before the router is used.
+func (r *Router) SetQuotaReserve(reserve float64) { r.reserve = reserve }
+
+// Pick returns the provider for model. A non-empty force names the provider
+// to use regardless of policy; it must serve the model.
+func (r *Router) Pick(model, force string) (provider.Provider, error) {
+	if force != "" {
+		for _, p := range r.registry.Candidates(model) {
+			if p.Name() == force {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("provider %q does not serve model %q", force, model)
+	}
+	if r.policy != RouteCheapest {
+		return r.registry.Lookup(model)
+	}
+
+	candidates := r.registry.Candidates(model)
+	if len(candidates) == 0 {
+		return r.registry.Lookup(model)
+	}
+	if best := r.best(model, candidates, nil); best != nil {
+		return best, nil
+	}
+	// Everything is cooling down or out of quota; try the first candidate
+	// anyway.
+	return candidates[0], nil
+}
+
+// Retry returns the provider to retry a request for model on after the
+// providers in tried failed it: the first candidate not tried yet, or under
+// the cheapest policy the one Pick would choose among them. Candidates
+// cooling down or out of quota are skipped. It returns nil if none is left.
+func (r *Router) Retry(model string, tried map[string]bool) provider.Provider {
+	return r.best(model, r.registry.Candidates(model), tried)
+}
+
+// best returns the candidate to use for model, skipping those in skip and
+// those cooling down or out of quota, or nil if none is left. Under the
+// cheapest policy it is the cheapest with quota to spare; otherwise the
+// first.
+func (r *Router) best(model string, candidates []provider.Provider, skip map[string]bool) provider.Provider {
+	now := r.now()
+	var best provider.Provider
+	var bestPrice float64
+	var bestLow bool
+	for _, p := range candidates {
+		if skip[p.Name()] || !r.healthy(p.Name()) {
+			continue
+		}
+		low := false
+		if qp, ok := p.(interface{ Quota() provider.Quota }); ok {
+			q := qp.Quota()
+			if q.Exhausted(now) {
+				continue
+			}
+			low = q.Remaining(now) < r.reserve
+		}
+		if r.policy != RouteCheapest {
+			return p
+		}
+		price, ok := pricing.Blended(p.Name(), model)
+		if !ok {
+			// Unknown prices rank after every priced provider.
+			price = 1
+		}
+		if best == nil || (bestLow && !low) || (low == bestLow && price < bestPrice) {
+			best, bestPrice, bestLow = p, price, low
+		}
+	}
+	return best
+}
+
+// Report records the outcome of a call to the named provider. Cancellation
+// by the client and prompts too long for the model do not count against the
+// provider. Health only matters to the cheapest policy, so other policies
+// skip the bookkeeping.
+func (r *Router) Report(name string, err error) {
+	if r.policy != RouteCheapest || errors.Is(err, context.Canceled) || errors.Is(err, provider.ErrContextLength) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		delete(r.downUntil, name)
+		return
+	}
+	r.downUntil[name] = r.now().Add(r.cooldown)
+}
+
+func (r *Router) healthy(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.now().Before(r.downUntil[name])
+}
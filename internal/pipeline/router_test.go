@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pricing"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+)
+
+func TestRouter_Cheapest(t *testing.T) {
+	reg := provider.NewRegistry()
+	reg.Register(provider.NewOpenAICompat("router-cheap", "http://unused", "k", []string{"router-llama"}))
+	reg.Register(provider.NewOpenAICompat("router-pricey", "http://unused", "k", []string{"router-llama"}))
+	reg.Register(provider.NewOpenAICompat("router-unpriced", "http://unused", "k", []string{"router-llama"}))
+	reg.Freeze()
+	pricing.SetForProvider("router-cheap", "router-llama", 0.5, 0.5)
+	pricing.SetForProvider("router-pricey", "router-llama", 1, 1)
+
+	now := time.Unix(1_700_000_000, 0)
+	r := NewRouter(reg, RouteCheapest, 30*time.Second)
+	r.now = func() time.Time { return now }
+
+	pick := func(force string) string {
+		t.Helper()
+		p, err := r.Pick("router-llama", force)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return p.Name()
+	}
+
+	if got := pick(""); got != "router-cheap" {
+		t.Errorf("expected cheapest provider, got %s", got)
+	}
+	// A prompt too long for the model is the client's problem.
+	r.Report("router-cheap", &provider.UpstreamError{Status: 400, Kind: provider.ErrContextLength})
+	if got := pick(""); got != "router-cheap" {
+		t.Errorf("expected context length errors not to mark the provider down, got %s", got)
+	}
+	r.Report("router-cheap", errors.New("upstream error (status 500)"))
+	if got := pick(""); got != "router-pricey" {
+		t.Errorf("expected next cheapest while cheap is down, got %s", got)
+	}
+	if got := pick("router-cheap"); got != "router-cheap" {
+		t.Errorf("expected forced provider, got %s", got)
+	}
+	if _, err := r.Pick("router-llama", "missing"); err == nil {
+		t.Error("expected error forcing a provider that doesn't serve the model")
+	}
+	now = now.Add(31 * time.Second)
+	if got := pick(""); got != "router-cheap" {
+		t.Errorf("expected cheap provider after cooldown, got %s", got)
+	}
+}
+
+func TestRouter_FirstIgnoresHealth(t *testing.T) {
+	reg := provider.NewRegistry()
+	reg.Register(provider.NewOpenAICompat("a", "http://unused", "k", []string{"m"}))
+	reg.Register(provider.NewOpenAICompat("b", "http://unused", "k", []string{"m"}))
+	reg.Freeze()
+
+	r := NewRouter(reg, RouteFirst, time.Minute)
+	r.Report("a", errors.New("boom"))
+	if p, err := r.Pick("m", ""); err != nil || p.Name() != "a" {
+		t.Errorf("expected registry choice, got %v, %v", p, err)
+	}
+}
+
+func TestRouter_FirstRegistered(t *testing.T) {
+	reg := provider.NewRegistry()
+	reg.Register(provider.NewOpenAICompat("first-a", "http://unused", "k", []string{"m"}))
+	reg.Register(provider.NewOpenAICompat("first-b", "http://unused", "k", []string{"m"}))
+	reg.Add(provider.NewOpenAICompat("first-c", "http://unused", "k", nil), []string{"m"})
+
+	r := NewRouter(reg, RouteFirst, time.Minute)
+	check := func(stage string) {
+		t.Helper()
+		if p, err := r.Pick("m", ""); err != nil || p.Name() != "first-a" {
+			t.Errorf("%s: expected the first registered provider, got %v, %v", stage, p, err)
+		}
+		if c := reg.Candidates("m"); len(c) != 3 || c[0].Name() != "first-a" {
+			t.Errorf("%s: expected candidates in registration order, got %d", stage, len(c))
+		}
+	}
+	check("before Freeze")
+	reg.Freeze()
+	check("after Freeze")
+}
+
+func TestRouter_Quota(t *testing.T) {
+	remaining := map[string]string{"quota-cheap": "0", "quota-mid": "5", "quota-pricey": "80"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		name = strings.TrimSuffix(name, "/chat/completions")
+		w.Header().Set("x-ratelimit-limit-requests", "100")
+		w.Header().Set("x-ratelimit-remaining-requests", remaining[name])
+		w.Header().Set("x-ratelimit-reset-requests", "1m0s")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	reg := provider.NewRegistry()
+	for name, price := range map[string]float64{"quota-cheap": 0.1, "quota-mid": 0.5, "quota-pricey": 1} {
+		p := provider.NewOpenAICompat(name, srv.URL+"/"+name, "k", []string{"quota-llama"})
+		p.Chat(context.Background(), &model.ChatRequest{Model: "quota-llama"})
+		reg.Register(p)
+		pricing.SetForProvider(name, "quota-llama", price, price)
+	}
+	reg.Freeze()
+
+	r := NewRouter(reg, RouteCheapest, 30*time.Second)
+	if p, _ := r.Pick("quota-llama", ""); p.Name() != "quota-mid" {
+		t.Errorf("expected the exhausted provider skipped, got %s", p.Name())
+	}
+	r.SetQuotaReserve(0.1)
+	if p, _ := r.Pick("quota-llama", ""); p.Name() != "quota-pricey" {
+		t.Errorf("expected the provider below the reserve ranked last, got %s", p.Name())
+	}
+	r.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if p, _ := r.Pick("quota-llama", ""); p.Name() != "quota-cheap" {
+		t.Errorf("expected the cheapest provider after the reset, got %s", p.Name())
+	}
+}
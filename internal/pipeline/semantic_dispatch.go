@@ -2,11 +2,16 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/cache"
 	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/shutdown"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
 )
 
@@ -14,209 +19,572 @@ import (
 // If the semantic cache returns first with a hit, the provider call is cancelled.
 // If the provider returns first (or semantic misses), the provider result is used
 // and the response is stored in Qdrant asynchronously.
+//
+// Neither racer outlives the call that started it: Process and ProcessStream
+// cancel the loser and wait for it before returning. Only async stores, and
+// dispatches left to finish after a hit (RaceConfig.FinishOnHit), run on
+// afterwards; Wait and Drain flush them.
 type SemanticDispatchStage struct {
-	semantic *cache.SemanticCache
-	dispatch *DispatchStage
-	logger   *slog.Logger
+	semantic  *cache.SemanticCache
+	dispatch  *DispatchStage
+	logger    *slog.Logger
+	lookahead bool
+	race      RaceConfig
+	truncator *ReplayTruncator
+
+	stores *shutdown.Tracker
 }
 
+// storeTimeout bounds each async semantic store.
+const storeTimeout = 30 * time.Second
+
+// errNoResponse is returned if dispatch yields neither a response nor an
+// error, so callers never see a nil response with a nil error.
+var errNoResponse = errors.New("dispatch returned no response")
+
 // NewSemanticDispatchStage creates a stage that races semantic cache against dispatch.
 func NewSemanticDispatchStage(semantic *cache.SemanticCache, dispatch *DispatchStage, logger *slog.Logger) *SemanticDispatchStage {
 	return &SemanticDispatchStage{
 		semantic: semantic,
 		dispatch: dispatch,
 		logger:   logger,
+		stores:   shutdown.NewTracker(),
 	}
 }
 
 func (s *SemanticDispatchStage) Name() string { return "semantic_dispatch" }
 
-type raceResult struct {
-	resp *model.ProxyResponse
-	emb  []float32
-	text string
-	err  error
-	from string
+// SetLookahead starts the semantic lookup (embedding + Qdrant search) as soon
+// as the pipeline begins, concurrently with the exact-cache check, instead of
+// after it misses. Exact hits then abandon a lookup that may already have
+// been billed by the embedding provider.
+func (s *SemanticDispatchStage) SetLookahead(on bool) {
+	s.lookahead = on
 }
 
-// Process handles non-streaming requests with parallel race.
-func (s *SemanticDispatchStage) Process(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
-	if s.shouldSkip(req) {
-		return s.dispatch.Process(ctx, req)
-	}
+// RaceConfig tunes the race between the semantic lookup and dispatch. The
+// zero value races them until both are done and cancels dispatch on a hit.
+type RaceConfig struct {
+	// Grace bounds how long dispatch output is held back for the lookup.
+	// Once it has passed, a stream starts writing, and a finished
+	// non-streaming dispatch is returned without the lookup. Zero waits for
+	// the lookup however long it takes.
+	Grace time.Duration
+	// FinishOnHit lets a non-streaming dispatch run to completion in the
+	// background when the lookup hits, instead of cancelling it, and stores
+	// the response it completes. Cancelling an upstream call mid-response
+	// closes its connection. Streams are still cancelled on a hit. The
+	// finished call's cost and usage are not counted, since the request was
+	// served as a hit.
+	FinishOnHit bool
+	// Sequential names providers not worth racing, typically ones that
+	// answer about as fast as the lookup: for requests routed to them the
+	// lookup runs first and dispatch only starts on a miss.
+	Sequential []string
+}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// SetRace replaces the default race behaviour. Must be called before
+// serving.
+func (s *SemanticDispatchStage) SetRace(rc RaceConfig) {
+	s.race = rc
+}
 
-	ch := make(chan raceResult, 2)
+// SetReplayTruncator cuts hits down to the max_tokens of the request they
+// answer. nil, the default, replays them whole. Must be called before
+// serving.
+func (s *SemanticDispatchStage) SetReplayTruncator(t *ReplayTruncator) {
+	s.truncator = t
+}
 
-	// Semantic path
-	go func() {
-		resp, emb, text, err := s.semantic.Lookup(ctx, &req.ChatRequest)
-		if resp != nil {
-			ch <- raceResult{
-				resp: &model.ProxyResponse{
-					ChatResponse: resp,
-					OutputTokens: resp.Usage.CompletionTokens,
-					Cost:         0,
-					CacheStatus:  "HIT",
-					ProviderName: "semantic_cache",
-				},
-				emb:  emb,
-				text: text,
-				from: "semantic",
-			}
-		} else {
-			ch <- raceResult{emb: emb, text: text, err: err, from: "semantic"}
-		}
-	}()
+// fit returns cached as it may be served to req.
+func (s *SemanticDispatchStage) fit(ctx context.Context, req *model.ProxyRequest, cached *model.ChatResponse) *model.ChatResponse {
+	return s.truncator.apply(ctx, s.Name(), &req.ChatRequest, cached)
+}
 
-	// Dispatch path
+// Wait blocks until all pending async semantic stores have finished.
+func (s *SemanticDispatchStage) Wait() {
+	s.stores.Wait()
+}
+
+// Drain waits for pending async semantic stores until ctx ends, then
+// cancels the rest and reports how many were dropped.
+func (s *SemanticDispatchStage) Drain(ctx context.Context) (int, error) {
+	return s.stores.Drain(ctx)
+}
+
+// lookupResult is the outcome of a semantic lookup. err is a lookup failure;
+// the request still falls through to dispatch. near is an entry below the
+// threshold that may serve a request whose latency budget runs out. score is
+// the closest entry's, if found; stale and excluded say why it was ignored.
+// late is set on a lookup abandoned once the grace window had passed.
+type lookupResult struct {
+	resp     *model.ChatResponse
+	near     *model.ChatResponse
+	score    float32
+	found    bool
+	stale    bool
+	excluded bool
+	late     bool
+	emb      []float32
+	text     string
+	err      error
+}
+
+// pendingLookup is a semantic lookup started by Prefetch. res is written
+// before done is closed.
+type pendingLookup struct {
+	done chan struct{}
+	res  lookupResult
+}
+
+type pendingLookupKey struct{}
+
+// Prefetch implements Prefetcher when lookahead is enabled. The lookup is
+// bound to ctx, which the pipeline cancels when the request finishes.
+func (s *SemanticDispatchStage) Prefetch(ctx context.Context, req *model.ProxyRequest) context.Context {
+	if !s.lookahead || s.skipReason(req) != "" {
+		return ctx
+	}
+	p := &pendingLookup{done: make(chan struct{})}
+	r := *req
 	go func() {
-		resp, err := s.dispatch.Process(ctx, req)
-		ch <- raceResult{resp: resp, err: err, from: "dispatch"}
+		defer close(p.done)
+		p.res = s.search(ctx, &r)
 	}()
+	return context.WithValue(ctx, pendingLookupKey{}, p)
+}
 
-	var dispatchResult *raceResult
-	var semanticEmb []float32
-	var semanticText string
-
-	for i := 0; i < 2; i++ {
-		r := <-ch
-		switch r.from {
-		case "semantic":
-			if r.resp != nil {
-				// Semantic cache hit — cancel dispatch and return.
-				cancel()
-				return r.resp, nil
-			}
-			semanticEmb = r.emb
-			semanticText = r.text
-		case "dispatch":
-			rCopy := r
-			dispatchResult = &rCopy
+// lookup returns the prefetched lookup for this request if there is one,
+// otherwise it runs the lookup now.
+func (s *SemanticDispatchStage) lookup(ctx context.Context, req *model.ProxyRequest) lookupResult {
+	if p, ok := ctx.Value(pendingLookupKey{}).(*pendingLookup); ok {
+		select {
+		case <-p.done:
+			return p.res
+		case <-ctx.Done():
+			return lookupResult{err: ctx.Err()}
 		}
 	}
+	return s.search(ctx, req)
+}
+
+// search runs the lookup, looking for a near entry too if req has a
+// latency budget.
+func (s *SemanticDispatchStage) search(ctx context.Context, req *model.ProxyRequest) lookupResult {
+	m, emb, text, err := s.semantic.SearchMatch(ctx, &req.ChatRequest, !req.LatencyDeadline.IsZero())
+	return lookupResult{
+		resp: m.Hit, near: m.Near, score: m.Score, found: m.Found, stale: m.Stale,
+		excluded: m.Excluded, emb: emb, text: text, err: err,
+	}
+}
 
-	if dispatchResult == nil {
-		// Should not happen — dispatch always sends a result.
+type dispatchResult struct {
+	resp *model.ProxyResponse
+	err  error
+}
+
+// Process handles non-streaming requests with parallel race.
+func (s *SemanticDispatchStage) Process(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
+	if reason := s.skipReason(req); reason != "" {
+		TraceFrom(ctx).Decide(s.Name(), "bypass: %s", reason)
+		cacheReason.Set(&req.Meta, reason)
 		return s.dispatch.Process(ctx, req)
 	}
-
-	if dispatchResult.err != nil {
-		return nil, dispatchResult.err
+	if s.sequential(ctx, req) {
+		sem := s.lookup(ctx, req)
+		if sem.resp != nil {
+			TraceFrom(ctx).Decide(s.Name(), "hit score=%.2f", sem.score)
+			return semanticHit(s.fit(ctx, req, sem.resp), hitReason(sem)), nil
+		}
+		resp, err := s.dispatch.Process(ctx, req)
+		return s.dispatched(ctx, req, dispatchResult{resp: resp, err: err}, sem, nil)
 	}
 
-	// Async store: fire-and-forget with background context.
-	if dispatchResult.resp != nil && dispatchResult.resp.ChatResponse != nil {
-		resp := dispatchResult.resp.ChatResponse
-		chatReq := req.ChatRequest
-		emb := semanticEmb
-		text := semanticText
-		go func() {
-			if err := s.semantic.Store(context.Background(), &chatReq, resp, emb, text); err != nil {
-				s.logger.Warn("async semantic store failed", "error", err)
+	var wg sync.WaitGroup
+	defer wg.Wait() // runs after cancel
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	semanticCh := make(chan lookupResult, 1)
+	wg.Go(func() {
+		semanticCh <- s.lookup(ctx, req)
+	})
+	d := s.startDispatch(ctx, s.race.FinishOnHit, func(ctx context.Context) (*model.ProxyResponse, error) {
+		return s.dispatch.Process(ctx, req)
+	})
+	defer d.stop()
+	grace, stopGrace := s.graceTimer()
+	defer stopGrace()
+
+	var sem lookupResult
+	var disp dispatchResult
+	semDone, graceOver := false, false
+	for !d.received || !semDone && !graceOver {
+		select {
+		case sem = <-semanticCh:
+			semDone = true
+			if sem.resp != nil {
+				// Semantic cache hit — stop dispatch and return.
+				s.dispatchLost(d, req, sem)
+				cancel()
+				TraceFrom(ctx).Decide(s.Name(), "hit score=%.2f", sem.score)
+				return semanticHit(s.fit(ctx, req, sem.resp), hitReason(sem)), nil
 			}
-		}()
+		case disp = <-d.results:
+			d.received = true
+			if errors.Is(disp.err, ErrLatencyBudget) {
+				cancel()
+				if s.traceBudget(ctx, sem) {
+					return semanticHit(s.fit(ctx, req, sem.near), budgetReason(sem)), nil
+				}
+				return nil, disp.err
+			}
+		case <-grace:
+			graceOver = true
+		}
 	}
-
-	return dispatchResult.resp, nil
+	if !semDone {
+		sem.late = true
+	}
+	return s.dispatched(ctx, req, disp, sem, nil)
 }
 
 // ProcessStream handles streaming requests with parallel race.
 // The semantic lookup runs concurrently with provider dispatch. Both goroutines
 // race to produce a result. A gatedWriter ensures only one path writes SSE events.
 func (s *SemanticDispatchStage) ProcessStream(ctx context.Context, req *model.ProxyRequest, sw sse.Writer) (*model.ProxyResponse, error) {
-	if s.shouldSkip(req) {
+	if reason := s.skipReason(req); reason != "" {
+		TraceFrom(ctx).Decide(s.Name(), "bypass: %s", reason)
+		cacheReason.Set(&req.Meta, reason)
+		sw.SetHeader("X-Cache-Reason", reason)
 		return s.dispatch.ProcessStream(ctx, req, sw)
 	}
+	if s.sequential(ctx, req) {
+		sem := s.lookup(ctx, req)
+		if sem.resp != nil {
+			TraceFrom(ctx).Decide(s.Name(), "hit score=%.2f", sem.score)
+			return replayHit(sw, s.fit(ctx, req, sem.resp), hitReason(sem))
+		}
+		sw.SetHeader("X-Cache-Reason", missReason(sem))
+		resp, err := s.dispatch.ProcessStream(ctx, req, sw)
+		return s.dispatched(ctx, req, dispatchResult{resp: resp, err: err}, sem, sw)
+	}
 
+	var wg sync.WaitGroup
+	defer wg.Wait() // runs after cancel
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	type semanticResult struct {
-		resp *model.ChatResponse
-		emb  []float32
-		text string
+	// Create a gated writer: dispatch writes go through this, but if semantic
+	// wins first, we block dispatch from writing and replay the cached response.
+	gw := &gatedWriter{inner: sw, gate: make(chan struct{})}
+	// Whatever happens, never leave dispatch blocked on the gate.
+	defer gw.release()
+
+	semanticCh := make(chan lookupResult, 1)
+	wg.Go(func() {
+		semanticCh <- s.lookup(ctx, req)
+	})
+	// A stream is never left to finish: nothing assembles its events into
+	// a response that could be stored.
+	d := s.startDispatch(ctx, false, func(ctx context.Context) (*model.ProxyResponse, error) {
+		return s.dispatch.ProcessStream(ctx, req, gw)
+	})
+	defer d.stop()
+	grace, stopGrace := s.graceTimer()
+	defer stopGrace()
+
+	// Wait for both results. Either can arrive first.
+	var sem lookupResult
+	var disp dispatchResult
+	for semDone := false; !semDone || !d.received; {
+		select {
+		case sem = <-semanticCh:
+			semDone = true
+			if sem.resp != nil && gw.claim() {
+				// Semantic hit won the race — stop dispatch and replay via SSE.
+				s.dispatchLost(d, req, sem)
+				cancel()
+				TraceFrom(ctx).Decide(s.Name(), "hit score=%.2f", sem.score)
+				return replayHit(sw, s.fit(ctx, req, sem.resp), hitReason(sem))
+			}
+			// Semantic miss (or dispatch already started writing) — let dispatch continue.
+			gw.SetHeader("X-Cache-Reason", missReason(sem))
+			gw.release()
+		case disp = <-d.results:
+			d.received = true
+			if errors.Is(disp.err, ErrLatencyBudget) {
+				cancel()
+				// The budget only fires before dispatch writes, so the
+				// claim normally succeeds.
+				if s.traceBudget(ctx, sem) && gw.claim() {
+					return replayHit(sw, s.fit(ctx, req, sem.near), budgetReason(sem))
+				}
+				return nil, disp.err
+			}
+		case <-grace:
+			// The lookup is too slow: let dispatch write. A hit can still
+			// win if it arrives before dispatch's first event.
+			if !semDone {
+				TraceFrom(ctx).Decide(s.Name(), "grace window passed: releasing dispatch")
+				gw.SetHeader("X-Cache-Reason", missReason(lookupResult{late: true}))
+				gw.release()
+			}
+		}
 	}
 
-	semanticCh := make(chan semanticResult, 1)
+	return s.dispatched(ctx, req, disp, sem, nil)
+}
 
-	// Semantic path — runs in parallel with dispatch.
-	go func() {
-		resp, emb, text, _ := s.semantic.Lookup(ctx, &req.ChatRequest)
-		semanticCh <- semanticResult{resp: resp, emb: emb, text: text}
-	}()
+// dispatched finishes a request the lookup didn't serve. If dispatch ran
+// out of latency budget, a near entry is served instead, replayed to sw
+// when it is non-nil.
+func (s *SemanticDispatchStage) dispatched(ctx context.Context, req *model.ProxyRequest, disp dispatchResult, sem lookupResult, sw sse.Writer) (*model.ProxyResponse, error) {
+	if errors.Is(disp.err, ErrLatencyBudget) {
+		if !s.traceBudget(ctx, sem) {
+			return nil, disp.err
+		}
+		if sw != nil {
+			return replayHit(sw, s.fit(ctx, req, sem.near), budgetReason(sem))
+		}
+		return semanticHit(s.fit(ctx, req, sem.near), budgetReason(sem)), nil
+	}
+	s.traceMiss(ctx, sem)
+	if err := s.dispatchErr(disp, sem); err != nil {
+		return nil, err
+	}
+	disp.resp.CacheReason = missReason(sem)
+	s.storeAsync(&req.ChatRequest, disp.resp.ChatResponse, sem)
+	return disp.resp, nil
+}
 
-	// Create a gated writer: dispatch writes go through this, but if semantic
-	// wins first, we block dispatch from writing and replay the cached response.
-	gw := &gatedWriter{inner: sw, gate: make(chan struct{})}
+// sequential reports whether req goes to a provider the lookup isn't
+// raced against. The provider it checked is the one dispatch then uses.
+func (s *SemanticDispatchStage) sequential(ctx context.Context, req *model.ProxyRequest) bool {
+	if len(s.race.Sequential) == 0 {
+		return false
+	}
+	p, err := s.dispatch.router.Pick(req.ChatRequest.Model, req.Provider)
+	if err != nil {
+		return false
+	}
+	pickedProvider.Set(&req.Meta, p)
+	if !slices.Contains(s.race.Sequential, p.Name()) {
+		return false
+	}
+	TraceFrom(ctx).Decide(s.Name(), "sequential: lookup before dispatch to %s", p.Name())
+	return true
+}
 
-	type dispatchResult struct {
-		resp *model.ProxyResponse
-		err  error
+// graceTimer returns a channel that fires when the grace window passes,
+// nil if there is none, and a function releasing it.
+func (s *SemanticDispatchStage) graceTimer() (<-chan time.Time, func()) {
+	if s.race.Grace <= 0 {
+		return nil, func() {}
 	}
-	dispatchCh := make(chan dispatchResult, 1)
+	t := time.NewTimer(s.race.Grace)
+	return t.C, func() { t.Stop() }
+}
+
+// dispatchRace is the dispatch side of a race. A detachable one's context
+// follows the request's only until the lookup wins.
+type dispatchRace struct {
+	results  chan dispatchResult
+	cancel   context.CancelFunc
+	detach   func() bool // stops following the request; nil unless detachable
+	received bool        // the result was read from results
+	detached bool
+}
 
-	// Dispatch path — also runs in parallel.
+// startDispatch runs fn as the dispatch side of a race on ctx. If
+// detachable, dispatchLost can leave it to finish after a hit.
+func (s *SemanticDispatchStage) startDispatch(ctx context.Context, detachable bool, fn func(context.Context) (*model.ProxyResponse, error)) *dispatchRace {
+	d := &dispatchRace{results: make(chan dispatchResult, 1)}
+	var dctx context.Context
+	if detachable {
+		dctx, d.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		d.detach = context.AfterFunc(ctx, d.cancel)
+	} else {
+		dctx, d.cancel = context.WithCancel(ctx)
+	}
 	go func() {
-		resp, err := s.dispatch.ProcessStream(ctx, req, gw)
-		dispatchCh <- dispatchResult{resp: resp, err: err}
+		resp, err := fn(dctx)
+		d.results <- dispatchResult{resp: resp, err: err}
 	}()
+	return d
+}
 
-	// Wait for both results. Either can arrive first.
-	var semRes *semanticResult
-	var dispRes *dispatchResult
+// stop cancels dispatch and waits for it, unless it was left to finish.
+func (d *dispatchRace) stop() {
+	if d.detached {
+		return
+	}
+	d.cancel()
+	if !d.received {
+		<-d.results
+		d.received = true
+	}
+}
 
-	for semRes == nil || dispRes == nil {
+// dispatchLost cancels dispatch after the lookup sem won, or if it is
+// detachable leaves it to finish in the background, where Drain can cancel
+// it, and stores the response it completes for req.
+func (s *SemanticDispatchStage) dispatchLost(d *dispatchRace, req *model.ProxyRequest, sem lookupResult) {
+	if d.received || d.detach == nil || !d.detach() {
+		d.cancel()
+		return
+	}
+	d.detached = true
+	chatReq := req.ChatRequest
+	s.stores.Go(func(ctx context.Context) {
+		defer d.cancel()
+		var disp dispatchResult
 		select {
-		case sr := <-semanticCh:
-			semRes = &sr
-			if sr.resp != nil && gw.claim() {
-				// Semantic hit won the race — replay via SSE.
-				cancel() // Cancel dispatch.
-				sw.SetHeader("X-Cache", "HIT")
-				sw.SetHeader("X-Provider", "semantic_cache")
-				sseErr := sse.WriteResponseAsSSE(sw, sr.resp)
-				// Drain dispatch channel to avoid goroutine leak.
-				go func() { <-dispatchCh }()
-				return &model.ProxyResponse{
-					ChatResponse: sr.resp,
-					OutputTokens: sr.resp.Usage.CompletionTokens,
-					Cost:         0,
-					CacheStatus:  "HIT",
-					ProviderName: "semantic_cache",
-				}, sseErr
-			}
-			// Semantic miss (or dispatch already started writing) — let dispatch continue.
-			gw.release()
-		case dr := <-dispatchCh:
-			dispRes = &dr
+		case disp = <-d.results:
+		case <-ctx.Done():
+			d.cancel()
+			<-d.results
+			return
+		}
+		if disp.err == nil && disp.resp != nil {
+			s.storeAsync(&chatReq, disp.resp.ChatResponse, sem)
 		}
+	})
+}
+
+// replayHit serves cached as the stream's response.
+func replayHit(sw sse.Writer, cached *model.ChatResponse, reason string) (*model.ProxyResponse, error) {
+	resp := semanticHit(cached, reason)
+	sw.SetHeader("X-Cache", "HIT")
+	sw.SetHeader("X-Cache-Reason", resp.CacheReason)
+	sw.SetHeader("X-Provider", "semantic_cache")
+	setFingerprintHeader(sw, cached)
+	return resp, sse.WriteResponseAsSSE(sw, cached)
+}
+
+// traceMiss records why a raced lookup didn't serve the request.
+func (s *SemanticDispatchStage) traceMiss(ctx context.Context, sem lookupResult) {
+	trace := TraceFrom(ctx)
+	switch {
+	case sem.resp != nil:
+		trace.Decide(s.Name(), "hit ignored: dispatch had started streaming")
+	case sem.err != nil:
+		trace.Decide(s.Name(), "lookup failed: %v", sem.err)
+	case sem.late:
+		trace.Decide(s.Name(), "miss: lookup still running after the grace window")
+	case sem.stale:
+		trace.Decide(s.Name(), "miss: closest entry has a stale fingerprint")
+	case sem.excluded:
+		trace.Decide(s.Name(), "miss: closest entry was rated down by users")
+	case sem.found:
+		trace.Decide(s.Name(), "miss: closest entry scored %.2f, below threshold", sem.score)
+	default:
+		trace.Decide(s.Name(), "miss: no entry above threshold")
 	}
+}
 
-	// If we get here, dispatch completed. Async-store if we have an embedding.
-	if dispRes.err == nil && dispRes.resp != nil && dispRes.resp.ChatResponse != nil && semRes != nil {
-		chatReq := req.ChatRequest
-		resp := dispRes.resp.ChatResponse
-		emb := semRes.emb
-		text := semRes.text
-		go func() {
-			if err := s.semantic.Store(context.Background(), &chatReq, resp, emb, text); err != nil {
-				s.logger.Warn("async semantic store failed", "error", err)
-			}
-		}()
+// missReason is the X-Cache-Reason of a response dispatched because the
+// raced lookup didn't serve it.
+func missReason(sem lookupResult) string {
+	switch {
+	case sem.resp != nil:
+		return "semantic_too_late"
+	case sem.err != nil:
+		return "semantic_error"
+	case sem.late:
+		return "semantic_grace_expired"
+	case sem.stale:
+		return cache.MissStale
+	case sem.excluded:
+		return cache.MissFeedback
+	case sem.found:
+		return fmt.Sprintf("semantic_below_threshold score=%.2f", sem.score)
+	default:
+		return "semantic_no_entries"
 	}
+}
 
-	if dispRes.err != nil {
-		return nil, dispRes.err
+func hitReason(sem lookupResult) string {
+	return fmt.Sprintf("hit score=%.2f", sem.score)
+}
+
+// budgetReason is the X-Cache-Reason of a near entry served because the
+// latency budget ran out.
+func budgetReason(sem lookupResult) string {
+	return fmt.Sprintf("latency_budget score=%.2f", sem.score)
+}
+
+// traceBudget records how a request whose latency budget ran out is
+// answered, and reports whether sem has a near entry to serve. A lookup
+// still in flight is not waited for.
+func (s *SemanticDispatchStage) traceBudget(ctx context.Context, sem lookupResult) bool {
+	trace := TraceFrom(ctx)
+	if sem.near == nil {
+		trace.Decide(s.Name(), "latency budget exceeded: no cached entry to serve")
+		return false
 	}
-	return dispRes.resp, nil
+	trace.Decide(s.Name(), "latency budget exceeded: serving entry below threshold")
+	return true
 }
 
-// shouldSkip returns true if this request should bypass semantic cache.
-func (s *SemanticDispatchStage) shouldSkip(req *model.ProxyRequest) bool {
-	return req.ChatRequest.Temperature != nil && *req.ChatRequest.Temperature > 0
+func semanticHit(resp *model.ChatResponse, reason string) *model.ProxyResponse {
+	return &model.ProxyResponse{
+		ChatResponse: resp,
+		OutputTokens: resp.Usage.CompletionTokens,
+		Cost:         0,
+		CacheStatus:  "HIT",
+		CacheReason:  reason,
+		ProviderName: "semantic_cache",
+	}
+}
+
+// dispatchErr returns the error to report for a dispatch result. When both
+// the semantic lookup and dispatch failed, the errors are joined.
+func (s *SemanticDispatchStage) dispatchErr(disp dispatchResult, sem lookupResult) error {
+	err := disp.err
+	if err == nil && disp.resp == nil {
+		err = errNoResponse
+	}
+	if err != nil && sem.err != nil {
+		err = errors.Join(err, fmt.Errorf("semantic lookup: %w", sem.err))
+	}
+	return err
+}
+
+// storeAsync stores resp in the semantic cache in the background, reusing
+// the lookup's embedding when there is one.
+func (s *SemanticDispatchStage) storeAsync(req *model.ChatRequest, resp *model.ChatResponse, sem lookupResult) {
+	if resp == nil {
+		return
+	}
+	chatReq := *req
+	s.stores.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if err := s.semantic.Store(ctx, &chatReq, resp, sem.emb, sem.text); err != nil {
+			s.logger.Warn("async semantic store failed", "error", err)
+		}
+	})
+}
+
+// skipReason returns why this request should bypass the semantic cache, as
+// reported in X-Cache-Reason, or "" if it shouldn't.
+func (s *SemanticDispatchStage) skipReason(req *model.ProxyRequest) string {
+	if req.NoCache {
+		return "no_cache"
+	}
+	if reason := s.semantic.BypassReason(&req.ChatRequest); reason != "" {
+		return reason
+	}
+	if !s.semantic.SamplingPolicy().AllowsTemperature(req.ChatRequest.Temperature) {
+		return "temp_above_zero"
+	}
+	// Entries hold one choice, or as many as the request that stored them
+	// asked for; only the exact cache keys on n.
+	if n := req.ChatRequest.N; n != nil && *n > 1 {
+		return "multiple_choices"
+	}
+	return ""
 }
 
 // gatedWriter wraps an sse.Writer and blocks writes until released or claimed.
@@ -226,8 +594,8 @@ type gatedWriter struct {
 	inner   sse.Writer
 	mu      sync.Mutex
 	gate    chan struct{} // closed when gate opens
-	claimed bool         // true if semantic claimed (dispatch should discard writes)
-	writing bool         // true once dispatch has started writing
+	claimed bool          // true if semantic claimed (dispatch should discard writes)
+	writing bool          // true once dispatch has started writing
 }
 
 // waitForGate blocks until the gate is opened (release or claim).
@@ -275,8 +643,14 @@ func (g *gatedWriter) release() {
 	}
 }
 
+// SetHeader passes headers through until the semantic path claims the
+// response; after that, dispatch headers are dropped.
 func (g *gatedWriter) SetHeader(key, value string) {
-	g.inner.SetHeader(key, value)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.claimed {
+		g.inner.SetHeader(key, value)
+	}
 }
 
 func (g *gatedWriter) WriteEvent(data []byte) error {
@@ -3,9 +3,13 @@ package pipeline
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -128,6 +132,72 @@ func TestSemanticDispatch_SemanticHit(t *testing.T) {
 	if resp.ChatResponse.ID != "semantic-cached" {
 		t.Errorf("expected semantic-cached, got %s", resp.ChatResponse.ID)
 	}
+	if resp.CacheReason != "hit score=0.99" {
+		t.Errorf("expected the hit's score as reason, got %q", resp.CacheReason)
+	}
+}
+
+func TestSemanticDispatch_MissReasons(t *testing.T) {
+	cachedResp := &model.ChatResponse{
+		ID:      "semantic-cached",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Cached"}, FinishReason: "stop"}},
+	}
+	providerResp := &model.ChatResponse{
+		ID:      "provider-resp",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Provider"}, FinishReason: "stop"}},
+	}
+	// The provider is slow enough that the lookup always finishes first.
+	slowProvider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		json.NewEncoder(w).Encode(providerResp)
+	}))
+	defer slowProvider.Close()
+	embServer := mockEmbeddingServer([]float32{0.1, 0.2, 0.3}, 0)
+	defer embServer.Close()
+
+	for _, tc := range []struct {
+		name string
+		hit  *model.ChatResponse
+		temp float64
+		n    int
+		want string
+	}{
+		{"below threshold", cachedResp, 0, 0, "semantic_below_threshold score=0.99"},
+		{"empty cache", nil, 0, 0, "semantic_no_entries"},
+		{"bypass", cachedResp, 0.7, 0, "temp_above_zero"},
+		{"multiple choices", cachedResp, 0, 3, "multiple_choices"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			qdrantSrv := mockQdrantServer(tc.hit, "gpt-4o")
+			defer qdrantSrv.Close()
+			sc := cache.NewSemanticCache(embedding.NewClient(embServer.URL, "key", "text-embedding-3-small"),
+				qdrant.NewClient(qdrantSrv.URL, "", "test"), 0.995)
+			stage := NewSemanticDispatchStage(sc, newTestDispatch(slowProvider.URL+"/v1"), slog.Default())
+			p, err := New(stage)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := &model.ProxyRequest{
+				ChatRequest: model.ChatRequest{
+					Model:       "gpt-4o",
+					Messages:    []model.Message{{Role: "user", Content: "Hello"}},
+					Temperature: &tc.temp,
+				},
+			}
+			if tc.n > 0 {
+				req.ChatRequest.N = &tc.n
+			}
+			resp, err := p.Execute(context.Background(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.ChatResponse.ID != "provider-resp" || resp.CacheReason != tc.want {
+				t.Errorf("expected a dispatched response with reason %q, got %s with %q", tc.want, resp.ChatResponse.ID, resp.CacheReason)
+			}
+			stage.Wait()
+		})
+	}
 }
 
 func TestSemanticDispatch_SemanticMiss_DispatchWins(t *testing.T) {
@@ -278,3 +348,301 @@ func TestSemanticDispatch_StreamSemanticHit(t *testing.T) {
 		t.Error("expected SSE events to be written")
 	}
 }
+
+// waitStage stands in for the exact-cache check: it waits for the embedding
+// request before letting the pipeline move on.
+type waitStage struct {
+	embedded <-chan struct{}
+	saw      bool
+}
+
+func (s *waitStage) Name() string { return "wait" }
+
+func (s *waitStage) Process(ctx context.Context, req *model.ProxyRequest) (*model.ProxyResponse, error) {
+	select {
+	case <-s.embedded:
+		s.saw = true
+	case <-time.After(500 * time.Millisecond):
+	}
+	return nil, nil
+}
+
+func TestSemanticDispatch_LookaheadStartsBeforeEarlierStages(t *testing.T) {
+	cachedResp := &model.ChatResponse{
+		ID:      "semantic-cached",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Cached"}, FinishReason: "stop"}},
+	}
+	upstream := mockUpstreamServer(&model.ChatResponse{ID: "provider-resp"})
+	defer upstream.Close()
+
+	var embCalls atomic.Int32
+	embedded := make(chan struct{}, 1)
+	embServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		embCalls.Add(1)
+		select {
+		case embedded <- struct{}{}:
+		default:
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": []float32{0.1, 0.2, 0.3}}}})
+	}))
+	defer embServer.Close()
+
+	qdrantSrv := mockQdrantServer(cachedResp, "gpt-4o")
+	defer qdrantSrv.Close()
+
+	sc := cache.NewSemanticCache(
+		embedding.NewClient(embServer.URL, "key", "text-embedding-3-small"),
+		qdrant.NewClient(qdrantSrv.URL, "", "test"), 0.95)
+	stage := NewSemanticDispatchStage(sc, newTestDispatch(upstream.URL+"/v1"), slog.Default())
+	stage.SetLookahead(true)
+
+	wait := &waitStage{embedded: embedded}
+	pipe, err := New(wait, stage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pipe.Execute(context.Background(), &model.ProxyRequest{
+		ChatRequest: model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !wait.saw {
+		t.Error("expected embedding to start before the earlier stage finished")
+	}
+	if resp.ProviderName != "semantic_cache" {
+		t.Errorf("expected semantic_cache, got %s", resp.ProviderName)
+	}
+	if n := embCalls.Load(); n != 1 {
+		t.Errorf("expected the prefetched embedding to be reused, got %d calls", n)
+	}
+}
+
+// checkNoLeaks fails t if goroutines started after baseline are still running
+// once the test's servers are closed. It is a stdlib stand-in for goleak.
+func checkNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutine leak: %d running, baseline %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSemanticDispatch_BothFailReturnsCombinedError(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	sc := cache.NewSemanticCache(
+		embedding.NewClient(failing.URL, "key", "text-embedding-3-small"),
+		qdrant.NewClient(failing.URL, "", "test"), 0.95)
+	stage := NewSemanticDispatchStage(sc, newTestDispatch(failing.URL+"/v1"), slog.Default())
+
+	for _, stream := range []bool{false, true} {
+		req := &model.ProxyRequest{ChatRequest: model.ChatRequest{
+			Model:    "gpt-4o",
+			Messages: []model.Message{{Role: "user", Content: "Hello"}},
+			Stream:   stream,
+		}}
+		var resp *model.ProxyResponse
+		var err error
+		if stream {
+			resp, err = stage.ProcessStream(context.Background(), req, newTestSSEWriter())
+		} else {
+			resp, err = stage.Process(context.Background(), req)
+		}
+		if err == nil || resp != nil {
+			t.Fatalf("stream=%t: expected error and nil response, got %v, %v", stream, resp, err)
+		}
+		if !strings.Contains(err.Error(), "calling provider") && !strings.Contains(err.Error(), "streaming from provider") {
+			t.Errorf("stream=%t: expected dispatch error, got %v", stream, err)
+		}
+		if !strings.Contains(err.Error(), "semantic lookup") {
+			t.Errorf("stream=%t: expected semantic error to be joined, got %v", stream, err)
+		}
+	}
+
+	failing.Close()
+	checkNoLeaks(t, baseline)
+}
+
+func TestSemanticDispatch_HitWaitsForCancelledDispatch(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	cachedResp := &model.ChatResponse{
+		ID:      "semantic-cached",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Cached"}, FinishReason: "stop"}},
+	}
+	var inFlight atomic.Int32
+	slowProvider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		// Reading the body lets the server notice the client going away.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	embServer := mockEmbeddingServer([]float32{0.1, 0.2, 0.3}, 0)
+	qdrantSrv := mockQdrantServer(cachedResp, "gpt-4o")
+
+	sc := cache.NewSemanticCache(
+		embedding.NewClient(embServer.URL, "key", "text-embedding-3-small"),
+		qdrant.NewClient(qdrantSrv.URL, "", "test"), 0.95)
+	stage := NewSemanticDispatchStage(sc, newTestDispatch(slowProvider.URL+"/v1"), slog.Default())
+
+	for _, stream := range []bool{false, true} {
+		req := &model.ProxyRequest{ChatRequest: model.ChatRequest{
+			Model:    "gpt-4o",
+			Messages: []model.Message{{Role: "user", Content: "Hello"}},
+			Stream:   stream,
+		}}
+		start := time.Now()
+		var resp *model.ProxyResponse
+		var err error
+		if stream {
+			resp, err = stage.ProcessStream(context.Background(), req, newTestSSEWriter())
+		} else {
+			resp, err = stage.Process(context.Background(), req)
+		}
+		if err != nil || resp.ProviderName != "semantic_cache" {
+			t.Fatalf("stream=%t: expected semantic hit, got %v, %v", stream, resp, err)
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Errorf("stream=%t: dispatch was not cancelled promptly", stream)
+		}
+		// Give the server a moment to see the cancelled request.
+		for i := 0; inFlight.Load() > 0 && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := inFlight.Load(); n != 0 {
+			t.Errorf("stream=%t: %d upstream requests still in flight", stream, n)
+		}
+	}
+
+	stage.Wait()
+	slowProvider.Close()
+	embServer.Close()
+	qdrantSrv.Close()
+	checkNoLeaks(t, baseline)
+}
+
+func TestSemanticDispatch_Race(t *testing.T) {
+	cachedResp := &model.ChatResponse{
+		ID:      "semantic-cached",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Cached"}, FinishReason: "stop"}},
+	}
+	providerResp := &model.ChatResponse{
+		ID:      "provider-resp",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "Fresh"}, FinishReason: "stop"}},
+	}
+	var calls, completed atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		completed.Add(1)
+		json.NewEncoder(w).Encode(providerResp)
+	}))
+	defer upstream.Close()
+	mockQdrant := mockQdrantServer(cachedResp, "gpt-4o")
+	defer mockQdrant.Close()
+	var upserts atomic.Int32
+	qdrantSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			upserts.Add(1)
+		}
+		mockQdrant.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer qdrantSrv.Close()
+
+	newStage := func(embeddingDelay time.Duration, rc RaceConfig) *SemanticDispatchStage {
+		embServer := mockEmbeddingServer([]float32{0.1, 0.2, 0.3}, embeddingDelay)
+		t.Cleanup(embServer.Close)
+		sc := cache.NewSemanticCache(
+			embedding.NewClient(embServer.URL, "key", "text-embedding-3-small"),
+			qdrant.NewClient(qdrantSrv.URL, "", "test"), 0.95)
+		stage := NewSemanticDispatchStage(sc, newTestDispatch(upstream.URL+"/v1"), slog.Default())
+		stage.SetRace(rc)
+		return stage
+	}
+	newReq := func() *model.ProxyRequest {
+		return &model.ProxyRequest{ChatRequest: model.ChatRequest{
+			Model:    "gpt-4o",
+			Messages: []model.Message{{Role: "user", Content: "Hello"}},
+		}}
+	}
+
+	t.Run("grace", func(t *testing.T) {
+		stage := newStage(time.Second, RaceConfig{Grace: 50 * time.Millisecond})
+		start := time.Now()
+		resp, err := stage.Process(context.Background(), newReq())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.ChatResponse.ID != "provider-resp" || resp.CacheReason != "semantic_grace_expired" {
+			t.Errorf("expected dispatch after the grace window, got %s (%q)", resp.ChatResponse.ID, resp.CacheReason)
+		}
+		if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+			t.Errorf("waited %v for the lookup", elapsed)
+		}
+		stage.Wait()
+	})
+
+	t.Run("sequential", func(t *testing.T) {
+		calls.Store(0)
+		stage := newStage(0, RaceConfig{Sequential: []string{"test"}})
+		req := newReq()
+		resp, err := stage.Process(context.Background(), req)
+		if err != nil || resp.ProviderName != "semantic_cache" {
+			t.Fatalf("expected semantic hit, got %v, %v", resp, err)
+		}
+		if n := calls.Load(); n != 0 {
+			t.Errorf("expected no upstream call before the lookup missed, got %d", n)
+		}
+		if p, ok := pickedProvider.Get(req.Meta); !ok || p.Name() != "test" {
+			t.Errorf("expected the checked provider kept for dispatch, got %v", p)
+		}
+	})
+
+	t.Run("finish on hit", func(t *testing.T) {
+		completed.Store(0)
+		upserts.Store(0)
+		stage := newStage(0, RaceConfig{FinishOnHit: true})
+		for _, stream := range []bool{false, true} {
+			req := newReq()
+			req.ChatRequest.Stream = stream
+			var resp *model.ProxyResponse
+			var err error
+			if stream {
+				resp, err = stage.ProcessStream(context.Background(), req, newTestSSEWriter())
+			} else {
+				resp, err = stage.Process(context.Background(), req)
+			}
+			if err != nil || resp.ProviderName != "semantic_cache" {
+				t.Fatalf("stream=%t: expected semantic hit, got %v, %v", stream, resp, err)
+			}
+		}
+		stage.Wait()
+		if n := completed.Load(); n != 1 {
+			t.Errorf("expected only the non-streaming upstream call to finish, %d did", n)
+		}
+		if n := upserts.Load(); n != 1 {
+			t.Errorf("expected the finished non-streaming response stored, got %d stores", n)
+		}
+	})
+}
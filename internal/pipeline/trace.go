@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Trace collects diagnostics for one request as it moves through the
+// pipeline: how long each stage took, why cache stages decided what they
+// did, and every upstream call. It is attached to the request context with
+// WithTrace; stages look it up with TraceFrom. All methods are safe on a nil
+// Trace, so stages record unconditionally.
+type Trace struct {
+	mu        sync.Mutex
+	stages    []StageTiming
+	decisions []Decision
+	upstream  []UpstreamCall
+}
+
+// StageTiming is the time spent in one stage and how it ended: "pass"
+// (handed on to the next stage), "response" or "error", with Error set.
+type StageTiming struct {
+	Stage   string  `json:"stage"`
+	Millis  float64 `json:"ms"`
+	Outcome string  `json:"outcome"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Decision is a stage's explanation of a choice, e.g. why the cache missed.
+type Decision struct {
+	Stage  string `json:"stage"`
+	Detail string `json:"detail"`
+}
+
+// UpstreamCall is one provider call. Error is empty on success.
+type UpstreamCall struct {
+	Provider string  `json:"provider"`
+	Millis   float64 `json:"ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type traceKey struct{}
+
+// WithTrace returns ctx carrying t.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFrom returns the Trace carried by ctx, or nil.
+func TraceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Decide records a decision by stage, formatted as with fmt.Sprintf.
+func (t *Trace) Decide(stage, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	d := Decision{Stage: stage, Detail: fmt.Sprintf(format, args...)}
+	t.mu.Lock()
+	t.decisions = append(t.decisions, d)
+	t.mu.Unlock()
+}
+
+// Upstream records a provider call that took d and failed with err, if
+// non-nil.
+func (t *Trace) Upstream(provider string, d time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	c := UpstreamCall{Provider: provider, Millis: millis(d)}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	t.mu.Lock()
+	t.upstream = append(t.upstream, c)
+	t.mu.Unlock()
+}
+
+func (t *Trace) stage(name string, d time.Duration, hasResp bool, err error) {
+	if t == nil {
+		return
+	}
+	st := StageTiming{Stage: name, Millis: millis(d), Outcome: "pass"}
+	switch {
+	case err != nil:
+		st.Outcome, st.Error = "error", err.Error()
+	case hasResp:
+		st.Outcome = "response"
+	}
+	t.mu.Lock()
+	t.stages = append(t.stages, st)
+	t.mu.Unlock()
+}
+
+// Stages returns the stage timings recorded so far, in pipeline order.
+func (t *Trace) Stages() []StageTiming {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]StageTiming(nil), t.stages...)
+}
+
+// Decisions returns the decisions recorded so far, in the order made.
+func (t *Trace) Decisions() []Decision {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Decision(nil), t.decisions...)
+}
+
+// UpstreamCalls returns the provider calls recorded so far.
+func (t *Trace) UpstreamCalls() []UpstreamCall {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]UpstreamCall(nil), t.upstream...)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
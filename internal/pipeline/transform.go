@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// ChunkTransformer rewrites streamed chunks on their way to the client, one
+// chunk at a time, without buffering the response. TransformChunk edits chunk
+// in place and reports whether it changed anything; unchanged chunks are
+// forwarded byte for byte. Changed chunks are re-encoded from
+// model.ChatStreamChunk, so fields qlite does not model are dropped from them.
+//
+// Usage is extracted by the provider before chunks reach transformers, so
+// transformers cannot affect cost accounting.
+type ChunkTransformer interface {
+	TransformChunk(chunk *model.ChatStreamChunk) bool
+}
+
+// transformWriter applies transformers to each event before passing it on.
+type transformWriter struct {
+	inner        sse.Writer
+	transformers []ChunkTransformer
+}
+
+func (t *transformWriter) SetHeader(key, value string) {
+	t.inner.SetHeader(key, value)
+}
+
+func (t *transformWriter) WriteEvent(data []byte) error {
+	var chunk model.ChatStreamChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return t.inner.WriteEvent(data)
+	}
+	changed := false
+	for _, tr := range t.transformers {
+		if tr.TransformChunk(&chunk) {
+			changed = true
+		}
+	}
+	if !changed {
+		return t.inner.WriteEvent(data)
+	}
+	return sse.WriteJSON(t.inner, &chunk)
+}
+
+func (t *transformWriter) Done() error { return t.inner.Done() }
+
+// WordMask replaces whole-word, case-insensitive matches of a word list in
+// content deltas with asterisks. Since chunks are handled independently, a
+// word split across two chunks is not masked.
+type WordMask struct {
+	re *regexp.Regexp
+}
+
+// NewWordMask creates a WordMask for words. It returns nil if words is empty.
+func NewWordMask(words []string) *WordMask {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return &WordMask{re: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+// TransformChunk implements ChunkTransformer.
+func (m *WordMask) TransformChunk(chunk *model.ChatStreamChunk) bool {
+	changed := false
+	for i := range chunk.Choices {
+		d := &chunk.Choices[i].Delta
+		if d.Content == "" || !m.re.MatchString(d.Content) {
+			continue
+		}
+		d.Content = m.re.ReplaceAllStringFunc(d.Content, func(s string) string {
+			return strings.Repeat("*", len([]rune(s)))
+		})
+		changed = true
+	}
+	return changed
+}
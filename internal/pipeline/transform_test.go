@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestDispatchStage_ChunkTransformers(t *testing.T) {
+	chunks := []string{
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","system_fingerprint":"fp_1","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"well Darn it, darned"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":4,"total_tokens":14}}`,
+	}
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer mockSrv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", mockSrv.URL, "test-key", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetChunkTransformers(NewWordMask([]string{"darn"}))
+
+	sw := newTestSSEWriter()
+	resp, err := dispatch.ProcessStream(context.Background(), &model.ProxyRequest{
+		ChatRequest: model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+	}, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sw.events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(sw.events))
+	}
+	// Untouched chunks pass through byte for byte.
+	if sw.events[0] != chunks[0] || sw.events[2] != chunks[2] {
+		t.Errorf("expected unchanged chunks forwarded verbatim, got %q", sw.events)
+	}
+	want := `{"id":"c","object":"chat.completion.chunk","created":0,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"well **** it, darned"}}]}`
+	if sw.events[1] != want {
+		t.Errorf("expected masked chunk\n%s\ngot\n%s", want, sw.events[1])
+	}
+	if resp.OutputTokens != 4 {
+		t.Errorf("expected usage to survive transformation, got %d output tokens", resp.OutputTokens)
+	}
+}
+
+func TestNewWordMask_Empty(t *testing.T) {
+	if NewWordMask([]string{" ", ""}) != nil {
+		t.Error("expected nil mask for an empty word list")
+	}
+}
+
+func TestDispatchStage_ChunkTransformers_InterleavedChoices(t *testing.T) {
+	chunks := []string{
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant"}},{"index":1,"delta":{"role":"assistant"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":1,"delta":{"content":"darn one"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"zero"}},{"index":1,"delta":{"content":" darn"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":1,"delta":{},"finish_reason":"length"},{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":6,"total_tokens":16}}`,
+	}
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer mockSrv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", mockSrv.URL, "test-key", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	dispatch.SetChunkTransformers(NewWordMask([]string{"darn"}))
+
+	n := 2
+	sw := newTestSSEWriter()
+	resp, err := dispatch.ProcessStream(context.Background(), &model.ProxyRequest{
+		ChatRequest: model.ChatRequest{Model: "gpt-4o", N: &n, Messages: []model.Message{{Role: "user", Content: "Hello"}}},
+	}, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content := map[int]string{}
+	finish := map[int]string{}
+	for _, e := range sw.events {
+		var chunk model.ChatStreamChunk
+		if err := json.Unmarshal([]byte(e), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", e, err)
+		}
+		for _, c := range chunk.Choices {
+			content[c.Index] += c.Delta.Content
+			if c.FinishReason != "" {
+				finish[c.Index] = c.FinishReason
+			}
+		}
+	}
+	if content[0] != "zero" || content[1] != "**** one ****" {
+		t.Errorf("content by index = %q", content)
+	}
+	if finish[0] != "stop" || finish[1] != "length" {
+		t.Errorf("finish reasons = %v", finish)
+	}
+	if resp.OutputTokens != 6 {
+		t.Errorf("expected usage across all choices, got %d output tokens", resp.OutputTokens)
+	}
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+// ReplayTruncator cuts cached answers down to the max_tokens of the request
+// they are replayed to. Neither cache keys on the legacy max_tokens, and the
+// semantic cache keys on no limit at all, so a hit may be longer than the
+// client asked for.
+type ReplayTruncator struct {
+	counter *tokenizer.Counter
+}
+
+// NewReplayTruncator creates a truncator counting tokens with counter.
+func NewReplayTruncator(counter *tokenizer.Counter) *ReplayTruncator {
+	return &ReplayTruncator{counter: counter}
+}
+
+// apply returns cached cut to req's max output tokens, each choice's
+// content separately, with finish_reason "length" on the choices that were
+// cut and usage recounted. cached itself is returned if it fits, or if t is
+// nil.
+func (t *ReplayTruncator) apply(ctx context.Context, stage string, req *model.ChatRequest, cached *model.ChatResponse) *model.ChatResponse {
+	limit := req.MaxOutputTokens()
+	if t == nil || limit == nil || *limit <= 0 {
+		return cached
+	}
+	if n := cached.Usage.CompletionTokens; n > 0 && n <= *limit {
+		return cached
+	}
+
+	choices := make([]model.Choice, len(cached.Choices))
+	completion := 0
+	truncated := false
+	for i, c := range cached.Choices {
+		content, n := t.counter.TruncateText(req.Model, c.Message.Content, *limit)
+		if content != c.Message.Content {
+			c.Message.Content = content
+			c.FinishReason = "length"
+			truncated = true
+		}
+		choices[i] = c
+		completion += n
+	}
+	if !truncated {
+		return cached
+	}
+	TraceFrom(ctx).Decide(stage, "replay truncated to max_tokens=%d", *limit)
+	resp := *cached
+	resp.Choices = choices
+	resp.Usage.CompletionTokens = completion
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + completion
+	return &resp
+}
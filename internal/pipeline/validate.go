@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// ErrMalformedResponse is returned when an upstream response fails validation
+// and retrying it didn't help.
+var ErrMalformedResponse = errors.New("malformed upstream response")
+
+// validateResponse checks a non-streaming upstream response for structural
+// problems that would otherwise be cached and propagated, reporting them as
+// an error wrapping ErrMalformedResponse. With rejectEmpty, a choice that
+// finished with "stop" but has no content is one too.
+func validateResponse(resp *model.ChatResponse, rejectEmpty bool) error {
+	if resp == nil {
+		return fmt.Errorf("%w: empty body", ErrMalformedResponse)
+	}
+	if len(resp.Choices) == 0 {
+		return fmt.Errorf("%w: no choices", ErrMalformedResponse)
+	}
+	if !rejectEmpty {
+		return nil
+	}
+	for _, c := range resp.Choices {
+		if c.Message.Content == "" && c.FinishReason == "stop" {
+			return fmt.Errorf("%w: choice %d has empty content with finish_reason stop", ErrMalformedResponse, c.Index)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestValidateResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		resp        *model.ChatResponse
+		rejectEmpty bool
+		wantErr     bool
+	}{
+		{"nil", nil, false, true},
+		{"no choices", &model.ChatResponse{}, false, true},
+		{"empty stop", &model.ChatResponse{Choices: []model.Choice{{FinishReason: "stop"}}}, false, false},
+		{"empty stop rejected", &model.ChatResponse{Choices: []model.Choice{{FinishReason: "stop"}}}, true, true},
+		{"empty tool calls", &model.ChatResponse{Choices: []model.Choice{{FinishReason: "tool_calls"}}}, true, false},
+		{"empty length", &model.ChatResponse{Choices: []model.Choice{{FinishReason: "length"}}}, true, false},
+		{"ok", &model.ChatResponse{Choices: []model.Choice{{Message: model.Message{Content: "hi"}, FinishReason: "stop"}}}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResponse(tt.resp, tt.rejectEmpty)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrMalformedResponse) {
+				t.Errorf("expected ErrMalformedResponse, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDispatchStage_RetriesMalformedResponse(t *testing.T) {
+	var calls atomic.Int32
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			w.Write([]byte(`{"id":"broken","choices":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "fixed",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", mockSrv.URL, "key", []string{"gpt-4o"}))
+	dispatch := NewDispatchStage(registry, tokenizer.NewCounter())
+	req := &model.ProxyRequest{ChatRequest: model.ChatRequest{Model: "gpt-4o"}}
+
+	_, err := dispatch.Process(context.Background(), req)
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("expected ErrMalformedResponse without retries, got %v", err)
+	}
+
+	calls.Store(0)
+	dispatch.SetValidationRetries(1)
+	resp, err := dispatch.Process(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ChatResponse.ID != "fixed" {
+		t.Errorf("expected retried response, got %s", resp.ChatResponse.ID)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls.Load())
+	}
+}
@@ -1,50 +1,84 @@
 package pricing
 
-// priceEntry holds per-token prices in USD.
-type priceEntry struct {
-	InputPerToken  float64
-	OutputPerToken float64
+import (
+	"github.com/eduardmaghakyan/qlite/internal/catalog"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Prompt-cache multipliers relative to the input price (Anthropic pricing):
+// cache writes cost 25% more, cache reads cost 10% of the base input price.
+const (
+	cacheWriteMultiplier = 1.25
+	cacheReadMultiplier  = 0.10
+)
+
+// perToken returns a catalog price in USD per token.
+func perToken(p *catalog.Price) (input, output float64) {
+	return p.Input / 1_000_000, p.Output / 1_000_000
 }
 
-// prices maps model names to their per-token pricing.
-// Prices are in USD per token (not per 1M tokens).
-var prices = map[string]priceEntry{
-	"gpt-4o": {
-		InputPerToken:  2.50 / 1_000_000,
-		OutputPerToken: 10.00 / 1_000_000,
-	},
-	"gpt-4o-mini": {
-		InputPerToken:  0.15 / 1_000_000,
-		OutputPerToken: 0.60 / 1_000_000,
-	},
-	"gpt-4.1-nano": {
-		InputPerToken:  0.10 / 1_000_000,
-		OutputPerToken: 0.40 / 1_000_000,
-	},
-	"claude-sonnet-4-5": {
-		InputPerToken:  3.00 / 1_000_000,
-		OutputPerToken: 15.00 / 1_000_000,
-	},
-	"claude-haiku-4-5": {
-		InputPerToken:  0.80 / 1_000_000,
-		OutputPerToken: 4.00 / 1_000_000,
-	},
-	"gemini-2.5-flash": {
-		InputPerToken:  0.15 / 1_000_000,
-		OutputPerToken: 0.60 / 1_000_000,
-	},
-	"gemini-2.5-pro": {
-		InputPerToken:  1.25 / 1_000_000,
-		OutputPerToken: 10.00 / 1_000_000,
-	},
+// SetForProvider registers the price of model when served by provider, in
+// USD per 1M tokens, keeping the rest of the model's catalog entry. It is
+// meant for startup wiring and is not safe to call concurrently with
+// Calculate.
+func SetForProvider(provider, model string, inputPerMillion, outputPerMillion float64) {
+	m, _ := catalog.Lookup(provider, model)
+	m.Price = &catalog.Price{Input: inputPerMillion, Output: outputPerMillion}
+	catalog.Register(provider, m)
+}
+
+// lookup returns provider's per-token price for model, falling back to the
+// model's default price.
+func lookup(provider, model string) (input, output float64, ok bool) {
+	m, ok := catalog.Lookup(provider, model)
+	if !ok || m.Price == nil {
+		return 0, 0, false
+	}
+	input, output = perToken(m.Price)
+	return input, output, true
+}
+
+// Blended returns a single per-token price for comparing providers: input
+// and output weighted 3:1, a typical chat token mix. ok is false when the
+// model has no known price.
+func Blended(provider, model string) (price float64, ok bool) {
+	input, output, ok := lookup(provider, model)
+	if !ok {
+		return 0, false
+	}
+	return (3*input + output) / 4, true
 }
 
 // Calculate returns the cost in USD for the given model and token counts.
 // Returns 0 for unknown models.
 func Calculate(model string, inputTokens, outputTokens int) float64 {
-	p, ok := prices[model]
+	input, output, ok := lookup("", model)
 	if !ok {
 		return 0
 	}
-	return float64(inputTokens)*p.InputPerToken + float64(outputTokens)*p.OutputPerToken
+	return float64(inputTokens)*input + float64(outputTokens)*output
+}
+
+// CalculateUsage returns the cost in USD for a full Usage record, billing
+// provider prompt-cache writes and reads at their discounted/premium rates.
+// Returns 0 for unknown models.
+func CalculateUsage(modelName string, u model.Usage) float64 {
+	return CalculateUsageFor("", modelName, u)
+}
+
+// CalculateUsageFor is CalculateUsage using provider's price for the model
+// when one was set with SetForProvider.
+func CalculateUsageFor(provider, modelName string, u model.Usage) float64 {
+	input, output, ok := lookup(provider, modelName)
+	if !ok {
+		return 0
+	}
+	uncached := u.PromptTokens - u.CacheCreationInputTokens - u.CacheReadInputTokens
+	if uncached < 0 {
+		uncached = 0
+	}
+	return float64(uncached)*input +
+		float64(u.CacheCreationInputTokens)*input*cacheWriteMultiplier +
+		float64(u.CacheReadInputTokens)*input*cacheReadMultiplier +
+		float64(u.CompletionTokens)*output
 }
@@ -3,6 +3,8 @@ package pricing
 import (
 	"math"
 	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
 )
 
 func TestCalculate_GPT4o(t *testing.T) {
@@ -95,3 +97,27 @@ func TestCalculate_ZeroTokens(t *testing.T) {
 		t.Errorf("expected 0 for zero tokens, got %f", cost)
 	}
 }
+
+func TestCalculateUsage_PromptCache(t *testing.T) {
+	cost := CalculateUsage("claude-sonnet-4-5", model.Usage{
+		PromptTokens:             1510,
+		CompletionTokens:         100,
+		CacheCreationInputTokens: 1000,
+		CacheReadInputTokens:     500,
+	})
+	// Uncached input: 10 * 3.00/1M = 0.00003
+	// Cache write: 1000 * 3.00/1M * 1.25 = 0.00375
+	// Cache read: 500 * 3.00/1M * 0.10 = 0.00015
+	// Output: 100 * 15.00/1M = 0.0015
+	expected := 0.00543
+	if math.Abs(cost-expected) > 1e-10 {
+		t.Errorf("expected cost %.10f, got %.10f", expected, cost)
+	}
+}
+
+func TestCalculateUsage_MatchesCalculateWithoutCache(t *testing.T) {
+	got := CalculateUsage("gpt-4o", model.Usage{PromptTokens: 1000, CompletionTokens: 500})
+	if want := Calculate("gpt-4o", 1000, 500); math.Abs(got-want) > 1e-12 {
+		t.Errorf("expected %.10f, got %.10f", want, got)
+	}
+}
@@ -1,7 +1,6 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -17,10 +16,11 @@ import (
 
 // Anthropic SSE event type byte slices for zero-alloc comparison.
 var (
-	eventMessageStart     = []byte("message_start")
+	eventMessageStart      = []byte("message_start")
+	eventContentBlockStart = []byte("content_block_start")
 	eventContentBlockDelta = []byte("content_block_delta")
-	eventMessageDelta     = []byte("message_delta")
-	eventMessageStop      = []byte("message_stop")
+	eventMessageDelta      = []byte("message_delta")
+	eventMessageStop       = []byte("message_stop")
 )
 
 // Anthropic is a provider that speaks the Anthropic Messages API.
@@ -30,45 +30,85 @@ type Anthropic struct {
 	apiKey  string
 	models  []string
 	client  *http.Client
+
+	extras  RequestExtras
+	auth    AuthProvider
+	noStore NoStoreHints
+	quota   QuotaTracker
+	version string // anthropic-version header
+	betas   string // anthropic-beta header, comma-separated
 }
 
+// DefaultAnthropicVersion is the anthropic-version sent unless configured.
+const DefaultAnthropicVersion = "2023-06-01"
+
 // NewAnthropic creates a new Anthropic provider.
 func NewAnthropic(name, baseURL, apiKey string, models []string) *Anthropic {
-	transport := &http.Transport{
-		DisableCompression:  true,
-		MaxIdleConns:        1000,
-		MaxIdleConnsPerHost: 1000,
-		IdleConnTimeout:     90 * time.Second,
-		WriteBufferSize:     32 << 10,
-		ReadBufferSize:      32 << 10,
-		ForceAttemptHTTP2:   true,
-	}
 	return &Anthropic{
 		name:    name,
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		models:  models,
-		client:  &http.Client{Transport: transport},
+		client:  &http.Client{Transport: NewTransport(TransportConfig{})},
+		version: DefaultAnthropicVersion,
 	}
 }
 
+// SetAPIVersion sets the anthropic-version header sent upstream.
+func (a *Anthropic) SetAPIVersion(v string) { a.version = v }
+
+// SetBetas opts in to beta features, sent as the anthropic-beta header.
+func (a *Anthropic) SetBetas(betas []string) { a.betas = strings.Join(betas, ",") }
+
+// SetTransport replaces the transport used for upstream calls.
+func (a *Anthropic) SetTransport(rt http.RoundTripper) { a.client.Transport = rt }
+
+// SetRequestExtras configures static headers and query parameters sent with
+// every upstream request.
+func (a *Anthropic) SetRequestExtras(e RequestExtras) { a.extras = e }
+
+// SetAuth authenticates every upstream request with auth, after the
+// provider's own credentials and the extras are set.
+func (a *Anthropic) SetAuth(auth AuthProvider) { a.auth = auth }
+
+// SetNoStoreHints marks non-streaming responses carrying any of h as not
+// cacheable.
+func (a *Anthropic) SetNoStoreHints(h NoStoreHints) { a.noStore = h }
+
+// Quota returns the rate limits last reported by the upstream.
+func (a *Anthropic) Quota() Quota { return a.quota.Quota() }
+
 func (a *Anthropic) Name() string    { return a.name }
 func (a *Anthropic) Models() []string { return a.models }
 
 // anthropicRequest is the Anthropic Messages API request format.
 type anthropicRequest struct {
-	Model       string            `json:"model"`
-	Messages    []anthropicMsg    `json:"messages"`
-	System      string            `json:"system,omitempty"`
-	MaxTokens   int               `json:"max_tokens"`
-	Temperature *float64          `json:"temperature,omitempty"`
-	TopP        *float64          `json:"top_p,omitempty"`
-	Stream      bool              `json:"stream,omitempty"`
+	Model       string             `json:"model"`
+	Messages    []anthropicMsg     `json:"messages"`
+	System      any                `json:"system,omitempty"` // string, or []anthropicTextBlock with cache_control
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+	Metadata    *anthropicMetadata `json:"metadata,omitempty"`
+}
+
+// anthropicMetadata carries the end-user ID for Anthropic's abuse monitoring.
+type anthropicMetadata struct {
+	UserID string `json:"user_id"`
 }
 
 type anthropicMsg struct {
 	Role    string `json:"role"`
-	Content string `json:"content"`
+	Content any    `json:"content"` // string, or []anthropicTextBlock with cache_control
+}
+
+// anthropicTextBlock is a content block; used instead of a plain string when
+// the message carries a prompt-caching breakpoint.
+type anthropicTextBlock struct {
+	Type         string              `json:"type"`
+	Text         string              `json:"text"`
+	CacheControl *model.CacheControl `json:"cache_control,omitempty"`
 }
 
 // anthropicResponse is the Anthropic Messages API response format.
@@ -88,8 +128,16 @@ type anthropicContent struct {
 }
 
 type anthropicUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// promptTokens returns the OpenAI-style prompt total. Anthropic's input_tokens
+// excludes tokens written to or read from the prompt cache.
+func (u anthropicUsage) promptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
 }
 
 // Anthropic SSE event types.
@@ -98,6 +146,18 @@ type anthropicMessageStart struct {
 	Message anthropicResponse `json:"message"`
 }
 
+// anthropicContentBlockStart opens a content block; tool_use blocks carry
+// the call's ID and name, and their input follows as input_json_delta.
+type anthropicContentBlockStart struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+}
+
 type anthropicContentBlockDelta struct {
 	Type  string                `json:"type"`
 	Index int                   `json:"index"`
@@ -105,8 +165,9 @@ type anthropicContentBlockDelta struct {
 }
 
 type anthropicDeltaContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	PartialJSON string `json:"partial_json"`
 }
 
 type anthropicMessageDelta struct {
@@ -130,27 +191,40 @@ func (a *Anthropic) convertRequest(req *model.ChatRequest) *anthropicRequest {
 		TopP:        req.TopP,
 	}
 
-	if req.MaxTokens != nil {
-		ar.MaxTokens = *req.MaxTokens
+	if n := req.MaxOutputTokens(); n != nil {
+		ar.MaxTokens = *n
 	} else {
 		ar.MaxTokens = 4096
 	}
 
+	if req.User != "" {
+		ar.Metadata = &anthropicMetadata{UserID: req.User}
+	}
+
 	ar.Messages = make([]anthropicMsg, 0, len(req.Messages))
 	for _, msg := range req.Messages {
 		if msg.Role == "system" {
-			ar.System = msg.Content
+			ar.System = anthropicContentFor(msg)
 			continue
 		}
 		ar.Messages = append(ar.Messages, anthropicMsg{
 			Role:    msg.Role,
-			Content: msg.Content,
+			Content: anthropicContentFor(msg),
 		})
 	}
 
 	return ar
 }
 
+// anthropicContentFor returns the message content as a plain string, or as a
+// single text block when the message is a prompt-caching breakpoint.
+func anthropicContentFor(msg model.Message) any {
+	if msg.CacheControl == nil {
+		return msg.Content
+	}
+	return []anthropicTextBlock{{Type: "text", Text: msg.Content, CacheControl: msg.CacheControl}}
+}
+
 func anthropicStopReason(reason string) string {
 	switch reason {
 	case "end_turn":
@@ -159,6 +233,8 @@ func anthropicStopReason(reason string) string {
 		return "length"
 	case "stop_sequence":
 		return "stop"
+	case "tool_use":
+		return "tool_calls"
 	default:
 		return reason
 	}
@@ -180,22 +256,25 @@ func (a *Anthropic) Chat(ctx context.Context, req *model.ChatRequest) (*model.Ch
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	a.setHeaders(httpReq)
+	if err := a.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := a.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
+	a.quota.observe(resp.Header)
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newUpstreamError(resp)
 	}
 
 	var ar2 anthropicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ar2); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
+	noStore, err := a.noStore.decode(resp, &ar2)
+	if err != nil {
+		return nil, err
 	}
 
 	// Concatenate content blocks.
@@ -206,7 +285,8 @@ func (a *Anthropic) Chat(ctx context.Context, req *model.ChatRequest) (*model.Ch
 		}
 	}
 
-	totalTokens := ar2.Usage.InputTokens + ar2.Usage.OutputTokens
+	promptTokens := ar2.Usage.promptTokens()
+	totalTokens := promptTokens + ar2.Usage.OutputTokens
 	return &model.ChatResponse{
 		ID:      ar2.ID,
 		Object:  "chat.completion",
@@ -223,10 +303,13 @@ func (a *Anthropic) Chat(ctx context.Context, req *model.ChatRequest) (*model.Ch
 			},
 		},
 		Usage: model.Usage{
-			PromptTokens:     ar2.Usage.InputTokens,
-			CompletionTokens: ar2.Usage.OutputTokens,
-			TotalTokens:      totalTokens,
+			PromptTokens:             promptTokens,
+			CompletionTokens:         ar2.Usage.OutputTokens,
+			TotalTokens:              totalTokens,
+			CacheCreationInputTokens: ar2.Usage.CacheCreationInputTokens,
+			CacheReadInputTokens:     ar2.Usage.CacheReadInputTokens,
 		},
+		NoStore: noStore,
 	}, nil
 }
 
@@ -246,122 +329,152 @@ func (a *Anthropic) ChatStream(ctx context.Context, req *model.ChatRequest, sw s
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	a.setHeaders(httpReq)
+	if err := a.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := a.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
+	a.quota.observe(resp.Header)
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newUpstreamError(resp)
 	}
 
-	var usage model.Usage
-	var msgID string
-	var modelName string
-	var curEvent []byte
-	created := time.Now().Unix()
-
-	eventPrefix := []byte("event: ")
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	return a.relayStream(resp.Body, sw)
+}
 
-		// Parse event type lines.
-		if bytes.HasPrefix(line, eventPrefix) {
-			curEvent = line[7:]
-			continue
-		}
+// relayStream converts Anthropic stream events from body into OpenAI chunks.
+// The decode targets and the outgoing chunk are reused across events, and
+// plain text deltas skip reflection-based decoding entirely.
+func (a *Anthropic) relayStream(body io.Reader, sw sse.Writer) (*model.Usage, error) {
+	var usage model.Usage
+	var (
+		ms    anthropicMessageStart
+		cbs   anthropicContentBlockStart
+		cbd   anthropicContentBlockDelta
+		md    anthropicMessageDelta
+		tools toolCallEncoder
+	)
+	var choice [1]model.StreamChoice
+	chunk := &model.ChatStreamChunk{
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Choices: choice[:],
+	}
 
-		// Parse data lines.
-		if !bytes.HasPrefix(line, dataPrefix) {
-			continue
+	events := newSSEReader(body)
+	for {
+		ev, ok := events.Next()
+		if !ok {
+			break
 		}
-		data := line[len(dataPrefix):]
+		curEvent, data := ev.Event, ev.Data
 
-		if bytes.Equal(curEvent, eventMessageStart) {
-			var ms anthropicMessageStart
+		choice[0] = model.StreamChoice{}
+		switch {
+		case bytes.Equal(curEvent, eventMessageStart):
+			ms = anthropicMessageStart{}
 			if err := json.Unmarshal(data, &ms); err != nil {
 				continue
 			}
-			msgID = ms.Message.ID
-			modelName = ms.Message.Model
-			usage.PromptTokens = ms.Message.Usage.InputTokens
-
-			chunk := model.ChatStreamChunk{
-				ID:      msgID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   modelName,
-				Choices: []model.StreamChoice{
-					{Index: 0, Delta: model.Delta{Role: "assistant"}},
-				},
-			}
-			if err := sse.WriteJSON(sw, chunk); err != nil {
-				return &usage, fmt.Errorf("writing event: %w", err)
-			}
-		} else if bytes.Equal(curEvent, eventContentBlockDelta) {
-			var cbd anthropicContentBlockDelta
-			if err := json.Unmarshal(data, &cbd); err != nil {
+			chunk.ID = ms.Message.ID
+			chunk.Model = ms.Message.Model
+			usage.PromptTokens = ms.Message.Usage.promptTokens()
+			usage.CacheCreationInputTokens = ms.Message.Usage.CacheCreationInputTokens
+			usage.CacheReadInputTokens = ms.Message.Usage.CacheReadInputTokens
+			choice[0].Delta.Role = "assistant"
+		case bytes.Equal(curEvent, eventContentBlockStart):
+			cbs = anthropicContentBlockStart{}
+			if err := json.Unmarshal(data, &cbs); err != nil || cbs.ContentBlock.Type != "tool_use" {
 				continue
 			}
-
-			chunk := model.ChatStreamChunk{
-				ID:      msgID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   modelName,
-				Choices: []model.StreamChoice{
-					{Index: 0, Delta: model.Delta{Content: cbd.Delta.Text}},
-				},
-			}
-			if err := sse.WriteJSON(sw, chunk); err != nil {
-				return &usage, fmt.Errorf("writing event: %w", err)
+			choice[0].Delta.ToolCalls = tools.start(cbs.Index, cbs.ContentBlock.ID, cbs.ContentBlock.Name, "")
+		case bytes.Equal(curEvent, eventContentBlockDelta):
+			text, ok := anthropicDeltaText(data)
+			if !ok {
+				cbd = anthropicContentBlockDelta{}
+				if err := json.Unmarshal(data, &cbd); err != nil {
+					continue
+				}
+				if cbd.Delta.Type == "input_json_delta" {
+					// A tool's input streams as JSON text, forwarded in
+					// fragments as it comes.
+					calls := tools.arguments(cbd.Index, cbd.Delta.PartialJSON)
+					if calls == nil || cbd.Delta.PartialJSON == "" {
+						continue
+					}
+					choice[0].Delta.ToolCalls = calls
+					break
+				}
+				text = cbd.Delta.Text
 			}
-		} else if bytes.Equal(curEvent, eventMessageDelta) {
-			var md anthropicMessageDelta
+			choice[0].Delta.Content = text
+		case bytes.Equal(curEvent, eventMessageDelta):
+			md = anthropicMessageDelta{}
 			if err := json.Unmarshal(data, &md); err != nil {
 				continue
 			}
 			usage.CompletionTokens = md.Usage.OutputTokens
 			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-
-			chunk := model.ChatStreamChunk{
-				ID:      msgID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   modelName,
-				Choices: []model.StreamChoice{
-					{
-						Index:        0,
-						Delta:        model.Delta{},
-						FinishReason: anthropicStopReason(md.Delta.StopReason),
-					},
-				},
-			}
-			if err := sse.WriteJSON(sw, chunk); err != nil {
-				return &usage, fmt.Errorf("writing event: %w", err)
-			}
-		} else if bytes.Equal(curEvent, eventMessageStop) {
+			choice[0].FinishReason = anthropicStopReason(md.Delta.StopReason)
+		case bytes.Equal(curEvent, eventMessageStop):
 			if err := sw.Done(); err != nil {
 				return &usage, fmt.Errorf("writing done: %w", err)
 			}
+			continue
+		default:
+			continue
+		}
+		if err := sse.WriteJSON(sw, chunk); err != nil {
+			return &usage, fmt.Errorf("writing event: %w", err)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := events.Err(); err != nil {
 		return &usage, fmt.Errorf("reading stream: %w", err)
 	}
 
 	return &usage, nil
 }
 
-func (a *Anthropic) setHeaders(req *http.Request) {
+var (
+	deltaKey      = []byte(`"delta":`)
+	textDeltaType = []byte(`"type":"text_delta"`)
+	textKey       = []byte(`"text":"`)
+)
+
+// anthropicDeltaText extracts the text of a compact text_delta
+// content_block_delta without a full JSON decode. Unescaped quotes only occur
+// as JSON structure, so the first "text":" after "delta": is the key. It
+// reports false for anything else (other delta types, escapes, whitespace
+// between tokens) so the caller falls back to json.Unmarshal.
+func anthropicDeltaText(data []byte) (string, bool) {
+	_, delta, ok := bytes.Cut(data, deltaKey)
+	if !ok || !bytes.Contains(delta, textDeltaType) {
+		return "", false
+	}
+	_, v, ok := bytes.Cut(delta, textKey)
+	if !ok {
+		return "", false
+	}
+	end := bytes.IndexByte(v, '"')
+	if end < 0 || bytes.IndexByte(v[:end], '\\') >= 0 {
+		return "", false
+	}
+	return string(v[:end]), true
+}
+
+func (a *Anthropic) setHeaders(ctx context.Context, req *http.Request) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", a.apiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	req.Header.Set("anthropic-version", a.version)
+	if a.betas != "" {
+		req.Header.Set("anthropic-beta", a.betas)
+	}
+	a.extras.apply(req)
+	return authenticate(ctx, a.auth, req)
 }
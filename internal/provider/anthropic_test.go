@@ -1,9 +1,11 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -143,6 +145,36 @@ func TestAnthropic_Chat_SystemMessage(t *testing.T) {
 	}
 }
 
+func TestAnthropic_Chat_UserMetadata(t *testing.T) {
+	var capturedRequest anthropicRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&capturedRequest)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anthropicResponse{
+			ID:         "msg_789",
+			StopReason: "end_turn",
+			Content:    []anthropicContent{{Type: "text", Text: "OK"}},
+		})
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("anthropic", srv.URL, "test-key", []string{"claude-sonnet-4-5"})
+	_, err := p.Chat(context.Background(), &model.ChatRequest{
+		Model:    "claude-sonnet-4-5",
+		Messages: []model.Message{{Role: "user", Content: "Hi"}},
+		User:     "user-42",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if capturedRequest.Metadata == nil || capturedRequest.Metadata.UserID != "user-42" {
+		t.Errorf("expected metadata.user_id user-42, got %+v", capturedRequest.Metadata)
+	}
+}
+
 func TestAnthropic_Chat_DefaultMaxTokens(t *testing.T) {
 	var capturedRequest anthropicRequest
 
@@ -328,6 +360,72 @@ func TestAnthropic_ChatStream(t *testing.T) {
 	}
 }
 
+func TestAnthropic_ChatStream_ToolUse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_tools","usage":{"input_tokens":10,"output_tokens":0}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\": "}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, e := range events {
+			var head struct{ Type string }
+			json.Unmarshal([]byte(e), &head)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", head.Type, e)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("anthropic", srv.URL, "test-key", []string{"claude-sonnet-4-5"})
+	req := &model.ChatRequest{
+		Model:    "claude-sonnet-4-5",
+		Messages: []model.Message{{Role: "user", Content: "Weather in Paris?"}},
+	}
+	sw := newTestSSEWriter()
+	if _, err := p.ChatStream(context.Background(), req, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// role, text, tool call start, 2 argument fragments (the empty one is
+	// dropped), finish.
+	if len(sw.events) != 6 {
+		t.Fatalf("expected 6 events, got %d: %v", len(sw.events), sw.events)
+	}
+	chunks := make([]model.ChatStreamChunk, len(sw.events))
+	for i, e := range sw.events {
+		if err := json.Unmarshal([]byte(e), &chunks[i]); err != nil {
+			t.Fatalf("invalid chunk %s: %v", e, err)
+		}
+	}
+
+	start := chunks[2].Choices[0].Delta.ToolCalls
+	if len(start) != 1 || start[0].Index != 0 || start[0].ID != "toolu_1" || start[0].Type != "function" || start[0].Function.Name != "get_weather" {
+		t.Fatalf("tool call start = %+v", start)
+	}
+	var args string
+	for _, c := range chunks[3:5] {
+		calls := c.Choices[0].Delta.ToolCalls
+		if len(calls) != 1 || calls[0].Index != 0 || calls[0].ID != "" || calls[0].Function.Name != "" {
+			t.Fatalf("argument delta = %+v", calls)
+		}
+		args += calls[0].Function.Arguments
+	}
+	if args != `{"city": "Paris"}` {
+		t.Errorf("arguments = %q", args)
+	}
+	if chunks[5].Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("expected finish_reason tool_calls, got %q", chunks[5].Choices[0].FinishReason)
+	}
+}
+
 func TestAnthropic_StopReasonMapping(t *testing.T) {
 	tests := []struct {
 		anthropicReason string
@@ -336,6 +434,7 @@ func TestAnthropic_StopReasonMapping(t *testing.T) {
 		{"end_turn", "stop"},
 		{"max_tokens", "length"},
 		{"stop_sequence", "stop"},
+		{"tool_use", "tool_calls"},
 		{"unknown", "unknown"},
 	}
 
@@ -348,3 +447,151 @@ func TestAnthropic_StopReasonMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestAnthropic_Chat_PromptCaching(t *testing.T) {
+	var raw map[string]json.RawMessage
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&raw)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anthropicResponse{
+			ID:         "msg_cache",
+			Model:      "claude-sonnet-4-5",
+			StopReason: "end_turn",
+			Content:    []anthropicContent{{Type: "text", Text: "OK"}},
+			Usage: anthropicUsage{
+				InputTokens:              10,
+				OutputTokens:             2,
+				CacheCreationInputTokens: 1000,
+				CacheReadInputTokens:     500,
+			},
+		})
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("anthropic", srv.URL, "test-key", []string{"claude-sonnet-4-5"})
+	req := &model.ChatRequest{
+		Model: "claude-sonnet-4-5",
+		Messages: []model.Message{
+			{Role: "system", Content: "Long policy document.", CacheControl: &model.CacheControl{Type: "ephemeral"}},
+			{Role: "user", Content: "Hi"},
+		},
+	}
+
+	resp, err := p.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantSystem := `[{"type":"text","text":"Long policy document.","cache_control":{"type":"ephemeral"}}]`
+	if string(raw["system"]) != wantSystem {
+		t.Errorf("expected system blocks %s, got %s", wantSystem, raw["system"])
+	}
+	if !strings.Contains(string(raw["messages"]), `"content":"Hi"`) {
+		t.Errorf("expected plain string content for uncached message, got %s", raw["messages"])
+	}
+
+	u := resp.Usage
+	if u.PromptTokens != 1510 {
+		t.Errorf("expected prompt tokens to include cached tokens (1510), got %d", u.PromptTokens)
+	}
+	if u.CacheCreationInputTokens != 1000 || u.CacheReadInputTokens != 500 {
+		t.Errorf("expected cache usage 1000/500, got %d/%d", u.CacheCreationInputTokens, u.CacheReadInputTokens)
+	}
+}
+
+func TestOpenAICompat_StripsCacheControl(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "cache_control") {
+			t.Errorf("expected cache_control to be stripped, got %s", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"x","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	req := &model.ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []model.Message{{Role: "system", Content: "sys", CacheControl: &model.CacheControl{Type: "ephemeral"}}},
+	}
+	if _, err := p.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Messages[0].CacheControl == nil {
+		t.Error("expected caller's request to be left untouched")
+	}
+}
+
+func BenchmarkAnthropicRelayStream(b *testing.B) {
+	var body bytes.Buffer
+	body.WriteString("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-sonnet-4-5\",\"usage\":{\"input_tokens\":12}}}\n\n")
+	for range 100 {
+		body.WriteString("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello there, \"}}\n\n")
+	}
+	body.WriteString("event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":100}}\n\n")
+	body.WriteString("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+
+	a := NewAnthropic("anthropic", "http://unused", "k", []string{"claude-sonnet-4-5"})
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := a.relayStream(bytes.NewReader(body.Bytes()), discardSSEWriter{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAnthropicDeltaText(t *testing.T) {
+	tests := []struct {
+		data string
+		text string
+		ok   bool
+	}{
+		{`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`, "Hello", true},
+		{`{"type":"content_block_delta","index":0,"delta":{"text":"Hi","type":"text_delta"}}`, "Hi", true},
+		{`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"say \"hi\""}}`, "", false},
+		{`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"a\""}}`, "", false},
+		{`{"type": "content_block_delta", "delta": {"type": "text_delta", "text": "spaced"}}`, "", false},
+	}
+	for _, tt := range tests {
+		text, ok := anthropicDeltaText([]byte(tt.data))
+		if text != tt.text || ok != tt.ok {
+			t.Errorf("anthropicDeltaText(%s) = %q, %v; want %q, %v", tt.data, text, ok, tt.text, tt.ok)
+		}
+	}
+}
+
+func TestAnthropic_APIVersionAndBetas(t *testing.T) {
+	var version, beta string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, beta = r.Header.Get("anthropic-version"), r.Header.Get("anthropic-beta")
+		json.NewEncoder(w).Encode(anthropicResponse{ID: "msg_1", Content: []anthropicContent{{Type: "text", Text: "ok"}}})
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("anthropic", srv.URL, "test-key", []string{"claude-sonnet-4-5"})
+	p.SetAPIVersion("2024-01-01")
+	p.SetBetas([]string{"tools-2024-04-04", "prompt-caching-2024-07-31"})
+
+	req := &model.ChatRequest{Model: "claude-sonnet-4-5", Messages: []model.Message{{Role: "user", Content: "Hi"}}}
+	if _, err := p.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "2024-01-01" {
+		t.Errorf("anthropic-version = %q", version)
+	}
+	if beta != "tools-2024-04-04,prompt-caching-2024-07-31" {
+		t.Errorf("anthropic-beta = %q", beta)
+	}
+}
+
+func TestAnthropic_MaxCompletionTokens(t *testing.T) {
+	legacy, current := 100, 300
+	a := NewAnthropic("anthropic", "http://unused", "k", nil)
+	ar := a.convertRequest(&model.ChatRequest{Model: "claude-sonnet-4-5", MaxTokens: &legacy, MaxCompletionTokens: &current})
+	if ar.MaxTokens != 300 {
+		t.Errorf("expected max_completion_tokens to map to max_tokens 300, got %d", ar.MaxTokens)
+	}
+}
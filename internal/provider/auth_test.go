@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestOpenAICompat_HMACAuth(t *testing.T) {
+	secret := []byte("s3cret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, secret)
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.URL.RequestURI(), r.Header.Get("X-Signature-Timestamp"), hex.EncodeToString(sum[:]))
+		if got, want := r.Header.Get("X-Signature"), hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		if r.Header.Get("X-Signature-Timestamp") != "1700000000" || r.Header.Get("X-Signature-Key-Id") != "k1" {
+			t.Errorf("unexpected signing headers %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	signer := NewHMACSigner(secret, "k1")
+	signer.now = func() time.Time { return time.Unix(1700000000, 0) }
+	p := NewOpenAICompat("gateway", srv.URL, "", []string{"gpt-4o"})
+	p.SetRequestExtras(RequestExtras{Query: map[string]string{"api-version": "1"}})
+	p.SetAuth(signer)
+	if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAnthropic_OAuthClientCredentials(t *testing.T) {
+	var fetches atomic.Int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "llm.read llm.write" || id != "qlite" || secret != "pw" {
+			t.Errorf("unexpected token request %v, %s:%s", r.Form, id, secret)
+		}
+		fmt.Fprint(w, `{"access_token":"tok-1","expires_in":3600}`)
+	}))
+	defer tokenSrv.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer tok-1" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg","model":"claude","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("gateway", srv.URL, "", []string{"claude"})
+	p.SetAuth(NewOAuthClientCredentials(tokenSrv.URL, "qlite", "pw", []string{"llm.read", "llm.write"}))
+	for range 3 {
+		if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "claude", Messages: []model.Message{{Role: "user", Content: "hi"}}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected the token to be fetched once and cached, got %d fetches", n)
+	}
+}
+
+func TestOAuthClientCredentials_Error(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+	}))
+	defer tokenSrv.Close()
+
+	p := NewOpenAICompat("gateway", "http://127.0.0.1:1", "", []string{"gpt-4o"})
+	p.SetAuth(NewOAuthClientCredentials(tokenSrv.URL, "qlite", "bad", nil))
+	if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err == nil {
+		t.Fatal("expected the token error")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		expiresIn int
+		want      time.Duration
+	}{
+		{3600, time.Hour - tokenExpiryMargin},
+		{60, 30 * time.Second},
+		{0, defaultTokenLifetime - tokenExpiryMargin},
+	}
+	for _, tt := range tests {
+		if got := tokenExpiry(now, tt.expiresIn).Sub(now); got != tt.want {
+			t.Errorf("tokenExpiry(%d) = now+%v, want now+%v", tt.expiresIn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ModelLister is implemented by providers whose upstream lists the models
+// it serves.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ListModels returns the IDs of the models the upstream lists at GET
+// /models.
+func (o *OpenAICompat) ListModels(ctx context.Context) ([]string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if err := o.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding model list: %w", err)
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if m.ID != "" {
+			models = append(models, m.ID)
+		}
+	}
+	return models, nil
+}
+
+// Discovery registers the models a provider's upstream lists, as far as
+// they match an allow pattern, so a model added upstream is served without
+// a configuration change. Models are only ever added: one that disappears
+// upstream stays registered and fails at the upstream.
+type Discovery struct {
+	registry *Registry
+	provider Provider
+	lister   ModelLister
+	allow    []*regexp.Regexp
+	now      func() time.Time
+
+	mu     sync.Mutex
+	models []string
+	status DiscoveryStatus
+}
+
+// DiscoveryStatus describes a discovery's last refresh.
+type DiscoveryStatus struct {
+	// RefreshedAt is when the model list was last fetched, successfully
+	// or not; zero before the first refresh.
+	RefreshedAt time.Time `json:"refreshed_at"`
+	// Listed is how many allowed models the last successful listing had.
+	Listed int    `json:"listed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewDiscovery creates a discovery that lists models with lister and
+// registers p for those matching one of the allow regular expressions, or
+// for all of them if there are none.
+func NewDiscovery(registry *Registry, p Provider, lister ModelLister, allow []string) (*Discovery, error) {
+	d := &Discovery{registry: registry, provider: p, lister: lister, now: time.Now}
+	for _, a := range allow {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, fmt.Errorf("compiling allow pattern %q: %w", a, err)
+		}
+		d.allow = append(d.allow, re)
+	}
+	return d, nil
+}
+
+// Refresh lists the upstream's models and registers the allowed ones. It
+// returns the models registered by this call.
+func (d *Discovery) Refresh(ctx context.Context) ([]string, error) {
+	at := d.now()
+	listed, err := d.lister.ListModels(ctx)
+	if err != nil {
+		err = fmt.Errorf("listing models of provider %s: %w", d.provider.Name(), err)
+		d.mu.Lock()
+		d.status.RefreshedAt, d.status.Error = at, err.Error()
+		d.mu.Unlock()
+		return nil, err
+	}
+	listed = slices.DeleteFunc(listed, func(m string) bool { return !d.allowed(m) })
+	added := d.registry.Add(d.provider, listed)
+	d.mu.Lock()
+	d.models = append(d.models, added...)
+	d.status = DiscoveryStatus{RefreshedAt: at, Listed: len(listed)}
+	d.mu.Unlock()
+	return added, nil
+}
+
+func (d *Discovery) allowed(m string) bool {
+	if len(d.allow) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(d.allow, func(re *regexp.Regexp) bool { return re.MatchString(m) })
+}
+
+// Status returns the outcome of the last refresh.
+func (d *Discovery) Status() DiscoveryStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Models returns the models registered by discovery so far.
+func (d *Discovery) Models() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.models)
+}
+
+// Run refreshes about every interval, jittered, until ctx is done,
+// reporting each result to onRefresh.
+func (d *Discovery) Run(ctx context.Context, interval time.Duration, onRefresh func(added []string, err error)) {
+	runJittered(ctx, interval, func() {
+		added, err := d.Refresh(ctx)
+		if onRefresh != nil {
+			onRefresh(added, err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiscovery_Refresh(t *testing.T) {
+	listed := `{"object":"list","data":[{"id":"llama-3.1-8b"},{"id":"llama-3.3-70b"},{"id":"bge-m3"}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/models" || r.Header.Get("Authorization") != "Bearer k" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(listed))
+	}))
+	defer srv.Close()
+
+	registry := NewRegistry()
+	p := NewOpenAICompat("vllm", srv.URL, "k", []string{"llama-3.1-8b"})
+	registry.Register(p)
+	registry.Freeze()
+
+	d, err := NewDiscovery(registry, p, p, []string{"^llama-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := d.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(added, []string{"llama-3.3-70b"}) {
+		t.Errorf("expected only the new allowed model, got %v", added)
+	}
+	if got, err := registry.Lookup("llama-3.3-70b"); err != nil || got != p {
+		t.Errorf("expected the discovered model to be served after Freeze, got %v, %v", got, err)
+	}
+	if _, err := registry.Lookup("bge-m3"); err == nil {
+		t.Error("expected a model outside the allow patterns not to be registered")
+	}
+
+	listed = `{"data":[{"id":"llama-3.3-70b"},{"id":"llama-4-scout"}]}`
+	if added, _ := d.Refresh(context.Background()); !slices.Equal(added, []string{"llama-4-scout"}) {
+		t.Errorf("expected a later refresh to add new models only, got %v", added)
+	}
+	if got := d.Models(); !slices.Equal(got, []string{"llama-3.3-70b", "llama-4-scout"}) {
+		t.Errorf("unexpected discovered models %v", got)
+	}
+}
+
+func TestDiscovery_UpstreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"nope"}}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	registry := NewRegistry()
+	p := NewOpenAICompat("vllm", srv.URL, "k", nil)
+	d, _ := NewDiscovery(registry, p, p, nil)
+	if _, err := d.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the listing error")
+	}
+	if len(d.Models()) != 0 {
+		t.Errorf("expected nothing registered, got %v", d.Models())
+	}
+	if st := d.Status(); st.RefreshedAt.IsZero() || st.Error == "" {
+		t.Errorf("expected the failed refresh in the status, got %+v", st)
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			http.Error(w, `{"error":{"message":"down"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"llama-3.1-8b"}]}`))
+	}))
+	defer srv.Close()
+
+	probe := NewHealthProbe(NewOpenAICompat("vllm", srv.URL, "k", nil))
+	if !probe.Status().CheckedAt.IsZero() {
+		t.Fatal("expected no result before the first check")
+	}
+	if st := probe.Check(context.Background()); !st.Healthy || st.CheckedAt.IsZero() {
+		t.Errorf("expected a healthy result, got %+v", st)
+	}
+	healthy = false
+	probe.Check(context.Background())
+	if st := probe.Status(); st.Healthy || st.Error == "" {
+		t.Errorf("expected the failed probe to be kept, got %+v", st)
+	}
+}
+
+func TestJittered(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		d := jittered(10 * time.Second)
+		if d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("jittered interval %v outside ±20%%", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected intervals to vary")
+	}
+}
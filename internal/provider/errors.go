@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider failures are classified into these kinds so callers can pick a
+// status code, retry or fall back with errors.Is instead of matching on
+// message text. Errors that fit none of them are returned unclassified.
+var (
+	ErrRateLimited     = errors.New("upstream rate limited")
+	ErrAuth            = errors.New("upstream rejected credentials")
+	ErrContextLength   = errors.New("context length exceeded")
+	ErrUpstreamTimeout = errors.New("upstream timeout")
+)
+
+// UpstreamError is a non-200 response from a provider. It unwraps to its
+// Kind, one of the Err* values above, or nil when the status isn't one
+// qlite classifies.
+type UpstreamError struct {
+	Status int
+	Body   string
+	Kind   error
+	// RetryAfter is the upstream's Retry-After hint, zero if absent.
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream error (status %d): %s", e.Status, e.Body)
+}
+
+func (e *UpstreamError) Unwrap() error { return e.Kind }
+
+// newUpstreamError reads up to 4 KiB of resp's body and classifies it.
+func newUpstreamError(resp *http.Response) *UpstreamError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &UpstreamError{
+		Status:     resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	e.Kind = classifyStatus(e.Status, e.Body)
+	return e
+}
+
+// contextLengthMarkers are substrings of the error bodies OpenAI-compatible
+// backends, Anthropic and Gemini return for over-long prompts.
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"prompt is too long",
+	"input token count",
+	"too many tokens",
+}
+
+func classifyStatus(status int, body string) error {
+	switch status {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrUpstreamTimeout
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		lower := strings.ToLower(body)
+		for _, m := range contextLengthMarkers {
+			if strings.Contains(lower, m) {
+				return ErrContextLength
+			}
+		}
+	}
+	return nil
+}
+
+// parseRetryAfter parses a Retry-After value in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// transportError marks err from sending a request as ErrUpstreamTimeout when
+// it was a timeout, keeping err itself in the chain.
+func transportError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %w", ErrUpstreamTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestUpstreamErrorClassification(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"rate limited", http.StatusTooManyRequests, `{"error":{"type":"rate_limit_error"}}`, ErrRateLimited},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"invalid api key"}`, ErrAuth},
+		{"forbidden", http.StatusForbidden, ``, ErrAuth},
+		{"openai context length", http.StatusBadRequest, `{"error":{"code":"context_length_exceeded"}}`, ErrContextLength},
+		{"anthropic context length", http.StatusBadRequest, `{"error":{"message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, ErrContextLength},
+		{"gemini context length", http.StatusBadRequest, `{"error":{"message":"The input token count (1200000) exceeds the maximum"}}`, ErrContextLength},
+		{"gateway timeout", http.StatusGatewayTimeout, ``, ErrUpstreamTimeout},
+		{"other bad request", http.StatusBadRequest, `{"error":"bad temperature"}`, nil},
+		{"server error", http.StatusInternalServerError, `oops`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			p := NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"})
+			_, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+			var ue *UpstreamError
+			if !errors.As(err, &ue) {
+				t.Fatalf("expected *UpstreamError, got %v", err)
+			}
+			if ue.Status != tt.status || ue.Kind != tt.want {
+				t.Errorf("got status %d kind %v, want %d %v", ue.Status, ue.Kind, tt.status, tt.want)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("errors.Is(err, %v) = false", tt.want)
+			}
+			if ue.RetryAfter != 7*time.Second {
+				t.Errorf("RetryAfter = %v, want 7s", ue.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestTransportErrorTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"})
+	p.client = &http.Client{Timeout: 20 * time.Millisecond}
+	_, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+	if !errors.Is(err, ErrUpstreamTimeout) {
+		t.Errorf("expected ErrUpstreamTimeout, got %v", err)
+	}
+}
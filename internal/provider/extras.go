@@ -0,0 +1,26 @@
+package provider
+
+import "net/http"
+
+// RequestExtras are static headers and query parameters attached to every
+// upstream request, for vendors with non-standard requirements (e.g.
+// OpenRouter's HTTP-Referer/X-Title or Azure's api-version).
+type RequestExtras struct {
+	Headers map[string]string
+	Query   map[string]string
+}
+
+// apply sets the extra headers and query parameters on req. It runs after
+// the provider's own headers, so extras can override them.
+func (e RequestExtras) apply(req *http.Request) {
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(e.Query) > 0 {
+		q := req.URL.Query()
+		for k, v := range e.Query {
+			q.Set(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// FixtureMode selects how a FixtureProvider treats upstream traffic.
+type FixtureMode string
+
+const (
+	// FixtureRecord forwards requests upstream and persists every response.
+	FixtureRecord FixtureMode = "record"
+	// FixtureReplay serves responses from disk and never touches the network.
+	FixtureReplay FixtureMode = "replay"
+)
+
+// ErrNoFixture is returned in replay mode when no recorded response exists.
+var ErrNoFixture = errors.New("no recorded fixture for request")
+
+// fixture is the on-disk format of a recorded exchange.
+type fixture struct {
+	Request  *model.ChatRequest  `json:"request"`
+	Response *model.ChatResponse `json:"response,omitempty"`
+	Events   []string            `json:"events,omitempty"`
+	Done     bool                `json:"done,omitempty"`
+	Usage    *model.Usage        `json:"usage,omitempty"`
+}
+
+// FixtureProvider wraps a Provider to record upstream responses to a fixtures
+// directory, or to replay them hermetically. Fixtures are keyed by a SHA-256
+// of the request, so identical requests map to the same file.
+type FixtureProvider struct {
+	inner Provider
+	dir   string
+	mode  FixtureMode
+}
+
+// NewFixtureProvider wraps inner. In replay mode inner is only used for its
+// name and model list.
+func NewFixtureProvider(inner Provider, dir string, mode FixtureMode) *FixtureProvider {
+	return &FixtureProvider{inner: inner, dir: dir, mode: mode}
+}
+
+func (f *FixtureProvider) Name() string     { return f.inner.Name() }
+func (f *FixtureProvider) Models() []string { return f.inner.Models() }
+
+// Chat records or replays a non-streaming request.
+func (f *FixtureProvider) Chat(ctx context.Context, req *model.ChatRequest) (*model.ChatResponse, error) {
+	path, err := f.path(req, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if f.mode == FixtureReplay {
+		fx, err := readFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		if fx.Response == nil {
+			return nil, fmt.Errorf("fixture %s has no response", filepath.Base(path))
+		}
+		return fx.Response, nil
+	}
+
+	reqCopy := *req
+	resp, err := f.inner.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFixture(path, &fixture{Request: &reqCopy, Response: resp}); err != nil {
+		return nil, fmt.Errorf("recording fixture: %w", err)
+	}
+	return resp, nil
+}
+
+// ChatStream records or replays a streaming request. Raw SSE event payloads are
+// stored verbatim so replay is byte-identical to the original stream.
+func (f *FixtureProvider) ChatStream(ctx context.Context, req *model.ChatRequest, sw sse.Writer) (*model.Usage, error) {
+	path, err := f.path(req, ".stream")
+	if err != nil {
+		return nil, err
+	}
+
+	if f.mode == FixtureReplay {
+		fx, err := readFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range fx.Events {
+			if err := sw.WriteEvent([]byte(ev)); err != nil {
+				return fx.Usage, fmt.Errorf("writing event: %w", err)
+			}
+		}
+		if fx.Done {
+			if err := sw.Done(); err != nil {
+				return fx.Usage, fmt.Errorf("writing done: %w", err)
+			}
+		}
+		return fx.Usage, nil
+	}
+
+	reqCopy := *req
+	rec := &recordingWriter{inner: sw}
+	usage, err := f.inner.ChatStream(ctx, req, rec)
+	if err != nil {
+		return usage, err
+	}
+	fx := &fixture{Request: &reqCopy, Events: rec.events, Done: rec.done, Usage: usage}
+	if err := writeFixture(path, fx); err != nil {
+		return usage, fmt.Errorf("recording fixture: %w", err)
+	}
+	return usage, nil
+}
+
+// path returns the fixture file for req. The stream flag and stream options are
+// excluded from the hash; streaming fixtures use a distinct suffix instead.
+func (f *FixtureProvider) path(req *model.ChatRequest, suffix string) (string, error) {
+	k := *req
+	k.Stream = false
+	k.StreamOptions = nil
+	b, err := json.Marshal(&k)
+	if err != nil {
+		return "", fmt.Errorf("hashing request: %w", err)
+	}
+	h := sha256.Sum256(b)
+	return filepath.Join(f.dir, hex.EncodeToString(h[:])+suffix+".json"), nil
+}
+
+func readFixture(path string) (*fixture, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoFixture, filepath.Base(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture: %w", err)
+	}
+	var fx fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("decoding fixture %s: %w", filepath.Base(path), err)
+	}
+	return &fx, nil
+}
+
+// writeFixture writes atomically so concurrent identical requests never leave
+// a partially written file behind.
+func writeFixture(path string, fx *fixture) error {
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordingWriter passes events through while keeping a copy of each payload.
+type recordingWriter struct {
+	inner  sse.Writer
+	events []string
+	done   bool
+}
+
+func (r *recordingWriter) SetHeader(key, value string) { r.inner.SetHeader(key, value) }
+
+func (r *recordingWriter) WriteEvent(data []byte) error {
+	r.events = append(r.events, string(data))
+	return r.inner.WriteEvent(data)
+}
+
+func (r *recordingWriter) Done() error {
+	r.done = true
+	return r.inner.Done()
+}
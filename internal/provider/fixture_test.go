@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestFixtureProvider_RecordThenReplay(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-rec",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "recorded"}}},
+		})
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	upstream := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	req := model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "Hello"}}}
+
+	rec := NewFixtureProvider(upstream, dir, FixtureRecord)
+	r1 := req
+	if _, err := rec.Chat(context.Background(), &r1); err != nil {
+		t.Fatalf("record: unexpected error: %v", err)
+	}
+
+	srv.Close()
+	replay := NewFixtureProvider(upstream, dir, FixtureReplay)
+	r2 := req
+	resp, err := replay.Chat(context.Background(), &r2)
+	if err != nil {
+		t.Fatalf("replay: unexpected error: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "recorded" {
+		t.Errorf("expected recorded content, got %q", resp.Choices[0].Message.Content)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls.Load())
+	}
+}
+
+func TestFixtureProvider_StreamRecordThenReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"id":"c","choices":[{"index":0,"delta":{"content":"Hi"}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"id":"c","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	upstream := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	req := model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "Hello"}}}
+
+	recSW := newTestSSEWriter()
+	r1 := req
+	if _, err := NewFixtureProvider(upstream, dir, FixtureRecord).ChatStream(context.Background(), &r1, recSW); err != nil {
+		t.Fatalf("record: unexpected error: %v", err)
+	}
+
+	srv.Close()
+	sw := newTestSSEWriter()
+	r2 := req
+	usage, err := NewFixtureProvider(upstream, dir, FixtureReplay).ChatStream(context.Background(), &r2, sw)
+	if err != nil {
+		t.Fatalf("replay: unexpected error: %v", err)
+	}
+	if len(sw.events) != len(recSW.events) {
+		t.Fatalf("expected %d events, got %d", len(recSW.events), len(sw.events))
+	}
+	for i := range sw.events {
+		if sw.events[i] != recSW.events[i] {
+			t.Errorf("event %d differs: %q vs %q", i, sw.events[i], recSW.events[i])
+		}
+	}
+	if !sw.done {
+		t.Error("expected Done to be called")
+	}
+	if usage == nil || usage.TotalTokens != 4 {
+		t.Errorf("expected usage with 4 total tokens, got %+v", usage)
+	}
+}
+
+func TestFixtureProvider_ReplayMissing(t *testing.T) {
+	upstream := NewOpenAICompat("test", "http://127.0.0.1:0", "test-key", []string{"gpt-4o"})
+	replay := NewFixtureProvider(upstream, t.TempDir(), FixtureReplay)
+
+	_, err := replay.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+	if !errors.Is(err, ErrNoFixture) {
+		t.Errorf("expected ErrNoFixture, got %v", err)
+	}
+}
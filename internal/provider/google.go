@@ -1,49 +1,119 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
 )
 
-// Google is a provider that speaks the Gemini API.
+// Google is a provider that speaks the Gemini API, either on the public
+// generativelanguage endpoint (API key) or on Vertex AI (OAuth bearer token).
 type Google struct {
 	name    string
 	baseURL string
 	apiKey  string
 	models  []string
 	client  *http.Client
+
+	tokens  *googleTokenSource // non-nil for Vertex AI
+	extras  RequestExtras
+	auth    AuthProvider
+	noStore NoStoreHints
 }
 
-// NewGoogle creates a new Google (Gemini) provider.
+// NewVertex creates a Gemini provider for Vertex AI. Authentication uses the
+// service account key in credentialsFile, or Application Default Credentials
+// when empty. If baseURL is empty it is derived from project and region.
+func NewVertex(name, baseURL, project, region, credentialsFile string, models []string) (*Google, error) {
+	if baseURL == "" {
+		host := region + "-aiplatform.googleapis.com"
+		if region == "global" {
+			host = "aiplatform.googleapis.com"
+		}
+		baseURL = "https://" + host + "/v1/projects/" + project + "/locations/" + region
+	}
+	g := NewGoogle(name, baseURL, "", models)
+	tokens, err := newGoogleTokenSource(credentialsFile, g.client)
+	if err != nil {
+		return nil, err
+	}
+	g.tokens = tokens
+	return g, nil
+}
+
+// DefaultGeminiBaseURL is the public Gemini API endpoint, used when a google
+// provider has no base URL.
+const DefaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// NewGoogle creates a new Google (Gemini) provider. An empty baseURL means
+// DefaultGeminiBaseURL.
 func NewGoogle(name, baseURL, apiKey string, models []string) *Google {
-	transport := &http.Transport{
-		DisableCompression:  true,
-		MaxIdleConns:        1000,
-		MaxIdleConnsPerHost: 1000,
-		IdleConnTimeout:     90 * time.Second,
-		WriteBufferSize:     32 << 10,
-		ReadBufferSize:      32 << 10,
-		ForceAttemptHTTP2:   true,
+	if baseURL == "" {
+		baseURL = DefaultGeminiBaseURL
 	}
 	return &Google{
 		name:    name,
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		models:  models,
-		client:  &http.Client{Transport: transport},
+		client:  &http.Client{Transport: NewTransport(TransportConfig{})},
 	}
 }
 
+// geminiVersion matches a Gemini API version path segment: v1, v1beta,
+// v1alpha, v1beta2 and so on.
+var geminiVersion = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+// SetAPIVersion switches the Gemini API version by replacing the version
+// segment of the base URL path (".../v1beta" or Vertex's "/v1/projects/..."),
+// or appending one if the path has none.
+func (g *Google) SetAPIVersion(v string) {
+	u, err := url.Parse(g.baseURL)
+	if err != nil {
+		return
+	}
+	segs := strings.Split(strings.TrimSuffix(u.Path, "/"), "/")
+	replaced := false
+	for i, seg := range segs {
+		if geminiVersion.MatchString(seg) {
+			segs[i] = v
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		segs = append(segs, v)
+	}
+	u.Path = strings.Join(segs, "/")
+	g.baseURL = u.String()
+}
+
+// SetTransport replaces the transport used for upstream calls.
+func (g *Google) SetTransport(rt http.RoundTripper) { g.client.Transport = rt }
+
+// SetRequestExtras configures static headers and query parameters sent with
+// every upstream request.
+func (g *Google) SetRequestExtras(e RequestExtras) { g.extras = e }
+
+// SetAuth authenticates every upstream request with auth, after the
+// provider's own credentials and the extras are set.
+func (g *Google) SetAuth(auth AuthProvider) { g.auth = auth }
+
+// SetNoStoreHints marks non-streaming responses carrying any of h as not
+// cacheable.
+func (g *Google) SetNoStoreHints(h NoStoreHints) { g.noStore = h }
+
 func (g *Google) Name() string    { return g.name }
 func (g *Google) Models() []string { return g.models }
 
@@ -60,7 +130,13 @@ type geminiContent struct {
 }
 
 type geminiPart struct {
-	Text string `json:"text"`
+	Text         string              `json:"text"`
+	FunctionCall *geminiFunctionCall `json:"functionCall,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
 }
 
 type geminiSystemInstruction struct {
@@ -71,6 +147,8 @@ type geminiGenerationConfig struct {
 	Temperature     *float64 `json:"temperature,omitempty"`
 	TopP            *float64 `json:"topP,omitempty"`
 	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+	CandidateCount  *int     `json:"candidateCount,omitempty"`
 }
 
 // Gemini response types.
@@ -82,6 +160,33 @@ type geminiResponse struct {
 type geminiCandidate struct {
 	Content      geminiContent `json:"content"`
 	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+// text concatenates all text parts of the candidate. Non-text parts such as
+// function calls carry no text and are skipped rather than dropping the chunk.
+func (c *geminiCandidate) text() string {
+	switch len(c.Content.Parts) {
+	case 0:
+		return ""
+	case 1:
+		return c.Content.Parts[0].Text
+	}
+	var sb strings.Builder
+	for _, p := range c.Content.Parts {
+		sb.WriteString(p.Text)
+	}
+	return sb.String()
+}
+
+// calls reports whether the candidate has function calls.
+func (c *geminiCandidate) calls() bool {
+	for _, p := range c.Content.Parts {
+		if p.FunctionCall != nil {
+			return true
+		}
+	}
+	return false
 }
 
 type geminiUsage struct {
@@ -103,8 +208,16 @@ func (g *Google) convertRequest(req *model.ChatRequest) *geminiRequest {
 		genConfig.TopP = req.TopP
 		hasConfig = true
 	}
-	if req.MaxTokens != nil {
-		genConfig.MaxOutputTokens = req.MaxTokens
+	if n := req.MaxOutputTokens(); n != nil {
+		genConfig.MaxOutputTokens = n
+		hasConfig = true
+	}
+	if req.Seed != nil {
+		genConfig.Seed = req.Seed
+		hasConfig = true
+	}
+	if req.N != nil && *req.N > 1 {
+		genConfig.CandidateCount = req.N
 		hasConfig = true
 	}
 	if hasConfig {
@@ -135,13 +248,43 @@ func (g *Google) convertRequest(req *model.ChatRequest) *geminiRequest {
 }
 
 func (g *Google) chatURL(modelName string) string {
+	if g.tokens != nil {
+		return g.baseURL + "/publishers/google/models/" + modelName + ":generateContent"
+	}
 	return g.baseURL + "/models/" + modelName + ":generateContent?key=" + g.apiKey
 }
 
 func (g *Google) streamURL(modelName string) string {
+	if g.tokens != nil {
+		return g.baseURL + "/publishers/google/models/" + modelName + ":streamGenerateContent?alt=sse"
+	}
 	return g.baseURL + "/models/" + modelName + ":streamGenerateContent?alt=sse&key=" + g.apiKey
 }
 
+// setHeaders sets request headers, including the OAuth bearer token on Vertex AI.
+func (g *Google) setHeaders(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Content-Type", "application/json")
+	if g.tokens != nil {
+		token, err := g.tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("authenticating to vertex ai: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	g.extras.apply(req)
+	return authenticate(ctx, g.auth, req)
+}
+
+// geminiArguments returns a function call's args as OpenAI's arguments
+// string: compact JSON, "{}" if there were none.
+func geminiArguments(args json.RawMessage) string {
+	var buf bytes.Buffer
+	if len(args) == 0 || json.Compact(&buf, args) != nil {
+		return "{}"
+	}
+	return buf.String()
+}
+
 func geminiFinishReason(reason string) string {
 	switch reason {
 	case "STOP":
@@ -170,32 +313,45 @@ func (g *Google) Chat(ctx context.Context, req *model.ChatRequest) (*model.ChatR
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	if err := g.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := g.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newUpstreamError(resp)
 	}
 
 	var gr2 geminiResponse
-	if err := json.NewDecoder(resp.Body).Decode(&gr2); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
+	noStore, err := g.noStore.decode(resp, &gr2)
+	if err != nil {
+		return nil, err
 	}
 
-	var content string
-	var finishReason string
-	if len(gr2.Candidates) > 0 {
-		cand := gr2.Candidates[0]
-		if len(cand.Content.Parts) > 0 {
-			content = cand.Content.Parts[0].Text
+	choices := make([]model.Choice, 0, len(gr2.Candidates))
+	for i := range gr2.Candidates {
+		cand := &gr2.Candidates[i]
+		finish := geminiFinishReason(cand.FinishReason)
+		if finish == "stop" && cand.calls() {
+			// As in streams; the calls themselves aren't carried here.
+			finish = "tool_calls"
 		}
-		finishReason = geminiFinishReason(cand.FinishReason)
+		choices = append(choices, model.Choice{
+			Index: cand.Index,
+			Message: model.Message{
+				Role:    "assistant",
+				Content: cand.text(),
+			},
+			FinishReason: finish,
+		})
+	}
+	if len(choices) == 0 {
+		choices = append(choices, model.Choice{Message: model.Message{Role: "assistant"}})
 	}
 
 	var usage model.Usage
@@ -213,17 +369,9 @@ func (g *Google) Chat(ctx context.Context, req *model.ChatRequest) (*model.ChatR
 		Object:  "chat.completion",
 		Created: now.Unix(),
 		Model:   req.Model,
-		Choices: []model.Choice{
-			{
-				Index: 0,
-				Message: model.Message{
-					Role:    "assistant",
-					Content: content,
-				},
-				FinishReason: finishReason,
-			},
-		},
-		Usage: usage,
+		Choices: choices,
+		Usage:   usage,
+		NoStore: noStore,
 	}, nil
 }
 
@@ -242,95 +390,125 @@ func (g *Google) ChatStream(ctx context.Context, req *model.ChatRequest, sw sse.
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	if err := g.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := g.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newUpstreamError(resp)
 	}
 
+	return g.relayStream(resp.Body, req.Model, sw)
+}
+
+// relayStream converts Gemini stream events from body into OpenAI chunks.
+// The decode target, outgoing chunk and choices slice are reused across
+// events.
+func (g *Google) relayStream(body io.Reader, modelName string, sw sse.Writer) (*model.Usage, error) {
 	now := time.Now()
-	genID := "gen-" + strconv.FormatInt(now.UnixNano(), 10)
-	created := now.Unix()
 	var usage model.Usage
+	var gr geminiResponse
+	chunk := &model.ChatStreamChunk{
+		ID:      "gen-" + strconv.FormatInt(now.UnixNano(), 10),
+		Object:  "chat.completion.chunk",
+		Created: now.Unix(),
+		Model:   modelName,
+	}
+	var choices []model.StreamChoice
 	first := true
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if !bytes.HasPrefix(line, dataPrefix) {
-			continue
+	// Candidates other than 0 (n>1) announce their role on their first
+	// delta, since the opening role chunk only covers index 0.
+	var announced map[int]bool
+	// tools numbers each candidate's function calls.
+	var tools map[int]*toolCallEncoder
+
+	events := newSSEReader(body)
+	for {
+		ev, ok := events.Next()
+		if !ok {
+			break
 		}
-		data := line[len(dataPrefix):]
 
-		var gr2 geminiResponse
-		if err := json.Unmarshal(data, &gr2); err != nil {
+		// Reset rather than reuse nested slices: json.Unmarshal would
+		// leave stale fields in recycled candidates.
+		gr = geminiResponse{}
+		if err := json.Unmarshal(ev.Data, &gr); err != nil {
 			continue
 		}
 
 		// Track usage from each chunk.
-		if gr2.UsageMetadata != nil {
+		if gr.UsageMetadata != nil {
 			usage = model.Usage{
-				PromptTokens:     gr2.UsageMetadata.PromptTokenCount,
-				CompletionTokens: gr2.UsageMetadata.CandidatesTokenCount,
-				TotalTokens:      gr2.UsageMetadata.TotalTokenCount,
+				PromptTokens:     gr.UsageMetadata.PromptTokenCount,
+				CompletionTokens: gr.UsageMetadata.CandidatesTokenCount,
+				TotalTokens:      gr.UsageMetadata.TotalTokenCount,
 			}
 		}
 
 		// Emit role chunk on first event.
 		if first {
 			first = false
-			roleChunk := model.ChatStreamChunk{
-				ID:      genID,
-				Object:  "chat.completion.chunk",
-				Created: created,
-				Model:   req.Model,
-				Choices: []model.StreamChoice{
-					{Index: 0, Delta: model.Delta{Role: "assistant"}},
-				},
-			}
-			if err := sse.WriteJSON(sw, roleChunk); err != nil {
+			choices = append(choices[:0], model.StreamChoice{Index: 0, Delta: model.Delta{Role: "assistant"}})
+			chunk.Choices = choices
+			if err := sse.WriteJSON(sw, chunk); err != nil {
 				return &usage, fmt.Errorf("writing event: %w", err)
 			}
 		}
 
-		var text string
-		var finishReason string
-		if len(gr2.Candidates) > 0 {
-			cand := gr2.Candidates[0]
-			if len(cand.Content.Parts) > 0 {
-				text = cand.Content.Parts[0].Text
+		if len(gr.Candidates) == 0 {
+			continue
+		}
+		choices = choices[:0]
+		for i := range gr.Candidates {
+			cand := &gr.Candidates[i]
+			c := model.StreamChoice{
+				Index:        cand.Index,
+				Delta:        model.Delta{Content: cand.text()},
+				FinishReason: geminiFinishReason(cand.FinishReason),
 			}
-			if cand.FinishReason != "" {
-				finishReason = geminiFinishReason(cand.FinishReason)
+			for _, p := range cand.Content.Parts {
+				if p.FunctionCall == nil {
+					continue
+				}
+				if tools == nil {
+					tools = make(map[int]*toolCallEncoder)
+				}
+				enc := tools[cand.Index]
+				if enc == nil {
+					enc = &toolCallEncoder{}
+					tools[cand.Index] = enc
+				}
+				// Gemini sends each call whole, so it is forwarded as soon
+				// as its part arrives.
+				n := enc.calls()
+				id := fmt.Sprintf("call_%s_%d_%d", strings.TrimPrefix(chunk.ID, "gen-"), cand.Index, n)
+				c.Delta.ToolCalls = append(c.Delta.ToolCalls, enc.start(n, id, p.FunctionCall.Name, geminiArguments(p.FunctionCall.Args))...)
 			}
+			if c.FinishReason == "stop" && tools[cand.Index] != nil {
+				c.FinishReason = "tool_calls"
+			}
+			if cand.Index != 0 && !announced[cand.Index] {
+				if announced == nil {
+					announced = make(map[int]bool)
+				}
+				announced[cand.Index] = true
+				c.Delta.Role = "assistant"
+			}
+			choices = append(choices, c)
 		}
-
-		chunk := model.ChatStreamChunk{
-			ID:      genID,
-			Object:  "chat.completion.chunk",
-			Created: created,
-			Model:   req.Model,
-			Choices: []model.StreamChoice{
-				{
-					Index:        0,
-					Delta:        model.Delta{Content: text},
-					FinishReason: finishReason,
-				},
-			},
-		}
+		chunk.Choices = choices
 		if err := sse.WriteJSON(sw, chunk); err != nil {
 			return &usage, fmt.Errorf("writing event: %w", err)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := events.Err(); err != nil {
 		return &usage, fmt.Errorf("reading stream: %w", err)
 	}
 
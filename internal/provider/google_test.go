@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -321,3 +322,243 @@ func TestGoogle_FinishReasonMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestGoogle_Chat_MultiPartAndCandidates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[`+
+			`{"index":0,"content":{"role":"model","parts":[{"text":"Here is code:\n"},{"functionCall":{"name":"run","args":{"x":1}}},{"text":"print(1)"}]},"finishReason":"STOP"},`+
+			`{"index":1,"content":{"role":"model","parts":[{"text":"Alt"}]},"finishReason":"MAX_TOKENS"}`+
+			`]}`)
+	}))
+	defer srv.Close()
+
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	resp, err := p.Chat(context.Background(), &model.ChatRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []model.Message{{Role: "user", Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Choices) != 2 {
+		t.Fatalf("expected 2 choices, got %d", len(resp.Choices))
+	}
+	if got := resp.Choices[0].Message.Content; got != "Here is code:\nprint(1)" {
+		t.Errorf("expected concatenated text parts, got %q", got)
+	}
+	if resp.Choices[1].Index != 1 || resp.Choices[1].FinishReason != "length" {
+		t.Errorf("unexpected second choice: %+v", resp.Choices[1])
+	}
+}
+
+func TestGoogle_Chat_FunctionCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"run","args":{"x":1}}}]},"finishReason":"STOP"}]}`)
+	}))
+	defer srv.Close()
+
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	resp, err := p.Chat(context.Background(), &model.ChatRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []model.Message{{Role: "user", Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resp.Choices[0].FinishReason; got != "tool_calls" {
+		t.Errorf("expected a function call to finish with tool_calls, got %q", got)
+	}
+}
+
+func TestGoogle_ChatStream_MultiPart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"a"},{"text":"b"}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"f"}}]},"finishReason":"STOP"}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2,"totalTokenCount":5}}`+"\n\n")
+	}))
+	defer srv.Close()
+
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	sw := newTestSSEWriter()
+	usage, err := p.ChatStream(context.Background(), &model.ChatRequest{Model: "gemini-2.5-flash"}, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// role + 2 candidate chunks; the usage-only chunk emits nothing.
+	if len(sw.events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(sw.events), sw.events)
+	}
+	var chunk model.ChatStreamChunk
+	json.Unmarshal([]byte(sw.events[1]), &chunk)
+	if chunk.Choices[0].Delta.Content != "ab" {
+		t.Errorf("expected concatenated parts 'ab', got %q", chunk.Choices[0].Delta.Content)
+	}
+	json.Unmarshal([]byte(sw.events[2]), &chunk)
+	if chunk.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("expected finish_reason tool_calls on function-call chunk, got %q", chunk.Choices[0].FinishReason)
+	}
+	if usage.TotalTokens != 5 {
+		t.Errorf("expected 5 total tokens, got %d", usage.TotalTokens)
+	}
+}
+
+func TestGoogle_RequestExtrasKeepAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" || r.URL.Query().Get("trace") != "1" {
+			t.Errorf("expected key and extra query params, got %q", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	p.SetRequestExtras(RequestExtras{Query: map[string]string{"trace": "1"}})
+	if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gemini-2.5-flash"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func BenchmarkGoogleRelayStream(b *testing.B) {
+	var body bytes.Buffer
+	for range 100 {
+		body.WriteString(`data: {"candidates":[{"content":{"parts":[{"text":"Hello there, "}],"role":"model"},"index":0}]}` + "\n\n")
+	}
+	body.WriteString(`data: {"candidates":[{"content":{"parts":[{"text":""}],"role":"model"},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":100,"totalTokenCount":112}}` + "\n\n")
+
+	g := NewGoogle("google", "http://unused", "k", []string{"gemini-2.5-flash"})
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := g.relayStream(bytes.NewReader(body.Bytes()), "gemini-2.5-flash", discardSSEWriter{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGoogle_SetAPIVersion(t *testing.T) {
+	tests := []struct {
+		base, version, want string
+	}{
+		{"", "v1", "https://generativelanguage.googleapis.com/v1"},
+		{"https://generativelanguage.googleapis.com/v1beta/", "v1", "https://generativelanguage.googleapis.com/v1"},
+		{"http://localhost:9999", "v1beta", "http://localhost:9999/v1beta"},
+		{"https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1", "v1beta1",
+			"https://us-central1-aiplatform.googleapis.com/v1beta1/projects/p/locations/us-central1"},
+	}
+	for _, tt := range tests {
+		g := NewGoogle("google", tt.base, "key", nil)
+		g.SetAPIVersion(tt.version)
+		if g.baseURL != tt.want {
+			t.Errorf("base %q + %s = %q, want %q", tt.base, tt.version, g.baseURL, tt.want)
+		}
+	}
+}
+
+func TestGoogle_MaxCompletionTokens(t *testing.T) {
+	legacy, current := 100, 300
+	g := NewGoogle("google", "", "k", nil)
+	gr := g.convertRequest(&model.ChatRequest{Model: "gemini-2.5-flash", MaxTokens: &legacy, MaxCompletionTokens: &current})
+	if gr.GenerationConfig == nil || gr.GenerationConfig.MaxOutputTokens == nil || *gr.GenerationConfig.MaxOutputTokens != 300 {
+		t.Errorf("expected maxOutputTokens 300, got %+v", gr.GenerationConfig)
+	}
+}
+
+func TestGoogle_ChatStream_InterleavedCandidates(t *testing.T) {
+	var candidateCount any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		candidateCount = body["generationConfig"]["candidateCount"]
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates":[{"index":0,"content":{"parts":[{"text":"A1"}]}},{"index":1,"content":{"parts":[{"text":"B1"}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates":[{"index":1,"content":{"parts":[{"text":"B2"}]},"finishReason":"MAX_TOKENS"}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates":[{"index":0,"content":{"parts":[{"text":"A2"}]},"finishReason":"STOP"}]}`+"\n\n")
+	}))
+	defer srv.Close()
+
+	n := 2
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	req := &model.ChatRequest{
+		Model:    "gemini-2.5-flash",
+		Messages: []model.Message{{Role: "user", Content: "Hello"}},
+		N:        &n,
+	}
+	sw := newTestSSEWriter()
+	if _, err := p.ChatStream(context.Background(), req, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if candidateCount != float64(2) {
+		t.Errorf("candidateCount = %v, want 2", candidateCount)
+	}
+
+	content := map[int]string{}
+	roles := map[int]int{}
+	finish := map[int]string{}
+	for _, e := range sw.events {
+		var chunk model.ChatStreamChunk
+		if err := json.Unmarshal([]byte(e), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", e, err)
+		}
+		for _, c := range chunk.Choices {
+			content[c.Index] += c.Delta.Content
+			if c.Delta.Role != "" {
+				roles[c.Index]++
+			}
+			if c.FinishReason != "" {
+				finish[c.Index] = c.FinishReason
+			}
+		}
+	}
+	if content[0] != "A1A2" || content[1] != "B1B2" {
+		t.Errorf("content by index = %v", content)
+	}
+	if roles[0] != 1 || roles[1] != 1 {
+		t.Errorf("expected one role delta per choice, got %v", roles)
+	}
+	if finish[0] != "stop" || finish[1] != "length" {
+		t.Errorf("finish reasons = %v", finish)
+	}
+}
+
+func TestGoogle_ChatStream_ToolCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{ "city": "Paris" }}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_time"}}]},"finishReason":"STOP"}]}`+"\n\n")
+	}))
+	defer srv.Close()
+
+	p := NewGoogle("google", srv.URL, "test-key", []string{"gemini-2.5-flash"})
+	sw := newTestSSEWriter()
+	if _, err := p.ChatStream(context.Background(), &model.ChatRequest{Model: "gemini-2.5-flash"}, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sw.events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(sw.events), sw.events)
+	}
+
+	var calls []model.ToolCallDelta
+	var chunk model.ChatStreamChunk
+	for _, e := range sw.events[1:] {
+		chunk = model.ChatStreamChunk{}
+		json.Unmarshal([]byte(e), &chunk)
+		calls = append(calls, chunk.Choices[0].Delta.ToolCalls...)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %+v", calls)
+	}
+	if c := calls[0]; c.Index != 0 || c.Type != "function" || c.ID == "" || c.Function.Name != "get_weather" || c.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("first call = %+v", c)
+	}
+	if c := calls[1]; c.Index != 1 || c.ID == calls[0].ID || c.Function.Name != "get_time" || c.Function.Arguments != "{}" {
+		t.Errorf("second call = %+v", c)
+	}
+	if chunk.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("expected finish_reason tool_calls, got %q", chunk.Choices[0].FinishReason)
+	}
+}
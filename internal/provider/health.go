@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// refreshJitter is how far, as a fraction of the interval, periodic
+// upstream refreshes vary at random, so replicas started together don't
+// keep probing providers in the same instant.
+const refreshJitter = 0.2
+
+// jittered returns interval varied at random by up to refreshJitter.
+func jittered(interval time.Duration) time.Duration {
+	return interval + time.Duration((rand.Float64()*2-1)*refreshJitter*float64(interval))
+}
+
+// runJittered calls fn about every interval, each wait jittered, until ctx
+// is done.
+func runJittered(ctx context.Context, interval time.Duration, fn func()) {
+	timer := time.NewTimer(jittered(interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			fn()
+			timer.Reset(jittered(interval))
+		}
+	}
+}
+
+// HealthStatus is the result of a provider's last health probe.
+type HealthStatus struct {
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// HealthProbe checks a provider's upstream by listing its models and keeps
+// the result, so readers see the last probe rather than causing one.
+type HealthProbe struct {
+	lister ModelLister
+	now    func() time.Time
+
+	mu     sync.Mutex
+	status HealthStatus
+}
+
+// NewHealthProbe creates a probe that lists models with lister. It has no
+// result until Check first runs.
+func NewHealthProbe(lister ModelLister) *HealthProbe {
+	return &HealthProbe{lister: lister, now: time.Now}
+}
+
+// Check probes the upstream now and returns the result, which Status
+// reports until the next check.
+func (h *HealthProbe) Check(ctx context.Context) HealthStatus {
+	start := h.now()
+	_, err := h.lister.ListModels(ctx)
+	elapsed := h.now().Sub(start)
+	s := HealthStatus{Healthy: err == nil, CheckedAt: start, LatencyMs: float64(elapsed) / float64(time.Millisecond)}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	h.mu.Lock()
+	h.status = s
+	h.mu.Unlock()
+	return s
+}
+
+// Status returns the result of the last check; CheckedAt is zero if there
+// was none yet.
+func (h *HealthProbe) Status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// Run checks about every interval, jittered, until ctx is done, each check
+// bounded by timeout. onCheck, if non-nil, is called with each result and
+// the one before it.
+func (h *HealthProbe) Run(ctx context.Context, interval, timeout time.Duration, onCheck func(prev, cur HealthStatus)) {
+	runJittered(ctx, interval, func() {
+		prev := h.Status()
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		cur := h.Check(cctx)
+		cancel()
+		if onCheck != nil {
+			onCheck(prev, cur)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// NoStoreHints name the upstream signals that a response must not be cached,
+// such as an x-no-cache header added by an internal gateway. Header is a
+// response header and Field a top-level field of the response body; either
+// marks the response when set to anything but an empty, false, no or 0
+// value. Empty names are not checked.
+type NoStoreHints struct {
+	Header string
+	Field  string
+}
+
+// marked reports whether header or the JSON body carry a no-store signal.
+func (h NoStoreHints) marked(header http.Header, body []byte) bool {
+	if h.Header != "" && truthy(header.Get(h.Header)) {
+		return true
+	}
+	if h.Field == "" || body == nil {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return false
+	}
+	raw, ok := fields[h.Field]
+	if !ok {
+		return false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return truthy(s)
+	}
+	return truthy(string(raw))
+}
+
+// decode decodes the JSON body of resp into v and reports whether the
+// response is marked as not cacheable. The body is only buffered when a
+// field has to be checked.
+func (h NoStoreHints) decode(resp *http.Response, v any) (bool, error) {
+	if h.Field == "" {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return false, fmt.Errorf("decoding response: %w", err)
+		}
+		return h.marked(resp.Header, nil), nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		return false, fmt.Errorf("decoding response: %w", err)
+	}
+	return h.marked(resp.Header, body), nil
+}
+
+func truthy(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "false", "no", "0", "null":
+		return false
+	}
+	return true
+}
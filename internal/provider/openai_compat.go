@@ -1,15 +1,17 @@
 package provider
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
@@ -22,6 +24,7 @@ var bufPool = sync.Pool{
 var (
 	dataPrefix = []byte("data: ")
 	doneMarker = []byte("[DONE]")
+	eventEnd   = []byte("\n\n")
 	usageKey   = []byte(`"usage"`)
 )
 
@@ -32,28 +35,75 @@ type OpenAICompat struct {
 	apiKey  string
 	models  []string
 	client  *http.Client
+
+	// Vendor profile overrides, set by NewFromPreset.
+	authHeader string
+	authScheme string
+	quirks     Quirks
+	// omitUsage stops streaming requests from asking for usage with
+	// stream_options, leaving its absence to be estimated. It is set by
+	// SetIncludeUsage(false), or once a stream sent without the field
+	// succeeds after the upstream rejected it (optionsRejected).
+	omitUsage       atomic.Bool
+	optionsRejected atomic.Bool
+
+	extras  RequestExtras
+	auth    AuthProvider
+	noStore NoStoreHints
+	quota   QuotaTracker
+
+	onUnknownFields func(fields []string)
 }
 
 // NewOpenAICompat creates a new OpenAI-compatible provider.
 func NewOpenAICompat(name, baseURL, apiKey string, models []string) *OpenAICompat {
-	transport := &http.Transport{
-		DisableCompression:  true,
-		MaxIdleConns:        1000,
-		MaxIdleConnsPerHost: 1000,
-		IdleConnTimeout:     90 * time.Second,
-		WriteBufferSize:     32 << 10,
-		ReadBufferSize:      32 << 10,
-		ForceAttemptHTTP2:   true,
-	}
 	return &OpenAICompat{
 		name:    name,
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		models:  models,
-		client:  &http.Client{Transport: transport},
+		client:  &http.Client{Transport: NewTransport(TransportConfig{})},
 	}
 }
 
+// SetTransport replaces the transport used for upstream calls.
+func (o *OpenAICompat) SetTransport(rt http.RoundTripper) { o.client.Transport = rt }
+
+// SetRequestExtras configures static headers and query parameters sent with
+// every upstream request.
+func (o *OpenAICompat) SetRequestExtras(e RequestExtras) { o.extras = e }
+
+// SetAuth authenticates every upstream request with auth, after the
+// provider's own credentials and the extras are set.
+func (o *OpenAICompat) SetAuth(auth AuthProvider) { o.auth = auth }
+
+// SetUnknownFieldsHook calls fn with the fields of each non-streaming
+// response that model.ChatResponse doesn't cover and qlite therefore drops.
+func (o *OpenAICompat) SetUnknownFieldsHook(fn func(fields []string)) { o.onUnknownFields = fn }
+
+// SetNoStoreHints marks non-streaming responses carrying any of h as not
+// cacheable.
+func (o *OpenAICompat) SetNoStoreHints(h NoStoreHints) { o.noStore = h }
+
+// SetIncludeUsage sets whether streaming requests send stream_options
+// include_usage, overriding the preset. Without it the upstream may report
+// no usage. Must be called before the provider is used.
+func (o *OpenAICompat) SetIncludeUsage(on bool) {
+	o.quirks.NoStreamOptions = false
+	o.omitUsage.Store(!on)
+	o.optionsRejected.Store(false)
+}
+
+// ReportsStreamUsage reports whether streams are expected to end with
+// usage: false while stream_options is not sent, unless the preset says
+// the upstream reports it anyway.
+func (o *OpenAICompat) ReportsStreamUsage() bool {
+	return !o.omitUsage.Load() && !o.optionsRejected.Load()
+}
+
+// Quota returns the rate limits last reported by the upstream.
+func (o *OpenAICompat) Quota() Quota { return o.quota.Quota() }
+
 func (o *OpenAICompat) Name() string    { return o.name }
 func (o *OpenAICompat) Models() []string { return o.models }
 
@@ -66,7 +116,7 @@ func (o *OpenAICompat) Chat(ctx context.Context, req *model.ChatRequest) (*model
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
-	if err := json.NewEncoder(buf).Encode(req); err != nil {
+	if err := json.NewEncoder(buf).Encode(o.prepare(req)); err != nil {
 		return nil, fmt.Errorf("marshaling request: %w", err)
 	}
 
@@ -74,22 +124,39 @@ func (o *OpenAICompat) Chat(ctx context.Context, req *model.ChatRequest) (*model
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	o.setHeaders(httpReq)
+	if err := o.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
+	o.quota.observe(resp.Header)
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newUpstreamError(resp)
 	}
 
 	var chatResp model.ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
+	if o.onUnknownFields != nil {
+		body, err := io.ReadAll(resp.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &chatResp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding response: %w", err)
+		}
+		if fields := model.UnknownFields(body, &chatResp); len(fields) > 0 {
+			o.onUnknownFields(fields)
+		}
+		chatResp.NoStore = o.noStore.marked(resp.Header, body)
+		return &chatResp, nil
+	}
+	chatResp.NoStore, err = o.noStore.decode(resp, &chatResp)
+	if err != nil {
+		return nil, err
 	}
 
 	return &chatResp, nil
@@ -99,12 +166,17 @@ func (o *OpenAICompat) Chat(ctx context.Context, req *model.ChatRequest) (*model
 func (o *OpenAICompat) ChatStream(ctx context.Context, req *model.ChatRequest, sw sse.Writer) (*model.Usage, error) {
 	// Enable streaming with usage.
 	req.Stream = true
-	req.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	req.StreamOptions = nil
+	retry := o.optionsRejected.Load()
+	withOptions := !o.omitUsage.Load() && !o.quirks.NoStreamOptions && !retry
+	if withOptions {
+		req.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
 
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
-	if err := json.NewEncoder(buf).Encode(req); err != nil {
+	if err := json.NewEncoder(buf).Encode(o.prepare(req)); err != nil {
 		return nil, fmt.Errorf("marshaling request: %w", err)
 	}
 
@@ -112,39 +184,107 @@ func (o *OpenAICompat) ChatStream(ctx context.Context, req *model.ChatRequest, s
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	o.setHeaders(httpReq)
+	if err := o.setHeaders(ctx, httpReq); err != nil {
+		return nil, err
+	}
 
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, fmt.Errorf("sending request: %w", transportError(err))
 	}
 	defer resp.Body.Close()
+	o.quota.observe(resp.Header)
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("upstream error (status %d): %s", resp.StatusCode, string(respBody))
+		ue := newUpstreamError(resp)
+		switch {
+		case withOptions && rejectsStreamOptions(ue):
+			o.optionsRejected.Store(true)
+			ue.Kind = ErrStreamOptionsRejected
+		case retry:
+			// Failing without the field too, so it wasn't the problem.
+			o.optionsRejected.Store(false)
+		}
+		return nil, ue
+	}
+	if retry && o.optionsRejected.CompareAndSwap(true, false) {
+		o.omitUsage.Store(true)
 	}
 
-	var usage *model.Usage
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if !bytes.HasPrefix(line, dataPrefix) {
-			continue
+	if rw, ok := sw.(sse.RawWriter); ok {
+		return o.relayRaw(resp.Body, rw, sw)
+	}
+	return o.relayEvents(newSSEReader(resp.Body), sw, nil)
+}
+
+// ErrStreamOptionsRejected is the kind of a streaming request's upstream
+// error when the upstream refused stream_options. The next stream is sent
+// without it, so the request can be retried once as is; if that succeeds
+// the provider stops sending it.
+var ErrStreamOptionsRejected = errors.New("upstream rejected stream_options")
+
+// rejectsStreamOptions reports whether e is the upstream refusing the
+// stream_options field: an OpenAI-style error whose param names it, or a
+// FastAPI validation error (vLLM and similar servers) located at it. Bodies
+// that merely mention the field, e.g. by echoing the request, don't count.
+func rejectsStreamOptions(e *UpstreamError) bool {
+	if e.Status != http.StatusBadRequest && e.Status != http.StatusUnprocessableEntity {
+		return false
+	}
+	var body struct {
+		Error struct {
+			Param   string `json:"param"`
+			Message string `json:"message"`
+		} `json:"error"`
+		Detail []struct {
+			Loc []any `json:"loc"`
+		} `json:"detail"`
+	}
+	if json.Unmarshal([]byte(e.Body), &body) != nil {
+		return false
+	}
+	if body.Error.Param == "stream_options" ||
+		strings.HasPrefix(body.Error.Message, "Unrecognized request argument supplied: stream_options") {
+		return true
+	}
+	for _, d := range body.Detail {
+		if len(d.Loc) > 0 && d.Loc[len(d.Loc)-1] == "stream_options" {
+			return true
+		}
+	}
+	return false
+}
+
+// relayEvents forwards each upstream event through sw.WriteEvent, starting
+// from usage already collected by relayRaw.
+func (o *OpenAICompat) relayEvents(events *sseReader, sw sse.Writer, usage *model.Usage) (*model.Usage, error) {
+	var compact bytes.Buffer
+	for {
+		ev, ok := events.Next()
+		if !ok {
+			break
 		}
-		data := line[len(dataPrefix):]
-		if bytes.Equal(data, doneMarker) {
+		data := ev.Data
+		if bytes.Equal(bytes.TrimSpace(data), doneMarker) {
 			if err := sw.Done(); err != nil {
 				return usage, fmt.Errorf("writing done: %w", err)
 			}
 			break
 		}
 
-		// Only parse chunks that contain a usage field (typically the final chunk).
-		if bytes.Contains(data, usageKey) {
-			var chunk model.ChatStreamChunk
-			if err := json.Unmarshal(data, &chunk); err == nil && chunk.Usage != nil {
-				usage = chunk.Usage
+		if u := o.chunkUsage(data); u != nil {
+			usage = u
+		}
+
+		// Multi-line events must be re-framed as a single data: line. A
+		// payload that isn't valid JSON still can't carry a raw newline, so
+		// its line breaks become spaces.
+		if bytes.IndexByte(data, '\n') >= 0 {
+			compact.Reset()
+			if err := json.Compact(&compact, data); err == nil {
+				data = compact.Bytes()
+			} else {
+				data = bytes.ReplaceAll(data, []byte{'\n'}, []byte{' '})
 			}
 		}
 
@@ -154,14 +294,148 @@ func (o *OpenAICompat) ChatStream(ctx context.Context, req *model.ChatRequest, s
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := events.Err(); err != nil {
 		return usage, fmt.Errorf("reading stream: %w", err)
 	}
 
 	return usage, nil
 }
 
-func (o *OpenAICompat) setHeaders(req *http.Request) {
+// relayRaw is the fast path for writers that accept pre-framed events: each
+// read from upstream is scanned for event boundaries and the complete events
+// are written as-is with one flush, instead of being split into lines and
+// re-framed one by one. It only handles events that are a single "data: "
+// line terminated by "\n\n"; at the first event that isn't (CRLF, multi-line
+// data, event:/id: fields, comments) it hands the rest of the stream to
+// relayEvents. [DONE] goes through sw.Done so writers can finish cleanly.
+func (o *OpenAICompat) relayRaw(body io.Reader, rw sse.RawWriter, sw sse.Writer) (*model.Usage, error) {
+	var usage *model.Usage
+	buf := make([]byte, 0, 32<<10)
+	for {
+		if len(buf) == cap(buf) {
+			if cap(buf) >= maxSSELine {
+				return usage, fmt.Errorf("reading stream: event exceeds %d bytes", maxSSELine)
+			}
+			buf = slices.Grow(buf, cap(buf))
+		}
+		n, readErr := body.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+
+		start := 0
+		for {
+			i := bytes.Index(buf[start:], eventEnd)
+			event := buf[start:]
+			if i >= 0 {
+				event = buf[start : start+i]
+			}
+			data, ok := bytes.CutPrefix(event, dataPrefix)
+			// CRLF framing never matches eventEnd, so check partial events
+			// too rather than buffering the whole stream.
+			if bytes.IndexByte(event, '\r') >= 0 || (i >= 0 && (!ok || bytes.IndexByte(event, '\n') >= 0)) {
+				if err := writeRaw(rw, buf[:start]); err != nil {
+					return usage, err
+				}
+				rest := io.MultiReader(bytes.NewReader(slices.Clone(buf[start:])), body)
+				return o.relayEvents(newSSEReader(rest), sw, usage)
+			}
+			if i < 0 {
+				break
+			}
+			if bytes.Equal(bytes.TrimSpace(data), doneMarker) {
+				if err := writeRaw(rw, buf[:start]); err != nil {
+					return usage, err
+				}
+				if err := sw.Done(); err != nil {
+					return usage, fmt.Errorf("writing done: %w", err)
+				}
+				return usage, nil
+			}
+			if u := o.chunkUsage(data); u != nil {
+				usage = u
+			}
+			start += i + len(eventEnd)
+		}
+		if err := writeRaw(rw, buf[:start]); err != nil {
+			return usage, err
+		}
+		buf = buf[:copy(buf, buf[start:])]
+
+		if readErr == io.EOF {
+			// A final event without its blank line is handled like the
+			// scanner path does.
+			if len(buf) > 0 {
+				return o.relayEvents(newSSEReader(bytes.NewReader(buf)), sw, usage)
+			}
+			return usage, nil
+		}
+		if readErr != nil {
+			return usage, fmt.Errorf("reading stream: %w", readErr)
+		}
+	}
+}
+
+func writeRaw(rw sse.RawWriter, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if err := rw.WriteRaw(p); err != nil {
+		return fmt.Errorf("writing event: %w", err)
+	}
+	return nil
+}
+
+// chunkUsage returns the usage carried by a stream chunk, or nil. Usage may
+// arrive on the final chunk, alongside content, or in a choice-less chunk
+// before [DONE]; callers keep the last one seen.
+func (o *OpenAICompat) chunkUsage(data []byte) *model.Usage {
+	if !bytes.Contains(data, usageKey) {
+		return nil
+	}
+	var chunk model.ChatStreamChunk
+	if err := json.Unmarshal(data, &chunk); err == nil && chunk.Usage != nil {
+		return chunk.Usage
+	}
+	if o.quirks.UsageField != "" {
+		return o.quirks.extensionUsage(data)
+	}
+	return nil
+}
+
+// withoutCacheControl returns req with Anthropic cache_control markers removed
+// from messages. The original request is not modified.
+func withoutCacheControl(req *model.ChatRequest) *model.ChatRequest {
+	for i, m := range req.Messages {
+		if m.CacheControl == nil {
+			continue
+		}
+		r := *req
+		r.Messages = make([]model.Message, len(req.Messages))
+		copy(r.Messages, req.Messages)
+		for j := i; j < len(r.Messages); j++ {
+			r.Messages[j].CacheControl = nil
+		}
+		return &r
+	}
+	return req
+}
+
+// prepare returns the request body to send upstream, with proxy-only fields
+// removed and vendor quirks applied.
+func (o *OpenAICompat) prepare(req *model.ChatRequest) *model.ChatRequest {
+	out := withoutCacheControl(req)
+	if o.quirks != (Quirks{}) {
+		out = o.quirks.applyQuirks(out)
+	}
+	return out
+}
+
+func (o *OpenAICompat) setHeaders(ctx context.Context, req *http.Request) error {
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	if o.authHeader != "" {
+		req.Header.Set(o.authHeader, o.authScheme+o.apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	o.extras.apply(req)
+	return authenticate(ctx, o.auth, req)
 }
@@ -3,9 +3,11 @@ package provider
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -134,6 +136,13 @@ func (w *testSSEWriter) Done() error {
 	return nil
 }
 
+// discardSSEWriter drops events, for benchmarks.
+type discardSSEWriter struct{}
+
+func (discardSSEWriter) SetHeader(key, value string)  {}
+func (discardSSEWriter) WriteEvent(data []byte) error { return nil }
+func (discardSSEWriter) Done() error                  { return nil }
+
 func TestOpenAICompat_ChatStream(t *testing.T) {
 	chunks := []string{
 		`{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
@@ -225,3 +234,317 @@ func TestRegistry_LookupAndRegister(t *testing.T) {
 
 // Ensure testSSEWriter implements sse.Writer.
 var _ sse.Writer = (*testSSEWriter)(nil)
+
+func TestOpenAICompat_ChatStreamQuirks(t *testing.T) {
+	// CRLF line endings, a multi-line data: event, usage attached to a content
+	// chunk, and a choice-less usage chunk before [DONE].
+	body := "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\r\n\r\n" +
+		"data: {\"id\":\"c\",\r\ndata: \"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}],\r\ndata: \"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\r\n\r\n" +
+		"event: ping\r\n: keepalive\r\n\r\n" +
+		"data:{\"id\":\"c\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\r\n\r\n" +
+		"data: [DONE]\r\n\r\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	provider := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	sw := newTestSSEWriter()
+	usage, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sw.events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(sw.events), sw.events)
+	}
+	if strings.Contains(sw.events[1], "\n") {
+		t.Errorf("expected multi-line event to be compacted, got %q", sw.events[1])
+	}
+	var chunk model.ChatStreamChunk
+	if err := json.Unmarshal([]byte(sw.events[1]), &chunk); err != nil {
+		t.Fatalf("forwarded event is not valid JSON: %v", err)
+	}
+	if chunk.Choices[0].Delta.Content != "Hi" {
+		t.Errorf("expected content Hi, got %q", chunk.Choices[0].Delta.Content)
+	}
+	if !sw.done {
+		t.Error("expected Done to be called")
+	}
+	if usage == nil || usage.CompletionTokens != 2 {
+		t.Errorf("expected last usage (2 completion tokens), got %+v", usage)
+	}
+}
+
+func TestOpenAICompat_StreamOptionsRejected(t *testing.T) {
+	var withOptions []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		_, ok := req["stream_options"]
+		withOptions = append(withOptions, ok)
+		if ok {
+			http.Error(w, `{"error":{"message":"Unrecognized request argument supplied: stream_options","param":"stream_options"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	provider := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	if !provider.ReportsStreamUsage() {
+		t.Fatal("expected usage to be requested by default")
+	}
+	_, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, newTestSSEWriter())
+	if !errors.Is(err, ErrStreamOptionsRejected) {
+		t.Fatalf("expected ErrStreamOptionsRejected, got %v", err)
+	}
+	if provider.ReportsStreamUsage() {
+		t.Error("expected usage not to be expected after the rejection")
+	}
+
+	sw := newTestSSEWriter()
+	usage, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(withOptions) != 2 || !withOptions[0] || withOptions[1] {
+		t.Errorf("expected stream_options only on the first request, got %v", withOptions)
+	}
+	if usage != nil || len(sw.events) != 1 {
+		t.Errorf("expected the stream relayed without usage, got %+v, events %q", usage, sw.events)
+	}
+	if _, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, newTestSSEWriter()); err != nil || withOptions[2] {
+		t.Errorf("expected stream_options left out after the retry succeeded, got %v, err %v", withOptions, err)
+	}
+
+	provider.SetIncludeUsage(true)
+	if !provider.ReportsStreamUsage() {
+		t.Error("expected SetIncludeUsage(true) to request usage again")
+	}
+}
+
+func TestOpenAICompat_StreamOptionsMentioned(t *testing.T) {
+	var withOptions []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		_, ok := req["stream_options"]
+		withOptions = append(withOptions, ok)
+		http.Error(w, `{"error":{"message":"invalid messages in {\"stream_options\":{\"include_usage\":true}}","param":"messages"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	provider := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	for range 2 {
+		_, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, newTestSSEWriter())
+		if err == nil || errors.Is(err, ErrStreamOptionsRejected) {
+			t.Fatalf("expected a plain upstream error, got %v", err)
+		}
+	}
+	if !provider.ReportsStreamUsage() || len(withOptions) != 2 || !withOptions[1] {
+		t.Errorf("expected stream_options kept, got %v", withOptions)
+	}
+}
+
+func TestRejectsStreamOptions(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   bool
+	}{
+		{400, `{"error":{"message":"bad","param":"stream_options"}}`, true},
+		{400, `{"error":{"message":"Unrecognized request argument supplied: stream_options"}}`, true},
+		{422, `{"detail":[{"type":"extra_forbidden","loc":["body","stream_options"],"msg":"Extra inputs are not permitted"}]}`, true},
+		{422, `{"detail":[{"loc":["body","messages",0],"msg":"bad","input":{"stream_options":{}}}]}`, false},
+		{400, `{"error":{"message":"stream_options must come with stream"}}`, false},
+		{400, `stream_options is not supported`, false},
+		{500, `{"error":{"param":"stream_options"}}`, false},
+	}
+	for _, tt := range tests {
+		if got := rejectsStreamOptions(&UpstreamError{Status: tt.status, Body: tt.body}); got != tt.want {
+			t.Errorf("rejectsStreamOptions(%d, %s) = %v, want %v", tt.status, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAICompat_RequestExtras(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("HTTP-Referer") != "https://example.com" {
+			t.Errorf("expected HTTP-Referer header, got %q", r.Header.Get("HTTP-Referer"))
+		}
+		if r.Header.Get("X-Title") != "qlite" {
+			t.Errorf("expected X-Title header, got %q", r.Header.Get("X-Title"))
+		}
+		if r.URL.Query().Get("api-version") != "2024-10-21" {
+			t.Errorf("expected api-version query, got %q", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	provider := NewOpenAICompat("openrouter", srv.URL, "test-key", []string{"gpt-4o"})
+	provider.SetRequestExtras(RequestExtras{
+		Headers: map[string]string{"HTTP-Referer": "https://example.com", "X-Title": "qlite"},
+		Query:   map[string]string{"api-version": "2024-10-21"},
+	})
+	if _, err := provider.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOpenAICompat_ChatStreamRaw(t *testing.T) {
+	chunks := []string{
+		`{"id":"c","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+		`{"id":"c","choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+		`{"id":"c","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+	}
+	var want strings.Builder
+	for _, c := range chunks {
+		fmt.Fprintf(&want, "data: %s\n\n", c)
+	}
+	want.WriteString("data: [DONE]\n\n")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"well formed", want.String()},
+		{"comment falls back", "data: " + chunks[0] + "\n\n: keep-alive\n\ndata: " + chunks[1] + "\n\ndata: " + chunks[2] + "\n\ndata: [DONE]\n\n"},
+		{"crlf falls back", strings.ReplaceAll(want.String(), "\n", "\r\n")},
+		{"missing final blank line", strings.TrimSuffix(want.String(), "data: [DONE]\n\n") + "data: [DONE]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				// Split writes at odd offsets so events straddle reads.
+				for b := []byte(tt.body); len(b) > 0; {
+					n := min(7, len(b))
+					w.Write(b[:n])
+					w.(http.Flusher).Flush()
+					b = b[n:]
+				}
+			}))
+			defer srv.Close()
+
+			rec := httptest.NewRecorder()
+			p := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+			usage, err := p.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, sse.NewWriter(rec))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := rec.Body.String(); got != want.String() {
+				t.Errorf("unexpected relay output:\n%q\nwant\n%q", got, want.String())
+			}
+			if usage == nil || usage.TotalTokens != 5 {
+				t.Errorf("expected usage from final chunk, got %+v", usage)
+			}
+		})
+	}
+}
+
+func TestOpenAICompat_UnknownFieldsHook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"x","service_tier":"default","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompat("test", srv.URL, "key", []string{"gpt-4o"})
+	var dropped []string
+	p.SetUnknownFieldsHook(func(fields []string) { dropped = fields })
+
+	resp, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Choices[0].Message.Content != "hi" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(dropped) != 1 || dropped[0] != "service_tier" {
+		t.Errorf("dropped = %v", dropped)
+	}
+}
+
+func TestOpenAICompat_NoStoreHints(t *testing.T) {
+	tests := []struct {
+		header, field string
+		want          bool
+	}{
+		{"", "false", false},
+		{"0", "null", false},
+		{"1", "false", true},
+		{"true", "false", true},
+		{"", "true", true},
+		{"", `"yes"`, true},
+		{"", `"false"`, false},
+	}
+	// The base URL of each provider is /<test index>.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.Split(r.URL.Path, "/")[1])
+		w.Header().Set("X-No-Cache", tests[i].header)
+		w.Write([]byte(`{"id":"x","gateway":{"a":1},"no_cache":` + tests[i].field + `,"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+
+	for _, hook := range []bool{false, true} {
+		for i, tt := range tests {
+			p := NewOpenAICompat("test", srv.URL+"/"+strconv.Itoa(i), "key", []string{"gpt-4o"})
+			p.SetNoStoreHints(NoStoreHints{Header: "x-no-cache", Field: "no_cache"})
+			if hook {
+				p.SetUnknownFieldsHook(func([]string) {})
+			}
+			resp, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.NoStore != tt.want {
+				t.Errorf("header=%q field=%s hook=%v: NoStore = %v, want %v", tt.header, tt.field, hook, resp.NoStore, tt.want)
+			}
+		}
+	}
+
+	// Without hints nothing is marked.
+	p := NewOpenAICompat("test", srv.URL+"/2", "key", []string{"gpt-4o"})
+	if resp, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err != nil || resp.NoStore {
+		t.Errorf("unexpected NoStore without hints: %+v, %v", resp, err)
+	}
+}
+
+func TestOpenAICompat_ChatStreamPrettyPrinted(t *testing.T) {
+	// A pretty-printed chunk split across data: lines, and a multi-line
+	// payload that isn't JSON.
+	body := "data: {\n" +
+		"data:   \"id\": \"c\",\n" +
+		"data:   \"choices\": [\n" +
+		"data:     {\"index\": 0, \"delta\": {\"content\": \"Hi\"}}\n" +
+		"data:   ]\n" +
+		"data: }\n\n" +
+		"data: not\ndata: json\n\n" +
+		"data: [DONE]\n\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	provider := NewOpenAICompat("test", srv.URL, "test-key", []string{"gpt-4o"})
+	sw := newTestSSEWriter()
+	if _, err := provider.ChatStream(context.Background(), &model.ChatRequest{Model: "gpt-4o"}, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sw.events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %q", len(sw.events), sw.events)
+	}
+	if want := `{"id":"c","choices":[{"index":0,"delta":{"content":"Hi"}}]}`; sw.events[0] != want {
+		t.Errorf("expected the chunk compacted to %s, got %q", want, sw.events[0])
+	}
+	if sw.events[1] != "not json" {
+		t.Errorf("expected line breaks in a non-JSON payload replaced, got %q", sw.events[1])
+	}
+}
@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Quirks describe vendor deviations from the OpenAI chat completions API
+// that the OpenAI-compatible provider needs to paper over.
+type Quirks struct {
+	// NoStreamOptions omits stream_options from streaming requests; the
+	// vendor rejects the field but reports usage on the final chunk anyway.
+	NoStreamOptions bool
+	// UsageField names a vendor extension object carrying stream usage
+	// (e.g. Groq's "x_groq": {"usage": {...}}).
+	UsageField string
+	// StopAsArray sends a single string stop sequence as a one-element array.
+	StopAsArray bool
+}
+
+// Preset pre-fills the configuration of a known OpenAI-compatible vendor.
+type Preset struct {
+	BaseURL    string
+	AuthHeader string // header carrying the API key
+	AuthScheme string // prefix placed before the key, e.g. "Bearer "
+	Quirks     Quirks
+}
+
+// Presets maps provider types to vendor profiles.
+var Presets = map[string]Preset{
+	"mistral": {
+		BaseURL:    "https://api.mistral.ai/v1",
+		AuthHeader: "Authorization",
+		AuthScheme: "Bearer ",
+		Quirks:     Quirks{NoStreamOptions: true},
+	},
+	"groq": {
+		BaseURL:    "https://api.groq.com/openai/v1",
+		AuthHeader: "Authorization",
+		AuthScheme: "Bearer ",
+		Quirks:     Quirks{UsageField: "x_groq"},
+	},
+	"together": {
+		BaseURL:    "https://api.together.xyz/v1",
+		AuthHeader: "Authorization",
+		AuthScheme: "Bearer ",
+		Quirks:     Quirks{StopAsArray: true},
+	},
+}
+
+// NewFromPreset creates an OpenAI-compatible provider from a named preset.
+// An empty baseURL uses the preset's default.
+func NewFromPreset(presetName, name, baseURL, apiKey string, models []string) (*OpenAICompat, error) {
+	preset, ok := Presets[presetName]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider preset %q", presetName)
+	}
+	if baseURL == "" {
+		baseURL = preset.BaseURL
+	}
+	o := NewOpenAICompat(name, baseURL, apiKey, models)
+	o.authHeader = preset.AuthHeader
+	o.authScheme = preset.AuthScheme
+	o.quirks = preset.Quirks
+	return o, nil
+}
+
+// applyQuirks returns req adjusted for the vendor. The original request is
+// not modified.
+func (q Quirks) applyQuirks(req *model.ChatRequest) *model.ChatRequest {
+	r := *req
+	if q.NoStreamOptions {
+		r.StreamOptions = nil
+	}
+	if q.StopAsArray && len(r.Stop) > 0 && r.Stop[0] == '"' {
+		var s string
+		if err := json.Unmarshal(r.Stop, &s); err == nil {
+			r.Stop, _ = json.Marshal([]string{s})
+		}
+	}
+	return &r
+}
+
+// extensionUsage extracts usage from a vendor extension object in a stream
+// chunk, e.g. {"x_groq": {"usage": {...}}}.
+func (q Quirks) extensionUsage(data []byte) *model.Usage {
+	var chunk map[string]json.RawMessage
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil
+	}
+	ext, ok := chunk[q.UsageField]
+	if !ok {
+		return nil
+	}
+	var wrapper struct {
+		Usage *model.Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(ext, &wrapper); err != nil {
+		return nil
+	}
+	return wrapper.Usage
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestPreset_GroqStreamUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gsk-test" {
+			t.Errorf("unexpected auth header %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}],\"x_groq\":{\"id\":\"req_1\",\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":1,\"total_tokens\":5}}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	p, err := NewFromPreset("groq", "groq", srv.URL, "gsk-test", []string{"llama-3.1-8b-instant"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	usage, err := p.ChatStream(context.Background(), &model.ChatRequest{Model: "llama-3.1-8b-instant"}, newTestSSEWriter())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage == nil || usage.TotalTokens != 5 {
+		t.Errorf("expected usage from x_groq (5 total tokens), got %+v", usage)
+	}
+}
+
+func TestPreset_RequestQuirks(t *testing.T) {
+	var body map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = nil
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	req := &model.ChatRequest{Model: "m", Stop: json.RawMessage(`"END"`)}
+
+	together, _ := NewFromPreset("together", "together", srv.URL, "k", []string{"m"})
+	if _, err := together.ChatStream(context.Background(), req, newTestSSEWriter()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body["stop"]) != `["END"]` {
+		t.Errorf("expected stop as array, got %s", body["stop"])
+	}
+	if string(req.Stop) != `"END"` {
+		t.Errorf("expected caller request to be unchanged, got %s", req.Stop)
+	}
+
+	mistral, _ := NewFromPreset("mistral", "mistral", srv.URL, "k", []string{"m"})
+	if _, err := mistral.ChatStream(context.Background(), req, newTestSSEWriter()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := body["stream_options"]; ok {
+		t.Error("expected stream_options to be omitted for mistral")
+	}
+}
+
+func TestPreset_DefaultBaseURLAndUnknown(t *testing.T) {
+	p, err := NewFromPreset("mistral", "mistral", "", "k", []string{"mistral-small-latest"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.baseURL != "https://api.mistral.ai/v1" {
+		t.Errorf("expected preset base URL, got %s", p.baseURL)
+	}
+	if _, err := NewFromPreset("nope", "x", "", "k", nil); err == nil {
+		t.Error("expected error for unknown preset")
+	}
+}
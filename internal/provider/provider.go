@@ -3,6 +3,8 @@ package provider
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"sync/atomic"
 
@@ -23,17 +25,31 @@ type Provider interface {
 	ChatStream(ctx context.Context, req *model.ChatRequest, sw sse.Writer) (*model.Usage, error)
 }
 
-// Registry maps model names to providers.
+// StreamUsageReporter is implemented by providers whose streams may end
+// without usage; ReportsStreamUsage is false while usage isn't expected.
+type StreamUsageReporter interface {
+	ReportsStreamUsage() bool
+}
+
+// Registry maps model names to providers. When several providers serve a
+// model, Lookup returns the first one registered and Candidates returns all.
 type Registry struct {
-	mu        sync.RWMutex
-	providers map[string]Provider
-	frozen    atomic.Pointer[map[string]Provider]
+	mu         sync.RWMutex
+	providers  map[string]Provider
+	candidates map[string][]Provider
+	frozen     atomic.Pointer[registrySnapshot]
+}
+
+type registrySnapshot struct {
+	providers  map[string]Provider
+	candidates map[string][]Provider
 }
 
 // NewRegistry creates an empty provider registry.
 func NewRegistry() *Registry {
 	return &Registry{
-		providers: make(map[string]Provider),
+		providers:  make(map[string]Provider),
+		candidates: make(map[string][]Provider),
 	}
 }
 
@@ -42,26 +58,61 @@ func (r *Registry) Register(p Provider) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for _, m := range p.Models() {
-		r.providers[m] = p
+		if _, ok := r.providers[m]; !ok {
+			r.providers[m] = p
+		}
+		r.candidates[m] = append(r.candidates[m], p)
+	}
+}
+
+// Add registers p for further models, e.g. ones discovered upstream, and
+// returns those p didn't serve yet. Unlike Register it may be called after
+// Freeze. A model another provider already serves keeps that provider as
+// its Lookup result; p becomes an additional candidate.
+func (r *Registry) Add(p Provider, models []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var added []string
+	for _, m := range models {
+		if slices.Contains(r.candidates[m], p) {
+			continue
+		}
+		if _, ok := r.providers[m]; !ok {
+			r.providers[m] = p
+		}
+		r.candidates[m] = append(r.candidates[m], p)
+		added = append(added, m)
+	}
+	if len(added) > 0 && r.frozen.Load() != nil {
+		r.freeze()
 	}
+	return added
 }
 
 // Freeze creates an immutable snapshot for lock-free reads.
 // Call after all providers are registered.
 func (r *Registry) Freeze() {
 	r.mu.RLock()
-	snapshot := make(map[string]Provider, len(r.providers))
-	for k, v := range r.providers {
-		snapshot[k] = v
+	defer r.mu.RUnlock()
+	r.freeze()
+}
+
+// freeze stores a snapshot of the registry. r.mu must be held.
+func (r *Registry) freeze() {
+	snapshot := &registrySnapshot{
+		providers:  maps.Clone(r.providers),
+		candidates: make(map[string][]Provider, len(r.candidates)),
+	}
+	for k, v := range r.candidates {
+		snapshot.candidates[k] = slices.Clone(v)
 	}
-	r.mu.RUnlock()
-	r.frozen.Store(&snapshot)
+	r.frozen.Store(snapshot)
 }
 
 // Lookup returns the provider for a given model name.
 func (r *Registry) Lookup(model string) (Provider, error) {
-	if m := r.frozen.Load(); m != nil {
-		p, ok := (*m)[model]
+	if s := r.frozen.Load(); s != nil {
+		p, ok := s.providers[model]
 		if !ok {
 			return nil, fmt.Errorf("no provider registered for model %q", model)
 		}
@@ -75,3 +126,14 @@ func (r *Registry) Lookup(model string) (Provider, error) {
 	}
 	return p, nil
 }
+
+// Candidates returns every provider serving model, in registration order.
+// The slice must not be modified.
+func (r *Registry) Candidates(model string) []Provider {
+	if s := r.frozen.Load(); s != nil {
+		return s.candidates[model]
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.candidates[model])
+}
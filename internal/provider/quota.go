@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Quota is a provider's upstream rate-limit state as last reported in
+// response headers. Counts the upstream didn't report are -1; a zero reset
+// time means the reset wasn't reported.
+type Quota struct {
+	LimitRequests     int64     `json:"limit_requests"`
+	RemainingRequests int64     `json:"remaining_requests"`
+	ResetRequests     time.Time `json:"reset_requests,omitzero"`
+	LimitTokens       int64     `json:"limit_tokens"`
+	RemainingTokens   int64     `json:"remaining_tokens"`
+	ResetTokens       time.Time `json:"reset_tokens,omitzero"`
+	Updated           time.Time `json:"updated"`
+}
+
+// quotaWindow is how long an exhausted limit without a reported reset is
+// assumed to last. OpenAI and Anthropic limits are per minute.
+const quotaWindow = time.Minute
+
+// Exhausted reports whether the requests or tokens limit had nothing left
+// and has not been reset by now.
+func (q Quota) Exhausted(now time.Time) bool {
+	return (q.RemainingRequests == 0 && q.active(q.RemainingRequests, q.ResetRequests, now)) ||
+		(q.RemainingTokens == 0 && q.active(q.RemainingTokens, q.ResetTokens, now))
+}
+
+// Remaining returns the smallest remaining share of a limit that has not
+// been reset by now, between 0 and 1. It is 1 when nothing applies.
+func (q Quota) Remaining(now time.Time) float64 {
+	share := 1.0
+	for _, l := range []struct {
+		limit, remaining int64
+		reset            time.Time
+	}{
+		{q.LimitRequests, q.RemainingRequests, q.ResetRequests},
+		{q.LimitTokens, q.RemainingTokens, q.ResetTokens},
+	} {
+		if l.limit <= 0 || !q.active(l.remaining, l.reset, now) {
+			continue
+		}
+		share = min(share, float64(l.remaining)/float64(l.limit))
+	}
+	return max(share, 0)
+}
+
+// active reports whether a reported remaining count still holds at now.
+func (q Quota) active(remaining int64, reset, now time.Time) bool {
+	if remaining < 0 || q.Updated.IsZero() {
+		return false
+	}
+	if reset.IsZero() {
+		reset = q.Updated.Add(quotaWindow)
+	}
+	return now.Before(reset)
+}
+
+// quotaHeaders name the rate-limit headers of one upstream dialect. Resets
+// are either a duration from now (OpenAI: "6m0s") or a timestamp
+// (Anthropic: RFC 3339).
+var quotaHeaders = []struct {
+	limitRequests, remainingRequests, resetRequests string
+	limitTokens, remainingTokens, resetTokens       string
+}{
+	{
+		"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests",
+		"x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens",
+	},
+	{
+		"anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset",
+		"anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset",
+	},
+}
+
+// QuotaTracker keeps the latest Quota reported by a provider's upstream.
+// The zero value is ready to use.
+type QuotaTracker struct {
+	mu sync.Mutex
+	q  Quota
+}
+
+// observe records the rate-limit headers of an upstream response, including
+// error responses. Responses without any are ignored.
+func (t *QuotaTracker) observe(h http.Header) {
+	now := time.Now()
+	for _, names := range quotaHeaders {
+		if h.Get(names.remainingRequests) == "" && h.Get(names.remainingTokens) == "" {
+			continue
+		}
+		q := Quota{
+			LimitRequests:     headerCount(h.Get(names.limitRequests)),
+			RemainingRequests: headerCount(h.Get(names.remainingRequests)),
+			ResetRequests:     headerReset(h.Get(names.resetRequests), now),
+			LimitTokens:       headerCount(h.Get(names.limitTokens)),
+			RemainingTokens:   headerCount(h.Get(names.remainingTokens)),
+			ResetTokens:       headerReset(h.Get(names.resetTokens), now),
+			Updated:           now,
+		}
+		t.mu.Lock()
+		t.q = q
+		t.mu.Unlock()
+		return
+	}
+}
+
+// Quota returns the latest reported quota. Its Updated time is zero if the
+// upstream never reported one.
+func (t *QuotaTracker) Quota() Quota {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.q.Updated.IsZero() {
+		return Quota{LimitRequests: -1, RemainingRequests: -1, LimitTokens: -1, RemainingTokens: -1}
+	}
+	return t.q
+}
+
+func headerCount(v string) int64 {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+func headerReset(v string, now time.Time) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(d)
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return now.Add(time.Duration(secs * float64(time.Second)))
+	}
+	return time.Time{}
+}
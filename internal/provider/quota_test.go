@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestQuota_OpenAIHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-limit-requests", "500")
+		w.Header().Set("x-ratelimit-remaining-requests", "0")
+		w.Header().Set("x-ratelimit-reset-requests", "6m0s")
+		w.Header().Set("x-ratelimit-limit-tokens", "30000")
+		w.Header().Set("x-ratelimit-remaining-tokens", "29000")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"slow down"}}`))
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompat("test", srv.URL, "k", []string{"gpt-4o"})
+	if q := p.Quota(); !q.Updated.IsZero() || q.RemainingRequests != -1 {
+		t.Fatalf("expected an unreported quota, got %+v", q)
+	}
+	start := time.Now()
+	p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"})
+
+	q := p.Quota()
+	if q.LimitRequests != 500 || q.RemainingRequests != 0 || q.LimitTokens != 30000 || q.RemainingTokens != 29000 {
+		t.Errorf("unexpected counts %+v", q)
+	}
+	if d := q.ResetRequests.Sub(start); d < 6*time.Minute || d > 6*time.Minute+time.Second {
+		t.Errorf("expected requests reset in 6m, got %v", d)
+	}
+	if !q.Exhausted(start) || q.Remaining(start) != 0 {
+		t.Errorf("expected exhausted quota, got %+v", q)
+	}
+	if q.Exhausted(start.Add(7 * time.Minute)) {
+		t.Error("expected the quota to be available after its reset")
+	}
+}
+
+func TestQuota_AnthropicHeaders(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second).UTC().Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("anthropic-ratelimit-requests-limit", "50")
+		w.Header().Set("anthropic-ratelimit-requests-remaining", "40")
+		w.Header().Set("anthropic-ratelimit-tokens-limit", "1000")
+		w.Header().Set("anthropic-ratelimit-tokens-remaining", "100")
+		w.Header().Set("anthropic-ratelimit-tokens-reset", reset.Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"type":"message","content":[{"type":"text","text":"hi"}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("test", srv.URL, "k", []string{"claude"})
+	if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "claude"}); err != nil {
+		t.Fatal(err)
+	}
+	q := p.Quota()
+	if !q.ResetTokens.Equal(reset) || !q.ResetRequests.IsZero() {
+		t.Errorf("unexpected resets %+v", q)
+	}
+	now := time.Now()
+	if q.Exhausted(now) {
+		t.Error("expected quota left")
+	}
+	if got := q.Remaining(now); got != 0.1 {
+		t.Errorf("expected the smaller remaining share 0.1, got %v", got)
+	}
+	// Without a reported reset the requests limit lapses after a minute.
+	if got := q.Remaining(q.Updated.Add(2 * time.Minute)); got != 1 {
+		t.Errorf("expected lapsed limits to count as full, got %v", got)
+	}
+}
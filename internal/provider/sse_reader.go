@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// maxSSELine bounds a single upstream SSE line; large tool-call or usage
+// chunks can exceed bufio.Scanner's 64 KB default.
+const maxSSELine = 1 << 20
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// sseEvent is one parsed upstream event. Data and Event are only valid until
+// the next call to sseReader.Next.
+type sseEvent struct {
+	Event []byte // event: field; empty means the default "message" type
+	Data  []byte // data: lines joined with '\n'
+	ID    string // last id: seen, which persists across events
+}
+
+// sseReader parses an upstream text/event-stream as the SSE spec describes:
+// lines end in LF, CRLF or a lone CR; "field: value" lines set event, data,
+// id or retry (one leading space of the value is dropped, and a line without
+// a colon is a field with an empty value); lines starting with ':' are
+// comments; a blank line dispatches the event. Multiple data: lines in one
+// event are joined with '\n'. Events without data are skipped, as browsers
+// do. Unlike the spec, a final event missing its blank line is still
+// returned, since some upstreams close the stream right after it.
+type sseReader struct {
+	scanner *bufio.Scanner
+	started bool
+	ev      sseEvent
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxSSELine)
+	scanner.Split(scanSSELines)
+	return &sseReader{scanner: scanner}
+}
+
+// Next returns the next event that carries data. Returns false at end of
+// stream.
+func (r *sseReader) Next() (sseEvent, bool) {
+	r.ev.Event = r.ev.Event[:0]
+	r.ev.Data = r.ev.Data[:0]
+	hasData := false
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if !r.started {
+			r.started = true
+			line = bytes.TrimPrefix(line, utf8BOM)
+		}
+		if len(line) == 0 {
+			if hasData {
+				return r.ev, true
+			}
+			r.ev.Event = r.ev.Event[:0]
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value, _ := bytes.Cut(line, []byte{':'})
+		if len(value) > 0 && value[0] == ' ' {
+			value = value[1:]
+		}
+		switch string(field) {
+		case "event":
+			r.ev.Event = append(r.ev.Event[:0], value...)
+		case "data":
+			if hasData {
+				r.ev.Data = append(r.ev.Data, '\n')
+			}
+			r.ev.Data = append(r.ev.Data, value...)
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				r.ev.ID = string(value)
+			}
+		}
+	}
+	// Stream ended without a trailing blank line — flush what we have.
+	if hasData {
+		return r.ev, true
+	}
+	return sseEvent{}, false
+}
+
+// Err returns the first non-EOF read error.
+func (r *sseReader) Err() error {
+	return r.scanner.Err()
+}
+
+// scanSSELines is a bufio.SplitFunc for SSE lines, which may end in LF, CRLF
+// or a lone CR.
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// CR: need the next byte to tell CRLF from a lone CR.
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
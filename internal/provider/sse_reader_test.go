@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSSEReader(t *testing.T) {
+	type event struct{ event, data, id string }
+	tests := []struct {
+		name  string
+		input string
+		want  []event
+	}{
+		{
+			name:  "single data lines",
+			input: "data: a\n\ndata: b\n\n",
+			want:  []event{{data: "a"}, {data: "b"}},
+		},
+		{
+			name:  "multi-line data joined",
+			input: "data: {\"a\":\ndata:  1}\n\n",
+			want:  []event{{data: "{\"a\":\n 1}"}},
+		},
+		{
+			name:  "event and id fields",
+			input: "event: message_start\nid: 7\ndata: x\n\nevent:ping\ndata:y\n\ndata: z\n\n",
+			want:  []event{{"message_start", "x", "7"}, {"ping", "y", "7"}, {"", "z", "7"}},
+		},
+		{
+			name:  "comments and unknown fields ignored",
+			input: ": keep-alive\nretry: 1000\nfoo: bar\ndata: x\n\n",
+			want:  []event{{data: "x"}},
+		},
+		{
+			name:  "CRLF and lone CR line endings",
+			input: "data: a\r\n\r\ndata: b\r\rdata: c\r\ndata: d\n\n",
+			want:  []event{{data: "a"}, {data: "b"}, {data: "c\nd"}},
+		},
+		{
+			name:  "event without data is skipped and type reset",
+			input: "event: ping\n\ndata: x\n\n",
+			want:  []event{{data: "x"}},
+		},
+		{
+			name:  "field without colon and empty data",
+			input: "data\ndata\n\n",
+			want:  []event{{data: "\n"}},
+		},
+		{
+			name:  "BOM and missing final blank line",
+			input: "\xEF\xBB\xBFdata: a\n\ndata: b",
+			want:  []event{{data: "a"}, {data: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One byte per read so CR/LF pairs straddle reads.
+			r := newSSEReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+			var got []event
+			for {
+				ev, ok := r.Next()
+				if !ok {
+					break
+				}
+				got = append(got, event{string(ev.Event), string(ev.Data), ev.ID})
+			}
+			if err := r.Err(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d events, got %d: %q", len(tt.want), len(got), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d: expected %+v, got %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
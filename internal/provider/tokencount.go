@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// TokenCounter is implemented by providers whose upstream counts a
+// request's input tokens without running it.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, req *model.ChatRequest) (int, error)
+}
+
+// anthropicCountRequest is the body of POST /messages/count_tokens: a
+// Messages API request without the generation parameters.
+type anthropicCountRequest struct {
+	Model    string         `json:"model"`
+	Messages []anthropicMsg `json:"messages"`
+	System   any            `json:"system,omitempty"`
+}
+
+// CountTokens returns the input tokens Anthropic counts for req at POST
+// /messages/count_tokens. The call is free but rate limited like others.
+func (a *Anthropic) CountTokens(ctx context.Context, req *model.ChatRequest) (int, error) {
+	ar := a.convertRequest(req)
+	body, err := json.Marshal(anthropicCountRequest{Model: ar.Model, Messages: ar.Messages, System: ar.System})
+	if err != nil {
+		return 0, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/messages/count_tokens", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	if err := a.setHeaders(ctx, httpReq); err != nil {
+		return 0, err
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("sending request: %w", transportError(err))
+	}
+	defer resp.Body.Close()
+	a.quota.observe(resp.Header)
+	if resp.StatusCode != http.StatusOK {
+		return 0, newUpstreamError(resp)
+	}
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return 0, fmt.Errorf("decoding token count: %w", err)
+	}
+	return count.InputTokens, nil
+}
@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestAnthropic_CountTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages/count_tokens" || r.Header.Get("x-api-key") != "k" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["system"] != "Be brief." || body["max_tokens"] != nil || len(body["messages"].([]any)) != 1 {
+			t.Errorf("unexpected body %v", body)
+		}
+		w.Write([]byte(`{"input_tokens":17}`))
+	}))
+	defer srv.Close()
+
+	p := NewAnthropic("test", srv.URL, "k", []string{"claude"})
+	n, err := p.CountTokens(context.Background(), &model.ChatRequest{
+		Model:    "claude",
+		Messages: []model.Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hi"}},
+	})
+	if err != nil || n != 17 {
+		t.Fatalf("expected 17 tokens, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,44 @@
+package provider
+
+import "github.com/eduardmaghakyan/qlite/internal/model"
+
+// toolCallEncoder turns tool calls streamed in a provider's own shape into
+// OpenAI tool_calls deltas, as they arrive rather than once complete, so
+// clients that stream tool arguments see them incrementally. Calls are
+// keyed by the upstream's index for them (an Anthropic content block, a
+// Gemini part) and numbered from 0 in the order they start.
+type toolCallEncoder struct {
+	indexes map[int]int
+	delta   [1]model.ToolCallDelta
+}
+
+// start returns the delta opening a call with the given ID and function
+// name. Arguments may already be complete, as Gemini sends them.
+func (e *toolCallEncoder) start(key int, id, name, arguments string) []model.ToolCallDelta {
+	if e.indexes == nil {
+		e.indexes = make(map[int]int)
+	}
+	index := len(e.indexes)
+	e.indexes[key] = index
+	e.delta[0] = model.ToolCallDelta{
+		Index:    index,
+		ID:       id,
+		Type:     "function",
+		Function: model.FunctionCallDelta{Name: name, Arguments: arguments},
+	}
+	return e.delta[:]
+}
+
+// arguments returns the delta appending fragment to the arguments of the
+// call started under key, or nil if no call was.
+func (e *toolCallEncoder) arguments(key int, fragment string) []model.ToolCallDelta {
+	index, ok := e.indexes[key]
+	if !ok {
+		return nil
+	}
+	e.delta[0] = model.ToolCallDelta{Index: index, Function: model.FunctionCallDelta{Arguments: fragment}}
+	return e.delta[:]
+}
+
+// calls returns how many calls were started.
+func (e *toolCallEncoder) calls() int { return len(e.indexes) }
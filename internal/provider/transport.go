@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportConfig tunes the HTTP transport used for upstream calls. Zero
+// fields keep the defaults every provider is built with.
+type TransportConfig struct {
+	MaxIdleConns          int           // default 1000
+	MaxIdleConnsPerHost   int           // default 1000
+	MaxConnsPerHost       int           // default unlimited
+	IdleConnTimeout       time.Duration // default 90s
+	DialTimeout           time.Duration // default none
+	TLSHandshakeTimeout   time.Duration // default none
+	ResponseHeaderTimeout time.Duration // default none; non-streaming responses only send headers when complete
+	WriteBufferSize       int           // default 32KiB
+	ReadBufferSize        int           // default 32KiB
+	DisableHTTP2          bool
+}
+
+// NewTransport builds an upstream transport from c.
+func NewTransport(c TransportConfig) *http.Transport {
+	t := &http.Transport{
+		DisableCompression:    true,
+		MaxIdleConns:          orDefault(c.MaxIdleConns, 1000),
+		MaxIdleConnsPerHost:   orDefault(c.MaxIdleConnsPerHost, 1000),
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       orDefault(c.IdleConnTimeout, 90*time.Second),
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		WriteBufferSize:       orDefault(c.WriteBufferSize, 32<<10),
+		ReadBufferSize:        orDefault(c.ReadBufferSize, 32<<10),
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+	}
+	if c.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: c.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if c.DisableHTTP2 {
+		// A non-nil empty map turns off the transport's built-in HTTP/2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
+
+// PoolStats reports connection pool utilization for a PooledTransport.
+type PoolStats struct {
+	Requests    int64 `json:"requests"`
+	InFlight    int64 `json:"in_flight"` // waiting for response headers
+	NewConns    int64 `json:"new_conns"`
+	ReusedConns int64 `json:"reused_conns"`
+	OpenConns   int64 `json:"open_conns"`
+}
+
+// PooledTransport is an upstream transport that counts connection use. One
+// can be shared by several providers; each provider still sets its own auth
+// headers per request.
+type PooledTransport struct {
+	*http.Transport
+
+	requests, inFlight, newConns, reused, open atomic.Int64
+}
+
+// NewPooledTransport builds an instrumented transport from c.
+func NewPooledTransport(c TransportConfig) *PooledTransport {
+	p := &PooledTransport{Transport: NewTransport(c)}
+	dial := p.Transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	p.Transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		p.open.Add(1)
+		return &countedConn{Conn: conn, open: &p.open}, nil
+	}
+	return p
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *PooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.requests.Add(1)
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				p.reused.Add(1)
+			} else {
+				p.newConns.Add(1)
+			}
+		},
+	}
+	return p.Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// Stats returns a snapshot of the pool counters.
+func (p *PooledTransport) Stats() PoolStats {
+	return PoolStats{
+		Requests:    p.requests.Load(),
+		InFlight:    p.inFlight.Load(),
+		NewConns:    p.newConns.Load(),
+		ReusedConns: p.reused.Load(),
+		OpenConns:   p.open.Load(),
+	}
+}
+
+// countedConn decrements the open connection count once when closed.
+type countedConn struct {
+	net.Conn
+	open *atomic.Int64
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestNewTransport(t *testing.T) {
+	def := NewTransport(TransportConfig{})
+	if def.MaxIdleConnsPerHost != 1000 || def.IdleConnTimeout != 90*time.Second || def.WriteBufferSize != 32<<10 {
+		t.Errorf("unexpected defaults: idle/host=%d idle timeout=%v write buf=%d",
+			def.MaxIdleConnsPerHost, def.IdleConnTimeout, def.WriteBufferSize)
+	}
+	if !def.ForceAttemptHTTP2 || def.TLSNextProto != nil || def.DialContext != nil {
+		t.Error("expected HTTP/2 on and the default dialer")
+	}
+
+	tuned := NewTransport(TransportConfig{
+		MaxIdleConnsPerHost:   50,
+		MaxConnsPerHost:       200,
+		DialTimeout:           2 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		ReadBufferSize:        4 << 10,
+		DisableHTTP2:          true,
+	})
+	if tuned.MaxIdleConnsPerHost != 50 || tuned.MaxConnsPerHost != 200 || tuned.ReadBufferSize != 4<<10 {
+		t.Errorf("overrides not applied: %+v", tuned)
+	}
+	if tuned.MaxIdleConns != 1000 {
+		t.Errorf("expected unset MaxIdleConns to keep default, got %d", tuned.MaxIdleConns)
+	}
+	if tuned.ResponseHeaderTimeout != time.Minute || tuned.DialContext == nil {
+		t.Error("expected timeouts to be applied")
+	}
+	if tuned.ForceAttemptHTTP2 || tuned.TLSNextProto == nil {
+		t.Error("expected HTTP/2 to be disabled")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestOpenAICompat_SetTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	calls := 0
+	base := NewTransport(TransportConfig{})
+	defer base.CloseIdleConnections()
+	p := NewOpenAICompat("openai", srv.URL, "test-key", []string{"gpt-4o"})
+	p.SetTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return base.RoundTrip(r)
+	}))
+	if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the configured transport to be used once, got %d", calls)
+	}
+}
+
+func TestPooledTransport_SharedAcrossProviders(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	shared := NewPooledTransport(TransportConfig{})
+	defer shared.CloseIdleConnections()
+	a := NewOpenAICompat("a", srv.URL, "key-a", []string{"gpt-4o"})
+	b := NewOpenAICompat("b", srv.URL, "key-b", []string{"gpt-4o"})
+	a.SetTransport(shared)
+	b.SetTransport(shared)
+
+	for _, p := range []*OpenAICompat{a, b} {
+		if _, err := p.Chat(context.Background(), &model.ChatRequest{Model: "gpt-4o"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(auth) != 2 || auth[0] != "Bearer key-a" || auth[1] != "Bearer key-b" {
+		t.Errorf("expected per-provider auth, got %v", auth)
+	}
+	want := PoolStats{Requests: 2, NewConns: 1, ReusedConns: 1, OpenConns: 1}
+	if got := shared.Stats(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	shared.CloseIdleConnections()
+	if got := shared.Stats().OpenConns; got != 0 {
+		t.Errorf("expected no open conns after close, got %d", got)
+	}
+}
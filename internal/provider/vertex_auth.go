@@ -0,0 +1,249 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleTokenURL     = "https://oauth2.googleapis.com/token"
+	googleMetadataURL  = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	// tokenExpiryMargin refreshes tokens slightly early so in-flight requests
+	// never carry an expired bearer.
+	tokenExpiryMargin = time.Minute
+	// defaultTokenLifetime is assumed when a token response has no
+	// expires_in, which RFC 6749 makes optional.
+	defaultTokenLifetime = 5 * time.Minute
+)
+
+// tokenExpiry returns when a token issued now with a lifetime of expiresIn
+// seconds should be refreshed: tokenExpiryMargin early, but never more than
+// half its lifetime, so short-lived tokens are still reused.
+func tokenExpiry(now time.Time, expiresIn int) time.Time {
+	lifetime := time.Duration(expiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	return now.Add(lifetime - min(tokenExpiryMargin, lifetime/2))
+}
+
+// googleCredentials is the subset of a Google credentials JSON file we use.
+// Supports service account keys and gcloud "authorized_user" ADC files.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleTokenSource fetches and caches OAuth2 access tokens for Google Cloud
+// using, in order: an explicit credentials file, GOOGLE_APPLICATION_CREDENTIALS,
+// the gcloud ADC file, or the GCE/GKE metadata server.
+type googleTokenSource struct {
+	creds  *googleCredentials // nil means metadata server
+	key    *rsa.PrivateKey
+	client *http.Client
+
+	// metadataURL is overridable for tests.
+	metadataURL string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newGoogleTokenSource resolves Application Default Credentials. credentialsFile
+// may be empty to use the standard ADC lookup.
+func newGoogleTokenSource(credentialsFile string, client *http.Client) (*googleTokenSource, error) {
+	ts := &googleTokenSource{client: client, metadataURL: googleMetadataURL}
+
+	path := credentialsFile
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			adc := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(adc); err == nil {
+				path = adc
+			}
+		}
+	}
+	if path == "" {
+		return ts, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading google credentials: %w", err)
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("decoding google credentials: %w", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+
+	switch creds.Type {
+	case "service_account":
+		key, err := parseRSAKey(creds.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing service account key: %w", err)
+		}
+		ts.key = key
+	case "authorized_user":
+		if creds.RefreshToken == "" {
+			return nil, errors.New("authorized_user credentials missing refresh_token")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported google credentials type %q", creds.Type)
+	}
+	ts.creds = &creds
+	return ts, nil
+}
+
+func parseRSAKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not RSA")
+		}
+		return rk, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// Token returns a valid access token, refreshing it if needed.
+func (ts *googleTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Now().Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	switch {
+	case ts.creds == nil:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, ts.metadataURL, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	case ts.key != nil:
+		var assertion string
+		assertion, err = ts.signJWT(time.Now())
+		if err == nil {
+			req, err = newFormRequest(ctx, ts.creds.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}
+	default:
+		req, err = newFormRequest(ctx, ts.creds.TokenURI, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {ts.creds.ClientID},
+			"client_secret": {ts.creds.ClientSecret},
+			"refresh_token": {ts.creds.RefreshToken},
+		})
+	}
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("token endpoint error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("token response missing access_token")
+	}
+
+	ts.token = tok.AccessToken
+	ts.expires = tokenExpiry(time.Now(), tok.ExpiresIn)
+	return ts.token, nil
+}
+
+// signJWT builds an RS256-signed assertion for the service account JWT bearer flow.
+func (ts *googleTokenSource) signJWT(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if ts.creds.PrivateKeyID != "" {
+		header["kid"] = ts.creds.PrivateKeyID
+	}
+	claims := map[string]any{
+		"iss":   ts.creds.ClientEmail,
+		"scope": cloudPlatformScope,
+		"aud":   ts.creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(hb) + "." + enc.EncodeToString(cb)
+
+	sum := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("signing jwt: %w", err)
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
+
+func newFormRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
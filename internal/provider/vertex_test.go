@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func writeServiceAccount(t *testing.T, tokenURI string) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "qlite@test.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+		"token_uri":    tokenURI,
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func TestVertex_ServiceAccountAuth(t *testing.T) {
+	var tokenCalls atomic.Int32
+	var key *rsa.PrivateKey
+
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls.Add(1)
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("unexpected grant_type %q", r.Form.Get("grant_type"))
+		}
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("expected 3-part JWT, got %d parts", len(parts))
+			http.Error(w, "bad assertion", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("invalid JWT signature: %v", err)
+		}
+		fmt.Fprint(w, `{"access_token":"ya29.test","expires_in":3600}`)
+	}))
+	defer tokenSrv.Close()
+
+	var credsPath string
+	credsPath, key = writeServiceAccount(t, tokenSrv.URL)
+
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ya29.test" {
+			t.Errorf("expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/publishers/google/models/gemini-2.5-flash:generateContent" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("key") != "" {
+			t.Error("expected no API key on vertex requests")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer apiSrv.Close()
+
+	p, err := NewVertex("vertex", apiSrv.URL, "proj", "us-central1", credsPath, []string{"gemini-2.5-flash"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := p.Chat(context.Background(), &model.ChatRequest{
+			Model:    "gemini-2.5-flash",
+			Messages: []model.Message{{Role: "user", Content: "Hi"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Choices[0].Message.Content != "hi" {
+			t.Errorf("expected content hi, got %q", resp.Choices[0].Message.Content)
+		}
+	}
+	if tokenCalls.Load() != 1 {
+		t.Errorf("expected token to be cached (1 call), got %d", tokenCalls.Load())
+	}
+}
+
+func TestVertex_DerivedBaseURL(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+
+	p, err := NewVertex("vertex", "", "my-proj", "europe-west4", "", []string{"gemini-2.5-pro"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "https://europe-west4-aiplatform.googleapis.com/v1/projects/my-proj/locations/europe-west4/publishers/google/models/gemini-2.5-pro:generateContent"
+	if got := p.chatURL("gemini-2.5-pro"); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestVertex_MetadataServer(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+
+	md := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			t.Error("expected Metadata-Flavor header")
+		}
+		fmt.Fprint(w, `{"access_token":"md-token","expires_in":3600}`)
+	}))
+	defer md.Close()
+
+	ts, err := newGoogleTokenSource("", http.DefaultClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ts.metadataURL = md.URL
+	tok, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok != "md-token" {
+		t.Errorf("expected md-token, got %q", tok)
+	}
+}
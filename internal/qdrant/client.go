@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
@@ -20,9 +21,27 @@ var bufPool = sync.Pool{
 
 // CachedPayload is the data stored alongside each vector in Qdrant.
 type CachedPayload struct {
-	Response  *model.ChatResponse `json:"response"`
+	Response  *model.ChatResponse `json:"response,omitempty"`
 	Model     string              `json:"model"`
 	CreatedAt int64               `json:"created_at"`
+	// ResponseGzip replaces Response when the client compresses payloads.
+	// Decoded payloads always carry Response.
+	ResponseGzip []byte `json:"response_gz,omitempty"`
+	// ExactKey is the exact-cache key of the request that produced Response,
+	// used to warm the exact cache on startup.
+	ExactKey string `json:"exact_key,omitempty"`
+	// Prompt is the embedded prompt text (possibly truncated or hashed),
+	// stored for debugging when enabled.
+	Prompt string `json:"prompt,omitempty"`
+	// ResponseHash identifies the response by content when responses are
+	// deduplicated: later points with the same answer refer to the point
+	// holding it through ResponseRef instead of repeating it.
+	ResponseHash string `json:"response_hash,omitempty"`
+	ResponseRef  string `json:"response_ref,omitempty"`
+	// ContentHash is the response's key in a shared response store (see
+	// cache.ContentHash), through which lookups serve the copy the exact
+	// cache holds. Response is still stored in full.
+	ContentHash string `json:"content_hash,omitempty"`
 }
 
 // SearchResult is a single match from Qdrant.
@@ -38,6 +57,11 @@ type Client struct {
 	apiKey     string
 	collection string
 	client     *http.Client
+	// compressMin is the smallest response, in JSON bytes, Upsert stores
+	// gzipped; 0 disables compression.
+	compressMin int
+	// hashIndexed is set once the response_hash payload index exists.
+	hashIndexed atomic.Bool
 }
 
 // NewClient creates a Qdrant REST client.
@@ -56,6 +80,29 @@ func NewClient(baseURL, apiKey, collection string) *Client {
 	}
 }
 
+// SetCompression makes Upsert store responses whose JSON encoding is at
+// least minBytes gzipped. Compressed payloads are decoded transparently
+// whether or not compression is enabled. 0 disables it.
+func (c *Client) SetCompression(minBytes int) {
+	c.compressMin = minBytes
+}
+
+// decodePayload decodes a point's payload, decompressing its response.
+func decodePayload(raw json.RawMessage) (*CachedPayload, error) {
+	var payload CachedPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Response == nil && payload.ResponseGzip != nil {
+		resp, err := model.DecompressResponse(payload.ResponseGzip)
+		if err != nil {
+			return nil, err
+		}
+		payload.Response, payload.ResponseGzip = resp, nil
+	}
+	return &payload, nil
+}
+
 // EnsureCollection creates the collection if it doesn't exist.
 func (c *Client) EnsureCollection(ctx context.Context, vectorSize int) error {
 	body := map[string]any{
@@ -105,12 +152,19 @@ type queryFilter struct {
 }
 
 type filterCondition struct {
-	Key   string       `json:"key"`
-	Match *matchValue  `json:"match"`
+	Key     string      `json:"key,omitempty"`
+	Match   *matchValue `json:"match,omitempty"`
+	IsEmpty *fieldRef   `json:"is_empty,omitempty"`
+}
+
+type fieldRef struct {
+	Key string `json:"key"`
 }
 
 type matchValue struct {
-	Value string `json:"value"`
+	Value string   `json:"value,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Any   []string `json:"any,omitempty"`
 }
 
 type searchResponse struct {
@@ -125,16 +179,34 @@ type searchResultRaw struct {
 
 // Search finds similar vectors in the collection, filtered by model.
 func (c *Client) Search(ctx context.Context, vector []float32, limit int, scoreThreshold float32, modelFilter string) ([]SearchResult, error) {
+	var models []string
+	if modelFilter != "" {
+		models = []string{modelFilter}
+	}
+	return c.SearchModels(ctx, vector, limit, scoreThreshold, models)
+}
+
+// SearchModels finds similar vectors stored for any of models. An empty list
+// searches all models.
+func (c *Client) SearchModels(ctx context.Context, vector []float32, limit int, scoreThreshold float32, models []string) ([]SearchResult, error) {
 	body := searchRequest{
 		Vector:      vector,
 		Limit:       limit,
 		ScoreThresh: scoreThreshold,
 		WithPayload: true,
 	}
-	if modelFilter != "" {
+	switch len(models) {
+	case 0:
+	case 1:
 		body.Filter = &queryFilter{
 			Must: []filterCondition{
-				{Key: "model", Match: &matchValue{Value: modelFilter}},
+				{Key: "model", Match: &matchValue{Value: models[0]}},
+			},
+		}
+	default:
+		body.Filter = &queryFilter{
+			Must: []filterCondition{
+				{Key: "model", Match: &matchValue{Any: models}},
 			},
 		}
 	}
@@ -171,14 +243,14 @@ func (c *Client) Search(ctx context.Context, vector []float32, limit int, scoreT
 
 	results := make([]SearchResult, 0, len(sr.Result))
 	for _, r := range sr.Result {
-		var payload CachedPayload
-		if err := json.Unmarshal(r.Payload, &payload); err != nil {
+		payload, err := decodePayload(r.Payload)
+		if err != nil {
 			continue
 		}
 		results = append(results, SearchResult{
 			ID:      r.ID,
 			Score:   r.Score,
-			Payload: &payload,
+			Payload: payload,
 		})
 	}
 	return results, nil
@@ -196,12 +268,22 @@ type point struct {
 
 // Upsert inserts or updates a point in the collection.
 func (c *Client) Upsert(ctx context.Context, id string, vector []float32, payload *CachedPayload) error {
-	body := upsertRequest{
+	if c.compressMin > 0 && payload.Response != nil {
+		if data, _, ok := model.CompressResponse(payload.Response, c.compressMin); ok {
+			p := *payload
+			p.Response, p.ResponseGzip = nil, data
+			payload = &p
+		}
+	}
+	return c.putPoints(ctx, upsertRequest{
 		Points: []point{
 			{ID: id, Vector: vector, Payload: payload},
 		},
-	}
+	})
+}
 
+// putPoints sends an upsert request body to the collection.
+func (c *Client) putPoints(ctx context.Context, body any) error {
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
@@ -248,6 +330,157 @@ func (c *Client) DeleteCollection(ctx context.Context) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status deleting collection: %d", resp.StatusCode)
 	}
+	c.hashIndexed.Store(false)
+	return nil
+}
+
+// Count returns the number of points in the collection.
+func (c *Client) Count(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL+"/collections/"+c.collection+"/points/count", strings.NewReader(`{"exact":true}`))
+	if err != nil {
+		return 0, fmt.Errorf("creating count request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("counting points: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return 0, fmt.Errorf("qdrant count error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var cr struct {
+		Result struct {
+			Count int `json:"count"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return 0, fmt.Errorf("decoding count response: %w", err)
+	}
+	return cr.Result.Count, nil
+}
+
+type scrollRequest struct {
+	Limit       int             `json:"limit"`
+	Offset      json.RawMessage `json:"offset,omitempty"`
+	WithPayload bool            `json:"with_payload"`
+	WithVector  bool            `json:"with_vector"`
+	Filter      *queryFilter    `json:"filter,omitempty"`
+	OrderBy     *scrollOrder    `json:"order_by,omitempty"`
+}
+
+type scrollOrder struct {
+	Key       string `json:"key"`
+	Direction string `json:"direction"`
+}
+
+type scrollResponse struct {
+	Result struct {
+		Points []searchResultRaw `json:"points"`
+	} `json:"result"`
+}
+
+// Recent returns up to limit points, newest first by created_at. It creates
+// the integer payload index Qdrant requires for ordering if it is missing.
+func (c *Client) Recent(ctx context.Context, limit int) ([]SearchResult, error) {
+	if err := c.ensureIndex(ctx, "created_at", "integer"); err != nil {
+		return nil, err
+	}
+	return c.scroll(ctx, scrollRequest{
+		Limit:       limit,
+		WithPayload: true,
+		OrderBy:     &scrollOrder{Key: "created_at", Direction: "desc"},
+	})
+}
+
+// FindPrompts returns up to limit points whose stored prompt contains text.
+// Without a full-text index on prompt, Qdrant matches it as a plain substring.
+func (c *Client) FindPrompts(ctx context.Context, text string, limit int) ([]SearchResult, error) {
+	return c.scroll(ctx, scrollRequest{
+		Limit:       limit,
+		WithPayload: true,
+		Filter: &queryFilter{
+			Must: []filterCondition{{Key: "prompt", Match: &matchValue{Text: text}}},
+		},
+	})
+}
+
+func (c *Client) scroll(ctx context.Context, body scrollRequest) ([]SearchResult, error) {
+	var sr scrollResponse
+	if err := c.scrollInto(ctx, body, &sr); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(sr.Result.Points))
+	for _, r := range sr.Result.Points {
+		payload, err := decodePayload(r.Payload)
+		if err != nil {
+			continue
+		}
+		results = append(results, SearchResult{ID: r.ID, Payload: payload})
+	}
+	return results, nil
+}
+
+// scrollInto sends a scroll request and decodes the response into out.
+func (c *Client) scrollInto(ctx context.Context, body scrollRequest, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshaling scroll request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL+"/collections/"+c.collection+"/points/scroll", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("creating scroll request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("scrolling qdrant: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("qdrant scroll error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding scroll response: %w", err)
+	}
+	return nil
+}
+
+// ensureIndex creates a payload index on field. Creating an existing index
+// is a no-op in Qdrant.
+func (c *Client) ensureIndex(ctx context.Context, field, schema string) error {
+	body, err := json.Marshal(map[string]string{"field_name": field, "field_schema": schema})
+	if err != nil {
+		return fmt.Errorf("marshaling index request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		c.baseURL+"/collections/"+c.collection+"/index", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating index request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("creating index: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status creating index on %s: %d", field, resp.StatusCode)
+	}
 	return nil
 }
 
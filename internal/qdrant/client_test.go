@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
@@ -114,6 +115,25 @@ func TestSearch_NoResults(t *testing.T) {
 	}
 }
 
+func TestCount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/collections/test_collection/points/count" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"result":{"count":12},"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "test_collection")
+	n, err := client.Count(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 12 {
+		t.Errorf("expected 12 points, got %d", n)
+	}
+}
+
 func TestUpsert(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
@@ -147,6 +167,62 @@ func TestUpsert(t *testing.T) {
 	}
 }
 
+func TestUpsert_Compression(t *testing.T) {
+	resp := &model.ChatResponse{
+		ID:      "resp-1",
+		Model:   "gpt-4o",
+		Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: strings.Repeat("lorem ipsum ", 500)}}},
+	}
+	var stored json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/test_collection/points":
+			var req struct {
+				Points []struct {
+					Payload json.RawMessage `json:"payload"`
+				} `json:"points"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			stored = req.Points[0].Payload
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+		case "/collections/test_collection/points/search":
+			json.NewEncoder(w).Encode(searchResponse{
+				Result: []searchResultRaw{{ID: "abc", Score: 0.99, Payload: stored}},
+			})
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "test_collection")
+	client.SetCompression(1024)
+	payload := &CachedPayload{Response: resp, Model: "gpt-4o"}
+	if err := client.Upsert(context.Background(), "p", []float32{0.1}, payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Response != resp {
+		t.Error("Upsert modified the caller's payload")
+	}
+	var raw map[string]json.RawMessage
+	json.Unmarshal(stored, &raw)
+	if _, ok := raw["response"]; ok || raw["response_gz"] == nil {
+		t.Errorf("expected only a compressed response, got keys %v", stored)
+	}
+	if len(stored) >= len(resp.Choices[0].Message.Content) {
+		t.Errorf("payload not smaller than the response: %d bytes", len(stored))
+	}
+
+	results, err := client.Search(context.Background(), []float32{0.1}, 1, 0.9, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Payload.Response == nil || results[0].Payload.Response.Choices[0].Message.Content != resp.Choices[0].Message.Content {
+		t.Fatalf("compressed payload not decoded: %+v", results)
+	}
+	if results[0].Payload.ResponseGzip != nil {
+		t.Error("decoded payload still carries compressed bytes")
+	}
+}
+
 func TestAPIKeyHeader(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("api-key") != "my-qdrant-key" {
@@ -163,3 +239,35 @@ func TestAPIKeyHeader(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestRecent(t *testing.T) {
+	var indexed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/test/index":
+			indexed = true
+			w.Write([]byte(`{"result":{"status":"acknowledged"}}`))
+		case "/collections/test/points/scroll":
+			var body scrollRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Limit != 5 || body.OrderBy.Key != "created_at" || body.OrderBy.Direction != "desc" {
+				t.Errorf("unexpected scroll request: %+v", body)
+			}
+			w.Write([]byte(`{"result":{"points":[{"id":"p1","payload":{"model":"gpt-4o","created_at":42,"exact_key":"k1","response":{"id":"r1"}}}]}}`))
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	results, err := NewClient(server.URL, "", "test").Recent(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !indexed {
+		t.Error("expected created_at index to be ensured")
+	}
+	if len(results) != 1 || results[0].Payload.ExactKey != "k1" || results[0].Payload.CreatedAt != 42 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
@@ -0,0 +1,84 @@
+package qdrant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// FindResponse returns the ID of a point stored for modelName that holds a
+// response with the given ResponseHash itself, rather than referring to
+// another point, or "" if there is none. It creates the keyword payload
+// index the lookup relies on the first time it is called.
+func (c *Client) FindResponse(ctx context.Context, modelName, hash string) (string, error) {
+	if !c.hashIndexed.Load() {
+		if err := c.ensureIndex(ctx, "response_hash", "keyword"); err != nil {
+			return "", err
+		}
+		c.hashIndexed.Store(true)
+	}
+	var sr struct {
+		Result struct {
+			Points []struct {
+				ID string `json:"id"`
+			} `json:"points"`
+		} `json:"result"`
+	}
+	err := c.scrollInto(ctx, scrollRequest{
+		Limit: 1,
+		Filter: &queryFilter{
+			Must: []filterCondition{
+				{Key: "model", Match: &matchValue{Value: modelName}},
+				{Key: "response_hash", Match: &matchValue{Value: hash}},
+				{IsEmpty: &fieldRef{Key: "response_ref"}},
+			},
+		},
+	}, &sr)
+	if err != nil || len(sr.Result.Points) == 0 {
+		return "", err
+	}
+	return sr.Result.Points[0].ID, nil
+}
+
+// Get returns the payloads of the points with the given IDs, keyed by ID.
+// Points that don't exist are missing from the map.
+func (c *Client) Get(ctx context.Context, ids []string) (map[string]*CachedPayload, error) {
+	body, err := json.Marshal(map[string]any{"ids": ids, "with_payload": true})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling get request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL+"/collections/"+c.collection+"/points", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating get request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting points: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("qdrant get error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var gr searchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return nil, fmt.Errorf("decoding get response: %w", err)
+	}
+	out := make(map[string]*CachedPayload, len(gr.Result))
+	for _, r := range gr.Result {
+		payload, err := decodePayload(r.Payload)
+		if err != nil {
+			continue
+		}
+		out[r.ID] = payload
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package qdrant
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// transferBatch is how many points Export reads and Import writes per
+// request.
+const transferBatch = 256
+
+// Point is a point as exported and imported: its ID, vector and payload,
+// kept in Qdrant's encoding so nothing is lost between clusters. Compressed
+// payloads stay compressed.
+type Point struct {
+	ID      json.RawMessage `json:"id"`
+	Vector  json.RawMessage `json:"vector"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type exportResponse struct {
+	Result struct {
+		Points         []Point         `json:"points"`
+		NextPageOffset json.RawMessage `json:"next_page_offset"`
+	} `json:"result"`
+}
+
+// Export writes every point of the collection to w as JSON lines, one Point
+// per line, and returns how many were written.
+func (c *Client) Export(ctx context.Context, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+	var offset json.RawMessage
+	for {
+		var page exportResponse
+		err := c.scrollInto(ctx, scrollRequest{
+			Limit:       transferBatch,
+			Offset:      offset,
+			WithPayload: true,
+			WithVector:  true,
+		}, &page)
+		if err != nil {
+			return n, err
+		}
+		for _, p := range page.Result.Points {
+			if err := enc.Encode(p); err != nil {
+				return n, fmt.Errorf("writing export: %w", err)
+			}
+			n++
+		}
+		offset = page.Result.NextPageOffset
+		if len(offset) == 0 || string(offset) == "null" {
+			return n, nil
+		}
+	}
+}
+
+// Import upserts the points in r, JSON lines as written by Export, into the
+// collection and returns how many were imported. Points with an ID already
+// in the collection replace it. A malformed line fails the import; the
+// points before its batch have already been written.
+func (c *Client) Import(ctx context.Context, r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	batch := make([]Point, 0, transferBatch)
+	n := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.putPoints(ctx, struct {
+			Points []Point `json:"points"`
+		}{batch}); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var p Point
+		if err := json.Unmarshal(b, &p); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(p.ID) == 0 || len(p.Vector) == 0 {
+			return n, fmt.Errorf("line %d: point needs an id and a vector", line)
+		}
+		batch = append(batch, p)
+		if len(batch) == transferBatch {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return n, fmt.Errorf("reading import: %w", err)
+	}
+	return n, flush()
+}
@@ -0,0 +1,98 @@
+package qdrant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeCollection serves scroll and upsert for an in-memory collection,
+// returning scroll pages of pageSize points.
+func fakeCollection(t *testing.T, points []Point, pageSize int) (*httptest.Server, *[]Point) {
+	stored := &[]Point{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/c/points/scroll":
+			var body scrollRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			if !body.WithVector || !body.WithPayload {
+				t.Errorf("export must request vectors and payloads: %+v", body)
+			}
+			start := 0
+			if len(body.Offset) > 0 {
+				json.Unmarshal(body.Offset, &start)
+			}
+			end := min(start+pageSize, len(points))
+			var resp exportResponse
+			resp.Result.Points = points[start:end]
+			resp.Result.NextPageOffset = json.RawMessage("null")
+			if end < len(points) {
+				resp.Result.NextPageOffset = json.RawMessage(fmt.Sprint(end))
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/collections/c/points":
+			var body struct {
+				Points []Point `json:"points"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			*stored = append(*stored, body.Points...)
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	return srv, stored
+}
+
+func TestExportImport(t *testing.T) {
+	var points []Point
+	for i := range 5 {
+		points = append(points, Point{
+			ID:      json.RawMessage(fmt.Sprintf(`"p%d"`, i)),
+			Vector:  json.RawMessage(`[0.1,0.2]`),
+			Payload: json.RawMessage(fmt.Sprintf(`{"model":"gpt-4o","response_gz":"H4sI%d"}`, i)),
+		})
+	}
+	src, _ := fakeCollection(t, points, 2)
+	defer src.Close()
+	dst, stored := fakeCollection(t, nil, 2)
+	defer dst.Close()
+
+	var buf bytes.Buffer
+	n, err := NewClient(src.URL, "", "c").Export(context.Background(), &buf)
+	if err != nil || n != 5 {
+		t.Fatalf("Export = %d, %v", n, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 5 {
+		t.Errorf("expected 5 lines, got %d", lines)
+	}
+
+	n, err = NewClient(dst.URL, "", "c").Import(context.Background(), &buf)
+	if err != nil || n != 5 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+	for i, p := range *stored {
+		if string(p.ID) != string(points[i].ID) || string(p.Payload) != string(points[i].Payload) {
+			t.Errorf("point %d = %s %s, want %s %s", i, p.ID, p.Payload, points[i].ID, points[i].Payload)
+		}
+	}
+}
+
+func TestImport_Malformed(t *testing.T) {
+	dst, stored := fakeCollection(t, nil, 2)
+	defer dst.Close()
+
+	in := `{"id":"a","vector":[1],"payload":{}}` + "\n\n" + `{"id":"b","payload":{}}` + "\n"
+	n, err := NewClient(dst.URL, "", "c").Import(context.Background(), strings.NewReader(in))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+	if n != 0 || len(*stored) != 0 {
+		t.Errorf("expected nothing imported, got %d (%d stored)", n, len(*stored))
+	}
+}
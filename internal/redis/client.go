@@ -0,0 +1,254 @@
+// Package redis is a minimal Redis client speaking RESP2, covering the
+// commands qlite needs for state shared between replicas.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply from the server. The connection stays usable.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// ErrClosed is returned for commands sent after Close.
+var ErrClosed = errors.New("redis: client closed")
+
+// maxIdle is how many idle connections the client keeps open.
+const maxIdle = 16
+
+// Client sends commands to one Redis server over a small pool of
+// connections. It is safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	idle   chan *conn
+	closed chan struct{}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewClient creates a client for the server at addr (host:port), logging
+// in with password and selecting db if they are set. Each command, dial
+// included, must complete within timeout (0 for none) or its context's
+// deadline, whichever is sooner. Connections are opened on demand.
+func NewClient(addr, password string, db int, timeout time.Duration) *Client {
+	return &Client{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+		idle:     make(chan *conn, maxIdle),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Do sends one command and returns its reply: an int64, a string, a []any
+// of replies, nil for a nil reply, or an Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.Pipeline(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(Error); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends cmds in one round trip and returns their replies in
+// order. Error replies are returned among the replies rather than as err,
+// which is only set if the exchange itself failed.
+func (c *Client) Pipeline(ctx context.Context, cmds ...[]string) ([]any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.exchange(c.deadline(ctx), cmds)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, nil
+}
+
+// Close closes idle connections; connections in use are closed when their
+// command completes.
+func (c *Client) Close() error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+	}
+	close(c.closed)
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) deadline(ctx context.Context) time.Time {
+	d, ok := ctx.Deadline()
+	if c.timeout > 0 {
+		if t := time.Now().Add(c.timeout); !ok || t.Before(d) {
+			return t
+		}
+	}
+	return d
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClosed
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	deadline := c.deadline(ctx)
+	d := net.Dialer{Deadline: deadline}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		replies, err := cn.exchange(deadline, setup)
+		if err == nil {
+			for _, r := range replies {
+				if e, ok := r.(Error); ok {
+					err = e
+					break
+				}
+			}
+		}
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case <-c.closed:
+		cn.Close()
+		return
+	default:
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// exchange writes cmds and reads one reply for each.
+func (cn *conn) exchange(deadline time.Time, cmds [][]string) ([]any, error) {
+	cn.SetDeadline(deadline)
+	for _, args := range cmds {
+		writeCommand(cn.w, args)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		r, err := readReply(cn.r)
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		replies[i] = r
+	}
+	return replies, nil
+}
+
+func writeCommand(w *bufio.Writer, args []string) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, a := range args {
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(a)))
+		w.WriteString("\r\n")
+		w.WriteString(a)
+		w.WriteString("\r\n")
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", line[0])
+}
@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers AUTH, INCR and PING from memory.
+type fakeServer struct {
+	ln       net.Listener
+	password string
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, password: password, counts: make(map[string]int64)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := s.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]any)
+		cmd, _ := args[0].(string)
+		switch {
+		case cmd == "AUTH":
+			if args[1] != s.password {
+				w.WriteString("-WRONGPASS invalid password\r\n")
+				break
+			}
+			authed = true
+			w.WriteString("+OK\r\n")
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case cmd == "INCR":
+			s.mu.Lock()
+			s.counts[args[1].(string)]++
+			n := s.counts[args[1].(string)]
+			s.mu.Unlock()
+			w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+		case cmd == "PING":
+			w.WriteString("+PONG\r\n")
+		default:
+			w.WriteString("-ERR unknown command\r\n")
+		}
+		w.Flush()
+	}
+}
+
+func TestClient_Commands(t *testing.T) {
+	srv := newFakeServer(t, "secret")
+	c := NewClient(srv.ln.Addr().String(), "secret", 0, time.Second)
+	defer c.Close()
+	ctx := context.Background()
+
+	if r, err := c.Do(ctx, "PING"); err != nil || r != "PONG" {
+		t.Fatalf("PING: %v, %v", r, err)
+	}
+	replies, err := c.Pipeline(ctx, []string{"INCR", "k"}, []string{"INCR", "k"}, []string{"NOPE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replies[0] != int64(1) || replies[1] != int64(2) {
+		t.Errorf("expected counts 1 and 2, got %v", replies[:2])
+	}
+	if _, ok := replies[2].(Error); !ok {
+		t.Errorf("expected an error reply, got %v", replies[2])
+	}
+	// The error reply leaves the pooled connection usable.
+	if r, err := c.Do(ctx, "INCR", "k"); err != nil || r != int64(3) {
+		t.Errorf("INCR after error: %v, %v", r, err)
+	}
+	var rerr Error
+	if _, err := c.Do(ctx, "NOPE"); !errors.As(err, &rerr) {
+		t.Errorf("expected Error from Do, got %v", err)
+	}
+
+	bad := NewClient(srv.ln.Addr().String(), "wrong", 0, time.Second)
+	defer bad.Close()
+	if _, err := bad.Do(ctx, "PING"); !errors.As(err, &rerr) {
+		t.Errorf("expected the AUTH error, got %v", err)
+	}
+
+	c.Close()
+	if _, err := c.Do(ctx, "PING"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close() // never answers
+		}
+	}()
+
+	c := NewClient(ln.Addr().String(), "", 0, 50*time.Millisecond)
+	defer c.Close()
+	start := time.Now()
+	if _, err := c.Do(context.Background(), "PING"); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timeout took %v", d)
+	}
+}
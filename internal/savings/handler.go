@@ -0,0 +1,53 @@
+package savings
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+type report struct {
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Rows  []Row  `json:"rows"`
+	Total Totals `json:"total"`
+}
+
+// ServeHTTP serves GET /admin/savings?from=YYYY-MM-DD&to=YYYY-MM-DD. With
+// by=tag it reports the tag rows instead, optionally narrowed to one tag name
+// with tag=NAME; the total then only makes sense for a single tag name.
+func (r *Rollup) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	from := req.URL.Query().Get("from")
+	to := req.URL.Query().Get("to")
+	for _, d := range []string{from, to} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse(dayFormat, d); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(model.ErrorResponse{
+				Error: model.ErrorDetail{
+					Message: "from and to must be dates in YYYY-MM-DD format",
+					Type:    "invalid_request_error",
+				},
+			})
+			return
+		}
+	}
+
+	rep := report{From: from, To: to}
+	if req.URL.Query().Get("by") == "tag" {
+		rep.Rows = r.QueryTags(from, to, req.URL.Query().Get("tag"))
+	} else {
+		rep.Rows = r.Query(from, to)
+	}
+	for i := range rep.Rows {
+		rep.Total.add(&rep.Rows[i].Totals)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,283 @@
+// Package savings keeps a persistent per-day rollup of request cost and cache
+// savings, broken down by model and API key, for finance reporting.
+package savings
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dayFormat = "2006-01-02"
+
+// Totals accumulates usage for one (day, model, key) bucket.
+type Totals struct {
+	Requests     int64   `json:"requests"`
+	CacheHits    int64   `json:"cache_hits"`
+	Cost         float64 `json:"cost"`
+	Saved        float64 `json:"saved"`
+	TokensInput  int64   `json:"tokens_input"`
+	TokensOutput int64   `json:"tokens_output"`
+	TokensSaved  int64   `json:"tokens_saved"`
+}
+
+func (t *Totals) add(o *Totals) {
+	t.Requests += o.Requests
+	t.CacheHits += o.CacheHits
+	t.Cost += o.Cost
+	t.Saved += o.Saved
+	t.TokensInput += o.TokensInput
+	t.TokensOutput += o.TokensOutput
+	t.TokensSaved += o.TokensSaved
+}
+
+// Row is one bucket of the rollup. Tag rows ("team=search") aggregate every
+// request carrying that tag for the day and leave Model and Key empty; a
+// request is counted once per tag, so tag rows overlap and must not be summed
+// with the untagged rows.
+type Row struct {
+	Day   string `json:"day"`
+	Model string `json:"model"`
+	Key   string `json:"key"`
+	Tag   string `json:"tag,omitempty"`
+	Totals
+}
+
+// Event is a single completed request to be recorded.
+type Event struct {
+	Time         time.Time
+	Model        string
+	APIKey       string
+	CacheHit     bool
+	Cost         float64
+	Saved        float64
+	TokensInput  int
+	TokensOutput int
+	TokensSaved  int
+	// Tags are caller-supplied cost attribution labels (X-QLite-Tags).
+	Tags map[string]string
+}
+
+type bucketKey struct {
+	day, model, key, tag string
+}
+
+// OtherTag is the tag row counting, once per request, the tags that found
+// no row of their own under SetMaxTags.
+const OtherTag = "_other"
+
+// Rollup is a concurrency-safe daily rollup persisted as a JSON file.
+// Writes go to memory; Flush (or Run) persists them atomically.
+type Rollup struct {
+	path    string
+	maxTags int
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*Totals
+	tagRows map[string]int // day -> tag rows, OtherTag aside
+	dirty   bool
+}
+
+// Open loads the rollup at path, or starts empty if the file doesn't exist.
+func Open(path string) (*Rollup, error) {
+	r := &Rollup{path: path, buckets: make(map[bucketKey]*Totals), tagRows: make(map[string]int)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading savings file: %w", err)
+	}
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("decoding savings file: %w", err)
+	}
+	for _, row := range rows {
+		t := row.Totals
+		r.buckets[bucketKey{row.Day, row.Model, row.Key, row.Tag}] = &t
+		if row.Tag != "" && row.Tag != OtherTag {
+			r.tagRows[row.Day]++
+		}
+	}
+	return r, nil
+}
+
+// SetMaxTags bounds the distinct tags (name=value pairs) that get a row of
+// their own each day, since tag values come from clients. Once a day has n,
+// requests with new tags count toward OtherTag instead. 0 means no limit.
+// Must be called before Record.
+func (r *Rollup) SetMaxTags(n int) {
+	r.maxTags = n
+}
+
+// KeyID returns a stable, non-reversible identifier for an API key so raw
+// credentials are never written to disk.
+func KeyID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(h[:8])
+}
+
+// Record adds an event to its day's bucket (UTC).
+func (r *Rollup) Record(e Event) {
+	k := bucketKey{
+		day:   e.Time.UTC().Format(dayFormat),
+		model: e.Model,
+		key:   KeyID(e.APIKey),
+	}
+	delta := Totals{
+		Requests:     1,
+		Cost:         e.Cost,
+		Saved:        e.Saved,
+		TokensInput:  int64(e.TokensInput),
+		TokensOutput: int64(e.TokensOutput),
+		TokensSaved:  int64(e.TokensSaved),
+	}
+	if e.CacheHit {
+		delta.CacheHits = 1
+	}
+
+	r.mu.Lock()
+	r.addLocked(k, &delta)
+	other := false
+	for name, value := range e.Tags {
+		tk := bucketKey{day: k.day, tag: name + "=" + value}
+		if _, ok := r.buckets[tk]; !ok {
+			if r.maxTags > 0 && r.tagRows[k.day] >= r.maxTags {
+				other = true
+				continue
+			}
+			r.tagRows[k.day]++
+		}
+		r.addLocked(tk, &delta)
+	}
+	if other {
+		r.addLocked(bucketKey{day: k.day, tag: OtherTag}, &delta)
+	}
+	r.dirty = true
+	r.mu.Unlock()
+}
+
+func (r *Rollup) addLocked(k bucketKey, delta *Totals) {
+	t, ok := r.buckets[k]
+	if !ok {
+		t = &Totals{}
+		r.buckets[k] = t
+	}
+	t.add(delta)
+}
+
+// Query returns rows with from <= day <= to (inclusive, "YYYY-MM-DD"), sorted
+// by day, model, key. Empty bounds are open-ended. Tag rows are excluded.
+func (r *Rollup) Query(from, to string) []Row {
+	return r.rows(from, to, func(k bucketKey) bool { return k.tag == "" })
+}
+
+// QueryTags returns the tag rows between from and to, sorted by day and tag.
+// A non-empty name keeps only tags with that name (e.g. "team").
+func (r *Rollup) QueryTags(from, to, name string) []Row {
+	return r.rows(from, to, func(k bucketKey) bool {
+		tagName, _, _ := strings.Cut(k.tag, "=")
+		return k.tag != "" && (name == "" || tagName == name)
+	})
+}
+
+func (r *Rollup) rows(from, to string, keep func(bucketKey) bool) []Row {
+	r.mu.Lock()
+	rows := make([]Row, 0, len(r.buckets))
+	for k, t := range r.buckets {
+		if (from != "" && k.day < from) || (to != "" && k.day > to) || !keep(k) {
+			continue
+		}
+		rows = append(rows, Row{Day: k.day, Model: k.model, Key: k.key, Tag: k.tag, Totals: *t})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		if rows[i].Model != rows[j].Model {
+			return rows[i].Model < rows[j].Model
+		}
+		if rows[i].Key != rows[j].Key {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].Tag < rows[j].Tag
+	})
+	return rows
+}
+
+// Flush writes the rollup to disk if it changed since the last flush.
+func (r *Rollup) Flush() error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	data, err := json.Marshal(r.rows("", "", func(bucketKey) bool { return true }))
+	if err != nil {
+		return fmt.Errorf("encoding savings: %w", err)
+	}
+	if err := writeFileAtomic(r.path, data); err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return fmt.Errorf("writing savings file: %w", err)
+	}
+	return nil
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more.
+func (r *Rollup) Run(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(); err != nil && onErr != nil {
+				onErr(err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".savings-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,146 @@
+package savings
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse(dayFormat, s)
+	return t.Add(12 * time.Hour)
+}
+
+func TestRollup_RecordAndQuery(t *testing.T) {
+	r, err := Open(filepath.Join(t.TempDir(), "savings.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", APIKey: "sk-a", Cost: 0.01, TokensInput: 10, TokensOutput: 5})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", APIKey: "sk-a", CacheHit: true, Saved: 0.01, TokensSaved: 15})
+	r.Record(Event{Time: day("2025-03-02"), Model: "gpt-4o", APIKey: "sk-b", Cost: 0.02})
+	r.Record(Event{Time: day("2025-03-05"), Model: "claude-haiku-4-5", Cost: 0.03})
+
+	rows := r.Query("2025-03-01", "2025-03-02")
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	first := rows[0]
+	if first.Day != "2025-03-01" || first.Requests != 2 || first.CacheHits != 1 {
+		t.Errorf("unexpected first row: %+v", first)
+	}
+	if first.Saved != 0.01 || first.TokensSaved != 15 {
+		t.Errorf("expected savings recorded, got %+v", first)
+	}
+	if first.Key == "sk-a" || first.Key != KeyID("sk-a") {
+		t.Errorf("expected hashed key id, got %q", first.Key)
+	}
+}
+
+func TestRollup_PersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savings.json")
+	r, _ := Open(path)
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 0.5})
+	if err := r.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	r2, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	r2.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 0.25})
+	rows := r2.Query("", "")
+	if len(rows) != 1 || rows[0].Requests != 2 || rows[0].Cost != 0.75 {
+		t.Errorf("expected totals to survive restart, got %+v", rows)
+	}
+}
+
+func TestRollup_ServeHTTP(t *testing.T) {
+	r, _ := Open(filepath.Join(t.TempDir(), "savings.json"))
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 1})
+	r.Record(Event{Time: day("2025-03-02"), Model: "gpt-4o", Cost: 2})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/savings?from=2025-03-02", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var rep report
+	if err := json.NewDecoder(rec.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(rep.Rows) != 1 || rep.Total.Cost != 2 {
+		t.Errorf("unexpected report: %+v", rep)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/savings?from=March", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad date, got %d", rec.Code)
+	}
+}
+
+func TestRollup_Tags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savings.json")
+	r, _ := Open(path)
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 1, Tags: map[string]string{"team": "search", "feature": "autocomplete"}})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 2, Tags: map[string]string{"team": "ads"}})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 4})
+
+	if rows := r.Query("", ""); len(rows) != 1 || rows[0].Cost != 7 {
+		t.Errorf("expected untagged totals to count every request once, got %+v", rows)
+	}
+	teams := r.QueryTags("", "", "team")
+	if len(teams) != 2 || teams[0].Tag != "team=ads" || teams[0].Cost != 2 || teams[1].Tag != "team=search" || teams[1].Cost != 1 {
+		t.Errorf("unexpected team rows: %+v", teams)
+	}
+	if all := r.QueryTags("", "", ""); len(all) != 3 {
+		t.Errorf("expected 3 tag rows, got %+v", all)
+	}
+
+	r.Flush()
+	r2, _ := Open(path)
+	if rows := r2.QueryTags("", "", "feature"); len(rows) != 1 || rows[0].Tag != "feature=autocomplete" {
+		t.Errorf("expected tag rows to persist, got %+v", rows)
+	}
+
+	rec := httptest.NewRecorder()
+	r2.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/savings?by=tag&tag=team", nil))
+	var rep report
+	json.NewDecoder(rec.Body).Decode(&rep)
+	if len(rep.Rows) != 2 || rep.Total.Cost != 3 {
+		t.Errorf("unexpected tag report: %+v", rep)
+	}
+}
+
+func TestRollup_MaxTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savings.json")
+	r, _ := Open(path)
+	r.SetMaxTags(2)
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 1, Tags: map[string]string{"team": "a"}})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 2, Tags: map[string]string{"team": "b"}})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 4, Tags: map[string]string{"team": "c", "user": "x"}})
+	r.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 8, Tags: map[string]string{"team": "a"}})
+	r.Record(Event{Time: day("2025-03-02"), Model: "gpt-4o", Cost: 16, Tags: map[string]string{"team": "d"}})
+
+	rows := r.QueryTags("2025-03-01", "2025-03-01", "")
+	if len(rows) != 3 || rows[0].Tag != OtherTag || rows[0].Cost != 4 || rows[1].Tag != "team=a" || rows[1].Cost != 9 {
+		t.Errorf("expected new tags past the cap counted once under %s, got %+v", OtherTag, rows)
+	}
+	if rows := r.QueryTags("2025-03-02", "2025-03-02", "team"); len(rows) != 1 || rows[0].Tag != "team=d" {
+		t.Errorf("expected the cap to apply per day, got %+v", rows)
+	}
+
+	r.Flush()
+	r2, _ := Open(path)
+	r2.SetMaxTags(2)
+	r2.Record(Event{Time: day("2025-03-01"), Model: "gpt-4o", Cost: 32, Tags: map[string]string{"team": "e"}})
+	if rows := r2.QueryTags("2025-03-01", "2025-03-01", OtherTag); len(rows) != 1 || rows[0].Cost != 36 {
+		t.Errorf("expected the cap to count rows loaded from disk, got %+v", rows)
+	}
+}
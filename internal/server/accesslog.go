@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFields are the fields a JSON access log line can carry.
+var AccessLogFields = []string{
+	"time", "request_id", "remote_addr", "method", "path", "proto", "status",
+	"bytes", "duration_ms", "upstream_latency_ms", "cache", "provider",
+	"cost", "tokens_in", "tokens_out", "user_agent",
+}
+
+// AccessLog writes one line per request to w, independent of the application
+// log. Format is "json" (JSON Lines with the given fields, all of
+// AccessLogFields if empty) or "common" (Common Log Format, which ignores
+// fields). Lines are written whole, so w may be shared by several servers if
+// its Write is safe for concurrent use.
+func AccessLog(w io.Writer, format string, fields []string) func(http.Handler) http.Handler {
+	if len(fields) == 0 {
+		fields = AccessLogFields
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			aw := &accessWriter{ResponseWriter: rw}
+			defer func() {
+				var line []byte
+				if format == "common" {
+					line = commonLogLine(r, aw, start)
+				} else {
+					line = jsonLogLine(r, aw, start, time.Since(start), fields)
+				}
+				w.Write(line)
+			}()
+			next.ServeHTTP(aw, r)
+		})
+	}
+}
+
+// accessWriter records the status and body size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessWriter) WriteHeader(code int) {
+	if aw.status == 0 {
+		aw.status = code
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *accessWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+func (aw *accessWriter) statusCode() int {
+	if aw.status == 0 {
+		return http.StatusOK
+	}
+	return aw.status
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// commonLogLine formats host ident authuser [date] "request" status bytes.
+func commonLogLine(r *http.Request, aw *accessWriter, start time.Time) []byte {
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if aw.bytes > 0 {
+		size = strconv.FormatInt(aw.bytes, 10)
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] %q %d %s\n",
+		remoteHost(r), user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto, aw.statusCode(), size)
+}
+
+func jsonLogLine(r *http.Request, aw *accessWriter, start time.Time, elapsed time.Duration, fields []string) []byte {
+	h := aw.Header()
+	line := make(map[string]any, len(fields))
+	for _, f := range fields {
+		var v any
+		switch f {
+		case "time":
+			v = start.UTC().Format(time.RFC3339Nano)
+		case "request_id":
+			v = GetRequestID(r.Context())
+		case "remote_addr":
+			v = remoteHost(r)
+		case "method":
+			v = r.Method
+		case "path":
+			v = r.URL.Path
+		case "proto":
+			v = r.Proto
+		case "status":
+			v = aw.statusCode()
+		case "bytes":
+			v = aw.bytes
+		case "duration_ms":
+			v = float64(elapsed.Microseconds()) / 1000
+		case "upstream_latency_ms":
+			v = headerNumber(h.Get("X-Upstream-Latency-Ms"))
+		case "cache":
+			v = h.Get("X-Cache")
+		case "provider":
+			v = h.Get("X-Provider")
+		case "cost":
+			v = headerNumber(h.Get("X-Request-Cost"))
+		case "tokens_in":
+			v = headerNumber(h.Get("X-Tokens-Input"))
+		case "tokens_out":
+			v = headerNumber(h.Get("X-Tokens-Output"))
+		case "user_agent":
+			v = r.UserAgent()
+		}
+		if v != nil && v != "" {
+			line[f] = v
+		}
+	}
+	// encoding/json sorts map keys; that keeps lines stable for diffing.
+	b, _ := json.Marshal(line)
+	return append(b, '\n')
+}
+
+// headerNumber returns a numeric response header as a number, or nil if it
+// is unset or not a number.
+func headerNumber(s string) any {
+	if s == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// RotatingFile is an append-only file that is renamed aside and reopened
+// once it grows past MaxSize bytes or has been open for MaxAge. Rotated
+// files get a timestamp suffix (access.log.20240102T150405.000) and only the
+// newest MaxBackups are kept. Zero MaxSize or MaxAge disables that trigger;
+// zero MaxBackups keeps every rotated file.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+// OpenRotatingFile opens path for appending, creating it if needed.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	rf.f, rf.size, rf.opened = f, info.Size(), rf.now()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past MaxSize or
+// the file is older than MaxAge. It is safe for concurrent use.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && ((rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) ||
+		(rf.maxAge > 0 && rf.now().Sub(rf.opened) >= rf.maxAge)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("rotating access log: %w", err)
+	}
+	rf.f = nil
+	backup := rf.path + "." + rf.now().UTC().Format("20060102T150405.000")
+	if err := os.Rename(rf.path, backup); err != nil {
+		// Keep writing to the current file.
+		if oerr := rf.open(); oerr != nil {
+			return oerr
+		}
+		return fmt.Errorf("rotating access log: %w", err)
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.prune()
+	return nil
+}
+
+// prune removes all but the newest maxBackups rotated files. The timestamp
+// suffixes sort chronologically.
+func (rf *RotatingFile) prune() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(rf.path + ".*")
+	if len(backups) <= rf.maxBackups {
+		return
+	}
+	slices.Sort(backups)
+	for _, b := range backups[:len(backups)-rf.maxBackups] {
+		os.Remove(b)
+	}
+}
+
+// Close closes the file. Later writes fail.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("X-Tokens-Input", "12")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}), RequestID, AccessLog(&buf, "json", []string{"request_id", "method", "path", "status", "bytes", "cache", "tokens_in", "provider"}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("invalid line %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"request_id": rec.Header().Get("X-Request-ID"),
+		"method":     "POST",
+		"path":       "/v1/chat/completions",
+		"status":     float64(201),
+		"bytes":      float64(5),
+		"cache":      "HIT",
+		"tokens_in":  float64(12),
+	}
+	if len(line) != len(want) {
+		t.Errorf("got fields %v, want %v", line, want)
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+}
+
+func TestAccessLog_Common(t *testing.T) {
+	var buf bytes.Buffer
+	h := AccessLog(&buf, "common", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/health?x=1", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := buf.String()
+	if !strings.HasPrefix(got, "10.0.0.1 - - [") || !strings.HasSuffix(got, `] "GET /health?x=1 HTTP/1.1" 200 2`+"\n") {
+		t.Errorf("unexpected line %q", got)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	rf, err := OpenRotatingFile(path, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time { return now }
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	write("12345\n")
+	write("1234\n") // fits exactly
+	write("a\n")    // past max size: rotates
+	write("b\n")
+	now = now.Add(time.Hour)
+	write("c\n") // past max age: rotates
+	write("1234567890\n")
+	write("d\n") // rotates, pruning the oldest backup
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+	for file, want := range map[string]string{
+		backups[0]: "c\n",
+		backups[1]: "1234567890\n",
+		path:       "d\n",
+	} {
+		got, _ := os.ReadFile(file)
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/shutdown"
+)
+
+// Async job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// callbackTimeout bounds the delivery of a finished job to its callback URL.
+const callbackTimeout = 10 * time.Second
+
+// asyncJob is a chat request queued with POST /v1/async/chat/completions.
+// The exported fields are its JSON form; they are guarded by the store's
+// mutex once the job is queued.
+type asyncJob struct {
+	ID          string              `json:"id"`
+	Object      string              `json:"object"`
+	Status      string              `json:"status"`
+	CreatedAt   int64               `json:"created_at"`
+	CompletedAt int64               `json:"completed_at,omitempty"`
+	Cache       string              `json:"cache,omitempty"`
+	Provider    string              `json:"provider,omitempty"`
+	Cost        float64             `json:"cost,omitempty"`
+	Response    *model.ChatResponse `json:"response,omitempty"`
+	Error       *model.ErrorDetail  `json:"error,omitempty"`
+
+	req        *model.ProxyRequest
+	callback   string
+	maxLatency time.Duration // X-QLite-Max-Latency, counted from when the job starts
+	expiresAt  time.Time
+}
+
+// asyncStore queues jobs for a fixed pool of workers and keeps them, once
+// finished, for ttl. Jobs live in memory only: a restart loses them.
+type asyncStore struct {
+	ttl           time.Duration
+	callbacks     bool
+	callbackHosts map[string]bool
+	queue         chan *asyncJob
+	workers       *shutdown.Tracker
+	client        *http.Client
+
+	mu        sync.Mutex
+	jobs      map[string]*asyncJob
+	closed    bool
+	lastPrune time.Time
+}
+
+// SetAsync enables POST /v1/async/chat/completions with workers running
+// queued requests, at most maxQueue waiting. Finished jobs can be polled
+// for ttl. With callbacks, clients may have the finished job POSTed to the
+// URL in X-QLite-Callback-URL. Its host must be one of callbackHosts or,
+// with none configured, have only public addresses. Must be called before
+// serving; the workers stop with DrainAsync.
+func (h *Handler) SetAsync(workers, maxQueue int, ttl time.Duration, callbacks bool, callbackHosts []string) {
+	s := &asyncStore{
+		ttl:       ttl,
+		callbacks: callbacks,
+		queue:     make(chan *asyncJob, maxQueue),
+		workers:   shutdown.NewTracker(),
+		client:    callbackClient(len(callbackHosts) == 0),
+		jobs:      make(map[string]*asyncJob),
+	}
+	if len(callbackHosts) > 0 {
+		s.callbackHosts = make(map[string]bool, len(callbackHosts))
+		for _, host := range callbackHosts {
+			s.callbackHosts[strings.ToLower(host)] = true
+		}
+	}
+	for range workers {
+		s.workers.Go(func(ctx context.Context) {
+			for job := range s.queue {
+				h.runJob(ctx, job)
+			}
+		})
+	}
+	h.async = s
+}
+
+// DrainAsync stops accepting jobs and lets the workers finish the queue
+// until ctx ends; jobs still running then are cancelled and fail. It
+// reports the number of workers that had to be cancelled. DrainAsync is a
+// shutdown hook.
+func (h *Handler) DrainAsync(ctx context.Context) (int, error) {
+	s := h.async
+	if s == nil {
+		return 0, nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	return s.workers.Drain(ctx)
+}
+
+func (h *Handler) handleAsyncChatCompletions(w http.ResponseWriter, r *http.Request) {
+	proxyReq, ok := h.prepareRequest(w, r)
+	if !ok {
+		return
+	}
+	if proxyReq.ChatRequest.Stream {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "async requests cannot stream")
+		return
+	}
+	callback := r.Header.Get("X-QLite-Callback-URL")
+	if callback != "" {
+		if !h.async.callbacks {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "X-QLite-Callback-URL is not enabled on this server")
+			return
+		}
+		if err := h.async.checkCallback(r.Context(), callback); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+	}
+
+	// The job runs on its own copy, which the submission's debug bundle
+	// doesn't see. Its latency budget starts when a worker picks it up, not
+	// while it waits in the queue.
+	req := *proxyReq
+	req.LatencyDeadline = time.Time{}
+	maxLatency, _ := parseMaxLatency(r.Header.Get("X-QLite-Max-Latency"))
+
+	job := &asyncJob{
+		ID:         "job_" + rand.Text(),
+		Object:     "chat.completion.job",
+		Status:     JobQueued,
+		CreatedAt:  h.now().Unix(),
+		req:        &req,
+		callback:   callback,
+		maxLatency: maxLatency,
+	}
+	if !h.async.enqueue(job, h.now()) {
+		w.Header().Set("Retry-After", "1")
+		writeErrorCode(w, http.StatusTooManyRequests, "rate_limit_error", "async_queue_full", "the async job queue is full")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/async/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(h.async.snapshot(job))
+}
+
+// handleAsyncJob returns a job's state, and its result once it finished.
+// Only the API key that queued a job can see it.
+func (h *Handler) handleAsyncJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.async.get(r.PathValue("id"), h.now())
+	if !ok || job.req.APIKey != extractAPIKey(r) {
+		writeErrorCode(w, http.StatusNotFound, "invalid_request_error", "job_not_found", "no async job with this ID")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// runJob runs job through the pipeline and delivers it to its callback.
+func (h *Handler) runJob(ctx context.Context, job *asyncJob) {
+	h.async.update(job, func(j *asyncJob) { j.Status = JobRunning })
+	if job.maxLatency > 0 {
+		job.req.LatencyDeadline = h.now().Add(job.maxLatency)
+	}
+	resp, err := h.execute(ctx, job.req)
+	finished := h.async.update(job, func(j *asyncJob) {
+		j.CompletedAt = h.now().Unix()
+		j.expiresAt = h.now().Add(h.async.ttl)
+		if err != nil {
+			_, errType, code := upstreamErrorKind(err)
+			j.Status = JobFailed
+			j.Error = &model.ErrorDetail{Message: err.Error(), Type: errType, Code: code}
+			return
+		}
+		j.Status = JobSucceeded
+		j.Cache = resp.CacheStatus
+		j.Provider = resp.ProviderName
+		j.Cost = resp.Cost
+		j.Response = resp.ChatResponse
+	})
+	if job.callback != "" {
+		h.deliverJob(ctx, finished)
+	}
+}
+
+// deliverJob POSTs a finished job to its callback URL, once.
+func (h *Handler) deliverJob(ctx context.Context, job asyncJob) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.callback, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.async.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("callback answered %s", resp.Status)
+		}
+	}
+	if err != nil {
+		h.logger.Warn("async job callback failed", "job", job.ID, "request_id", job.req.RequestID, "error", err)
+	}
+}
+
+// checkCallback returns an error for the client if a job may not be
+// delivered to the callback URL raw. With callback hosts configured only
+// those are allowed; otherwise every address of the host must be public, so
+// clients cannot have qlite call loopback, private networks or cloud
+// metadata endpoints.
+func (s *asyncStore) checkCallback(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("X-QLite-Callback-URL must be an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if s.callbackHosts != nil {
+		if !s.callbackHosts[host] {
+			return fmt.Errorf("X-QLite-Callback-URL host %q is not allowed", host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("X-QLite-Callback-URL host %q cannot be resolved", host)
+	}
+	for _, a := range addrs {
+		if !publicAddr(a) {
+			return fmt.Errorf("X-QLite-Callback-URL host %q is not a public address", host)
+		}
+	}
+	return nil
+}
+
+// callbackClient returns the client delivering callbacks. With
+// publicOnly, connections to non-public addresses are refused when dialing,
+// so a host that resolves differently after checkCallback, or a redirect,
+// cannot reach them either.
+func callbackClient(publicOnly bool) *http.Client {
+	if !publicOnly {
+		return &http.Client{Timeout: callbackTimeout}
+	}
+	dialer := &net.Dialer{Control: func(_, address string, _ syscall.RawConn) error {
+		if ap, err := netip.ParseAddrPort(address); err != nil || !publicAddr(ap.Addr()) {
+			return fmt.Errorf("callback address %s is not public", address)
+		}
+		return nil
+	}}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil // the dialed address must be the callback's own
+	t.DialContext = dialer.DialContext
+	return &http.Client{Timeout: callbackTimeout, Transport: t}
+}
+
+// sharedAddrs is the carrier-grade NAT range, not routable on the internet.
+var sharedAddrs = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether a is a globally routable unicast address.
+func publicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddrs.Contains(a)
+}
+
+// enqueue stores job and queues it, unless the queue is full or closed.
+func (s *asyncStore) enqueue(job *asyncJob, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if s.closed {
+		return false
+	}
+	select {
+	case s.queue <- job:
+	default:
+		return false
+	}
+	s.jobs[job.ID] = job
+	return true
+}
+
+// update applies fn to job under the lock and returns a copy of the result.
+func (s *asyncStore) update(job *asyncJob, fn func(*asyncJob)) asyncJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(job)
+	return *job
+}
+
+// snapshot returns a copy of job safe to read without the lock.
+func (s *asyncStore) snapshot(job *asyncJob) asyncJob {
+	return s.update(job, func(*asyncJob) {})
+}
+
+// get returns a copy of the job with the given ID, pruning expired jobs.
+func (s *asyncStore) get(id string, now time.Time) (asyncJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	j, ok := s.jobs[id]
+	if !ok || j.expired(now) {
+		return asyncJob{}, false
+	}
+	return *j, true
+}
+
+// prune drops expired jobs, at most once per ttl. s.mu must be held.
+func (s *asyncStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) <= s.ttl {
+		return
+	}
+	for id, j := range s.jobs {
+		if j.expired(now) {
+			delete(s.jobs, id)
+		}
+	}
+	s.lastPrune = now
+}
+
+// expired reports whether a finished job's retention has passed.
+func (j *asyncJob) expired(now time.Time) bool {
+	return !j.expiresAt.IsZero() && now.After(j.expiresAt)
+}
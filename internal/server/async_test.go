@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestHandler_AsyncJob(t *testing.T) {
+	release := make(chan struct{})
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-async",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "summary"}, FinishReason: "stop"}},
+			Usage:   model.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+		})
+	}))
+	defer mockSrv.Close()
+
+	callbacks := make(chan asyncJob, 1)
+	callbackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job asyncJob
+		json.NewDecoder(r.Body).Decode(&job)
+		callbacks <- job
+	}))
+	defer callbackSrv.Close()
+
+	h := setupTestHandler(t, mockSrv)
+	h.SetAsync(1, 1, time.Hour, true, []string{"127.0.0.1"})
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	submit := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/async/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"summarize"}]}`))
+		req.Header.Set("Authorization", "Bearer client-key")
+		req.Header.Set("X-QLite-Callback-URL", callbackSrv.URL)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	poll := func(id, key string) (int, asyncJob) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/async/jobs/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var job asyncJob
+		json.NewDecoder(rec.Body).Decode(&job)
+		return rec.Code, job
+	}
+
+	rec := submit()
+	var job asyncJob
+	if rec.Code != http.StatusAccepted || json.NewDecoder(rec.Body).Decode(&job) != nil || !strings.HasPrefix(job.ID, "job_") {
+		t.Fatalf("expected an accepted job, got %d %s", rec.Code, rec.Body.String())
+	}
+	if job.Status != JobQueued || rec.Header().Get("Location") != "/v1/async/jobs/"+job.ID {
+		t.Errorf("unexpected job %+v (Location %q)", job, rec.Header().Get("Location"))
+	}
+	if code, _ := poll(job.ID, "other-key"); code != http.StatusNotFound {
+		t.Errorf("expected another API key not to see the job, got %d", code)
+	}
+
+	// One worker busy with the first job and one queued fill the queue.
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, j := poll(job.ID, "client-key"); j.Status == JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job never started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rec := submit(); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the second job to be queued, got %d", rec.Code)
+	}
+	if rec := submit(); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "async_queue_full") {
+		t.Errorf("expected a full queue to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+	close(release)
+
+	select {
+	case got := <-callbacks:
+		if got.ID != job.ID || got.Status != JobSucceeded || got.Response == nil || got.Response.ID != "chatcmpl-async" {
+			t.Errorf("unexpected callback %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the finished job to be delivered to its callback")
+	}
+	code, got := poll(job.ID, "client-key")
+	if code != http.StatusOK || got.Status != JobSucceeded || got.Response.Choices[0].Message.Content != "summary" || got.Provider != "test" {
+		t.Errorf("unexpected polled job %d %+v", code, got)
+	}
+	if h.Stats().Requests == 0 {
+		t.Error("expected async jobs to be counted as requests")
+	}
+
+	if dropped, err := h.DrainAsync(context.Background()); dropped != 0 || err != nil {
+		t.Errorf("expected the queue to drain, got %d, %v", dropped, err)
+	}
+	if rec := submit(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected jobs to be refused after draining, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AsyncRejectsStreaming(t *testing.T) {
+	mockSrv := httptest.NewServer(http.NotFoundHandler())
+	defer mockSrv.Close()
+	h := setupTestHandler(t, mockSrv)
+	h.SetAsync(1, 1, time.Hour, false, nil)
+	defer h.DrainAsync(context.Background())
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	for _, tc := range []struct {
+		body, callback string
+	}{
+		{`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`, ""},
+		{`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, "http://example.com/done"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/async/chat/completions", strings.NewReader(tc.body))
+		if tc.callback != "" {
+			req.Header.Set("X-QLite-Callback-URL", tc.callback)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s (callback %q): expected 400, got %d", tc.body, tc.callback, rec.Code)
+		}
+	}
+}
+
+func TestHandler_AsyncCallbackAddresses(t *testing.T) {
+	mockSrv := httptest.NewServer(http.NotFoundHandler())
+	defer mockSrv.Close()
+
+	for _, tc := range []struct {
+		hosts    []string
+		callback string
+		ok       bool
+	}{
+		{nil, "http://127.0.0.1:8081/admin/config/apply", false},
+		{nil, "http://169.254.169.254/latest/meta-data/", false},
+		{nil, "http://10.0.0.7/done", false},
+		{nil, "http://[::1]/done", false},
+		{nil, "http://100.100.100.200/done", false},
+		{nil, "http://93.184.215.14/done", true},
+		{[]string{"hooks.internal"}, "http://127.0.0.1/done", false},
+		{[]string{"hooks.internal"}, "https://HOOKS.internal/done", true},
+	} {
+		h := setupTestHandler(t, mockSrv)
+		h.SetAsync(0, 1, time.Hour, true, tc.hosts)
+		mux := http.NewServeMux()
+		h.RegisterRoutes(mux)
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/async/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("X-QLite-Callback-URL", tc.callback)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if got := rec.Code == http.StatusAccepted; got != tc.ok {
+			t.Errorf("%s (hosts %v): expected accepted %v, got %d %s", tc.callback, tc.hosts, tc.ok, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Addresses are checked again when dialing, in case the host resolves
+	// differently by then.
+	h := setupTestHandler(t, mockSrv)
+	h.SetAsync(0, 1, time.Hour, true, nil)
+	if resp, err := h.async.client.Get(mockSrv.URL); err == nil {
+		resp.Body.Close()
+		t.Error("expected the callback client to refuse a loopback address")
+	}
+}
+
+func TestHandler_AsyncLatencyBudgetStartsWithJob(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-async",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	h := setupTestHandler(t, mockSrv)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	h.SetAsync(0, 1, time.Hour, false, nil) // no workers: the job stays queued
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/async/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("X-QLite-Max-Latency", "2s")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var submitted asyncJob
+	if rec.Code != http.StatusAccepted || json.NewDecoder(rec.Body).Decode(&submitted) != nil {
+		t.Fatalf("expected an accepted job, got %d %s", rec.Code, rec.Body.String())
+	}
+	job := h.async.jobs[submitted.ID]
+	if !job.req.LatencyDeadline.IsZero() {
+		t.Error("expected no latency deadline while the job is queued")
+	}
+
+	now = now.Add(time.Minute) // queued for longer than the budget
+	h.runJob(context.Background(), job)
+	if want := now.Add(2 * time.Second); !job.req.LatencyDeadline.Equal(want) {
+		t.Errorf("expected the deadline to count from the job's start, %v, got %v", want, job.req.LatencyDeadline)
+	}
+	if got, _ := h.async.get(job.ID, now); got.Status != JobSucceeded {
+		t.Errorf("expected the job to succeed within its budget, got %+v", got)
+	}
+}
@@ -261,3 +261,39 @@ func TestProxyOverhead_P99_Concurrent(t *testing.T) {
 
 	fmt.Printf("Performance (concurrent): P99 overhead = %v (limit: %v)\n", overhead, maxOverhead)
 }
+
+// BenchmarkHandler_RecordStats measures the request counters under many
+// concurrent requests while an admin poller reads them every millisecond.
+// req/s is the rate the counters alone sustain; it has to stay far above
+// the 10k RPS the proxy is sized for.
+func BenchmarkHandler_RecordStats(b *testing.B) {
+	h := NewHandler(nil, tokenizer.NewCounter(), slog.New(slog.DiscardHandler), nil)
+	req := &model.ProxyRequest{ChatRequest: model.ChatRequest{Model: "gpt-4o"}}
+	resp := &model.ProxyResponse{CacheStatus: "HIT", Cost: 0.0001}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				h.Stats()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.record(req, resp)
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+	if got := h.Stats().Requests; got != uint64(b.N) {
+		b.Fatalf("counted %d requests, want %d", got, b.N)
+	}
+}
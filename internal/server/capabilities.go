@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/eduardmaghakyan/qlite/internal/catalog"
+)
+
+// ProviderModel is one provider's catalog entry for a model.
+type ProviderModel struct {
+	Provider string
+	catalog.Model
+}
+
+// SetCapabilities checks requests that send tools or image parts against
+// the catalog entries models returns, one per provider serving a model.
+// When some of those providers are known not to support the request, it is
+// pinned to the first one that may; when none may, it is rejected with a
+// 400 and the code unsupported_capability. Must be called before serving.
+func (h *Handler) SetCapabilities(models func(model string) []ProviderModel) {
+	h.capabilities = models
+}
+
+// requestNeeds are the request features some models don't support.
+type requestNeeds struct {
+	tools  bool
+	vision bool
+}
+
+// needsOf reports which features the raw request body uses: tools (or the
+// legacy functions), and image content parts.
+func needsOf(body []byte) requestNeeds {
+	var req struct {
+		Tools     []json.RawMessage `json:"tools"`
+		Functions []json.RawMessage `json:"functions"`
+		Messages  []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return requestNeeds{}
+	}
+	needs := requestNeeds{tools: len(req.Tools) > 0 || len(req.Functions) > 0}
+	for _, m := range req.Messages {
+		var parts []struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(m.Content, &parts) != nil {
+			continue
+		}
+		for _, p := range parts {
+			if p.Type == "image_url" || p.Type == "image" {
+				needs.vision = true
+			}
+		}
+	}
+	return needs
+}
+
+// String names the features, for error messages.
+func (n requestNeeds) String() string {
+	switch {
+	case n.tools && n.vision:
+		return "tools and image input"
+	case n.tools:
+		return "tools"
+	}
+	return "image input"
+}
+
+// routeCapable returns the provider a request for model needing needs must
+// be pinned to, "" to leave routing alone, or an error if no provider
+// serving the model supports it. forced is the provider the client pinned.
+func (h *Handler) routeCapable(modelName, forced string, needs requestNeeds) (string, error) {
+	if h.capabilities == nil || !needs.tools && !needs.vision {
+		return "", nil
+	}
+	var serving int
+	var capable []string
+	for _, m := range h.capabilities(modelName) {
+		if forced != "" && m.Provider != forced {
+			continue
+		}
+		serving++
+		if !m.Lacks(needs.tools, needs.vision) {
+			capable = append(capable, m.Provider)
+		}
+	}
+	switch {
+	case serving == 0:
+		// Unknown model or provider: dispatch reports it.
+		return "", nil
+	case len(capable) == 0:
+		return "", fmt.Errorf("model %s does not support %s", modelName, needs)
+	case len(capable) < serving:
+		return capable[0], nil
+	}
+	return "", nil
+}
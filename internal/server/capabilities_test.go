@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/catalog"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestHandler_Capabilities(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-ok",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	no, yes := false, true
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetCapabilities(func(m string) []ProviderModel {
+		switch m {
+		case "gpt-4o-mini":
+			return []ProviderModel{{Provider: "test", Model: catalog.Model{Name: m, SupportsTools: &no, SupportsVision: &no}}}
+		case "gpt-4o":
+			return []ProviderModel{{Provider: "test", Model: catalog.Model{Name: m, SupportsTools: &yes}}}
+		}
+		return nil
+	})
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	const (
+		tools = `,"tools":[{"type":"function","function":{"name":"f"}}]`
+		image = `[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AA=="}}]`
+	)
+	tests := []struct {
+		name, model, content, extra string
+		wantStatus                  int
+		wantCode                    string
+	}{
+		{"tools on a model without them", "gpt-4o-mini", `"hi"`, tools, http.StatusBadRequest, "unsupported_capability"},
+		{"tools on a model with them", "gpt-4o", `"hi"`, tools, http.StatusOK, ""},
+		{"no tools", "gpt-4o-mini", `"hi"`, "", http.StatusOK, ""},
+		{"image on a model without vision", "gpt-4o-mini", image, "", http.StatusBadRequest, "unsupported_capability"},
+		{"image on a model of unknown vision", "gpt-4o", image, "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model":"` + tt.model + `","messages":[{"role":"user","content":` + tt.content + `}]` + tt.extra + `}`
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+			var errResp model.ErrorResponse
+			json.Unmarshal(rec.Body.Bytes(), &errResp)
+			if errResp.Error.Code != tt.wantCode {
+				t.Errorf("unexpected error: %+v", errResp.Error)
+			}
+		})
+	}
+}
+
+func TestRouteCapable(t *testing.T) {
+	no := false
+	h := &Handler{}
+	h.SetCapabilities(func(m string) []ProviderModel {
+		return []ProviderModel{
+			{Provider: "plain", Model: catalog.Model{Name: m, SupportsTools: &no}},
+			{Provider: "full", Model: catalog.Model{Name: m}},
+		}
+	})
+	tools := requestNeeds{tools: true}
+
+	if pin, err := h.routeCapable("m", "", tools); err != nil || pin != "full" {
+		t.Errorf("expected the request pinned to the capable provider, got %q, %v", pin, err)
+	}
+	if pin, err := h.routeCapable("m", "", requestNeeds{}); err != nil || pin != "" {
+		t.Errorf("expected routing left alone without tools, got %q, %v", pin, err)
+	}
+	if _, err := h.routeCapable("m", "plain", tools); err == nil {
+		t.Error("expected a pinned provider without tools to be rejected")
+	}
+	if pin, err := h.routeCapable("m", "full", tools); err != nil || pin != "" {
+		t.Errorf("expected a pinned capable provider kept, got %q, %v", pin, err)
+	}
+	if pin, err := h.routeCapable("m", "missing", tools); err != nil || pin != "" {
+		t.Errorf("expected an unknown pinned provider left to dispatch, got %q, %v", pin, err)
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+)
+
+// maxDebugContent caps each message kept in a bundle when content is
+// included.
+const maxDebugContent = 4 << 10
+
+// DebugLog keeps a diagnostic bundle for each of the most recent chat
+// requests in a fixed-size ring, so "why was this a MISS?" can be answered
+// after the fact from the request ID alone. Prompt content is replaced by its
+// length unless includeContent is set; API keys are never kept.
+type DebugLog struct {
+	includeContent bool
+
+	mu   sync.Mutex
+	ring []string // request IDs, oldest overwritten first
+	next int
+	byID map[string]*DebugBundle
+}
+
+// DebugBundle is everything recorded about one request.
+type DebugBundle struct {
+	RequestID  string                  `json:"request_id"`
+	Time       time.Time               `json:"time"`
+	DurationMs float64                 `json:"duration_ms"`
+	Status     int                     `json:"status"`
+	Cache      string                  `json:"cache,omitempty"`
+	Provider   string                  `json:"provider,omitempty"`
+	Request    *DebugRequest           `json:"request,omitempty"`
+	Stages     []pipeline.StageTiming  `json:"stages"`
+	Decisions  []pipeline.Decision     `json:"decisions"`
+	Upstream   []pipeline.UpstreamCall `json:"upstream"`
+
+	// replay is the full request, kept only when content is included.
+	replay *ReplayRecord
+}
+
+// DebugRequest is a sanitized chat request.
+type DebugRequest struct {
+	Model       string            `json:"model"`
+	Stream      bool              `json:"stream"`
+	Temperature *float64          `json:"temperature,omitempty"`
+	TopP        *float64          `json:"top_p,omitempty"`
+	Seed        *int              `json:"seed,omitempty"`
+	MaxTokens   *int              `json:"max_tokens,omitempty"`
+	Messages    []model.Message   `json:"messages"`
+	CacheKey    string            `json:"cache_key,omitempty"`
+	Provider    string            `json:"provider_override,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// Metadata names the model.Metadata keys stages set on the request.
+	// Values are left out: they may hold prompt content.
+	Metadata []string `json:"metadata,omitempty"`
+}
+
+// NewDebugLog creates a log keeping the last size requests.
+func NewDebugLog(size int, includeContent bool) *DebugLog {
+	return &DebugLog{
+		includeContent: includeContent,
+		ring:           make([]string, size),
+		byID:           make(map[string]*DebugBundle, size),
+	}
+}
+
+type debugKey struct{}
+
+// debugRecord is the bundle being built for an in-flight request.
+type debugRecord struct {
+	bundle *DebugBundle
+	req    *model.ProxyRequest
+}
+
+// Wrap records a bundle for every request next serves. The bundle is
+// stored once next returns, so in-flight requests are not visible.
+func (d *DebugLog) Wrap(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := &DebugBundle{RequestID: GetRequestID(r.Context()), Time: time.Now()}
+		rec := &debugRecord{bundle: b}
+		trace := &pipeline.Trace{}
+		ctx := pipeline.WithTrace(context.WithValue(r.Context(), debugKey{}, rec), trace)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		b.DurationMs = float64(time.Since(b.Time)) / float64(time.Millisecond)
+		b.Status = sw.status
+		b.Cache = w.Header().Get("X-Cache")
+		b.Provider = w.Header().Get("X-Provider")
+		b.Stages = trace.Stages()
+		b.Decisions = trace.Decisions()
+		b.Upstream = trace.UpstreamCalls()
+		if rec.req != nil {
+			// Only known once CacheStage has run.
+			b.Request.CacheKey = rec.req.CacheKey
+			b.Request.Metadata = rec.req.Meta.Names()
+		}
+		d.add(b)
+	})
+}
+
+// setRequest attaches a sanitized copy of req to the bundle being recorded
+// for ctx, if any. Call it once req is final, before the pipeline runs.
+func (d *DebugLog) setRequest(ctx context.Context, req *model.ProxyRequest) {
+	rec, ok := ctx.Value(debugKey{}).(*debugRecord)
+	if d == nil || !ok {
+		return
+	}
+	c := &req.ChatRequest
+	dr := &DebugRequest{
+		Model:       c.Model,
+		Stream:      c.Stream,
+		Temperature: c.Temperature,
+		TopP:        c.TopP,
+		Seed:        c.Seed,
+		MaxTokens:   c.MaxOutputTokens(),
+		Messages:    make([]model.Message, len(c.Messages)),
+		Provider:    req.Provider,
+		Tags:        req.Tags,
+	}
+	for i, m := range c.Messages {
+		dr.Messages[i] = model.Message{Role: m.Role, Content: d.sanitize(m.Content)}
+	}
+	rec.bundle.Request = dr
+	rec.req = req
+	if d.includeContent {
+		rec.bundle.replay = newReplayRecord(req)
+	}
+}
+
+func (d *DebugLog) sanitize(content string) string {
+	if !d.includeContent {
+		return fmt.Sprintf("[%d chars]", len(content))
+	}
+	if len(content) > maxDebugContent {
+		return content[:maxDebugContent] + "…"
+	}
+	return content
+}
+
+func (d *DebugLog) add(b *DebugBundle) {
+	if b.RequestID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old := d.ring[d.next]; old != "" {
+		delete(d.byID, old)
+	}
+	d.ring[d.next] = b.RequestID
+	d.byID[b.RequestID] = b
+	d.next = (d.next + 1) % len(d.ring)
+}
+
+// Get returns the bundle recorded for a request ID.
+func (d *DebugLog) Get(id string) (*DebugBundle, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.byID[id]
+	return b, ok
+}
+
+// Replayable returns the recorded request for id in replayable form. ok is
+// false if no bundle is kept for id; rec is nil if content wasn't recorded.
+func (d *DebugLog) Replayable(id string) (rec *ReplayRecord, ok bool) {
+	if d == nil {
+		return nil, false
+	}
+	b, ok := d.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return b.replay, true
+}
+
+// ServeExport serves GET /admin/debug/export: every kept request that can be
+// replayed, oldest first, as JSON lines accepted by POST /admin/replay.
+func (d *DebugLog) ServeExport(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	var recs []*ReplayRecord
+	for i := range d.ring {
+		id := d.ring[(d.next+i)%len(d.ring)]
+		if b := d.byID[id]; b != nil && b.replay != nil {
+			recs = append(recs, b.replay)
+		}
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		enc.Encode(rec)
+	}
+}
+
+// ServeHTTP serves GET /admin/debug/requests/{id}.
+func (d *DebugLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, ok := d.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no debug bundle for request (unknown, in flight or already evicted)", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestDebugLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-1",
+			Model:   "gpt-4o",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+			Usage:   model.Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	exact := cache.New(time.Hour, 100)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact)
+	debug := NewDebugLog(2, false)
+	h.SetDebugLog(debug)
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	mux.Handle("GET /admin/debug/requests/{id}", debug)
+	srv := Chain(mux, RequestID)
+
+	send := func(content string) string {
+		body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"` + content + `"}]}`
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chat: %d %s", rec.Code, rec.Body.String())
+		}
+		return rec.Header().Get("X-Request-ID")
+	}
+	bundle := func(id string) (*DebugBundle, int) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/requests/"+id, nil))
+		if rec.Code != http.StatusOK {
+			return nil, rec.Code
+		}
+		var b DebugBundle
+		if err := json.NewDecoder(rec.Body).Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+		return &b, rec.Code
+	}
+
+	miss := send("secret")
+	b, _ := bundle(miss)
+	if b == nil {
+		t.Fatal("no bundle for first request")
+	}
+	if b.Status != http.StatusOK || b.Cache != "MISS" || b.Provider != "test" {
+		t.Errorf("unexpected outcome: %+v", b)
+	}
+	if got := b.Request.Messages[0].Content; got != "[6 chars]" {
+		t.Errorf("content not redacted: %q", got)
+	}
+	if b.Request.CacheKey == "" {
+		t.Error("expected cache key")
+	}
+	if len(b.Stages) != 2 || b.Stages[0].Stage != "cache" || b.Stages[0].Outcome != "pass" || b.Stages[1].Outcome != "response" {
+		t.Errorf("unexpected stages: %+v", b.Stages)
+	}
+	if len(b.Decisions) == 0 || !strings.HasPrefix(b.Decisions[0].Detail, "miss key=") {
+		t.Errorf("unexpected decisions: %+v", b.Decisions)
+	}
+	if len(b.Upstream) != 1 || b.Upstream[0].Provider != "test" || b.Upstream[0].Error != "" {
+		t.Errorf("unexpected upstream calls: %+v", b.Upstream)
+	}
+
+	hit := send("secret")
+	if b, _ := bundle(hit); b == nil || b.Cache != "HIT" || len(b.Upstream) != 0 {
+		t.Errorf("expected cache hit bundle, got %+v", b)
+	}
+
+	// The ring holds two bundles; a third request evicts the first.
+	send("other")
+	if _, code := bundle(miss); code != http.StatusNotFound {
+		t.Errorf("expected evicted bundle to 404, got %d", code)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// What happens to requests for a deprecated model once its sunset date has
+// passed.
+const (
+	SunsetReject  = "reject"
+	SunsetRewrite = "rewrite"
+)
+
+// ModelDeprecation marks Model as deprecated, with Replacement as the
+// suggested successor. Requests for it are served with an
+// X-QLite-Deprecation header until Sunset; from then on they are rejected
+// or, with Action SunsetRewrite, sent to Replacement instead. A zero Sunset
+// is never enforced.
+type ModelDeprecation struct {
+	Model       string
+	Replacement string
+	Sunset      time.Time
+	Action      string
+}
+
+// SetDeprecations configures deprecated models. With logRequests every
+// request for one is logged. Must be called before serving.
+func (h *Handler) SetDeprecations(ds []ModelDeprecation, logRequests bool) {
+	h.deprecations = make(map[string]ModelDeprecation, len(ds))
+	for _, d := range ds {
+		h.deprecations[d.Model] = d
+	}
+	h.logDeprecated = logRequests
+}
+
+// applyDeprecation handles a request for a deprecated model: it sets the
+// deprecation headers and, past the sunset date, rewrites req.Model or
+// writes a 410 error. It returns false if the request was rejected.
+func (h *Handler) applyDeprecation(w http.ResponseWriter, r *http.Request, req *model.ChatRequest) bool {
+	d, ok := h.deprecations[req.Model]
+	if !ok {
+		return true
+	}
+	h.deprecated.Add(1)
+	sunset := !d.Sunset.IsZero() && !h.now().Before(d.Sunset)
+
+	notice := "model " + d.Model + " is deprecated"
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		verb := "will be retired"
+		if sunset {
+			verb = "was retired"
+		}
+		notice += fmt.Sprintf(" and %s on %s", verb, d.Sunset.Format(time.DateOnly))
+	}
+	if d.Replacement != "" {
+		notice += "; use " + d.Replacement
+	}
+	if sunset && d.Action == SunsetRewrite {
+		notice += "; this request was sent to " + d.Replacement
+	}
+	w.Header().Set("X-QLite-Deprecation", notice)
+	if h.logDeprecated {
+		h.logger.Warn("request for deprecated model",
+			"model", d.Model,
+			"replacement", d.Replacement,
+			"sunset", sunset,
+			"request_id", GetRequestID(r.Context()),
+		)
+	}
+
+	if !sunset {
+		return true
+	}
+	if d.Action == SunsetRewrite {
+		req.Model = d.Replacement
+		return true
+	}
+	writeErrorCode(w, http.StatusGone, "invalid_request_error", "model_deprecated", notice)
+	return false
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestHandler_Deprecations(t *testing.T) {
+	var upstreamModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		upstreamModel = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-1",
+			Model:   req.Model,
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"old", "older", "oldest", "new"}))
+	pipe, err := pipeline.New(pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), nil)
+	h.now = func() time.Time { return time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC) }
+	h.SetDeprecations([]ModelDeprecation{
+		{Model: "old", Replacement: "new", Sunset: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), Action: SunsetReject},
+		{Model: "older", Replacement: "new", Sunset: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), Action: SunsetRewrite},
+		{Model: "oldest", Sunset: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Action: SunsetReject},
+	}, false)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	tests := []struct {
+		model, wantUpstream string
+		wantStatus          int
+		wantNotice          string
+		wantRequested       string
+	}{
+		{"new", "new", http.StatusOK, "", ""},
+		{"old", "old", http.StatusOK, "model old is deprecated and will be retired on 2025-12-31; use new", ""},
+		{"older", "new", http.StatusOK, "model older is deprecated and was retired on 2025-06-30; use new; this request was sent to new", "older"},
+		{"oldest", "", http.StatusGone, "model oldest is deprecated and was retired on 2025-01-01", ""},
+	}
+	for _, tt := range tests {
+		upstreamModel = ""
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+			strings.NewReader(`{"model":"`+tt.model+`","messages":[{"role":"user","content":"hello"}]}`)))
+		if rec.Code != tt.wantStatus || upstreamModel != tt.wantUpstream {
+			t.Errorf("%s: status %d, upstream model %q; want %d, %q", tt.model, rec.Code, upstreamModel, tt.wantStatus, tt.wantUpstream)
+		}
+		if got := rec.Header().Get("X-QLite-Deprecation"); got != tt.wantNotice {
+			t.Errorf("%s: X-QLite-Deprecation = %q, want %q", tt.model, got, tt.wantNotice)
+		}
+		if tt.wantNotice != "" && rec.Header().Get("Sunset") == "" {
+			t.Errorf("%s: missing Sunset header", tt.model)
+		}
+		if got := rec.Header().Get("X-Requested-Model"); got != tt.wantRequested {
+			t.Errorf("%s: X-Requested-Model = %q, want %q", tt.model, got, tt.wantRequested)
+		}
+	}
+	if got := h.Stats().Deprecated; got != 3 {
+		t.Errorf("Deprecated = %d, want 3", got)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// feedbackRequest is the body of POST /v1/feedback.
+type feedbackRequest struct {
+	RequestID string `json:"request_id"`
+	Rating    int    `json:"rating"`
+}
+
+// SetFeedback enables POST /v1/feedback, through which clients rate the
+// answer a request was served, by the X-Request-ID it was answered with.
+// Completed requests are remembered in f. Must be called before
+// RegisterRoutes. nil disables feedback.
+func (h *Handler) SetFeedback(f *cache.Feedback) {
+	h.feedback = f
+}
+
+func (h *Handler) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse request body: "+err.Error())
+		return
+	}
+	if req.RequestID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "request_id is required")
+		return
+	}
+	if req.Rating != 1 && req.Rating != -1 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "rating must be 1 or -1")
+		return
+	}
+
+	excluded, err := h.feedback.Rate(req.RequestID, extractAPIKey(r), req.Rating)
+	if errors.Is(err, cache.ErrUnknownRequest) {
+		writeErrorCode(w, http.StatusNotFound, "invalid_request_error", "request_not_found", "no rateable request with this ID")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		RequestID string `json:"request_id"`
+		Rating    int    `json:"rating"`
+		Excluded  bool   `json:"excluded"`
+	}{req.RequestID, req.Rating, excluded})
+}
+
+// streamedAnswer assembles the choices of the events relayed to a client,
+// so a streamed miss, which the pipeline returns without a response, can
+// be rated like any other answer.
+type streamedAnswer struct {
+	sse.Writer
+	choices []model.Choice
+}
+
+func (a *streamedAnswer) WriteEvent(data []byte) error {
+	var chunk model.ChatStreamChunk
+	if json.Unmarshal(data, &chunk) == nil {
+		for _, c := range chunk.Choices {
+			if c.Index < 0 {
+				continue
+			}
+			for len(a.choices) <= c.Index {
+				a.choices = append(a.choices, model.Choice{Index: len(a.choices), Message: model.Message{Role: "assistant"}})
+			}
+			choice := &a.choices[c.Index]
+			if c.Delta.Role != "" {
+				choice.Message.Role = c.Delta.Role
+			}
+			choice.Message.Content += c.Delta.Content
+			choice.Message.ReasoningContent += c.Delta.ReasoningContent
+			if c.FinishReason != "" {
+				choice.FinishReason = c.FinishReason
+			}
+		}
+	}
+	return a.Writer.WriteEvent(data)
+}
+
+func (a *streamedAnswer) WriteComment(text string) error {
+	if cw, ok := a.Writer.(sse.CommentWriter); ok {
+		return cw.WriteComment(text)
+	}
+	return nil
+}
+
+// response returns the answer relayed so far, nil if no choice was.
+func (a *streamedAnswer) response() *model.ChatResponse {
+	if len(a.choices) == 0 {
+		return nil
+	}
+	return &model.ChatResponse{Choices: a.choices}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+// ContextGuard rejects, or truncates, requests whose estimated input plus
+// max_tokens would not fit the model's context window, so they fail fast
+// with context_length_exceeded instead of costing an upstream round trip.
+type ContextGuard struct {
+	counter  *tokenizer.Counter
+	windows  map[string]int
+	truncate bool
+}
+
+// NewContextGuard creates a guard for the given per-model windows. With
+// truncate set, the oldest non-system messages are dropped until the request
+// fits; the last message is always kept.
+func NewContextGuard(counter *tokenizer.Counter, windows map[string]int, truncate bool) *ContextGuard {
+	return &ContextGuard{counter: counter, windows: windows, truncate: truncate}
+}
+
+// contextLengthError is returned when a request cannot fit its model's window.
+type contextLengthError struct {
+	window  int
+	tokens  int
+	reserve int
+}
+
+func (e *contextLengthError) Error() string {
+	return fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+		e.window, e.tokens+e.reserve, e.tokens, e.reserve)
+}
+
+// Apply checks req, with the hidden prompts dispatch will add to it,
+// against its model's window, truncating req.Messages in place when
+// enabled. Models without a known window are not checked.
+func (g *ContextGuard) Apply(req *model.ChatRequest, hidden []model.InjectedPrompt) error {
+	window, ok := g.windows[req.Model]
+	if !ok || window <= 0 {
+		return nil
+	}
+	reserve := 0
+	if n := req.MaxOutputTokens(); n != nil {
+		reserve = *n
+	}
+	limit := window - reserve
+
+	// The len/4 estimate is cheap; only pay for tiktoken when the request
+	// is anywhere near the limit.
+	if g.counter.QuickEstimate(withHidden(req.Messages, hidden))*2 < limit {
+		return nil
+	}
+	tokens := g.counter.CountMessages(req.Model, withHidden(req.Messages, hidden))
+	if tokens <= limit {
+		return nil
+	}
+	if g.truncate {
+		msgs, n, ok := g.truncateToFit(req.Model, req.Messages, hidden, limit)
+		if ok {
+			req.Messages = msgs
+			return nil
+		}
+		tokens = n
+	}
+	return &contextLengthError{window: window, tokens: tokens, reserve: reserve}
+}
+
+// truncateToFit drops the oldest non-system messages (never the last one)
+// until the conversation, with the hidden prompts, fits limit. It returns
+// the token count reached if nothing more can be dropped.
+func (g *ContextGuard) truncateToFit(modelName string, messages []model.Message, hidden []model.InjectedPrompt, limit int) ([]model.Message, int, bool) {
+	msgs := append([]model.Message(nil), messages...)
+	for {
+		tokens := g.counter.CountMessages(modelName, withHidden(msgs, hidden))
+		if tokens <= limit {
+			return msgs, tokens, true
+		}
+		drop := -1
+		for i := 0; i < len(msgs)-1; i++ {
+			if msgs[i].Role != "system" {
+				drop = i
+				break
+			}
+		}
+		if drop < 0 {
+			return nil, tokens, false
+		}
+		msgs = append(msgs[:drop], msgs[drop+1:]...)
+	}
+}
+
+// withHidden returns msgs as sent upstream, with the hidden prompts added.
+func withHidden(msgs []model.Message, hidden []model.InjectedPrompt) []model.Message {
+	if len(hidden) == 0 {
+		return msgs
+	}
+	return model.InjectPrompts(msgs, hidden)
+}
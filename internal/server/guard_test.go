@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func longText(words int) string {
+	return strings.Repeat("hello ", words)
+}
+
+func TestContextGuard_RejectsBeforeUpstream(t *testing.T) {
+	var calls atomic.Int32
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-1",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetContextGuard(NewContextGuard(tokenizer.NewCounter(), map[string]int{"gpt-4o": 100}, false))
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body, _ := json.Marshal(model.ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []model.Message{{Role: "user", Content: longText(200)}},
+	})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(string(body))))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	var errResp model.ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&errResp)
+	if errResp.Error.Code != "context_length_exceeded" {
+		t.Errorf("expected context_length_exceeded, got %+v", errResp.Error)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no upstream call, got %d", calls.Load())
+	}
+
+	// Small requests and unknown models pass through.
+	for _, m := range []string{"gpt-4o", "gpt-4o-mini"} {
+		content := "hi"
+		if m == "gpt-4o-mini" {
+			content = longText(200)
+		}
+		body, _ := json.Marshal(model.ChatRequest{Model: m, Messages: []model.Message{{Role: "user", Content: content}}})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(string(body))))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", m, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestContextGuard_MaxTokensCountsAgainstWindow(t *testing.T) {
+	g := NewContextGuard(tokenizer.NewCounter(), map[string]int{"gpt-4o": 100}, false)
+	maxTokens := 95
+	req := &model.ChatRequest{
+		Model:     "gpt-4o",
+		Messages:  []model.Message{{Role: "user", Content: longText(10)}},
+		MaxTokens: &maxTokens,
+	}
+	if err := g.Apply(req, nil); err == nil {
+		t.Error("expected max_tokens to push the request over the window")
+	}
+}
+
+func TestContextGuard_Truncate(t *testing.T) {
+	g := NewContextGuard(tokenizer.NewCounter(), map[string]int{"gpt-4o": 100}, true)
+	req := &model.ChatRequest{
+		Model: "gpt-4o",
+		Messages: []model.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: longText(80)},
+			{Role: "assistant", Content: longText(80)},
+			{Role: "user", Content: "and now?"},
+		},
+	}
+	if err := g.Apply(req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[1].Content != "and now?" {
+		t.Errorf("expected system and last message kept, got %+v", req.Messages)
+	}
+
+	// The last message alone does not fit: reject rather than drop it.
+	req = &model.ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []model.Message{{Role: "user", Content: longText(200)}},
+	}
+	if err := g.Apply(req, nil); err == nil {
+		t.Error("expected error when the last message exceeds the window")
+	}
+}
+
+func TestContextGuard_CountsHiddenPrompts(t *testing.T) {
+	g := NewContextGuard(tokenizer.NewCounter(), map[string]int{"gpt-4o": 100}, false)
+	req := &model.ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []model.Message{{Role: "user", Content: longText(60)}},
+	}
+	if err := g.Apply(req, nil); err != nil {
+		t.Fatalf("expected the request alone to fit, got %v", err)
+	}
+	hidden := []model.InjectedPrompt{{Content: longText(60)}}
+	if err := g.Apply(req, hidden); err == nil {
+		t.Error("expected hidden prompts to count against the window")
+	}
+}
@@ -1,18 +1,26 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/cache"
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/pipeline"
 	"github.com/eduardmaghakyan/qlite/internal/pricing"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/savings"
 	"github.com/eduardmaghakyan/qlite/internal/sse"
+	"github.com/eduardmaghakyan/qlite/internal/stats"
 	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
 )
 
@@ -22,6 +30,76 @@ type Handler struct {
 	counter  *tokenizer.Counter
 	logger   *slog.Logger
 	cache    *cache.ExactCache
+
+	sseHeartbeat    time.Duration
+	sseMetadata     bool
+	sseBackpressure sse.Backpressure
+	savings         *savings.Rollup
+
+	userHeader      string
+	metadataHeaders map[string]string
+
+	limiter     *Limiter
+	rateLimiter *RateLimiter
+	mirror      *Mirror
+	idempotency *idempotencyStore
+	async       *asyncStore
+	guard       *ContextGuard
+	debug       *DebugLog
+	tokenCounts *remoteTokenCounts
+	feedback    *cache.Feedback
+	storeFilter *cache.StoreFilter
+
+	capabilities func(model string) []ProviderModel
+
+	buildInfo       *BuildInfo
+	features        Features
+	healthBody      []byte
+	semanticEntries func(ctx context.Context) (int, error)
+
+	defaultModel  string
+	systemPrompts []SystemPrompt
+	tenants       map[string]string // API key -> tenant
+	profiles      map[string]Profile
+	schemaMode    string
+	deprecations  map[string]ModelDeprecation
+	logDeprecated bool
+	continuations int
+	now           func() time.Time
+
+	requests    stats.Counter
+	cacheHits   stats.Counter
+	costNanoUSD stats.Counter
+	deprecated  stats.Counter
+	slowClients stats.Counter
+}
+
+// RequestStats are cumulative counters over completed chat requests.
+// Deprecated counts requests for deprecated models, including rejected ones,
+// SlowClients streams abandoned because the client fell behind and
+// RateLimited requests rejected by the rate limiter.
+type RequestStats struct {
+	Requests    uint64  `json:"requests"`
+	CacheHits   uint64  `json:"cache_hits"`
+	Cost        float64 `json:"cost"`
+	Deprecated  uint64  `json:"deprecated"`
+	SlowClients uint64  `json:"slow_clients"`
+	RateLimited uint64  `json:"rate_limited"`
+}
+
+// Stats returns cumulative request counters since startup.
+func (h *Handler) Stats() RequestStats {
+	s := RequestStats{
+		Requests:    h.requests.Load(),
+		CacheHits:   h.cacheHits.Load(),
+		Cost:        float64(h.costNanoUSD.Load()) / 1e9,
+		Deprecated:  h.deprecated.Load(),
+		SlowClients: h.slowClients.Load(),
+	}
+	if h.rateLimiter != nil {
+		s.RateLimited = h.rateLimiter.Rejected()
+	}
+	return s
 }
 
 // NewHandler creates a new request handler. The cache parameter may be nil (disabled).
@@ -31,80 +109,378 @@ func NewHandler(p *pipeline.Pipeline, counter *tokenizer.Counter, logger *slog.L
 		counter:  counter,
 		logger:   logger,
 		cache:    c,
+		now:      time.Now,
 	}
 }
 
+// SetSSEHeartbeat enables ": ping" comments on streaming responses after d of
+// inactivity, starting before the first event. Zero disables heartbeats.
+func (h *Handler) SetSSEHeartbeat(d time.Duration) {
+	h.sseHeartbeat = d
+}
+
+// SetSSEBackpressure queues streamed events for the client instead of
+// relaying them as it reads, so a slow client doesn't hold up the upstream.
+// Each write must finish within writeTimeout, and at most maxBuffered bytes
+// may be queued; a client that falls further behind is disconnected and the
+// upstream cancelled. Zero writeTimeout disables queueing. Must be called
+// before serving.
+func (h *Handler) SetSSEBackpressure(writeTimeout time.Duration, maxBuffered int) {
+	h.sseBackpressure = sse.Backpressure{WriteTimeout: writeTimeout, MaxBuffered: maxBuffered}
+}
+
+// SetSSEMetadata makes streaming responses start with an SSE comment
+// carrying the request ID, provider and cache status, for clients behind
+// intermediaries that strip the X-* headers.
+func (h *Handler) SetSSEMetadata(on bool) {
+	h.sseMetadata = on
+}
+
+// SetSavings enables recording every completed request into the daily
+// savings rollup. nil disables recording.
+func (h *Handler) SetSavings(r *savings.Rollup) {
+	h.savings = r
+}
+
+// SetClientMetadata maps incoming headers onto the upstream request: the
+// value of userHeader becomes the "user" field, and each header in
+// metadataHeaders is copied into request metadata under the mapped key.
+// Header values override those sent in the request body.
+func (h *Handler) SetClientMetadata(userHeader string, metadataHeaders map[string]string) {
+	h.userHeader = userHeader
+	h.metadataHeaders = metadataHeaders
+}
+
+// SetStoreFilter makes the handler count, once per upstream response, the
+// responses f keeps out of the caches (see StoreFilter.Count). Must be
+// called before serving.
+func (h *Handler) SetStoreFilter(f *cache.StoreFilter) {
+	h.storeFilter = f
+}
+
+// SetIdempotency enables Idempotency-Key handling: a retried key within ttl
+// replays the original result instead of calling upstream again. Zero
+// disables it.
+func (h *Handler) SetIdempotency(ttl time.Duration) {
+	if ttl <= 0 {
+		h.idempotency = nil
+		return
+	}
+	h.idempotency = newIdempotencyStore(ttl)
+}
+
+// SetContextGuard checks requests against per-model context windows before
+// they reach the pipeline. nil disables the check.
+func (h *Handler) SetContextGuard(g *ContextGuard) {
+	h.guard = g
+}
+
+// SetDefaultModel sets the model used when a request omits model or asks
+// for "auto". Empty keeps model required.
+func (h *Handler) SetDefaultModel(m string) {
+	h.defaultModel = m
+}
+
+// SetSchemaMode sets how request fields qlite doesn't model, and would drop
+// before forwarding, are handled: SchemaOff ignores them, SchemaPermissive
+// logs them and lists them in X-QLite-Dropped-Fields, SchemaStrict rejects
+// the request. Must be called before serving.
+func (h *Handler) SetSchemaMode(mode string) {
+	h.schemaMode = mode
+}
+
+// SetSystemPrompts configures system prompts added to matching requests.
+// Must be called before serving.
+func (h *Handler) SetSystemPrompts(prompts []SystemPrompt) {
+	h.systemPrompts = prompts
+}
+
+// SetContinuations lets clients opt in, with the X-QLite-Continue header,
+// to having a response cut off by max_tokens continued by up to limit
+// follow-up requests. The header is a number of follow-ups, capped at
+// limit, or "true" for limit. Zero disables continuation. Must be called
+// before serving.
+func (h *Handler) SetContinuations(limit int) {
+	h.continuations = limit
+}
+
+// SetLimiter bounds concurrent chat completions. Must be called before
+// RegisterRoutes. nil disables limiting.
+func (h *Handler) SetLimiter(l *Limiter) {
+	h.limiter = l
+}
+
+// SetMirror replays a sample of chat requests to a sandbox. Must be called
+// before RegisterRoutes. nil disables mirroring.
+func (h *Handler) SetMirror(m *Mirror) {
+	h.mirror = m
+}
+
+// SetDebugLog records a diagnostic bundle for every chat request in d. Must
+// be called before RegisterRoutes. nil disables recording.
+func (h *Handler) SetDebugLog(d *DebugLog) {
+	h.debug = d
+}
+
 // RegisterRoutes registers all HTTP routes on the given mux.
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("POST /v1/chat/completions", h.handleChatCompletions)
+	// chat wraps a handler of chat requests, sync or async, in the
+	// per-request middleware.
+	chat := func(f http.HandlerFunc) http.Handler {
+		return h.debug.Wrap(h.rateLimiter.Wrap(h.limiter.Wrap(h.mirror.Wrap(f))))
+	}
+	mux.Handle("POST /v1/chat/completions", chat(h.handleChatCompletions))
 	mux.HandleFunc("GET /health", h.handleHealth)
+	if h.async != nil {
+		mux.Handle("POST /v1/async/chat/completions", chat(h.handleAsyncChatCompletions))
+		mux.HandleFunc("GET /v1/async/jobs/{id}", h.handleAsyncJob)
+	}
+	if h.feedback != nil {
+		mux.HandleFunc("POST /v1/feedback", h.handleFeedback)
+	}
 }
 
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if h.healthBody != nil {
+		w.Write(h.healthBody)
+		return
+	}
 	fmt.Fprint(w, `{"status":"ok"}`)
 }
 
+// ServeCachedResponse returns the exact-cache entry for the {key} path
+// value, without counting a cache lookup or refreshing the entry. Entries
+// are shared by every client, so it belongs on the admin mux.
+func (h *Handler) ServeCachedResponse(w http.ResponseWriter, r *http.Request) {
+	var entry *cache.Entry
+	ok := false
+	if h.cache != nil {
+		entry, ok = h.cache.Peek(r.PathValue("key"))
+	}
+	if !ok {
+		writeErrorCode(w, http.StatusNotFound, "invalid_request_error", "cache_miss", "no cached response for this key")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Expires", entry.ExpiresAt.UTC().Format(http.TimeFormat))
+	json.NewEncoder(w).Encode(entry.Response)
+}
+
 func (h *Handler) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	proxyReq, ok := h.prepareRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" && h.idempotency != nil {
+		h.handleIdempotent(w, r, proxyReq, key)
+		return
+	}
+
+	if proxyReq.ChatRequest.Stream {
+		h.handleStreaming(w, r, proxyReq, nil)
+	} else {
+		h.handleNonStreaming(w, r, proxyReq)
+	}
+}
+
+// prepareRequest decodes a chat request and applies the request policies
+// (default model, deprecations, system prompts, guardrails, client metadata
+// and the X-QLite-* options). On failure it writes the error and returns
+// false.
+func (h *Handler) prepareRequest(w http.ResponseWriter, r *http.Request) (*model.ProxyRequest, bool) {
 	r.Body = http.MaxBytesReader(w, r.Body, 10<<20) // 10 MB limit
+	body, decodeErr := io.ReadAll(r.Body)
 	var chatReq model.ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&chatReq); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse request body: "+err.Error())
-		return
+	if decodeErr == nil {
+		decodeErr = json.Unmarshal(body, &chatReq)
+	}
+	var needs requestNeeds
+	if h.capabilities != nil {
+		needs = needsOf(body)
+	}
+	// Image parts don't decode into model.Message. Such a request still
+	// fails below, but a model known not to take images is named first.
+	var typeErr *json.UnmarshalTypeError
+	if decodeErr != nil && !(needs.vision && errors.As(decodeErr, &typeErr)) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse request body: "+decodeErr.Error())
+		return nil, false
+	}
+	if decodeErr == nil && !h.checkSchema(w, r, body, &chatReq) {
+		return nil, false
 	}
 
+	requestedModel := chatReq.Model
+	if !h.applyProfile(w, r, &chatReq) {
+		return nil, false
+	}
+	if h.defaultModel != "" && (chatReq.Model == "" || chatReq.Model == "auto") {
+		chatReq.Model = h.defaultModel
+		if requestedModel == "" {
+			requestedModel = "auto"
+		}
+	}
 	if chatReq.Model == "" {
 		writeError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
-		return
+		return nil, false
+	}
+	if !h.applyDeprecation(w, r, &chatReq) {
+		return nil, false
+	}
+	forced := r.Header.Get("X-QLite-Provider")
+	pin, err := h.routeCapable(chatReq.Model, forced, needs)
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, "invalid_request_error", "unsupported_capability", err.Error())
+		return nil, false
+	}
+	if pin != "" {
+		forced = pin
+	}
+	if decodeErr != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse request body: "+decodeErr.Error())
+		return nil, false
 	}
 
 	apiKey := extractAPIKey(r)
+	tags := parseTags(r.Header.Get("X-QLite-Tags"))
+	hiddenPrompts := h.applySystemPrompts(&chatReq, apiKey)
+
+	if h.guard != nil {
+		if err := h.guard.Apply(&chatReq, hiddenPrompts); err != nil {
+			writeErrorCode(w, http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", err.Error())
+			return nil, false
+		}
+	}
+
+	h.applyClientMetadata(r, &chatReq)
 
 	// For non-streaming, skip local token counting — upstream returns accurate Usage.
-	// For streaming, use fast len/4 heuristic to set the X-Tokens-Input header.
+	// For streaming, use fast len/4 heuristic to set the X-Tokens-Input header;
+	// dispatch replaces it when the upstream counts tokens for us.
 	var inputTokens int
 	if chatReq.Stream {
 		inputTokens = h.counter.QuickEstimate(chatReq.Messages)
 	}
 
+	deadline, ok := h.latencyDeadline(w, r.Header.Get("X-QLite-Max-Latency"))
+	if !ok {
+		return nil, false
+	}
+
 	proxyReq := &model.ProxyRequest{
-		ChatRequest: chatReq,
-		RequestID:   GetRequestID(r.Context()),
-		InputTokens: inputTokens,
-		APIKey:      apiKey,
+		ChatRequest:    chatReq,
+		RequestID:      GetRequestID(r.Context()),
+		InputTokens:    inputTokens,
+		APIKey:         apiKey,
+		Tags:           tags,
+		Provider:       forced,
+		RequestedModel: requestedModel,
+
+		HiddenPrompts: hiddenPrompts,
+		Continuations: h.continuationRounds(r.Header.Get("X-QLite-Continue")),
+
+		LatencyDeadline: deadline,
 	}
+	h.debug.setRequest(r.Context(), proxyReq)
+	return proxyReq, true
+}
 
-	if chatReq.Stream {
-		h.handleStreaming(w, r, proxyReq)
-	} else {
-		h.handleNonStreaming(w, r, proxyReq)
+// continuationRounds returns the follow-ups an X-QLite-Continue value asks
+// for, within the configured maximum.
+func (h *Handler) continuationRounds(v string) int {
+	if v == "" || h.continuations == 0 {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return min(max(n, 0), h.continuations)
+	}
+	if on, _ := strconv.ParseBool(v); on {
+		return h.continuations
+	}
+	return 0
+}
+
+// latencyDeadline returns the deadline an X-QLite-Max-Latency value sets,
+// either a duration such as "800ms" or a number of milliseconds. An invalid
+// value is rejected with a 400 error and ok false.
+func (h *Handler) latencyDeadline(w http.ResponseWriter, v string) (_ time.Time, ok bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	d, ok := parseMaxLatency(v)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("X-QLite-Max-Latency must be a positive duration or number of milliseconds, got %q", v))
+		return time.Time{}, false
+	}
+	return h.now().Add(d), true
+}
+
+// parseMaxLatency parses an X-QLite-Max-Latency value.
+func parseMaxLatency(v string) (time.Duration, bool) {
+	d, err := time.ParseDuration(v)
+	if ms, aerr := strconv.Atoi(v); aerr == nil {
+		d, err = time.Duration(ms)*time.Millisecond, nil
 	}
+	return d, err == nil && d > 0
 }
 
-func (h *Handler) handleNonStreaming(w http.ResponseWriter, r *http.Request, proxyReq *model.ProxyRequest) {
-	resp, err := h.pipeline.Execute(r.Context(), proxyReq)
+// handleNonStreaming runs the pipeline and writes the JSON response. It
+// returns the response, or nil if the request failed.
+func (h *Handler) handleNonStreaming(w http.ResponseWriter, r *http.Request, proxyReq *model.ProxyRequest) *model.ProxyResponse {
+	resp, err := h.execute(r.Context(), proxyReq)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return nil
+	}
+	h.writeChatResponse(w, proxyReq, resp)
+	return resp
+}
+
+// execute runs a non-streaming request through the pipeline, caches the
+// response on a miss and records it.
+func (h *Handler) execute(ctx context.Context, proxyReq *model.ProxyRequest) (*model.ProxyResponse, error) {
+	resp, err := h.pipeline.Execute(ctx, proxyReq)
 	if err != nil {
 		h.logger.Error("pipeline error", "error", err, "request_id", proxyReq.RequestID)
-		writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
-		return
+		return nil, err
 	}
 
-	// Store in cache on miss.
-	if h.cache != nil && resp.CacheStatus == "MISS" {
-		if proxyReq.CacheKey != "" {
-			h.cache.PutByKey(proxyReq.CacheKey, resp.ChatResponse)
-		} else {
-			h.cache.Put(&proxyReq.ChatRequest, resp.ChatResponse)
-		}
+	// Store in cache on miss. CacheKey is only set when CacheStage considered
+	// the request cacheable, so bypassed requests never fill the cache.
+	if h.cache != nil && resp.CacheStatus == "MISS" && proxyReq.CacheKey != "" {
+		h.cache.PutByKey(proxyReq.CacheKey, resp.ChatResponse)
+	}
+	if resp.CacheStatus == "MISS" && !proxyReq.NoCache {
+		h.storeFilter.Count(resp.ChatResponse)
 	}
 
+	h.record(proxyReq, resp)
+	return resp, nil
+}
+
+// writeChatResponse writes resp as a JSON chat completion with the X-* cost,
+// token and cache headers.
+func (h *Handler) writeChatResponse(w http.ResponseWriter, proxyReq *model.ProxyRequest, resp *model.ProxyResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Request-Cost", strconv.FormatFloat(resp.Cost, 'f', 8, 64))
 	w.Header().Set("X-Tokens-Input", strconv.Itoa(resp.ChatResponse.Usage.PromptTokens))
 	w.Header().Set("X-Tokens-Output", strconv.Itoa(resp.OutputTokens))
 	w.Header().Set("X-Cache", resp.CacheStatus)
+	if resp.CacheReason != "" {
+		w.Header().Set("X-Cache-Reason", resp.CacheReason)
+	}
 	w.Header().Set("X-Provider", resp.ProviderName)
+	if resp.UpstreamLatency > 0 {
+		w.Header().Set("X-Upstream-Latency-Ms", formatMillis(resp.UpstreamLatency))
+	}
+	if fp := resp.ChatResponse.SystemFingerprint; fp != "" {
+		w.Header().Set("X-QLite-System-Fingerprint", fp)
+	}
+	setRequestedModel(w.Header().Set, proxyReq)
 
 	if resp.CacheStatus == "HIT" {
 		totalTokens := resp.ChatResponse.Usage.PromptTokens + resp.ChatResponse.Usage.CompletionTokens
@@ -113,32 +489,306 @@ func (h *Handler) handleNonStreaming(w http.ResponseWriter, r *http.Request, pro
 		w.Header().Set("X-Cost-Saved", strconv.FormatFloat(costSaved, 'f', 8, 64))
 	}
 
-	if err := json.NewEncoder(w).Encode(resp.ChatResponse); err != nil {
+	body := resp.ChatResponse
+	if body.Model == "" {
+		// The response may be shared with a cache, so it is not modified.
+		c := *body
+		c.Model = proxyReq.ChatRequest.Model
+		body = &c
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		h.logger.Error("failed to write response", "error", err, "request_id", proxyReq.RequestID)
 	}
 }
 
-func (h *Handler) handleStreaming(w http.ResponseWriter, r *http.Request, proxyReq *model.ProxyRequest) {
+// setRequestedModel sets X-Requested-Model to the model the client asked
+// for if the proxy rewrote it, so clients can tell it apart from the model
+// in the response.
+func setRequestedModel(set func(key, value string), proxyReq *model.ProxyRequest) {
+	if m := proxyReq.RequestedModel; m != "" && m != proxyReq.ChatRequest.Model {
+		set("X-Requested-Model", m)
+	}
+}
+
+// handleStreaming runs the pipeline as SSE. If rec is non-nil, headers and
+// events are also captured into it. It returns the response, or nil if the
+// request failed.
+func (h *Handler) handleStreaming(w http.ResponseWriter, r *http.Request, proxyReq *model.ProxyRequest, rec *eventRecorder) *model.ProxyResponse {
+	sent := &sentWriter{ResponseWriter: w}
+	w = sent
 	sw := sse.NewWriter(w)
+	ctx := r.Context()
+	if h.tokenCounts != nil {
+		ctx = pipeline.WithInputCounter(ctx, h.tokenCounts.count)
+	}
+	flush, stopHeartbeat := func() {}, func() {}
+	var relayed *relayTally
+	if h.sseBackpressure.WriteTimeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		sw, flush = sse.NewBufferedWriter(w, sw, h.sseBackpressure, func() { cancel(sse.ErrSlowClient) })
+		defer flush()
+	}
+	if h.sseHeartbeat > 0 {
+		// Wraps the buffered writer, if any, so pings are queued behind
+		// events and sent within the write deadline too.
+		sw, stopHeartbeat = sse.WithHeartbeat(sw, h.sseHeartbeat)
+		defer stopHeartbeat()
+	}
+	if h.sseBackpressure.WriteTimeout > 0 {
+		// Only clients dropped for falling behind need the tally.
+		relayed = &relayTally{Writer: sw}
+		sw = relayed
+	}
+	var answer *streamedAnswer
+	if h.feedback != nil {
+		answer = &streamedAnswer{Writer: sw}
+		sw = answer
+	}
+	if h.sseMetadata {
+		sw = &metadataWriter{Writer: sw, header: w.Header(), requestID: proxyReq.RequestID}
+	}
+	if rec != nil {
+		sw = rec.wrap(sw)
+	}
 	sw.SetHeader("X-Tokens-Input", strconv.Itoa(proxyReq.InputTokens))
 	sw.SetHeader("X-Cache", "MISS")
+	setRequestedModel(sw.SetHeader, proxyReq)
+	// Upstream latency is only known once the stream ends, so it is sent as
+	// a trailer.
+	sw.SetHeader("Trailer", "X-Upstream-Latency-Ms")
 
-	resp, err := h.pipeline.ExecuteStream(r.Context(), proxyReq, sw)
+	resp, err := h.pipeline.ExecuteStream(ctx, proxyReq, sw)
+	// No ping may race the writes below, and everything queued must be sent
+	// before the response is touched again.
+	stopHeartbeat()
+	flush()
+	if errors.Is(context.Cause(ctx), sse.ErrSlowClient) {
+		h.slowClients.Add(1)
+		h.logger.Warn("stream aborted: client too slow", "request_id", proxyReq.RequestID)
+		// The upstream stopped when the client was dropped, but the tokens
+		// it produced until then are still billed.
+		if w.Header().Get("X-Cache") == "MISS" {
+			h.record(proxyReq, relayed.abandoned(h.counter, proxyReq, w.Header()))
+		}
+		return nil
+	}
 	if err != nil {
 		h.logger.Error("streaming pipeline error", "error", err, "request_id", proxyReq.RequestID)
-		// For streaming, we can't write an error response if we've already started streaming.
-		// The error will manifest as an incomplete stream to the client.
-		return
+		// Once streaming has started the status can't change, and the error
+		// manifests as an incomplete stream. Before that, answer like a
+		// non-streaming request would.
+		if !sent.sent {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Trailer")
+			writeUpstreamError(w, err)
+		}
+		return nil
 	}
 
 	if resp != nil {
+		if resp.UpstreamLatency > 0 {
+			w.Header().Set("X-Upstream-Latency-Ms", formatMillis(resp.UpstreamLatency))
+		}
+		if answer != nil && resp.ChatResponse == nil {
+			// A streamed miss comes back without a response: remember the
+			// answer the client was sent, so it can be rated.
+			h.feedback.Served(proxyReq.RequestID, proxyReq.APIKey, answer.response())
+		}
+		h.record(proxyReq, resp)
 		h.logger.Info("stream completed",
 			"request_id", proxyReq.RequestID,
 			"output_tokens", resp.OutputTokens,
 			"cost", resp.Cost,
 			"provider", resp.ProviderName,
+			"upstream_latency", resp.UpstreamLatency,
 		)
 	}
+	return resp
+}
+
+// metadataWriter writes a ": qlite {...}" comment with the request ID,
+// provider and cache status just before the first event, once the pipeline
+// has set the X-Provider and X-Cache headers.
+type metadataWriter struct {
+	sse.Writer
+	header    http.Header
+	requestID string
+	sent      bool
+}
+
+func (m *metadataWriter) WriteEvent(data []byte) error {
+	if err := m.send(); err != nil {
+		return err
+	}
+	return m.Writer.WriteEvent(data)
+}
+
+func (m *metadataWriter) Done() error {
+	if err := m.send(); err != nil {
+		return err
+	}
+	return m.Writer.Done()
+}
+
+func (m *metadataWriter) send() error {
+	cw, ok := m.Writer.(sse.CommentWriter)
+	if m.sent || !ok {
+		return nil
+	}
+	m.sent = true
+	meta, err := json.Marshal(struct {
+		RequestID string `json:"request_id"`
+		Provider  string `json:"provider"`
+		Cache     string `json:"cache"`
+	}{m.requestID, m.header.Get("X-Provider"), m.header.Get("X-Cache")})
+	if err != nil {
+		return err
+	}
+	return cw.WriteComment("qlite " + string(meta))
+}
+
+// relayTally collects the content of the events relayed to a client, so a
+// stream the client was dropped from can still be billed for what the
+// upstream produced. Like the dispatch stage's usage estimator it does not
+// implement sse.RawWriter: chunks have to be inspected one by one.
+type relayTally struct {
+	sse.Writer
+	content strings.Builder
+}
+
+func (t *relayTally) WriteEvent(data []byte) error {
+	var chunk model.ChatStreamChunk
+	if json.Unmarshal(data, &chunk) == nil {
+		for _, c := range chunk.Choices {
+			t.content.WriteString(c.Delta.ReasoningContent)
+			t.content.WriteString(c.Delta.Content)
+		}
+	}
+	return t.Writer.WriteEvent(data)
+}
+
+func (t *relayTally) WriteComment(text string) error {
+	if cw, ok := t.Writer.(sse.CommentWriter); ok {
+		return cw.WriteComment(text)
+	}
+	return nil
+}
+
+// abandoned returns the response to record for a stream cut off after t's
+// content was relayed, estimating its output tokens. header holds the
+// stream's provider and input token count, which also replaces
+// proxyReq.InputTokens.
+func (t *relayTally) abandoned(counter *tokenizer.Counter, proxyReq *model.ProxyRequest, header http.Header) *model.ProxyResponse {
+	if n, err := strconv.Atoi(header.Get("X-Tokens-Input")); err == nil {
+		proxyReq.InputTokens = n
+	}
+	name := proxyReq.ChatRequest.Model
+	u := model.Usage{PromptTokens: proxyReq.InputTokens, CompletionTokens: counter.CountText(name, t.content.String())}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	p := header.Get("X-Provider")
+	return &model.ProxyResponse{
+		OutputTokens: u.CompletionTokens,
+		Cost:         pricing.CalculateUsageFor(p, name, u),
+		CacheStatus:  "MISS",
+		ProviderName: p,
+	}
+}
+
+// formatMillis formats d as fractional milliseconds, e.g. "412.38".
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+}
+
+// record updates the request counters and the savings rollup for a
+// completed request.
+func (h *Handler) record(proxyReq *model.ProxyRequest, resp *model.ProxyResponse) {
+	h.requests.Add(1)
+	if resp.CacheStatus == "HIT" {
+		h.cacheHits.Add(1)
+	}
+	h.costNanoUSD.Add(uint64(resp.Cost * 1e9))
+	h.feedback.Served(proxyReq.RequestID, proxyReq.APIKey, resp.ChatResponse)
+	h.recordSavings(proxyReq, resp)
+}
+
+// recordSavings adds a completed request to the savings rollup, if enabled.
+// Cache hits record the avoided upstream cost as saved.
+func (h *Handler) recordSavings(proxyReq *model.ProxyRequest, resp *model.ProxyResponse) {
+	if h.savings == nil {
+		return
+	}
+	e := savings.Event{
+		Time:         h.now(),
+		Model:        proxyReq.ChatRequest.Model,
+		APIKey:       proxyReq.APIKey,
+		CacheHit:     resp.CacheStatus == "HIT",
+		Cost:         resp.Cost,
+		TokensOutput: resp.OutputTokens,
+		Tags:         proxyReq.Tags,
+	}
+	if resp.ChatResponse != nil {
+		u := resp.ChatResponse.Usage
+		e.TokensInput = u.PromptTokens
+		if e.CacheHit {
+			e.TokensSaved = u.PromptTokens + u.CompletionTokens
+			e.Saved = pricing.Calculate(proxyReq.ChatRequest.Model, u.PromptTokens, u.CompletionTokens)
+		}
+	} else {
+		e.TokensInput = proxyReq.InputTokens
+	}
+	h.savings.Record(e)
+}
+
+// Limits on X-QLite-Tags, since every distinct tag becomes a persisted
+// savings bucket.
+const (
+	maxTags      = 10
+	maxTagLength = 64
+)
+
+// parseTags parses a comma-separated "key=value" list, e.g.
+// "team=search, feature=autocomplete". Malformed or oversized pairs are
+// ignored, as are pairs beyond maxTags.
+func parseTags(header string) map[string]string {
+	if header == "" {
+		return nil
+	}
+	tags := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" || len(k) > maxTagLength || len(v) > maxTagLength {
+			continue
+		}
+		if len(tags) == maxTags {
+			break
+		}
+		tags[k] = v
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+func (h *Handler) applyClientMetadata(r *http.Request, req *model.ChatRequest) {
+	if h.userHeader != "" {
+		if v := r.Header.Get(h.userHeader); v != "" {
+			req.User = v
+		}
+	}
+	for header, key := range h.metadataHeaders {
+		v := r.Header.Get(header)
+		if v == "" {
+			continue
+		}
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string, len(h.metadataHeaders))
+		}
+		req.Metadata[key] = v
+	}
 }
 
 func extractAPIKey(r *http.Request) string {
@@ -150,12 +800,72 @@ func extractAPIKey(r *http.Request) string {
 }
 
 func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeErrorCode(w, status, errType, "", message)
+}
+
+// writeUpstreamError writes a pipeline failure with a status matching its
+// provider error kind: 429 for rate limits (with the upstream's Retry-After),
+// 400 for over-long prompts, 504 for timeouts and 502 otherwise.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	var ue *provider.UpstreamError
+	if errors.Is(err, provider.ErrRateLimited) && errors.As(err, &ue) && ue.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(ue.RetryAfter.Seconds()))))
+	}
+	status, errType, code := upstreamErrorKind(err)
+	writeErrorCode(w, status, errType, code, err.Error())
+}
+
+// upstreamErrorKind returns the status, error type and code a pipeline
+// failure is reported with.
+func upstreamErrorKind(err error) (status int, errType, code string) {
+	status, errType = http.StatusBadGateway, "upstream_error"
+	switch {
+	case errors.Is(err, provider.ErrRateLimited):
+		status, errType, code = http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded"
+	case errors.Is(err, provider.ErrContextLength):
+		status, errType, code = http.StatusBadRequest, "invalid_request_error", "context_length_exceeded"
+	case errors.Is(err, pipeline.ErrLatencyBudget):
+		status, errType, code = http.StatusGatewayTimeout, "timeout_error", "latency_budget_exceeded"
+	case errors.Is(err, provider.ErrUpstreamTimeout):
+		status, errType, code = http.StatusGatewayTimeout, "timeout_error", "upstream_timeout"
+	case errors.Is(err, provider.ErrAuth):
+		// The proxy's own provider credentials were rejected; nothing the
+		// client can fix, so this stays a gateway error.
+		code = "upstream_auth_failed"
+	}
+	return status, errType, code
+}
+
+// sentWriter records whether anything has been written to the response.
+type sentWriter struct {
+	http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) WriteHeader(code int) {
+	s.sent = true
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *sentWriter) Write(b []byte) (int, error) {
+	s.sent = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (s *sentWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// writeErrorCode writes an OpenAI-style error with a machine-readable code.
+func writeErrorCode(w http.ResponseWriter, status int, errType, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(model.ErrorResponse{
 		Error: model.ErrorDetail{
 			Message: message,
 			Type:    errType,
+			Code:    code,
 		},
 	})
 }
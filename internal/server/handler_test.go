@@ -1,15 +1,24 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/eduardmaghakyan/qlite/internal/cache"
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/eduardmaghakyan/qlite/internal/pipeline"
 	"github.com/eduardmaghakyan/qlite/internal/provider"
@@ -167,6 +176,485 @@ func TestHandler_Streaming(t *testing.T) {
 	}
 }
 
+func TestHandler_UpstreamLatencyHeader(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		var req model.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(`data: {"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}` + "\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	for _, stream := range []bool{false, true} {
+		body := fmt.Sprintf(`{"model":"gpt-4o","stream":%t,"messages":[{"role":"user","content":"hi"}]}`, stream)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		got := res.Header.Get("X-Upstream-Latency-Ms")
+		if stream {
+			// Streaming responses carry the latency as a trailer.
+			got = res.Trailer.Get("X-Upstream-Latency-Ms")
+		}
+		ms, err := strconv.ParseFloat(got, 64)
+		if err != nil || ms < 20 {
+			t.Errorf("stream=%t: expected X-Upstream-Latency-Ms >= 20, got %q", stream, got)
+		}
+	}
+}
+
+func TestHandler_DefaultModel(t *testing.T) {
+	var upstream model.ChatRequest
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	var requested string
+	send := func(body string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		requested = rec.Header().Get("X-Requested-Model")
+		return rec.Code
+	}
+
+	if code := send(`{"messages":[{"role":"user","content":"hi"}]}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a default model, got %d", code)
+	}
+
+	handler.SetDefaultModel("gpt-4o-mini")
+	for _, body := range []string{
+		`{"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"auto","messages":[{"role":"user","content":"hi"}]}`,
+	} {
+		upstream = model.ChatRequest{}
+		if code := send(body); code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d", body, code)
+		}
+		if upstream.Model != "gpt-4o-mini" {
+			t.Errorf("expected default model upstream, got %q", upstream.Model)
+		}
+		if requested != "auto" {
+			t.Errorf("X-Requested-Model = %q, want auto", requested)
+		}
+	}
+}
+
+func TestHandler_StreamingMetadataComment(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}` + "\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetSSEMetadata(true)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+	wrapped := RequestID(mux)
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec, req)
+
+	want := fmt.Sprintf(`: qlite {"request_id":%q,"provider":"test","cache":"MISS"}`+"\n\n", rec.Header().Get("X-Request-ID"))
+	if got := rec.Body.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("expected stream to start with %q, got %q", want, got)
+	}
+	if strings.Count(rec.Body.String(), ": qlite") != 1 {
+		t.Error("expected exactly one metadata comment")
+	}
+}
+
+// stalledWriter is a client that reads nothing until release is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (s *stalledWriter) Write(p []byte) (int, error) {
+	<-s.release
+	return s.ResponseRecorder.Write(p)
+}
+
+func (s *stalledWriter) Unwrap() http.ResponseWriter { return s.ResponseRecorder }
+
+func TestHandler_StreamingSlowClient(t *testing.T) {
+	upstreamGone := make(chan struct{})
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamGone)
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk := `data: {"id":"c","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"` + strings.Repeat("x", 100) + `"}}]}` + "\n\n"
+		for {
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetSSEBackpressure(time.Second, 1024)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		mux.ServeHTTP(w, req)
+	}()
+
+	select {
+	case <-upstreamGone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the upstream request to be cancelled")
+	}
+	close(w.release)
+	<-served
+	if n := handler.Stats().SlowClients; n != 1 {
+		t.Errorf("expected 1 slow client, got %d", n)
+	}
+	// The tokens streamed before the client was dropped are still billed.
+	if s := handler.Stats(); s.Requests != 1 || s.Cost <= 0 {
+		t.Errorf("expected the abandoned stream to be recorded with its cost, got %+v", s)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	got := parseTags(" team=search, feature = autocomplete ,bad, =x, y=")
+	if len(got) != 2 || got["team"] != "search" || got["feature"] != "autocomplete" {
+		t.Errorf("unexpected tags: %v", got)
+	}
+	if parseTags("") != nil || parseTags("junk") != nil {
+		t.Error("expected nil for empty or malformed header")
+	}
+	long := strings.Repeat("k", maxTagLength+1) + "=v"
+	if parseTags(long) != nil {
+		t.Error("expected oversized tag to be dropped")
+	}
+}
+
+func TestHandler_ContinuationRounds(t *testing.T) {
+	h := &Handler{}
+	if got := h.continuationRounds("true"); got != 0 {
+		t.Errorf("expected no continuation unless enabled, got %d", got)
+	}
+	h.SetContinuations(3)
+	for v, want := range map[string]int{"": 0, "true": 3, "false": 0, "1": 1, "10": 3, "-2": 0, "junk": 0} {
+		if got := h.continuationRounds(v); got != want {
+			t.Errorf("continuationRounds(%q) = %d, want %d", v, got, want)
+		}
+	}
+}
+
+func TestHandler_LatencyDeadline(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &Handler{now: func() time.Time { return now }}
+	for v, want := range map[string]time.Time{
+		"":      {},
+		"250":   now.Add(250 * time.Millisecond),
+		"1.5s":  now.Add(1500 * time.Millisecond),
+		"800ms": now.Add(800 * time.Millisecond),
+	} {
+		got, ok := h.latencyDeadline(httptest.NewRecorder(), v)
+		if !ok || !got.Equal(want) {
+			t.Errorf("latencyDeadline(%q) = %v, %v, want %v", v, got, ok, want)
+		}
+	}
+	for _, v := range []string{"0", "-5", "soon"} {
+		rec := httptest.NewRecorder()
+		if _, ok := h.latencyDeadline(rec, v); ok || rec.Code != http.StatusBadRequest {
+			t.Errorf("latencyDeadline(%q): expected a 400, got %d", v, rec.Code)
+		}
+	}
+}
+
+func TestHandler_CachedResponseByKey(t *testing.T) {
+	exact := cache.New(time.Hour, 100)
+	h := NewHandler(nil, tokenizer.NewCounter(), slog.New(slog.DiscardHandler), exact)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	mux.HandleFunc("GET /admin/cache/entries/{key}", h.ServeCachedResponse)
+
+	req := &model.ChatRequest{Model: "gpt-4o", Messages: []model.Message{{Role: "user", Content: "hi"}}}
+	key := exact.Key(req)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/cache/"+key, nil))
+	if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "cache_miss") {
+		t.Errorf("expected no public cache lookup route, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/entries/"+key, nil))
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "cache_miss") {
+		t.Fatalf("expected a 404 before the response is cached, got %d %s", rec.Code, rec.Body.String())
+	}
+	exact.Put(req, &model.ChatResponse{ID: "chatcmpl-1", Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hello"}}}})
+
+	rec = get()
+	var resp model.ChatResponse
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil || resp.ID != "chatcmpl-1" {
+		t.Fatalf("expected the cached response, got %d %+v", rec.Code, resp)
+	}
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Header().Get("Expires") == "" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+	if s := exact.Stats(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("expected key lookups not to count as cache lookups, got %+v", s)
+	}
+}
+
+func TestHandler_CacheReasonHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	exact := cache.New(time.Hour, 100)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact).RegisterRoutes(mux)
+
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`, cache.MissNotCached},
+		{`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`, "hit"},
+		{`{"model":"gpt-4o","temperature":0.9,"messages":[{"role":"user","content":"hi"}]}`, "temp_above_zero"},
+		{`{"model":"gpt-4o","temperature":0,"stream":true,"messages":[{"role":"user","content":"bye"}]}`, cache.MissNotCached},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tc.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.body, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("X-Cache-Reason"); got != tc.want {
+			t.Errorf("%s: expected X-Cache-Reason %q, got %q", tc.body, tc.want, got)
+		}
+	}
+}
+
+func TestHandler_Feedback(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: fmt.Sprintf("answer %d", calls)}, FinishReason: "stop"}},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	feedback := cache.NewFeedback(1, time.Hour, 100)
+	exact := cache.New(time.Hour, 100)
+	exact.SetFeedback(feedback)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact)
+	h.SetFeedback(feedback)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	srv := RequestID(mux)
+
+	chat := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer user-key")
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	rate := func(id, key string, rating int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/feedback", strings.NewReader(fmt.Sprintf(`{"request_id":%q,"rating":%d}`, id, rating)))
+		req.Header.Set("Authorization", "Bearer "+key)
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	chat()
+	hit := chat()
+	if hit.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected the second request to hit, got %s", hit.Header().Get("X-Cache"))
+	}
+	id := hit.Header().Get("X-Request-ID")
+
+	if rec := rate(id, "user-key", 5); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid rating to be rejected, got %d", rec.Code)
+	}
+	if rec := rate(id, "other-key", -1); rec.Code != http.StatusNotFound {
+		t.Errorf("expected another key's request to be unknown, got %d", rec.Code)
+	}
+	rec := rate(id, "user-key", -1)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"excluded":true`) {
+		t.Fatalf("expected the answer to be excluded, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	miss := chat()
+	if miss.Header().Get("X-Cache-Reason") != cache.MissFeedback || !strings.Contains(miss.Body.String(), "answer 2") {
+		t.Errorf("expected a fresh answer after negative feedback, got %s %s", miss.Header().Get("X-Cache-Reason"), miss.Body.String())
+	}
+}
+
+func TestHandler_FeedbackStreamed(t *testing.T) {
+	// Both prompts get the same answer, so rating the streamed one down
+	// excludes the entry the other left in the cache.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"same \"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"answer\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "same answer"}, FinishReason: "stop"}},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	feedback := cache.NewFeedback(1, time.Hour, 100)
+	exact := cache.New(time.Hour, 100)
+	exact.SetFeedback(feedback)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact)
+	h.SetFeedback(feedback)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	srv := RequestID(mux)
+
+	chat := func(content string, stream bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"model":"gpt-4o","temperature":0,"stream":%t,"messages":[{"role":"user","content":%q}]}`, stream, content)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer user-key")
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	chat("hi", false)
+	if hit := chat("hi", false); hit.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected the answer cached, got %s", hit.Header().Get("X-Cache"))
+	}
+	streamed := chat("hello", true)
+	if streamed.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected a streamed miss, got %s", streamed.Header().Get("X-Cache"))
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/feedback", strings.NewReader(fmt.Sprintf(`{"request_id":%q,"rating":-1}`, streamed.Header().Get("X-Request-ID"))))
+	req.Header.Set("Authorization", "Bearer user-key")
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"excluded":true`) {
+		t.Fatalf("expected the streamed answer to be rated and excluded, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if miss := chat("hi", false); miss.Header().Get("X-Cache-Reason") != cache.MissFeedback {
+		t.Errorf("expected the cached entry excluded, got %s", miss.Header().Get("X-Cache-Reason"))
+	}
+}
+
+func TestHandler_ForwardsClientMetadata(t *testing.T) {
+	var upstream model.ChatRequest
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetClientMetadata("X-User-Id", map[string]string{"X-Org": "org"})
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"user":"from-body"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("X-User-Id", "user-42")
+	req.Header.Set("X-Org", "acme")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstream.User != "user-42" {
+		t.Errorf("expected user from header, got %q", upstream.User)
+	}
+	if upstream.Metadata["org"] != "acme" {
+		t.Errorf("expected org metadata, got %v", upstream.Metadata)
+	}
+}
+
 func TestHandler_InvalidRequest(t *testing.T) {
 	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("upstream should not be called")
@@ -237,6 +725,78 @@ func TestHandler_Health(t *testing.T) {
 	}
 }
 
+func TestHandler_Version(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.cache = cache.New(time.Minute, 100)
+	handler.SetBuildInfo(BuildInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2026-01-02"}, Features{Providers: []string{"test"}, SemanticCache: true})
+	handler.SetSemanticEntries(func(context.Context) (int, error) { return 7, nil })
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if got, want := rec.Body.String(), `{"status":"ok","version":"v1.2.3","commit":"abc123"}`; got != want {
+		t.Errorf("health = %s, want %s", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected /version not to be served publicly, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeVersion(rec, httptest.NewRequest(http.MethodGet, "/admin/version", nil))
+	var got struct {
+		Version    string   `json:"version"`
+		BuildDate  string   `json:"build_date"`
+		Providers  []string `json:"providers"`
+		Semantic   bool     `json:"semantic_cache"`
+		ExactCache struct {
+			MaxEntries int `json:"max_entries"`
+		} `json:"exact_cache"`
+		SemanticEntries int `json:"semantic_cache_entries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "v1.2.3" || got.BuildDate != "2026-01-02" || len(got.Providers) != 1 || !got.Semantic || got.ExactCache.MaxEntries != 100 || got.SemanticEntries != 7 {
+		t.Errorf("unexpected version response %+v", got)
+	}
+}
+
+func TestHandler_StoreFilterCountsOnce(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-big",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "far too long"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	filter := cache.NewStoreFilter(nil, false)
+	filter.SetMaxBytes(4)
+	handler.cache = cache.New(time.Minute, 100)
+	handler.cache.SetStoreFilter(filter)
+	handler.SetStoreFilter(filter)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := filter.Oversize(); got != 1 {
+		t.Errorf("Oversize = %d, want 1", got)
+	}
+}
+
 func TestHandler_UnknownModel(t *testing.T) {
 	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatal("upstream should not be called for unknown model")
@@ -258,6 +818,57 @@ func TestHandler_UnknownModel(t *testing.T) {
 	}
 }
 
+func TestHandler_UpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"rate limited", http.StatusTooManyRequests, `{"error":"slow down"}`, http.StatusTooManyRequests, "rate_limit_exceeded"},
+		{"context length", http.StatusBadRequest, `{"error":{"code":"context_length_exceeded"}}`, http.StatusBadRequest, "context_length_exceeded"},
+		{"auth", http.StatusUnauthorized, `{"error":"bad key"}`, http.StatusBadGateway, "upstream_auth_failed"},
+		{"timeout", http.StatusGatewayTimeout, ``, http.StatusGatewayTimeout, "upstream_timeout"},
+		{"unclassified", http.StatusInternalServerError, `oops`, http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", tt.name, stream), func(t *testing.T) {
+				mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Retry-After", "3")
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+				}))
+				defer mockSrv.Close()
+
+				handler := setupTestHandler(t, mockSrv)
+				mux := http.NewServeMux()
+				handler.RegisterRoutes(mux)
+
+				reqBody := fmt.Sprintf(`{"model":"gpt-4o","stream":%v,"messages":[{"role":"user","content":"hello"}]}`, stream)
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(reqBody))
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+				}
+				var errResp model.ErrorResponse
+				if err := json.NewDecoder(rec.Body).Decode(&errResp); err != nil {
+					t.Fatalf("invalid error body: %v", err)
+				}
+				if errResp.Error.Code != tt.wantCode {
+					t.Errorf("expected code %q, got %q", tt.wantCode, errResp.Error.Code)
+				}
+				if got := rec.Header().Get("Retry-After"); (tt.wantStatus == http.StatusTooManyRequests) != (got == "3") {
+					t.Errorf("unexpected Retry-After %q", got)
+				}
+			})
+		}
+	}
+}
+
 func TestMiddleware_RequestID(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := GetRequestID(r.Context())
@@ -297,6 +908,72 @@ func TestMiddleware_CORS(t *testing.T) {
 	}
 }
 
+func TestMiddleware_BearerAuth(t *testing.T) {
+	h := BearerAuth("s3cret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, tt := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Authorization %q: expected %d, got %d", tt.auth, tt.want, rec.Code)
+		}
+	}
+
+	// No token configured: everything passes.
+	rec := httptest.NewRecorder()
+	BearerAuth("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected pass-through without a token, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_AdminAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := Chain(ok, AdminOnly(func(next http.Handler) http.Handler {
+		return Chain(next, BearerAuth("s3cret"), ClientCertAuth)
+	}))
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	for _, tt := range []struct {
+		path string
+		auth string
+		tls  *tls.ConnectionState
+		want int
+	}{
+		{"/v1/chat/completions", "", nil, http.StatusNoContent},
+		{"/health", "", nil, http.StatusNoContent},
+		{"/admin/cache/clear", "", verified, http.StatusUnauthorized},
+		{"/admin/cache/clear", "Bearer s3cret", nil, http.StatusUnauthorized},
+		{"/admin/cache/clear", "Bearer s3cret", &tls.ConnectionState{}, http.StatusUnauthorized},
+		{"/admin/cache/clear", "Bearer s3cret", verified, http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		req.Header.Set("Authorization", tt.auth)
+		req.TLS = tt.tls
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s (auth %q, tls %v): expected %d, got %d", tt.path, tt.auth, tt.tls != nil, tt.want, rec.Code)
+		}
+	}
+}
+
 func TestMiddleware_Recovery(t *testing.T) {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -312,3 +989,71 @@ func TestMiddleware_Recovery(t *testing.T) {
 		t.Errorf("expected 500, got %d", rec.Code)
 	}
 }
+
+func TestHandler_StreamingOverH2C(t *testing.T) {
+	// The upstream holds the stream open after its first event until the
+	// client has read it, so the event must be flushed through the proxy.
+	release := make(chan struct{})
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"c","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hi"}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	proxy := httptest.NewUnstartedServer(Chain(mux, RequestID))
+	proxy.Config.Protocols = new(http.Protocols)
+	proxy.Config.Protocols.SetHTTP1(true)
+	proxy.Config.Protocols.SetUnencryptedHTTP2(true)
+	proxy.Start()
+	defer proxy.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello!"}]}`
+	resp, err := client.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	first := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if !strings.Contains(line, `"content":"Hi"`) {
+			t.Errorf("expected relayed chunk, got %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("first event was not flushed while the upstream was still streaming")
+	}
+	close(release)
+
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "[DONE]") {
+		t.Error("expected [DONE] event in response")
+	}
+}
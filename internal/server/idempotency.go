@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/savings"
+	"github.com/eduardmaghakyan/qlite/internal/sse"
+)
+
+// idempotencyState is the outcome of claiming an Idempotency-Key.
+type idempotencyState int
+
+const (
+	idemNew      idempotencyState = iota // first use: caller must complete or abort
+	idemReplay                           // finished earlier: replay the stored result
+	idemInFlight                         // original request still running
+	idemMismatch                         // key reused with a different request body
+)
+
+// idemEntry is the stored outcome of one idempotent request. Non-streaming
+// requests keep the full ProxyResponse; streaming requests keep the exact
+// events and headers that were sent.
+type idemEntry struct {
+	fingerprint string
+	done        bool
+	expiresAt   time.Time
+
+	resp    *model.ProxyResponse
+	events  [][]byte
+	headers map[string]string
+}
+
+// idempotencyStore remembers results by Idempotency-Key for a short TTL.
+// It is deliberately separate from the response caches: it replays any
+// request (including temperature > 0) but only to retries of the same key.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idemEntry
+	lastPrune time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{ttl: ttl, entries: make(map[string]*idemEntry)}
+}
+
+// begin claims key for a request with the given body fingerprint.
+func (s *idempotencyStore) begin(key, fingerprint string) (*idemEntry, idempotencyState) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > s.ttl {
+		for k, e := range s.entries {
+			if e.done && now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	e, ok := s.entries[key]
+	if ok && e.done && now.After(e.expiresAt) {
+		ok = false
+	}
+	if !ok {
+		s.entries[key] = &idemEntry{fingerprint: fingerprint}
+		return nil, idemNew
+	}
+	switch {
+	case e.fingerprint != fingerprint:
+		return nil, idemMismatch
+	case !e.done:
+		return nil, idemInFlight
+	}
+	return e, idemReplay
+}
+
+// complete stores the result for key and starts its TTL.
+func (s *idempotencyStore) complete(key string, resp *model.ProxyResponse, events [][]byte, headers map[string]string) {
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok {
+		e.done = true
+		e.expiresAt = time.Now().Add(s.ttl)
+		e.resp = resp
+		e.events = events
+		e.headers = headers
+	}
+	s.mu.Unlock()
+}
+
+// abort releases key after a failed request so a retry can run it again.
+// It does nothing once the request completed.
+func (s *idempotencyStore) abort(key string) {
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok && !e.done {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+}
+
+// requestFingerprint hashes the effective request so a key reused with a
+// different body is detected.
+func requestFingerprint(req *model.ChatRequest) string {
+	b, _ := json.Marshal(req)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// handleIdempotent serves a request carrying an Idempotency-Key. Keys are
+// scoped per API key so tenants cannot replay each other's results.
+func (h *Handler) handleIdempotent(w http.ResponseWriter, r *http.Request, proxyReq *model.ProxyRequest, idemKey string) {
+	key := savings.KeyID(proxyReq.APIKey) + ":" + idemKey
+	entry, state := h.idempotency.begin(key, requestFingerprint(&proxyReq.ChatRequest))
+
+	switch state {
+	case idemMismatch:
+		writeError(w, http.StatusUnprocessableEntity, "invalid_request_error",
+			"Idempotency-Key was already used with a different request body")
+	case idemInFlight:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, "invalid_request_error",
+			"a request with this Idempotency-Key is still in progress")
+	case idemReplay:
+		w.Header().Set("Idempotent-Replayed", "true")
+		if proxyReq.ChatRequest.Stream {
+			h.replayStream(w, entry)
+		} else {
+			h.writeChatResponse(w, proxyReq, entry.resp)
+		}
+	case idemNew:
+		// Release the key unless the request completes, including when the
+		// handler panics, so retries aren't refused as in flight for good.
+		defer h.idempotency.abort(key)
+		if proxyReq.ChatRequest.Stream {
+			rec := &eventRecorder{headers: make(map[string]string)}
+			if resp := h.handleStreaming(w, r, proxyReq, rec); resp != nil {
+				h.idempotency.complete(key, nil, rec.events, rec.headers)
+			}
+			return
+		}
+		if resp := h.handleNonStreaming(w, r, proxyReq); resp != nil {
+			h.idempotency.complete(key, resp, nil, nil)
+		}
+	}
+}
+
+func (h *Handler) replayStream(w http.ResponseWriter, entry *idemEntry) {
+	sw := sse.NewWriter(w)
+	for k, v := range entry.headers {
+		sw.SetHeader(k, v)
+	}
+	for _, ev := range entry.events {
+		if err := sw.WriteEvent(ev); err != nil {
+			return
+		}
+	}
+	sw.Done()
+}
+
+// eventRecorder wraps an sse.Writer, keeping a copy of headers and events.
+type eventRecorder struct {
+	inner   sse.Writer
+	headers map[string]string
+	events  [][]byte
+}
+
+func (e *eventRecorder) wrap(sw sse.Writer) sse.Writer {
+	e.inner = sw
+	return e
+}
+
+func (e *eventRecorder) SetHeader(key, value string) {
+	e.headers[key] = value
+	e.inner.SetHeader(key, value)
+}
+
+func (e *eventRecorder) WriteEvent(data []byte) error {
+	e.events = append(e.events, append([]byte(nil), data...))
+	return e.inner.WriteEvent(data)
+}
+
+func (e *eventRecorder) Done() error { return e.inner.Done() }
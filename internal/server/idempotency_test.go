@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func idempotentRequest(body, key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Idempotency-Key", key)
+	return req
+}
+
+func TestHandler_IdempotencyReplaysNonStreaming(t *testing.T) {
+	var calls atomic.Int32
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      fmt.Sprintf("chatcmpl-%d", n),
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetIdempotency(time.Minute)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	// temperature > 0: not cacheable, but still replayed for the same key.
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.9}`
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, idempotentRequest(body, "key-1"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp model.ChatResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		ids = append(ids, resp.ID)
+		if i == 1 && rec.Header().Get("Idempotent-Replayed") != "true" {
+			t.Error("expected Idempotent-Replayed header on retry")
+		}
+	}
+	if calls.Load() != 1 || ids[0] != ids[1] {
+		t.Errorf("expected one upstream call and identical responses, got %d calls, ids %v", calls.Load(), ids)
+	}
+
+	// Same key, different body.
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, idempotentRequest(`{"model":"gpt-4o","messages":[{"role":"user","content":"other"}]}`, "key-1"))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for reused key, got %d", rec.Code)
+	}
+
+	// A different key goes upstream again.
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, idempotentRequest(body, "key-2"))
+	if calls.Load() != 2 {
+		t.Errorf("expected a new upstream call for a new key, got %d", calls.Load())
+	}
+}
+
+func TestHandler_IdempotencyReplaysStreaming(t *testing.T) {
+	var calls atomic.Int32
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetIdempotency(time.Minute)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"stream":true}`
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, idempotentRequest(body, "stream-key"))
+		bodies = append(bodies, rec.Body.String())
+		if rec.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("expected original X-Cache header, got %q", rec.Header().Get("X-Cache"))
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected one upstream call, got %d", calls.Load())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("expected identical replayed stream:\n%q\n%q", bodies[0], bodies[1])
+	}
+}
+
+func TestHandler_IdempotencyFailedRequestCanRetry(t *testing.T) {
+	var calls atomic.Int32
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-ok",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetIdempotency(time.Minute)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, idempotentRequest(body, "k"))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, idempotentRequest(body, "k"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected retry after failure to succeed, got %d", rec.Code)
+	}
+}
+
+// panicWriter panics on the first write, like a handler bug would.
+type panicWriter struct {
+	http.ResponseWriter
+}
+
+func (p panicWriter) Write([]byte) (int, error) { panic("write failed") }
+
+func TestHandler_IdempotencyPanicReleasesKey(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-ok",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetIdempotency(time.Minute)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the handler to panic")
+			}
+		}()
+		mux.ServeHTTP(panicWriter{httptest.NewRecorder()}, idempotentRequest(body, "k"))
+	}()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, idempotentRequest(body, "k"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a retry after the panic to run, got %d", rec.Code)
+	}
+}
+
+func TestIdempotencyStore_InFlight(t *testing.T) {
+	s := newIdempotencyStore(time.Minute)
+	if _, state := s.begin("k", "fp"); state != idemNew {
+		t.Fatalf("expected new, got %v", state)
+	}
+	if _, state := s.begin("k", "fp"); state != idemInFlight {
+		t.Errorf("expected in-flight, got %v", state)
+	}
+}
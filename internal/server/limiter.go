@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter bounds the number of chat requests in flight. Requests beyond the
+// limit wait in a bounded queue; when the queue is full, or a request waits
+// longer than the queue timeout, it is rejected with Retry-After so clients
+// and autoscalers can back off instead of timing out blindly.
+type Limiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+
+	queued   atomic.Int64
+	rejected atomic.Uint64
+	// avgLatency is an EWMA of request duration in nanoseconds, used to
+	// estimate Retry-After.
+	avgLatency atomic.Int64
+}
+
+// LoadStats is a point-in-time view of proxy pressure, served at /admin/load.
+type LoadStats struct {
+	InFlight      int     `json:"in_flight"`
+	Queued        int64   `json:"queued"`
+	MaxConcurrent int     `json:"max_concurrent"`
+	MaxQueue      int64   `json:"max_queue"`
+	Rejected      uint64  `json:"rejected_total"`
+	Utilization   float64 `json:"utilization"`
+	RetryAfter    int     `json:"retry_after_seconds"`
+}
+
+// NewLimiter creates a limiter allowing maxConcurrent requests in flight and
+// up to maxQueue waiting. A waiting request gives up after queueTimeout.
+func NewLimiter(maxConcurrent, maxQueue int, queueTimeout time.Duration) *Limiter {
+	return &Limiter{
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Wrap returns next guarded by the limiter. Every response carries
+// X-QLite-Queue-Depth. A nil Limiter returns next unchanged.
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if !l.wait(w, r) {
+				return
+			}
+		}
+		w.Header().Set("X-QLite-Queue-Depth", strconv.FormatInt(l.queued.Load(), 10))
+
+		start := time.Now()
+		defer func() {
+			<-l.slots
+			l.observe(time.Since(start))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait queues the request until a slot frees up. It writes the rejection
+// response and returns false if the request cannot be admitted.
+func (l *Limiter) wait(w http.ResponseWriter, r *http.Request) bool {
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.reject(w, http.StatusTooManyRequests, "request queue is full")
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		l.reject(w, http.StatusServiceUnavailable, "timed out waiting in request queue")
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, status int, message string) {
+	l.rejected.Add(1)
+	w.Header().Set("X-QLite-Queue-Depth", strconv.FormatInt(l.queued.Load(), 10))
+	w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+	writeError(w, status, "rate_limit_error", message)
+}
+
+func (l *Limiter) observe(d time.Duration) {
+	for {
+		old := l.avgLatency.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)/8
+		}
+		if l.avgLatency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// retryAfter estimates how many seconds until the current queue drains,
+// assuming requests keep completing at the observed average latency.
+func (l *Limiter) retryAfter() int {
+	avg := time.Duration(l.avgLatency.Load())
+	waves := l.queued.Load()/int64(cap(l.slots)) + 1
+	secs := int((time.Duration(waves)*avg + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// Stats returns the current load.
+func (l *Limiter) Stats() LoadStats {
+	inFlight := len(l.slots)
+	return LoadStats{
+		InFlight:      inFlight,
+		Queued:        l.queued.Load(),
+		MaxConcurrent: cap(l.slots),
+		MaxQueue:      l.maxQueue,
+		Rejected:      l.rejected.Load(),
+		Utilization:   float64(inFlight) / float64(cap(l.slots)),
+		RetryAfter:    l.retryAfter(),
+	}
+}
+
+// ServeHTTP serves GET /admin/load.
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.Stats())
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds each request until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestLimiter_RejectsWhenQueueFull(t *testing.T) {
+	l := NewLimiter(1, 0, time.Second)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h := l.Wrap(blockingHandler(started, release))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+		done <- rec
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if rec.Header().Get("X-QLite-Queue-Depth") != "0" {
+		t.Errorf("expected queue depth 0, got %q", rec.Header().Get("X-QLite-Queue-Depth"))
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Errorf("expected first request to succeed, got %d", first.Code)
+	}
+	if got := l.Stats().Rejected; got != 1 {
+		t.Errorf("expected 1 rejection, got %d", got)
+	}
+}
+
+func TestLimiter_QueuesThenTimesOut(t *testing.T) {
+	l := NewLimiter(1, 1, 20*time.Millisecond)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := l.Wrap(blockingHandler(started, release))
+	defer close(release)
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after queue timeout, got %d", rec.Code)
+	}
+	if l.Stats().Queued != 0 {
+		t.Errorf("expected queue to drain, got %d", l.Stats().Queued)
+	}
+}
+
+func TestLimiter_QueuedRequestAdmitted(t *testing.T) {
+	l := NewLimiter(1, 1, time.Second)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := l.Wrap(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		done <- rec.Code
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Queued != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/load", nil))
+	var stats LoadStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode load stats: %v", err)
+	}
+	if stats.InFlight != 1 || stats.Queued != 1 || stats.Utilization != 1 {
+		t.Errorf("unexpected load stats: %+v", stats)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected queued request to be admitted, got %d", code)
+	}
+}
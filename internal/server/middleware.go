@@ -2,9 +2,11 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,7 +38,9 @@ var statusWriterPool = sync.Pool{
 	New: func() any { return &statusWriter{} },
 }
 
-// Logger logs each request with method, path, status, and duration.
+// Logger logs each request with method, path, status, and duration. When the
+// handler reports upstream time via X-Upstream-Latency-Ms it is logged too,
+// so proxy overhead is duration minus upstream_latency_ms.
 func Logger(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -48,8 +52,10 @@ func Logger(logger *slog.Logger) func(http.Handler) http.Handler {
 			logger.Info("request",
 				"method", r.Method,
 				"path", r.URL.Path,
+				"proto", r.Proto,
 				"status", sw.status,
 				"duration", time.Since(start),
+				"upstream_latency_ms", sw.Header().Get("X-Upstream-Latency-Ms"),
 				"request_id", GetRequestID(r.Context()),
 			)
 			sw.ResponseWriter = nil
@@ -80,12 +86,16 @@ func (sw *statusWriter) Unwrap() http.ResponseWriter {
 	return sw.ResponseWriter
 }
 
-// Recovery catches panics and returns a 500 error.
+// Recovery catches panics and returns a 500 error. http.ErrAbortHandler is
+// re-raised so the server aborts the response as intended.
 func Recovery(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
 					logger.Error("panic recovered",
 						"error", err,
 						"request_id", GetRequestID(r.Context()),
@@ -103,8 +113,8 @@ func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Request-Cost, X-Tokens-Input, X-Tokens-Output, X-Cache, X-Cost-Saved, X-Provider")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, X-QLite-Tags, X-QLite-Provider, X-QLite-Continue, X-QLite-Max-Latency, X-QLite-Callback-URL, X-QLite-Profile")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Request-Cost, X-Tokens-Input, X-Tokens-Output, X-Cache, X-Cache-Reason, X-Cost-Saved, X-Provider, X-Upstream-Latency-Ms, X-QLite-Queue-Depth, Retry-After, Idempotent-Replayed, X-QLite-Dropped-Fields, X-QLite-System-Fingerprint, X-QLite-Deprecation, Sunset, X-Requested-Model")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -113,6 +123,53 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
+// BearerAuth rejects requests whose Authorization header isn't "Bearer
+// token" with 401. An empty token lets every request through.
+func BearerAuth(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="qlite-admin"`)
+				writeError(w, http.StatusUnauthorized, "authentication_error", "invalid or missing admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientCertAuth rejects requests that didn't present a client certificate
+// the TLS server verified with 401. The server must ask for certificates
+// (tls.Config ClientAuth) and trust their issuers (ClientCAs).
+func ClientCertAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			writeError(w, http.StatusUnauthorized, "authentication_error", "a verified client certificate is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminOnly applies mw to requests for /admin/* only, for admin endpoints
+// that share a port with the API.
+func AdminOnly(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		guarded := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/admin/") {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // Chain applies middleware in order (first middleware is outermost).
 func Chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
 	for i := len(middlewares) - 1; i >= 0; i-- {
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/shutdown"
+)
+
+// Mirror asynchronously replays a sample of incoming requests to another
+// qlite instance or URL so staging sees realistic traffic. The mirrored
+// response is discarded and never affects the client. Authorization is not
+// copied; the target uses its own provider keys.
+type Mirror struct {
+	target  string
+	percent float64
+	timeout time.Duration
+	client  *http.Client
+	logger  *slog.Logger
+	// slots bounds mirrored requests in flight; when full, requests are
+	// dropped rather than queued.
+	slots    chan struct{}
+	inFlight *shutdown.Tracker
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// MirrorStats counts mirrored requests.
+type MirrorStats struct {
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
+
+// NewMirror creates a mirror sending percent (0-100) of requests to target,
+// the base URL of the receiving instance. Each mirrored request is given up
+// after timeout, and at most maxInFlight run at once.
+func NewMirror(target string, percent float64, timeout time.Duration, maxInFlight int, logger *slog.Logger) *Mirror {
+	return &Mirror{
+		target:   strings.TrimRight(target, "/"),
+		percent:  percent,
+		timeout:  timeout,
+		client:   &http.Client{},
+		logger:   logger,
+		slots:    make(chan struct{}, maxInFlight),
+		inFlight: shutdown.NewTracker(),
+	}
+}
+
+// Stats returns cumulative mirroring counters.
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Sent:    m.sent.Load(),
+		Dropped: m.dropped.Load(),
+		Failed:  m.failed.Load(),
+	}
+}
+
+// Drain waits for mirrored requests in flight until ctx ends, then cancels
+// the rest and reports how many were dropped.
+func (m *Mirror) Drain(ctx context.Context) (int, error) {
+	return m.inFlight.Drain(ctx)
+}
+
+// Wrap returns next with sampled requests mirrored. A nil Mirror returns
+// next unchanged.
+func (m *Mirror) Wrap(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64()*100 < m.percent {
+			m.mirror(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mirror buffers r's body, restores it for the real handler and sends a copy
+// in the background.
+func (m *Mirror) mirror(r *http.Request) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.dropped.Add(1)
+		return
+	}
+
+	const maxBody = 10 << 20 // matches the chat handler's limit
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > maxBody {
+		// Leave the error for the real handler to report.
+		<-m.slots
+		m.dropped.Add(1)
+		return
+	}
+
+	header := r.Header.Clone()
+	header.Del("Authorization")
+	header.Del("X-QLite-Callback-URL") // the client gets one callback, from us
+	header.Set("X-QLite-Mirrored", "1")
+	url := m.target + r.URL.RequestURI()
+
+	m.inFlight.Go(func(ctx context.Context) {
+		defer func() { <-m.slots }()
+		ctx, cancel := context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, r.Method, url, bytes.NewReader(body))
+		if err != nil {
+			m.failed.Add(1)
+			return
+		}
+		req.Header = header
+		resp, err := m.client.Do(req)
+		if err != nil {
+			m.failed.Add(1)
+			m.logger.Debug("mirror request failed", "url", url, "error", err)
+			return
+		}
+		// Drain so streamed responses run to completion on the target.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		m.sent.Add(1)
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	type mirrored struct {
+		path, body, auth, flag string
+	}
+	got := make(chan mirrored, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- mirrored{r.URL.Path, string(b), r.Header.Get("Authorization"), r.Header.Get("X-QLite-Mirrored")}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	m := NewMirror(target.URL+"/", 100, time.Second, 4, slog.New(slog.DiscardHandler))
+	var seen string
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		seen = string(b)
+		w.Write([]byte("primary"))
+	}))
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if seen != body {
+		t.Errorf("handler saw body %q, want %q", seen, body)
+	}
+	if rec.Body.String() != "primary" {
+		t.Errorf("mirror must not affect the response, got %q", rec.Body.String())
+	}
+
+	select {
+	case mr := <-got:
+		if mr.path != "/v1/chat/completions" || mr.body != body {
+			t.Errorf("unexpected mirrored request: %+v", mr)
+		}
+		if mr.auth != "" {
+			t.Error("Authorization must not be mirrored")
+		}
+		if mr.flag != "1" {
+			t.Error("expected X-QLite-Mirrored header")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
+
+func TestMirror_ZeroPercent(t *testing.T) {
+	called := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- struct{}{}
+	}))
+	defer target.Close()
+
+	m := NewMirror(target.URL, 0, time.Second, 4, slog.New(slog.DiscardHandler))
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 20 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader("{}")))
+	}
+
+	select {
+	case <-called:
+		t.Error("expected no mirrored requests at 0%")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s := m.Stats(); s.Sent != 0 || s.Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestMirror_Drain(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer target.Close()
+	defer close(release)
+
+	m := NewMirror(target.URL, 100, time.Minute, 4, slog.New(slog.DiscardHandler))
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader("{}")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dropped, err := m.Drain(ctx)
+	if dropped != 1 || err == nil {
+		t.Errorf("Drain = %d, %v; want 1 dropped with an error", dropped, err)
+	}
+	if s := m.Stats(); s.Failed != 1 {
+		t.Errorf("failed = %d, want the cancelled request counted", s.Failed)
+	}
+}
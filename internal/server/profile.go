@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Profile is a named bundle of generation settings that clients select
+// with the X-QLite-Profile header, so teams share settings configured
+// centrally instead of each picking their own. Set fields replace the
+// client's values, which also makes otherwise equal requests share exact
+// cache entries.
+type Profile struct {
+	Temperature *float64
+	// MaxTokens replaces max_completion_tokens if the request sets it, and
+	// max_tokens otherwise.
+	MaxTokens *int
+	// SystemPrompt, if its content is set, is added to the request as a
+	// system message, ahead of any enforced system prompts.
+	SystemPrompt model.InjectedPrompt
+	// Models maps model aliases to the models they stand for.
+	Models map[string]string
+}
+
+// SetProfiles configures the profiles clients may select by name. Must be
+// called before serving.
+func (h *Handler) SetProfiles(profiles map[string]Profile) {
+	h.profiles = profiles
+}
+
+// applyProfile applies the profile named in X-QLite-Profile to req, before
+// the default model and deprecations are resolved. It writes a 400 error
+// and returns false if no such profile is configured.
+func (h *Handler) applyProfile(w http.ResponseWriter, r *http.Request, req *model.ChatRequest) bool {
+	name := r.Header.Get("X-QLite-Profile")
+	if name == "" {
+		return true
+	}
+	p, ok := h.profiles[name]
+	if !ok {
+		writeErrorCode(w, http.StatusBadRequest, "invalid_request_error", "unknown_profile", "unknown profile "+name)
+		return false
+	}
+	if m, ok := p.Models[req.Model]; ok {
+		req.Model = m
+	}
+	if p.Temperature != nil {
+		t := *p.Temperature
+		req.Temperature = &t
+	}
+	if p.MaxTokens != nil {
+		n := *p.MaxTokens
+		if req.MaxCompletionTokens != nil {
+			req.MaxCompletionTokens = &n
+		} else {
+			req.MaxTokens = &n
+		}
+	}
+	if p.SystemPrompt.Content != "" {
+		req.Messages = model.InjectPrompts(req.Messages, []model.InjectedPrompt{p.SystemPrompt})
+	}
+	return true
+}
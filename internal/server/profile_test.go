@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestHandler_Profiles(t *testing.T) {
+	var upstream model.ChatRequest
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = model.ChatRequest{}
+		json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	temp, maxTokens := 0.2, 64
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetProfiles(map[string]Profile{
+		"support": {
+			Temperature:  &temp,
+			MaxTokens:    &maxTokens,
+			SystemPrompt: model.InjectedPrompt{Content: "be brief"},
+			Models:       map[string]string{"fast": "gpt-4o"},
+		},
+	})
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	send := func(profile, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("X-QLite-Profile", profile)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("support", `{"model":"fast","temperature":1.5,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstream.Model != "gpt-4o" {
+		t.Errorf("alias should resolve to gpt-4o, got %q", upstream.Model)
+	}
+	if got := rec.Header().Get("X-Requested-Model"); got != "fast" {
+		t.Errorf("X-Requested-Model = %q, want the alias", got)
+	}
+	var body model.ChatResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Model != "gpt-4o" {
+		t.Errorf("response model = %q, want the model actually used", body.Model)
+	}
+	if upstream.Temperature == nil || *upstream.Temperature != 0.2 {
+		t.Errorf("profile temperature should replace the client's, got %v", upstream.Temperature)
+	}
+	if upstream.MaxTokens == nil || *upstream.MaxTokens != 64 {
+		t.Errorf("expected max_tokens 64, got %v", upstream.MaxTokens)
+	}
+	if len(upstream.Messages) != 2 || upstream.Messages[0].Role != "system" || upstream.Messages[0].Content != "be brief" {
+		t.Errorf("expected the profile prompt first, got %+v", upstream.Messages)
+	}
+
+	rec = send("support", `{"model":"gpt-4o","max_completion_tokens":1000,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstream.MaxCompletionTokens == nil || *upstream.MaxCompletionTokens != 64 || upstream.MaxTokens != nil {
+		t.Errorf("expected max_completion_tokens 64 only, got %v / %v", upstream.MaxCompletionTokens, upstream.MaxTokens)
+	}
+	if got := rec.Header().Get("X-Requested-Model"); got != "" {
+		t.Errorf("model wasn't rewritten, but X-Requested-Model = %q", got)
+	}
+
+	rec = send("nope", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown_profile") {
+		t.Errorf("unknown profile: expected 400 unknown_profile, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/redis"
+)
+
+// RateStore counts requests per key in fixed time windows.
+type RateStore interface {
+	// Incr counts one request for key in the window starting at start and
+	// lasting window, and returns the window's count so far.
+	Incr(ctx context.Context, key string, start time.Time, window time.Duration) (int64, error)
+}
+
+// RateLimiter caps chat requests per API key in fixed windows. Requests
+// without an API key share one allowance. Counts are kept in a RateStore,
+// which a Redis store shares between replicas; a key found over its limit
+// is remembered locally until its window ends, so further requests in the
+// window are rejected without asking the store.
+type RateLimiter struct {
+	limit  int64
+	window time.Duration
+	store  RateStore
+	now    func() time.Time
+
+	mu      sync.Mutex
+	blocked map[string]time.Time // key -> start of the window it is over in
+
+	rejected atomic.Uint64
+}
+
+// NewRateLimiter creates a limiter allowing limit requests per API key in
+// each window, counted in store.
+func NewRateLimiter(limit int, window time.Duration, store RateStore) *RateLimiter {
+	return &RateLimiter{
+		limit:   int64(limit),
+		window:  window,
+		store:   store,
+		now:     time.Now,
+		blocked: make(map[string]time.Time),
+	}
+}
+
+// SetRateLimiter enables per-key rate limits on chat requests. Must be
+// called before RegisterRoutes.
+func (h *Handler) SetRateLimiter(l *RateLimiter) {
+	h.rateLimiter = l
+}
+
+// Wrap returns next guarded by the limiter. A nil RateLimiter returns next
+// unchanged.
+func (l *RateLimiter) Wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := l.now()
+		start := now.Truncate(l.window)
+		if !l.allow(r.Context(), rateKey(extractAPIKey(r)), start) {
+			l.rejected.Add(1)
+			retry := int((start.Add(l.window).Sub(now) + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+			writeErrorCode(w, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded",
+				"rate limit of "+strconv.FormatInt(l.limit, 10)+" requests per "+l.window.String()+" exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rejected returns how many requests were rejected since startup.
+func (l *RateLimiter) Rejected() uint64 { return l.rejected.Load() }
+
+// allow counts a request for key in the window starting at start and
+// reports whether it is within the limit. A store error admits the request.
+func (l *RateLimiter) allow(ctx context.Context, key string, start time.Time) bool {
+	l.mu.Lock()
+	if blocked, ok := l.blocked[key]; ok {
+		if blocked.Equal(start) {
+			l.mu.Unlock()
+			return false
+		}
+		delete(l.blocked, key)
+	}
+	l.mu.Unlock()
+
+	n, err := l.store.Incr(ctx, key, start, l.window)
+	if err != nil || n <= l.limit {
+		return true
+	}
+	l.mu.Lock()
+	l.blocked[key] = start
+	if len(l.blocked) > 10000 {
+		for k, s := range l.blocked {
+			if s.Before(start) {
+				delete(l.blocked, k)
+			}
+		}
+	}
+	l.mu.Unlock()
+	return false
+}
+
+// rateKey identifies an API key in rate-limit state without storing the
+// key itself.
+func rateKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:12])
+}
+
+// LocalRateStore counts requests in memory, for a single replica.
+type LocalRateStore struct {
+	mu      sync.Mutex
+	current time.Time // start of the newest window counted
+	counts  map[string]localCount
+}
+
+type localCount struct {
+	start time.Time
+	n     int64
+}
+
+// NewLocalRateStore creates an empty in-memory store.
+func NewLocalRateStore() *LocalRateStore {
+	return &LocalRateStore{counts: make(map[string]localCount)}
+}
+
+// Incr implements RateStore. Counts of past windows are dropped once a
+// newer window starts.
+func (s *LocalRateStore) Incr(_ context.Context, key string, start time.Time, _ time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if start.After(s.current) {
+		s.current = start
+		for k, c := range s.counts {
+			if c.start.Before(start) {
+				delete(s.counts, k)
+			}
+		}
+	}
+	c := s.counts[key]
+	if !c.start.Equal(start) {
+		c = localCount{start: start}
+	}
+	c.n++
+	s.counts[key] = c
+	return c.n, nil
+}
+
+// RedisRateStore counts requests in Redis, so every replica sharing it
+// enforces one limit. While Redis is unreachable it falls back to counting
+// locally, so each replica then allows the full limit on its own; Redis is
+// tried again after the retry interval.
+type RedisRateStore struct {
+	client *redis.Client
+	prefix string
+	retry  time.Duration
+	local  *LocalRateStore
+	logger *slog.Logger
+	now    func() time.Time
+
+	downUntil atomic.Int64 // unix nanos; Redis is not tried before then
+}
+
+// NewRedisRateStore creates a store keeping counts in client under keys
+// starting with prefix, falling back to local counts for retry after a
+// Redis error.
+func NewRedisRateStore(client *redis.Client, prefix string, retry time.Duration, logger *slog.Logger) *RedisRateStore {
+	return &RedisRateStore{
+		client: client,
+		prefix: prefix,
+		retry:  retry,
+		local:  NewLocalRateStore(),
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Incr implements RateStore. The Redis key expires at least a window after
+// its window ends, so counts of past windows don't accumulate.
+func (s *RedisRateStore) Incr(ctx context.Context, key string, start time.Time, window time.Duration) (int64, error) {
+	down := s.downUntil.Load()
+	if down != 0 && s.now().UnixNano() < down {
+		return s.local.Incr(ctx, key, start, window)
+	}
+	rkey := s.prefix + key + ":" + strconv.FormatInt(start.Unix(), 10)
+	replies, err := s.client.Pipeline(ctx,
+		[]string{"INCR", rkey},
+		[]string{"PEXPIRE", rkey, strconv.FormatInt((2 * window).Milliseconds(), 10)},
+	)
+	var n int64
+	if err == nil {
+		var ok bool
+		if n, ok = replies[0].(int64); !ok {
+			err = unexpectedReply(replies[0])
+		}
+	}
+	if err != nil {
+		if s.downUntil.Swap(s.now().Add(s.retry).UnixNano()) == 0 {
+			s.logger.Warn("redis unreachable, counting rate limits locally", "error", err, "retry", s.retry)
+		}
+		return s.local.Incr(ctx, key, start, window)
+	}
+	if down != 0 && s.downUntil.CompareAndSwap(down, 0) {
+		s.logger.Info("redis reachable again, sharing rate limits")
+	}
+	return n, nil
+}
+
+func unexpectedReply(r any) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("unexpected reply %v to INCR", r)
+}
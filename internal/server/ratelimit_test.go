@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/redis"
+)
+
+// countingStore counts Incr calls and fails them while err is set.
+type countingStore struct {
+	local *LocalRateStore
+	calls int
+	err   error
+}
+
+func (s *countingStore) Incr(ctx context.Context, key string, start time.Time, window time.Duration) (int64, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.local.Incr(ctx, key, start, window)
+}
+
+func TestRateLimiter(t *testing.T) {
+	store := &countingStore{local: NewLocalRateStore()}
+	l := NewRateLimiter(2, time.Minute, store)
+	now := time.Date(2026, 1, 1, 12, 0, 30, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := send("a"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := send("a")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("expected Retry-After 30 until the window ends, got %q", got)
+	}
+	if rec := send("b"); rec.Code != http.StatusOK {
+		t.Errorf("other keys have their own limit, got %d", rec.Code)
+	}
+
+	calls := store.calls
+	send("a")
+	if store.calls != calls {
+		t.Error("a key over its limit should be rejected without asking the store")
+	}
+	if l.Rejected() != 2 {
+		t.Errorf("expected 2 rejections, got %d", l.Rejected())
+	}
+
+	now = now.Add(time.Minute)
+	if rec := send("a"); rec.Code != http.StatusOK {
+		t.Errorf("expected the next window to admit the key, got %d", rec.Code)
+	}
+
+	store.err = errors.New("store down")
+	for range 3 {
+		if rec := send("a"); rec.Code != http.StatusOK {
+			t.Errorf("store errors should admit requests, got %d", rec.Code)
+		}
+	}
+}
+
+func TestRedisRateStore_FallsBackToLocal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // nothing listens: every command fails
+
+	client := redis.NewClient(addr, "", 0, 100*time.Millisecond)
+	defer client.Close()
+	store := NewRedisRateStore(client, "test:", time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for want := int64(1); want <= 3; want++ {
+		n, err := store.Incr(ctx, "k", now, time.Minute)
+		if err != nil || n != want {
+			t.Fatalf("expected local count %d, got %d, %v", want, n, err)
+		}
+	}
+	if store.downUntil.Load() != now.Add(time.Minute).UnixNano() {
+		t.Error("expected Redis to be skipped until the retry interval passes")
+	}
+}
+
+func TestHandler_RateLimitsAsyncSubmissions(t *testing.T) {
+	mockSrv := httptest.NewServer(http.NotFoundHandler())
+	defer mockSrv.Close()
+	h := setupTestHandler(t, mockSrv)
+	h.SetRateLimiter(NewRateLimiter(1, time.Minute, NewLocalRateStore()))
+	h.SetAsync(1, 10, time.Hour, false, nil)
+	defer h.DrainAsync(context.Background())
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	submit := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/async/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer client-key")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := submit(); code != http.StatusAccepted {
+		t.Fatalf("expected the first job to be accepted, got %d", code)
+	}
+	if code := submit(); code != http.StatusTooManyRequests {
+		t.Errorf("expected async submissions over the limit to get 429, got %d", code)
+	}
+}
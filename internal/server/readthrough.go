@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+)
+
+// ReadThrough forwards /v1/models and /v1/embeddings to an OpenAI-compatible
+// upstream and caches successful responses, so SDKs that list models or
+// re-embed the same input on every start don't hit the provider each time.
+type ReadThrough struct {
+	baseURL    string
+	apiKey     string
+	client     *http.Client
+	models     *cache.ResponseCache
+	embeddings *cache.ResponseCache
+}
+
+// NewReadThrough creates a read-through proxy for baseURL (e.g.
+// https://api.openai.com/v1). Each endpoint has its own TTL; zero forwards
+// without caching.
+func NewReadThrough(baseURL, apiKey string, modelsTTL, embeddingsTTL time.Duration) *ReadThrough {
+	return &ReadThrough{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		client:     &http.Client{Timeout: 60 * time.Second},
+		models:     cache.NewResponseCache(modelsTTL, 16),
+		embeddings: cache.NewResponseCache(embeddingsTTL, 10000),
+	}
+}
+
+// RegisterRoutes registers the read-through endpoints on mux.
+func (rt *ReadThrough) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/models", rt.handleModels)
+	mux.HandleFunc("POST /v1/embeddings", rt.handleEmbeddings)
+}
+
+func (rt *ReadThrough) handleModels(w http.ResponseWriter, r *http.Request) {
+	rt.serve(w, r, rt.models, "models", http.MethodGet, "/models", nil)
+}
+
+func (rt *ReadThrough) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 10<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body: "+err.Error())
+		return
+	}
+	h := sha256.Sum256(body)
+	rt.serve(w, r, rt.embeddings, hex.EncodeToString(h[:]), http.MethodPost, "/embeddings", body)
+}
+
+// serve answers from c under key, or forwards to upstream and stores a 200
+// response.
+func (rt *ReadThrough) serve(w http.ResponseWriter, r *http.Request, c *cache.ResponseCache, key, method, path string, body []byte) {
+	if cached, ok := c.Get(key); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		w.Write(cached)
+		return
+	}
+
+	status, respBody, err := rt.forward(r, method, path, body)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		return
+	}
+	if status == http.StatusOK {
+		c.Put(key, respBody)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", "MISS")
+	w.WriteHeader(status)
+	w.Write(respBody)
+}
+
+func (rt *ReadThrough) forward(r *http.Request, method, path string, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, rt.baseURL+path, reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if rt.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.apiKey)
+	}
+
+	resp, err := rt.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading response: %w", err)
+	}
+	return resp.StatusCode, respBody, nil
+}
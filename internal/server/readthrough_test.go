@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThrough_CachesModelsAndEmbeddings(t *testing.T) {
+	var modelCalls, embCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-up" {
+			t.Errorf("expected upstream key, got %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			modelCalls.Add(1)
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"gpt-4o"}]}`)
+		case "/v1/embeddings":
+			n := embCalls.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(body), "fail") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":{"message":"bad"}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"object":"list","data":[{"embedding":[0.%d]}]}`, n)
+		}
+	}))
+	defer upstream.Close()
+
+	mux := http.NewServeMux()
+	NewReadThrough(upstream.URL+"/v1", "sk-up", time.Minute, time.Minute).RegisterRoutes(mux)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	for i, want := range []string{"MISS", "HIT"} {
+		rec := do(http.MethodGet, "/v1/models", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != want {
+			t.Errorf("models call %d: expected 200 %s, got %d %s", i, want, rec.Code, rec.Header().Get("X-Cache"))
+		}
+	}
+	if modelCalls.Load() != 1 {
+		t.Errorf("expected 1 upstream models call, got %d", modelCalls.Load())
+	}
+
+	first := do(http.MethodPost, "/v1/embeddings", `{"model":"text-embedding-3-small","input":"a"}`).Body.String()
+	second := do(http.MethodPost, "/v1/embeddings", `{"model":"text-embedding-3-small","input":"a"}`).Body.String()
+	do(http.MethodPost, "/v1/embeddings", `{"model":"text-embedding-3-small","input":"b"}`)
+	if first != second || embCalls.Load() != 2 {
+		t.Errorf("expected identical bodies to share an entry, got %d calls", embCalls.Load())
+	}
+
+	// Errors are passed through and not cached.
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodPost, "/v1/embeddings", `{"input":"fail"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected upstream 400 passed through, got %d", rec.Code)
+		}
+	}
+	if embCalls.Load() != 4 {
+		t.Errorf("expected errors not to be cached, got %d calls", embCalls.Load())
+	}
+}
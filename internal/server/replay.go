@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// ReplayRecord is a chat request as it entered the pipeline: system prompts,
+// guardrails and client metadata already applied. Replaying it skips that
+// processing, so providers see exactly what they saw the first time.
+type ReplayRecord struct {
+	RequestID     string                 `json:"request_id,omitempty"`
+	Request       json.RawMessage        `json:"request,omitempty"`
+	Provider      string                 `json:"provider,omitempty"`
+	Tags          map[string]string      `json:"tags,omitempty"`
+	HiddenPrompts []model.InjectedPrompt `json:"hidden_prompts,omitempty"`
+}
+
+// newReplayRecord snapshots req. The chat request is encoded right away, as
+// later stages may modify it in place.
+func newReplayRecord(req *model.ProxyRequest) *ReplayRecord {
+	body, err := json.Marshal(&req.ChatRequest)
+	if err != nil {
+		return nil
+	}
+	return &ReplayRecord{
+		RequestID:     req.RequestID,
+		Request:       body,
+		Provider:      req.Provider,
+		Tags:          req.Tags,
+		HiddenPrompts: req.HiddenPrompts,
+	}
+}
+
+// ServeReplay serves POST /admin/replay. The body is a ReplayRecord: either
+// a full record, as exported by GET /admin/debug/export, or just the
+// request_id of a request still held by the debug log. The request runs
+// through the live pipeline under a new request ID and is answered as the
+// original client was. With ?no_cache=true both caches are skipped.
+func (h *Handler) ServeReplay(w http.ResponseWriter, r *http.Request) {
+	var rec ReplayRecord
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10<<20)).Decode(&rec); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse replay record: "+err.Error())
+		return
+	}
+	var noCache bool
+	if v := r.URL.Query().Get("no_cache"); v != "" {
+		var err error
+		if noCache, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "no_cache must be a boolean")
+			return
+		}
+	}
+
+	if len(rec.Request) == 0 {
+		if rec.RequestID == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "request or request_id is required")
+			return
+		}
+		stored, ok := h.debug.Replayable(rec.RequestID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "invalid_request_error", "no recorded request "+rec.RequestID+" (debug log disabled, unknown or evicted)")
+			return
+		}
+		if stored == nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_request_error", "request content was not recorded; set debug.include_content to replay by ID")
+			return
+		}
+		rec = *stored
+	}
+
+	var chatReq model.ChatRequest
+	if err := json.Unmarshal(rec.Request, &chatReq); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to parse recorded request: "+err.Error())
+		return
+	}
+	if chatReq.Model == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
+		return
+	}
+
+	proxyReq := &model.ProxyRequest{
+		ChatRequest:   chatReq,
+		RequestID:     GetRequestID(r.Context()),
+		Tags:          rec.Tags,
+		Provider:      rec.Provider,
+		HiddenPrompts: rec.HiddenPrompts,
+		NoCache:       noCache,
+	}
+	if chatReq.Stream {
+		proxyReq.InputTokens = h.counter.QuickEstimate(chatReq.Messages)
+	}
+	if rec.RequestID != "" {
+		w.Header().Set("X-QLite-Replay-Of", rec.RequestID)
+	}
+	h.logger.Info("replaying request", "request_id", proxyReq.RequestID, "original", rec.RequestID, "no_cache", noCache)
+
+	if chatReq.Stream {
+		h.handleStreaming(w, r, proxyReq, nil)
+	} else {
+		h.handleNonStreaming(w, r, proxyReq)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+func TestReplay(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-1",
+			Model:   "gpt-4o",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "hi"}, FinishReason: "stop"}},
+			Usage:   model.Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+		})
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	exact := cache.New(time.Hour, 100)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact)
+	debug := NewDebugLog(10, true)
+	h.SetDebugLog(debug)
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	mux.HandleFunc("POST /admin/replay", h.ServeReplay)
+	mux.HandleFunc("GET /admin/debug/export", debug.ServeExport)
+	srv := Chain(mux, RequestID)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hello"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", rec.Code, rec.Body.String())
+	}
+	id := rec.Header().Get("X-Request-ID")
+
+	rec = do(http.MethodPost, "/admin/replay", `{"request_id":"`+id+`"}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("replay: %d cache=%q %s", rec.Code, rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if got := rec.Header().Get("X-QLite-Replay-Of"); got != id {
+		t.Errorf("X-QLite-Replay-Of = %q, want %q", got, id)
+	}
+	if rec.Header().Get("X-Request-ID") == id {
+		t.Error("replay reused the original request ID")
+	}
+
+	rec = do(http.MethodPost, "/admin/replay?no_cache=true", `{"request_id":"`+id+`"}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("replay without cache: %d cache=%q", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want 2", n)
+	}
+
+	// Exported lines replay on their own, without the debug log.
+	rec = do(http.MethodGet, "/admin/debug/export", "")
+	sc := bufio.NewScanner(rec.Body)
+	if !sc.Scan() {
+		t.Fatal("empty export")
+	}
+	var exported ReplayRecord
+	if err := json.Unmarshal(sc.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.RequestID != id {
+		t.Errorf("first exported record is %q, want %q", exported.RequestID, id)
+	}
+	h.SetDebugLog(nil)
+	rec = do(http.MethodPost, "/admin/replay", sc.Text())
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("replay from export: %d cache=%q %s", rec.Code, rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+
+	if rec = do(http.MethodPost, "/admin/replay", `{"request_id":"unknown"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown ID: got %d, want 404", rec.Code)
+	}
+}
+
+func TestReplayWithoutContent(t *testing.T) {
+	debug := NewDebugLog(2, false)
+	h := &Handler{debug: debug, logger: slog.New(slog.DiscardHandler)}
+	debug.add(&DebugBundle{RequestID: "r1"})
+
+	rec := httptest.NewRecorder()
+	h.ServeReplay(rec, httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(`{"request_id":"r1"}`)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got %d, want 422", rec.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Schema modes for request fields that model.ChatRequest doesn't cover.
+const (
+	SchemaOff        = "off"
+	SchemaPermissive = "permissive"
+	SchemaStrict     = "strict"
+)
+
+// checkSchema applies the schema mode to the fields decoding body into req
+// dropped. It writes the error response and returns false if the request
+// must not proceed.
+func (h *Handler) checkSchema(w http.ResponseWriter, r *http.Request, body []byte, req *model.ChatRequest) bool {
+	if h.schemaMode == "" || h.schemaMode == SchemaOff {
+		return true
+	}
+	unknown := model.UnknownFields(body, req)
+	if len(unknown) == 0 {
+		return true
+	}
+	list := strings.Join(unknown, ", ")
+	if h.schemaMode == SchemaStrict {
+		writeErrorCode(w, http.StatusBadRequest, "invalid_request_error", "unsupported_field",
+			"Unsupported request field(s): "+list+". qlite would not forward them upstream.")
+		return false
+	}
+	w.Header().Set("X-QLite-Dropped-Fields", list)
+	h.logger.Warn("dropping unsupported request fields", "fields", list, "request_id", GetRequestID(r.Context()))
+	return true
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestHandler_SchemaModes(t *testing.T) {
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"tools":[],"response_format":{"type":"json_object"}}`
+	tests := []struct {
+		mode        string
+		wantCode    int
+		wantDropped string
+	}{
+		{SchemaOff, http.StatusOK, ""},
+		{SchemaPermissive, http.StatusOK, "response_format, tools"},
+		{SchemaStrict, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			handler := setupTestHandler(t, mockSrv)
+			handler.SetSchemaMode(tt.mode)
+			mux := http.NewServeMux()
+			handler.RegisterRoutes(mux)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("X-QLite-Dropped-Fields"); got != tt.wantDropped {
+				t.Errorf("X-QLite-Dropped-Fields = %q, want %q", got, tt.wantDropped)
+			}
+			if tt.mode == SchemaStrict {
+				var errResp model.ErrorResponse
+				json.Unmarshal(rec.Body.Bytes(), &errResp)
+				if errResp.Error.Code != "unsupported_field" || !strings.Contains(errResp.Error.Message, "response_format, tools") {
+					t.Errorf("unexpected error: %+v", errResp.Error)
+				}
+			}
+		})
+	}
+}
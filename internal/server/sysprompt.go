@@ -0,0 +1,65 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// SystemPrompt is a system message enforced on requests from matching
+// clients, such as org-wide guardrail instructions. A request matches when
+// its API key is in APIKeys and belongs to a tenant in Tenants (see
+// SetTenants); an empty list matches anything.
+type SystemPrompt struct {
+	APIKeys []string
+	Tenants []string
+	Prompt  model.InjectedPrompt
+	// HideFromCache adds the prompt only on the way upstream, so requests
+	// share cache entries with and without it. Otherwise it is part of the
+	// request from the start and of its cache keys.
+	HideFromCache bool
+}
+
+func (p *SystemPrompt) matches(apiKey, tenant string) bool {
+	if len(p.APIKeys) > 0 && !slices.Contains(p.APIKeys, apiKey) {
+		return false
+	}
+	if len(p.Tenants) > 0 && (tenant == "" || !slices.Contains(p.Tenants, tenant)) {
+		return false
+	}
+	return true
+}
+
+// SetTenants assigns API keys to tenants, which system prompt rules can
+// match on. tenants maps each tenant to its keys; a key belongs to at most
+// one tenant. Must be called before serving.
+func (h *Handler) SetTenants(tenants map[string][]string) {
+	h.tenants = make(map[string]string)
+	for tenant, keys := range tenants {
+		for _, k := range keys {
+			h.tenants[k] = tenant
+		}
+	}
+}
+
+// applySystemPrompts adds every matching prompt to req, in rule order, and
+// returns the hidden ones, which are left for dispatch to add.
+func (h *Handler) applySystemPrompts(req *model.ChatRequest, apiKey string) (hidden []model.InjectedPrompt) {
+	var visible []model.InjectedPrompt
+	tenant := h.tenants[apiKey]
+	for i := range h.systemPrompts {
+		p := &h.systemPrompts[i]
+		if !p.matches(apiKey, tenant) {
+			continue
+		}
+		if p.HideFromCache {
+			hidden = append(hidden, p.Prompt)
+		} else {
+			visible = append(visible, p.Prompt)
+		}
+	}
+	if len(visible) > 0 {
+		req.Messages = model.InjectPrompts(req.Messages, visible)
+	}
+	return hidden
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestHandler_SystemPrompts(t *testing.T) {
+	var upstream model.ChatRequest
+	mockSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = model.ChatRequest{}
+		json.NewDecoder(r.Body).Decode(&upstream)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.ChatResponse{
+			ID:      "chatcmpl-test",
+			Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "ok"}, FinishReason: "stop"}},
+		})
+	}))
+	defer mockSrv.Close()
+
+	handler := setupTestHandler(t, mockSrv)
+	handler.SetSystemPrompts([]SystemPrompt{
+		{Prompt: model.InjectedPrompt{Content: "org policy"}},
+		{APIKeys: []string{"team-a"}, Prompt: model.InjectedPrompt{Content: "team a footer", Append: true}, HideFromCache: true},
+		{Tenants: []string{"acme"}, Prompt: model.InjectedPrompt{Content: "acme rules"}},
+	})
+	handler.SetTenants(map[string][]string{"acme": {"team-a", "acme-b"}})
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	send := func(apiKey, tags string) []model.Message {
+		t.Helper()
+		body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		if tags != "" {
+			req.Header.Set("X-QLite-Tags", tags)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return upstream.Messages
+	}
+	contents := func(msgs []model.Message) string {
+		var parts []string
+		for _, m := range msgs {
+			parts = append(parts, m.Role+":"+m.Content)
+		}
+		return strings.Join(parts, "|")
+	}
+
+	if got := contents(send("other", "")); got != "system:org policy|user:hi" {
+		t.Errorf("other key: %s", got)
+	}
+	if got := contents(send("team-a", "")); got != "system:org policy|system:acme rules|user:hi|system:team a footer" {
+		t.Errorf("team-a/acme: %s", got)
+	}
+	if got := contents(send("acme-b", "")); got != "system:org policy|system:acme rules|user:hi" {
+		t.Errorf("acme-b: %s", got)
+	}
+	// Tenants come from the API key, not from client-supplied tags.
+	if got := contents(send("other", "tenant=acme")); got != "system:org policy|user:hi" {
+		t.Errorf("other key tagged acme: %s", got)
+	}
+}
+
+func TestApplySystemPrompts_HiddenFromCacheKey(t *testing.T) {
+	h := &Handler{systemPrompts: []SystemPrompt{
+		{Prompt: model.InjectedPrompt{Content: "visible"}},
+		{Prompt: model.InjectedPrompt{Content: "hidden"}, HideFromCache: true},
+	}}
+	req := model.ChatRequest{Messages: []model.Message{{Role: "user", Content: "hi"}}}
+	hidden := h.applySystemPrompts(&req, "")
+
+	if len(req.Messages) != 2 || req.Messages[0].Content != "visible" {
+		t.Errorf("visible prompt should be part of the request, got %+v", req.Messages)
+	}
+	if len(hidden) != 1 || hidden[0].Content != "hidden" {
+		t.Fatalf("expected the hidden prompt to be returned, got %+v", hidden)
+	}
+
+	proxyReq := &model.ProxyRequest{ChatRequest: req, HiddenPrompts: hidden}
+	up := proxyReq.UpstreamRequest()
+	if len(up.Messages) != 3 || up.Messages[0].Content != "hidden" {
+		t.Errorf("upstream request should carry the hidden prompt, got %+v", up.Messages)
+	}
+	if len(proxyReq.ChatRequest.Messages) != 2 {
+		t.Error("UpstreamRequest must not modify ChatRequest")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/stats"
+)
+
+// remoteTokenCounts asks the upstream serving a model for the input tokens
+// of streaming requests that miss the cache, so X-Tokens-Input is exact
+// rather than a len/4 estimate.
+type remoteTokenCounts struct {
+	lookup  func(model string) provider.TokenCounter
+	counts  *cache.ResponseCache
+	timeout time.Duration
+
+	calls    stats.Counter
+	failures stats.Counter
+}
+
+// TokenCountStats counts upstream token-count calls. Cache hits, and
+// prompts counted recently, make none.
+type TokenCountStats struct {
+	Calls    uint64 `json:"calls"`
+	Failures uint64 `json:"failures"`
+}
+
+// SetRemoteTokenCounts makes streaming requests for a model whose provider,
+// as returned by lookup, counts tokens upstream (provider.TokenCounter)
+// report that count in X-Tokens-Input. lookup returns nil for other models.
+// Counts are cached for ttl, for up to size distinct prompts. A count that
+// takes longer than timeout, or fails, falls back to the estimate. Must be
+// called before serving.
+func (h *Handler) SetRemoteTokenCounts(lookup func(model string) provider.TokenCounter, size int, ttl, timeout time.Duration) {
+	h.tokenCounts = &remoteTokenCounts{
+		lookup:  lookup,
+		counts:  cache.NewResponseCache(ttl, size),
+		timeout: timeout,
+	}
+}
+
+// TokenCountStats returns upstream token-count counters, or nil if remote
+// token counts are off.
+func (h *Handler) TokenCountStats() *TokenCountStats {
+	if h.tokenCounts == nil {
+		return nil
+	}
+	return &TokenCountStats{Calls: h.tokenCounts.calls.Load(), Failures: h.tokenCounts.failures.Load()}
+}
+
+// count returns the upstream's input token count for req, and false if
+// there is none to use. It is safe on a nil receiver.
+func (c *remoteTokenCounts) count(ctx context.Context, req *model.ChatRequest) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	counter := c.lookup(req.Model)
+	if counter == nil {
+		return 0, false
+	}
+	key := tokenCountKey(req)
+	if b, ok := c.counts.Get(key); ok {
+		n, err := strconv.Atoi(string(b))
+		return n, err == nil
+	}
+
+	c.calls.Add(1)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	n, err := counter.CountTokens(ctx, req)
+	if err != nil {
+		c.failures.Add(1)
+		return 0, false
+	}
+	c.counts.Put(key, strconv.AppendInt(nil, int64(n), 10))
+	return n, true
+}
+
+// tokenCountKey identifies the parts of req that input tokens depend on.
+func tokenCountKey(req *model.ChatRequest) string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(struct {
+		Model    string          `json:"model"`
+		Messages []model.Message `json:"messages"`
+	}{req.Model, req.Messages})
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardmaghakyan/qlite/internal/cache"
+	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/eduardmaghakyan/qlite/internal/pipeline"
+	"github.com/eduardmaghakyan/qlite/internal/provider"
+	"github.com/eduardmaghakyan/qlite/internal/tokenizer"
+)
+
+type fakeTokenCounter struct {
+	n   int
+	err error
+}
+
+func (f fakeTokenCounter) CountTokens(ctx context.Context, req *model.ChatRequest) (int, error) {
+	return f.n, f.err
+}
+
+func TestHandler_RemoteTokenCounts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	h := setupTestHandler(t, upstream)
+	counters := map[string]provider.TokenCounter{
+		"gpt-4o":      fakeTokenCounter{n: 42},
+		"gpt-4o-mini": fakeTokenCounter{err: errors.New("unavailable")},
+	}
+	h.SetRemoteTokenCounts(func(model string) provider.TokenCounter { return counters[model] }, 10, time.Minute, time.Second)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	send := func(model string) string {
+		body := `{"model":"` + model + `","stream":true,"messages":[{"role":"user","content":"` + strings.Repeat("a", 40) + `"}]}`
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		return rec.Header().Get("X-Tokens-Input")
+	}
+	if got := send("gpt-4o"); got != "42" {
+		t.Errorf("expected the upstream count, got %s", got)
+	}
+	send("gpt-4o")
+	if got := send("gpt-4o-mini"); got != "10" {
+		t.Errorf("expected the estimate when counting fails, got %s", got)
+	}
+	if s := h.TokenCountStats(); s.Calls != 2 || s.Failures != 1 {
+		t.Errorf("expected the repeated prompt to be counted once, got %+v", s)
+	}
+}
+
+func TestHandler_RemoteTokenCountsSkipCacheHits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	counter := tokenizer.NewCounter()
+	registry := provider.NewRegistry()
+	registry.Register(provider.NewOpenAICompat("test", upstream.URL, "k", []string{"gpt-4o"}))
+	exact := cache.New(time.Hour, 100)
+	pipe, err := pipeline.New(pipeline.NewCacheStage(exact, true), pipeline.NewDispatchStage(registry, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(pipe, counter, slog.New(slog.DiscardHandler), exact)
+	h.SetRemoteTokenCounts(func(string) provider.TokenCounter { return fakeTokenCounter{n: 42} }, 10, time.Minute, time.Second)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	exact.Put(&model.ChatRequest{Model: "gpt-4o", Temperature: new(float64), Stream: true, Messages: []model.Message{{Role: "user", Content: "hi"}}},
+		&model.ChatResponse{Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: "cached"}, FinishReason: "stop"}}})
+	body := `{"model":"gpt-4o","temperature":0,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected a cache hit, got %v", rec.Header())
+	}
+	if s := h.TokenCountStats(); s.Calls != 0 {
+		t.Errorf("expected cache hits not to be counted upstream, got %+v", s)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// BuildInfo identifies the running binary, so the instances behind a load
+// balancer can be told apart.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+// Features summarizes how an instance is configured.
+type Features struct {
+	Providers     []string `json:"providers"`
+	SemanticCache bool     `json:"semantic_cache"`
+}
+
+// versionResponse is the body of GET /admin/version.
+type versionResponse struct {
+	BuildInfo
+	Features
+	ExactCache *exactCacheSize `json:"exact_cache,omitempty"`
+	// SemanticCacheEntries is omitted when Qdrant can't be counted in time.
+	SemanticCacheEntries *int `json:"semantic_cache_entries,omitempty"`
+}
+
+type exactCacheSize struct {
+	Entries    int `json:"entries"`
+	MaxEntries int `json:"max_entries"`
+}
+
+// semanticCountTimeout bounds how long ServeVersion waits for Qdrant.
+const semanticCountTimeout = 2 * time.Second
+
+// SetBuildInfo sets what ServeVersion reports, and adds the version and
+// commit to GET /health. Must be called before RegisterRoutes.
+func (h *Handler) SetBuildInfo(info BuildInfo, features Features) {
+	h.buildInfo = &info
+	h.features = features
+	h.healthBody, _ = json.Marshal(struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+		Commit  string `json:"commit,omitempty"`
+	}{"ok", info.Version, info.Commit})
+}
+
+// SetSemanticEntries makes ServeVersion report the semantic cache's size as
+// returned by entries. Must be called before serving.
+func (h *Handler) SetSemanticEntries(entries func(ctx context.Context) (int, error)) {
+	h.semanticEntries = entries
+}
+
+// ServeVersion reports the build info and features set by SetBuildInfo,
+// along with the caches' current sizes. It lists the configured providers,
+// so it belongs on the admin mux.
+func (h *Handler) ServeVersion(w http.ResponseWriter, r *http.Request) {
+	var resp versionResponse
+	if h.buildInfo != nil {
+		resp = versionResponse{BuildInfo: *h.buildInfo, Features: h.features}
+	}
+	if h.cache != nil {
+		s := h.cache.Stats()
+		resp.ExactCache = &exactCacheSize{Entries: s.Entries, MaxEntries: s.MaxEntries}
+	}
+	if h.semanticEntries != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), semanticCountTimeout)
+		n, err := h.semanticEntries(ctx)
+		cancel()
+		if err != nil {
+			h.logger.Warn("counting semantic cache entries failed", "error", err)
+		} else {
+			resp.SemanticCacheEntries = &n
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,116 @@
+// Package shutdown coordinates flushing background work when the proxy
+// stops, so async stores and mirrored requests get the grace period to
+// finish instead of being dropped when the process exits.
+package shutdown
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook flushes one source of async work. It returns when the work is done or
+// ctx ends, reporting how many queued items it had to abandon.
+type Hook func(ctx context.Context) (dropped int, err error)
+
+// Report is the outcome of one hook.
+type Report struct {
+	Name    string
+	Dropped int
+	Err     error
+	Elapsed time.Duration
+}
+
+type namedHook struct {
+	name string
+	fn   Hook
+}
+
+// Coordinator runs registered hooks on shutdown. The zero value is ready to
+// use.
+type Coordinator struct {
+	mu    sync.Mutex
+	hooks []namedHook
+}
+
+// Register adds a hook run by Run under name.
+func (c *Coordinator) Register(name string, h Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, namedHook{name, h})
+}
+
+// Run runs every hook concurrently, each bounded by ctx, and returns their
+// reports in registration order.
+func (c *Coordinator) Run(ctx context.Context) []Report {
+	c.mu.Lock()
+	hooks := append([]namedHook(nil), c.hooks...)
+	c.mu.Unlock()
+
+	reports := make([]Report, len(hooks))
+	var wg sync.WaitGroup
+	for i, h := range hooks {
+		wg.Go(func() {
+			start := time.Now()
+			dropped, err := h.fn(ctx)
+			reports[i] = Report{Name: h.name, Dropped: dropped, Err: err, Elapsed: time.Since(start)}
+		})
+	}
+	wg.Wait()
+	return reports
+}
+
+// Tracker runs background tasks and can drain them on shutdown. Tasks get a
+// context that is cancelled when a drain gives up on them.
+type Tracker struct {
+	wg      sync.WaitGroup
+	pending atomic.Int64
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewTracker creates an empty Tracker.
+func NewTracker() *Tracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Tracker{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a new goroutine.
+func (t *Tracker) Go(fn func(ctx context.Context)) {
+	t.pending.Add(1)
+	t.wg.Go(func() {
+		defer t.pending.Add(-1)
+		fn(t.ctx)
+	})
+}
+
+// Pending returns the number of tasks still running.
+func (t *Tracker) Pending() int {
+	return int(t.pending.Load())
+}
+
+// Wait blocks until every task has returned.
+func (t *Tracker) Wait() {
+	t.wg.Wait()
+}
+
+// Drain waits for running tasks until ctx ends. Tasks still running then are
+// cancelled and counted as dropped; Drain returns once they have exited.
+// Drain is a Hook.
+func (t *Tracker) Drain(ctx context.Context) (dropped int, err error) {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0, nil
+	case <-ctx.Done():
+		dropped = t.Pending()
+		t.cancel()
+		<-done
+		return dropped, ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker_DrainWaits(t *testing.T) {
+	tr := NewTracker()
+	finished := make(chan struct{})
+	tr.Go(func(ctx context.Context) {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	})
+
+	dropped, err := tr.Drain(context.Background())
+	if err != nil || dropped != 0 {
+		t.Fatalf("Drain = %d, %v; want 0, nil", dropped, err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Drain returned before the task finished")
+	}
+}
+
+func TestTracker_DrainDropsOnDeadline(t *testing.T) {
+	tr := NewTracker()
+	for range 3 {
+		tr.Go(func(ctx context.Context) { <-ctx.Done() })
+	}
+	tr.Go(func(ctx context.Context) {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dropped, err := tr.Drain(ctx)
+	if dropped != 3 {
+		t.Errorf("dropped = %d, want 3", dropped)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if tr.Pending() != 0 {
+		t.Errorf("pending = %d after drain", tr.Pending())
+	}
+}
+
+func TestCoordinator_Run(t *testing.T) {
+	var c Coordinator
+	c.Register("fast", func(ctx context.Context) (int, error) { return 0, nil })
+	c.Register("slow", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 2, ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	reports := c.Run(ctx)
+	if len(reports) != 2 || reports[0].Name != "fast" || reports[1].Name != "slow" {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	if reports[0].Dropped != 0 || reports[0].Err != nil {
+		t.Errorf("fast: %+v", reports[0])
+	}
+	if reports[1].Dropped != 2 || reports[1].Err == nil {
+		t.Errorf("slow: %+v", reports[1])
+	}
+}
@@ -0,0 +1,208 @@
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrSlowClient is returned by a buffered Writer whose client stopped
+// keeping up with the stream.
+var ErrSlowClient = errors.New("client is not keeping up with the stream")
+
+// errStopped is returned for writes after a buffered Writer was stopped.
+var errStopped = errors.New("sse: writer stopped")
+
+// Backpressure bounds how far a stream's client may fall behind.
+type Backpressure struct {
+	// WriteTimeout is the deadline for each write to the client.
+	WriteTimeout time.Duration
+	// MaxBuffered is how many bytes may be queued for the client. One
+	// event is always accepted, however large.
+	MaxBuffered int
+}
+
+type itemKind uint8
+
+const (
+	itemEvent itemKind = iota
+	itemRaw
+	itemComment
+	itemDone
+)
+
+type bufferedItem struct {
+	kind itemKind
+	data []byte
+}
+
+// bufferedWriter queues writes for a goroutine that sends them to the
+// client, so a slow client doesn't stall whoever is relaying the upstream.
+// A client whose write times out, or that lets the queue grow past
+// MaxBuffered, is given up on: writes fail with ErrSlowClient and onSlow is
+// called, typically to cancel the upstream.
+type bufferedWriter struct {
+	inner  Writer
+	rc     *http.ResponseController
+	bp     Backpressure
+	onSlow func()
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []bufferedItem
+	queued  int  // bytes in queue
+	started bool // something was queued, so headers are committed
+	closed  bool
+	err     error // sticky: the first failed write
+
+	done chan struct{} // closed when run returns
+}
+
+// NewBufferedWriter wraps sw, as returned by NewWriter for w, so its writes
+// are queued and sent in the background, each within bp.WriteTimeout. Add
+// heartbeats on top with WithHeartbeat, so they are queued too. onSlow is called once if the
+// client falls behind. The returned stop function sends what is still
+// queued; it must be called before the HTTP handler returns or touches w
+// itself, and is safe to call more than once.
+func NewBufferedWriter(w http.ResponseWriter, sw Writer, bp Backpressure, onSlow func()) (Writer, func()) {
+	b := &bufferedWriter{
+		inner:  sw,
+		rc:     http.NewResponseController(w),
+		bp:     bp,
+		onSlow: onSlow,
+		done:   make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	go b.run()
+	return b, b.stop
+}
+
+// SetHeader passes headers through until the first write is queued; after
+// that they could no longer be sent, and are dropped.
+func (b *bufferedWriter) SetHeader(key, value string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		b.inner.SetHeader(key, value)
+	}
+}
+
+func (b *bufferedWriter) WriteEvent(data []byte) error {
+	return b.enqueue(itemEvent, data)
+}
+
+// WriteRaw queues p, which must hold complete SSE events.
+func (b *bufferedWriter) WriteRaw(p []byte) error {
+	return b.enqueue(itemRaw, p)
+}
+
+// WriteComment queues text as an SSE comment line.
+func (b *bufferedWriter) WriteComment(text string) error {
+	return b.enqueue(itemComment, []byte(text))
+}
+
+// Done queues the final [DONE] event and waits until everything queued has
+// been sent.
+func (b *bufferedWriter) Done() error {
+	if err := b.enqueue(itemDone, nil); err != nil {
+		return err
+	}
+	b.stop()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// enqueue copies data into the queue, since callers reuse their buffers.
+func (b *bufferedWriter) enqueue(kind itemKind, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	if b.closed {
+		return errStopped
+	}
+	if b.queued > 0 && b.queued+len(data) > b.bp.MaxBuffered {
+		b.failLocked(ErrSlowClient)
+		return b.err
+	}
+	b.queue = append(b.queue, bufferedItem{kind: kind, data: append([]byte(nil), data...)})
+	b.queued += len(data)
+	b.started = true
+	b.cond.Signal()
+	return nil
+}
+
+func (b *bufferedWriter) run() {
+	defer close(b.done)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		for len(b.queue) == 0 && !b.closed && b.err == nil {
+			b.cond.Wait()
+		}
+		if len(b.queue) == 0 || b.err != nil {
+			return
+		}
+		it := b.queue[0]
+		b.queue[0] = bufferedItem{}
+		b.queue = b.queue[1:]
+		b.mu.Unlock()
+		err := b.write(it)
+		b.mu.Lock()
+		if b.err != nil {
+			return // failed while writing; the queue was dropped
+		}
+		b.queued -= len(it.data)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = ErrSlowClient
+			}
+			b.failLocked(err)
+		}
+	}
+}
+
+// write sends it to the client within the write timeout. The deadline is
+// cleared afterwards so heartbeats sent between writes aren't cut short.
+func (b *bufferedWriter) write(it bufferedItem) error {
+	if b.bp.WriteTimeout > 0 {
+		b.rc.SetWriteDeadline(time.Now().Add(b.bp.WriteTimeout))
+		defer b.rc.SetWriteDeadline(time.Time{})
+	}
+	switch it.kind {
+	case itemRaw:
+		return b.inner.(RawWriter).WriteRaw(it.data)
+	case itemComment:
+		return b.inner.(CommentWriter).WriteComment(string(it.data))
+	case itemDone:
+		return b.inner.Done()
+	}
+	return b.inner.WriteEvent(it.data)
+}
+
+// failLocked records the first failure, dropping what is still queued.
+// b.mu must be held.
+func (b *bufferedWriter) failLocked(err error) {
+	if b.err != nil {
+		return
+	}
+	b.err = err
+	b.queue, b.queued = nil, 0
+	b.cond.Broadcast()
+	if errors.Is(err, ErrSlowClient) && b.onSlow != nil {
+		b.onSlow()
+	}
+}
+
+// stop sends what is queued, then ends the writing goroutine.
+func (b *bufferedWriter) stop() {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	<-b.done
+}
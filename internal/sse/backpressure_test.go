@@ -0,0 +1,132 @@
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferedWriter_WritesInOrder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, stop := NewBufferedWriter(rec, NewWriter(rec), Backpressure{MaxBuffered: 1 << 20}, nil)
+	defer stop()
+
+	sw.SetHeader("X-Cache", "MISS")
+	buf := []byte(`{"a":1}`)
+	if err := sw.WriteEvent(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	copy(buf, `{"b":2}`) // writes must not alias the caller's buffer
+	sw.(CommentWriter).WriteComment("note")
+	sw.(RawWriter).WriteRaw([]byte("data: raw\n\n"))
+	sw.SetHeader("X-Late", "dropped")
+	if err := sw.Done(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "data: {\"a\":1}\n\n: note\n\ndata: raw\n\ndata: [DONE]\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if rec.Header().Get("X-Cache") != "MISS" || rec.Header().Get("X-Late") != "" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+	if err := sw.WriteEvent([]byte(`{}`)); err == nil {
+		t.Error("expected writes after Done to fail")
+	}
+}
+
+// stalledWriter blocks every write until release is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (s *stalledWriter) Write(p []byte) (int, error) {
+	<-s.release
+	return s.ResponseRecorder.Write(p)
+}
+
+func TestBufferedWriter_SlowClient(t *testing.T) {
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+	var slow atomic.Int32
+	sw, stop := NewBufferedWriter(w, NewWriter(w), Backpressure{MaxBuffered: 64}, func() { slow.Add(1) })
+
+	event := []byte(strings.Repeat("x", 40))
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = sw.WriteEvent(event)
+	}
+	if !errors.Is(err, ErrSlowClient) {
+		t.Fatalf("expected ErrSlowClient once the queue is full, got %v", err)
+	}
+	if !errors.Is(sw.WriteEvent(event), ErrSlowClient) || !errors.Is(sw.Done(), ErrSlowClient) {
+		t.Error("expected later writes to fail too")
+	}
+	close(w.release)
+	stop()
+	if n := slow.Load(); n != 1 {
+		t.Errorf("onSlow called %d times, want 1", n)
+	}
+}
+
+func TestBufferedWriter_WriteTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var slow atomic.Bool
+		sw, stop := NewBufferedWriter(w, NewWriter(w), Backpressure{WriteTimeout: 50 * time.Millisecond, MaxBuffered: 64 << 20}, func() { slow.Store(true) })
+		defer stop()
+		// Far more than the socket buffers hold, for a client that never
+		// reads.
+		event := []byte(strings.Repeat("x", 1<<20))
+		deadline := time.Now().Add(5 * time.Second)
+		for !slow.Load() && time.Now().Before(deadline) {
+			if err := sw.WriteEvent(event); err != nil && !errors.Is(err, ErrSlowClient) {
+				t.Errorf("unexpected error: %v", err)
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if !slow.Load() {
+			t.Error("expected the write deadline to give up on the client")
+		}
+		close(release)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-release
+	resp.Body.Close()
+}
+
+func TestBufferedWriter_HeartbeatsAreQueued(t *testing.T) {
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+	var slow atomic.Int32
+	bw, stopBuffered := NewBufferedWriter(w, NewWriter(w), Backpressure{WriteTimeout: time.Second, MaxBuffered: 1 << 20}, func() { slow.Add(1) })
+	sw, stopHeartbeat := WithHeartbeat(bw, 5*time.Millisecond)
+
+	if err := sw.WriteEvent([]byte(`{"a":1}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The stalled client holds the event; pings queue up behind it instead
+	// of writing to the response concurrently.
+	time.Sleep(30 * time.Millisecond)
+	close(w.release)
+	stopHeartbeat()
+	stopBuffered()
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "data: {\"a\":1}\n\n: ping\n\n") {
+		t.Errorf("expected pings queued after the event, got %q", body)
+	}
+	if slow.Load() != 0 {
+		t.Error("expected no slow-client callback")
+	}
+}
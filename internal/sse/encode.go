@@ -0,0 +1,117 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// chunkEncoder is a JSON encoder bound to its own buffer, pooled so each
+// event doesn't allocate a new encoder.
+type chunkEncoder struct {
+	buf     bytes.Buffer
+	enc     *json.Encoder
+	scratch []byte // for hand-assembled template chunks
+}
+
+// maxPooledEncoderBuf keeps one huge response from pinning its buffer in
+// the pool.
+const maxPooledEncoderBuf = 64 << 10
+
+var encoderPool = sync.Pool{
+	New: func() any {
+		e := new(chunkEncoder)
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+func getEncoder() *chunkEncoder {
+	return encoderPool.Get().(*chunkEncoder)
+}
+
+func (e *chunkEncoder) release() {
+	if e.buf.Cap() <= maxPooledEncoderBuf && cap(e.scratch) <= maxPooledEncoderBuf {
+		encoderPool.Put(e)
+	}
+}
+
+// encode returns the JSON encoding of v without the trailing newline. The
+// result is valid until the next call.
+func (e *chunkEncoder) encode(v any) ([]byte, error) {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	b := e.buf.Bytes()
+	// json.Encoder.Encode appends a trailing newline; trim it.
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		b = b[:len(b)-1]
+	}
+	return b, nil
+}
+
+// Pre-marshaled pieces of the fixed-shape replay chunks. They must match
+// encoding/json's output for the equivalent model.ChatStreamChunk.
+const (
+	chunkObjectField  = `,"object":"chat.completion.chunk","created":`
+	roleChoicesField  = `,"choices":[{"index":0,"delta":{"role":"assistant"}}]}`
+	stopChoicesField  = `,"choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":`
+	promptTokensField = `{"prompt_tokens":`
+)
+
+// appendChunkHeader appends `{"id":...,"object":...,"created":...,"model":...`.
+func appendChunkHeader(b []byte, id string, created int64, modelName string) []byte {
+	b = append(b, `{"id":`...)
+	b = appendJSONString(b, id)
+	b = append(b, chunkObjectField...)
+	b = strconv.AppendInt(b, created, 10)
+	b = append(b, `,"model":`...)
+	return appendJSONString(b, modelName)
+}
+
+// appendRoleChunk appends the assistant role chunk that opens a replay.
+func appendRoleChunk(b []byte, id string, created int64, modelName string) []byte {
+	b = appendChunkHeader(b, id, created, modelName)
+	return append(b, roleChoicesField...)
+}
+
+// appendStopChunk appends the final chunk with finish_reason "stop" and usage.
+func appendStopChunk(b []byte, id string, created int64, modelName string, u *model.Usage) []byte {
+	b = appendChunkHeader(b, id, created, modelName)
+	b = append(b, stopChoicesField...)
+	b = append(b, promptTokensField...)
+	b = strconv.AppendInt(b, int64(u.PromptTokens), 10)
+	b = append(b, `,"completion_tokens":`...)
+	b = strconv.AppendInt(b, int64(u.CompletionTokens), 10)
+	b = append(b, `,"total_tokens":`...)
+	b = strconv.AppendInt(b, int64(u.TotalTokens), 10)
+	if u.CacheCreationInputTokens != 0 {
+		b = append(b, `,"cache_creation_input_tokens":`...)
+		b = strconv.AppendInt(b, int64(u.CacheCreationInputTokens), 10)
+	}
+	if u.CacheReadInputTokens != 0 {
+		b = append(b, `,"cache_read_input_tokens":`...)
+		b = strconv.AppendInt(b, int64(u.CacheReadInputTokens), 10)
+	}
+	return append(b, "}}"...)
+}
+
+// appendJSONString appends s as a JSON string, escaped exactly as
+// encoding/json does. IDs and model names are plain ASCII, which is copied
+// directly; anything else goes through json.Marshal.
+func appendJSONString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			q, _ := json.Marshal(s)
+			return append(b, q...)
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
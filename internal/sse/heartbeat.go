@@ -0,0 +1,122 @@
+package sse
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// heartbeatWriter emits SSE comment lines when no event has been written for
+// the configured interval, so idle-timeout intermediaries keep the connection
+// open during long upstream gaps, including the wait for the first token. A
+// ping before the first event commits the response headers; headers set
+// after it are lost.
+type heartbeatWriter struct {
+	inner    heartbeatTarget
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+// heartbeatTarget is a Writer a heartbeatWriter can wrap.
+type heartbeatTarget interface {
+	Writer
+	RawWriter
+	CommentWriter
+}
+
+// NewWriterWithHeartbeat creates an SSE Writer that sends ": ping" comments
+// after interval of inactivity. The returned stop function must be called
+// before the HTTP handler returns; it is safe to call more than once.
+func NewWriterWithHeartbeat(w http.ResponseWriter, interval time.Duration) (Writer, func()) {
+	return WithHeartbeat(NewWriter(w), interval)
+}
+
+// WithHeartbeat is NewWriterWithHeartbeat for an existing Writer, such as a
+// buffered one, which the pings are sent through. The idle timer starts
+// right away. A sw that doesn't implement RawWriter and CommentWriter is
+// returned as is, without heartbeats. The returned stop function must be
+// called before sw is stopped.
+func WithHeartbeat(sw Writer, interval time.Duration) (Writer, func()) {
+	inner, ok := sw.(heartbeatTarget)
+	if !ok {
+		return sw, func() {}
+	}
+	hw := &heartbeatWriter{inner: inner, interval: interval}
+	hw.timer = time.AfterFunc(interval, hw.ping)
+	return hw, hw.stop
+}
+
+func (h *heartbeatWriter) SetHeader(key, value string) {
+	// A ping may be committing the headers meanwhile.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inner.SetHeader(key, value)
+}
+
+func (h *heartbeatWriter) WriteEvent(data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.inner.WriteEvent(data); err != nil {
+		return err
+	}
+	h.arm()
+	return nil
+}
+
+func (h *heartbeatWriter) WriteRaw(p []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.inner.WriteRaw(p); err != nil {
+		return err
+	}
+	h.arm()
+	return nil
+}
+
+func (h *heartbeatWriter) WriteComment(text string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.inner.WriteComment(text)
+}
+
+func (h *heartbeatWriter) Done() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopLocked()
+	return h.inner.Done()
+}
+
+// arm restarts the idle timer. Must be called with h.mu held.
+func (h *heartbeatWriter) arm() {
+	if h.stopped {
+		return
+	}
+	h.timer.Reset(h.interval)
+}
+
+func (h *heartbeatWriter) ping() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return
+	}
+	if err := h.inner.WriteComment("ping"); err != nil {
+		h.stopLocked()
+		return
+	}
+	h.timer.Reset(h.interval)
+}
+
+func (h *heartbeatWriter) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopLocked()
+}
+
+func (h *heartbeatWriter) stopLocked() {
+	h.stopped = true
+	h.timer.Stop()
+}
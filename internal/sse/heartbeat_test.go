@@ -0,0 +1,74 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatWriter_PingsWhenIdle(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, stop := NewWriterWithHeartbeat(rec, 10*time.Millisecond)
+	defer stop()
+
+	if err := sw.WriteEvent([]byte(`{"a":1}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "data: {\"a\":1}\n\n") {
+		t.Errorf("expected event first, got %q", body)
+	}
+	if !strings.Contains(body, ": ping\n\n") {
+		t.Errorf("expected heartbeat comment, got %q", body)
+	}
+}
+
+func TestHeartbeatWriter_PingsBeforeFirstEvent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, stop := NewWriterWithHeartbeat(rec, 5*time.Millisecond)
+	defer stop()
+	sw.SetHeader("X-Provider", "test")
+	time.Sleep(25 * time.Millisecond)
+	stop()
+
+	if !strings.HasPrefix(rec.Body.String(), ": ping\n\n") {
+		t.Errorf("expected pings while waiting for the first event, got %q", rec.Body.String())
+	}
+	if rec.Header().Get("X-Provider") != "test" {
+		t.Errorf("expected headers set before the ping to be sent, got %v", rec.Header())
+	}
+}
+
+func TestWithHeartbeat_UnsupportedWriter(t *testing.T) {
+	var sw Writer = writerOnly{NewWriter(httptest.NewRecorder())}
+	got, stop := WithHeartbeat(sw, time.Millisecond)
+	defer stop()
+	if got != sw {
+		t.Error("expected a writer without comment support returned unwrapped")
+	}
+}
+
+// writerOnly hides every method but Writer's.
+type writerOnly struct{ Writer }
+
+func TestHeartbeatWriter_NoPingAfterDone(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, stop := NewWriterWithHeartbeat(rec, 5*time.Millisecond)
+	defer stop()
+
+	sw.WriteEvent([]byte(`{}`))
+	sw.Done()
+	n := rec.Body.Len()
+	time.Sleep(25 * time.Millisecond)
+
+	if rec.Body.Len() != n {
+		t.Errorf("expected no output after Done, got %q", rec.Body.String())
+	}
+	if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+		t.Errorf("expected stream to end with [DONE], got %q", rec.Body.String())
+	}
+}
@@ -1,92 +1,112 @@
 package sse
 
 import (
-	"bytes"
-	"encoding/json"
-	"sync"
 	"time"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
 )
 
-var replayBufPool = sync.Pool{
-	New: func() any { return new(bytes.Buffer) },
-}
-
 // WriteResponseAsSSE replays a complete ChatResponse as SSE events.
 // This is used for serving cached responses to streaming requests.
 func WriteResponseAsSSE(sw Writer, resp *model.ChatResponse) error {
-	buf := replayBufPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer replayBufPool.Put(buf)
+	e := getEncoder()
+	defer e.release()
 
 	created := time.Now().Unix()
+	// Single-choice responses, by far the most common, use pre-marshaled
+	// templates for the role and finish chunks; n>1 responses get one entry
+	// per choice in each.
+	multi := len(resp.Choices) > 1
 
-	// Send role chunk.
-	roleChunk := model.ChatStreamChunk{
+	if multi {
+		choices := make([]model.StreamChoice, len(resp.Choices))
+		for i, c := range resp.Choices {
+			choices[i] = model.StreamChoice{Index: c.Index, Delta: model.Delta{Role: "assistant"}}
+		}
+		if err := writeChunk(sw, e, resp, created, choices, nil); err != nil {
+			return err
+		}
+	} else {
+		e.scratch = appendRoleChunk(e.scratch[:0], resp.ID, created, resp.Model)
+		if err := sw.WriteEvent(e.scratch); err != nil {
+			return err
+		}
+	}
+
+	// Send content chunk(s). Reasoning traces are replayed ahead of the
+	// answer, matching the order reasoning backends stream them in. One
+	// chunk is reused for every choice.
+	var choice [1]model.StreamChoice
+	chunk := &model.ChatStreamChunk{
 		ID:      resp.ID,
 		Object:  "chat.completion.chunk",
 		Created: created,
 		Model:   resp.Model,
-		Choices: []model.StreamChoice{
-			{
-				Index: 0,
-				Delta: model.Delta{Role: "assistant"},
-			},
-		},
-	}
-	if err := json.NewEncoder(buf).Encode(roleChunk); err != nil {
-		return err
-	}
-	if err := sw.WriteEvent(buf.Bytes()); err != nil {
-		return err
+		Choices: choice[:],
 	}
+	for _, c := range resp.Choices {
+		if c.Message.ReasoningContent != "" {
+			choice[0] = model.StreamChoice{
+				Index: c.Index,
+				Delta: model.Delta{ReasoningContent: c.Message.ReasoningContent},
+			}
+			b, err := e.encode(chunk)
+			if err != nil {
+				return err
+			}
+			if err := sw.WriteEvent(b); err != nil {
+				return err
+			}
+		}
 
-	// Send content chunk(s).
-	for _, choice := range resp.Choices {
-		buf.Reset()
-		contentChunk := model.ChatStreamChunk{
-			ID:      resp.ID,
-			Object:  "chat.completion.chunk",
-			Created: created,
-			Model:   resp.Model,
-			Choices: []model.StreamChoice{
-				{
-					Index: choice.Index,
-					Delta: model.Delta{Content: choice.Message.Content},
-				},
-			},
+		choice[0] = model.StreamChoice{
+			Index: c.Index,
+			Delta: model.Delta{Content: c.Message.Content},
 		}
-		if err := json.NewEncoder(buf).Encode(contentChunk); err != nil {
+		b, err := e.encode(chunk)
+		if err != nil {
 			return err
 		}
-		if err := sw.WriteEvent(buf.Bytes()); err != nil {
+		if err := sw.WriteEvent(b); err != nil {
 			return err
 		}
 	}
 
 	// Send finish chunk with usage.
-	buf.Reset()
-	finishChunk := model.ChatStreamChunk{
+	if multi {
+		choices := make([]model.StreamChoice, len(resp.Choices))
+		for i, c := range resp.Choices {
+			reason := c.FinishReason
+			if reason == "" {
+				reason = "stop"
+			}
+			choices[i] = model.StreamChoice{Index: c.Index, FinishReason: reason}
+		}
+		if err := writeChunk(sw, e, resp, created, choices, &resp.Usage); err != nil {
+			return err
+		}
+	} else {
+		e.scratch = appendStopChunk(e.scratch[:0], resp.ID, created, resp.Model, &resp.Usage)
+		if err := sw.WriteEvent(e.scratch); err != nil {
+			return err
+		}
+	}
+
+	return sw.Done()
+}
+
+// writeChunk encodes and writes a chunk of resp carrying choices and usage.
+func writeChunk(sw Writer, e *chunkEncoder, resp *model.ChatResponse, created int64, choices []model.StreamChoice, usage *model.Usage) error {
+	b, err := e.encode(&model.ChatStreamChunk{
 		ID:      resp.ID,
 		Object:  "chat.completion.chunk",
 		Created: created,
 		Model:   resp.Model,
-		Choices: []model.StreamChoice{
-			{
-				Index:        0,
-				Delta:        model.Delta{},
-				FinishReason: "stop",
-			},
-		},
-		Usage: &resp.Usage,
-	}
-	if err := json.NewEncoder(buf).Encode(finishChunk); err != nil {
+		Choices: choices,
+		Usage:   usage,
+	})
+	if err != nil {
 		return err
 	}
-	if err := sw.WriteEvent(buf.Bytes()); err != nil {
-		return err
-	}
-
-	return sw.Done()
+	return sw.WriteEvent(b)
 }
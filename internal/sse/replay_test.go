@@ -0,0 +1,155 @@
+package sse
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+func TestWriteResponseAsSSE_ReasoningContent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	resp := &model.ChatResponse{
+		ID:    "chatcmpl-r1",
+		Model: "deepseek-reasoner",
+		Choices: []model.Choice{{
+			Message:      model.Message{Role: "assistant", Content: "42", ReasoningContent: "6 times 7"},
+			FinishReason: "stop",
+		}},
+	}
+	if err := WriteResponseAsSSE(NewWriter(rec), resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var deltas []model.Delta
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk model.ChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		deltas = append(deltas, chunk.Choices[0].Delta)
+	}
+
+	// Role, reasoning, content, finish.
+	if len(deltas) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(deltas))
+	}
+	if deltas[1].ReasoningContent != "6 times 7" || deltas[1].Content != "" {
+		t.Errorf("expected reasoning chunk before content, got %+v", deltas[1])
+	}
+	if deltas[2].Content != "42" {
+		t.Errorf("expected content chunk, got %+v", deltas[2])
+	}
+}
+
+func TestReplayTemplatesMatchEncoding(t *testing.T) {
+	for _, id := range []string{"chatcmpl-1", `odd "id" <&> é`} {
+		usages := []model.Usage{
+			{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7},
+			{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7, CacheCreationInputTokens: 1, CacheReadInputTokens: 2},
+		}
+		role := model.ChatStreamChunk{
+			ID: id, Object: "chat.completion.chunk", Created: 1700000000, Model: "gpt-4o",
+			Choices: []model.StreamChoice{{Delta: model.Delta{Role: "assistant"}}},
+		}
+		want, _ := json.Marshal(role)
+		if got := appendRoleChunk(nil, id, 1700000000, "gpt-4o"); string(got) != string(want) {
+			t.Errorf("role chunk mismatch:\n got %s\nwant %s", got, want)
+		}
+		for _, u := range usages {
+			stop := model.ChatStreamChunk{
+				ID: id, Object: "chat.completion.chunk", Created: 1700000000, Model: "gpt-4o",
+				Choices: []model.StreamChoice{{FinishReason: "stop"}},
+				Usage:   &u,
+			}
+			want, _ := json.Marshal(stop)
+			if got := appendStopChunk(nil, id, 1700000000, "gpt-4o", &u); string(got) != string(want) {
+				t.Errorf("stop chunk mismatch:\n got %s\nwant %s", got, want)
+			}
+		}
+	}
+}
+
+type discardWriter struct{}
+
+func (discardWriter) SetHeader(key, value string)  {}
+func (discardWriter) WriteEvent(data []byte) error { return nil }
+func (discardWriter) Done() error                  { return nil }
+
+func BenchmarkWriteResponseAsSSE(b *testing.B) {
+	resp := &model.ChatResponse{
+		ID:    "chatcmpl-bench",
+		Model: "gpt-4o-mini",
+		Choices: []model.Choice{{
+			Message:      model.Message{Role: "assistant", Content: strings.Repeat("cached answer ", 20)},
+			FinishReason: "stop",
+		}},
+		Usage: model.Usage{PromptTokens: 12, CompletionTokens: 40, TotalTokens: 52},
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := WriteResponseAsSSE(discardWriter{}, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteResponseAsSSE_MultiChoice(t *testing.T) {
+	rec := httptest.NewRecorder()
+	resp := &model.ChatResponse{
+		ID:    "chatcmpl-n2",
+		Model: "gpt-4o",
+		Choices: []model.Choice{
+			{Index: 0, Message: model.Message{Role: "assistant", Content: "first"}, FinishReason: "stop"},
+			{Index: 1, Message: model.Message{Role: "assistant", Content: "second"}, FinishReason: "length"},
+		},
+		Usage: model.Usage{PromptTokens: 5, CompletionTokens: 7, TotalTokens: 12},
+	}
+	if err := WriteResponseAsSSE(NewWriter(rec), resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content := map[int]string{}
+	roles := map[int]bool{}
+	finish := map[int]string{}
+	var usage *model.Usage
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk model.ChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, c := range chunk.Choices {
+			content[c.Index] += c.Delta.Content
+			roles[c.Index] = roles[c.Index] || c.Delta.Role == "assistant"
+			if c.FinishReason != "" {
+				finish[c.Index] = c.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+
+	if content[0] != "first" || content[1] != "second" {
+		t.Errorf("content by index = %v", content)
+	}
+	if !roles[0] || !roles[1] {
+		t.Errorf("expected a role delta for every choice, got %v", roles)
+	}
+	if finish[0] != "stop" || finish[1] != "length" {
+		t.Errorf("finish reasons = %v", finish)
+	}
+	if usage == nil || *usage != resp.Usage {
+		t.Errorf("usage = %+v, want %+v", usage, resp.Usage)
+	}
+}
@@ -1,16 +1,9 @@
 package sse
 
 import (
-	"bytes"
-	"encoding/json"
 	"net/http"
-	"sync"
 )
 
-var jsonBufPool = sync.Pool{
-	New: func() any { return new(bytes.Buffer) },
-}
-
 // Writer writes Server-Sent Events to an HTTP response.
 type Writer interface {
 	// SetHeader sets a response header. Must be called before WriteEvent.
@@ -21,6 +14,20 @@ type Writer interface {
 	Done() error
 }
 
+// CommentWriter is implemented by Writers that can send SSE comment lines.
+// Clients ignore comments, so they are safe for out-of-band metadata.
+type CommentWriter interface {
+	WriteComment(text string) error
+}
+
+// RawWriter is implemented by Writers that can forward bytes already framed
+// as complete SSE events, such as an OpenAI-compatible upstream body, without
+// re-framing them. Wrappers that inspect or rewrite events must not
+// implement it, so providers fall back to WriteEvent behind them.
+type RawWriter interface {
+	WriteRaw(p []byte) error
+}
+
 type writer struct {
 	w   http.ResponseWriter
 	rc  *http.ResponseController
@@ -59,6 +66,22 @@ func (s *writer) WriteEvent(data []byte) error {
 	return s.rc.Flush()
 }
 
+// WriteRaw writes p, which must hold complete SSE events, and flushes.
+func (s *writer) WriteRaw(p []byte) error {
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// WriteComment writes text as an SSE comment line (": text").
+func (s *writer) WriteComment(text string) error {
+	if _, err := s.w.Write([]byte(": " + text + "\n\n")); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
 func (s *writer) Done() error {
 	if _, err := s.w.Write([]byte("data: [DONE]\n\n")); err != nil {
 		return err
@@ -68,16 +91,11 @@ func (s *writer) Done() error {
 
 // WriteJSON marshals v to JSON and sends it as an SSE event.
 func WriteJSON(sw Writer, v any) error {
-	buf := jsonBufPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer jsonBufPool.Put(buf)
-	if err := json.NewEncoder(buf).Encode(v); err != nil {
+	e := getEncoder()
+	defer e.release()
+	b, err := e.encode(v)
+	if err != nil {
 		return err
 	}
-	b := buf.Bytes()
-	// json.Encoder.Encode appends a trailing newline; trim it.
-	if len(b) > 0 && b[len(b)-1] == '\n' {
-		b = b[:len(b)-1]
-	}
 	return sw.WriteEvent(b)
 }
@@ -0,0 +1,43 @@
+// Package stats provides counters for request paths that run at high
+// concurrency. It is a leaf package: anything may use it.
+package stats
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// shards is the number of cells a Counter spreads increments over; a power
+// of two, so picking one is a mask.
+const shards = 32
+
+// cell is one shard, padded to its own cache line so increments to
+// neighbouring shards don't invalidate each other.
+type cell struct {
+	n atomic.Uint64
+	_ [56]byte
+}
+
+// Counter is a monotonic uint64 counter. Add picks a shard at random, so
+// goroutines incrementing at once rarely touch the same cache line; Load
+// sums the shards. The zero value is ready to use.
+//
+// Load is not a snapshot of a single instant: increments racing with it may
+// or may not be included. Successive Loads never go backwards.
+type Counter struct {
+	cells [shards]cell
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta uint64) {
+	c.cells[rand.Uint32()&(shards-1)].n.Add(delta)
+}
+
+// Load returns the sum of everything added so far.
+func (c *Counter) Load() uint64 {
+	var n uint64
+	for i := range c.cells {
+		n += c.cells[i].n.Load()
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	go func() {
+		// Concurrent readers see a count that only grows.
+		defer close(done)
+		var last uint64
+		for last < 64*1000 {
+			n := c.Load()
+			if n < last {
+				t.Errorf("Load went backwards: %d after %d", n, last)
+				return
+			}
+			last = n
+		}
+	}()
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				c.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+	if got := c.Load(); got != 64*1000 {
+		t.Errorf("Load() = %d, want %d", got, 64*1000)
+	}
+}
+
+// BenchmarkCounter compares a sharded Counter with a single atomic under
+// parallel increments, with a reader taking a snapshot every millisecond as
+// an admin endpoint polling stats would.
+func BenchmarkCounter(b *testing.B) {
+	poll := func(b *testing.B, load func() uint64) func() {
+		stop := make(chan struct{})
+		go func() {
+			t := time.NewTicker(time.Millisecond)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					load()
+				case <-stop:
+					return
+				}
+			}
+		}()
+		return func() { close(stop) }
+	}
+	b.Run("atomic", func(b *testing.B) {
+		var n atomic.Uint64
+		defer poll(b, n.Load)()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				n.Add(1)
+			}
+		})
+	})
+	b.Run("sharded", func(b *testing.B) {
+		var c Counter
+		defer poll(b, c.Load)()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Add(1)
+			}
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// downloadTimeout bounds fetching one BPE file.
+const downloadTimeout = 30 * time.Second
+
+// LoaderConfig says where encodings' BPE files come from.
+type LoaderConfig struct {
+	// Dir holds <name>.tiktoken files (o200k_base.tiktoken, ...), as
+	// published by OpenAI. When set, nothing else is consulted.
+	Dir string
+	// CacheDir holds downloaded files, named like tiktoken's own cache so
+	// an existing cache can be reused. Empty means $TIKTOKEN_CACHE_DIR,
+	// $DATA_GYM_CACHE_DIR or data-gym-cache under the temp dir.
+	CacheDir string
+	// Offline disables downloading: files missing from CacheDir are
+	// unavailable.
+	Offline bool
+}
+
+// SetLoader configures where every Counter loads encodings from. Call it
+// before any encoding is loaded; encodings already loaded are kept.
+func SetLoader(cfg LoaderConfig) {
+	tiktoken.SetBpeLoader(&loader{cfg: cfg, client: &http.Client{Timeout: downloadTimeout}})
+}
+
+func init() {
+	SetLoader(LoaderConfig{})
+}
+
+type loader struct {
+	cfg    LoaderConfig
+	client *http.Client
+}
+
+// LoadTiktokenBpe loads the BPE ranks published at url.
+func (l *loader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	if l.cfg.Dir != "" {
+		return readBPE(filepath.Join(l.cfg.Dir, path.Base(url)))
+	}
+	cached := filepath.Join(l.cacheDir(), fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+	if _, err := os.Stat(cached); err == nil {
+		return readBPE(cached)
+	}
+	if l.cfg.Offline {
+		return nil, fmt.Errorf("%s is not cached at %s and downloads are disabled", path.Base(url), cached)
+	}
+	if err := l.download(url, cached); err != nil {
+		return nil, err
+	}
+	return readBPE(cached)
+}
+
+func (l *loader) cacheDir() string {
+	for _, dir := range []string{l.cfg.CacheDir, os.Getenv("TIKTOKEN_CACHE_DIR"), os.Getenv("DATA_GYM_CACHE_DIR")} {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			return dir
+		}
+	}
+	return filepath.Join(os.TempDir(), "data-gym-cache")
+}
+
+// download fetches url into file, atomically.
+func (l *loader) download(url, file string) error {
+	resp, err := l.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: status %d", url, resp.StatusCode)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("downloading %s: %w", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// readBPE parses a tiktoken BPE file: one base64 token and its rank per
+// line.
+func readBPE(file string) (map[string]int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ranks := make(map[string]int)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		tok, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: malformed line", file, i+1)
+		}
+		b, err := base64.StdEncoding.DecodeString(tok)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+		}
+		ranks[string(b)] = n
+	}
+	return ranks, nil
+}
@@ -0,0 +1,73 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// "IQ==" is "!", "Ig==" is "\"".
+const testBPE = "IQ== 0\nIg== 1\n"
+
+func checkRanks(t *testing.T, l *loader, url string) {
+	t.Helper()
+	ranks, err := l.LoadTiktokenBpe(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranks) != 2 || ranks["!"] != 0 || ranks[`"`] != 1 {
+		t.Errorf("unexpected ranks: %v", ranks)
+	}
+}
+
+func TestLoader_Dir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "o200k_base.tiktoken"), []byte(testBPE), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := &loader{cfg: LoaderConfig{Dir: dir}}
+	checkRanks(t, l, "https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken")
+
+	if _, err := l.LoadTiktokenBpe("https://example.com/cl100k_base.tiktoken"); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.tiktoken"), []byte("IQ==\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.LoadTiktokenBpe("bad.tiktoken"); err == nil {
+		t.Error("expected error for malformed file")
+	}
+}
+
+func TestLoader_CacheDir(t *testing.T) {
+	var downloads int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		fmt.Fprint(w, testBPE)
+	}))
+	defer upstream.Close()
+	url := upstream.URL + "/encodings/o200k_base.tiktoken"
+	cacheDir := t.TempDir()
+
+	offline := &loader{cfg: LoaderConfig{CacheDir: cacheDir, Offline: true}}
+	if _, err := offline.LoadTiktokenBpe(url); err == nil {
+		t.Error("offline loader downloaded")
+	}
+
+	online := &loader{cfg: LoaderConfig{CacheDir: cacheDir}, client: upstream.Client()}
+	checkRanks(t, online, url)
+	if _, err := os.Stat(filepath.Join(cacheDir, fmt.Sprintf("%x", sha1.Sum([]byte(url))))); err != nil {
+		t.Errorf("download not cached: %v", err)
+	}
+
+	// Cached files are used without downloading, also offline.
+	checkRanks(t, online, url)
+	checkRanks(t, offline, url)
+	if downloads != 1 {
+		t.Errorf("downloads = %d, want 1", downloads)
+	}
+}
@@ -1,24 +1,94 @@
 package tokenizer
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
 	"github.com/pkoukk/tiktoken-go"
 )
 
+// retryInterval is how long an encoding that failed to load is left alone
+// before the next attempt.
+const retryInterval = time.Minute
+
 // Counter provides token counting for chat messages.
 type Counter struct {
-	mu        sync.RWMutex
-	encodings map[string]*tiktoken.Tiktoken
+	// encodings has a slot for every encoding in modelEncoding; the map
+	// itself never changes, so it's read without locking.
+	encodings map[string]*encodingSlot
+	logger    *slog.Logger
+	load      func(encName string) (*tiktoken.Tiktoken, error)
+
+	// fallbacks counts counts made with len/4 because the model's encoding
+	// couldn't be loaded.
+	fallbacks atomic.Uint64
+}
+
+// encodingSlot holds one encoding once loaded, or its last load failure.
+// Both are read atomically so counting never waits on a lock; mu only
+// serializes attempts to load it.
+type encodingSlot struct {
+	enc     atomic.Pointer[tiktoken.Tiktoken]
+	failure atomic.Pointer[encodingFailure]
+	mu      sync.Mutex
+}
+
+type encodingFailure struct {
+	at  time.Time
+	err error
+}
+
+// Stats reports encodings that couldn't be loaded and how often counting
+// fell back to the len/4 estimate because of them.
+type Stats struct {
+	Loaded      []string          `json:"loaded"`
+	Unavailable map[string]string `json:"unavailable,omitempty"` // encoding -> last error
+	Fallbacks   uint64            `json:"fallbacks"`
 }
 
 // NewCounter creates a new token counter.
 func NewCounter() *Counter {
-	return &Counter{
-		encodings: make(map[string]*tiktoken.Tiktoken),
+	c := &Counter{
+		encodings: make(map[string]*encodingSlot),
+		load:      tiktoken.GetEncoding,
+	}
+	for _, encName := range modelEncoding {
+		c.encodings[encName] = &encodingSlot{}
+	}
+	return c
+}
+
+// SetLogger makes the counter log a warning each time an encoding fails to
+// load.
+func (c *Counter) SetLogger(l *slog.Logger) {
+	c.logger = l
+}
+
+// Stats returns the counter's encoding status.
+func (c *Counter) Stats() Stats {
+	s := Stats{Loaded: []string{}, Fallbacks: c.fallbacks.Load()}
+	for name, slot := range c.encodings {
+		if slot.enc.Load() != nil {
+			s.Loaded = append(s.Loaded, name)
+			continue
+		}
+		if f := slot.failure.Load(); f != nil {
+			if s.Unavailable == nil {
+				s.Unavailable = make(map[string]string)
+			}
+			s.Unavailable[name] = f.err.Error()
+		}
 	}
+	sort.Strings(s.Loaded)
+	return s
 }
 
 // modelEncoding maps model prefixes to tiktoken encoding names.
@@ -43,28 +113,74 @@ func (c *Counter) getEncoding(modelName string) *tiktoken.Tiktoken {
 	if encName == "" {
 		return nil
 	}
+	enc, err := c.loadEncoding(encName)
+	if err != nil {
+		c.fallbacks.Add(1)
+	}
+	return enc
+}
 
-	c.mu.RLock()
-	enc, ok := c.encodings[encName]
-	c.mu.RUnlock()
-	if ok {
-		return enc
+func (c *Counter) loadEncoding(encName string) (*tiktoken.Tiktoken, error) {
+	slot := c.encodings[encName]
+	if enc, ok, err := slot.status(); ok {
+		return enc, err
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
 
-	// Double-check after acquiring write lock.
-	if enc, ok := c.encodings[encName]; ok {
-		return enc
+	// Double-check: another call may have loaded it meanwhile.
+	if enc, ok, err := slot.status(); ok {
+		return enc, err
 	}
 
-	enc, err := tiktoken.GetEncoding(encName)
+	enc, err := c.load(encName)
 	if err != nil {
-		return nil
+		slot.failure.Store(&encodingFailure{at: time.Now(), err: err})
+		if c.logger != nil {
+			c.logger.Warn("tokenizer encoding unavailable, estimating tokens as len/4", "encoding", encName, "retry_in", retryInterval, "error", err)
+		}
+		return nil, err
 	}
-	c.encodings[encName] = enc
-	return enc
+	slot.enc.Store(enc)
+	slot.failure.Store(nil)
+	return enc, nil
+}
+
+// status returns the loaded encoding, or the last failure if it happened
+// less than retryInterval ago, so a failed download isn't retried on every
+// request. ok is false when a load should be attempted.
+func (s *encodingSlot) status() (enc *tiktoken.Tiktoken, ok bool, err error) {
+	if enc = s.enc.Load(); enc != nil {
+		return enc, true, nil
+	}
+	if f := s.failure.Load(); f != nil && time.Since(f.at) < retryInterval {
+		return nil, true, f.err
+	}
+	return nil, false, nil
+}
+
+// Warm loads the encodings used by models up front, so the first request
+// for them isn't slowed down by downloading and parsing BPE ranks. Models
+// counted with the len/4 heuristic are ignored. It returns the names of the
+// encodings loaded, and an error naming each one that failed.
+func (c *Counter) Warm(models []string) ([]string, error) {
+	var loaded []string
+	var errs []error
+	seen := make(map[string]bool)
+	for _, m := range models {
+		encName := encodingForModel(m)
+		if encName == "" || seen[encName] {
+			continue
+		}
+		seen[encName] = true
+		if _, err := c.loadEncoding(encName); err != nil {
+			errs = append(errs, fmt.Errorf("loading %s: %w", encName, err))
+			continue
+		}
+		loaded = append(loaded, encName)
+	}
+	return loaded, errors.Join(errs...)
 }
 
 // CountMessages estimates the token count for a slice of messages.
@@ -97,6 +213,37 @@ func (c *Counter) CountText(modelName string, text string) int {
 	return len(enc.Encode(text, nil, nil))
 }
 
+// TruncateText cuts text down to at most n tokens and returns it with its
+// token count. Without an encoding for the model, n tokens are taken to be
+// 4n bytes. The cut never splits a UTF-8 sequence.
+func (c *Counter) TruncateText(modelName string, text string, n int) (string, int) {
+	enc := c.getEncoding(modelName)
+	if enc == nil {
+		if len(text) <= 4*n {
+			return text, len(text) / 4
+		}
+		return trimPartialRune(text[:4*n]), n
+	}
+	tokens := enc.Encode(text, nil, nil)
+	if len(tokens) <= n {
+		return text, len(tokens)
+	}
+	return trimPartialRune(enc.Decode(tokens[:n])), n
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of s,
+// left by cutting it at a byte or token boundary.
+func trimPartialRune(s string) string {
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
 // QuickEstimate returns a fast token estimate using len/4 heuristic (no tiktoken).
 func (c *Counter) QuickEstimate(messages []model.Message) int {
 	return c.fallbackCount(messages)
@@ -1,9 +1,12 @@
 package tokenizer
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/eduardmaghakyan/qlite/internal/model"
+	"github.com/pkoukk/tiktoken-go"
 )
 
 func TestCounter_CountMessages_KnownModel(t *testing.T) {
@@ -75,3 +78,58 @@ func TestCounter_CountMessages_GPT4_1Nano(t *testing.T) {
 		t.Errorf("expected positive token count for gpt-4.1-nano, got %d", tokens)
 	}
 }
+
+func TestCounter_Warm(t *testing.T) {
+	counter := NewCounter()
+	if loaded, err := counter.Warm([]string{"claude-sonnet-4", "gemini-2.5-flash"}); len(loaded) != 0 || err != nil {
+		t.Errorf("models without encodings: loaded %v, err %v", loaded, err)
+	}
+
+	// tiktoken keeps encodings it loaded for the whole process, so the
+	// loader is stubbed rather than pointed at an empty BPE directory.
+	var calls int
+	counter.load = func(string) (*tiktoken.Tiktoken, error) {
+		calls++
+		return nil, errors.New("offline")
+	}
+	loaded, err := counter.Warm([]string{"gpt-4o", "gpt-4o-mini", "claude-sonnet-4"})
+	if len(loaded) != 0 || err == nil || !strings.Contains(err.Error(), "o200k_base") || calls != 1 {
+		t.Errorf("unavailable encoding: loaded %v, err %v, %d loads", loaded, err, calls)
+	}
+	// Until retryInterval passes, counting falls back without retrying.
+	counter.CountText("gpt-4o", "hello")
+	if s := counter.Stats(); s.Fallbacks != 1 || s.Unavailable["o200k_base"] == "" || calls != 1 {
+		t.Errorf("unexpected stats after %d loads: %+v", calls, s)
+	}
+
+	counter = NewCounter()
+	counter.load = func(string) (*tiktoken.Tiktoken, error) { return &tiktoken.Tiktoken{}, nil }
+	if loaded, err := counter.Warm([]string{"gpt-4o", "gpt-4o-mini"}); err != nil || len(loaded) != 1 || loaded[0] != "o200k_base" {
+		t.Errorf("loaded %v, err %v, want [o200k_base]", loaded, err)
+	}
+}
+
+func TestCounter_TruncateText(t *testing.T) {
+	counter := NewCounter()
+
+	// Fallback: 4 bytes per token.
+	if got, n := counter.TruncateText("unknown-model", "Hello world!", 5); got != "Hello world!" || n != 3 {
+		t.Errorf("short text: got %q, %d", got, n)
+	}
+	if got, n := counter.TruncateText("unknown-model", "Hello world!", 2); got != "Hello wo" || n != 2 {
+		t.Errorf("long text: got %q, %d", got, n)
+	}
+	// A cut inside "é" drops its first byte too.
+	if got, _ := counter.TruncateText("unknown-model", "abcé", 1); got != "abc" {
+		t.Errorf("expected the cut to keep runes whole, got %q", got)
+	}
+
+	text := "The quick brown fox jumps over the lazy dog."
+	got, n := counter.TruncateText("gpt-4o", text, 3)
+	if n != 3 || !strings.HasPrefix(text, got) || got == text {
+		t.Errorf("expected a 3-token prefix, got %q, %d", got, n)
+	}
+	if counter.CountText("gpt-4o", got) > 3 {
+		t.Errorf("prefix %q counts more than 3 tokens", got)
+	}
+}
@@ -0,0 +1,394 @@
+//go:build ignore
+
+// soak.go — Long-running soak test at a fixed request rate. Samples the
+// proxy's memory and goroutine count from pprof and fails when they grow
+// monotonically over the run, which points at leaks (buffer pools, async
+// cache stores, stream goroutines) that short load tests don't reveal.
+//
+// Prerequisites:
+//   go run ./cmd/mockserver/ -port 9999 -latency 50ms
+//   QLITE_PPROF=1 QLITE_CONFIG=config/config.mock.yaml go run ./cmd/proxy/
+//
+// Usage:
+//   QLITE_SOAK_DURATION=4h QLITE_SOAK_RPS=100 go run loadtest/soak.go
+//
+// Env vars:
+//   QLITE_PROXY_URL       — default http://localhost:8080
+//   QLITE_PPROF_URL       — default http://localhost:6060
+//   QLITE_TEST_MODEL      — default gpt-4o-mini
+//   QLITE_SOAK_DURATION   — default 2h
+//   QLITE_SOAK_RPS        — default 50 (requests started per second)
+//   QLITE_SOAK_SAMPLE     — default 1m (pprof sampling interval)
+//   QLITE_SOAK_PROMPTS    — default 1000 (distinct prompts; repeats hit the cache)
+//   QLITE_SOAK_STREAM     — default 0.5 (fraction of streaming requests)
+//   QLITE_SOAK_WINDOWS    — default 5 (windows compared for monotonic growth)
+//   QLITE_SOAK_GROWTH     — default 0.10 (growth tolerated from first to last window)
+//   QLITE_SOAK_PID        — proxy PID; when set, RSS is read from /proc/PID/status,
+//                           otherwise the Go runtime's Sys is used
+//   QLITE_SOAK_PROFILES   — directory to save each heap profile for `go tool pprof -base`
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Configuration
+// ---------------------------------------------------------------------------
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(env(key, fallback.String()))
+	if err != nil || d <= 0 {
+		fail("%s: invalid duration", key)
+	}
+	return d
+}
+
+func envFloat(key string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(env(key, strconv.FormatFloat(fallback, 'f', -1, 64)), 64)
+	if err != nil || f < 0 {
+		fail("%s: invalid number", key)
+	}
+	return f
+}
+
+var (
+	proxyURL   = env("QLITE_PROXY_URL", "http://localhost:8080")
+	pprofURL   = env("QLITE_PPROF_URL", "http://localhost:6060")
+	model      = env("QLITE_TEST_MODEL", "gpt-4o-mini")
+	duration   = envDuration("QLITE_SOAK_DURATION", 2*time.Hour)
+	rps        = envFloat("QLITE_SOAK_RPS", 50)
+	interval   = envDuration("QLITE_SOAK_SAMPLE", time.Minute)
+	prompts    = int(envFloat("QLITE_SOAK_PROMPTS", 1000))
+	streamFrac = envFloat("QLITE_SOAK_STREAM", 0.5)
+	windows    = int(envFloat("QLITE_SOAK_WINDOWS", 5))
+	growth     = envFloat("QLITE_SOAK_GROWTH", 0.10)
+	pid        = os.Getenv("QLITE_SOAK_PID")
+	profileDir = os.Getenv("QLITE_SOAK_PROFILES")
+)
+
+// ---------------------------------------------------------------------------
+// Load generation
+// ---------------------------------------------------------------------------
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+var client = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 100,
+	},
+}
+
+type counters struct {
+	sent, ok, failed, hits, skipped atomic.Int64
+	inFlight                        atomic.Int64
+}
+
+func send(c *counters, rng *rand.Rand, mu *sync.Mutex) {
+	defer c.inFlight.Add(-1)
+
+	mu.Lock()
+	n := rng.Intn(prompts)
+	stream := rng.Float64() < streamFrac
+	mu.Unlock()
+
+	zero := 0.0
+	body, _ := json.Marshal(chatRequest{
+		Model:       model,
+		Messages:    []message{{Role: "user", Content: fmt.Sprintf("Soak prompt number %d", n)}},
+		Temperature: &zero,
+		MaxTokens:   10,
+		Stream:      stream,
+	})
+	req, _ := http.NewRequest("POST", proxyURL+"/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+env("OPENAI_API_KEY", "test-key"))
+
+	c.sent.Add(1)
+	resp, err := client.Do(req)
+	if err != nil {
+		c.failed.Add(1)
+		return
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil || resp.StatusCode != http.StatusOK {
+		c.failed.Add(1)
+		return
+	}
+	c.ok.Add(1)
+	if resp.Header.Get("X-Cache") == "HIT" {
+		c.hits.Add(1)
+	}
+}
+
+// generate starts requests at a fixed rate until stop is closed. It does not
+// wait for responses, so a slow proxy doesn't lower the offered load; requests
+// beyond maxInFlight are counted as skipped instead of piling up here.
+func generate(c *counters, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	rng := rand.New(rand.NewSource(1))
+	var mu sync.Mutex
+	maxInFlight := int64(rps*10) + 10
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if c.inFlight.Load() >= maxInFlight {
+				c.skipped.Add(1)
+				continue
+			}
+			c.inFlight.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				send(c, rng, &mu)
+			}()
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Sampling
+// ---------------------------------------------------------------------------
+
+type sample struct {
+	at         time.Duration
+	rss        int64
+	heapInuse  int64
+	goroutines int64
+}
+
+func fetch(path string) ([]byte, error) {
+	resp, err := client.Get(pprofURL + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// memStat returns the value of a "# Name = N" line from a debug=1 heap profile.
+func memStat(profile []byte, name string) int64 {
+	sc := bufio.NewScanner(bytes.NewReader(profile))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	prefix := "# " + name + " = "
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), prefix); ok {
+			n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// procRSS reads VmRSS (in bytes) from /proc/PID/status.
+func procRSS(pid string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", pid, "status"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "kB")), 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("VmRSS not found for pid %s", pid)
+}
+
+func takeSample(at time.Duration, n int) (sample, error) {
+	s := sample{at: at}
+	// gc=1 runs a collection first so HeapInuse reflects live memory.
+	heap, err := fetch("/debug/pprof/heap?gc=1&debug=1")
+	if err != nil {
+		return s, err
+	}
+	s.heapInuse = memStat(heap, "HeapInuse")
+	s.rss = memStat(heap, "Sys")
+	if pid != "" {
+		if s.rss, err = procRSS(pid); err != nil {
+			return s, err
+		}
+	}
+
+	gr, err := fetch("/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		return s, err
+	}
+	first, _, _ := strings.Cut(string(gr), "\n")
+	s.goroutines, _ = strconv.ParseInt(strings.TrimPrefix(first, "goroutine profile: total "), 10, 64)
+
+	if profileDir != "" {
+		raw, err := fetch("/debug/pprof/heap")
+		if err != nil {
+			return s, err
+		}
+		if err := os.WriteFile(filepath.Join(profileDir, fmt.Sprintf("heap-%03d.pb.gz", n)), raw, 0o644); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// ---------------------------------------------------------------------------
+// Leak detection
+// ---------------------------------------------------------------------------
+
+func medianInt(vals []int64) int64 {
+	sorted := append([]int64(nil), vals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// monotonic splits vals into n equal windows and reports whether every
+// window's median is above the previous one and the last exceeds the first
+// by more than the tolerated growth. Window medians smooth out GC sawtooth,
+// so a plateau after warm-up passes while steady creep fails.
+func monotonic(vals []int64, n int) (bool, []int64) {
+	size := len(vals) / n
+	medians := make([]int64, n)
+	for i := range medians {
+		medians[i] = medianInt(vals[i*size : (i+1)*size])
+	}
+	for i := 1; i < n; i++ {
+		if medians[i] <= medians[i-1] {
+			return false, medians
+		}
+	}
+	return float64(medians[n-1]) > float64(medians[0])*(1+growth), medians
+}
+
+// ---------------------------------------------------------------------------
+// Main
+// ---------------------------------------------------------------------------
+
+func main() {
+	if rps <= 0 || prompts < 1 || windows < 2 {
+		fail("QLITE_SOAK_RPS, QLITE_SOAK_PROMPTS and QLITE_SOAK_WINDOWS must be positive (windows >= 2)")
+	}
+	if profileDir != "" {
+		if err := os.MkdirAll(profileDir, 0o755); err != nil {
+			fail("%v", err)
+		}
+	}
+	rssSource := "runtime Sys"
+	if pid != "" {
+		rssSource = "/proc/" + pid + "/status"
+	}
+
+	fmt.Println("=== qlite Soak Test ===")
+	fmt.Printf("Proxy: %s | pprof: %s | Model: %s\n", proxyURL, pprofURL, model)
+	fmt.Printf("Duration: %s | RPS: %g | Sample every: %s | RSS from: %s\n\n", duration, rps, interval, rssSource)
+
+	if _, err := takeSample(0, 0); err != nil {
+		fail("pprof not reachable (start the proxy with QLITE_PPROF=1): %v", err)
+	}
+
+	var c counters
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go generate(&c, stop, &wg)
+
+	fmt.Printf("%10s %10s %8s %8s %12s %12s %10s\n", "elapsed", "requests", "errors", "hits", "rss", "heap_inuse", "goroutines")
+	var samples []sample
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		elapsed := time.Since(start)
+		s, err := takeSample(elapsed, len(samples)+1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sample failed: %v\n", err)
+		} else {
+			samples = append(samples, s)
+			fmt.Printf("%10s %10d %8d %8d %12s %12s %10d\n", elapsed.Round(time.Second),
+				c.sent.Load(), c.failed.Load(), c.hits.Load(), mib(s.rss), mib(s.heapInuse), s.goroutines)
+		}
+		if elapsed >= duration {
+			break
+		}
+	}
+	ticker.Stop()
+	close(stop)
+	wg.Wait()
+
+	fmt.Printf("\nRequests: %d ok, %d failed, %d skipped (max in-flight reached)\n", c.ok.Load(), c.failed.Load(), c.skipped.Load())
+
+	if len(samples) < windows {
+		fail("only %d samples; need at least %d (lengthen QLITE_SOAK_DURATION or shorten QLITE_SOAK_SAMPLE)", len(samples), windows)
+	}
+	// The first window includes warm-up (connection pools, cache fill), which
+	// only makes a real leak look smaller, so it is kept.
+	var rss, heap, goroutines []int64
+	for _, s := range samples {
+		rss = append(rss, s.rss)
+		heap = append(heap, s.heapInuse)
+		goroutines = append(goroutines, s.goroutines)
+	}
+	failed := false
+	for _, m := range []struct {
+		name string
+		vals []int64
+	}{{"rss", rss}, {"heap_inuse", heap}, {"goroutines", goroutines}} {
+		leak, medians := monotonic(m.vals, windows)
+		status := "ok"
+		if leak {
+			status = "GROWING"
+			failed = true
+		}
+		fmt.Printf("  %-11s window medians %v  %s\n", m.name, medians, status)
+	}
+	if failed {
+		fail("monotonic growth detected; compare saved heap profiles with `go tool pprof -base`")
+	}
+	fmt.Println("\nPASS: no monotonic growth")
+}
+
+func mib(b int64) string {
+	return fmt.Sprintf("%.1fMiB", float64(b)/(1<<20))
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "FAIL: "+format+"\n", args...)
+	os.Exit(1)
+}
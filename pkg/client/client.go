@@ -0,0 +1,251 @@
+// Package client is a small Go client for the qlite proxy.
+//
+// It wraps the OpenAI-compatible /v1/chat/completions endpoint and decodes the
+// proxy's response headers (X-Cache, X-Request-Cost, ...) into typed results,
+// so services can integrate without hand-rolling HTTP and SSE parsing.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eduardmaghakyan/qlite/internal/model"
+)
+
+// Request and response types are shared with the proxy.
+type (
+	ChatRequest     = model.ChatRequest
+	ChatResponse    = model.ChatResponse
+	ChatStreamChunk = model.ChatStreamChunk
+	Choice          = model.Choice
+	Message         = model.Message
+	Usage           = model.Usage
+)
+
+var (
+	dataPrefix = []byte("data: ")
+	doneMarker = []byte("[DONE]")
+)
+
+// Client talks to a qlite proxy.
+type Client struct {
+	baseURL string
+	apiKey  string
+
+	// HTTPClient is used for all requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New creates a client for the proxy at baseURL (e.g. "http://localhost:8080").
+// apiKey is sent as a Bearer token and may be empty.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Meta holds the proxy metadata returned in response headers.
+type Meta struct {
+	RequestID    string
+	Cache        string // "HIT" or "MISS"
+	Provider     string
+	Cost         float64
+	CostSaved    float64
+	TokensInput  int
+	TokensOutput int
+	TokensSaved  int
+}
+
+// CacheHit reports whether the response was served from a cache.
+func (m Meta) CacheHit() bool { return m.Cache == "HIT" }
+
+func metaFromHeader(h http.Header) Meta {
+	cost, _ := strconv.ParseFloat(h.Get("X-Request-Cost"), 64)
+	costSaved, _ := strconv.ParseFloat(h.Get("X-Cost-Saved"), 64)
+	in, _ := strconv.Atoi(h.Get("X-Tokens-Input"))
+	out, _ := strconv.Atoi(h.Get("X-Tokens-Output"))
+	saved, _ := strconv.Atoi(h.Get("X-Tokens-Saved"))
+	return Meta{
+		RequestID:    h.Get("X-Request-ID"),
+		Cache:        h.Get("X-Cache"),
+		Provider:     h.Get("X-Provider"),
+		Cost:         cost,
+		CostSaved:    costSaved,
+		TokensInput:  in,
+		TokensOutput: out,
+		TokensSaved:  saved,
+	}
+}
+
+// Result is a non-streaming completion with its proxy metadata.
+type Result struct {
+	*ChatResponse
+	Meta Meta
+}
+
+// APIError is returned when the proxy responds with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("qlite: %s (status %d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// ChatCompletion sends a non-streaming chat completion request.
+func (c *Client) ChatCompletion(ctx context.Context, req *ChatRequest) (*Result, error) {
+	r := *req
+	r.Stream = false
+
+	resp, err := c.do(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &Result{ChatResponse: &chatResp, Meta: metaFromHeader(resp.Header)}, nil
+}
+
+// Stream is an in-progress streaming completion. Chunks are delivered on C,
+// which is closed when the stream ends; call Err afterwards to check for failure.
+type Stream struct {
+	// C delivers decoded chunks in order.
+	C <-chan *ChatStreamChunk
+	// Meta is decoded from the response headers, available immediately.
+	Meta Meta
+
+	body  io.ReadCloser
+	err   error
+	usage *Usage
+	done  chan struct{}
+}
+
+// Err returns the error that terminated the stream, if any.
+// It blocks until the stream has finished.
+func (s *Stream) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Usage returns the token usage reported in the final chunk, or nil.
+// It blocks until the stream has finished.
+func (s *Stream) Usage() *Usage {
+	<-s.done
+	return s.usage
+}
+
+// Close aborts the stream and releases the underlying connection.
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
+
+// ChatCompletionStream sends a streaming chat completion request.
+// The returned Stream must be drained or closed.
+func (c *Client) ChatCompletionStream(ctx context.Context, req *ChatRequest) (*Stream, error) {
+	r := *req
+	r.Stream = true
+
+	resp, err := c.do(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *ChatStreamChunk)
+	s := &Stream{
+		C:    ch,
+		Meta: metaFromHeader(resp.Header),
+		body: resp.Body,
+		done: make(chan struct{}),
+	}
+	go s.read(ctx, ch)
+	return s, nil
+}
+
+func (s *Stream) read(ctx context.Context, ch chan<- *ChatStreamChunk) {
+	defer close(s.done)
+	defer close(ch)
+	defer s.body.Close()
+
+	scanner := bufio.NewScanner(s.body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if !bytes.HasPrefix(line, dataPrefix) {
+			continue
+		}
+		data := line[len(dataPrefix):]
+		if bytes.Equal(data, doneMarker) {
+			return
+		}
+
+		var chunk ChatStreamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			s.err = fmt.Errorf("decoding chunk: %w", err)
+			return
+		}
+		if chunk.Usage != nil {
+			s.usage = chunk.Usage
+		}
+
+		select {
+		case ch <- &chunk:
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.err = fmt.Errorf("reading stream: %w", err)
+		return
+	}
+	s.err = io.ErrUnexpectedEOF
+}
+
+func (c *Client) do(ctx context.Context, req *ChatRequest) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: string(respBody)}
+		var errResp model.ErrorResponse
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error.Message != "" {
+			apiErr.Type = errResp.Error.Type
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_ChatCompletion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("expected /v1/chat/completions, got %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("expected Bearer test-key, got %s", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("X-Provider", "cache")
+		w.Header().Set("X-Request-Cost", "0.00000000")
+		w.Header().Set("X-Cost-Saved", "0.00012500")
+		w.Header().Set("X-Tokens-Saved", "15")
+		json.NewEncoder(w).Encode(ChatResponse{
+			ID:      "chatcmpl-1",
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: "Hi"}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "test-key")
+	res, err := c.ChatCompletion(context.Background(), &ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []Message{{Role: "user", Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ID != "chatcmpl-1" {
+		t.Errorf("expected ID chatcmpl-1, got %s", res.ID)
+	}
+	if !res.Meta.CacheHit() {
+		t.Error("expected cache hit")
+	}
+	if res.Meta.Provider != "cache" {
+		t.Errorf("expected provider cache, got %s", res.Meta.Provider)
+	}
+	if res.Meta.CostSaved != 0.000125 {
+		t.Errorf("expected cost saved 0.000125, got %f", res.Meta.CostSaved)
+	}
+	if res.Meta.TokensSaved != 15 {
+		t.Errorf("expected 15 tokens saved, got %d", res.Meta.TokensSaved)
+	}
+}
+
+func TestClient_ChatCompletionStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("expected stream to be true")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Cache", "MISS")
+		w.Header().Set("X-Provider", "openai")
+		w.Write([]byte(`data: {"id":"c","choices":[{"index":0,"delta":{"role":"assistant"}}]}` + "\n\n"))
+		w.Write([]byte(`data: {"id":"c","choices":[{"index":0,"delta":{"content":"Hi"}}]}` + "\n\n"))
+		w.Write([]byte(`data: {"id":"c","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}` + "\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "")
+	s, err := c.ChatCompletionStream(context.Background(), &ChatRequest{
+		Model:    "gpt-4o",
+		Messages: []Message{{Role: "user", Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Meta.Provider != "openai" {
+		t.Errorf("expected provider openai, got %s", s.Meta.Provider)
+	}
+
+	var content string
+	var n int
+	for chunk := range s.C {
+		n++
+		if len(chunk.Choices) > 0 {
+			content += chunk.Choices[0].Delta.Content
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 chunks, got %d", n)
+	}
+	if content != "Hi" {
+		t.Errorf("expected content Hi, got %q", content)
+	}
+	if u := s.Usage(); u == nil || u.TotalTokens != 4 {
+		t.Errorf("expected usage with 4 total tokens, got %+v", u)
+	}
+}
+
+func TestClient_StreamTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"c","choices":[{"index":0,"delta":{"content":"Hi"}}]}` + "\n\n"))
+	}))
+	defer srv.Close()
+
+	s, err := New(srv.URL, "").ChatCompletionStream(context.Background(), &ChatRequest{Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for range s.C {
+	}
+	if s.Err() == nil {
+		t.Error("expected error for stream without [DONE]")
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"model is required","type":"invalid_request_error"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "").ChatCompletion(context.Background(), &ChatRequest{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", apiErr.StatusCode)
+	}
+	if apiErr.Type != "invalid_request_error" {
+		t.Errorf("expected type invalid_request_error, got %s", apiErr.Type)
+	}
+}